@@ -0,0 +1,485 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/agent"
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"github.com/Zayan-Mohamed/orb/internal/daemon"
+	"github.com/spf13/cobra"
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Keep several shares running in one background process",
+	Long: `Run the shares added with orb agent add and keep them running: each share
+gets a new session whenever its receiver leaves or its connection to the
+relay fails, retrying with a growing delay while the relay is unreachable.
+The shares are kept in agent.yaml in the configuration directory, changes to
+it are picked up while the agent runs.
+
+The agent also serves the control API of orb daemon and runs the jobs of orb
+jobs, so run either the agent or the daemon. Tray apps and scripts find the
+current session and passcode of each share at GET /v1/agent/shares; orb
+agent list prints them.`,
+	Args: cobra.NoArgs,
+	RunE: runAgent,
+}
+
+var agentAddCmd = &cobra.Command{
+	Use:   "add <name> <path>...",
+	Short: "Add a share to the agent",
+	Args:  cobra.MinimumNArgs(2),
+	RunE:  runAgentAdd,
+}
+
+var agentRmCmd = &cobra.Command{
+	Use:   "rm <name>",
+	Short: "Remove a share from the agent",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runAgentRm,
+}
+
+var agentListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the shares of the agent with their current session",
+	Args:  cobra.NoArgs,
+	RunE:  runAgentList,
+}
+
+var (
+	agentReadOnly bool
+	agentIncludes []string
+	agentExcludes []string
+	agentIndex    bool
+)
+
+const (
+	// agentReload is how often the agent rereads agent.yaml
+	agentReload = 5 * time.Second
+	// agentMaxBackoff caps the delay between failed starts of a share
+	agentMaxBackoff = time.Minute
+)
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+	agentCmd.AddCommand(agentAddCmd, agentRmCmd, agentListCmd)
+	addRelayFlags(agentCmd)
+	agentAddCmd.Flags().BoolVar(&agentReadOnly, "readonly", false, "Share in read-only mode")
+	agentAddCmd.Flags().StringArrayVar(&agentIncludes, "include", nil, "Only share files matching this glob (repeatable)")
+	agentAddCmd.Flags().StringArrayVar(&agentExcludes, "exclude", nil, "Hide files and directories matching this glob (repeatable)")
+	agentAddCmd.Flags().BoolVar(&agentIndex, "index", false, "Answer searches from an index of file names")
+}
+
+func runAgentAdd(cmd *cobra.Command, args []string) error {
+	paths := make([]string, 0, len(args)-1)
+	for _, p := range args[1:] {
+		p, err := config.ExpandHome(p)
+		if err != nil {
+			return err
+		}
+		if p, err = filepath.Abs(p); err != nil {
+			return fmt.Errorf("invalid path: %w", err)
+		}
+		if _, err := os.Stat(p); err != nil {
+			return err
+		}
+		paths = append(paths, p)
+	}
+
+	store, err := agent.Open()
+	if err != nil {
+		return err
+	}
+	share := agent.Share{
+		Name:     args[0],
+		Paths:    paths,
+		ReadOnly: agentReadOnly,
+		Include:  agentIncludes,
+		Exclude:  agentExcludes,
+		Index:    agentIndex,
+	}
+	if err := store.Add(share); err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		return printJSON(event{Event: "agent_share_added", Share: share.Name, Text: strings.Join(paths, " ")})
+	}
+	fmt.Printf("✓ Added %s: %s\n", share.Name, strings.Join(paths, ", "))
+	fmt.Println("  It is shared while orb agent is running.")
+	return nil
+}
+
+func runAgentRm(cmd *cobra.Command, args []string) error {
+	store, err := agent.Open()
+	if err != nil {
+		return err
+	}
+	if err := store.Remove(args[0]); err != nil {
+		return fmt.Errorf("%w: %s", err, args[0])
+	}
+	if jsonOutput {
+		return printJSON(event{Event: "agent_share_removed", Share: args[0]})
+	}
+	fmt.Printf("✓ Removed %s\n", args[0])
+	return nil
+}
+
+// runAgentList prints the shares of the running agent, or those in
+// agent.yaml when it is not running
+func runAgentList(cmd *cobra.Command, args []string) error {
+	managed, err := daemon.ManagedShares()
+	if err != nil {
+		if !errors.Is(err, daemon.ErrNoAPI) {
+			return err
+		}
+		store, err := agent.Open()
+		if err != nil {
+			return err
+		}
+		shares, err := store.Shares()
+		if err != nil {
+			return err
+		}
+		managed = make([]daemon.ManagedShare, 0, len(shares))
+		for _, s := range shares {
+			managed = append(managed, daemon.ManagedShare{Name: s.Name, Paths: s.Paths, State: "stopped"})
+		}
+	}
+
+	if jsonOutput {
+		if managed == nil {
+			managed = []daemon.ManagedShare{}
+		}
+		return printJSON(managed)
+	}
+	if len(managed) == 0 {
+		fmt.Println("No shares. Add one with orb agent add.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATE\tSESSION\tPASSCODE\tPATHS")
+	for _, s := range managed {
+		state := s.State
+		if s.Error != "" {
+			state += ": " + s.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.Name, state, orDash(s.SessionID), orDash(s.Passcode), strings.Join(s.Paths, ", "))
+	}
+	return w.Flush()
+}
+
+// orDash fills empty table cells
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func runAgent(cmd *cobra.Command, args []string) error {
+	store, err := agent.Open()
+	if err != nil {
+		return err
+	}
+	backend := &agentBackend{daemonBackend: &daemonBackend{}, shares: make(map[string]*agentShare)}
+	server, err := daemon.ServeAPI(backend)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = server.Close() }()
+	defer backend.close()
+
+	socket, token, _ := daemon.APIPaths()
+	if jsonOutput {
+		if err := printJSON(event{Event: "listening", Address: socket, Path: token}); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("Orb agent running the shares in %s\n", store.Path())
+		fmt.Printf("Control API on %s\n", socket)
+		fmt.Printf("Press Ctrl+C to stop.\n")
+	}
+	slog.Info("agent started", "socket", socket, "shares", store.Path())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go backend.runJobs(ctx)
+
+	ticker := time.NewTicker(agentReload)
+	defer ticker.Stop()
+	for {
+		shares, err := store.Shares()
+		if err != nil {
+			slog.Warn("failed to read the agent's shares", "err", err)
+		} else {
+			backend.apply(ctx, shares)
+		}
+		select {
+		case <-ctx.Done():
+			backend.apply(ctx, nil)
+			slog.Info("agent stopped")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// agentBackend is the backend of orb daemon that also keeps the shares of
+// agent.yaml running
+type agentBackend struct {
+	*daemonBackend
+
+	sharesMu sync.Mutex
+	shares   map[string]*agentShare
+	order    []string // names in the order of agent.yaml
+}
+
+// apply starts the shares that are new or changed and stops those that are
+// gone, waiting for them to end
+func (b *agentBackend) apply(ctx context.Context, defs []agent.Share) {
+	b.sharesMu.Lock()
+	wanted := make(map[string]agent.Share, len(defs))
+	b.order = b.order[:0]
+	for _, def := range defs {
+		wanted[def.Name] = def
+		b.order = append(b.order, def.Name)
+	}
+	var stopping []*agentShare
+	for name, s := range b.shares {
+		if def, ok := wanted[name]; !ok || !def.Equal(s.def) {
+			stopping = append(stopping, s)
+			delete(b.shares, name)
+		}
+	}
+	var starting []*agentShare
+	if ctx.Err() == nil {
+		for _, def := range defs {
+			if _, ok := b.shares[def.Name]; !ok {
+				s := newAgentShare(def)
+				b.shares[def.Name] = s
+				starting = append(starting, s)
+			}
+		}
+	}
+	b.sharesMu.Unlock()
+
+	for _, s := range stopping {
+		s.stop()
+	}
+	for _, s := range starting {
+		go s.run()
+	}
+}
+
+func (b *agentBackend) ManagedShares() []daemon.ManagedShare {
+	b.sharesMu.Lock()
+	defer b.sharesMu.Unlock()
+	list := make([]daemon.ManagedShare, 0, len(b.shares))
+	for _, name := range b.order {
+		if s, ok := b.shares[name]; ok {
+			list = append(list, s.snapshot())
+		}
+	}
+	return list
+}
+
+// agentShare is one share the agent keeps running, through a child orb share
+// per session
+type agentShare struct {
+	def    agent.Share
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.Mutex
+	status daemon.ManagedShare
+}
+
+func newAgentShare(def agent.Share) *agentShare {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &agentShare{
+		def:    def,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		status: daemon.ManagedShare{Name: def.Name, Paths: def.Paths, State: "starting", Since: time.Now()},
+	}
+}
+
+// run starts a session of the share whenever the last one ends. A session
+// that ends normally is replaced at once; failures are retried with a delay
+// that doubles up to agentMaxBackoff and resets once a session ran a while.
+func (s *agentShare) run() {
+	defer close(s.done)
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := s.serve()
+		if s.ctx.Err() != nil {
+			return
+		}
+
+		delay := time.Duration(0)
+		if err != nil {
+			if time.Since(started) > agentMaxBackoff {
+				backoff = time.Second
+			}
+			delay = backoff
+			backoff = min(backoff*2, agentMaxBackoff)
+			slog.Warn("share failed", "share", s.def.Name, "err", err, "retry", delay)
+			if jsonOutput {
+				_ = printJSON(event{Event: "agent_share_failed", Share: s.def.Name, Error: err.Error()})
+			} else {
+				fmt.Printf("%s: %v, retrying in %s\n", s.def.Name, err, delay)
+			}
+		} else {
+			backoff = time.Second
+			slog.Info("share ended, starting a new session", "share", s.def.Name)
+		}
+		s.update(func(st *daemon.ManagedShare) {
+			st.State, st.SessionID, st.Passcode, st.Relay, st.PID = "restarting", "", "", "", 0
+			st.Since = time.Now()
+			if err != nil {
+				st.Error = err.Error()
+			}
+		})
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// serve runs one session of the share in a child orb share and follows the
+// events it prints until it exits
+func (s *agentShare) serve() error {
+	args := []string{"share", "--json"}
+	if s.def.ReadOnly {
+		args = append(args, "--readonly")
+	}
+	for _, g := range s.def.Include {
+		args = append(args, "--include", g)
+	}
+	for _, g := range s.def.Exclude {
+		args = append(args, "--exclude", g)
+	}
+	if s.def.Index {
+		args = append(args, "--index")
+	}
+	args = append(args, "--relay", strings.Join(relayURLs, ","))
+	args = append(args, inheritedFlags()...)
+	args = append(args, "--")
+	args = append(args, s.def.Paths...)
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate orb: %w", err)
+	}
+	// #nosec G204 -- runs this same binary with the paths of agent.yaml
+	child := exec.Command(exe, args...)
+	var stderr bytes.Buffer
+	child.Stderr = &stderr
+	stdout, err := child.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := child.Start(); err != nil {
+		return fmt.Errorf("failed to start share: %w", err)
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-exited:
+			return
+		case <-s.ctx.Done():
+		}
+		// Let the share close its session, then make sure it is gone
+		if id := s.snapshot().SessionID; id != "" {
+			_ = daemon.Stop(id)
+		}
+		select {
+		case <-exited:
+		case <-time.After(5 * time.Second):
+			_ = child.Process.Kill()
+		}
+	}()
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		var e event
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		s.observe(e, child.Process.Pid)
+	}
+	err = child.Wait()
+	close(exited)
+	if err != nil && s.ctx.Err() == nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return errors.New(shareFailure(stderr.Bytes()))
+		}
+		return err
+	}
+	return nil
+}
+
+// observe records an event of the share's child and announces new sessions
+func (s *agentShare) observe(e event, pid int) {
+	switch e.Event {
+	case "session":
+		s.update(func(st *daemon.ManagedShare) {
+			st.State, st.SessionID, st.Passcode, st.Relay, st.PID = "waiting", e.SessionID, e.Passcode, e.Relay, pid
+			st.Sessions++
+			st.Since, st.Error = time.Now(), ""
+		})
+		slog.Info("share waiting", "share", s.def.Name, "session", e.SessionID)
+		if jsonOutput {
+			e.Share, e.PID = s.def.Name, pid
+			_ = printJSON(e)
+		} else {
+			fmt.Printf("%s: session %s, passcode %s\n", s.def.Name, e.SessionID, e.Passcode)
+		}
+	case "connected":
+		s.update(func(st *daemon.ManagedShare) { st.State, st.Since = "connected", time.Now() })
+		slog.Info("receiver connected", "share", s.def.Name)
+	}
+}
+
+func (s *agentShare) update(change func(*daemon.ManagedShare)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change(&s.status)
+}
+
+func (s *agentShare) snapshot() daemon.ManagedShare {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// stop ends the share and waits for its child to exit
+func (s *agentShare) stop() {
+	s.cancel()
+	<-s.done
+}
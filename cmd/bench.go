@@ -0,0 +1,324 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	"github.com/spf13/cobra"
+)
+
+var benchCmd = &cobra.Command{
+	Use:   "bench <session-id>",
+	Short: "Measure the throughput of a tunnel",
+	Long: `Send synthetic data through a tunnel to a share in both directions and report
+the throughput, the round trip time and the overhead of framing and
+encryption. Neither side reads or writes files for this, so the result is
+what the relay and the network path can carry.
+
+To find out whether the disks hold transfers back, --file also reads a real
+file from the share, and the write speed of the local disk is measured.
+A share ends once its receiver disconnects, so bench a share started for the
+purpose.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runBench,
+}
+
+var (
+	benchDuration time.Duration
+	benchFile     string
+)
+
+const (
+	// benchPings is the number of round trips measured
+	benchPings = 10
+	// benchProbeTimeout is how long to wait for a sharer that may not
+	// know bench requests
+	benchProbeTimeout = 5 * time.Second
+	// benchDiskLimit bounds the local disk test
+	benchDiskLimit = 256 * 1024 * 1024
+)
+
+// benchResult is printed by --json. Rates are in bytes per second.
+type benchResult struct {
+	RelayRTT        time.Duration `json:"relay_rtt_ns"`
+	TunnelRTT       time.Duration `json:"tunnel_rtt_ns"`
+	TunnelRTTMin    time.Duration `json:"tunnel_rtt_min_ns"`
+	Download        float64       `json:"download_bps"`
+	DownloadWire    float64       `json:"download_wire_bps"`
+	Upload          float64       `json:"upload_bps"`
+	UploadWire      float64       `json:"upload_wire_bps"`
+	Overhead        float64       `json:"overhead_percent"`
+	SharerDisk      float64       `json:"sharer_disk_bps,omitempty"`
+	LocalDisk       float64       `json:"local_disk_bps"`
+	ChunkSize       int           `json:"chunk_size"`
+	Parallel        int           `json:"parallel"`
+	DurationSeconds float64       `json:"duration_seconds"`
+}
+
+func init() {
+	rootCmd.AddCommand(benchCmd)
+	addRelayFlags(benchCmd)
+	addPasscodeFlags(benchCmd)
+	benchCmd.Flags().DurationVar(&benchDuration, "duration", 5*time.Second, "How long to measure each direction")
+	benchCmd.Flags().IntVar(&parallel, "parallel", 1, "Number of requests in flight, as with get --parallel")
+	benchCmd.Flags().StringVar(&benchFile, "file", "", "Also read this file of the share to measure the sharer's disk")
+}
+
+func runBench(cmd *cobra.Command, args []string) error {
+	if err := checkParallel(); err != nil {
+		return err
+	}
+	if benchDuration <= 0 {
+		return errors.New("--duration must be positive")
+	}
+
+	tun, client, err := dialSession(args[0])
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tun.Close(); err != nil {
+			slog.Warn("failed to close tunnel", "err", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// Sharers that predate bench ignore the request instead of answering
+	probe, cancel := context.WithTimeout(ctx, benchProbeTimeout)
+	_, err = client.Bench(probe, nil, 0)
+	cancel()
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return errors.New("the sharer does not answer bench requests, it needs a newer orb")
+		}
+		return fmt.Errorf("bench failed: %w", err)
+	}
+
+	result := benchResult{ChunkSize: transfer.ChunkSize, Parallel: parallel, DurationSeconds: benchDuration.Seconds()}
+	progress := func(format string, a ...any) {
+		if !jsonOutput {
+			fmt.Printf(format, a...)
+		}
+	}
+	progress("Benchmarking session %s, %s in each direction\n\n", args[0], benchDuration)
+
+	result.RelayRTT = relayRoundTrip()
+	if result.TunnelRTT, result.TunnelRTTMin, err = tunnelRoundTrip(ctx, client); err != nil {
+		return err
+	}
+	progress("%-12s to the relay %s, to the sharer %s (min %s)\n", "Round trip",
+		formatRTT(result.RelayRTT), formatRTT(result.TunnelRTT), formatRTT(result.TunnelRTTMin))
+
+	_, received := tun.Traffic()
+	n, elapsed, err := benchLoop(ctx, func(ctx context.Context) (int, error) {
+		data, err := client.Bench(ctx, nil, transfer.ChunkSize)
+		return len(data), err
+	})
+	if err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	_, receivedAfter := tun.Traffic()
+	result.Download = float64(n) / elapsed.Seconds()
+	result.DownloadWire = float64(receivedAfter-received) / elapsed.Seconds()
+	progress("%-12s %s/s\n", "Download", formatBytes(int64(result.Download)))
+
+	sent, _ := tun.Traffic()
+	filler := make([]byte, transfer.ChunkSize)
+	m, elapsed, err := benchLoop(ctx, func(ctx context.Context) (int, error) {
+		_, err := client.Bench(ctx, filler, 0)
+		return len(filler), err
+	})
+	if err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+	sentAfter, _ := tun.Traffic()
+	result.Upload = float64(m) / elapsed.Seconds()
+	result.UploadWire = float64(sentAfter-sent) / elapsed.Seconds()
+	progress("%-12s %s/s\n", "Upload", formatBytes(int64(result.Upload)))
+
+	result.Overhead = (result.DownloadWire/result.Download - 1) * 100
+	progress("%-12s %.1f%% for framing and encryption of %s chunks\n", "Overhead",
+		result.Overhead, formatBytes(transfer.ChunkSize))
+
+	if benchFile != "" {
+		if result.SharerDisk, err = benchRemoteFile(ctx, client, path.Join("/", benchFile)); err != nil {
+			return err
+		}
+		progress("%-12s %s/s reading %s\n", "Sharer disk", formatBytes(int64(result.SharerDisk)), benchFile)
+	}
+
+	if result.LocalDisk, err = benchLocalDisk(); err != nil {
+		return fmt.Errorf("local disk test failed: %w", err)
+	}
+	progress("%-12s %s/s writing\n", "Local disk", formatBytes(int64(result.LocalDisk)))
+
+	if jsonOutput {
+		return printJSON(result)
+	}
+	fmt.Printf("\n%s\n", benchVerdict(result))
+	return nil
+}
+
+// benchLoop runs op from --parallel workers for --duration and returns the
+// bytes they moved and the time it took
+func benchLoop(ctx context.Context, op func(ctx context.Context) (int, error)) (int64, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, benchDuration)
+	defer cancel()
+
+	var total atomic.Int64
+	var firstErr error
+	var once sync.Once
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range parallel {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				n, err := op(ctx)
+				if err != nil {
+					if ctx.Err() == nil {
+						once.Do(func() { firstErr = err; cancel() })
+					}
+					return
+				}
+				total.Add(int64(n))
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Interrupting the benchmark is not a failure of the tunnel
+	if firstErr == nil && errors.Is(ctx.Err(), context.Canceled) {
+		return 0, 0, errors.New("interrupted")
+	}
+	return total.Load(), time.Since(start), firstErr
+}
+
+// relayRoundTrip times a plain HTTP request to the relay, or returns 0 when
+// it cannot be reached that way
+func relayRoundTrip() time.Duration {
+	client := relayHTTPClient(5 * time.Second)
+	url := strings.TrimSuffix(relayURL, "/") + "/"
+
+	// The first request also sets up the connection, keep the second
+	var rtt time.Duration
+	for range 2 {
+		start := time.Now()
+		resp, err := client.Get(url)
+		if err != nil {
+			return 0
+		}
+		_ = resp.Body.Close()
+		rtt = time.Since(start)
+	}
+	return rtt
+}
+
+// tunnelRoundTrip returns the average and shortest ping through the tunnel
+func tunnelRoundTrip(ctx context.Context, client *remote.Client) (avg, lowest time.Duration, err error) {
+	var total time.Duration
+	for i := range benchPings {
+		rtt, err := client.Ping(ctx)
+		if err != nil {
+			return 0, 0, fmt.Errorf("ping failed: %w", err)
+		}
+		total += rtt
+		if i == 0 || rtt < lowest {
+			lowest = rtt
+		}
+	}
+	return total / benchPings, lowest, nil
+}
+
+// benchRemoteFile reads a shared file for --duration and returns the rate,
+// starting over at its end
+func benchRemoteFile(ctx context.Context, client *remote.Client, remotePath string) (float64, error) {
+	info, err := client.Stat(ctx, remotePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", remotePath, err)
+	}
+	if info.IsDir || info.Size == 0 {
+		return 0, fmt.Errorf("%s is not a file with content", remotePath)
+	}
+
+	var next atomic.Int64
+	n, elapsed, err := benchLoop(ctx, func(ctx context.Context) (int, error) {
+		offset := (next.Add(transfer.ChunkSize) - transfer.ChunkSize) % info.Size
+		data, err := client.Read(ctx, remotePath, offset, transfer.ChunkSize)
+		return len(data), err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("reading %s failed: %w", remotePath, err)
+	}
+	return float64(n) / elapsed.Seconds(), nil
+}
+
+// benchLocalDisk writes to a temporary file for up to a second, or up to
+// benchDiskLimit, and returns the rate including the final sync
+func benchLocalDisk() (float64, error) {
+	file, err := os.CreateTemp("", "orb-bench-*")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+
+	buf := make([]byte, 1024*1024)
+	var written int64
+	start := time.Now()
+	for written < benchDiskLimit && time.Since(start) < time.Second {
+		n, err := file.Write(buf)
+		if err != nil {
+			return 0, err
+		}
+		written += int64(n)
+	}
+	if err := file.Sync(); err != nil {
+		return 0, err
+	}
+	return float64(written) / time.Since(start).Seconds(), nil
+}
+
+// benchVerdict names the slowest part of a transfer
+func benchVerdict(r benchResult) string {
+	tunnel := min(r.Download, r.Upload)
+	switch {
+	case r.SharerDisk > 0 && r.SharerDisk < tunnel*0.8:
+		return "The sharer's disk is slower than the tunnel and limits downloads."
+	case r.LocalDisk < tunnel:
+		return "This machine's disk is slower than the tunnel and limits downloads."
+	case r.RelayRTT > 0 && r.TunnelRTTMin > 4*r.RelayRTT:
+		return "Most of the round trip is spent between the relay and the sharer; a relay closer to the sharer would help."
+	case parallel == 1 && r.TunnelRTT > 20*time.Millisecond:
+		return "The round trip is long; try --parallel 4 or more to keep the tunnel busy."
+	default:
+		return "The relay and the network path set the speed; the disks keep up."
+	}
+}
+
+// formatRTT rounds a round trip time for display
+func formatRTT(d time.Duration) string {
+	if d == 0 {
+		return "unknown"
+	}
+	if d < time.Millisecond {
+		return d.Round(10 * time.Microsecond).String()
+	}
+	return d.Round(100 * time.Microsecond).String()
+}
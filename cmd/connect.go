@@ -1,13 +1,22 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
-	"runtime"
+	"os/signal"
+	"time"
 
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"github.com/Zayan-Mohamed/orb/internal/mount"
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
 	"github.com/Zayan-Mohamed/orb/internal/tui"
 	"github.com/Zayan-Mohamed/orb/internal/tunnel"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var connectCmd = &cobra.Command{
@@ -19,49 +28,163 @@ var connectCmd = &cobra.Command{
 }
 
 var (
-	passcode  string
-	mountPath string
-	tuiMode   bool
+	passcode    string
+	mountPath   string
+	tuiMode     bool
+	concurrency int
+	parallel    int
+	outDir      string
+
+	// verifyTransfers compares checksums after each transfer
+	verifyTransfers bool
+
+	// passcodeFile holds the passcode, to keep it out of process listings
+	passcodeFile string
+
+	// Tuning of --mount and the local servers
+	mountAttrTimeout time.Duration
+	mountReadAhead   = byteSize(mount.DefaultReadAhead)
+	mountWriteBack   = byteSize(mount.DefaultWriteBack)
+	mountCacheSize   = byteSize(mount.DefaultCacheSize)
+	mountCacheDir    string
 )
 
 func init() {
 	rootCmd.AddCommand(connectCmd)
-	connectCmd.Flags().StringVar(&relayURL, "relay", "http://localhost:8080", "Relay server URL")
-	connectCmd.Flags().StringVarP(&passcode, "passcode", "p", "", "Session passcode (will prompt if not provided)")
-	connectCmd.Flags().StringVarP(&mountPath, "mount", "m", "", "Mount point (Linux/macOS only)")
+	addRelayFlags(connectCmd)
+	addPasscodeFlags(connectCmd)
+	connectCmd.Flags().StringVarP(&mountPath, "mount", "m", "", "Mount the share at this directory instead of opening the file browser, or at a drive letter such as X: on Windows")
+	addLocalServerFlags(connectCmd)
+	connectCmd.Flags().DurationVar(&mountAttrTimeout, "attr-timeout", mount.DefaultAttrTimeout, "How long --mount and the local servers trust file attributes before asking the sharer again")
+	connectCmd.Flags().Var(&mountReadAhead, "read-ahead", "How much of a file --mount and the local servers fetch at once while it is read from start to end")
+	connectCmd.Flags().Var(&mountWriteBack, "write-back", "How much written data --mount and the local servers collect before sending it to the sharer")
+	connectCmd.Flags().Var(&mountCacheSize, "cache-size", "How much of what --mount and the local servers read is kept for reading it again, 0 to keep nothing")
+	connectCmd.Flags().StringVar(&mountCacheDir, "cache-dir", "", "Keep the cache of --cache-size on disk in this directory instead of in memory, removed on exit")
 	connectCmd.Flags().BoolVar(&tuiMode, "tui", true, "Use TUI file browser")
+	connectCmd.Flags().StringVarP(&outDir, "output", "o", defaultDownloadDir(), "Directory where downloaded files are saved, created if missing")
+	// --out was the original name of --output and keeps working
+	connectCmd.Flags().SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		if name == "out" {
+			name = "output"
+		}
+		return pflag.NormalizedName(name)
+	})
+	connectCmd.Flags().IntVar(&concurrency, "concurrency", transfer.DefaultConcurrency, "Number of transfers to run at the same time")
+	connectCmd.Flags().BoolVar(&verifyTransfers, "verify", false, "Check every transfer against the sharer's SHA-256 checksum")
+	connectCmd.Flags().IntVar(&parallel, "parallel", 1, "Number of chunks of each download to fetch at the same time")
+	connectCmd.Flags().Var(&bwLimit, "bwlimit", "Limit bandwidth in each direction, e.g. 500K or 2M per second")
+	connectCmd.Flags().StringArrayVar(&forwardSpecs, "forward", nil, "Forward a local port to an address the sharer allowed, [bind:]port:host:hostport (repeatable)")
+	addMetricsFlag(connectCmd)
+	addReportFlag(connectCmd)
 }
 
-func runConnect(cmd *cobra.Command, args []string) error {
+func runConnect(cmd *cobra.Command, args []string) (err error) {
 	sessionID := args[0]
 
+	if jsonOutput {
+		return fmt.Errorf("connect is interactive, use ls, sync or receive for JSON output")
+	}
+
+	if err := checkParallel(); err != nil {
+		return err
+	}
+	if err := checkReportFile(); err != nil {
+		return err
+	}
+	// Rather than falling back to the file browser, say why there is no mount
+	if mountPath != "" && !mount.Supported {
+		return mount.ErrUnsupported
+	}
+	server, err := chosenLocalServer()
+	if err != nil {
+		return err
+	}
+	forwards, err := parseForwards()
+	if err != nil {
+		return err
+	}
+	if len(forwards) > 0 && (server != nil || mountPath != "") {
+		return fmt.Errorf("--forward cannot be combined with --mount or the local servers")
+	}
+
+	downloadDir, err := resolveDownloadDir(outDir)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	keys, err := tui.KeyMapFromConfig(cfg.TUI.Keymap)
+	if err != nil {
+		return fmt.Errorf("invalid keymap in config: %w", err)
+	}
+
+	theme, err := tui.ThemeFromConfig(cfg.TUI.Theme)
+	if err != nil {
+		return fmt.Errorf("invalid theme in config: %w", err)
+	}
+
 	// Prompt for passcode if not provided
-	if passcode == "" {
-		fmt.Print("Enter passcode: ")
-		_, _ = fmt.Scanln(&passcode)
+	if err := resolvePasscode(); err != nil {
+		return err
 	}
 
+	stopMetrics, err := serveMetrics()
+	if err != nil {
+		return err
+	}
+	defer stopMetrics()
+
 	// Establish tunnel
 	fmt.Printf("Connecting to session %s...\n", sessionID)
 
 	// Connector is the initiator (starts the handshake)
-	tun, err := tunnel.NewTunnel(relayURL, sessionID, passcode, true)
+	tun, err := dialAnyRelay(sessionID, passcode,
+		relayDialOptions(tunnel.WithBandwidthLimit(int64(bwLimit)))...)
 	if err != nil {
 		return fmt.Errorf("failed to connect: %w", err)
 	}
 	defer func() {
 		if err := tun.Close(); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to close tunnel: %v\n", err)
+			slog.Warn("failed to close tunnel", "err", err)
+		}
+	}()
+	// Leaving revokes the session, so that it cannot be joined again
+	defer endSession(sessionID, passcode)()
+
+	// The report covers how the session ended, and the tunnel is still
+	// open to tell what went through it
+	var reportPath string
+	if server == nil && len(forwards) == 0 && mountPath == "" {
+		reportPath = downloadDir
+	}
+	report := newSessionReport("connect", sessionID, reportPath)
+	defer func() {
+		if err != nil {
+			report.failed("", err.Error())
 		}
+		report.write()
 	}()
+	peer := report.connected("sharer")
+	defer report.disconnected(peer, tun)
 
 	fmt.Printf("✓ Connected! Tunnel established.\n")
+	if len(relayURLs) > 1 {
+		fmt.Printf("  Relay: %s\n", relayURL)
+	}
+
+	if server != nil {
+		return serveLocally(tun, server)
+	}
 
-	// Determine mode based on platform and flags
-	canMount := runtime.GOOS == "linux" || runtime.GOOS == "darwin"
+	if len(forwards) > 0 {
+		return runForwards(tun, forwards)
+	}
 
-	if mountPath != "" && canMount {
-		// Try FUSE mounting (Linux/macOS only)
+	if mountPath != "" {
 		fmt.Printf("Mounting at %s...\n", mountPath)
 		return mountFilesystem(tun, mountPath)
 	}
@@ -69,15 +192,90 @@ func runConnect(cmd *cobra.Command, args []string) error {
 	// Use TUI file browser (cross-platform)
 	if tuiMode {
 		fmt.Printf("Opening file browser...\n")
+		fmt.Printf("Downloads will be saved to %s\n", downloadDir)
 		fmt.Printf("Press Ctrl+C to disconnect.\n\n")
-		return tui.StartFileBrowser(tun)
+		return tui.StartFileBrowser(tun, tui.Options{
+			Concurrency: concurrency,
+			Parallel:    parallel,
+			Verify:      verifyTransfers,
+			DownloadDir: downloadDir,
+			SessionID:   sessionID,
+			Keys:        &keys,
+			Theme:       &theme,
+			Finished:    report.transfers,
+		})
 	}
 
 	return fmt.Errorf("no mode selected (use --tui or --mount)")
 }
 
-// mountFilesystem mounts the remote filesystem using FUSE
+// mountFilesystem mounts the share at mountPoint until Ctrl+C or the end
+// of the session
 func mountFilesystem(tun *tunnel.Tunnel, mountPoint string) error {
-	// This will be implemented with FUSE support
-	return fmt.Errorf("FUSE mounting not yet implemented - use --tui mode")
+	if err := mount.CheckMountPoint(mountPoint); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client, info, err := mountClient(ctx, tun, "--mount")
+	if err != nil {
+		return err
+	}
+
+	if info.ReadOnly {
+		fmt.Printf("The share is read-only, so is the mount.\n")
+	}
+	opts, err := mountOptions(info)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = opts.Cache.Close() }()
+
+	fmt.Printf("Press Ctrl+C to unmount and disconnect.\n")
+	err = mount.Mount(ctx, client, mountPoint, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Unmounted %s.\n", mountPoint)
+	return nil
+}
+
+// mountClient starts the requests of --mount or a local server (named by
+// flag), kept alive while idle, and checks that the share can be used
+// through them
+func mountClient(ctx context.Context, tun *tunnel.Tunnel, flag string) (*remote.Client, *protocol.InfoResponse, error) {
+	mux := tunnel.NewMux(tun)
+	mux.KeepAlive()
+	client := remote.NewClient(mux)
+	info, err := client.Info(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read share info: %w", err)
+	}
+	if info.Stream {
+		return nil, nil, fmt.Errorf("a stream can only be read once, use orb receive instead of %s", flag)
+	}
+	return client, info, nil
+}
+
+// mountOptions tunes --mount and the local servers for a share. The cache
+// it sets up has to be closed.
+func mountOptions(info *protocol.InfoResponse) (mount.Options, error) {
+	opts := mount.Options{
+		ReadOnly:    info.ReadOnly,
+		AttrTimeout: mountAttrTimeout,
+		ReadAhead:   int(mountReadAhead),
+		WriteBack:   int(mountWriteBack),
+	}
+	if mountCacheSize > 0 {
+		dir, err := config.ExpandHome(mountCacheDir)
+		if err != nil {
+			return mount.Options{}, err
+		}
+		if opts.Cache, err = mount.NewCache(int64(mountCacheSize), dir); err != nil {
+			return mount.Options{}, err
+		}
+	}
+	return opts, nil
 }
@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+
+	"github.com/Zayan-Mohamed/orb/internal/forward"
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/internal/tunnel"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// forwardSpecs is --forward
+var forwardSpecs []string
+
+// portForward is a parsed --forward: connections to Listen on this machine
+// are carried to Target, which the sharer connects to
+type portForward struct {
+	Listen string
+	Target string
+}
+
+// parseForward parses [bind:]port:host:hostport like ssh -L. Without a bind
+// address only this machine can use the port. IPv6 addresses go in brackets.
+func parseForward(spec string) (portForward, error) {
+	parts, err := splitForward(spec)
+	if err != nil {
+		return portForward{}, err
+	}
+
+	bind := "127.0.0.1"
+	switch len(parts) {
+	case 3:
+	case 4:
+		bind, parts = parts[0], parts[1:]
+	default:
+		return portForward{}, fmt.Errorf("invalid --forward %q, expected [bind:]port:host:hostport, e.g. 8080:localhost:3000", spec)
+	}
+	if parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return portForward{}, fmt.Errorf("invalid --forward %q, expected [bind:]port:host:hostport, e.g. 8080:localhost:3000", spec)
+	}
+
+	return portForward{
+		Listen: net.JoinHostPort(bind, parts[0]),
+		Target: net.JoinHostPort(parts[1], parts[2]),
+	}, nil
+}
+
+// splitForward splits spec at colons outside of brackets and strips the brackets
+func splitForward(spec string) ([]string, error) {
+	var parts []string
+	var current strings.Builder
+	inBrackets := false
+	for _, r := range spec {
+		switch {
+		case r == '[' && !inBrackets:
+			inBrackets = true
+		case r == ']' && inBrackets:
+			inBrackets = false
+		case r == ':' && !inBrackets:
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if inBrackets {
+		return nil, fmt.Errorf("invalid --forward %q: unclosed bracket", spec)
+	}
+	return append(parts, current.String()), nil
+}
+
+// parseForwards parses every --forward
+func parseForwards() ([]portForward, error) {
+	forwards := make([]portForward, 0, len(forwardSpecs))
+	for _, spec := range forwardSpecs {
+		f, err := parseForward(spec)
+		if err != nil {
+			return nil, err
+		}
+		forwards = append(forwards, f)
+	}
+	return forwards, nil
+}
+
+// runForwards carries connections to the forwarded ports through the tunnel
+// until Ctrl+C or the end of the session
+func runForwards(tun *tunnel.Tunnel, forwards []portForward) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	mux := tunnel.NewMux(tun)
+	mux.KeepAlive()
+	client := remote.NewClient(mux)
+	streams := forward.NewStreams(mux.Notify)
+	for _, t := range forward.FrameTypes {
+		mux.Handle(t, streams.Handle)
+	}
+
+	var listeners []net.Listener
+	defer func() {
+		for _, ln := range listeners {
+			_ = ln.Close()
+		}
+	}()
+	for _, f := range forwards {
+		ln, err := net.Listen("tcp", f.Listen)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", f.Listen, err)
+		}
+		listeners = append(listeners, ln)
+		fmt.Printf("Forwarding %s → %s on the sharer's side\n", ln.Addr(), f.Target)
+		if host, _, _ := net.SplitHostPort(f.Listen); !isLoopback(host) {
+			fmt.Printf("Warning: anyone who can reach %s can use the forward, without a passcode.\n", ln.Addr())
+		}
+	}
+	fmt.Printf("Press Ctrl+C to stop forwarding and disconnect.\n")
+
+	var wg sync.WaitGroup
+	for i, ln := range listeners {
+		wg.Add(1)
+		go func(ln net.Listener, target string) {
+			defer wg.Done()
+			acceptForwards(ctx, ln, target, client, streams, &wg)
+		}(ln, forwards[i].Target)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-mux.Done():
+	}
+	for _, ln := range listeners {
+		_ = ln.Close()
+	}
+	streams.CloseAll()
+	wg.Wait()
+
+	if err := mux.Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("session ended: %w", err)
+	}
+	fmt.Printf("Stopped forwarding.\n")
+	return nil
+}
+
+// acceptForwards carries each connection accepted on ln to target
+func acceptForwards(ctx context.Context, ln net.Listener, target string, client *remote.Client, streams *forward.Streams, wg *sync.WaitGroup) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Warn("failed to accept connection", "addr", ln.Addr(), "err", err)
+			}
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st, err := streams.New()
+			if err != nil {
+				_ = conn.Close()
+				return
+			}
+			if err := client.Forward(ctx, target, st.ID()); err != nil {
+				var remoteErr *protocol.ErrorResponse
+				if errors.As(err, &remoteErr) {
+					fmt.Fprintf(os.Stderr, "Forward to %s refused: %s\n", target, remoteErr.Message)
+				} else {
+					slog.Warn("failed to forward connection", "target", target, "err", err)
+				}
+				_ = st.Close()
+				_ = conn.Close()
+				return
+			}
+			forward.Pipe(conn, st)
+		}()
+	}
+}
+
+// isLoopback reports whether host is a loopback address or localhost
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"github.com/Zayan-Mohamed/orb/internal/gateway"
+	"github.com/Zayan-Mohamed/orb/internal/mount"
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/internal/tunnel"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+// localServer serves the share to programs on the receiving machine, in
+// place of the file browser
+type localServer struct {
+	flag  string // the flag that picks it, e.g. "--webdav"
+	name  string // the protocol served, for messages
+	usage string
+	addr  string // the value of the flag
+	// setup, if set, prepares the server before it listens
+	setup func() error
+	serve func(ctx context.Context, client *remote.Client, ln net.Listener, opts mount.Options) error
+	// hints prints how to use the server listening at addr
+	hints func(addr net.Addr)
+}
+
+var localServers = []*localServer{
+	{
+		flag:  "--webdav",
+		name:  "WebDAV",
+		usage: "Serve the share as a WebDAV endpoint at this address, e.g. :8081, instead of opening the file browser",
+		serve: mount.ServeWebDAV,
+		hints: func(addr net.Addr) {
+			fmt.Printf("  Finder:   Go > Connect to Server, http://%s/\n", addr)
+			fmt.Printf("  Explorer: Map network drive, http://%s/\n", addr)
+			fmt.Printf("  Nautilus: Other Locations, dav://%s/\n", addr)
+		},
+	},
+	{
+		flag:  "--http",
+		name:  "HTTP",
+		usage: "Serve a web UI for the share at this address, e.g. :8090, instead of opening the file browser",
+		serve: gateway.Serve,
+		hints: func(addr net.Addr) {
+			fmt.Printf("  Open http://%s/ in a browser.\n", addr)
+		},
+	},
+	{
+		flag:  "--sftp",
+		name:  "SFTP",
+		usage: "Serve the share over SFTP at this address, e.g. :2222, with the session passcode as password, instead of opening the file browser",
+		setup: loadSFTPHostKey,
+		serve: func(ctx context.Context, client *remote.Client, ln net.Listener, opts mount.Options) error {
+			return mount.ServeSFTP(ctx, client, ln, opts, mount.SFTPConfig{HostKey: sftpHostKey, Password: passcode})
+		},
+		hints: func(addr net.Addr) {
+			host, port, _ := net.SplitHostPort(addr.String())
+			fmt.Printf("  Connect:  sftp -P %s orb@%s\n", port, host)
+			fmt.Printf("  Password: the session passcode, any user name works\n")
+			fmt.Printf("  Host key: %s\n", ssh.FingerprintSHA256(sftpHostKey.PublicKey()))
+		},
+	},
+	{
+		flag:  "--restic",
+		name:  "restic REST",
+		usage: "Serve the share as a restic REST backend at this address, e.g. :8000, with the session passcode as password, instead of opening the file browser",
+		serve: func(ctx context.Context, client *remote.Client, ln net.Listener, opts mount.Options) error {
+			return mount.ServeRestic(ctx, client, ln, opts, passcode)
+		},
+		hints: func(addr net.Addr) {
+			fmt.Printf("  Repository: restic -r rest:http://orb:PASSCODE@%s/backups/ init\n", addr)
+			fmt.Printf("  PASSCODE is the session passcode, /backups/ any directory of the share.\n")
+		},
+	},
+	{
+		flag:  "--9p",
+		name:  "9P",
+		usage: "Serve the share over 9P2000.L at this address, e.g. :5640, for the 9p client of Linux, instead of opening the file browser",
+		serve: func(ctx context.Context, client *remote.Client, ln net.Listener, opts mount.Options) error {
+			return mount.Serve9P(ctx, client, ln, opts, passcode)
+		},
+		hints: func(addr net.Addr) {
+			host, port, _ := net.SplitHostPort(addr.String())
+			fmt.Printf("  Mount: sudo mount -t 9p -o trans=tcp,port=%s,version=9p2000.L,msize=1048576,aname=PASSCODE %s /mnt/orb\n", port, host)
+			fmt.Printf("  PASSCODE is the session passcode. Unmount with: sudo umount /mnt/orb\n")
+		},
+	},
+	{
+		flag:  "--nfs",
+		name:  "NFS",
+		usage: "Serve the share over NFSv3 at this address, e.g. :2049, for devices that only speak NFS, instead of opening the file browser (experimental)",
+		serve: func(ctx context.Context, client *remote.Client, ln net.Listener, opts mount.Options) error {
+			return mount.ServeNFS(ctx, client, ln, opts, passcode)
+		},
+		hints: func(addr net.Addr) {
+			host, port, _ := net.SplitHostPort(addr.String())
+			fmt.Printf("  Linux: sudo mount -t nfs -o vers=3,proto=tcp,port=%s,mountport=%s,mountproto=tcp,nolock %s:/PASSCODE /mnt/orb\n", port, port, host)
+			fmt.Printf("  macOS: sudo mount -t nfs -o vers=3,tcp,port=%s,mountport=%s,nolocks %s:/PASSCODE /mnt/orb\n", port, port, host)
+			fmt.Printf("  PASSCODE is the session passcode. The mount protocol is served on the same port.\n")
+		},
+	},
+}
+
+// sftpHostKey identifies --sftp to SSH clients
+var sftpHostKey ssh.Signer
+
+// loadSFTPHostKey loads the host key of --sftp from the configuration
+// directory, creating it on first use
+func loadSFTPHostKey() error {
+	dir, err := config.Dir()
+	if err != nil {
+		return err
+	}
+	sftpHostKey, err = mount.LoadHostKey(filepath.Join(dir, "sftp_host_key"))
+	return err
+}
+
+// addLocalServerFlags registers the flags of the local servers on cmd
+func addLocalServerFlags(cmd *cobra.Command) {
+	for _, s := range localServers {
+		cmd.Flags().StringVar(&s.addr, strings.TrimPrefix(s.flag, "--"), "", s.usage)
+	}
+}
+
+// chosenLocalServer returns the local server picked by its flag, nil when
+// none was. Only one of them and --mount can be used at a time.
+func chosenLocalServer() (*localServer, error) {
+	var chosen *localServer
+	for _, s := range localServers {
+		if s.addr == "" {
+			continue
+		}
+		if chosen != nil {
+			return nil, fmt.Errorf("%s and %s cannot be used together", chosen.flag, s.flag)
+		}
+		chosen = s
+	}
+	if chosen != nil && mountPath != "" {
+		return nil, fmt.Errorf("--mount and %s cannot be used together", chosen.flag)
+	}
+	return chosen, nil
+}
+
+// serveLocally runs server until Ctrl+C or the end of the session. A bare
+// port listens on localhost only.
+func serveLocally(tun *tunnel.Tunnel, server *localServer) error {
+	host, port, err := net.SplitHostPort(server.addr)
+	if err != nil {
+		return fmt.Errorf("invalid %s address %q: %w", server.flag, server.addr, err)
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+
+	if server.setup != nil {
+		if err := server.setup(); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client, info, err := mountClient(ctx, tun, server.flag)
+	if err != nil {
+		return err
+	}
+	opts, err := mountOptions(info)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = opts.Cache.Close() }()
+
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", server.addr, err)
+	}
+
+	fmt.Printf("Serving the share over %s at %s\n", server.name, ln.Addr())
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		fmt.Printf("Warning: anyone who can reach this address can use the share, without a passcode.\n")
+	}
+	if info.ReadOnly {
+		fmt.Printf("The share is read-only, changes are refused.\n")
+	}
+	server.hints(ln.Addr())
+	fmt.Printf("Press Ctrl+C to stop and disconnect.\n")
+
+	if err := server.serve(ctx, client, ln, opts); err != nil {
+		return err
+	}
+	fmt.Printf("Stopped serving over %s.\n", server.name)
+	return nil
+}
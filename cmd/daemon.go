@@ -0,0 +1,387 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"github.com/Zayan-Mohamed/orb/internal/daemon"
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	"github.com/Zayan-Mohamed/orb/internal/tunnel"
+	"github.com/spf13/cobra"
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run the control API for GUIs and automation",
+	Long: `Run orb in the background with a local control API, so that GUIs, tray apps
+and scripts can start shares, list sessions and peers, download files and
+follow their progress. It also runs the syncs scheduled with orb jobs.
+
+The API is served over HTTP on the unix socket daemons/api.sock in the
+configuration directory. Each request needs the token the daemon writes to
+daemons/api.token on start, e.g.
+
+  curl --unix-socket ~/.config/orb/daemons/api.sock \
+    -H "Authorization: Bearer $(cat ~/.config/orb/daemons/api.token)" \
+    http://orb/v1/shares`,
+	Args: cobra.NoArgs,
+	RunE: runDaemon,
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+	addRelayFlags(daemonCmd)
+}
+
+func runDaemon(cmd *cobra.Command, args []string) error {
+	backend := &daemonBackend{}
+	server, err := daemon.ServeAPI(backend)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = server.Close() }()
+	defer backend.close()
+
+	socket, token, _ := daemon.APIPaths()
+	if jsonOutput {
+		if err := printJSON(event{Event: "listening", Address: socket, Path: token}); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("Orb daemon listening on %s\n", socket)
+		fmt.Printf("API token in %s\n", token)
+		fmt.Printf("Press Ctrl+C to stop.\n")
+	}
+	slog.Info("daemon started", "socket", socket)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go backend.runJobs(ctx)
+	<-ctx.Done()
+	slog.Info("daemon stopped")
+	return nil
+}
+
+// daemonBackend carries out the requests of the control API. Transfers run
+// in this process, one tunnel per session; shares run as background shares
+// of their own, like those of orb share --daemon.
+type daemonBackend struct {
+	mu        sync.Mutex
+	sessions  map[string]*receivedSession
+	transfers []daemonTransfer
+}
+
+// receivedSession is a session the daemon receives from
+type receivedSession struct {
+	id      string
+	tun     *tunnel.Tunnel
+	client  *remote.Client
+	manager *transfer.Manager
+}
+
+// daemonTransfer maps an API transfer ID to a transfer of a session
+type daemonTransfer struct {
+	session *receivedSession
+	id      int // in the manager of session
+}
+
+func (b *daemonBackend) CreateShare(req daemon.ShareRequest) (daemon.ShareCreated, error) {
+	args := []string{"share", "--daemon", "--json"}
+	if req.ReadOnly {
+		args = append(args, "--readonly")
+	}
+	if req.Expire != "" {
+		if _, err := time.ParseDuration(req.Expire); err != nil {
+			return daemon.ShareCreated{}, fmt.Errorf("%w: expire: %w", daemon.ErrInvalidRequest, err)
+		}
+		args = append(args, "--expire", req.Expire)
+	}
+	if req.MaxDownloads > 0 {
+		args = append(args, "--max-downloads", strconv.Itoa(req.MaxDownloads))
+	}
+	relays := strings.Join(relayURLs, ",")
+	if req.Relay != "" {
+		relays = req.Relay
+	}
+	args = append(args, "--relay", relays)
+	args = append(args, inheritedFlags()...)
+
+	paths := make([]string, len(req.Paths))
+	for i, p := range req.Paths {
+		p, err := config.ExpandHome(p)
+		if err != nil {
+			return daemon.ShareCreated{}, err
+		}
+		if !filepath.IsAbs(p) {
+			return daemon.ShareCreated{}, fmt.Errorf("%w: %s is not an absolute path", daemon.ErrInvalidRequest, p)
+		}
+		if _, err := os.Stat(p); err != nil {
+			return daemon.ShareCreated{}, fmt.Errorf("%w: %w", daemon.ErrInvalidRequest, err)
+		}
+		paths[i] = p
+	}
+	args = append(args, "--")
+	args = append(args, paths...)
+
+	exe, err := os.Executable()
+	if err != nil {
+		return daemon.ShareCreated{}, fmt.Errorf("failed to locate orb: %w", err)
+	}
+	// #nosec G204 -- runs this same binary with arguments checked above
+	child := exec.Command(exe, args...)
+	out, err := child.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return daemon.ShareCreated{}, fmt.Errorf("failed to start share: %s", shareFailure(exitErr.Stderr))
+		}
+		return daemon.ShareCreated{}, fmt.Errorf("failed to start share: %w", err)
+	}
+
+	// The share prints its session as JSON once it runs in the background
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		var e event
+		if json.Unmarshal(scanner.Bytes(), &e) != nil || e.Event != "session" {
+			continue
+		}
+		slog.Info("share started", "session", e.SessionID, "pid", e.PID)
+		return daemon.ShareCreated{
+			SessionID: e.SessionID,
+			Passcode:  e.Passcode,
+			Relay:     e.Relay,
+			PID:       e.PID,
+			LogFile:   e.LogFile,
+			Expires:   e.Expires,
+		}, nil
+	}
+	return daemon.ShareCreated{}, errors.New("share started without reporting its session")
+}
+
+// inheritedFlags passes the global flags of the daemon on to the shares it
+// starts
+func inheritedFlags() []string {
+	var flags []string
+	if profile != "" {
+		flags = append(flags, "--profile", profile)
+	}
+	if relayToken != "" {
+		flags = append(flags, "--relay-token", relayToken)
+	}
+	if proxyFlag != "" {
+		flags = append(flags, "--proxy", proxyFlag)
+	}
+	if viaFlag != "" {
+		flags = append(flags, "--via", viaFlag)
+	}
+	if viaToken != "" {
+		flags = append(flags, "--via-token", viaToken)
+	}
+	return flags
+}
+
+// shareFailure picks the error a share printed as JSON on failure
+func shareFailure(stderr []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(stderr)), "\n")
+	last := lines[len(lines)-1]
+	var e struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal([]byte(last), &e) == nil && e.Error != "" {
+		return e.Error
+	}
+	return last
+}
+
+func (b *daemonBackend) StartTransfer(req daemon.TransferRequest) (daemon.TransferStatus, error) {
+	s, err := b.session(req)
+	if err != nil {
+		return daemon.TransferStatus{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	remotePath := path.Clean("/" + req.Path)
+	stat, err := s.client.Stat(ctx, remotePath)
+	if err != nil {
+		return daemon.TransferStatus{}, fmt.Errorf("failed to read %s: %w", remotePath, err)
+	}
+	if stat.IsDir {
+		return daemon.TransferStatus{}, fmt.Errorf("%w: %s is a directory, only files can be downloaded", daemon.ErrInvalidRequest, remotePath)
+	}
+
+	dest := req.Dest
+	if dest == "" {
+		dest = defaultDownloadDir() + string(filepath.Separator)
+	}
+	if expanded, err := config.ExpandHome(dest); err != nil || !filepath.IsAbs(expanded) {
+		return daemon.TransferStatus{}, fmt.Errorf("%w: dest must be an absolute path", daemon.ErrInvalidRequest)
+	}
+	target, err := getTarget(dest, path.Base(remotePath))
+	if err != nil {
+		return daemon.TransferStatus{}, fmt.Errorf("%w: %w", daemon.ErrInvalidRequest, err)
+	}
+
+	localID := s.manager.Enqueue(transfer.Download, remotePath, target, stat.Size)
+	b.mu.Lock()
+	b.transfers = append(b.transfers, daemonTransfer{session: s, id: localID})
+	id := len(b.transfers)
+	b.mu.Unlock()
+	slog.Info("transfer started", "id", id, "session", s.id, "path", remotePath, "target", target)
+
+	for _, t := range b.Transfers() {
+		if t.ID == id {
+			return t, nil
+		}
+	}
+	return daemon.TransferStatus{}, daemon.ErrTransferNotFound
+}
+
+// session returns the open session of req, connecting to it first when the
+// daemon has not yet. Sessions take one receiver, so transfers from the same
+// session share its tunnel.
+func (b *daemonBackend) session(req daemon.TransferRequest) (*receivedSession, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.sessions == nil {
+		b.sessions = make(map[string]*receivedSession)
+	}
+	if s, ok := b.sessions[req.SessionID]; ok {
+		select {
+		case <-s.client.Mux().Done():
+			delete(b.sessions, req.SessionID)
+		default:
+			return s, nil
+		}
+	}
+
+	var tun *tunnel.Tunnel
+	var err error
+	if req.Relay != "" {
+		tun, err = tunnel.NewTunnel(req.Relay, req.SessionID, req.Passcode, true, relayDialOptions()...)
+	} else {
+		tun, err = dialAnyRelay(req.SessionID, req.Passcode, relayDialOptions()...)
+	}
+	if err != nil {
+		if errors.Is(err, tunnel.ErrSessionNotFound) {
+			return nil, fmt.Errorf("%w: %w", daemon.ErrNotFound, err)
+		}
+		return nil, err
+	}
+
+	client := remote.NewClient(tunnel.NewMux(tun))
+	manager := transfer.NewManager(client, transfer.DefaultConcurrency)
+	manager.SetVerify(true)
+	manager.SetObserver(transferObserver(req.SessionID))
+	s := &receivedSession{id: req.SessionID, tun: tun, client: client, manager: manager}
+	b.sessions[req.SessionID] = s
+	go s.keepAlive()
+	slog.Info("connected to session", "session", req.SessionID)
+	return s, nil
+}
+
+// keepAlive pings the sharer so an idle session stays open for further
+// transfers, until the tunnel closes
+func (s *receivedSession) keepAlive() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.client.Mux().Done():
+			slog.Info("session closed", "session", s.id, "err", s.client.Mux().Err())
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			_, _ = s.client.Ping(ctx)
+			cancel()
+		}
+	}
+}
+
+func (b *daemonBackend) Transfers() []daemon.TransferStatus {
+	b.mu.Lock()
+	transfers := append([]daemonTransfer(nil), b.transfers...)
+	b.mu.Unlock()
+
+	// One snapshot per session
+	snapshots := make(map[*receivedSession]map[int]transfer.Transfer)
+	statuses := make([]daemon.TransferStatus, 0, len(transfers))
+	for i, t := range transfers {
+		if snapshots[t.session] == nil {
+			snapshots[t.session] = make(map[int]transfer.Transfer)
+			for _, tr := range t.session.manager.Snapshot() {
+				snapshots[t.session][tr.ID] = tr
+			}
+		}
+		tr, ok := snapshots[t.session][t.id]
+		if !ok {
+			continue
+		}
+		status := daemon.TransferStatus{
+			ID:          i + 1,
+			SessionID:   t.session.id,
+			Direction:   tr.Direction.String(),
+			RemotePath:  tr.RemotePath,
+			LocalPath:   tr.LocalPath,
+			Size:        tr.Size,
+			Transferred: tr.Transferred,
+			Progress:    tr.Progress(),
+			Speed:       tr.Speed,
+			State:       tr.State.String(),
+			Started:     tr.Started,
+			Finished:    tr.Finished,
+		}
+		if tr.Err != nil {
+			status.Error = tr.Err.Error()
+		}
+		if tr.SHA256 != nil {
+			status.SHA256 = hex.EncodeToString(tr.SHA256)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func (b *daemonBackend) CancelTransfer(id int) error {
+	b.mu.Lock()
+	if id < 1 || id > len(b.transfers) {
+		b.mu.Unlock()
+		return daemon.ErrTransferNotFound
+	}
+	t := b.transfers[id-1]
+	b.mu.Unlock()
+	for _, tr := range t.session.manager.Snapshot() {
+		if tr.ID == t.id && tr.State.Finished() {
+			return fmt.Errorf("%w: transfer %d is %s", daemon.ErrInvalidRequest, id, tr.State)
+		}
+	}
+	return t.session.manager.Cancel(t.id)
+}
+
+// close disconnects from all sessions, cancelling their transfers
+func (b *daemonBackend) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range b.sessions {
+		s.manager.CancelAll()
+		_ = s.tun.Close()
+	}
+	b.sessions = nil
+}
@@ -0,0 +1,321 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"github.com/Zayan-Mohamed/orb/internal/drop"
+	"github.com/Zayan-Mohamed/orb/internal/filesystem"
+	"github.com/spf13/cobra"
+)
+
+// encryptedSuffix is added to the names of downloads saved with --encrypt
+const encryptedSuffix = ".orbenc"
+
+var decryptCmd = &cobra.Command{
+	Use:   "decrypt <file>...",
+	Short: "Decrypt files saved with --encrypt",
+	Long: `Decrypt files that orb get or orb receive saved with --encrypt. Each file is
+saved under the name it was received with, next to the encrypted file unless
+--output names a directory, and only once it decrypted completely. The
+encrypted files are kept. With --output - a single file is written to
+standard output, e.g.
+
+  orb decrypt report.pdf.orbenc -o - | less
+
+Files are decrypted with the key of this machine, receive.key next to
+config.yaml, unless --key-file names another one.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runDecrypt,
+}
+
+var (
+	// receiveEncrypt is --encrypt of get and receive
+	receiveEncrypt bool
+	// encryptKeyFile is --key-file of get, receive and decrypt
+	encryptKeyFile string
+	// decryptOutput is where orb decrypt saves files, "-" for standard output
+	decryptOutput string
+	// configEncrypt is encrypt of config.yaml, --encrypt unless given
+	configEncrypt bool
+)
+
+func init() {
+	rootCmd.AddCommand(decryptCmd)
+	decryptCmd.Flags().StringVarP(&decryptOutput, "output", "o", "", "Directory to save to, or - for standard output")
+	decryptCmd.Flags().BoolVarP(&receiveYes, "yes", "y", false, "Overwrite existing files without asking")
+	decryptCmd.Flags().StringVar(&encryptKeyFile, "key-file", "", "Key to decrypt with instead of this machine's")
+}
+
+// addEncryptFlags registers --encrypt and --key-file on cmd
+func addEncryptFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&receiveEncrypt, "encrypt", false, "Save the file encrypted with this machine's key, see orb decrypt")
+	cmd.Flags().StringVar(&encryptKeyFile, "key-file", "", "Key to encrypt with instead of this machine's, created if missing")
+}
+
+// checkEncrypt applies encrypt of config.yaml, which standard output is
+// exempt from, and rejects what cannot be done with a download that is
+// only ever written encrypted
+func checkEncrypt(cmd *cobra.Command, toStdout bool) error {
+	if !cmd.Flags().Changed("encrypt") {
+		receiveEncrypt = configEncrypt && !toStdout
+	}
+	if !receiveEncrypt {
+		return nil
+	}
+	switch {
+	case toStdout:
+		return errors.New("--encrypt saves an encrypted file, it cannot be combined with --output -")
+	case wantsExtract():
+		return errors.New("--extract writes the files of the archive in plaintext, it cannot be combined with --encrypt")
+	case resumeDownload:
+		return errors.New("an encrypted download is written in one go, --resume does not apply")
+	case parallel > 1:
+		return errors.New("an encrypted download is written in order, --parallel does not apply")
+	case manifestFile != "":
+		return errors.New("--manifest checks the saved file, it cannot be combined with --encrypt")
+	}
+	return nil
+}
+
+// localKeyPath returns --key-file, or receive.key next to config.yaml
+func localKeyPath() (string, error) {
+	if encryptKeyFile != "" {
+		return config.ExpandHome(encryptKeyFile)
+	}
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "receive.key"), nil
+}
+
+// loadLocalKey reads the key downloads are encrypted with. With create, a
+// missing key is created, which the user is told to keep a copy of.
+func loadLocalKey(create bool) ([]byte, error) {
+	keyPath, err := localKeyPath()
+	if err != nil {
+		return nil, err
+	}
+	// #nosec G304 -- the path is under the config directory or given by the user
+	data, err := os.ReadFile(keyPath)
+	if errors.Is(err, os.ErrNotExist) && create {
+		return createLocalKey(keyPath)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no key in %s, give the one the files were encrypted with with --key-file", keyPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != drop.KeySize {
+		return nil, fmt.Errorf("%s is not a key of orb", keyPath)
+	}
+	return key, nil
+}
+
+func createLocalKey(keyPath string) ([]byte, error) {
+	key := make([]byte, drop.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(keyPath), err)
+	}
+	// O_EXCL keeps the key of a process that created it at the same time
+	// #nosec G304 -- the path is under the config directory or given by the user
+	f, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return loadLocalKey(false)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write key: %w", err)
+	}
+	if _, err := f.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+		_ = f.Close()
+		_ = os.Remove(keyPath)
+		return nil, fmt.Errorf("failed to write key: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write key: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Created the key %s, keep a copy elsewhere: what is encrypted with it cannot be decrypted without it\n", keyPath)
+	return key, nil
+}
+
+// sealWriter encrypts what is written to it with a local key, in the format
+// of package drop. Close writes the last segment.
+type sealWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newSealWriter(dst io.Writer, key []byte, meta drop.Meta) (*sealWriter, error) {
+	sealer, err := drop.NewKeySealer(key)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	w := &sealWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := sealer.Seal(dst, pr, meta)
+		// Writes fail from now on, also when sealing failed halfway
+		_ = pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+func (w *sealWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *sealWriter) Close() error {
+	_ = w.pw.Close()
+	return <-w.done
+}
+
+// createEncrypted creates the partial download of an encrypted file, which
+// has the given name and size, or -1 for a stream. Closing the returned
+// writer completes the file.
+func createEncrypted(partial, name string, size int64) (io.WriteCloser, error) {
+	key, err := loadLocalKey(true)
+	if err != nil {
+		return nil, err
+	}
+	// #nosec G304 -- the target is chosen by the receiving user
+	file, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	w, err := newSealWriter(file, key, drop.Meta{Name: name, Size: size})
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &encryptedFile{sealWriter: w, file: file}, nil
+}
+
+// encryptedFile is a sealWriter into a file, closed with it
+type encryptedFile struct {
+	*sealWriter
+	file *os.File
+}
+
+func (f *encryptedFile) Close() error {
+	err := f.sealWriter.Close()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func runDecrypt(cmd *cobra.Command, args []string) error {
+	toStdout := decryptOutput == "-"
+	if toStdout && len(args) > 1 {
+		return errors.New("--output - writes a single file, give only one")
+	}
+	if toStdout && jsonOutput {
+		return errors.New("--output - cannot be combined with --json")
+	}
+	var dir string
+	if decryptOutput != "" && !toStdout {
+		var err error
+		if dir, err = resolveDownloadDir(decryptOutput); err != nil {
+			return err
+		}
+	}
+	key, err := loadLocalKey(false)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, source := range args {
+		if err := decryptFile(key, source, dir, toStdout); err != nil {
+			if len(args) == 1 {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files could not be decrypted", failed, len(args))
+	}
+	return nil
+}
+
+// decryptFile decrypts source into dir, or next to source when dir is
+// empty, or to standard output
+func decryptFile(key []byte, source, dir string, toStdout bool) error {
+	// #nosec G304 -- the user names the files to decrypt
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	r, err := drop.OpenKey(in, key)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s: %w", source, err)
+	}
+	if toStdout {
+		if _, err := io.Copy(os.Stdout, r); err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w, the output is incomplete", source, err)
+		}
+		return nil
+	}
+
+	// The name is stored in the file, never let it point elsewhere
+	name := filesystem.LocalName(filepath.Base(filepath.Clean(r.Meta().Name)))
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		name = strings.TrimSuffix(filepath.Base(source), encryptedSuffix)
+	}
+	if dir == "" {
+		if dir, err = filepath.Abs(filepath.Dir(source)); err != nil {
+			return err
+		}
+	}
+	target := filepath.Join(dir, name)
+	if same, err := filepath.Abs(source); err == nil && same == target {
+		return fmt.Errorf("decrypting %s would replace it, use --output to save it elsewhere", source)
+	}
+	if err := confirmOverwrite(target); err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	partial := target + ".orb-partial"
+	// #nosec G304 -- the target is chosen by the user
+	file, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(io.MultiWriter(file, h), r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(partial)
+		return fmt.Errorf("failed to decrypt %s: %w", source, err)
+	}
+	if err := os.Rename(partial, target); err != nil {
+		return fmt.Errorf("failed to save %s: %w", name, err)
+	}
+
+	if jsonOutput {
+		return printJSON(event{Event: "decrypted", Path: target, Target: source, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))})
+	}
+	fmt.Printf("✓ Decrypted %s into %s\n", filepath.Base(source), target)
+	return nil
+}
@@ -0,0 +1,327 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/crypto"
+	"github.com/Zayan-Mohamed/orb/internal/tunnel"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+	"github.com/spf13/cobra"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check that this machine can reach a relay and open a tunnel",
+	Long: `Run the steps of a connection one by one and report which of them fails:
+reaching the relay over HTTP, the relay token, opening a WebSocket tunnel
+through it and the encrypted handshake. It also measures the round trip
+through the relay, compares the clock with the relay's and times the
+passcode key derivation. Every problem comes with a suggestion.
+
+The test creates a session of its own and revokes it when done. With several
+relays each of them is checked.`,
+	Args: cobra.NoArgs,
+	RunE: runDoctor,
+}
+
+const (
+	// doctorPings is the number of round trips measured through the tunnel
+	doctorPings = 5
+	// maxClockSkew is the difference to the relay's clock worth a warning
+	maxClockSkew = time.Minute
+	// slowKeyDerivation is the key derivation time worth a warning
+	slowKeyDerivation = 3 * time.Second
+	// doctorSessionTTL bounds the test session, should revoking it fail
+	doctorSessionTTL = 5 * time.Minute
+)
+
+// Results of a single check
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "fail"
+	checkSkip = "skip"
+)
+
+// check is the outcome of one diagnostic step
+type check struct {
+	Relay  string `json:"relay,omitempty"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+	Advice string `json:"advice,omitempty"`
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	addRelayFlags(doctorCmd)
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	var checks []check
+	report := func(c check) {
+		checks = append(checks, c)
+		if !jsonOutput {
+			printCheck(c)
+		}
+	}
+
+	keyTime, c := checkKeyDerivation()
+	report(c)
+
+	for _, relay := range relayURLs {
+		relayURL = relay
+		if !jsonOutput {
+			fmt.Printf("\nChecking relay %s\n", relayURL)
+		}
+		checkRelay(keyTime, func(c check) {
+			c.Relay = relay
+			report(c)
+		})
+	}
+
+	failed := 0
+	for _, c := range checks {
+		if c.Status == checkFail {
+			failed++
+		}
+	}
+
+	if jsonOutput {
+		if err := printJSON(checks); err != nil {
+			return err
+		}
+	} else if failed == 0 {
+		fmt.Printf("\nNo problems found.\n")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+// checkRelay runs the checks of relayURL, passing each to report
+func checkRelay(keyTime time.Duration, report func(check)) {
+	httpOK := true
+	for _, c := range checkRelayHTTP() {
+		report(c)
+		httpOK = httpOK && c.Status != checkFail
+	}
+
+	// Everything else needs a session on the relay
+	var sessionID, code string
+	if httpOK {
+		var c check
+		sessionID, code, c = checkSessionCreate()
+		report(c)
+	}
+	if sessionID == "" {
+		report(check{Name: "Tunnel", Status: checkSkip, Detail: "needs a session on the relay"})
+		report(check{Name: "Latency", Status: checkSkip, Detail: "needs a tunnel"})
+	} else {
+		for _, c := range checkTunnel(sessionID, code, keyTime) {
+			report(c)
+		}
+		if err := revokeSession(relayURL, sessionID, code); err != nil {
+			report(check{Name: "Revoke", Status: checkWarn, Detail: err.Error(),
+				Advice: "The relay expires the test session on its own; upgrade it to revoke sessions at once"})
+		}
+	}
+}
+
+// printCheck shows a check as a line with its advice below
+func printCheck(c check) {
+	mark := map[string]string{checkOK: "✓", checkWarn: "!", checkFail: "✗", checkSkip: "-"}[c.Status]
+	fmt.Printf("%s %-10s %s\n", mark, c.Name, c.Detail)
+	if c.Advice != "" {
+		fmt.Printf("  %-10s → %s\n", "", c.Advice)
+	}
+}
+
+// checkKeyDerivation times the Argon2id derivation every tunnel starts with
+func checkKeyDerivation() (time.Duration, check) {
+	start := time.Now()
+	crypto.DeriveKey("000-000", "DOCTOR")
+	elapsed := time.Since(start)
+
+	c := check{Name: "Argon2id", Status: checkOK,
+		Detail: fmt.Sprintf("passcode key derived in %s", elapsed.Round(time.Millisecond))}
+	if elapsed > slowKeyDerivation {
+		c.Status = checkWarn
+		c.Advice = fmt.Sprintf("This machine is slow at deriving keys (it needs %d MB of memory); connecting takes a few seconds longer, but works",
+			crypto.Argon2Memory/1024)
+	}
+	return elapsed, c
+}
+
+// checkRelayHTTP reaches the relay over plain HTTP(S) and compares its clock
+// with ours. Any HTTP response will do, the relay has no page of its own.
+func checkRelayHTTP() []check {
+	u, err := url.Parse(relayURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return []check{{Name: "HTTP", Status: checkFail, Detail: fmt.Sprintf("invalid relay URL %q", relayURL),
+			Advice: "Give the relay as http://host:port or https://host"}}
+	}
+
+	client := relayHTTPClient(10 * time.Second)
+	start := time.Now()
+	resp, err := client.Get(strings.TrimSuffix(relayURL, "/") + "/")
+	rtt := time.Since(start)
+	if err != nil {
+		return []check{{Name: "HTTP", Status: checkFail, Detail: err.Error(), Advice: httpAdvice(err)}}
+	}
+	_ = resp.Body.Close()
+
+	checks := []check{{Name: "HTTP", Status: checkOK,
+		Detail: fmt.Sprintf("relay answered in %s", rtt.Round(time.Millisecond))}}
+
+	// The Date header is only accurate to the second
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return append(checks, check{Name: "Clock", Status: checkSkip, Detail: "the relay sent no date"})
+	}
+	skew := date.Sub(start.Add(rtt / 2)).Round(time.Second)
+	clock := check{Name: "Clock", Status: checkOK, Detail: "within a second of the relay's"}
+	if skew.Abs() > time.Second {
+		clock.Detail = fmt.Sprintf("%s %s the relay's", skew.Abs(), map[bool]string{true: "behind", false: "ahead of"}[skew > 0])
+	}
+	if skew.Abs() > maxClockSkew {
+		clock.Status = checkWarn
+		clock.Advice = "Synchronize the clock, e.g. with timedatectl set-ntp true; TLS certificates and logs depend on it"
+	}
+	return append(checks, clock)
+}
+
+// httpAdvice suggests a fix for a failed HTTP request
+func httpAdvice(err error) string {
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	switch {
+	case errors.As(err, &certErr), errors.As(err, &unknownAuthority):
+		return "The relay's TLS certificate is not trusted; check that it is valid and that this machine's clock is right"
+	case strings.Contains(err.Error(), "proxyconnect"), strings.Contains(err.Error(), "socks connect"):
+		return "The proxy could not be reached or refused the connection; check --proxy"
+	case strings.Contains(err.Error(), "connection refused"):
+		return "Nothing listens at that address; check the host and port and that orb relay is running"
+	case strings.Contains(err.Error(), "no such host"):
+		return "The host name does not resolve; check it for typos and check DNS"
+	case strings.Contains(err.Error(), "server gave HTTP response to HTTPS client"):
+		return "The relay does not use TLS; use an http:// URL"
+	default:
+		return "Check the relay URL and your network; behind a proxy, pass it with --proxy"
+	}
+}
+
+// checkSessionCreate creates the session used for the tunnel test, which
+// also proves that the relay token is accepted
+func checkSessionCreate() (string, string, check) {
+	sessionID, code, err := createSession(relayURL, "doctor", doctorSessionTTL)
+	if err != nil {
+		c := check{Name: "Session", Status: checkFail, Detail: err.Error(),
+			Advice: "Check that the URL points to an orb relay and not to another web server"}
+		if strings.Contains(err.Error(), "unauthorized") {
+			c.Detail = "the relay requires a token"
+			c.Advice = "Pass the relay's token with --relay-token or set ORB_RELAY_TOKEN"
+			if relayToken != "" {
+				c.Detail = "the relay refused the token"
+				c.Advice = "Check the token with the relay's owner"
+			}
+		}
+		return "", "", c
+	}
+
+	detail := "created a test session"
+	if relayToken != "" {
+		detail += ", token accepted"
+	}
+	return sessionID, code, check{Name: "Session", Status: checkOK, Detail: detail}
+}
+
+// checkTunnel opens both ends of a tunnel through the relay, the way a share
+// and a receiver do, and measures round trips through it
+func checkTunnel(sessionID, code string, keyTime time.Duration) []check {
+	type result struct {
+		tun *tunnel.Tunnel
+		err error
+	}
+
+	// The responder plays the sharer and answers pings
+	responder := make(chan result, 1)
+	go func() {
+		tun, err := tunnel.NewTunnel(relayURL, sessionID, code, false, relayDialOptions()...)
+		responder <- result{tun, err}
+		if err != nil {
+			return
+		}
+		for {
+			frame, err := tun.ReceiveFrame()
+			if err != nil {
+				return
+			}
+			if frame.Type == protocol.FrameTypePing {
+				_ = tun.SendFrame(&protocol.Frame{Type: protocol.FrameTypePong, ID: frame.ID, Payload: []byte{}})
+			}
+		}
+	}()
+
+	start := time.Now()
+	tun, err := tunnel.NewTunnel(relayURL, sessionID, code, true, relayDialOptions()...)
+	elapsed := time.Since(start)
+	if err != nil {
+		if r := <-responder; r.tun != nil {
+			_ = r.tun.Close()
+		}
+		return []check{
+			{Name: "Tunnel", Status: checkFail, Detail: err.Error(), Advice: tunnelAdvice(err)},
+			{Name: "Latency", Status: checkSkip, Detail: "needs a tunnel"},
+		}
+	}
+	defer func() { _ = tun.Close() }()
+	if r := <-responder; r.tun != nil {
+		defer func() { _ = r.tun.Close() }()
+	}
+
+	checks := []check{{Name: "Tunnel", Status: checkOK,
+		Detail: fmt.Sprintf("WebSocket and handshake done in %s, %s of it key derivation",
+			elapsed.Round(time.Millisecond), keyTime.Round(time.Millisecond))}}
+
+	var lowest, highest, total time.Duration
+	for i := 0; i < doctorPings; i++ {
+		start := time.Now()
+		if err := tun.Ping(); err != nil {
+			return append(checks, check{Name: "Latency", Status: checkFail, Detail: err.Error(),
+				Advice: "The relay dropped the tunnel; check its logs and any proxy timeouts"})
+		}
+		rtt := time.Since(start)
+		total += rtt
+		if i == 0 || rtt < lowest {
+			lowest = rtt
+		}
+		highest = max(highest, rtt)
+	}
+
+	return append(checks, check{Name: "Latency", Status: checkOK,
+		Detail: fmt.Sprintf("round trip through the relay min %s, avg %s, max %s",
+			lowest.Round(100*time.Microsecond), (total / doctorPings).Round(100*time.Microsecond), highest.Round(100*time.Microsecond))})
+}
+
+// tunnelAdvice suggests a fix for a tunnel that could not be opened
+func tunnelAdvice(err error) string {
+	switch {
+	case strings.Contains(err.Error(), "token"):
+		return "Pass the relay's token with --relay-token or set ORB_RELAY_TOKEN"
+	case strings.Contains(err.Error(), "bad handshake"):
+		return "A proxy in front of the relay may not pass WebSocket upgrades; it has to forward the Upgrade and Connection headers"
+	case strings.Contains(err.Error(), "handshake failed"):
+		return "The relay did not pair the two ends; check that it runs a recent orb relay"
+	default:
+		return "A firewall or proxy may block WebSocket connections to the relay"
+	}
+}
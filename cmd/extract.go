@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/Zayan-Mohamed/orb/internal/archive"
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// extractDownload is --extract
+	extractDownload bool
+	// extractTo is --extract-to
+	extractTo string
+)
+
+// addExtractFlags registers --extract and --extract-to on cmd
+func addExtractFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&extractDownload, "extract", "x", false, "Extract a tar or zip archive next to it once verified")
+	cmd.Flags().StringVar(&extractTo, "extract-to", "", "Extract a tar or zip archive into this directory once verified")
+}
+
+// wantsExtract reports whether --extract or --extract-to was given
+func wantsExtract() bool {
+	return extractDownload || extractTo != ""
+}
+
+// checkExtract rejects extraction where there is no file to extract
+func checkExtract(toStdout bool) error {
+	if wantsExtract() && toStdout {
+		return errors.New("--extract needs a file to extract, it cannot be combined with --output -")
+	}
+	return nil
+}
+
+// extractArchive unpacks a verified download into --extract-to, or a
+// directory next to it named after it. The archive is kept.
+func extractArchive(ctx context.Context, name string) error {
+	dest := archive.DefaultDir(name)
+	if extractTo != "" {
+		expanded, err := config.ExpandHome(extractTo)
+		if err != nil {
+			return err
+		}
+		if dest, err = filepath.Abs(expanded); err != nil {
+			return fmt.Errorf("invalid --extract-to: %w", err)
+		}
+	}
+
+	result, err := archive.Extract(ctx, name, dest)
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w, the archive was kept", filepath.Base(name), err)
+	}
+
+	if jsonOutput {
+		return printJSON(event{Event: "extracted", Path: dest, Files: result.Files, Size: result.Bytes})
+	}
+	fmt.Printf("✓ Extracted %d files (%s) into %s\n", result.Files, formatBytes(result.Bytes), dest)
+	if result.Skipped > 0 {
+		fmt.Printf("  Skipped %d devices, pipes and other special entries\n", result.Skipped)
+	}
+	return nil
+}
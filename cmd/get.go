@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"github.com/Zayan-Mohamed/orb/internal/filesystem"
+	"github.com/Zayan-Mohamed/orb/internal/manifest"
+	"github.com/spf13/cobra"
+)
+
+var getCmd = &cobra.Command{
+	Use:   "get <session-id> <path>",
+	Short: "Download one file from a shared session",
+	Long: `Download a single file from a share without opening the file browser. The
+file is saved under its own name in the current directory unless --output
+names a file or directory, and is checked against the sharer's SHA-256
+checksum before it replaces anything. With --output - it is written to
+standard output, e.g.
+
+  orb get 7F9Q2A backup.tar.gz -o - | tar xz
+
+With --encrypt the file is saved encrypted with this machine's key, as
+<name>.orbenc, and never written in plaintext. Open it with orb decrypt.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runGet,
+}
+
+// getOutput is where orb get saves the file, "-" for standard output
+var getOutput string
+
+func init() {
+	rootCmd.AddCommand(getCmd)
+	addRelayFlags(getCmd)
+	addPasscodeFlags(getCmd)
+	getCmd.Flags().StringVarP(&getOutput, "output", "o", "", "File or directory to save to, or - for standard output (default ~/Downloads/orb)")
+	getCmd.Flags().BoolVarP(&receiveYes, "yes", "y", false, "Overwrite an existing file without asking")
+	getCmd.Flags().IntVar(&parallel, "parallel", 1, "Number of chunks to fetch at the same time")
+	getCmd.Flags().BoolVar(&resumeDownload, "resume", false, "Continue a download that was interrupted earlier")
+	addExtractFlags(getCmd)
+	addEncryptFlags(getCmd)
+	addManifestFlags(getCmd)
+	addProgressFlag(getCmd)
+}
+
+func runGet(cmd *cobra.Command, args []string) error {
+	remotePath := path.Join("/", args[1])
+	if remotePath == "/" {
+		return errors.New("give the path of a file to download")
+	}
+	if err := checkParallel(); err != nil {
+		return err
+	}
+	toStdout := getOutput == "-"
+	if toStdout && jsonOutput {
+		return errors.New("--output - cannot be combined with --json")
+	}
+	if toStdout && resumeDownload {
+		return errors.New("--resume needs a file to continue, it cannot be combined with --output -")
+	}
+	if err := checkExtract(toStdout); err != nil {
+		return err
+	}
+	if manifestFile != "" && toStdout {
+		return errors.New("--manifest checks the saved file, it cannot be combined with --output -")
+	}
+	if err := checkEncrypt(cmd, toStdout); err != nil {
+		return err
+	}
+
+	tun, client, err := dialSession(args[0])
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tun.Close(); err != nil {
+			slog.Warn("failed to close tunnel", "err", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	info, err := client.Info(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read session details: %w", err)
+	}
+	var signed *manifest.Manifest
+	if manifestFile != "" {
+		if signed, err = fetchManifest(ctx, client, info, args[0]); err != nil {
+			return err
+		}
+	}
+
+	// The size of a stream is unknown until it has been read
+	size := int64(-1)
+	if !info.Stream {
+		stat, err := client.Stat(ctx, remotePath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", remotePath, err)
+		}
+		if stat.IsDir {
+			return fmt.Errorf("%s is a directory, use orb sync to download directories", remotePath)
+		}
+		size = stat.Size
+	}
+
+	if toStdout {
+		return receiveToStdout(ctx, client, remotePath, size)
+	}
+
+	target, err := getTarget(getOutput, path.Base(remotePath))
+	if err != nil {
+		return err
+	}
+	if err := receiveToFile(ctx, client, remotePath, target, size, transferObserver(args[0])); err != nil {
+		return err
+	}
+	if signed != nil {
+		if err := checkAgainstManifest(signed, remotePath, target); err != nil {
+			return err
+		}
+	}
+	if wantsExtract() {
+		return extractArchive(ctx, target)
+	}
+	return nil
+}
+
+// getTarget resolves --output to the local file to save name to. An existing
+// directory, or a path ending in a separator, receives the file under name,
+// as this machine can store it. Without --output that is the download
+// directory of connect and receive.
+func getTarget(output, name string) (string, error) {
+	name = filesystem.LocalName(name)
+	if output == "" {
+		output = defaultDownloadDir() + string(filepath.Separator)
+	}
+	if strings.HasSuffix(output, "/") || strings.HasSuffix(output, string(filepath.Separator)) {
+		dir, err := resolveDownloadDir(output)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, name), nil
+	}
+
+	output, err := config.ExpandHome(output)
+	if err != nil {
+		return "", err
+	}
+	target, err := filepath.Abs(output)
+	if err != nil {
+		return "", fmt.Errorf("invalid output path: %w", err)
+	}
+	if info, err := os.Stat(target); err == nil && info.IsDir() {
+		return filepath.Join(target, name), nil
+	}
+	return target, nil
+}
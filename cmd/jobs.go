@@ -0,0 +1,435 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"github.com/Zayan-Mohamed/orb/internal/daemon"
+	"github.com/Zayan-Mohamed/orb/internal/jobs"
+	"github.com/Zayan-Mohamed/orb/internal/mirror"
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "Sync with a session on a schedule",
+	Long: `Jobs are syncs that orb daemon runs on a cron schedule, so that recurring
+pulls and pushes over a long-lived session need no cron entries or scripts.
+They are kept in jobs.yaml in the configuration directory, with the passcodes
+of their sessions. The daemon stays connected to a session between runs,
+since a session ends when its receiver leaves. Without a subcommand the jobs
+are listed.`,
+	Args: cobra.NoArgs,
+	RunE: runJobsList,
+}
+
+var jobsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the jobs with their next and last run",
+	Args:  cobra.NoArgs,
+	RunE:  runJobsList,
+}
+
+var jobsAddCmd = &cobra.Command{
+	Use:   "add \"<schedule> sync <session-id> [remote-path] <local-dir> [flags]\"",
+	Short: "Add a job",
+	Long: `Add a sync to run on a schedule. The schedule is a cron expression of five
+fields, minute hour day month weekday, or @hourly, @daily, @weekly, @monthly
+or @yearly. The command takes the arguments and the --push, --delete,
+--checksum, --whole-file and --verify flags of orb sync; the remote path
+defaults to the root of the share. Quote the whole job, e.g.
+
+  orb jobs add "0 2 * * * sync 7F9Q2A /backups" --passcode-file ~/.orb-pass
+  orb jobs add "*/30 * * * * sync 7F9Q2A /reports ~/reports --delete"
+
+The passcode is asked for unless given.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runJobsAdd,
+}
+
+var jobsRmCmd = &cobra.Command{
+	Use:   "rm <id>",
+	Short: "Remove a job",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runJobsRm,
+}
+
+var jobsRunCmd = &cobra.Command{
+	Use:   "run <id>",
+	Short: "Run a job now, in the foreground",
+	Long: `Run a job once in this process and print what it copies, e.g. to try it
+out. A session takes one receiver, so this fails while orb daemon is
+connected to it.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runJobsRun,
+}
+
+// jobsTick is how often orb daemon checks whether a job is due
+const jobsTick = 15 * time.Second
+
+func init() {
+	rootCmd.AddCommand(jobsCmd)
+	jobsCmd.AddCommand(jobsListCmd, jobsAddCmd, jobsRmCmd, jobsRunCmd)
+	addPasscodeFlags(jobsAddCmd)
+	addRelayFlags(jobsRunCmd)
+}
+
+// syncJob is the sync a job runs
+type syncJob struct {
+	sessionID string
+	remote    string
+	local     string
+	push      bool
+	delete    bool
+	checksum  bool
+	whole     bool
+	verify    bool
+}
+
+// parseJobCommand parses the orb command of a job
+func parseJobCommand(args []string) (syncJob, error) {
+	if len(args) == 0 || args[0] != "sync" {
+		return syncJob{}, errors.New("jobs run orb sync, start the command with sync")
+	}
+
+	var s syncJob
+	flags := pflag.NewFlagSet("sync", pflag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.BoolVar(&s.push, "push", false, "")
+	flags.BoolVar(&s.delete, "delete", false, "")
+	flags.BoolVarP(&s.checksum, "checksum", "c", false, "")
+	flags.BoolVarP(&s.whole, "whole-file", "W", false, "")
+	flags.BoolVar(&s.verify, "verify", false, "")
+	if err := flags.Parse(args[1:]); err != nil {
+		return syncJob{}, fmt.Errorf("sync: %w", err)
+	}
+
+	rest := flags.Args()
+	switch len(rest) {
+	case 2:
+		s.sessionID, s.remote, s.local = rest[0], "/", rest[1]
+	case 3:
+		s.sessionID, s.remote, s.local = rest[0], rest[1], rest[2]
+	default:
+		return syncJob{}, errors.New("sync needs a session ID, an optional remote path and a local directory")
+	}
+
+	local, err := config.ExpandHome(s.local)
+	if err != nil {
+		return syncJob{}, err
+	}
+	if s.local, err = filepath.Abs(local); err != nil {
+		return syncJob{}, fmt.Errorf("invalid local directory: %w", err)
+	}
+	return s, nil
+}
+
+// args returns the command of the job as it is stored
+func (s syncJob) args() []string {
+	args := []string{"sync", s.sessionID, s.remote, s.local}
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{{s.push, "--push"}, {s.delete, "--delete"}, {s.checksum, "--checksum"}, {s.whole, "--whole-file"}, {s.verify, "--verify"}} {
+		if f.set {
+			args = append(args, f.name)
+		}
+	}
+	return args
+}
+
+// mirror prepares the sync over client
+func (s syncJob) mirror(client *remote.Client) *mirror.Mirror {
+	direction := mirror.Pull
+	if s.push {
+		direction = mirror.Push
+	}
+	return mirror.New(client, direction, s.remote, s.local, mirror.Options{
+		Delete:    s.delete,
+		Checksum:  s.checksum,
+		Verify:    s.verify,
+		WholeFile: s.whole,
+		Observer:  transferObserver(s.sessionID),
+	})
+}
+
+// splitJob separates the schedule of a job from its command. The job is
+// one quoted argument, or the fields of one.
+func splitJob(args []string) (string, []string, error) {
+	fields := args
+	if len(args) == 1 {
+		fields = strings.Fields(args[0])
+	}
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		return fields[0], fields[1:], nil
+	}
+	if len(fields) < 6 {
+		return "", nil, errors.New(`expected a schedule of five fields and a command, e.g. "0 2 * * * sync SESSION /backups"`)
+	}
+	return strings.Join(fields[:5], " "), fields[5:], nil
+}
+
+func runJobsAdd(cmd *cobra.Command, args []string) error {
+	schedule, command, err := splitJob(args)
+	if err != nil {
+		return err
+	}
+	sched, err := jobs.ParseSchedule(schedule)
+	if err != nil {
+		return err
+	}
+	s, err := parseJobCommand(command)
+	if err != nil {
+		return err
+	}
+	if err := resolvePasscode(); err != nil {
+		return err
+	}
+
+	store, err := jobs.Open()
+	if err != nil {
+		return err
+	}
+	job, err := store.Add(jobs.Job{Schedule: schedule, Args: s.args(), Passcode: passcode})
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		return printJSON(event{Event: "job_added", Job: job.ID, Text: strings.Join(job.Args, " ")})
+	}
+	fmt.Printf("✓ Added job %s: orb %s\n", job.ID, strings.Join(job.Args, " "))
+	fmt.Printf("  Next run %s, while orb daemon is running\n", formatNextRun(sched.Next(time.Now())))
+	return nil
+}
+
+func runJobsRm(cmd *cobra.Command, args []string) error {
+	store, err := jobs.Open()
+	if err != nil {
+		return err
+	}
+	if err := store.Remove(args[0]); err != nil {
+		return fmt.Errorf("%w: %s", err, args[0])
+	}
+	if jsonOutput {
+		return printJSON(event{Event: "job_removed", Job: args[0]})
+	}
+	fmt.Printf("✓ Removed job %s\n", args[0])
+	return nil
+}
+
+func runJobsList(cmd *cobra.Command, args []string) error {
+	store, err := jobs.Open()
+	if err != nil {
+		return err
+	}
+	list, err := store.Jobs()
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		if list == nil {
+			list = []jobs.Job{}
+		}
+		return printJSON(list)
+	}
+
+	if len(list) == 0 {
+		fmt.Println("No jobs. Add one with orb jobs add.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSCHEDULE\tNEXT RUN\tLAST RUN\tCOMMAND")
+	for _, j := range list {
+		next := "invalid schedule"
+		if sched, err := jobs.ParseSchedule(j.Schedule); err == nil {
+			next = formatNextRun(sched.Next(time.Now()))
+		}
+		last := "never"
+		switch {
+		case j.LastRun.IsZero():
+		case j.LastError != "":
+			last = fmt.Sprintf("%s ago, failed: %s", time.Since(j.LastRun).Round(time.Second), j.LastError)
+		default:
+			last = fmt.Sprintf("%s ago, %d changes", time.Since(j.LastRun).Round(time.Second), j.LastChanges)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\torb %s\n", j.ID, j.Schedule, next, last, strings.Join(j.Args, " "))
+	}
+	return w.Flush()
+}
+
+func runJobsRun(cmd *cobra.Command, args []string) error {
+	store, err := jobs.Open()
+	if err != nil {
+		return err
+	}
+	job, err := store.Find(args[0])
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, args[0])
+	}
+	s, err := parseJobCommand(job.Args)
+	if err != nil {
+		return err
+	}
+
+	passcode = job.Passcode
+	tun, client, err := dialSession(s.sessionID)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tun.Close() }()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	started := time.Now()
+	changes, err := syncOnce(ctx, s.mirror(client), s.local, false)
+	if recordErr := store.Ran(job.ID, started, changes, err); recordErr != nil {
+		slog.Warn("failed to record the run", "job", job.ID, "err", recordErr)
+	}
+	if err != nil {
+		return err
+	}
+	if changes == 0 {
+		if jsonOutput {
+			return printJSON(event{Event: "synced", Job: job.ID})
+		}
+		fmt.Println("Already up to date.")
+	}
+	return nil
+}
+
+// formatNextRun describes when a job runs next
+func formatNextRun(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format("Mon 2 Jan 15:04")
+}
+
+// jobRunner runs the jobs of orb daemon when they are due, on the sessions
+// of the daemon's backend
+type jobRunner struct {
+	backend *daemonBackend
+	store   *jobs.Store
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+// runJobs checks for due jobs until ctx ends. A run that is still going when
+// its job is due again is not started twice.
+func (b *daemonBackend) runJobs(ctx context.Context) {
+	store, err := jobs.Open()
+	if err != nil {
+		slog.Warn("not running jobs", "err", err)
+		return
+	}
+	r := &jobRunner{backend: b, store: store, running: make(map[string]bool)}
+
+	next := make(map[string]time.Time)
+	ticker := time.NewTicker(jobsTick)
+	defer ticker.Stop()
+	for {
+		list, err := store.Jobs()
+		if err != nil {
+			slog.Warn("failed to read jobs", "err", err)
+		}
+		now := time.Now()
+		seen := make(map[string]bool, len(list))
+		for _, j := range list {
+			seen[j.ID] = true
+			sched, err := jobs.ParseSchedule(j.Schedule)
+			if err != nil {
+				continue
+			}
+			due, ok := next[j.ID]
+			if !ok {
+				next[j.ID] = sched.Next(now)
+				continue
+			}
+			if due.IsZero() || now.Before(due) {
+				continue
+			}
+			next[j.ID] = sched.Next(now)
+			r.start(ctx, j)
+		}
+		for id := range next {
+			if !seen[id] {
+				delete(next, id)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// start runs a job in the background unless it is still running
+func (r *jobRunner) start(ctx context.Context, j jobs.Job) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running[j.ID] {
+		slog.Warn("job is still running, skipping this run", "job", j.ID)
+		return
+	}
+	r.running[j.ID] = true
+
+	go func() {
+		defer func() {
+			r.mu.Lock()
+			delete(r.running, j.ID)
+			r.mu.Unlock()
+		}()
+
+		started := time.Now()
+		slog.Info("job started", "job", j.ID, "command", strings.Join(j.Args, " "))
+		changes, err := r.run(ctx, j)
+		if err != nil {
+			slog.Warn("job failed", "job", j.ID, "err", err)
+		} else {
+			slog.Info("job finished", "job", j.ID, "changes", changes, "took", time.Since(started).Round(time.Second))
+		}
+		if err := r.store.Ran(j.ID, started, changes, err); err != nil {
+			slog.Warn("failed to record the run", "job", j.ID, "err", err)
+		}
+	}()
+}
+
+// run carries out one sync of a job and returns the number of changes made
+func (r *jobRunner) run(ctx context.Context, j jobs.Job) (int, error) {
+	s, err := parseJobCommand(j.Args)
+	if err != nil {
+		return 0, err
+	}
+	session, err := r.backend.session(daemon.TransferRequest{SessionID: s.sessionID, Passcode: j.Passcode})
+	if err != nil {
+		return 0, err
+	}
+
+	m := s.mirror(session.client)
+	actions, err := m.Plan(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return len(actions), m.Apply(ctx, actions, transfer.DefaultConcurrency, nil)
+}
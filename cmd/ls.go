@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+	"github.com/spf13/cobra"
+)
+
+var lsCmd = &cobra.Command{
+	Use:   "ls <session-id> [path]",
+	Short: "List files in a shared session",
+	Long: `List a directory of a shared folder without opening the file browser.
+Paths are relative to the shared folder; the default is its root.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runLs,
+}
+
+var (
+	lsLong      bool
+	lsRecursive bool
+)
+
+func init() {
+	rootCmd.AddCommand(lsCmd)
+	addRelayFlags(lsCmd)
+	addPasscodeFlags(lsCmd)
+	lsCmd.Flags().BoolVarP(&lsLong, "long", "l", false, "Show mode, owner, size and modification time")
+	lsCmd.Flags().BoolVarP(&lsRecursive, "recursive", "R", false, "List subdirectories recursively")
+}
+
+// lsEntry is a listed file as printed by --json
+type lsEntry struct {
+	Path    string    `json:"path"`
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"mod_time"`
+	IsDir   bool      `json:"is_dir"`
+	Owner   string    `json:"owner,omitempty"`
+}
+
+func runLs(cmd *cobra.Command, args []string) error {
+	dir := "/"
+	if len(args) == 2 {
+		dir = path.Join("/", args[1])
+	}
+
+	tun, client, err := dialSession(args[0])
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tun.Close(); err != nil {
+			slog.Warn("failed to close tunnel", "err", err)
+		}
+	}()
+
+	entries, err := listEntries(context.Background(), client, dir, "", lsRecursive)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, e := range entries {
+		name := e.Path
+		if e.IsDir {
+			name += "/"
+		}
+		if !lsLong {
+			fmt.Fprintln(w, name)
+			continue
+		}
+
+		owner := e.Owner
+		if owner == "" {
+			owner = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", e.Mode, owner, e.Size, e.ModTime.Format("2006-01-02 15:04"), name)
+	}
+	return w.Flush()
+}
+
+// listEntries lists dir on the sharer. Entry paths are relative to the listed
+// directory, prefixed with prefix; recursive descends into subdirectories.
+func listEntries(ctx context.Context, client *remote.Client, dir, prefix string, recursive bool) ([]lsEntry, error) {
+	files, err := client.List(ctx, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+
+	entries := make([]lsEntry, 0, len(files))
+	for _, f := range files {
+		entries = append(entries, newLsEntry(f, path.Join(prefix, f.Name)))
+
+		if recursive && f.IsDir {
+			children, err := listEntries(ctx, client, path.Join(dir, f.Name), path.Join(prefix, f.Name), true)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, children...)
+		}
+	}
+	return entries, nil
+}
+
+func newLsEntry(f protocol.FileInfo, p string) lsEntry {
+	return lsEntry{
+		Path:    p,
+		Name:    f.Name,
+		Size:    f.Size,
+		Mode:    os.FileMode(f.Mode).String(),
+		ModTime: time.Unix(f.ModTime, 0),
+		IsDir:   f.IsDir,
+		Owner:   f.Owner,
+	}
+}
@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"github.com/Zayan-Mohamed/orb/internal/manifest"
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+	"github.com/spf13/cobra"
+)
+
+var manifestCmd = &cobra.Command{
+	Use:   "manifest",
+	Short: "Inspect and check signed manifests of shares",
+	Long: `A share started with --manifest offers a list of its files with their
+sizes, modification times and SHA-256 checksums, signed with the identity
+key of the sharer. orb get and orb sync save it with --manifest FILE and
+check what they downloaded against it. The saved manifest proves later,
+without the sharer, which files were received and that they are unchanged.
+
+The identity key is created on first use in identity.key next to
+config.yaml. Its fingerprint is shown when sharing, and receivers can insist
+on it with --sharer-key.`,
+}
+
+var manifestShowCmd = &cobra.Command{
+	Use:   "show <file>",
+	Short: "Verify a saved manifest and list its files",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runManifestShow,
+}
+
+var manifestCheckCmd = &cobra.Command{
+	Use:   "check <file> <local-path>",
+	Short: "Check local files against a saved manifest",
+	Long: `Verify the signature of a saved manifest, then compare local files with
+the checksums it lists. The local path is the copy of the share's root, or
+of the file or directory given with --path, e.g.
+
+  orb manifest check photos.manifest ~/photos --path /2024
+
+Files the manifest lists but that are missing locally count as mismatches,
+local files it does not list are ignored.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runManifestCheck,
+}
+
+var manifestKeyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "Print the fingerprint of this machine's identity key",
+	Args:  cobra.NoArgs,
+	RunE:  runManifestKey,
+}
+
+var (
+	// manifestFile is --manifest of get and sync, manifestSharerKey
+	// --sharer-key
+	manifestFile      string
+	manifestSharerKey string
+
+	// manifestPath is --path of manifest check
+	manifestPath string
+)
+
+func init() {
+	rootCmd.AddCommand(manifestCmd)
+	manifestCmd.AddCommand(manifestShowCmd, manifestCheckCmd, manifestKeyCmd)
+	manifestCheckCmd.Flags().StringVar(&manifestPath, "path", "/", "File or directory of the share the local path is a copy of")
+	manifestCheckCmd.Flags().StringVar(&manifestSharerKey, "sharer-key", "", "Only accept a manifest signed by the key with this fingerprint")
+	manifestShowCmd.Flags().StringVar(&manifestSharerKey, "sharer-key", "", "Only accept a manifest signed by the key with this fingerprint")
+}
+
+// addManifestFlags adds --manifest and --sharer-key to a command that
+// downloads
+func addManifestFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&manifestFile, "manifest", "", "Save the sharer's signed manifest to this file and check the download against it")
+	cmd.Flags().StringVar(&manifestSharerKey, "sharer-key", "", "Only accept a manifest signed by the key with this fingerprint")
+}
+
+// manifestSummary is the output of manifest show --json
+type manifestSummary struct {
+	SessionID string           `json:"session_id"`
+	Created   time.Time        `json:"created"`
+	Identity  string           `json:"identity"`
+	Files     []manifest.Entry `json:"files"`
+}
+
+func runManifestShow(cmd *cobra.Command, args []string) error {
+	m, pub, err := readManifest(args[0])
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		files := m.Files
+		if files == nil {
+			files = []manifest.Entry{}
+		}
+		return printJSON(manifestSummary{SessionID: m.SessionID, Created: m.Created, Identity: manifest.Fingerprint(pub), Files: files})
+	}
+
+	var total int64
+	for _, e := range m.Files {
+		total += e.Size
+	}
+	fmt.Printf("Session:   %s\n", m.SessionID)
+	fmt.Printf("Created:   %s\n", m.Created.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("Signed by: %s\n", manifest.Fingerprint(pub))
+	fmt.Printf("Files:     %d, %s\n", len(m.Files), formatBytes(total))
+	if len(m.Files) == 0 {
+		return nil
+	}
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SIZE\tMODIFIED\tSHA256\tPATH")
+	for _, e := range m.Files {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", formatBytes(e.Size), e.ModTime.Local().Format("2006-01-02 15:04"), e.SHA256[:min(16, len(e.SHA256))], e.Path)
+	}
+	return w.Flush()
+}
+
+func runManifestCheck(cmd *cobra.Command, args []string) error {
+	m, _, err := readManifest(args[0])
+	if err != nil {
+		return err
+	}
+	local, err := config.ExpandHome(args[1])
+	if err != nil {
+		return err
+	}
+	if local, err = filepath.Abs(local); err != nil {
+		return fmt.Errorf("invalid local path: %w", err)
+	}
+	return checkAgainstManifest(m, manifestPath, local)
+}
+
+func runManifestKey(cmd *cobra.Command, args []string) error {
+	key, err := manifest.LoadIdentity()
+	if err != nil {
+		return err
+	}
+	fingerprint := manifest.Fingerprint(key.Public().(ed25519.PublicKey))
+	if jsonOutput {
+		keyPath, _ := manifest.IdentityPath()
+		return printJSON(event{Event: "identity", Identity: fingerprint, Path: keyPath})
+	}
+	fmt.Println(fingerprint)
+	return nil
+}
+
+// readManifest loads a saved manifest and verifies its signature, and its
+// signer when --sharer-key is given
+func readManifest(file string) (*manifest.Manifest, ed25519.PublicKey, error) {
+	file, err := config.ExpandHome(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	// #nosec G304 -- the user names the manifest to read
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	m, pub, err := manifest.Open(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkSharerKey(pub); err != nil {
+		return nil, nil, err
+	}
+	return m, pub, nil
+}
+
+// checkSharerKey refuses a manifest signed by another key than --sharer-key
+func checkSharerKey(pub ed25519.PublicKey) error {
+	if manifestSharerKey == "" {
+		return nil
+	}
+	want := strings.TrimPrefix(strings.TrimSpace(manifestSharerKey), "SHA256:")
+	got := manifest.Fingerprint(pub)
+	if strings.TrimPrefix(got, "SHA256:") != want {
+		return fmt.Errorf("the manifest is signed by %s, not by the expected key %s", got, manifestSharerKey)
+	}
+	return nil
+}
+
+// fetchManifest downloads the signed manifest of a session for --manifest,
+// checks that it was signed for this session, and saves it before anything
+// is downloaded
+func fetchManifest(ctx context.Context, client *remote.Client, info *protocol.InfoResponse, sessionID string) (*manifest.Manifest, error) {
+	if !info.Manifest {
+		return nil, errors.New("this share has no manifest, the sharer has to start it with --manifest")
+	}
+	data, err := client.Manifest(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the manifest: %w", err)
+	}
+	m, pub, err := manifest.Open(data)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(m.SessionID, sessionID) {
+		return nil, fmt.Errorf("the manifest was made for session %s, not %s", m.SessionID, sessionID)
+	}
+	if err := checkSharerKey(pub); err != nil {
+		return nil, err
+	}
+
+	file, err := config.ExpandHome(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to save the manifest: %w", err)
+	}
+	if !jsonOutput {
+		fmt.Fprintf(os.Stderr, "Manifest of %d files signed by %s saved to %s\n", len(m.Files), manifest.Fingerprint(pub), file)
+	}
+	return m, nil
+}
+
+// checkAgainstManifest compares the local copy of remote with the manifest
+// and fails when any file differs
+func checkAgainstManifest(m *manifest.Manifest, remote, local string) error {
+	remote = path.Join("/", remote)
+	checked, problems, err := manifest.Check(m, remote, local)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		for _, p := range problems {
+			if err := printJSON(event{Event: "mismatch", Path: p.Path, Target: p.Local, Error: p.Reason}); err != nil {
+				return err
+			}
+		}
+		if len(problems) == 0 {
+			return printJSON(event{Event: "verified", Path: remote, Files: checked})
+		}
+	} else {
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "✗ %s: %s\n", p.Local, p.Reason)
+		}
+		switch {
+		case len(problems) > 0:
+		case checked == 1:
+			fmt.Fprintf(os.Stderr, "✓ Matches the manifest\n")
+		default:
+			fmt.Fprintf(os.Stderr, "✓ All %d files match the manifest\n", checked)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d of %d files do not match the manifest", len(problems), checked)
+	}
+	return nil
+}
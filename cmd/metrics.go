@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/metrics"
+	"github.com/spf13/cobra"
+)
+
+// metricsAddr is where --metrics-addr serves Prometheus metrics
+var metricsAddr string
+
+// addMetricsFlag adds --metrics-addr to a command that keeps running
+func addMetricsFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics at /metrics on this address, e.g. localhost:9464")
+}
+
+// serveMetrics serves /metrics on --metrics-addr, if given, until the
+// returned function is called
+func serveMetrics() (func(), error) {
+	if metricsAddr == "" {
+		return func() {}, nil
+	}
+
+	listener, err := net.Listen("tcp", metricsAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serve metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() { _ = server.Serve(listener) }()
+
+	slog.Info("serving metrics", "addr", listener.Addr().String())
+	return func() { _ = server.Close() }, nil
+}
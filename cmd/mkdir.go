@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path"
+
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/spf13/cobra"
+)
+
+var mkdirCmd = &cobra.Command{
+	Use:   "mkdir <session-id> <path>...",
+	Short: "Create directories in a writable share",
+	Long: `Create directories in a shared folder the sharer made writable. The parent
+directory must exist unless --parents is given. Paths are relative to the
+shared folder.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: runMkdir,
+}
+
+// mkdirParents is --parents
+var mkdirParents bool
+
+// remoteDirPerm is the mode of directories created by orb mkdir
+const remoteDirPerm = 0755
+
+func init() {
+	rootCmd.AddCommand(mkdirCmd)
+	addRelayFlags(mkdirCmd)
+	addPasscodeFlags(mkdirCmd)
+	mkdirCmd.Flags().BoolVarP(&mkdirParents, "parents", "P", false, "Create missing parent directories too, and accept existing directories")
+}
+
+func runMkdir(cmd *cobra.Command, args []string) error {
+	tun, client, err := dialSession(args[0])
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tun.Close(); err != nil {
+			slog.Warn("failed to close tunnel", "err", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	for _, arg := range args[1:] {
+		dir := path.Join("/", arg)
+		if err := makeRemoteDir(ctx, client, dir, mkdirParents); err != nil {
+			return err
+		}
+		if jsonOutput {
+			if err := printJSON(event{Event: "created", Path: dir}); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Printf("Created %s\n", dir)
+	}
+	return nil
+}
+
+// makeRemoteDir creates dir on the sharer. With parents, missing parent
+// directories are created first and an existing directory is no error.
+func makeRemoteDir(ctx context.Context, client *remote.Client, dir string, parents bool) error {
+	if info, err := client.Stat(ctx, dir); err == nil {
+		if !info.IsDir {
+			return fmt.Errorf("%s exists and is not a directory", dir)
+		}
+		if parents {
+			return nil
+		}
+		return fmt.Errorf("%s already exists", dir)
+	}
+
+	if parent := path.Dir(dir); parent != "/" {
+		info, err := client.Stat(ctx, parent)
+		switch {
+		case err != nil && parents:
+			if err := makeRemoteDir(ctx, client, parent, true); err != nil {
+				return err
+			}
+		case err != nil:
+			return fmt.Errorf("%s does not exist, use --parents to create it", parent)
+		case !info.IsDir:
+			return fmt.Errorf("%s is not a directory", parent)
+		}
+	}
+
+	if err := client.Mkdir(ctx, dir, remoteDirPerm); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	return nil
+}
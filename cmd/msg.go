@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Zayan-Mohamed/orb/internal/daemon"
+	"github.com/spf13/cobra"
+)
+
+var msgCmd = &cobra.Command{
+	Use:   "msg <session-id> [text...]",
+	Short: "Chat with the receiver of a running share",
+	Long: `Send a message to the receiver connected to a share running on this
+machine, in the foreground or with --daemon. The receiver sees it in the file
+browser and can answer from there.
+
+Without text, the chat so far is shown.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runMsg,
+}
+
+func init() {
+	rootCmd.AddCommand(msgCmd)
+}
+
+func runMsg(cmd *cobra.Command, args []string) error {
+	sessionID := args[0]
+
+	if len(args) == 1 {
+		messages, err := daemon.Messages(sessionID)
+		if err != nil {
+			return fmt.Errorf("%s: %w", sessionID, err)
+		}
+		if jsonOutput {
+			return printJSON(messages)
+		}
+		if len(messages) == 0 {
+			fmt.Println("No messages yet.")
+			return nil
+		}
+		for _, m := range messages {
+			who := "You"
+			if m.From == "receiver" {
+				who = "Receiver"
+			}
+			fmt.Printf("%s  %s: %s\n", m.Time.Format("15:04:05"), who, m.Text)
+		}
+		return nil
+	}
+
+	text := strings.Join(args[1:], " ")
+	if err := daemon.SendMessage(sessionID, text); err != nil {
+		if errors.Is(err, daemon.ErrNotFound) {
+			return fmt.Errorf("%s: %w, see orb sessions", sessionID, err)
+		}
+		return fmt.Errorf("%s: %w", sessionID, err)
+	}
+
+	if jsonOutput {
+		return printJSON(event{Event: "sent", SessionID: sessionID, Text: text})
+	}
+	fmt.Println("✓ Sent")
+	return nil
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path"
+
+	"github.com/spf13/cobra"
+)
+
+var mvCmd = &cobra.Command{
+	Use:   "mv <session-id> <source> <destination>",
+	Short: "Move or rename a file in a writable share",
+	Long: `Move or rename a file or directory of a shared folder the sharer made
+writable. When the destination is an existing directory, the source is moved
+into it. Paths are relative to the shared folder.`,
+	Args: cobra.ExactArgs(3),
+	RunE: runMv,
+}
+
+func init() {
+	rootCmd.AddCommand(mvCmd)
+	addRelayFlags(mvCmd)
+	addPasscodeFlags(mvCmd)
+}
+
+func runMv(cmd *cobra.Command, args []string) error {
+	source, target := path.Join("/", args[1]), path.Join("/", args[2])
+	if source == "/" {
+		return errors.New("the root of a share cannot be moved")
+	}
+
+	tun, client, err := dialSession(args[0])
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tun.Close(); err != nil {
+			slog.Warn("failed to close tunnel", "err", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if _, err := client.Stat(ctx, source); err != nil {
+		return fmt.Errorf("failed to read %s: %w", source, err)
+	}
+	if info, err := client.Stat(ctx, target); err == nil && info.IsDir {
+		target = path.Join(target, path.Base(source))
+	}
+
+	if err := client.Rename(ctx, source, target); err != nil {
+		return fmt.Errorf("failed to move %s: %w", source, err)
+	}
+	if jsonOutput {
+		return printJSON(event{Event: "moved", Path: source, Target: target})
+	}
+	fmt.Printf("Moved %s to %s\n", source, target)
+	return nil
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// jsonOutput switches commands to machine-readable output: every result is
+// printed as one JSON object per line on stdout, errors as {"error": "..."}
+// on stderr, and progress and prompts are left out.
+var jsonOutput bool
+
+// printJSON writes v as a single line of JSON to stdout
+func printJSON(v interface{}) error {
+	return json.NewEncoder(os.Stdout).Encode(v)
+}
+
+// printJSONError reports a failed command on stderr
+func printJSONError(err error) {
+	_ = json.NewEncoder(os.Stderr).Encode(map[string]string{"error": err.Error()})
+}
+
+// event is a line of output of a long-running command such as share, send
+// or watch. Fields that do not apply to an event are left out.
+type event struct {
+	Event     string `json:"event"`
+	SessionID string `json:"session_id,omitempty"`
+	Passcode  string `json:"passcode,omitempty"`
+	Code      string `json:"code,omitempty"`
+	Job       string `json:"job,omitempty"`
+	Share     string `json:"share,omitempty"`
+	Relay     string `json:"relay,omitempty"`
+	Address   string `json:"address,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Target    string `json:"target,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Reused    int64  `json:"reused,omitempty"`
+	Files     int    `json:"files,omitempty"`
+	PID       int    `json:"pid,omitempty"`
+	LogFile   string `json:"log_file,omitempty"`
+	Service   string `json:"service,omitempty"`
+	Logs      string `json:"logs,omitempty"`
+	SHA256    string `json:"sha256,omitempty"`
+	Expires   string `json:"expires,omitempty"`
+	Identity  string `json:"identity,omitempty"`
+	Text      string `json:"text,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
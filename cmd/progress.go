@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// progressInterval is the least time between two progress events of a file
+const progressInterval = 250 * time.Millisecond
+
+// progressFormat is a value of --progress
+type progressFormat string
+
+const (
+	progressBar   progressFormat = "bar"   // a line redrawn in place, for terminals
+	progressPlain progressFormat = "plain" // a line per percent, for logs
+	progressJSON  progressFormat = "json"  // a JSON event per line, for programs
+	progressNone  progressFormat = "none"
+)
+
+// progressMode is --progress, empty when not given
+var progressMode progressFormat
+
+func (f *progressFormat) String() string { return string(*f) }
+
+func (f *progressFormat) Set(value string) error {
+	switch progressFormat(value) {
+	case progressBar, progressPlain, progressJSON, progressNone:
+		*f = progressFormat(value)
+		return nil
+	}
+	return fmt.Errorf("unknown progress format %q, use bar, plain, json or none", value)
+}
+
+func (f *progressFormat) Type() string { return "format" }
+
+// addProgressFlag registers --progress on cmd
+func addProgressFlag(cmd *cobra.Command) {
+	cmd.Flags().Var(&progressMode, "progress", "How to show progress on stderr: bar, plain (a line per percent), json (an event per line) or none")
+}
+
+// progressEvent is a line of --progress json
+type progressEvent struct {
+	Event       string  `json:"event"`
+	Path        string  `json:"path"`
+	Transferred int64   `json:"transferred"`
+	Size        int64   `json:"size"` // -1 for a stream
+	Percent     float64 `json:"percent"`
+	Speed       int64   `json:"bytes_per_second"`
+}
+
+// progressReporter shows how far transfers have come in the format chosen
+// with --progress. It is safe for concurrent use.
+type progressReporter struct {
+	mu     sync.Mutex
+	format progressFormat
+	out    io.Writer
+	files  map[string]*fileProgress
+}
+
+// fileProgress is what was last reported for a file, and where its
+// transfer started
+type fileProgress struct {
+	at      time.Time
+	percent int
+	start   time.Time
+	offset  int64
+}
+
+// newProgressReporter writes progress to out, in the format of --progress or
+// else in fallback. JSON output leaves progress out unless asked for.
+func newProgressReporter(out io.Writer, fallback progressFormat) *progressReporter {
+	format := progressMode
+	if format == "" {
+		format = fallback
+		if jsonOutput {
+			format = progressNone
+		}
+	}
+	return &progressReporter{format: format, out: out, files: make(map[string]*fileProgress)}
+}
+
+// update reports transferred bytes of a file of size bytes, or of a stream
+// when size < 0. The first and the last update of a file are always shown,
+// those in between only as often as the format calls for. Without a speed
+// measured by the caller, the average since the first update is shown.
+func (p *progressReporter) update(path string, transferred, size int64, speed float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	percent := 0.0
+	if size > 0 {
+		percent = float64(transferred) / float64(size) * 100
+	} else if size == 0 {
+		percent = 100
+	}
+
+	now := time.Now()
+	last, seen := p.files[path]
+	if !seen {
+		last = &fileProgress{percent: -1, start: now, offset: transferred}
+		p.files[path] = last
+	}
+	if elapsed := now.Sub(last.start).Seconds(); speed <= 0 && elapsed > 0 {
+		speed = float64(transferred-last.offset) / elapsed
+	}
+	complete := size >= 0 && transferred >= size
+
+	switch p.format {
+	case progressBar:
+		if size >= 0 {
+			fmt.Fprintf(p.out, "\r  %5.1f%%  %s / %s  %s/s   ",
+				percent, formatBytes(transferred), formatBytes(size), formatBytes(int64(speed)))
+		} else {
+			fmt.Fprintf(p.out, "\r  %s  %s/s   ", formatBytes(transferred), formatBytes(int64(speed)))
+		}
+	case progressPlain:
+		// Streams have no percentage, they get a line per interval
+		if size >= 0 && int(percent) == last.percent || size < 0 && seen && now.Sub(last.at) < progressInterval {
+			return
+		}
+		if size >= 0 {
+			fmt.Fprintf(p.out, "%3d%%  %s  %s / %s  %s/s\n",
+				int(percent), path, formatBytes(transferred), formatBytes(size), formatBytes(int64(speed)))
+		} else {
+			fmt.Fprintf(p.out, "%s  %s  %s/s\n", path, formatBytes(transferred), formatBytes(int64(speed)))
+		}
+	case progressJSON:
+		if seen && !complete && now.Sub(last.at) < progressInterval {
+			return
+		}
+		if seen && complete && last.percent == 100 {
+			return
+		}
+		_ = json.NewEncoder(p.out).Encode(progressEvent{
+			Event:       "progress",
+			Path:        path,
+			Transferred: transferred,
+			Size:        size,
+			Percent:     math.Round(percent*10) / 10,
+			Speed:       int64(speed),
+		})
+	default:
+		return
+	}
+	last.at = now
+	last.percent = int(percent)
+}
+
+// finish ends the progress shown, before other output or an error
+func (p *progressReporter) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.format == progressBar {
+		fmt.Fprintln(p.out)
+	}
+}
@@ -0,0 +1,421 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/filesystem"
+	"github.com/Zayan-Mohamed/orb/internal/relay"
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	"github.com/spf13/cobra"
+)
+
+var receiveCmd = &cobra.Command{
+	Use:   "receive <code>",
+	Short: "Receive a file offered with orb send",
+	Long: `Download the file offered by "orb send" into the current directory, or the
+one given with --output, under the name chosen by the sender. The download is
+checked against the sender's SHA-256 checksum before it replaces anything.
+With --output - the file is written to standard output.
+
+A file stored at the relay with "orb send --later" is picked up the same
+way, also when the sender is offline. It is decrypted here and the relay
+deletes it once it was received.
+
+With --encrypt the file is saved encrypted with this machine's key, as
+<name>.orbenc, and never written in plaintext. Open it with orb decrypt.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runReceive,
+}
+
+var (
+	receiveYes     bool
+	receiveDir     string
+	resumeDownload bool
+)
+
+func init() {
+	rootCmd.AddCommand(receiveCmd)
+	addRelayFlags(receiveCmd)
+	receiveCmd.Flags().BoolVarP(&receiveYes, "yes", "y", false, "Overwrite an existing file without asking")
+	receiveCmd.Flags().StringVarP(&receiveDir, "output", "o", defaultDownloadDir(), "Directory where the file is saved, created if missing, or - for standard output")
+	receiveCmd.Flags().BoolVar(&resumeDownload, "resume", false, "Continue a download that was interrupted earlier")
+	addExtractFlags(receiveCmd)
+	addEncryptFlags(receiveCmd)
+}
+
+func runReceive(cmd *cobra.Command, args []string) error {
+	sessionID, code, err := parseTransferCode(args[0])
+	if err != nil {
+		return err
+	}
+	passcode = code
+
+	toStdout := receiveDir == "-"
+	if toStdout && jsonOutput {
+		return errors.New("--output - cannot be combined with --json")
+	}
+	if toStdout && resumeDownload {
+		return errors.New("--resume needs a file to continue, it cannot be combined with --output -")
+	}
+	if err := checkExtract(toStdout); err != nil {
+		return err
+	}
+	if err := checkEncrypt(cmd, toStdout); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// Files stored with orb send --later have longer IDs than sessions
+	if len(sessionID) == relay.DropIDLength {
+		if resumeDownload {
+			return errors.New("a stored file is downloaded in one go, --resume does not apply")
+		}
+		return receiveLater(ctx, sessionID, passcode, toStdout)
+	}
+
+	tun, client, err := dialSession(sessionID)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tun.Close() }()
+
+	info, err := client.Info(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read session details: %w", err)
+	}
+	if info.File == "" {
+		return fmt.Errorf("session %s shares a folder, use orb connect instead", sessionID)
+	}
+
+	// The name comes from the sender, never let it point elsewhere
+	name := filepath.Base(filepath.Clean(info.File))
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return fmt.Errorf("sender offered an invalid file name %q", info.File)
+	}
+	remotePath := "/" + name
+
+	// The size of a stream is unknown until it has been read
+	size := int64(-1)
+	if !info.Stream {
+		stat, err := client.Stat(ctx, remotePath)
+		if err != nil {
+			return fmt.Errorf("failed to read file details: %w", err)
+		}
+		size = stat.Size
+	}
+
+	if toStdout {
+		return receiveToStdout(ctx, client, remotePath, size)
+	}
+
+	dir, err := resolveDownloadDir(receiveDir)
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(dir, filesystem.LocalName(name))
+	if err := receiveToFile(ctx, client, remotePath, target, size, transferObserver(sessionID)); err != nil {
+		return err
+	}
+	if wantsExtract() {
+		return extractArchive(ctx, target)
+	}
+	return nil
+}
+
+// receiveToFile downloads a remote file of the given size, or a stream when
+// size < 0, to target. The download is only moved into place once it
+// matches the sharer's checksum. An interrupted download is kept for
+// --resume or orb transfers resume. observe, if set, sees the download.
+// With --encrypt it is saved encrypted, to target with encryptedSuffix.
+func receiveToFile(ctx context.Context, client *remote.Client, remotePath, target string, size int64, observe func(transfer.Transfer)) error {
+	name := filepath.Base(target)
+	if receiveEncrypt {
+		target += encryptedSuffix
+	}
+	if err := confirmOverwrite(target); err != nil {
+		return err
+	}
+
+	// Download next to the target and only replace it once verified
+	partial := target + ".orb-partial"
+	var offset int64
+	if resumeDownload && size >= 0 {
+		var err error
+		if offset, err = resumeOffset(ctx, client, remotePath, partial, size); err != nil {
+			return err
+		}
+	}
+
+	if !jsonOutput {
+		switch {
+		case size < 0:
+			fmt.Printf("Receiving %s\n", name)
+		case offset > 0:
+			fmt.Printf("Resuming %s at %s of %s\n", name, formatBytes(offset), formatBytes(size))
+		default:
+			fmt.Printf("Receiving %s (%s)\n", name, formatBytes(size))
+		}
+	}
+
+	var localSum []byte
+	switch {
+	case receiveEncrypt:
+		// Encrypted as it arrives, so the download is fetched in order
+		file, err := createEncrypted(partial, name, size)
+		if err != nil {
+			return err
+		}
+		localSum, size, err = copyRemote(ctx, client, remotePath, size, file)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(partial)
+			return err
+		}
+	case size < 0:
+		// A stream is read once, so there is nothing to resume
+		// #nosec G304 -- the target is chosen by the receiving user
+		file, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		localSum, size, err = copyRemote(ctx, client, remotePath, -1, file)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(partial)
+			return err
+		}
+	default:
+		if err := downloadFile(ctx, client, remotePath, partial, size, offset, observe); err != nil {
+			return fmt.Errorf("%w, run the command again with --resume to continue", err)
+		}
+		var err error
+		if localSum, err = hashLocalFile(partial); err != nil {
+			return err
+		}
+	}
+
+	if err := verifyRemote(ctx, client, remotePath, localSum); err != nil {
+		_ = os.Remove(partial)
+		return fmt.Errorf("%w, the download was discarded", err)
+	}
+	if err := os.Rename(partial, target); err != nil {
+		return fmt.Errorf("failed to save %s: %w", name, err)
+	}
+	journalMoved(partial, target)
+
+	if jsonOutput {
+		return printJSON(event{Event: "received", Path: target, Size: size, SHA256: hex.EncodeToString(localSum)})
+	}
+	if receiveEncrypt {
+		fmt.Printf("✓ Received %s, checksum verified, saved encrypted as %s\n", name, filepath.Base(target))
+		return nil
+	}
+	fmt.Printf("✓ Received %s, checksum verified\n", name)
+	return nil
+}
+
+// confirmOverwrite asks before a download replaces target, unless --yes
+// was given
+func confirmOverwrite(target string) error {
+	if _, err := os.Stat(target); err != nil || receiveYes {
+		return nil
+	}
+	name := filepath.Base(target)
+	if jsonOutput {
+		return fmt.Errorf("%s already exists, use --yes to overwrite it", name)
+	}
+	if !confirm(fmt.Sprintf("%s already exists. Overwrite?", name)) {
+		return errors.New("not overwriting existing file")
+	}
+	return nil
+}
+
+// receiveToStdout writes a remote file, or a stream when size < 0, to
+// standard output. Messages go to stderr so that the output can be piped.
+func receiveToStdout(ctx context.Context, client *remote.Client, remotePath string, size int64) error {
+	sum, _, err := copyRemote(ctx, client, remotePath, size, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if err := verifyRemote(ctx, client, remotePath, sum); err != nil {
+		return fmt.Errorf("%w, the output is corrupt", err)
+	}
+	fmt.Fprintf(os.Stderr, "✓ Received %s, checksum verified\n", path.Base(remotePath))
+	return nil
+}
+
+// resumeOffset returns how much of an earlier partial download can be kept.
+// It is only continued when it matches the start of the remote file, which
+// the sharer checks by hashing the same number of bytes. Otherwise the
+// download starts over, reusing the blocks the two have in common.
+func resumeOffset(ctx context.Context, client *remote.Client, remotePath, partial string, size int64) (int64, error) {
+	info, err := os.Stat(partial)
+	if err != nil || !info.Mode().IsRegular() || info.Size() == 0 {
+		return 0, nil
+	}
+	n := info.Size()
+	if n > size {
+		fmt.Fprintf(os.Stderr, "%s is larger than the remote file, fetching only the blocks that differ\n", filepath.Base(partial))
+		return 0, nil
+	}
+
+	localSum, err := hashLocalFile(partial)
+	if err != nil {
+		return 0, err
+	}
+	remoteSum, err := client.HashPrefix(ctx, remotePath, n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check the partial download: %w", err)
+	}
+	if !bytes.Equal(localSum, remoteSum) {
+		fmt.Fprintf(os.Stderr, "%s does not match the remote file, fetching only the blocks that differ\n", filepath.Base(partial))
+		return 0, nil
+	}
+	return n, nil
+}
+
+// verifyRemote compares a local checksum with the sharer's
+func verifyRemote(ctx context.Context, client *remote.Client, remotePath string, sum []byte) error {
+	remoteSum, err := client.Hash(ctx, remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to read checksum: %w", err)
+	}
+	if !bytes.Equal(remoteSum, sum) {
+		return errors.New("checksum mismatch")
+	}
+	return nil
+}
+
+// copyRemote writes a remote file to w in order and returns its SHA-256
+// checksum and size. With size < 0 it reads until the sharer has no more
+// data, as streams require. Progress is shown on stderr as --progress asks.
+func copyRemote(ctx context.Context, client *remote.Client, remotePath string, size int64, w io.Writer) ([]byte, int64, error) {
+	progress := newProgressReporter(os.Stderr, progressBar)
+
+	h := sha256.New()
+	out := io.MultiWriter(w, h)
+	start := time.Now()
+	var offset int64
+	for size < 0 || offset < size {
+		length := int64(transfer.ChunkSize)
+		if size >= 0 && size-offset < length {
+			length = size - offset
+		}
+		data, err := client.Read(ctx, remotePath, offset, length)
+		if err != nil {
+			progress.finish()
+			return nil, offset, fmt.Errorf("download failed: %w", err)
+		}
+		if len(data) == 0 {
+			if size >= 0 {
+				progress.finish()
+				return nil, offset, fmt.Errorf("unexpected end of file at offset %d", offset)
+			}
+			break
+		}
+		if _, err := out.Write(data); err != nil {
+			progress.finish()
+			return nil, offset, err
+		}
+		offset += int64(len(data))
+
+		progress.update(remotePath, offset, size, float64(offset)/time.Since(start).Seconds())
+	}
+	progress.finish()
+	return h.Sum(nil), offset, nil
+}
+
+// downloadFile copies one remote file to localPath from offset on, showing
+// progress on stderr as --progress asks. When ctx is cancelled the bytes
+// downloaded so far are kept. observe, if set, sees the download.
+func downloadFile(ctx context.Context, client *remote.Client, remotePath, localPath string, size, offset int64, observe func(transfer.Transfer)) error {
+	manager := transfer.NewManager(client, 1)
+	manager.SetParallel(parallel)
+	// A partial download that no longer matches the remote file still has
+	// most of its blocks
+	manager.SetDelta(resumeDownload)
+	manager.SetObserver(observe)
+	id := manager.EnqueueFrom(transfer.Download, remotePath, localPath, size, offset)
+	return waitTransfer(ctx, manager, id, remotePath)
+}
+
+// waitTransfer shows the progress of one transfer on stderr as --progress
+// asks until it ends. When ctx is cancelled the transfer is paused, which
+// keeps what it moved so far.
+func waitTransfer(ctx context.Context, manager *transfer.Manager, id int, name string) error {
+	progress := newProgressReporter(os.Stderr, progressBar)
+
+	direction := transfer.Download
+	for {
+		for _, t := range manager.Snapshot() {
+			if t.ID != id {
+				continue
+			}
+			direction = t.Direction
+			progress.update(name, t.Transferred, t.Size, t.Speed)
+
+			switch t.State {
+			case transfer.StateDone:
+				progress.finish()
+				return nil
+			case transfer.StateFailed, transfer.StateCancelled:
+				progress.finish()
+				return fmt.Errorf("%s failed: %v", t.Direction, t.Err)
+			}
+		}
+
+		select {
+		case <-manager.Updates():
+		case <-ctx.Done():
+			// Pausing keeps the partial file, where cancelling removes it
+			_ = manager.Pause(id)
+			manager.Wait()
+			progress.finish()
+			return fmt.Errorf("%s interrupted", direction)
+		}
+	}
+}
+
+// confirm asks a yes/no question on stderr, defaulting to no
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// hashLocalFile returns the SHA-256 checksum of a local file
+func hashLocalFile(name string) ([]byte, error) {
+	// #nosec G304 -- the file was just written by this command
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -2,7 +2,9 @@ package cmd
 
 import (
 	"fmt"
-	"log"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/Zayan-Mohamed/orb/internal/relay"
 	"github.com/spf13/cobra"
@@ -17,14 +19,57 @@ var relayCmd = &cobra.Command{
 
 var (
 	listenAddr string
+	relayToken string
+	relayWeb   string
+
+	// relayStore* are --store-dir and the limits of stored files
+	relayStoreDir   string
+	relayStoreMax   = byteSize(100 << 20)
+	relayStoreTotal = byteSize(1 << 30)
+	relayStoreTTL   time.Duration
+
+	// relayHopTo are the relays clients may reach through this one
+	relayHopTo []string
 )
 
 func init() {
 	rootCmd.AddCommand(relayCmd)
 	relayCmd.Flags().StringVar(&listenAddr, "listen", ":8080", "Listen address (e.g., :8080 or 0.0.0.0:8080)")
+	relayCmd.Flags().StringVar(&relayToken, "token", "", "Only serve clients that present this token")
+	relayCmd.Flags().StringVar(&relayWeb, "web", "", "Serve the browser receiver built with \"make web\" from this directory at /receive/")
+	relayCmd.Flags().StringVar(&relayStoreDir, "store-dir", "", "Keep encrypted files sent with \"orb send --later\" in this directory until they are picked up")
+	relayCmd.Flags().Var(&relayStoreMax, "store-max-size", "Largest file to store, e.g. 100M")
+	relayCmd.Flags().Var(&relayStoreTotal, "store-total", "Space all stored files may take, e.g. 10G")
+	relayCmd.Flags().DurationVar(&relayStoreTTL, "store-ttl", 24*time.Hour, "Longest time a file is kept, senders may ask for less")
+	relayCmd.Flags().StringSliceVar(&relayHopTo, "hop-to", nil, "Pass connections of clients using --via on to these relays, as host:port or URL, \"*\" for any relay at a public address")
 }
 
 func runRelay(cmd *cobra.Command, args []string) error {
+	if relayWeb != "" {
+		if relayToken != "" {
+			return fmt.Errorf("--web cannot be used with --token, browsers cannot present the token")
+		}
+		if _, err := os.Stat(filepath.Join(relayWeb, "orb.wasm")); err != nil {
+			return fmt.Errorf("no browser receiver in %s, build it with \"make web\": %w", relayWeb, err)
+		}
+	}
+
+	if relayStoreDir != "" {
+		switch {
+		case relayStoreMax <= 0 || relayStoreTotal < relayStoreMax:
+			return fmt.Errorf("--store-max-size must be above 0 and at most --store-total")
+		case relayStoreTTL <= 0:
+			return fmt.Errorf("--store-ttl must be above 0")
+		}
+	}
+
+	if jsonOutput {
+		if err := printJSON(event{Event: "listening", Address: listenAddr}); err != nil {
+			return err
+		}
+		return startRelay()
+	}
+
 	fmt.Printf("Starting Orb relay server...\n")
 	fmt.Printf("Listening on %s\n", listenAddr)
 	fmt.Printf("\n")
@@ -32,13 +77,48 @@ func runRelay(cmd *cobra.Command, args []string) error {
 	fmt.Printf("  • The relay server never sees plaintext data\n")
 	fmt.Printf("  • All encryption happens at the edges\n")
 	fmt.Printf("  • Sessions expire automatically\n")
+	if relayToken != "" {
+		fmt.Printf("  • Clients must present the relay token\n")
+	}
+	if relayWeb != "" {
+		fmt.Printf("  • Browsers can receive at /receive/, decrypting on their own\n")
+	}
+	if len(relayHopTo) > 0 {
+		fmt.Printf("  • Clients can reach other relays through this one with --via\n")
+	}
+	if relayStoreDir != "" {
+		fmt.Printf("  • Stored files are encrypted by their senders, kept up to %s\n", relayStoreTTL)
+	}
 	fmt.Printf("\n")
 
+	return startRelay()
+}
+
+// startRelay runs the relay server until it fails
+func startRelay() error {
 	server := relay.NewRelayServer()
+	server.RequireToken(relayToken)
+	if err := server.ForwardHops(relayHopTo); err != nil {
+		return err
+	}
+	if relayWeb != "" {
+		server.ServeWeb(relayWeb)
+	}
+	if relayStoreDir != "" {
+		store, err := relay.NewStore(relayStoreDir, relay.StoreLimits{
+			MaxSize: int64(relayStoreMax),
+			Total:   int64(relayStoreTotal),
+			MaxTTL:  relayStoreTTL,
+		})
+		if err != nil {
+			return err
+		}
+		server.StoreFiles(store)
+	}
 	defer server.Shutdown()
 
 	if err := server.Start(listenAddr); err != nil {
-		log.Fatalf("Relay server error: %v", err)
+		return fmt.Errorf("relay server error: %w", err)
 	}
 
 	return nil
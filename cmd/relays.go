@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/tunnel"
+	"github.com/spf13/cobra"
+)
+
+// defaultRelay is the relay used when none is configured
+const defaultRelay = "http://localhost:8080"
+
+// relayProbeTimeout bounds the HTTP round trip measured by --fastest-relay
+const relayProbeTimeout = 5 * time.Second
+
+var (
+	// relayURLs are the relays given with --relay, ORB_RELAY or config.yaml,
+	// in the order they are tried. relayURL is the one in use.
+	relayURLs []string
+
+	// fastestRelay tries the relays in order of their round trip time, and
+	// relayLatency holds the round trips measured
+	fastestRelay bool
+	relayLatency map[string]time.Duration
+
+	// proxyFlag is --proxy, and relayProxy the proxy it names. Without it
+	// the proxy comes from HTTPS_PROXY and related variables.
+	proxyFlag  string
+	relayProxy *url.URL
+
+	// viaFlag is --via, the relay that hides this machine's address from
+	// the relay of the session, and viaRelay the relay it names. viaToken is
+	// --via-token.
+	viaFlag  string
+	viaToken string
+	viaRelay *url.URL
+)
+
+// addRelayFlags registers --relay, --fastest-relay, --proxy and --via on cmd
+func addRelayFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&relayURLs, "relay", []string{defaultRelay}, "Relay server URL, repeat or separate with commas to fall back to further relays")
+	cmd.Flags().BoolVar(&fastestRelay, "fastest-relay", false, "Try the relays in order of their round trip time instead of the given order")
+	cmd.Flags().StringVar(&proxyFlag, "proxy", "", "Reach the relay through this proxy, e.g. socks5://127.0.0.1:1080 or http://proxy:3128")
+	cmd.Flags().StringVar(&viaFlag, "via", "", "Reach the relay through this other relay, so that it does not learn this machine's address")
+	cmd.Flags().StringVar(&viaToken, "via-token", "", "Token for a --via relay that requires one")
+}
+
+// parseProxy checks a --proxy URL
+func parseProxy(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q, expected something like socks5://host:port or http://host:port", raw)
+	}
+	if u.Scheme != "http" && u.Scheme != "socks5" {
+		return nil, fmt.Errorf("unsupported proxy scheme %q, use http or socks5", u.Scheme)
+	}
+	return u, nil
+}
+
+// parseVia checks a --via URL
+func parseVia(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid --via relay %q, expected something like https://relay.example", raw)
+	}
+	return u, nil
+}
+
+// relayHTTPClient returns a client for plain requests to relays, which goes
+// through --via and --proxy when they are given
+func relayHTTPClient(timeout time.Duration) *http.Client {
+	client := &http.Client{Timeout: timeout}
+	switch {
+	case viaRelay != nil:
+		// The proxy, if any, is only used to reach the --via relay
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil
+		transport.DialContext = tunnel.HopDialer(relayDialOptions()...)
+		client.Transport = transport
+	case relayProxy != nil:
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(relayProxy)
+		client.Transport = transport
+	}
+	return client
+}
+
+// browserLink returns the link that opens a session in the browser receiver
+// of the relay, empty when the relay serves none. Browsers cannot present a
+// relay token, so token-protected relays get no link either.
+func browserLink(sessionID string) string {
+	if relayToken != "" {
+		return ""
+	}
+	base := strings.TrimSuffix(relayURL, "/")
+	resp, err := relayHTTPClient(relayProbeTimeout).Head(base + "/receive/orb.wasm")
+	if err != nil {
+		return ""
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	return base + "/receive/#" + sessionID
+}
+
+// relayChoice describes the relay in use for the session details, with its
+// round trip when --fastest-relay measured it
+func relayChoice() string {
+	if rtt, ok := relayLatency[relayURL]; ok {
+		return fmt.Sprintf("%s (fastest, %s round trip)", relayURL, rtt.Round(time.Millisecond))
+	}
+	return relayURL
+}
+
+// relayDialOptions returns the options every tunnel to a relay is opened
+// with, followed by extra
+func relayDialOptions(extra ...tunnel.Option) []tunnel.Option {
+	opts := []tunnel.Option{tunnel.WithRelayToken(relayToken), tunnel.WithProxy(relayProxy)}
+	if viaRelay != nil {
+		opts = append(opts, tunnel.WithHop(viaRelay, viaToken))
+	}
+	return append(opts, extra...)
+}
+
+// candidateRelays returns the relays to try, closest first with
+// --fastest-relay. Unreachable relays keep their place at the end. All are
+// probed at once, so choosing takes as long as the slowest probe.
+func candidateRelays() []string {
+	relays := slices.Clone(relayURLs)
+	if !fastestRelay || len(relays) < 2 {
+		return relays
+	}
+
+	rtts := make([]time.Duration, len(relays))
+	var wg sync.WaitGroup
+	for i, relay := range relays {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rtts[i] = relayRTT(relay)
+		}()
+	}
+	wg.Wait()
+
+	order := make([]int, len(relays))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		switch {
+		case rtts[a] == rtts[b]:
+			return 0
+		case rtts[a] == 0:
+			return 1
+		case rtts[b] == 0:
+			return -1
+		}
+		return int(rtts[a] - rtts[b])
+	})
+
+	sorted := make([]string, len(relays))
+	relayLatency = make(map[string]time.Duration, len(relays))
+	for i, j := range order {
+		sorted[i] = relays[j]
+		if rtts[j] > 0 {
+			relayLatency[relays[j]] = rtts[j]
+		}
+	}
+	return sorted
+}
+
+// relayRTT times a plain HTTP request to relay and a WebSocket message
+// through it, the way tunnels go, and returns their sum, or 0 when the relay
+// cannot be reached. Relays too old to answer WebSocket probes count the
+// HTTP round trip twice.
+func relayRTT(relay string) time.Duration {
+	client := relayHTTPClient(relayProbeTimeout)
+	start := time.Now()
+	resp, err := client.Get(strings.TrimSuffix(relay, "/") + "/")
+	if err != nil {
+		return 0
+	}
+	_ = resp.Body.Close()
+	httpRTT := time.Since(start)
+
+	wsRTT, err := tunnel.Probe(relay, relayDialOptions()...)
+	if err != nil {
+		if !errors.Is(err, tunnel.ErrNoProbe) {
+			slog.Debug("relay probe failed", "relay", relay, "err", err)
+		}
+		wsRTT = httpRTT
+	}
+	slog.Debug("relay round trip", "relay", relay, "http", httpRTT, "websocket", wsRTT)
+	return httpRTT + wsRTT
+}
+
+// createSessionOnAnyRelay creates a session on the first relay that accepts
+// it and makes that relay the one in use
+func createSessionOnAnyRelay(sharedPath string, ttl time.Duration) (string, string, error) {
+	relays := candidateRelays()
+	var errs []error
+	for _, relay := range relays {
+		sessionID, passcode, err := createSession(relay, sharedPath, ttl)
+		if err == nil {
+			relayURL = relay
+			return sessionID, passcode, nil
+		}
+		if len(relays) == 1 {
+			return "", "", err
+		}
+		slog.Warn("relay failed, trying the next one", "relay", relay, "err", err)
+		errs = append(errs, fmt.Errorf("%s: %w", relay, err))
+	}
+	return "", "", fmt.Errorf("no relay accepted the session:\n%w", errors.Join(errs...))
+}
+
+// dialAnyRelay connects to a session as the initiator on the first relay
+// that knows it and makes that relay the one in use. Failures past the relay,
+// such as a wrong passcode, are returned without trying further relays.
+func dialAnyRelay(sessionID, passcode string, opts ...tunnel.Option) (*tunnel.Tunnel, error) {
+	relays := candidateRelays()
+	var errs []error
+	for _, relay := range relays {
+		tun, err := tunnel.NewTunnel(relay, sessionID, passcode, true, opts...)
+		if err == nil {
+			relayURL = relay
+			return tun, nil
+		}
+		if len(relays) == 1 || !(errors.Is(err, tunnel.ErrSessionNotFound) || errors.Is(err, tunnel.ErrRelayUnreachable)) {
+			return nil, err
+		}
+		slog.Debug("session not reachable on relay", "relay", relay, "err", err)
+		errs = append(errs, fmt.Errorf("%s: %w", relay, err))
+	}
+	return nil, fmt.Errorf("no relay has the session:\n%w", errors.Join(errs...))
+}
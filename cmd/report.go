@@ -0,0 +1,323 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"github.com/Zayan-Mohamed/orb/internal/hooks"
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	"github.com/Zayan-Mohamed/orb/internal/tunnel"
+	"github.com/spf13/cobra"
+)
+
+// reportFile is --report of share and connect
+var reportFile string
+
+// maxReportErrors bounds the errors a report lists, the rest are counted
+const maxReportErrors = 100
+
+// addReportFlag adds --report to a command that runs a session
+func addReportFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&reportFile, "report", "", "Write a summary of the session to this file when it ends, as JSON if it ends in .json")
+}
+
+// sessionReport is the summary --report writes when a session ends, a
+// receipt of what was handed over. Files are named from the receiver's
+// side: downloads went from the sharer to the receiver, uploads the other
+// way. A nil sessionReport records nothing.
+type sessionReport struct {
+	Role          string         `json:"role"`
+	SessionID     string         `json:"session_id"`
+	Relay         string         `json:"relay,omitempty"`
+	Path          string         `json:"path,omitempty"`
+	Started       time.Time      `json:"started"`
+	Ended         time.Time      `json:"ended"`
+	Duration      float64        `json:"duration_seconds"`
+	Peers         []reportPeer   `json:"peers"`
+	Files         []reportedFile `json:"files"`
+	BytesSent     int64          `json:"bytes_sent"`
+	BytesReceived int64          `json:"bytes_received"`
+	Throughput    float64        `json:"throughput_bytes_per_second"`
+	Errors        []reportError  `json:"errors"`
+	MoreErrors    int            `json:"more_errors,omitempty"`
+
+	mu     sync.Mutex
+	hashes sync.WaitGroup // checksums of files still being computed
+}
+
+// reportPeer is a peer of the session with the traffic of its tunnel
+type reportPeer struct {
+	Name          string    `json:"name"`
+	Connected     time.Time `json:"connected"`
+	Disconnected  time.Time `json:"disconnected"`
+	BytesSent     int64     `json:"bytes_sent"`
+	BytesReceived int64     `json:"bytes_received"`
+}
+
+// reportedFile is a file transferred completely
+type reportedFile struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	Path      string    `json:"path"`
+	LocalPath string    `json:"local_path,omitempty"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256,omitempty"`
+}
+
+// reportError is a request or transfer that failed
+type reportError struct {
+	Time    time.Time `json:"time"`
+	Path    string    `json:"path,omitempty"`
+	Message string    `json:"message"`
+}
+
+// checkReportFile makes --report absolute, and makes sure that there is
+// somewhere to write it before the session rather than after
+func checkReportFile() error {
+	if reportFile == "" {
+		return nil
+	}
+	p, err := config.ExpandHome(reportFile)
+	if err != nil {
+		return err
+	}
+	if p, err = filepath.Abs(p); err != nil {
+		return fmt.Errorf("invalid --report: %w", err)
+	}
+	if info, err := os.Stat(filepath.Dir(p)); err != nil || !info.IsDir() {
+		return fmt.Errorf("invalid --report: %s is not a directory", filepath.Dir(p))
+	}
+	reportFile = p
+	return nil
+}
+
+// newSessionReport starts the report of a session when --report is given,
+// and returns nil otherwise. path is what is shared, or where downloads go.
+func newSessionReport(role, sessionID, path string) *sessionReport {
+	if reportFile == "" {
+		return nil
+	}
+	return &sessionReport{
+		Role:      role,
+		SessionID: sessionID,
+		Relay:     relayURL,
+		Path:      path,
+		Started:   time.Now(),
+		Peers:     []reportPeer{},
+		Files:     []reportedFile{},
+		Errors:    []reportError{},
+	}
+}
+
+// connected notes a peer that connected and returns its number for
+// disconnected
+func (r *sessionReport) connected(name string) int {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Peers = append(r.Peers, reportPeer{Name: name, Connected: time.Now()})
+	return len(r.Peers) - 1
+}
+
+// disconnected notes that a peer left, with what went through its tunnel
+func (r *sessionReport) disconnected(peer int, tun *tunnel.Tunnel) {
+	if r == nil {
+		return
+	}
+	sent, received := tun.Traffic()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := &r.Peers[peer]
+	p.Disconnected, p.BytesSent, p.BytesReceived = time.Now(), sent, received
+}
+
+// file notes a file transferred completely. Without a checksum, that of the
+// local file is computed in the background.
+func (r *sessionReport) file(f reportedFile) {
+	if r == nil {
+		return
+	}
+	if f.Time.IsZero() {
+		f.Time = time.Now()
+	}
+	r.mu.Lock()
+	r.Files = append(r.Files, f)
+	i := len(r.Files) - 1
+	r.mu.Unlock()
+	if f.SHA256 != "" || f.LocalPath == "" {
+		return
+	}
+
+	r.hashes.Add(1)
+	go func() {
+		defer r.hashes.Done()
+		sum, err := hashLocalFile(f.LocalPath)
+		if err != nil {
+			slog.Debug("failed to checksum file for the report", "path", f.LocalPath, "err", err)
+			return
+		}
+		r.mu.Lock()
+		r.Files[i].SHA256 = hex.EncodeToString(sum)
+		r.mu.Unlock()
+	}()
+}
+
+// failed notes an error, about path if it is not empty
+func (r *sessionReport) failed(path, message string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.Errors) >= maxReportErrors {
+		r.MoreErrors++
+		return
+	}
+	r.Errors = append(r.Errors, reportError{Time: time.Now(), Path: path, Message: message})
+}
+
+// event notes the files of an event of the hooks of a share
+func (r *sessionReport) event(e hooks.Event) {
+	switch e.Event {
+	case hooks.FileDownloaded:
+		r.file(reportedFile{Time: e.Time, Direction: "download", Path: e.Path, LocalPath: e.LocalPath, Size: e.Size})
+	case hooks.UploadCompleted:
+		r.file(reportedFile{Time: e.Time, Direction: "upload", Path: e.Path, LocalPath: e.LocalPath, Size: e.Size})
+	}
+}
+
+// transfers notes the finished transfers of the file browser
+func (r *sessionReport) transfers(history []transfer.Transfer) {
+	if r == nil {
+		return
+	}
+	for _, t := range history {
+		switch t.State {
+		case transfer.StateDone:
+			f := reportedFile{Time: t.Finished, Direction: t.Direction.String(), Path: t.RemotePath, LocalPath: t.LocalPath, Size: t.Size}
+			if t.SHA256 != nil {
+				f.SHA256 = hex.EncodeToString(t.SHA256)
+			}
+			r.file(f)
+		case transfer.StateFailed:
+			r.failed(t.RemotePath, t.Err.Error())
+		}
+	}
+}
+
+// write completes the report and writes it to --report, then says where
+func (r *sessionReport) write() {
+	if r == nil {
+		return
+	}
+	r.hashes.Wait()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Ended = time.Now()
+	r.Duration = r.Ended.Sub(r.Started).Seconds()
+	// Throughput is averaged over the time peers were connected, a share
+	// waiting for its receiver moves nothing
+	var connected time.Duration
+	r.BytesSent, r.BytesReceived = 0, 0
+	for _, p := range r.Peers {
+		r.BytesSent += p.BytesSent
+		r.BytesReceived += p.BytesReceived
+		if !p.Disconnected.IsZero() {
+			connected += p.Disconnected.Sub(p.Connected)
+		}
+	}
+	if connected > 0 {
+		r.Throughput = float64(r.BytesSent+r.BytesReceived) / connected.Seconds()
+	}
+
+	var buf bytes.Buffer
+	if strings.EqualFold(filepath.Ext(reportFile), ".json") {
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(r); err != nil {
+			slog.Warn("failed to write the report", "err", err)
+			return
+		}
+	} else {
+		r.writeText(&buf)
+	}
+	if err := os.WriteFile(reportFile, buf.Bytes(), 0600); err != nil {
+		slog.Warn("failed to write the report", "err", err)
+		return
+	}
+
+	if jsonOutput {
+		_ = printJSON(event{Event: "report", SessionID: r.SessionID, Path: reportFile})
+		return
+	}
+	fmt.Printf("Report of the session written to %s\n", reportFile)
+}
+
+// writeText writes the report for people to read
+func (r *sessionReport) writeText(w io.Writer) {
+	const timeFormat = "2006-01-02 15:04:05 MST"
+	fmt.Fprintf(w, "Orb session report\n\n")
+	fmt.Fprintf(w, "  Session:    %s (%s)\n", r.SessionID, r.Role)
+	if r.Relay != "" {
+		fmt.Fprintf(w, "  Relay:      %s\n", r.Relay)
+	}
+	if r.Path != "" {
+		fmt.Fprintf(w, "  Path:       %s\n", r.Path)
+	}
+	fmt.Fprintf(w, "  Started:    %s\n", r.Started.Format(timeFormat))
+	fmt.Fprintf(w, "  Ended:      %s\n", r.Ended.Format(timeFormat))
+	fmt.Fprintf(w, "  Duration:   %s\n", r.Ended.Sub(r.Started).Round(time.Second))
+	fmt.Fprintf(w, "  Traffic:    %s sent, %s received\n", formatBytes(r.BytesSent), formatBytes(r.BytesReceived))
+	if r.Throughput > 0 {
+		fmt.Fprintf(w, "  Throughput: %s/s on average while connected\n", formatBytes(int64(r.Throughput)))
+	}
+
+	fmt.Fprintf(w, "\nPeers (%d)\n", len(r.Peers))
+	for _, p := range r.Peers {
+		until := "still connected"
+		if !p.Disconnected.IsZero() {
+			until = p.Disconnected.Format("15:04:05")
+		}
+		fmt.Fprintf(w, "  %-10s %s to %s, %s sent, %s received\n", p.Name, p.Connected.Format("15:04:05"), until,
+			formatBytes(p.BytesSent), formatBytes(p.BytesReceived))
+	}
+
+	var total int64
+	for _, f := range r.Files {
+		total += f.Size
+	}
+	fmt.Fprintf(w, "\nFiles (%d, %s)\n", len(r.Files), formatBytes(total))
+	for _, f := range r.Files {
+		sum := f.SHA256
+		if sum == "" {
+			sum = "no checksum"
+		}
+		fmt.Fprintf(w, "  %s  %-8s %10s  %s\n", f.Time.Format("15:04:05"), f.Direction, formatBytes(f.Size), f.Path)
+		fmt.Fprintf(w, "            sha256 %s\n", sum)
+	}
+
+	fmt.Fprintf(w, "\nErrors (%d)\n", len(r.Errors)+r.MoreErrors)
+	for _, e := range r.Errors {
+		if e.Path != "" {
+			fmt.Fprintf(w, "  %s  %s: %s\n", e.Time.Format("15:04:05"), e.Path, e.Message)
+		} else {
+			fmt.Fprintf(w, "  %s  %s\n", e.Time.Format("15:04:05"), e.Message)
+		}
+	}
+	if r.MoreErrors > 0 {
+		fmt.Fprintf(w, "  and %d more\n", r.MoreErrors)
+	}
+}
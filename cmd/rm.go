@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path"
+
+	"github.com/spf13/cobra"
+)
+
+var rmCmd = &cobra.Command{
+	Use:   "rm <session-id> <path>...",
+	Short: "Delete files from a writable share",
+	Long: `Delete files, or with -r directories and everything in them, from a shared
+folder the sharer made writable. Paths are relative to the shared folder.
+Deleting a directory asks first unless --yes is given.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: runRm,
+}
+
+var (
+	rmRecursive bool
+	rmYes       bool
+)
+
+func init() {
+	rootCmd.AddCommand(rmCmd)
+	addRelayFlags(rmCmd)
+	addPasscodeFlags(rmCmd)
+	rmCmd.Flags().BoolVarP(&rmRecursive, "recursive", "r", false, "Delete directories and everything in them")
+	rmCmd.Flags().BoolVarP(&rmYes, "yes", "y", false, "Delete directories without asking")
+}
+
+func runRm(cmd *cobra.Command, args []string) error {
+	if rmRecursive && !rmYes && jsonOutput {
+		return errors.New("--json cannot ask before deleting a directory, add --yes")
+	}
+
+	tun, client, err := dialSession(args[0])
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tun.Close(); err != nil {
+			slog.Warn("failed to close tunnel", "err", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	for _, arg := range args[1:] {
+		remotePath := path.Join("/", arg)
+		if remotePath == "/" {
+			return errors.New("the root of a share cannot be deleted")
+		}
+
+		info, err := client.Stat(ctx, remotePath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", remotePath, err)
+		}
+		if info.IsDir {
+			if !rmRecursive {
+				return fmt.Errorf("%s is a directory, use -r to delete it and everything in it", remotePath)
+			}
+			if !rmYes && !confirm(fmt.Sprintf("Delete %s and everything in it?", remotePath)) {
+				return errors.New("aborted")
+			}
+		}
+
+		if err := client.Delete(ctx, remotePath); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", remotePath, err)
+		}
+		if jsonOutput {
+			if err := printJSON(event{Event: "deleted", Path: remotePath}); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Printf("Deleted %s\n", remotePath)
+	}
+	return nil
+}
@@ -2,8 +2,16 @@ package cmd
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"github.com/Zayan-Mohamed/orb/internal/logging"
+	"github.com/Zayan-Mohamed/orb/internal/service"
+	"github.com/Zayan-Mohamed/orb/internal/telemetry"
 	"github.com/spf13/cobra"
 )
 
@@ -20,22 +28,56 @@ var rootCmd = &cobra.Command{
 	Long: `Orb is a secure folder sharing tool that uses end-to-end encryption.
 No accounts, no cloud storage, no port forwarding.
 All data is encrypted and the relay server is blind.`,
-	Version: Version,
+	Version:           Version,
+	PersistentPreRunE: applyConfig,
 }
 
+// profile selects a named profile from config.yaml
+var profile string
+
+// Logging flags
+var (
+	verbose  bool
+	quiet    bool
+	logLevel string
+	logFile  string
+)
+
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Print the version information",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if jsonOutput {
+			return printJSON(map[string]string{
+				"version":    Version,
+				"git_commit": GitCommit,
+				"build_date": BuildDate,
+			})
+		}
 		fmt.Printf("Orb version %s\n", Version)
 		fmt.Printf("Git commit: %s\n", GitCommit)
 		fmt.Printf("Build date: %s\n", BuildDate)
+		return nil
 	},
 }
 
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
+	// Installed as a Windows service, the service manager runs the command
+	if service.RunIfService(rootCmd.Execute) {
+		telemetry.Shutdown()
+		logging.Close()
+		return
+	}
+
+	err := rootCmd.Execute()
+	telemetry.Shutdown()
+	logging.Close()
+	if err != nil {
+		if jsonOutput {
+			printJSONError(err)
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
 		os.Exit(1)
 	}
 }
@@ -44,4 +86,166 @@ func init() {
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
 	rootCmd.SetVersionTemplate(fmt.Sprintf("Orb version %s\nGit commit: %s\nBuild date: %s\n", Version, GitCommit, BuildDate))
 	rootCmd.AddCommand(versionCmd)
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "Configuration profile to use")
+	rootCmd.PersistentFlags().StringVar(&relayToken, "relay-token", "", "Token for relays that require one")
+	rootCmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "Print machine-readable JSON instead of text")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Log debug messages")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Only log errors")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "Log level: debug, info, warn or error")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "Write the log to this file instead of stderr")
+
+	// In JSON mode Execute reports errors itself, as JSON. Initializers run
+	// once flags are parsed, before arguments are checked.
+	cobra.OnInitialize(silenceInJSONMode)
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		silenceInJSONMode()
+		return err
+	})
+}
+
+// envFlags maps flags to the environment variables that set them, for
+// containers and CI jobs where passing flags or answering prompts is awkward
+var envFlags = map[string]string{
+	"relay":         "ORB_RELAY",
+	"relay-token":   "ORB_RELAY_TOKEN",
+	"token":         "ORB_RELAY_TOKEN",
+	"passcode":      "ORB_PASSCODE",
+	"passcode-file": "ORB_PASSCODE_FILE",
+	"output":        "ORB_OUTPUT_DIR",
+	"concurrency":   "ORB_CONCURRENCY",
+	"bwlimit":       "ORB_BWLIMIT",
+	"proxy":         "ORB_PROXY",
+	"via":           "ORB_VIA",
+	"fastest-relay": "ORB_FASTEST_RELAY",
+	"listen":        "ORB_LISTEN",
+	"metrics-addr":  "ORB_METRICS_ADDR",
+	"log-level":     "ORB_LOG_LEVEL",
+	"log-file":      "ORB_LOG_FILE",
+}
+
+// outputDir marks an --output taken from ORB_OUTPUT_DIR or the config as a
+// directory, which orb get would otherwise save to as a file when it does
+// not exist yet
+func outputDir(dir string) string {
+	if dir == "" || dir == "-" || strings.HasSuffix(dir, "/") || strings.HasSuffix(dir, string(filepath.Separator)) {
+		return dir
+	}
+	return dir + string(filepath.Separator)
+}
+
+// applyConfig fills in the flags not given on the command line, first from
+// ORB_* environment variables, then from config.yaml and the selected profile
+func applyConfig(cmd *cobra.Command, args []string) error {
+	for name, env := range envFlags {
+		flag := cmd.Flags().Lookup(name)
+		value, ok := os.LookupEnv(env)
+		if flag == nil || flag.Changed || !ok {
+			continue
+		}
+		if name == "output" {
+			value = outputDir(value)
+		}
+		if err := cmd.Flags().Set(name, value); err != nil {
+			return fmt.Errorf("invalid %s: %w", env, err)
+		}
+	}
+	if env, ok := os.LookupEnv("ORB_PROFILE"); ok && !cmd.Flags().Changed("profile") {
+		profile = env
+	}
+
+	// ORB_DEBUG predates the logging flags and still turns on debug logging
+	if env := os.Getenv("ORB_DEBUG"); env != "" && env != "0" && !quiet {
+		verbose = true
+	}
+	path, err := config.ExpandHome(logFile)
+	if err != nil {
+		return err
+	}
+	if err := logging.Setup(logging.Options{
+		Level:   logLevel,
+		Verbose: verbose,
+		Quiet:   quiet,
+		File:    path,
+		JSON:    jsonOutput,
+	}); err != nil {
+		return err
+	}
+	// Traces are exported when OTEL_EXPORTER_OTLP_ENDPOINT is set, e.g.
+	// as service orb-relay or orb-share
+	name := strings.ReplaceAll(cmd.CommandPath(), " ", "-")
+	if err := telemetry.Setup(name, Version); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	settings, err := cfg.Resolve(profile)
+	if err != nil {
+		return err
+	}
+	configHooks = cfg.Hooks
+	configEncrypt = settings.Encrypt
+
+	output, err := config.ExpandHome(settings.Output)
+	if err != nil {
+		return err
+	}
+	defaults := map[string]string{
+		"relay":   strings.Join(settings.RelayList(), ","),
+		"output":  outputDir(output),
+		"bwlimit": settings.BWLimit,
+		"proxy":   settings.Proxy,
+		"via":     settings.Via,
+	}
+	if settings.Concurrency != 0 {
+		defaults["concurrency"] = strconv.Itoa(settings.Concurrency)
+	}
+	if settings.FastestRelay {
+		defaults["fastest-relay"] = "true"
+	}
+
+	for name, value := range defaults {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil || flag.Changed || value == "" {
+			continue
+		}
+		if err := cmd.Flags().Set(name, value); err != nil {
+			return fmt.Errorf("invalid %s in config: %w", name, err)
+		}
+	}
+
+	// The first relay is used until another one has to stand in for it
+	if len(relayURLs) == 0 {
+		relayURLs = []string{defaultRelay}
+	}
+	relayURL = relayURLs[0]
+	if proxyFlag != "" {
+		if relayProxy, err = parseProxy(proxyFlag); err != nil {
+			return err
+		}
+	}
+	if viaFlag != "" {
+		if viaRelay, err = parseVia(viaFlag); err != nil {
+			return err
+		}
+		// Over plain http the --via relay could read which session is joined
+		for _, relay := range relayURLs {
+			if !strings.HasPrefix(relay, "https://") {
+				slog.Warn("with --via, use https relays, or the --via relay sees the session being joined", "relay", relay)
+			}
+		}
+	}
+
+	return nil
+}
+
+// silenceInJSONMode keeps cobra from printing errors and usage as text when
+// --json is set
+func silenceInJSONMode() {
+	if jsonOutput {
+		rootCmd.SilenceErrors = true
+		rootCmd.SilenceUsage = true
+	}
 }
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/clipboard"
+	"github.com/Zayan-Mohamed/orb/internal/filesystem"
+	"github.com/Zayan-Mohamed/orb/internal/monitor"
+	"github.com/Zayan-Mohamed/orb/internal/tunnel"
+	"github.com/spf13/cobra"
+)
+
+var sendCmd = &cobra.Command{
+	Use:   "send <file|->",
+	Short: "Send a single file",
+	Long: `Offer exactly one file to one receiver. A short code is printed that the
+receiver passes to "orb receive". The session ends once the receiver
+disconnects.
+
+With --later the file is encrypted and left at the relay instead, and orb
+returns at once. The receiver picks it up with the printed code while you
+are offline, for as long as the relay keeps it. The relay has to be started
+with --store-dir.
+
+With "-" standard input is sent as it is read, e.g.
+
+  tar cz . | orb send - --name project.tar.gz`,
+	Args: cobra.ExactArgs(1),
+	RunE: runSend,
+}
+
+var (
+	// sendName is the file name offered for standard input
+	sendName string
+
+	// sendLater is --later and sendKeep --keep
+	sendLater bool
+	sendKeep  time.Duration
+)
+
+func init() {
+	rootCmd.AddCommand(sendCmd)
+	addRelayFlags(sendCmd)
+	sendCmd.Flags().BoolVar(&copyInvite, "copy", false, "Copy the receive command to the clipboard")
+	sendCmd.Flags().StringVar(&sendName, "name", "stdin", "File name the receiver saves standard input under")
+	sendCmd.Flags().BoolVar(&sendLater, "later", false, "Leave the file encrypted at the relay for the receiver to pick up later, without waiting")
+	sendCmd.Flags().DurationVar(&sendKeep, "keep", 0, "How long the relay keeps a file sent with --later (default: as long as the relay allows)")
+}
+
+// transferCode joins a session ID and passcode into the single code used by
+// send and receive, e.g. "7F9Q2A-493-771"
+func transferCode(sessionID, passcode string) string {
+	return sessionID + "-" + passcode
+}
+
+// parseTransferCode splits a code made by transferCode
+func parseTransferCode(code string) (string, string, error) {
+	sessionID, passcode, ok := strings.Cut(strings.TrimSpace(code), "-")
+	if !ok || sessionID == "" || passcode == "" {
+		return "", "", fmt.Errorf("invalid code %q, expected something like 7F9Q2A-493-771", code)
+	}
+	return sessionID, passcode, nil
+}
+
+func runSend(cmd *cobra.Command, args []string) error {
+	if sendLater {
+		return runSendLater(args[0])
+	}
+	if args[0] == "-" {
+		return runSendStream()
+	}
+
+	// Only this file is reachable through the session
+	secureFS, err := filesystem.NewSingleFileFilesystem(args[0])
+	if err != nil {
+		return fmt.Errorf("cannot send %s: %w", args[0], err)
+	}
+	name := secureFS.SharedFile()
+	info, err := os.Stat(filepath.Join(secureFS.RootPath(), name))
+	if err != nil {
+		return err
+	}
+
+	sessionID, passcode, err := createSessionOnAnyRelay(filepath.Join(secureFS.RootPath(), name), 0)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	code := transferCode(sessionID, passcode)
+	invite := fmt.Sprintf("orb receive %s --relay %s", code, relayURL)
+
+	if jsonOutput {
+		if err := printJSON(event{
+			Event:     "session",
+			SessionID: sessionID,
+			Passcode:  passcode,
+			Code:      code,
+			Relay:     relayURL,
+			Path:      name,
+			Size:      info.Size(),
+		}); err != nil {
+			return err
+		}
+	} else {
+		printSendSession(name, info.Size(), code, invite)
+	}
+
+	// Sharer is the responder (waits for connector to initiate handshake)
+	tun, err := tunnel.NewTunnel(relayURL, sessionID, passcode, false, relayDialOptions()...)
+	if err != nil {
+		return fmt.Errorf("failed to establish tunnel: %w", err)
+	}
+	defer func() { _ = tun.Close() }()
+
+	if jsonOutput {
+		if err := printJSON(event{Event: "connected"}); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("✓ Connected, sending...\n")
+	}
+
+	// The monitor counts what was read, to tell a finished download from
+	// a receiver that gave up
+	mon := monitor.New()
+	peer := mon.AddPeer("receiver", func() { _ = tun.Close() })
+	if err := handleShareRequests(tun, secureFS, mon, peer, shareControls{}); err != nil {
+		return err
+	}
+
+	var sent int64
+	for _, op := range mon.Ops() {
+		if op.Kind == "read" && op.Err == "" {
+			sent += op.Bytes
+		}
+	}
+	if sent < info.Size() {
+		return errors.New("the receiver disconnected before the download finished")
+	}
+
+	if jsonOutput {
+		return printJSON(event{Event: "sent", Path: name, Size: info.Size()})
+	}
+	fmt.Printf("✓ Sent %s\n", name)
+	return nil
+}
+
+// printSendSession shows the code the receiver needs
+func printSendSession(name string, size int64, code, invite string) {
+	fmt.Printf("\n")
+	if size < 0 {
+		fmt.Printf("  Sending:  %s (from standard input)\n", name)
+	} else {
+		fmt.Printf("  Sending:  %s (%s)\n", name, formatBytes(size))
+	}
+	fmt.Printf("  Code:     %s\n", code)
+	fmt.Printf("\n")
+	fmt.Printf("On the other computer run:\n")
+	fmt.Printf("  %s\n", invite)
+	if copyInvite {
+		if err := clipboard.Write(invite); err != nil {
+			slog.Warn("failed to copy to clipboard", "err", err)
+		} else {
+			fmt.Printf("Receive command copied to the clipboard.\n")
+		}
+	}
+	fmt.Printf("\n")
+	fmt.Printf("Waiting for the receiver...\n")
+}
@@ -0,0 +1,368 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/clipboard"
+	"github.com/Zayan-Mohamed/orb/internal/drop"
+	"github.com/Zayan-Mohamed/orb/internal/filesystem"
+	"github.com/Zayan-Mohamed/orb/internal/session"
+	"golang.org/x/term"
+)
+
+// dropTokenHeader carries the token that deletes a stored file, see
+// relay.HandleDropPut
+const dropTokenHeader = "X-Orb-Drop-Token"
+
+// errNoStore is returned by relays started without --store-dir
+var errNoStore = errors.New("the relay does not store files, it has to be started with --store-dir")
+
+// dropPasscode returns the passcode of a stored file. The relay keeps the
+// ciphertext for as long as the file is stored, where it could be attacked
+// offline, so the passcode is twice as long as that of a session.
+func dropPasscode() (string, error) {
+	first, err := session.GeneratePasscode()
+	if err != nil {
+		return "", err
+	}
+	second, err := session.GeneratePasscode()
+	if err != nil {
+		return "", err
+	}
+	return first + "-" + second, nil
+}
+
+// runSendLater encrypts a file, or standard input for "-", and leaves it at
+// the relay for "orb send --later"
+func runSendLater(source string) error {
+	if sendKeep < 0 {
+		return errors.New("--keep cannot be negative")
+	}
+	var meta drop.Meta
+	var open func() (io.ReadCloser, error)
+	if source == "-" {
+		if term.IsTerminal(int(os.Stdin.Fd())) {
+			return errors.New("standard input is a terminal, pipe data into orb send -")
+		}
+		meta = drop.Meta{Name: filesystem.WireName(filepath.Base(filepath.Clean(sendName))), Size: -1}
+		used := false
+		open = func() (io.ReadCloser, error) {
+			// A pipe can only be read once, so there is no next relay
+			if used {
+				return nil, errors.New("standard input was read already")
+			}
+			used = true
+			return io.NopCloser(os.Stdin), nil
+		}
+	} else {
+		info, err := os.Stat(source)
+		if err != nil {
+			return fmt.Errorf("cannot send %s: %w", source, err)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("cannot send %s: not a regular file", source)
+		}
+		meta = drop.Meta{Name: filesystem.WireName(filepath.Base(source)), Size: info.Size()}
+		open = func() (io.ReadCloser, error) {
+			// #nosec G304 -- the user names the file to send
+			return os.Open(source)
+		}
+	}
+	if meta.Name == "." || meta.Name == ".." || meta.Name == string(filepath.Separator) {
+		return fmt.Errorf("invalid name %q", meta.Name)
+	}
+
+	passcode, err := dropPasscode()
+	if err != nil {
+		return err
+	}
+	sealer, err := drop.NewSealer(passcode)
+	if err != nil {
+		return err
+	}
+
+	relays := candidateRelays()
+	var errs []error
+	for _, relay := range relays {
+		src, err := open()
+		if err != nil {
+			errs = append(errs, err)
+			break
+		}
+		id, expires, err := storeDrop(relay, sealer, src, meta)
+		_ = src.Close()
+		if err == nil {
+			relayURL = relay
+			return printStoredDrop(meta, id, passcode, expires)
+		}
+		if len(relays) == 1 {
+			return err
+		}
+		slog.Warn("relay failed, trying the next one", "relay", relay, "err", err)
+		errs = append(errs, fmt.Errorf("%s: %w", relay, err))
+	}
+	return fmt.Errorf("no relay stored the file:\n%w", errors.Join(errs...))
+}
+
+// storeDrop uploads the encrypted file to a relay and returns its ID and
+// when the relay deletes it
+func storeDrop(relay string, sealer *drop.Sealer, src io.Reader, meta drop.Meta) (string, time.Time, error) {
+	progress := newProgressReporter(os.Stderr, progressBar)
+	defer progress.finish()
+	counted := &countingReader{r: src, progress: func(n int64) { progress.update(meta.Name, n, meta.Size, 0) }}
+
+	body, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(sealer.Seal(pw, counted, meta))
+	}()
+	defer func() { _ = body.Close() }()
+
+	target := strings.TrimSuffix(relay, "/") + "/drop"
+	if sendKeep > 0 {
+		target += "?ttl=" + strconv.FormatInt(int64(sendKeep.Seconds()), 10)
+	}
+	req, err := http.NewRequest(http.MethodPost, target, body)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid relay URL: %w", err)
+	}
+	req.ContentLength = drop.SealedSize(meta)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(dropTokenHeader, sealer.Token())
+	if relayToken != "" {
+		req.Header.Set("Authorization", "Bearer "+relayToken)
+	}
+
+	resp, err := relayHTTPClient(0).Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to store the file: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed:
+		return "", time.Time{}, errNoStore
+	case resp.StatusCode != http.StatusOK:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", time.Time{}, fmt.Errorf("relay error: %s", strings.TrimSpace(string(msg)))
+	}
+
+	var result struct {
+		ID        string    `json:"id"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return result.ID, result.ExpiresAt, nil
+}
+
+// printStoredDrop shows the code of a stored file
+func printStoredDrop(meta drop.Meta, id, passcode string, expires time.Time) error {
+	code := transferCode(id, passcode)
+	invite := fmt.Sprintf("orb receive %s --relay %s", code, relayURL)
+	if jsonOutput {
+		return printJSON(event{
+			Event:     "stored",
+			SessionID: id,
+			Passcode:  passcode,
+			Code:      code,
+			Relay:     relayURL,
+			Path:      meta.Name,
+			Size:      meta.Size,
+			Expires:   formatExpiry(expires),
+		})
+	}
+
+	fmt.Printf("\n")
+	fmt.Printf("  Stored:   %s, encrypted, at %s\n", meta.Name, relayChoice())
+	fmt.Printf("  Code:     %s\n", code)
+	fmt.Printf("  Expires:  %s (in %s)\n", expires.Local().Format("2006-01-02 15:04"), time.Until(expires).Round(time.Minute))
+	fmt.Printf("\n")
+	fmt.Printf("The receiver can pick it up until then, also when you are offline:\n")
+	fmt.Printf("  %s\n", invite)
+	if copyInvite {
+		if err := clipboard.Write(invite); err != nil {
+			slog.Warn("failed to copy to clipboard", "err", err)
+		} else {
+			fmt.Printf("Receive command copied to the clipboard.\n")
+		}
+	}
+	return nil
+}
+
+// receiveLater picks up a file stored with "orb send --later", decrypts it
+// and has the relay delete it
+func receiveLater(ctx context.Context, id, passcode string, toStdout bool) error {
+	body, relay, err := fetchDrop(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = body.Close() }()
+
+	r, err := drop.Open(body, passcode)
+	if err != nil {
+		return err
+	}
+	meta := r.Meta()
+	// The name comes from the sender, never let it point elsewhere
+	name := filepath.Base(filepath.Clean(meta.Name))
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return fmt.Errorf("sender stored an invalid file name %q", meta.Name)
+	}
+
+	progress := newProgressReporter(os.Stderr, progressBar)
+	counted := &countingReader{r: r, progress: func(n int64) { progress.update(name, n, meta.Size, 0) }}
+
+	if toStdout {
+		_, err := io.Copy(os.Stdout, counted)
+		progress.finish()
+		if err != nil {
+			return fmt.Errorf("%w, the output is incomplete", err)
+		}
+		deleteDrop(relay, id, r.Token())
+		fmt.Fprintf(os.Stderr, "✓ Received %s, decrypted and complete\n", name)
+		return nil
+	}
+
+	dir, err := resolveDownloadDir(receiveDir)
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(dir, filesystem.LocalName(name))
+	if receiveEncrypt {
+		target += encryptedSuffix
+	}
+	if err := confirmOverwrite(target); err != nil {
+		return err
+	}
+	if !jsonOutput {
+		if meta.Size >= 0 {
+			fmt.Printf("Receiving %s (%s)\n", name, formatBytes(meta.Size))
+		} else {
+			fmt.Printf("Receiving %s\n", name)
+		}
+	}
+
+	h := sha256.New()
+	partial := target + ".orb-partial"
+	var file io.WriteCloser
+	if receiveEncrypt {
+		file, err = createEncrypted(partial, name, meta.Size)
+	} else {
+		// #nosec G304 -- the target is chosen by the receiving user
+		file, err = os.OpenFile(partial, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	}
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(io.MultiWriter(file, h), counted)
+	progress.finish()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(partial)
+		return fmt.Errorf("%w, the download was discarded", err)
+	}
+	if err := os.Rename(partial, target); err != nil {
+		return fmt.Errorf("failed to save %s: %w", name, err)
+	}
+	deleteDrop(relay, id, r.Token())
+
+	if jsonOutput {
+		if err := printJSON(event{Event: "received", Path: target, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}); err != nil {
+			return err
+		}
+	} else if receiveEncrypt {
+		fmt.Printf("✓ Received %s, complete, saved encrypted as %s\n", name, filepath.Base(target))
+	} else {
+		fmt.Printf("✓ Received %s, decrypted and complete\n", name)
+	}
+	if wantsExtract() {
+		return extractArchive(ctx, target)
+	}
+	return nil
+}
+
+// fetchDrop starts the download of a stored file from the first relay that
+// has it
+func fetchDrop(ctx context.Context, id string) (io.ReadCloser, string, error) {
+	relays := candidateRelays()
+	var errs []error
+	for _, relay := range relays {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(relay, "/")+"/drop/"+id, nil)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid relay URL: %w", err)
+		}
+		if relayToken != "" {
+			req.Header.Set("Authorization", "Bearer "+relayToken)
+		}
+		resp, err := relayHTTPClient(0).Do(req)
+		if err == nil && resp.StatusCode == http.StatusOK {
+			return resp.Body, relay, nil
+		}
+		if err == nil {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			_ = resp.Body.Close()
+			err = errors.New(strings.TrimSpace(string(msg)))
+			if resp.StatusCode == http.StatusNotFound && !strings.Contains(string(msg), "stored file") {
+				err = errNoStore
+			}
+		}
+		if len(relays) == 1 {
+			return nil, "", err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", relay, err))
+	}
+	return nil, "", fmt.Errorf("no relay has the file:\n%w", errors.Join(errs...))
+}
+
+// deleteDrop has the relay delete a file that was picked up. It expires
+// anyway, so failing is only logged.
+func deleteDrop(relay, id, token string) {
+	req, err := http.NewRequest(http.MethodDelete, strings.TrimSuffix(relay, "/")+"/drop/"+id, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set(dropTokenHeader, token)
+	if relayToken != "" {
+		req.Header.Set("Authorization", "Bearer "+relayToken)
+	}
+	resp, err := relayHTTPClient(10 * time.Second).Do(req)
+	if err != nil {
+		slog.Warn("failed to delete the stored file, the relay deletes it when it expires", "err", err)
+		return
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		slog.Warn("failed to delete the stored file, the relay deletes it when it expires", "status", resp.Status)
+	}
+}
+
+// countingReader reports how much was read through it
+type countingReader struct {
+	r        io.Reader
+	n        int64
+	progress func(int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if n > 0 || errors.Is(err, io.EOF) {
+		c.progress(c.n)
+	}
+	return n, err
+}
@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/tunnel"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+	"golang.org/x/term"
+)
+
+// maxStreamRead bounds a single read of a stream, well below the frame limit
+const maxStreamRead = 512 * 1024
+
+// stream serves a pipe as a file that can be read once, from start to end.
+// Its size is only known once the pipe has been read to the end.
+type stream struct {
+	name    string
+	r       io.Reader
+	pos     int64
+	eof     bool
+	sum     hash.Hash
+	started time.Time
+}
+
+func newStream(name string, r io.Reader) *stream {
+	return &stream{name: name, r: r, sum: sha256.New(), started: time.Now()}
+}
+
+// runSendStream offers standard input to one receiver, for "orb send -"
+func runSendStream() error {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return errors.New("standard input is a terminal, pipe data into orb send -")
+	}
+
+	name := filepath.Base(filepath.Clean(sendName))
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return fmt.Errorf("invalid name %q", sendName)
+	}
+	s := newStream(name, os.Stdin)
+
+	sessionID, passcode, err := createSessionOnAnyRelay("-", 0)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	defer endSession(sessionID, passcode)()
+	code := transferCode(sessionID, passcode)
+	invite := fmt.Sprintf("orb receive %s --relay %s", code, relayURL)
+
+	if jsonOutput {
+		if err := printJSON(event{
+			Event:     "session",
+			SessionID: sessionID,
+			Passcode:  passcode,
+			Code:      code,
+			Relay:     relayURL,
+			Path:      name,
+		}); err != nil {
+			return err
+		}
+	} else {
+		printSendSession(name, -1, code, invite)
+	}
+
+	// Sharer is the responder (waits for connector to initiate handshake)
+	tun, err := tunnel.NewTunnel(relayURL, sessionID, passcode, false, relayDialOptions()...)
+	if err != nil {
+		return fmt.Errorf("failed to establish tunnel: %w", err)
+	}
+	defer func() { _ = tun.Close() }()
+
+	if jsonOutput {
+		if err := printJSON(event{Event: "connected"}); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("✓ Connected, sending...\n")
+	}
+
+	if err := s.serve(tun); err != nil {
+		return err
+	}
+	if !s.eof {
+		return errors.New("the receiver disconnected before the download finished")
+	}
+
+	if jsonOutput {
+		return printJSON(event{Event: "sent", Path: name, Size: s.pos})
+	}
+	fmt.Printf("✓ Sent %s (%s)\n", name, formatBytes(s.pos))
+	return nil
+}
+
+// serve answers requests until the receiver leaves. Requests are handled one
+// at a time since the stream can only be read in order anyway.
+func (s *stream) serve(tun *tunnel.Tunnel) error {
+	for {
+		frame, err := tun.ReceiveFrame()
+		if err != nil {
+			if tun.IsClosed() {
+				return nil
+			}
+			slog.Warn("failed to receive frame", "err", err)
+			continue
+		}
+		if frame.Type == protocol.FrameTypeCancel {
+			continue
+		}
+
+		response := s.handle(frame)
+		response.ID = frame.ID
+		if err := tun.SendFrame(response); err != nil {
+			return fmt.Errorf("failed to send response: %w", err)
+		}
+	}
+}
+
+func (s *stream) handle(frame *protocol.Frame) *protocol.Frame {
+	switch frame.Type {
+	case protocol.FrameTypePing:
+		return &protocol.Frame{Type: protocol.FrameTypePong, Payload: []byte{}}
+	case protocol.FrameTypeInfo:
+		return responseFrame(&protocol.InfoResponse{ReadOnly: true, File: s.name, Stream: true})
+	case protocol.FrameTypeStat:
+		var req protocol.StatRequest
+		if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+			return errorFrame(protocol.ErrCodeUnknown, err.Error())
+		}
+		if !s.is(req.Path) {
+			return errorFrame(protocol.ErrCodeNotFound, "file not found")
+		}
+		return responseFrame(&protocol.StatResponse{Info: protocol.FileInfo{
+			Name:    s.name,
+			Size:    s.pos,
+			Mode:    0644,
+			ModTime: s.started.Unix(),
+		}})
+	case protocol.FrameTypeRead:
+		var req protocol.ReadRequest
+		if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+			return errorFrame(protocol.ErrCodeUnknown, err.Error())
+		}
+		return s.read(req)
+	case protocol.FrameTypeHash:
+		var req protocol.HashRequest
+		if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+			return errorFrame(protocol.ErrCodeUnknown, err.Error())
+		}
+		if !s.is(req.Path) {
+			return errorFrame(protocol.ErrCodeNotFound, "file not found")
+		}
+		if !s.eof {
+			return errorFrame(protocol.ErrCodeIO, "the stream has not been read to the end")
+		}
+		if req.Length > 0 {
+			return errorFrame(protocol.ErrCodeIO, "a stream cannot be resumed")
+		}
+		return responseFrame(&protocol.HashResponse{SHA256: s.sum.Sum(nil)})
+	default:
+		return errorFrame(protocol.ErrCodePermission, "a stream can only be read")
+	}
+}
+
+// read returns the next part of the stream, or no data at its end
+func (s *stream) read(req protocol.ReadRequest) *protocol.Frame {
+	if !s.is(req.Path) {
+		return errorFrame(protocol.ErrCodeNotFound, "file not found")
+	}
+	if req.Offset != s.pos {
+		return errorFrame(protocol.ErrCodeIO, "a stream can only be read once, from start to end")
+	}
+
+	length := req.Length
+	if length <= 0 || length > maxStreamRead {
+		length = maxStreamRead
+	}
+	buf := make([]byte, length)
+
+	n := 0
+	if !s.eof {
+		var err error
+		n, err = io.ReadFull(s.r, buf)
+		switch {
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			s.eof = true
+		case err != nil:
+			return errorFrame(protocol.ErrCodeIO, err.Error())
+		}
+	}
+
+	s.sum.Write(buf[:n])
+	s.pos += int64(n)
+	return responseFrame(&protocol.ReadResponse{Data: buf[:n]})
+}
+
+// is reports whether path names the stream
+func (s *stream) is(path string) bool {
+	return path == "/"+s.name || path == s.name
+}
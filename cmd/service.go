@@ -0,0 +1,214 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"github.com/Zayan-Mohamed/orb/internal/service"
+	"github.com/spf13/cobra"
+)
+
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Run a relay, share or daemon as a system service",
+	Long: `Install a relay, share or daemon as a service of the operating system, so that it
+starts at boot and restarts when it exits. Orb writes a systemd unit on Linux,
+a launchd agent on macOS and registers a service on Windows.`,
+}
+
+var serviceInstallCmd = &cobra.Command{
+	Use:   "install <relay|share|daemon> [args...]",
+	Short: "Install and start a relay, share or daemon service",
+	Long: `Install a service running "orb relay", "orb share" or "orb daemon" with the
+given arguments, then enable and start it. Flags after the command name are
+passed on to that command, e.g.
+
+  orb service install relay --listen :9000 --token secret
+  orb service install share ~/Public --readonly --relay https://relay.example.com
+  orb service install daemon --relay https://relay.example.com
+
+A share service creates a new session each time a receiver disconnects;
+its session ID and passcode appear in the service's logs.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runServiceInstall,
+}
+
+var serviceUninstallCmd = &cobra.Command{
+	Use:   "uninstall <name>",
+	Short: "Stop and remove a service",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runServiceUninstall,
+}
+
+var (
+	serviceName   string
+	serviceSystem bool
+	serviceDryRun bool
+)
+
+func init() {
+	rootCmd.AddCommand(serviceCmd)
+	serviceCmd.AddCommand(serviceInstallCmd, serviceUninstallCmd)
+
+	serviceCmd.PersistentFlags().BoolVar(&serviceSystem, "system", false, "Use a system-wide systemd unit instead of a user unit (needs root)")
+	serviceInstallCmd.Flags().StringVar(&serviceName, "name", "", "Service name (default orb-relay, orb-daemon or orb-share-<dir>)")
+	serviceInstallCmd.Flags().BoolVar(&serviceDryRun, "dry-run", false, "Print the service definition instead of installing it")
+	// Flags after relay, share or daemon belong to that command
+	serviceInstallCmd.Flags().SetInterspersed(false)
+}
+
+func runServiceInstall(cmd *cobra.Command, args []string) error {
+	spec, err := serviceSpec(args[0], args[1:])
+	if err != nil {
+		return err
+	}
+	opts := service.Options{System: serviceSystem}
+
+	if serviceDryRun {
+		path, content, err := service.Preview(spec, opts)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("# %s\n%s", path, content)
+		return nil
+	}
+
+	if err := service.Install(spec, opts); err != nil {
+		return err
+	}
+
+	logs := service.Logs(spec.Name, opts)
+	if jsonOutput {
+		return printJSON(event{Event: "installed", Service: spec.Name, Logs: logs})
+	}
+	fmt.Printf("✓ Installed and started %s\n", spec.Name)
+	if logs != "" {
+		fmt.Printf("  Logs: %s\n", logs)
+	}
+	if runtime.GOOS == "linux" && !serviceSystem {
+		fmt.Printf("\nUser services stop when you log out. To keep it running, run:\n")
+		fmt.Printf("  loginctl enable-linger %s\n", os.Getenv("USER"))
+	}
+	fmt.Printf("\nRemove it with: orb service uninstall %s\n", spec.Name)
+	return nil
+}
+
+func runServiceUninstall(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	if err := service.Uninstall(name, service.Options{System: serviceSystem}); err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(event{Event: "uninstalled", Service: name})
+	}
+	fmt.Printf("✓ Removed %s\n", name)
+	return nil
+}
+
+// serviceSpec builds the service running "orb <kind> args...". The arguments
+// are checked against the command's flags now rather than when the service
+// first starts.
+func serviceSpec(kind string, args []string) (service.Spec, error) {
+	var target *cobra.Command
+	switch kind {
+	case "relay":
+		target = relayCmd
+	case "share":
+		target = shareCmd
+	case "daemon":
+		target = daemonCmd
+	default:
+		return service.Spec{}, fmt.Errorf("unknown service %q, use relay, share or daemon", kind)
+	}
+
+	if err := target.ParseFlags(args); err != nil {
+		return service.Spec{}, fmt.Errorf("orb %s: %w", kind, err)
+	}
+	if err := target.ValidateArgs(target.Flags().Args()); err != nil {
+		return service.Spec{}, fmt.Errorf("orb %s: %w", kind, err)
+	}
+
+	spec := service.Spec{Name: serviceName}
+	switch kind {
+	case "relay":
+		if spec.Name == "" {
+			spec.Name = "orb-relay"
+		}
+		spec.Description = "Orb relay server"
+		spec.Args = append([]string{"relay"}, args...)
+
+	case "daemon":
+		if spec.Name == "" {
+			spec.Name = "orb-daemon"
+		}
+		spec.Description = "Orb control API"
+		spec.Args = append([]string{"daemon"}, args...)
+
+	case "share":
+		for _, name := range []string{"daemon", "dashboard"} {
+			if target.Flags().Changed(name) {
+				return service.Spec{}, fmt.Errorf("--%s cannot be used in a service", name)
+			}
+		}
+		// The share paths have to come first so that they can be made
+		// absolute; services do not start in the current directory
+		paths := slices.Clone(target.Flags().Args())
+		if len(args) < len(paths) || !slices.Equal(args[:len(paths)], paths) {
+			return service.Spec{}, errors.New("give the share paths before any flags")
+		}
+		for i, p := range paths {
+			path, err := config.ExpandHome(p)
+			if err != nil {
+				return service.Spec{}, err
+			}
+			path, err = filepath.Abs(path)
+			if err != nil {
+				return service.Spec{}, fmt.Errorf("invalid path: %w", err)
+			}
+			if info, err := os.Stat(path); err != nil || !info.IsDir() {
+				return service.Spec{}, fmt.Errorf("%s is not a directory", path)
+			}
+			paths[i] = path
+		}
+		if spec.Name == "" {
+			spec.Name = "orb-share-" + serviceSlug(filepath.Base(paths[0]))
+		}
+		spec.Description = "Orb share of " + strings.Join(paths, ", ")
+		spec.Args = append(append([]string{"share"}, paths...), args[len(paths):]...)
+		spec.Restart = true
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return service.Spec{}, fmt.Errorf("failed to locate the orb executable: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	spec.Executable = exe
+	return spec, nil
+}
+
+// serviceSlug turns a directory name into something usable in a service name
+func serviceSlug(s string) string {
+	slug := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, s)
+	slug = strings.Trim(slug, "-")
+	if slug == "" {
+		return "root"
+	}
+	return slug
+}
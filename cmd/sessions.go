@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/daemon"
+	"github.com/spf13/cobra"
+)
+
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "Manage the shares running on this machine",
+	Long: `List and revoke the sessions of the shares running on this machine, in a
+terminal or in the background. Without a subcommand the sessions are listed.`,
+	Args: cobra.NoArgs,
+	RunE: runSessionsList,
+}
+
+var sessionsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the shares running on this machine",
+	Args:  cobra.NoArgs,
+	RunE:  runSessionsList,
+}
+
+var sessionsRevokeCmd = &cobra.Command{
+	Use:   "revoke <session-id>...",
+	Short: "End sessions and disconnect their receivers",
+	Long: `Stop the shares serving the given sessions. Their receivers are disconnected
+and the sessions are revoked at the relay, so the credentials stop working.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runSessionsRevoke,
+}
+
+func init() {
+	rootCmd.AddCommand(sessionsCmd)
+	sessionsCmd.AddCommand(sessionsListCmd, sessionsRevokeCmd)
+}
+
+func runSessionsList(cmd *cobra.Command, args []string) error {
+	statuses, err := runningShares(false)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		return printJSON(statuses)
+	}
+
+	if len(statuses) == 0 {
+		fmt.Println("No shares are running on this machine.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SESSION\tPID\tMODE\tPEERS\tAGE\tPATH")
+	for _, s := range statuses {
+		mode := "foreground"
+		if s.Background {
+			mode = "background"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%s\t%s\n",
+			s.SessionID, s.PID, mode, s.Peers, time.Since(s.Started).Round(time.Second), s.Path)
+	}
+	return w.Flush()
+}
+
+func runSessionsRevoke(cmd *cobra.Command, args []string) error {
+	for _, id := range args {
+		if err := daemon.Stop(id); err != nil {
+			if errors.Is(err, daemon.ErrNotFound) {
+				return fmt.Errorf("%s: %w, see orb sessions", id, err)
+			}
+			return fmt.Errorf("%s: %w", id, err)
+		}
+
+		if jsonOutput {
+			if err := printJSON(event{Event: "revoked", SessionID: id}); err != nil {
+				return err
+			}
+		} else {
+			fmt.Printf("✓ Revoked %s\n", id)
+		}
+	}
+	return nil
+}
@@ -2,107 +2,530 @@ package cmd
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/gob"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"maps"
+	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/Zayan-Mohamed/orb/internal/clipboard"
 	"github.com/Zayan-Mohamed/orb/internal/filesystem"
+	"github.com/Zayan-Mohamed/orb/internal/forward"
+	"github.com/Zayan-Mohamed/orb/internal/hooks"
+	"github.com/Zayan-Mohamed/orb/internal/manifest"
+	"github.com/Zayan-Mohamed/orb/internal/metrics"
+	"github.com/Zayan-Mohamed/orb/internal/monitor"
+	"github.com/Zayan-Mohamed/orb/internal/session"
+	"github.com/Zayan-Mohamed/orb/internal/telemetry"
 	"github.com/Zayan-Mohamed/orb/internal/tunnel"
 	"github.com/Zayan-Mohamed/orb/pkg/protocol"
 	"github.com/spf13/cobra"
 )
 
 var shareCmd = &cobra.Command{
-	Use:   "share <path>",
+	Use:   "share <path>...",
 	Short: "Share a local directory",
-	Long:  `Share a local directory over an encrypted tunnel. Creates a session ID and passcode.`,
-	Args:  cobra.ExactArgs(1),
-	RunE:  runShare,
+	Long: `Share a local directory over an encrypted tunnel. Creates a session ID and passcode.
+
+Given several directories, the receiver sees each of them as a top-level
+directory named after it, e.g. "orb share ~/docs ~/photos" shares /docs and
+/photos.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runShare,
 }
 
 var (
-	relayURL string
-	readOnly bool
+	relayURL   string
+	readOnly   bool
+	copyInvite bool
+	dashboard  bool
+	includes   []string
+	excludes   []string
+	bwLimit    byteSize
+	daemonMode bool
+
+	// shareExpire is --expire, and shareExpiresAt the moment the share ends
+	// because of it
+	shareExpire    time.Duration
+	shareExpiresAt time.Time
+
+	// shareMaxDownloads is --max-downloads and shareConfirmWrites
+	// --confirm-writes, applied through shareControl
+	shareMaxDownloads  int
+	shareConfirmWrites bool
+	shareControl       shareControls
+
+	// shareAllowForward is --allow-forward
+	shareAllowForward []string
+
+	// shareIndex is --index
+	shareIndex bool
+
+	// shareManifestFlag is --manifest, and shareIdentity the fingerprint of
+	// the key the manifest is signed with
+	shareManifestFlag bool
+	shareIdentity     string
 )
 
+// errShareExpired ends a share once --expire has elapsed
+var errShareExpired = errors.New("session expired")
+
 func init() {
 	rootCmd.AddCommand(shareCmd)
-	shareCmd.Flags().StringVar(&relayURL, "relay", "http://localhost:8080", "Relay server URL")
+	addRelayFlags(shareCmd)
 	shareCmd.Flags().BoolVar(&readOnly, "readonly", false, "Share folder in read-only mode")
+	shareCmd.Flags().BoolVar(&copyInvite, "copy", false, "Copy the connect command with session ID and passcode to the clipboard")
+	shareCmd.Flags().BoolVar(&dashboard, "dashboard", false, "Show a live dashboard of connected peers and served requests")
+	shareCmd.Flags().BoolVar(&daemonMode, "daemon", false, "Keep sharing in the background after the command returns")
+	shareCmd.Flags().DurationVar(&shareExpire, "expire", 0, "End the session after this long, e.g. 30m or 2h")
+	shareCmd.Flags().IntVar(&shareMaxDownloads, "max-downloads", 0, "End the session after this many complete file downloads")
+	shareCmd.Flags().BoolVar(&shareConfirmWrites, "confirm-writes", false, "Ask before carrying out each change the receiver makes")
+	shareCmd.Flags().Var(&bwLimit, "bwlimit", "Limit bandwidth in each direction, e.g. 500K or 2M per second")
+	shareCmd.Flags().StringArrayVar(&includes, "include", nil, "Only share files matching this glob (repeatable)")
+	shareCmd.Flags().StringArrayVar(&excludes, "exclude", nil, "Hide files and directories matching this glob (repeatable)")
+	shareCmd.Flags().StringArrayVar(&shareAllowForward, "allow-forward", nil, "Let the receiver forward ports to this host:port with connect --forward (repeatable)")
+	shareCmd.Flags().BoolVar(&shareIndex, "index", false, "Index file names in the background so that searches of large shares return at once")
+	shareCmd.Flags().BoolVar(&shareManifestFlag, "manifest", false, "Offer receivers a list of the shared files with their checksums, signed with this machine's identity key")
+	addMetricsFlag(shareCmd)
+	addReportFlag(shareCmd)
 }
 
 func runShare(cmd *cobra.Command, args []string) error {
-	sharePath := args[0]
+	// Validate paths exist
+	absPaths := make([]string, 0, len(args))
+	for _, sharePath := range args {
+		p, err := filepath.Abs(sharePath)
+		if err != nil {
+			return fmt.Errorf("invalid path: %w", err)
+		}
 
-	// Validate path exists
-	absPath, err := filepath.Abs(sharePath)
+		info, err := os.Stat(p)
+		if err != nil {
+			return fmt.Errorf("path does not exist: %w", err)
+		}
+
+		if !info.IsDir() {
+			return fmt.Errorf("%s: path must be a directory", sharePath)
+		}
+		absPaths = append(absPaths, p)
+	}
+	// absPath describes the share in session details and orb status
+	absPath := strings.Join(absPaths, ", ")
+
+	filter, err := filesystem.NewFilter(includes, excludes)
 	if err != nil {
-		return fmt.Errorf("invalid path: %w", err)
+		return err
 	}
 
-	info, err := os.Stat(absPath)
+	if dashboard && jsonOutput {
+		return fmt.Errorf("--dashboard cannot be combined with --json")
+	}
+	if dashboard && daemonMode {
+		return fmt.Errorf("--dashboard cannot be combined with --daemon")
+	}
+	if shareExpire < 0 || shareExpire > session.SessionTimeout {
+		return fmt.Errorf("--expire must be between 0 and %s, the longest a relay keeps a session", session.SessionTimeout)
+	}
+	if err := checkReportFile(); err != nil {
+		return err
+	}
+	if shareMaxDownloads < 0 {
+		return fmt.Errorf("--max-downloads cannot be negative")
+	}
+	if shareConfirmWrites {
+		switch {
+		case readOnly:
+			return fmt.Errorf("--confirm-writes cannot be combined with --readonly, which refuses all changes")
+		case jsonOutput, dashboard, daemonMode:
+			return fmt.Errorf("--confirm-writes asks on the terminal and cannot be combined with --json, --dashboard or --daemon")
+		}
+		shareControl.writes = newWriteApproval(os.Stdin, os.Stderr)
+	}
+	for _, addr := range shareAllowForward {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("invalid --allow-forward address %q, expected host:port", addr)
+		}
+	}
+	shareControl.forwards = shareAllowForward
+	runner, err := hooks.New(configHooks)
+	if err != nil {
+		return fmt.Errorf("invalid hooks in config.yaml: %w", err)
+	}
+	defer runner.Close()
+	var identity ed25519.PrivateKey
+	if shareManifestFlag {
+		if identity, err = manifest.LoadIdentity(); err != nil {
+			return err
+		}
+		shareIdentity = manifest.Fingerprint(identity.Public().(ed25519.PublicKey))
+	}
+
+	// Initialize secure filesystem
+	var secureFS *filesystem.SecureFilesystem
+	if len(absPaths) == 1 {
+		secureFS, err = filesystem.NewSecureFilesystem(absPaths[0], readOnly)
+	} else {
+		secureFS, err = filesystem.NewMultiRootFilesystem(absPaths, readOnly)
+	}
 	if err != nil {
-		return fmt.Errorf("path does not exist: %w", err)
+		return fmt.Errorf("failed to initialize filesystem: %w", err)
+	}
+	if !filter.IsEmpty() {
+		secureFS.SetFilter(filter)
 	}
+	recoverTransactions(secureFS)
 
-	if !info.IsDir() {
-		return fmt.Errorf("path must be a directory")
+	// The background process of --daemon serves the session created by
+	// the foreground one
+	sessionID, passcode, background := daemonSession()
+	if !background {
+		sessionID, passcode, err = createSessionOnAnyRelay(absPath, shareExpire)
+		if err != nil {
+			return fmt.Errorf("failed to create session: %w", err)
+		}
+		if shareExpire > 0 {
+			shareExpiresAt = time.Now().Add(shareExpire)
+		}
+		if daemonMode {
+			return startShareDaemon(sessionID, passcode, absPath, secureFS.RootNames())
+		}
 	}
 
-	// Create session with relay
-	sessionID, passcode, err := createSession(relayURL, absPath)
+	// Leaving revokes the session, so that it cannot be joined once orb is
+	// gone, and Ctrl+C or SIGTERM leave right away
+	end := endSession(sessionID, passcode)
+	defer end()
+	// Only the process serving the share serves its metrics, for --daemon
+	// the background one
+	stopMetrics, err := serveMetrics()
 	if err != nil {
-		return fmt.Errorf("failed to create session: %w", err)
+		return err
+	}
+	defer stopMetrics()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if !shareExpiresAt.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadlineCause(ctx, shareExpiresAt, errShareExpired)
+		defer cancel()
+	}
+	// The report is written once the session ended, after what is said
+	// about how it ended
+	report := newSessionReport("share", sessionID, absPath)
+	defer report.write()
+	shareControl.report = report
+	shareControl.hooks = newShareHooks(runner, report, sessionID, secureFS)
+	if shareMaxDownloads > 0 {
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		shareControl.downloads = newDownloadLimit(shareMaxDownloads, func() { cancel(errDownloadLimit) })
+	} else if shareControl.hooks.wants(hooks.FileDownloaded) {
+		// Downloads are only counted for the hooks and the report
+		shareControl.downloads = newDownloadLimit(0, nil)
+	}
+	if downloads := shareControl.downloads; downloads != nil && shareControl.hooks != nil {
+		downloads.completed = func(p string, size int64) {
+			shareControl.hooks.downloaded(p, size)
+			if downloads.Reached() {
+				shareControl.hooks.quota(hooks.Event{Files: downloads.Count(), Text: errDownloadLimit.Error()})
+			}
+		}
+	}
+	if shareIndex {
+		go buildSearchIndex(ctx, secureFS)
+	}
+	if identity != nil {
+		shareControl.manifest = newShareManifest(ctx, sessionID, secureFS, identity)
 	}
 
-	// Display session info
-	fmt.Printf("\n")
-	fmt.Printf("╔════════════════════════════════════════╗\n")
-	fmt.Printf("║     Orb - Secure Folder Sharing       ║\n")
-	fmt.Printf("╚════════════════════════════════════════╝\n")
-	fmt.Printf("\n")
-	fmt.Printf("  Session:  %s\n", sessionID)
-	fmt.Printf("  Passcode: %s\n", passcode)
-	fmt.Printf("\n")
-	fmt.Printf("Share these credentials with the receiver.\n")
-	fmt.Printf("Waiting for connection...\n")
-	fmt.Printf("\n")
+	// Display session info, a background share has shown it already
+	if !background {
+		if jsonOutput {
+			if err := printJSON(event{Event: "session", SessionID: sessionID, Passcode: passcode, Relay: relayURL, Path: absPath, Expires: formatExpiry(shareExpiresAt), Identity: shareIdentity}); err != nil {
+				return err
+			}
+		} else {
+			printShareSession(sessionID, passcode, secureFS.RootNames())
+		}
+	}
 
-	// Initialize secure filesystem
-	secureFS, err := filesystem.NewSecureFilesystem(absPath, readOnly)
+	// Register the share so that orb sessions can find it, and orb status
+	// and orb stop when it runs in the background
+	mon := monitor.New()
+	shareControl.chat = newShareChat(mon)
+	server, err := registerShare(sessionID, absPath, secureFS.IsReadOnly(), background, mon, stop)
 	if err != nil {
-		return fmt.Errorf("failed to initialize filesystem: %w", err)
+		if background {
+			return err
+		}
+		slog.Warn("failed to register share, orb sessions will not list it", "err", err)
+	} else {
+		defer func() { _ = server.Close() }()
 	}
 
-	// Connect to relay and establish tunnel
+	if dashboard {
+		return runShareDashboard(ctx, sessionID, passcode, absPath, secureFS, mon)
+	}
+	if background {
+		return runShareDaemon(ctx, sessionID, passcode, absPath, secureFS, mon)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- serveShare(ctx, sessionID, passcode, secureFS, mon)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Revoking the session disconnects the receiver. Give requests being
+	// served a moment to finish.
+	end()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+	}
+	expired := errors.Is(context.Cause(ctx), errShareExpired)
+	limited := errors.Is(context.Cause(ctx), errDownloadLimit)
+	if jsonOutput {
+		switch {
+		case expired:
+			return printJSON(event{Event: "expired", SessionID: sessionID})
+		case limited:
+			return printJSON(event{Event: "limit_reached", SessionID: sessionID, Files: shareControl.downloads.Count()})
+		}
+		return printJSON(event{Event: "stopped", SessionID: sessionID})
+	}
+	switch {
+	case expired:
+		fmt.Printf("\nSession %s expired after %s, sharing stopped.\n", sessionID, shareExpire)
+		return nil
+	case limited:
+		fmt.Printf("\nSession %s reached its download limit (%d), sharing stopped.\n", sessionID, shareMaxDownloads)
+		return nil
+	}
+	fmt.Printf("\nSharing stopped, session %s revoked.\n", sessionID)
+	return nil
+}
+
+// stopReason tells the receiver why the share ended, or is empty when it
+// ended for no particular reason
+func stopReason(ctx context.Context) string {
+	if cause := context.Cause(ctx); errors.Is(cause, errShareExpired) || errors.Is(cause, errDownloadLimit) {
+		return cause.Error()
+	}
+	return ""
+}
+
+// formatExpiry formats the end of a share for JSON, empty when it has none
+func formatExpiry(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// serveShare waits for the receiver and serves it until it leaves or ctx
+// is cancelled
+func serveShare(ctx context.Context, sessionID, passcode string, fs *filesystem.SecureFilesystem, mon *monitor.Monitor) error {
+	err := serveReceiver(ctx, sessionID, passcode, fs, mon, func() error {
+		if jsonOutput {
+			return printJSON(event{Event: "connected"})
+		}
+		printShareConnected(sessionID)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	if jsonOutput {
+		return printJSON(event{Event: "disconnected"})
+	}
+	fmt.Printf("Receiver disconnected, session ended.\n")
+	return nil
+}
+
+// serveReceiver connects to the relay as the sharer of the session, calls
+// connected, if set, once the receiver is there and serves it until it
+// leaves. Cancelling ctx disconnects the receiver, telling it why with
+// stopReason. Shares in the foreground, in the background and with the
+// dashboard all serve their receiver this way.
+func serveReceiver(ctx context.Context, sessionID, passcode string, fs *filesystem.SecureFilesystem, mon *monitor.Monitor, connected func() error) error {
 	// Sharer is the responder (waits for connector to initiate handshake)
-	tun, err := tunnel.NewTunnel(relayURL, sessionID, passcode, false)
+	tun, err := tunnel.NewTunnel(relayURL, sessionID, passcode, false,
+		relayDialOptions(tunnel.WithBandwidthLimit(int64(bwLimit)))...)
 	if err != nil {
 		return fmt.Errorf("failed to establish tunnel: %w", err)
 	}
 	defer func() {
 		if err := tun.Close(); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to close tunnel: %v\n", err)
+			slog.Warn("failed to close tunnel", "err", err)
 		}
 	}()
 
+	// Closing the tunnel sends the receiver a close frame
+	go func() {
+		<-ctx.Done()
+		_ = tun.CloseWithReason(stopReason(ctx))
+	}()
+
+	if connected != nil {
+		if err := connected(); err != nil {
+			return err
+		}
+	}
+
+	// Handle requests until the receiver leaves
+	peer := mon.AddPeer("receiver", func() { _ = tun.Close() })
+	defer mon.RemovePeer(peer)
+	return handleShareRequests(tun, fs, mon, peer, shareControl)
+}
+
+// printShareSession shows the credentials of a new share, and where the
+// top-level directories of a share of several directories come from
+func printShareSession(sessionID, passcode string, roots map[string]string) {
+	fmt.Printf("\n")
+	fmt.Printf("╔════════════════════════════════════════╗\n")
+	fmt.Printf("║     Orb - Secure Folder Sharing       ║\n")
+	fmt.Printf("╚════════════════════════════════════════╝\n")
+	fmt.Printf("\n")
+	fmt.Printf("  Session:  %s\n", sessionID)
+	fmt.Printf("  Passcode: %s\n", passcode)
+	if link := browserLink(sessionID); link != "" {
+		fmt.Printf("  Browser:  %s\n", link)
+	}
+	if len(relayURLs) > 1 {
+		// Receivers go straight to the relay the session is on
+		fmt.Printf("  Relay:    %s\n", relayChoice())
+		fmt.Printf("  Connect:  orb connect %s --relay %s\n", sessionID, relayURL)
+	}
+	if !shareExpiresAt.IsZero() {
+		fmt.Printf("  Expires:  %s (in %s)\n", shareExpiresAt.Format("15:04:05"), time.Until(shareExpiresAt).Round(time.Second))
+	}
+	if shareIdentity != "" {
+		fmt.Printf("  Identity: %s\n", shareIdentity)
+	}
+	if shareMaxDownloads == 1 {
+		fmt.Printf("  Limit:    1 download, then the session ends\n")
+	} else if shareMaxDownloads > 1 {
+		fmt.Printf("  Limit:    %d downloads, then the session ends\n", shareMaxDownloads)
+	}
+	if len(roots) > 0 {
+		fmt.Printf("\n")
+		for _, name := range slices.Sorted(maps.Keys(roots)) {
+			fmt.Printf("  /%-15s %s\n", name, roots[name])
+		}
+	}
+	fmt.Printf("\n")
+	fmt.Printf("Share these credentials with the receiver.\n")
+	if copyInvite {
+		invite := fmt.Sprintf("orb connect %s --passcode %s --relay %s", sessionID, passcode, relayURL)
+		if err := clipboard.Write(invite); err != nil {
+			slog.Warn("failed to copy to clipboard", "err", err)
+		} else {
+			fmt.Printf("Connect command copied to the clipboard.\n")
+		}
+	}
+	fmt.Printf("Waiting for connection...\n")
+	fmt.Printf("\n")
+}
+
+// printShareConnected describes the share once the receiver is connected
+func printShareConnected(sessionID string) {
 	fmt.Printf("✓ Connected! Tunnel established.\n")
-	if readOnly {
+	switch {
+	case readOnly:
 		fmt.Printf("  Mode: Read-only\n")
-	} else {
+	case shareConfirmWrites:
+		fmt.Printf("  Mode: Read-write, each change needs your approval\n")
+	default:
 		fmt.Printf("  Mode: Read-write\n")
 	}
+	if bwLimit > 0 {
+		fmt.Printf("  Bandwidth limit: %s/s\n", formatBytes(int64(bwLimit)))
+	}
+	if len(includes) > 0 {
+		fmt.Printf("  Including: %s\n", strings.Join(includes, ", "))
+	}
+	if len(excludes) > 0 {
+		fmt.Printf("  Excluding: %s\n", strings.Join(excludes, ", "))
+	}
+	if len(shareAllowForward) > 0 {
+		fmt.Printf("  Forwarding allowed to: %s\n", strings.Join(shareAllowForward, ", "))
+	}
 	fmt.Printf("\n")
+	fmt.Printf("Message the receiver with \"orb msg %s <text>\".\n", sessionID)
 	fmt.Printf("Press Ctrl+C to stop sharing.\n")
 	fmt.Printf("\n")
+}
+
+// maxConcurrentRequests bounds how many requests a sharer serves at once
+const maxConcurrentRequests = 16
 
-	// Handle requests
-	return handleShareRequests(tun, secureFS)
+// errCancelled answers requests the receiver gave up on, which nobody reads
+const errCancelled = "cancelled by the receiver"
+
+// Metrics of the requests a share serves, see --metrics-addr
+var (
+	requestsServed = metrics.NewCounter("orb_requests_served_total", "Requests of receivers served, by type and result", "type", "result")
+	requestSeconds = metrics.NewHistogram("orb_request_duration_seconds", "Time taken to serve a request, including sending the response", metrics.LatencyBuckets, "type")
+)
+
+// shareControls are the optional checks a share applies to the requests it
+// serves. The zero value serves everything.
+type shareControls struct {
+	downloads *downloadLimit // --max-downloads
+	writes    *writeApproval // --confirm-writes
+	chat      *shareChat     // messages to and from the receiver
+	forwards  []string       // --allow-forward
+	hooks     *shareHooks    // the hooks of config.yaml and --report
+	manifest  *shareManifest // --manifest
+	report    *sessionReport // --report
 }
 
-func handleShareRequests(tun *tunnel.Tunnel, fs *filesystem.SecureFilesystem) error {
+// handleShareRequests serves requests until the tunnel closes. When mon is
+// set, every request is recorded for the dashboard as coming from peer.
+func handleShareRequests(tun *tunnel.Tunnel, fs *filesystem.SecureFilesystem, mon *monitor.Monitor, peer int, controls shareControls) error {
+	downloads := controls.downloads
+	sem := make(chan struct{}, maxConcurrentRequests)
+	urgent := make(chan struct{}, maxConcurrentRequests)
+	inflight := newInflightRequests()
+	watches := newShareWatches(tun, fs)
+	defer watches.Close()
+	if controls.chat != nil {
+		controls.chat.attach(tun)
+		defer controls.chat.detach()
+	}
+	forwards := newShareForwards(tun, controls.forwards)
+	defer forwards.Close()
+	controls.hooks.connected()
+	defer controls.hooks.disconnected()
+	reported := controls.report.connected("receiver")
+	defer controls.report.disconnected(reported, tun)
+	txs := newShareTxs(fs, controls.hooks)
+	defer txs.Close()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
 	for {
 		// Receive request
 		frame, err := tun.ReceiveFrame()
@@ -110,22 +533,197 @@ func handleShareRequests(tun *tunnel.Tunnel, fs *filesystem.SecureFilesystem) er
 			if tun.IsClosed() {
 				return nil
 			}
-			log.Printf("Error receiving frame: %v", err)
+			slog.Warn("failed to receive frame", "err", err)
 			continue
 		}
 
-		// Handle request
-		response := processRequest(frame, fs)
+		// The receiver gave up on a request, e.g. a cancelled transfer
+		if frame.Type == protocol.FrameTypeCancel {
+			var req protocol.CancelRequest
+			if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err == nil {
+				inflight.cancel(req.ID)
+			}
+			continue
+		}
 
-		// Send response
-		if err := tun.SendFrame(response); err != nil {
-			log.Printf("Error sending response: %v", err)
+		// Data of forwarded connections, which must stay in order
+		if slices.Contains(forward.FrameTypes, frame.Type) {
+			forwards.streams.Handle(frame)
 			continue
 		}
+
+		// Chat messages get no response
+		if frame.Type == protocol.FrameTypeMessage {
+			if controls.chat != nil {
+				controls.chat.receive(frame)
+			}
+			continue
+		}
+
+		// Requests are served concurrently so that a large read does not
+		// hold up directory listings issued by the receiver in the meantime.
+		// Interactive requests have slots of their own, which reads and
+		// writes cannot all take.
+		slots := sem
+		if protocol.FramePriority(frame) == protocol.PriorityInteractive {
+			slots = urgent
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(frame *protocol.Frame) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			ctx, done := inflight.start(frame.ID)
+			defer done()
+			ctx, span := traceRequest(ctx, frame)
+			var response *protocol.Frame
+			started := time.Now()
+			defer func() {
+				endRequestSpan(span, response)
+				recordServed(frame, response, started)
+			}()
+			if downloads != nil && frame.Type != protocol.FrameTypePing {
+				downloads.begin()
+				defer downloads.end()
+			}
+
+			slog.Debug("serving request", "type", frame.Type, "id", frame.ID)
+
+			// Handle request
+			if mon != nil && mon.Paused() && frame.Type != protocol.FrameTypePing {
+				response = errorFrame(protocol.ErrCodePermission, "sharing is paused by the owner")
+			} else if downloads != nil && frame.Type == protocol.FrameTypeRead && downloads.Reached() {
+				response = errorFrame(protocol.ErrCodePermission, "the share has reached its download limit")
+			} else if controls.writes != nil && isChange(frame.Type) && !controls.writes.allow(peer, frame) {
+				response = errorFrame(protocol.ErrCodePermission, "the sharer declined the change")
+			} else if frame.Type == protocol.FrameTypeWatch {
+				response = watches.handle(frame)
+			} else if frame.Type == protocol.FrameTypeForward {
+				response = forwards.handle(ctx, frame)
+			} else if txs.handles(frame) {
+				response = txs.handle(ctx, frame)
+			} else if frame.Type == protocol.FrameTypeManifest {
+				response = controls.manifest.handle(ctx, frame)
+			} else if frame.Type == protocol.FrameTypeInfo && controls.manifest != nil {
+				response = responseFrame(&protocol.InfoResponse{
+					ReadOnly:     fs.IsReadOnly(),
+					File:         filesystem.WireName(fs.SharedFile()),
+					Manifest:     true,
+					Transactions: !fs.IsReadOnly(),
+				})
+			} else {
+				response = processRequest(ctx, frame, fs)
+			}
+			response.ID = frame.ID
+
+			// Nobody is waiting for the response of a cancelled request
+			if ctx.Err() != nil {
+				response = errorFrame(protocol.ErrCodeUnknown, errCancelled)
+			}
+
+			if mon != nil {
+				recordRequest(mon, peer, frame, response)
+			}
+			controls.hooks.served(frame, response)
+			if ctx.Err() != nil {
+				return
+			}
+
+			// Send response
+			if err := tun.SendFrame(response); err != nil {
+				slog.Warn("failed to send response", "err", err)
+				return
+			}
+			if downloads != nil && frame.Type == protocol.FrameTypeRead && response.Type == protocol.FrameTypeResponse {
+				downloads.record(frame, fs)
+			}
+		}(frame)
+	}
+}
+
+// traceRequest starts the span of serving frame, in the trace of the
+// receiver's request when it sent one. Pings are not traced.
+func traceRequest(ctx context.Context, frame *protocol.Frame) (context.Context, *telemetry.Span) {
+	if frame.Type == protocol.FrameTypePing {
+		return ctx, nil
 	}
+	ctx = telemetry.ContextWithRemote(ctx, telemetry.ParseTraceparent(frame.Trace))
+	return telemetry.Start(ctx, "serve "+protocol.FrameTypeName(frame.Type), telemetry.KindServer,
+		slog.Int("orb.request.bytes", len(frame.Payload)))
 }
 
-func processRequest(frame *protocol.Frame, fs *filesystem.SecureFilesystem) *protocol.Frame {
+// endRequestSpan ends the span of a request with the response sent for it
+func endRequestSpan(span *telemetry.Span, response *protocol.Frame) {
+	if response == nil {
+		span.End(errors.New("no response"))
+		return
+	}
+	span.SetAttributes(slog.Int("orb.response.bytes", len(response.Payload)))
+	if response.Type == protocol.FrameTypeError {
+		span.End(errors.New("error response"))
+		return
+	}
+	span.End(nil)
+}
+
+// recordServed counts a request in the metrics. Pings are left out.
+func recordServed(frame, response *protocol.Frame, started time.Time) {
+	if frame.Type == protocol.FrameTypePing {
+		return
+	}
+	kind := protocol.FrameTypeName(frame.Type)
+	result := "ok"
+	if response == nil || response.Type == protocol.FrameTypeError {
+		result = "error"
+	}
+	requestsServed.Inc(kind, result)
+	requestSeconds.Observe(time.Since(started).Seconds(), kind)
+}
+
+// inflightRequests tracks the requests being served so the receiver can cancel them
+type inflightRequests struct {
+	mu      sync.Mutex
+	cancels map[uint32]context.CancelFunc
+}
+
+func newInflightRequests() *inflightRequests {
+	return &inflightRequests{cancels: make(map[uint32]context.CancelFunc)}
+}
+
+// start returns the context for serving request id and a function to call when done
+func (r *inflightRequests) start(id uint32) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if id == 0 {
+		return ctx, cancel
+	}
+
+	r.mu.Lock()
+	r.cancels[id] = cancel
+	r.mu.Unlock()
+
+	return ctx, func() {
+		r.mu.Lock()
+		delete(r.cancels, id)
+		r.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancel stops serving request id if it is still running
+func (r *inflightRequests) cancel(id uint32) {
+	r.mu.Lock()
+	cancel, ok := r.cancels[id]
+	r.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
+
+func processRequest(ctx context.Context, frame *protocol.Frame, fs *filesystem.SecureFilesystem) *protocol.Frame {
 	switch frame.Type {
 	case protocol.FrameTypePing:
 		return &protocol.Frame{
@@ -146,6 +744,22 @@ func processRequest(frame *protocol.Frame, fs *filesystem.SecureFilesystem) *pro
 		return handleRenameRequest(frame, fs)
 	case protocol.FrameTypeMkdir:
 		return handleMkdirRequest(frame, fs)
+	case protocol.FrameTypeSearch:
+		return handleSearchRequest(ctx, frame, fs)
+	case protocol.FrameTypeHash:
+		return handleHashRequest(ctx, frame, fs)
+	case protocol.FrameTypeSignature:
+		return handleSignatureRequest(ctx, frame, fs)
+	case protocol.FrameTypeCopy:
+		return handleCopyRequest(frame, fs)
+	case protocol.FrameTypeBench:
+		return handleBenchRequest(frame)
+	case protocol.FrameTypeInfo:
+		return responseFrame(&protocol.InfoResponse{
+			ReadOnly:     fs.IsReadOnly(),
+			File:         filesystem.WireName(fs.SharedFile()),
+			Transactions: !fs.IsReadOnly(),
+		})
 	default:
 		return errorFrame(protocol.ErrCodeUnknown, "unknown request type")
 	}
@@ -179,6 +793,57 @@ func handleStatRequest(frame *protocol.Frame, fs *filesystem.SecureFilesystem) *
 	return responseFrame(resp)
 }
 
+func handleHashRequest(ctx context.Context, frame *protocol.Frame, fs *filesystem.SecureFilesystem) *protocol.Frame {
+	var req protocol.HashRequest
+	if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+		return errorFrame(protocol.ErrCodeUnknown, err.Error())
+	}
+
+	resp, err := fs.Hash(ctx, req.Path, req.Length)
+	if err != nil {
+		return errorFrame(protocol.ErrCodeIO, err.Error())
+	}
+
+	return responseFrame(resp)
+}
+
+func handleSignatureRequest(ctx context.Context, frame *protocol.Frame, fs *filesystem.SecureFilesystem) *protocol.Frame {
+	var req protocol.SignatureRequest
+	if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+		return errorFrame(protocol.ErrCodeUnknown, err.Error())
+	}
+
+	resp, err := fs.Signature(ctx, req.Path, req.BlockSize, req.First, req.Count)
+	if err != nil {
+		return errorFrame(protocol.ErrCodeIO, err.Error())
+	}
+
+	return responseFrame(resp)
+}
+
+// maxBenchSize bounds the filler sent for one bench request, well below the
+// frame limit
+const maxBenchSize = 512 * 1024
+
+// benchFiller is the data returned to orb bench. It is random so that it
+// cannot be compressed on the way.
+var benchFiller = sync.OnceValue(func() []byte {
+	b := make([]byte, maxBenchSize)
+	_, _ = rand.Read(b)
+	return b
+})
+
+func handleBenchRequest(frame *protocol.Frame) *protocol.Frame {
+	var req protocol.BenchRequest
+	if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+		return errorFrame(protocol.ErrCodeUnknown, err.Error())
+	}
+	if req.Size < 0 || req.Size > maxBenchSize {
+		return errorFrame(protocol.ErrCodeUnknown, fmt.Sprintf("bench size must be at most %d bytes", maxBenchSize))
+	}
+	return responseFrame(&protocol.BenchResponse{Data: benchFiller()[:req.Size]})
+}
+
 func handleReadRequest(frame *protocol.Frame, fs *filesystem.SecureFilesystem) *protocol.Frame {
 	var req protocol.ReadRequest
 	if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
@@ -201,7 +866,27 @@ func handleWriteRequest(frame *protocol.Frame, fs *filesystem.SecureFilesystem)
 
 	resp, err := fs.Write(req.Path, req.Offset, req.Data)
 	if err != nil {
-		return errorFrame(protocol.ErrCodePermission, err.Error())
+		return errorFrame(writeErrorCode(err), err.Error())
+	}
+	if req.Truncate {
+		if err := fs.Truncate(req.Path, req.Offset+resp.BytesWritten); err != nil {
+			return errorFrame(writeErrorCode(err), err.Error())
+		}
+		resp.Truncated = true
+	}
+
+	return responseFrame(resp)
+}
+
+func handleCopyRequest(frame *protocol.Frame, fs *filesystem.SecureFilesystem) *protocol.Frame {
+	var req protocol.CopyRequest
+	if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+		return errorFrame(protocol.ErrCodeUnknown, err.Error())
+	}
+
+	resp, err := fs.Copy(req.Path, req.Offset, req.Source, req.SourceOffset, req.Length)
+	if err != nil {
+		return errorFrame(writeErrorCode(err), err.Error())
 	}
 
 	return responseFrame(resp)
@@ -246,6 +931,20 @@ func handleMkdirRequest(frame *protocol.Frame, fs *filesystem.SecureFilesystem)
 	return responseFrame(&protocol.WriteResponse{BytesWritten: 0})
 }
 
+func handleSearchRequest(ctx context.Context, frame *protocol.Frame, fs *filesystem.SecureFilesystem) *protocol.Frame {
+	var req protocol.SearchRequest
+	if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+		return errorFrame(protocol.ErrCodeUnknown, err.Error())
+	}
+
+	resp, err := fs.Search(ctx, req.Path, req.Query, req.MaxResults)
+	if err != nil {
+		return errorFrame(protocol.ErrCodeIO, err.Error())
+	}
+
+	return responseFrame(resp)
+}
+
 func responseFrame(data interface{}) *protocol.Frame {
 	var buf bytes.Buffer
 	_ = gob.NewEncoder(&buf).Encode(data)
@@ -270,3 +969,15 @@ func errorFrame(code uint32, message string) *protocol.Frame {
 		Payload: buf.Bytes(),
 	}
 }
+
+// buildSearchIndex indexes the share for --index, searches walk the tree
+// until it is done
+func buildSearchIndex(ctx context.Context, fs *filesystem.SecureFilesystem) {
+	start := time.Now()
+	n, err := fs.BuildIndex(ctx)
+	if err != nil {
+		slog.Warn("failed to index the share, searches walk it instead", "err", err)
+		return
+	}
+	slog.Info("search index ready", "entries", n, "took", time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/daemon"
+	"github.com/Zayan-Mohamed/orb/internal/monitor"
+	"github.com/Zayan-Mohamed/orb/internal/tunnel"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// maxChatHistory is the number of chat messages a share keeps for orb msg
+const maxChatHistory = 100
+
+// shareChat is the chat of a share with its receiver. Messages from the
+// receiver are printed, or shown on the dashboard; the sharer answers with
+// orb msg through the control socket.
+type shareChat struct {
+	mon *monitor.Monitor
+
+	mu       sync.Mutex
+	tun      *tunnel.Tunnel // nil while nobody is connected
+	messages []daemon.Message
+}
+
+func newShareChat(mon *monitor.Monitor) *shareChat {
+	return &shareChat{mon: mon}
+}
+
+// attach sends messages through tun until detach
+func (c *shareChat) attach(tun *tunnel.Tunnel) {
+	c.mu.Lock()
+	c.tun = tun
+	c.mu.Unlock()
+}
+
+// detach stops sending messages once the receiver left
+func (c *shareChat) detach() {
+	c.mu.Lock()
+	c.tun = nil
+	c.mu.Unlock()
+}
+
+// Messages implements daemon.Chat
+func (c *shareChat) Messages() []daemon.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]daemon.Message(nil), c.messages...)
+}
+
+// Send implements daemon.Chat
+func (c *shareChat) Send(text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return fmt.Errorf("empty message")
+	}
+	if len(text) > protocol.MaxChatMessage {
+		return fmt.Errorf("message is longer than %d bytes", protocol.MaxChatMessage)
+	}
+
+	c.mu.Lock()
+	tun := c.tun
+	c.mu.Unlock()
+	if tun == nil {
+		return daemon.ErrNoReceiver
+	}
+
+	now := time.Now()
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(protocol.ChatMessage{Text: text, Sent: now.Unix()}); err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	if err := tun.SendFrame(&protocol.Frame{Type: protocol.FrameTypeMessage, Payload: buf.Bytes()}); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	c.add(daemon.Message{From: "sharer", Text: text, Time: now})
+	return nil
+}
+
+// receive shows a message of the receiver
+func (c *shareChat) receive(frame *protocol.Frame) {
+	var msg protocol.ChatMessage
+	if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&msg); err != nil {
+		slog.Debug("invalid chat message", "err", err)
+		return
+	}
+	text := strings.TrimSpace(msg.Text)
+	if text == "" || len(text) > protocol.MaxChatMessage {
+		return
+	}
+	// Control characters could redraw the sharer's terminal
+	text = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, text)
+
+	c.add(daemon.Message{From: "receiver", Text: text, Time: time.Now()})
+	c.mon.Record(monitor.Op{Kind: "chat", Path: text}, 0, 0)
+
+	switch {
+	case dashboard:
+		// The dashboard shows it in the activity stream
+	case jsonOutput:
+		_ = printJSON(event{Event: "message", Text: text})
+	default:
+		fmt.Printf("💬 Receiver: %s\n", text)
+	}
+}
+
+// add keeps a message for orb msg
+func (c *shareChat) add(msg daemon.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, msg)
+	if len(c.messages) > maxChatHistory {
+		c.messages = append([]daemon.Message(nil), c.messages[len(c.messages)-maxChatHistory:]...)
+	}
+}
@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/daemon"
+	"github.com/Zayan-Mohamed/orb/internal/filesystem"
+	"github.com/Zayan-Mohamed/orb/internal/monitor"
+)
+
+// The background process of "orb share --daemon" receives the session the
+// foreground process created, and the relay it was created on, through these
+// variables. The environment keeps the passcode out of process listings.
+const (
+	daemonSessionEnv  = "ORB_DAEMON_SESSION"
+	daemonPasscodeEnv = "ORB_DAEMON_PASSCODE"
+	daemonRelayEnv    = "ORB_DAEMON_RELAY"
+	daemonExpiresEnv  = "ORB_DAEMON_EXPIRES"
+)
+
+// daemonStartTimeout is how long to wait for the background share to register
+const daemonStartTimeout = 10 * time.Second
+
+// daemonSession returns the session handed to a background share process,
+// or ok == false when running in the foreground
+func daemonSession() (sessionID, passcode string, ok bool) {
+	sessionID = os.Getenv(daemonSessionEnv)
+	passcode = os.Getenv(daemonPasscodeEnv)
+	if relay := os.Getenv(daemonRelayEnv); relay != "" {
+		relayURL = relay
+	}
+	if expires, err := time.Parse(time.RFC3339Nano, os.Getenv(daemonExpiresEnv)); err == nil {
+		shareExpiresAt = expires
+	}
+	_ = os.Unsetenv(daemonSessionEnv)
+	_ = os.Unsetenv(daemonPasscodeEnv)
+	_ = os.Unsetenv(daemonRelayEnv)
+	_ = os.Unsetenv(daemonExpiresEnv)
+	return sessionID, passcode, sessionID != "" && passcode != ""
+}
+
+// startShareDaemon starts this command again as a detached process to serve
+// the session, and returns once it is running
+func startShareDaemon(sessionID, passcode, absPath string, roots map[string]string) error {
+	dir, err := daemon.Dir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	logPath := filepath.Join(dir, sessionID+".log")
+	// #nosec G304 -- the log file is inside the daemon directory
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create log file: %w", err)
+	}
+	defer func() { _ = logFile.Close() }()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate orb: %w", err)
+	}
+
+	// #nosec G204 -- runs this same binary with the arguments it was given
+	child := exec.Command(exe, os.Args[1:]...)
+	child.Env = append(os.Environ(), daemonSessionEnv+"="+sessionID, daemonPasscodeEnv+"="+passcode,
+		daemonRelayEnv+"="+relayURL)
+	if !shareExpiresAt.IsZero() {
+		child.Env = append(child.Env, daemonExpiresEnv+"="+shareExpiresAt.Format(time.RFC3339Nano))
+	}
+	child.Stdout = logFile
+	child.Stderr = logFile
+	daemon.Detach(child)
+
+	if err := child.Start(); err != nil {
+		return fmt.Errorf("failed to start background share: %w", err)
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		_ = child.Wait()
+		close(exited)
+	}()
+
+	// Wait until the background share answers on its control socket
+	deadline := time.After(daemonStartTimeout)
+	for {
+		if _, err := daemon.Lookup(sessionID); err == nil {
+			break
+		}
+		select {
+		case <-exited:
+			return fmt.Errorf("background share failed to start, see %s", logPath)
+		case <-deadline:
+			return fmt.Errorf("background share did not start in time, see %s", logPath)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	if jsonOutput {
+		return printJSON(event{
+			Event:     "session",
+			SessionID: sessionID,
+			Passcode:  passcode,
+			Relay:     relayURL,
+			Path:      absPath,
+			Expires:   formatExpiry(shareExpiresAt),
+			PID:       child.Process.Pid,
+			LogFile:   logPath,
+		})
+	}
+
+	printShareSession(sessionID, passcode, roots)
+	fmt.Printf("Sharing in the background (PID %d), logging to %s\n", child.Process.Pid, logPath)
+	fmt.Printf("Check on it with \"orb status\" and stop it with \"orb stop %s\".\n", sessionID)
+	return nil
+}
+
+// registerShare makes a share running in this process known to orb sessions
+// and, for a background share, to orb status and orb stop. stop is called
+// when one of them asks the share to end.
+func registerShare(sessionID, absPath string, readOnly, background bool, mon *monitor.Monitor, stop func()) (*daemon.Server, error) {
+	info := daemon.Info{
+		SessionID:  sessionID,
+		PID:        os.Getpid(),
+		Path:       absPath,
+		Relay:      relayURL,
+		ReadOnly:   readOnly,
+		Background: background,
+		Started:    time.Now(),
+	}
+	if dir, err := daemon.Dir(); err == nil && background {
+		info.LogFile = filepath.Join(dir, sessionID+".log")
+	}
+
+	var chat daemon.Chat
+	if shareControl.chat != nil {
+		chat = shareControl.chat
+	}
+	return daemon.Serve(info, func() daemon.Status {
+		return shareStatus(info, mon)
+	}, stop, chat)
+}
+
+// runShareDaemon serves the session without a terminal until the receiver
+// leaves or ctx is cancelled, e.g. by orb stop
+func runShareDaemon(ctx context.Context, sessionID, passcode, absPath string, fs *filesystem.SecureFilesystem, mon *monitor.Monitor) error {
+	slog.Info("background share started", "session", sessionID, "path", absPath)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- serveReceiver(ctx, sessionID, passcode, fs, mon, func() error {
+			slog.Info("receiver connected", "session", sessionID)
+			return nil
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+		slog.Info("receiver disconnected, session ended", "session", sessionID)
+	case <-ctx.Done():
+		if errors.Is(context.Cause(ctx), errShareExpired) {
+			slog.Info("session expired, background share stopped", "session", sessionID)
+			break
+		}
+		if errors.Is(context.Cause(ctx), errDownloadLimit) {
+			slog.Info("download limit reached, background share stopped", "session", sessionID, "downloads", shareControl.downloads.Count())
+			break
+		}
+		slog.Info("background share stopped", "session", sessionID)
+	}
+	return nil
+}
+
+// shareStatus summarizes a background share for orb status
+func shareStatus(info daemon.Info, mon *monitor.Monitor) daemon.Status {
+	status := daemon.Status{Info: info}
+	for _, p := range mon.Peers() {
+		status.Peers++
+		status.Connected = true
+		status.ConnectedAt = p.Connected
+		status.Requests += p.Requests
+		status.BytesSent += p.BytesSent
+		status.BytesReceived += p.BytesReceived
+	}
+	return status
+}
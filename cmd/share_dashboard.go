@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"github.com/Zayan-Mohamed/orb/internal/filesystem"
+	"github.com/Zayan-Mohamed/orb/internal/logging"
+	"github.com/Zayan-Mohamed/orb/internal/monitor"
+	"github.com/Zayan-Mohamed/orb/internal/tui"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// requestKinds names the request types shown in the dashboard
+var requestKinds = map[uint32]string{
+	protocol.FrameTypeList:       "list",
+	protocol.FrameTypeStat:       "stat",
+	protocol.FrameTypeRead:       "read",
+	protocol.FrameTypeWrite:      "write",
+	protocol.FrameTypeDelete:     "delete",
+	protocol.FrameTypeRename:     "rename",
+	protocol.FrameTypeMkdir:      "mkdir",
+	protocol.FrameTypeSearch:     "search",
+	protocol.FrameTypeInfo:       "info",
+	protocol.FrameTypeHash:       "hash",
+	protocol.FrameTypeWatch:      "watch",
+	protocol.FrameTypeForward:    "forward",
+	protocol.FrameTypeSignature:  "signature",
+	protocol.FrameTypeCopy:       "copy",
+	protocol.FrameTypeManifest:   "manifest",
+	protocol.FrameTypeTxBegin:    "begin",
+	protocol.FrameTypeTxCommit:   "commit",
+	protocol.FrameTypeTxRollback: "rollback",
+}
+
+// runShareDashboard serves the share in the background while a dashboard
+// shows who is connected and what they are doing
+func runShareDashboard(ctx context.Context, sessionID, passcode, absPath string, fs *filesystem.SecureFilesystem, mon *monitor.Monitor) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	theme, err := tui.ThemeFromConfig(cfg.TUI.Theme)
+	if err != nil {
+		return fmt.Errorf("invalid theme in config: %w", err)
+	}
+
+	// Log lines would tear the dashboard apart, show them in the activity stream
+	defer logging.Redirect(mon)()
+
+	done := make(chan error, 1)
+	go func() {
+		connected := false
+		err := serveReceiver(ctx, sessionID, passcode, fs, mon, func() error {
+			connected = true
+			return nil
+		})
+		if err != nil && !connected {
+			mon.Fail(err)
+		}
+		done <- err
+	}()
+
+	err = tui.StartDashboard(mon, tui.DashboardOptions{
+		SessionID: sessionID,
+		Passcode:  passcode,
+		Path:      absPath,
+		ReadOnly:  fs.IsReadOnly(),
+		Theme:     &theme,
+		Context:   ctx,
+	})
+
+	// Quitting the dashboard stops sharing
+	mon.RevokeAll()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-done:
+		return err
+	default:
+		// Still waiting for a receiver
+		return nil
+	}
+}
+
+// recordRequest adds a served request to the dashboard's activity stream
+func recordRequest(mon *monitor.Monitor, peer int, frame, response *protocol.Frame) {
+	kind, ok := requestKinds[frame.Type]
+	if !ok {
+		// Pings keep the connection bar of the receiver alive, they are not activity
+		return
+	}
+
+	// Only the path fields are decoded, gob skips the others
+	var paths struct {
+		Path    string
+		OldPath string
+		NewPath string
+		Address string
+	}
+	_ = gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&paths)
+
+	op := monitor.Op{
+		Peer: peer,
+		Kind: kind,
+		Path: paths.Path,
+	}
+	switch frame.Type {
+	case protocol.FrameTypeRename:
+		op.Path = paths.OldPath + " → " + paths.NewPath
+	case protocol.FrameTypeForward:
+		op.Path = paths.Address
+	}
+
+	switch frame.Type {
+	case protocol.FrameTypeRead:
+		op.Bytes = int64(len(response.Payload))
+	case protocol.FrameTypeWrite:
+		op.Bytes = int64(len(frame.Payload))
+	}
+
+	if response.Type == protocol.FrameTypeError {
+		var errResp protocol.ErrorResponse
+		if err := gob.NewDecoder(bytes.NewReader(response.Payload)).Decode(&errResp); err == nil {
+			op.Err = errResp.Message
+		} else {
+			op.Err = "request failed"
+		}
+	}
+
+	mon.Record(op, int64(len(response.Payload)), int64(len(frame.Payload)))
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"log/slog"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/filesystem"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// errDownloadLimit ends a share once --max-downloads files were downloaded
+var errDownloadLimit = errors.New("download limit reached")
+
+// downloadLimitIdle is how long a share waits for requests after the last
+// allowed download, so the receiver can still verify the file
+const downloadLimitIdle = 2 * time.Second
+
+// span is a range of a file that was read, from start up to end
+type span struct {
+	start, end int64
+}
+
+// downloadLimit counts the files downloaded from a share and ends it after
+// the last one allowed. A file counts once every byte of it was read, in any
+// order, so parallel and resumed downloads count as one.
+type downloadLimit struct {
+	mu       sync.Mutex
+	max      int
+	count    int
+	served   map[string][]span // ranges read since the last complete download of a file
+	inflight int
+	idle     *time.Timer
+	stop     func()
+
+	// completed, if set, is called with each file downloaded
+	completed func(p string, size int64)
+}
+
+// newDownloadLimit allows limit downloads and calls stop once the receiver
+// is done with the last of them. A limit of 0 only counts them.
+func newDownloadLimit(limit int, stop func()) *downloadLimit {
+	return &downloadLimit{max: limit, served: make(map[string][]span), stop: stop}
+}
+
+// Reached reports whether the last allowed download is complete
+func (d *downloadLimit) Reached() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.max > 0 && d.count >= d.max
+}
+
+// Count returns the number of complete downloads
+func (d *downloadLimit) Count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.count
+}
+
+// begin marks a request as being served. Pings are left out, they keep
+// coming while the receiver is idle.
+func (d *downloadLimit) begin() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inflight++
+	if d.idle != nil {
+		d.idle.Stop()
+	}
+}
+
+// end marks a request as answered. Once the limit is reached, the share
+// stops after downloadLimitIdle without requests.
+func (d *downloadLimit) end() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inflight--
+	if d.max == 0 || d.count < d.max || d.inflight > 0 {
+		return
+	}
+	if d.idle == nil {
+		d.idle = time.AfterFunc(downloadLimitIdle, d.stop)
+	} else {
+		d.idle.Reset(downloadLimitIdle)
+	}
+}
+
+// record notes the range of a file served by a successful read request
+func (d *downloadLimit) record(frame *protocol.Frame, fs *filesystem.SecureFilesystem) {
+	var req protocol.ReadRequest
+	if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil || req.Length <= 0 {
+		return
+	}
+	stat, err := fs.Stat(req.Path)
+	if err != nil || stat.Info.IsDir || stat.Info.Size == 0 {
+		return
+	}
+	size := stat.Info.Size
+	p := path.Clean("/" + req.Path)
+
+	d.mu.Lock()
+	spans := mergeSpan(d.served[p], span{req.Offset, min(req.Offset+req.Length, size)})
+	if len(spans) != 1 || spans[0].start > 0 || spans[0].end < size {
+		d.served[p] = spans
+		d.mu.Unlock()
+		return
+	}
+
+	delete(d.served, p)
+	d.count++
+	slog.Info("download complete", "path", p, "downloads", d.count, "max", d.max)
+	d.mu.Unlock()
+
+	if d.completed != nil {
+		d.completed(p, size)
+	}
+}
+
+// mergeSpan adds s to a sorted list of disjoint spans, joining those it
+// overlaps or touches
+func mergeSpan(spans []span, s span) []span {
+	if s.end <= s.start {
+		return spans
+	}
+	i, _ := slices.BinarySearchFunc(spans, s.start, func(e span, start int64) int {
+		switch {
+		case e.end < start:
+			return -1
+		case e.start > start:
+			return 1
+		}
+		return 0
+	})
+	j := i
+	for j < len(spans) && spans[j].start <= s.end {
+		s.start = min(s.start, spans[j].start)
+		s.end = max(s.end, spans[j].end)
+		j++
+	}
+	return slices.Replace(spans, i, j, s)
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/forward"
+	"github.com/Zayan-Mohamed/orb/internal/tunnel"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// forwardDialTimeout bounds connecting to a forwarded address
+const forwardDialTimeout = 10 * time.Second
+
+// shareForwards connects the receiver's forwarded ports to the addresses
+// the sharer allowed with --allow-forward
+type shareForwards struct {
+	allowed []string
+	streams *forward.Streams
+	wg      sync.WaitGroup
+}
+
+func newShareForwards(tun *tunnel.Tunnel, allowed []string) *shareForwards {
+	return &shareForwards{
+		allowed: allowed,
+		streams: forward.NewStreams(func(frame *protocol.Frame) error {
+			frame.ID = 0
+			return tun.SendFrame(frame)
+		}),
+	}
+}
+
+// handle serves a forward request
+func (f *shareForwards) handle(ctx context.Context, frame *protocol.Frame) *protocol.Frame {
+	var req protocol.ForwardRequest
+	if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+		return errorFrame(protocol.ErrCodeUnknown, err.Error())
+	}
+	if !slices.Contains(f.allowed, req.Address) {
+		return errorFrame(protocol.ErrCodePermission, fmt.Sprintf("the sharer does not allow forwarding to %s", req.Address))
+	}
+
+	dialer := net.Dialer{Timeout: forwardDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", req.Address)
+	if err != nil {
+		return errorFrame(protocol.ErrCodeIO, err.Error())
+	}
+	st, err := f.streams.Open(req.Stream)
+	if err != nil {
+		_ = conn.Close()
+		return errorFrame(protocol.ErrCodeUnknown, err.Error())
+	}
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		forward.Pipe(conn, st)
+	}()
+	return responseFrame(struct{}{})
+}
+
+// Close ends all forwarded connections once the tunnel is gone
+func (f *shareForwards) Close() {
+	f.streams.CloseAll()
+	f.wg.Wait()
+}
@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"github.com/Zayan-Mohamed/orb/internal/filesystem"
+	"github.com/Zayan-Mohamed/orb/internal/hooks"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// configHooks are the hooks of config.yaml
+var configHooks []config.Hook
+
+// uploadSettle is how long a file has to go without writes before its
+// upload counts as complete. The protocol has no end of an upload: writes
+// stop, or the file is renamed into place.
+const uploadSettle = 2 * time.Second
+
+// shareHooks reports the events of a share to the hooks of config.yaml and
+// to --report. A nil shareHooks reports nothing.
+type shareHooks struct {
+	runner    *hooks.Runner
+	report    *sessionReport
+	sessionID string
+	fs        *filesystem.SecureFilesystem
+
+	mu      sync.Mutex
+	uploads map[string]*time.Timer // files written to, by path
+}
+
+// newShareHooks returns nil when no hooks are configured and no report is
+// written
+func newShareHooks(runner *hooks.Runner, report *sessionReport, sessionID string, fs *filesystem.SecureFilesystem) *shareHooks {
+	if runner == nil && report == nil {
+		return nil
+	}
+	return &shareHooks{runner: runner, report: report, sessionID: sessionID, fs: fs, uploads: make(map[string]*time.Timer)}
+}
+
+// wants reports whether anything is done on event, so that callers can skip
+// work only hooks and the report need
+func (h *shareHooks) wants(event string) bool {
+	return h != nil && (h.report != nil || h.runner.Wants(event))
+}
+
+// fire reports an event, filling in the session and where its path lies
+func (h *shareHooks) fire(e hooks.Event) {
+	e.SessionID = h.sessionID
+	if e.Path != "" {
+		if local, err := h.fs.LocalPath(e.Path); err == nil {
+			e.LocalPath = local
+		}
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	h.runner.Fire(e)
+	if h.report != nil {
+		h.report.event(e)
+	}
+}
+
+func (h *shareHooks) connected() {
+	if h != nil {
+		h.fire(hooks.Event{Event: hooks.PeerConnected})
+	}
+}
+
+// disconnected completes the uploads still settling, the receiver is gone
+func (h *shareHooks) disconnected() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	pending := make([]string, 0, len(h.uploads))
+	for p, timer := range h.uploads {
+		timer.Stop()
+		pending = append(pending, p)
+	}
+	clear(h.uploads)
+	h.mu.Unlock()
+	for _, p := range pending {
+		h.uploaded(p)
+	}
+	h.fire(hooks.Event{Event: hooks.PeerDisconnected})
+}
+
+// downloaded reports a file read from start to end
+func (h *shareHooks) downloaded(p string, size int64) {
+	if h != nil {
+		h.fire(hooks.Event{Event: hooks.FileDownloaded, Path: p, Size: size})
+	}
+}
+
+// quota reports a limit of the share that was reached
+func (h *shareHooks) quota(e hooks.Event) {
+	if h != nil {
+		e.Event = hooks.QuotaExceeded
+		h.fire(e)
+	}
+}
+
+// served follows the changes the receiver makes, to tell when an upload is
+// complete, and reports requests refused for a quota
+func (h *shareHooks) served(frame, response *protocol.Frame) {
+	if h == nil || response == nil {
+		return
+	}
+	if response.Type == protocol.FrameTypeError {
+		var resp protocol.ErrorResponse
+		if gob.NewDecoder(bytes.NewReader(response.Payload)).Decode(&resp) != nil {
+			return
+		}
+		if resp.Code == protocol.ErrCodeQuotaExceeded {
+			h.quota(hooks.Event{Path: requestPath(frame), Text: resp.Message})
+		}
+		// Reads and changes that failed make the report, but not files
+		// looked for that are not there or requests given up on
+		if (frame.Type == protocol.FrameTypeRead || isChange(frame.Type)) && resp.Code != protocol.ErrCodeNotFound && resp.Message != errCancelled {
+			h.report.failed(requestPath(frame), resp.Message)
+		}
+		return
+	}
+	// Changes staged in a transaction are reported once it is committed
+	if !h.wants(hooks.UploadCompleted) || transactionOf(frame) != "" {
+		return
+	}
+
+	switch frame.Type {
+	case protocol.FrameTypeWrite, protocol.FrameTypeCopy:
+		h.written(requestPath(frame))
+	case protocol.FrameTypeRename:
+		var req protocol.RenameRequest
+		if gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req) != nil {
+			return
+		}
+		// Uploads written under a temporary name are done once moved
+		if timer := h.take(req.OldPath); timer != nil {
+			timer.Stop()
+			h.uploaded(req.NewPath)
+		}
+	case protocol.FrameTypeDelete:
+		if timer := h.take(requestPath(frame)); timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// written notes a write to p, which completes its upload once no further
+// writes follow for uploadSettle
+func (h *shareHooks) written(p string) {
+	if p == "" {
+		return
+	}
+	p = path.Clean("/" + p)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if timer, ok := h.uploads[p]; ok {
+		timer.Reset(uploadSettle)
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(uploadSettle, func() {
+		// A rename or disconnect may have completed the upload already
+		h.mu.Lock()
+		current := h.uploads[p] == timer
+		if current {
+			delete(h.uploads, p)
+		}
+		h.mu.Unlock()
+		if current {
+			h.uploaded(p)
+		}
+	})
+	h.uploads[p] = timer
+}
+
+// take stops following the upload of p and returns its timer, if any
+func (h *shareHooks) take(p string) *time.Timer {
+	p = path.Clean("/" + p)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	timer := h.uploads[p]
+	delete(h.uploads, p)
+	return timer
+}
+
+// committed reports the files written in a transaction that was committed
+func (h *shareHooks) committed(written []string) {
+	if !h.wants(hooks.UploadCompleted) {
+		return
+	}
+	for _, p := range written {
+		h.uploaded(p)
+	}
+}
+
+// uploaded reports the complete upload of p
+func (h *shareHooks) uploaded(p string) {
+	p = path.Clean("/" + p)
+	e := hooks.Event{Event: hooks.UploadCompleted, Path: p}
+	if stat, err := h.fs.Stat(p); err == nil {
+		e.Size = stat.Info.Size
+	}
+	h.fire(e)
+}
+
+// requestPath returns the path a request is about, or an empty string
+func requestPath(frame *protocol.Frame) string {
+	var req struct{ Path string }
+	if gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req) != nil {
+		return ""
+	}
+	return req.Path
+}
+
+// writeErrorCode tells the receiver when a change failed because the disk
+// or the user's quota is full
+func writeErrorCode(err error) uint32 {
+	if errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT) {
+		return protocol.ErrCodeQuotaExceeded
+	}
+	return protocol.ErrCodePermission
+}
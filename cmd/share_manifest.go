@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/filesystem"
+	"github.com/Zayan-Mohamed/orb/internal/manifest"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// manifestChunk is how much of a manifest one response carries, well below
+// protocol.MaxFrameSize
+const manifestChunk = 512 * 1024
+
+// shareManifest builds the signed manifest of --manifest in the background
+// and serves it once it is ready. Receivers asking earlier wait for it.
+type shareManifest struct {
+	ready chan struct{}
+	data  []byte
+	err   error
+}
+
+func newShareManifest(ctx context.Context, sessionID string, fs *filesystem.SecureFilesystem, key ed25519.PrivateKey) *shareManifest {
+	m := &shareManifest{ready: make(chan struct{})}
+	go func() {
+		defer close(m.ready)
+		start := time.Now()
+		m.data, m.err = buildManifest(ctx, sessionID, fs, key)
+		if m.err != nil {
+			slog.Warn("failed to build the manifest", "err", m.err)
+			return
+		}
+		slog.Info("manifest ready", "bytes", len(m.data), "took", time.Since(start).Round(time.Millisecond))
+	}()
+	return m
+}
+
+// handle serves the part of the manifest a receiver asks for
+func (m *shareManifest) handle(ctx context.Context, frame *protocol.Frame) *protocol.Frame {
+	if m == nil {
+		return errorFrame(protocol.ErrCodeNotFound, "this share has no manifest, the sharer has to start it with --manifest")
+	}
+	var req protocol.ManifestRequest
+	if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+		return errorFrame(protocol.ErrCodeUnknown, err.Error())
+	}
+
+	select {
+	case <-m.ready:
+	case <-ctx.Done():
+		return errorFrame(protocol.ErrCodeUnknown, errCancelled)
+	}
+	if m.err != nil {
+		return errorFrame(protocol.ErrCodeUnknown, "the sharer failed to build the manifest: "+m.err.Error())
+	}
+	if req.Offset < 0 || req.Offset > int64(len(m.data)) {
+		return errorFrame(protocol.ErrCodeUnknown, "offset outside the manifest")
+	}
+	end := min(req.Offset+manifestChunk, int64(len(m.data)))
+	return responseFrame(&protocol.ManifestResponse{Data: m.data[req.Offset:end], Size: int64(len(m.data))})
+}
+
+// buildManifest checksums every file of the share and signs the list
+func buildManifest(ctx context.Context, sessionID string, fs *filesystem.SecureFilesystem, key ed25519.PrivateKey) ([]byte, error) {
+	m := &manifest.Manifest{Version: manifest.Version, SessionID: sessionID, Created: time.Now().UTC()}
+
+	// A share of a single file holds just that file
+	if name := fs.SharedFile(); name != "" {
+		if err := addManifestEntry(ctx, m, fs, "/"+name); err != nil {
+			return nil, err
+		}
+		return manifest.Sign(m, key)
+	}
+
+	dirs := []string{"/"}
+	for len(dirs) > 0 {
+		dir := dirs[len(dirs)-1]
+		dirs = dirs[:len(dirs)-1]
+		list, err := fs.List(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range list.Files {
+			p := path.Join(dir, f.Name)
+			if f.IsDir {
+				dirs = append(dirs, p)
+				continue
+			}
+			if !os.FileMode(f.Mode).IsRegular() {
+				continue
+			}
+			if err := addManifestEntry(ctx, m, fs, p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return manifest.Sign(m, key)
+}
+
+func addManifestEntry(ctx context.Context, m *manifest.Manifest, fs *filesystem.SecureFilesystem, p string) error {
+	stat, err := fs.Stat(p)
+	if err != nil {
+		return err
+	}
+	hash, err := fs.Hash(ctx, p, 0)
+	if err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+	m.Files = append(m.Files, manifest.Entry{
+		Path:    p,
+		Size:    stat.Info.Size,
+		ModTime: time.Unix(stat.Info.ModTime, 0).UTC(),
+		SHA256:  hex.EncodeToString(hash.SHA256),
+	})
+	return nil
+}
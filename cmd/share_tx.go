@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sync"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"github.com/Zayan-Mohamed/orb/internal/filesystem"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// maxTransactions bounds the transactions a receiver has open at once
+const maxTransactions = 4
+
+// transactionDir is where shares stage the changes of transactions, next
+// to config.yaml
+func transactionDir() (string, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "transactions"), nil
+}
+
+// recoverTransactions finishes the transactions an earlier run of this
+// share committed but did not get to make, e.g. when the machine went down
+func recoverTransactions(fs *filesystem.SecureFilesystem) {
+	if fs.IsReadOnly() {
+		return
+	}
+	dir, err := transactionDir()
+	if err != nil {
+		return
+	}
+	finished, err := fs.RecoverTx(dir)
+	if finished > 0 {
+		slog.Info("finished changes committed before the last run stopped", "transactions", finished)
+	}
+	if err != nil {
+		slog.Warn("failed to finish changes committed before the last run stopped, they were dropped", "err", err)
+	}
+}
+
+// shareTxs holds the transactions a receiver opened. Those it did not
+// commit are rolled back when it disconnects.
+type shareTxs struct {
+	fs    *filesystem.SecureFilesystem
+	hooks *shareHooks
+
+	mu   sync.Mutex
+	open map[string]*filesystem.Tx
+}
+
+func newShareTxs(fs *filesystem.SecureFilesystem, hooks *shareHooks) *shareTxs {
+	return &shareTxs{fs: fs, hooks: hooks, open: make(map[string]*filesystem.Tx)}
+}
+
+// transactionOf returns the transaction a change is staged in, or "" for
+// changes to be made right away and other requests
+func transactionOf(frame *protocol.Frame) string {
+	if !isChange(frame.Type) && frame.Type != protocol.FrameTypeHash {
+		return ""
+	}
+	// Only this field is decoded, gob skips the others
+	var req struct{ Tx string }
+	if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+		return ""
+	}
+	return req.Tx
+}
+
+// handles reports whether a request is for the transactions
+func (s *shareTxs) handles(frame *protocol.Frame) bool {
+	switch frame.Type {
+	case protocol.FrameTypeTxBegin, protocol.FrameTypeTxCommit, protocol.FrameTypeTxRollback:
+		return true
+	}
+	return transactionOf(frame) != ""
+}
+
+// handle serves a request for the transactions
+func (s *shareTxs) handle(ctx context.Context, frame *protocol.Frame) *protocol.Frame {
+	switch frame.Type {
+	case protocol.FrameTypeTxBegin:
+		return s.begin()
+	case protocol.FrameTypeTxCommit:
+		var req protocol.TxCommitRequest
+		if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+			return errorFrame(protocol.ErrCodeUnknown, err.Error())
+		}
+		return s.commit(req.ID)
+	case protocol.FrameTypeTxRollback:
+		var req protocol.TxRollbackRequest
+		if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+			return errorFrame(protocol.ErrCodeUnknown, err.Error())
+		}
+		tx := s.take(req.ID)
+		if tx == nil {
+			return errorFrame(protocol.ErrCodeNotFound, "no such transaction")
+		}
+		if err := tx.Rollback(); err != nil {
+			return errorFrame(protocol.ErrCodeIO, err.Error())
+		}
+		return responseFrame(struct{}{})
+	}
+
+	s.mu.Lock()
+	tx := s.open[transactionOf(frame)]
+	s.mu.Unlock()
+	if tx == nil {
+		return errorFrame(protocol.ErrCodeNotFound, "no such transaction")
+	}
+	return stageRequest(ctx, frame, tx)
+}
+
+// begin opens a transaction
+func (s *shareTxs) begin() *protocol.Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.open) >= maxTransactions {
+		return errorFrame(protocol.ErrCodeQuotaExceeded, fmt.Sprintf("at most %d transactions can be open at once", maxTransactions))
+	}
+	dir, err := transactionDir()
+	if err != nil {
+		return errorFrame(protocol.ErrCodeIO, err.Error())
+	}
+	tx, err := s.fs.BeginTx(dir)
+	if err != nil {
+		return errorFrame(writeErrorCode(err), err.Error())
+	}
+	s.open[tx.ID()] = tx
+	return responseFrame(&protocol.TxBeginResponse{ID: tx.ID()})
+}
+
+// commit makes the changes of a transaction and reports the files it wrote
+// to the hooks, which it kept from them until now
+func (s *shareTxs) commit(id string) *protocol.Frame {
+	tx := s.take(id)
+	if tx == nil {
+		return errorFrame(protocol.ErrCodeNotFound, "no such transaction")
+	}
+	written := tx.Written()
+	changes, err := tx.Commit()
+	if err != nil {
+		return errorFrame(writeErrorCode(err), err.Error())
+	}
+	slog.Debug("transaction committed", "changes", changes)
+	s.hooks.committed(written)
+	return responseFrame(&protocol.TxCommitResponse{Changes: changes})
+}
+
+// take removes a transaction from the open ones
+func (s *shareTxs) take(id string) *filesystem.Tx {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx := s.open[id]
+	delete(s.open, id)
+	return tx
+}
+
+// Close rolls back the transactions that were not committed
+func (s *shareTxs) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, tx := range s.open {
+		if err := tx.Rollback(); err != nil {
+			slog.Warn("failed to roll back transaction", "err", err)
+		}
+		delete(s.open, id)
+	}
+}
+
+// stageRequest serves a change, or a hash, within a transaction
+func stageRequest(ctx context.Context, frame *protocol.Frame, tx *filesystem.Tx) *protocol.Frame {
+	var resp any
+	var err error
+	switch frame.Type {
+	case protocol.FrameTypeWrite:
+		var req protocol.WriteRequest
+		if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+			return errorFrame(protocol.ErrCodeUnknown, err.Error())
+		}
+		var written *protocol.WriteResponse
+		written, err = tx.Write(req.Path, req.Offset, req.Data)
+		if err == nil && req.Truncate {
+			if err = tx.Truncate(req.Path, req.Offset+written.BytesWritten); err == nil {
+				written.Truncated = true
+			}
+		}
+		resp = written
+	case protocol.FrameTypeCopy:
+		var req protocol.CopyRequest
+		if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+			return errorFrame(protocol.ErrCodeUnknown, err.Error())
+		}
+		resp, err = tx.Copy(req.Path, req.Offset, req.Source, req.SourceOffset, req.Length)
+	case protocol.FrameTypeDelete:
+		var req protocol.DeleteRequest
+		if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+			return errorFrame(protocol.ErrCodeUnknown, err.Error())
+		}
+		resp, err = &protocol.WriteResponse{}, tx.Delete(req.Path)
+	case protocol.FrameTypeRename:
+		var req protocol.RenameRequest
+		if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+			return errorFrame(protocol.ErrCodeUnknown, err.Error())
+		}
+		resp, err = &protocol.WriteResponse{}, tx.Rename(req.OldPath, req.NewPath)
+	case protocol.FrameTypeMkdir:
+		var req protocol.MkdirRequest
+		if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+			return errorFrame(protocol.ErrCodeUnknown, err.Error())
+		}
+		resp, err = &protocol.WriteResponse{}, tx.Mkdir(req.Path, req.Perm)
+	case protocol.FrameTypeHash:
+		var req protocol.HashRequest
+		if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+			return errorFrame(protocol.ErrCodeUnknown, err.Error())
+		}
+		if resp, err = tx.Hash(ctx, req.Path, req.Length); err != nil {
+			return errorFrame(protocol.ErrCodeIO, err.Error())
+		}
+	default:
+		return errorFrame(protocol.ErrCodeUnknown, "cannot be part of a transaction")
+	}
+	if err != nil {
+		return errorFrame(writeErrorCode(err), err.Error())
+	}
+	return responseFrame(resp)
+}
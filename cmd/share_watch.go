@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"log/slog"
+	"path"
+	"sync"
+
+	"github.com/Zayan-Mohamed/orb/internal/filesystem"
+	"github.com/Zayan-Mohamed/orb/internal/tunnel"
+	"github.com/Zayan-Mohamed/orb/internal/watch"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// maxWatches limits the directories a single receiver can watch
+const maxWatches = 8
+
+// shareWatches runs the watches a receiver asked for and forwards their
+// changes as event frames
+type shareWatches struct {
+	tun *tunnel.Tunnel
+	fs  *filesystem.SecureFilesystem
+
+	mu       sync.Mutex
+	watchers []*watch.Watcher
+	wg       sync.WaitGroup
+}
+
+func newShareWatches(tun *tunnel.Tunnel, fs *filesystem.SecureFilesystem) *shareWatches {
+	return &shareWatches{tun: tun, fs: fs}
+}
+
+// handle serves a watch request
+func (w *shareWatches) handle(frame *protocol.Frame) *protocol.Frame {
+	var req protocol.WatchRequest
+	if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+		return errorFrame(protocol.ErrCodeUnknown, err.Error())
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.watchers) >= maxWatches {
+		return errorFrame(protocol.ErrCodeQuotaExceeded, fmt.Sprintf("at most %d directories can be watched", maxWatches))
+	}
+
+	watcher, err := w.fs.Watch(req.Path)
+	if err != nil {
+		return errorFrame(protocol.ErrCodeNotFound, err.Error())
+	}
+	w.watchers = append(w.watchers, watcher)
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.forward(req.Path, watcher)
+	}()
+
+	return responseFrame(struct{}{})
+}
+
+// forward sends a watcher's changes to the receiver until it is closed
+func (w *shareWatches) forward(dir string, watcher *watch.Watcher) {
+	for changes := range watcher.Changes() {
+		// Never reveal the names of filtered files
+		changed := changes[:0]
+		for _, c := range changes {
+			if w.fs.Shared(path.Join(dir, c)) {
+				changed = append(changed, filesystem.WirePath(c))
+			}
+		}
+		if len(changed) == 0 {
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(protocol.WatchEvent{Path: dir, Changed: changed}); err != nil {
+			continue
+		}
+		if err := w.tun.SendFrame(&protocol.Frame{Type: protocol.FrameTypeEvent, Payload: buf.Bytes()}); err != nil {
+			slog.Warn("failed to send watch event", "err", err)
+		}
+	}
+}
+
+// Close stops all watches
+func (w *shareWatches) Close() {
+	w.mu.Lock()
+	for _, watcher := range w.watchers {
+		_ = watcher.Close()
+	}
+	w.watchers = nil
+	w.mu.Unlock()
+
+	w.wg.Wait()
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// writeApproval asks the sharer before a change made by a receiver is
+// carried out. Questions are asked one at a time, the requests that wait
+// for them are held.
+type writeApproval struct {
+	mu    sync.Mutex
+	in    *bufio.Reader
+	out   io.Writer
+	peers map[int]*peerApproval
+}
+
+// peerApproval is what the sharer allowed one receiver
+type peerApproval struct {
+	always bool
+	files  map[string]bool // files being written, later chunks need no answer
+}
+
+// newWriteApproval asks the questions on out and reads the answers from in
+func newWriteApproval(in io.Reader, out io.Writer) *writeApproval {
+	return &writeApproval{in: bufio.NewReader(in), out: out, peers: make(map[int]*peerApproval)}
+}
+
+// isChange reports whether a request changes the share
+func isChange(frameType uint32) bool {
+	switch frameType {
+	case protocol.FrameTypeWrite, protocol.FrameTypeCopy, protocol.FrameTypeDelete, protocol.FrameTypeRename, protocol.FrameTypeMkdir:
+		return true
+	}
+	return false
+}
+
+// allow holds a change requested by peer until the sharer answers. An upload
+// is asked about once, at its first chunk.
+func (w *writeApproval) allow(peer int, frame *protocol.Frame) bool {
+	// Only these fields are decoded, gob skips the others
+	var req struct {
+		Path     string
+		OldPath  string
+		NewPath  string
+		Offset   int64
+		Data     []byte
+		Truncate bool
+	}
+	if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&req); err != nil {
+		return false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	p, ok := w.peers[peer]
+	if !ok {
+		p = &peerApproval{files: make(map[string]bool)}
+		w.peers[peer] = p
+	}
+	if p.always {
+		return true
+	}
+
+	var question string
+	file := path.Clean("/" + req.Path)
+	switch frame.Type {
+	case protocol.FrameTypeWrite, protocol.FrameTypeCopy:
+		if p.files[file] {
+			return true
+		}
+		if req.Truncate && len(req.Data) == 0 {
+			question = fmt.Sprintf("set the size of %s to %d bytes", file, req.Offset)
+		} else if req.Offset > 0 {
+			question = fmt.Sprintf("write to %s from byte %d", file, req.Offset)
+		} else {
+			question = "write " + file
+		}
+	case protocol.FrameTypeDelete:
+		question = "delete " + file
+	case protocol.FrameTypeRename:
+		question = fmt.Sprintf("move %s to %s", path.Clean("/"+req.OldPath), path.Clean("/"+req.NewPath))
+	case protocol.FrameTypeMkdir:
+		question = "create the directory " + file
+	default:
+		return true
+	}
+
+	fmt.Fprintf(w.out, "\nThe receiver wants to %s. Allow? [y/N/always] ", question)
+	answer, err := w.in.ReadString('\n')
+	if err != nil && answer == "" {
+		// Nobody is left to answer
+		fmt.Fprintln(w.out)
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		if frame.Type == protocol.FrameTypeWrite || frame.Type == protocol.FrameTypeCopy {
+			p.files[file] = true
+		}
+		return true
+	case "a", "always":
+		p.always = true
+		return true
+	}
+	return false
+}
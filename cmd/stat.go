@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var statCmd = &cobra.Command{
+	Use:   "stat <session-id> <path>",
+	Short: "Show details of one file in a shared session",
+	Long: `Show the type, size, mode, owner and modification time of a file or
+directory of a shared folder, and with --checksum the SHA-256 checksum of a
+file as the sharer computes it. Paths are relative to the shared folder.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runStat,
+}
+
+// statChecksum is --checksum
+var statChecksum bool
+
+func init() {
+	rootCmd.AddCommand(statCmd)
+	addRelayFlags(statCmd)
+	addPasscodeFlags(statCmd)
+	statCmd.Flags().BoolVarP(&statChecksum, "checksum", "c", false, "Also show the SHA-256 checksum of a file; the sharer reads the whole file for it")
+}
+
+// statResult is the entry printed by --json
+type statResult struct {
+	lsEntry
+	Type   string `json:"type"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+func runStat(cmd *cobra.Command, args []string) error {
+	remotePath := path.Join("/", args[1])
+
+	tun, client, err := dialSession(args[0])
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tun.Close(); err != nil {
+			slog.Warn("failed to close tunnel", "err", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	info, err := client.Stat(ctx, remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", remotePath, err)
+	}
+	result := statResult{lsEntry: newLsEntry(*info, remotePath), Type: fileType(os.FileMode(info.Mode))}
+
+	if statChecksum {
+		if info.IsDir {
+			return errors.New("--checksum needs a file, " + remotePath + " is a directory")
+		}
+		sum, err := client.Hash(ctx, remotePath)
+		if err != nil {
+			return fmt.Errorf("failed to read the checksum of %s: %w", remotePath, err)
+		}
+		result.SHA256 = hex.EncodeToString(sum)
+	}
+
+	if jsonOutput {
+		return printJSON(result)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Path:\t%s\n", result.Path)
+	fmt.Fprintf(w, "Type:\t%s\n", result.Type)
+	fmt.Fprintf(w, "Size:\t%s (%d bytes)\n", formatBytes(result.Size), result.Size)
+	fmt.Fprintf(w, "Mode:\t%s (%04o)\n", result.Mode, os.FileMode(info.Mode).Perm())
+	fmt.Fprintf(w, "Modified:\t%s\n", result.ModTime.Format(time.RFC3339))
+	if result.Owner != "" {
+		fmt.Fprintf(w, "Owner:\t%s\n", result.Owner)
+	}
+	if result.SHA256 != "" {
+		fmt.Fprintf(w, "SHA-256:\t%s\n", result.SHA256)
+	}
+	return w.Flush()
+}
+
+// fileType names the kind of a file for orb stat
+func fileType(mode os.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "directory"
+	case mode&os.ModeSymlink != 0:
+		return "symlink"
+	case mode.IsRegular():
+		return "file"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/daemon"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status [session-id]",
+	Short: "Show shares running in the background",
+	Long: `List the shares started with "orb share --daemon" that are still running,
+with whether a receiver is connected and how much has been served. With a
+session ID only that share is shown.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runStatus,
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
+
+func runStatus(cmd *cobra.Command, args []string) error {
+	var statuses []daemon.Status
+	if len(args) == 1 {
+		status, err := daemon.Query(args[0])
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		statuses = append(statuses, status)
+	} else {
+		var err error
+		statuses, err = runningShares(true)
+		if err != nil {
+			return err
+		}
+	}
+
+	if jsonOutput {
+		return printJSON(statuses)
+	}
+
+	if len(statuses) == 0 {
+		fmt.Println("No shares are running in the background.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SESSION\tPID\tSTATE\tUPTIME\tREQUESTS\tSENT\tPATH")
+	for _, s := range statuses {
+		state := "waiting"
+		if s.Connected {
+			state = "connected"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%d\t%s\t%s\n",
+			s.SessionID, s.PID, state, time.Since(s.Started).Round(time.Second),
+			s.Requests, formatBytes(s.BytesSent), s.Path)
+	}
+	return w.Flush()
+}
+
+// runningShares returns the status of the shares running on this machine,
+// oldest first. With background set only shares started with --daemon are
+// included.
+func runningShares(background bool) ([]daemon.Status, error) {
+	infos, err := daemon.List()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]daemon.Status, 0, len(infos))
+	for _, info := range infos {
+		if background && !info.Background {
+			continue
+		}
+		status, err := daemon.Query(info.SessionID)
+		if errors.Is(err, daemon.ErrNotFound) {
+			continue // ended while being listed
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", info.SessionID, err)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Zayan-Mohamed/orb/internal/daemon"
+	"github.com/spf13/cobra"
+)
+
+var stopCmd = &cobra.Command{
+	Use:   "stop [session-id]",
+	Short: "Stop a share running in the background",
+	Long: `Stop a share started with "orb share --daemon". A connected receiver is
+disconnected. Use --all to stop every background share.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runStop,
+}
+
+var stopAll bool
+
+func init() {
+	rootCmd.AddCommand(stopCmd)
+	stopCmd.Flags().BoolVar(&stopAll, "all", false, "Stop all background shares")
+}
+
+func runStop(cmd *cobra.Command, args []string) error {
+	var ids []string
+	switch {
+	case stopAll && len(args) == 0:
+		infos, err := daemon.List()
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			if info.Background {
+				ids = append(ids, info.SessionID)
+			}
+		}
+	case !stopAll && len(args) == 1:
+		ids = args
+	default:
+		return errors.New("give either a session ID or --all")
+	}
+
+	for _, id := range ids {
+		if err := daemon.Stop(id); err != nil {
+			if errors.Is(err, daemon.ErrNotFound) {
+				return fmt.Errorf("%s: %w, see orb status", id, err)
+			}
+			return fmt.Errorf("%s: %w", id, err)
+		}
+
+		if jsonOutput {
+			if err := printJSON(event{Event: "stopped", SessionID: id}); err != nil {
+				return err
+			}
+		} else {
+			fmt.Printf("✓ Stopped %s\n", id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/Zayan-Mohamed/orb/internal/manifest"
+	"github.com/Zayan-Mohamed/orb/internal/mirror"
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	"github.com/spf13/cobra"
+)
+
+var syncCmd = &cobra.Command{
+	Use:   "sync <session-id> <remote-path> <local-dir>",
+	Short: "Copy only what changed between a share and a local directory",
+	Long: `Make a local directory match a directory of a shared folder, transferring
+only new and changed files. With --push the direction is reversed and the
+shared directory is made to match the local one, which needs a writable share.
+
+Files are compared by size and modification time, or by SHA-256 checksum
+with --checksum. Of a changed file only the blocks that differ from the
+destination's copy are transferred, unless --whole-file is given.
+
+With --push --atomic the sharer stages every change and makes them all
+once the last file is complete, so that a push cut short by a disconnect
+leaves the shared directory as it was.`,
+	Args: cobra.ExactArgs(3),
+	RunE: runSync,
+}
+
+var (
+	syncPush     bool
+	syncDelete   bool
+	syncDryRun   bool
+	syncChecksum bool
+	syncWhole    bool
+	syncAtomic   bool
+)
+
+func init() {
+	rootCmd.AddCommand(syncCmd)
+	addRelayFlags(syncCmd)
+	addPasscodeFlags(syncCmd)
+	syncCmd.Flags().BoolVar(&syncPush, "push", false, "Upload the local directory to the share instead")
+	syncCmd.Flags().BoolVar(&syncDelete, "delete", false, "Delete files that no longer exist in the source")
+	syncCmd.Flags().BoolVarP(&syncDryRun, "dry-run", "n", false, "Show what would change without changing anything")
+	syncCmd.Flags().BoolVarP(&syncChecksum, "checksum", "c", false, "Compare file contents instead of modification times")
+	syncCmd.Flags().BoolVarP(&syncWhole, "whole-file", "W", false, "Copy changed files in full instead of only the changed blocks")
+	syncCmd.Flags().BoolVar(&syncAtomic, "atomic", false, "With --push, make all changes or none, also when cut short")
+	syncCmd.Flags().BoolVar(&verifyTransfers, "verify", false, "Check every copied file against the sharer's SHA-256 checksum")
+	syncCmd.Flags().IntVar(&concurrency, "concurrency", transfer.DefaultConcurrency, "Number of files to copy at the same time")
+	addManifestFlags(syncCmd)
+	addProgressFlag(syncCmd)
+}
+
+func runSync(cmd *cobra.Command, args []string) error {
+	sessionID, remoteDir := args[0], args[1]
+
+	localDir, err := filepath.Abs(args[2])
+	if err != nil {
+		return fmt.Errorf("invalid local directory: %w", err)
+	}
+	// Files are copied side by side, a bar redrawn in place can only show one
+	if progressMode == progressBar {
+		return errors.New("--progress bar shows a single file, use plain or json with sync")
+	}
+
+	if manifestFile != "" && (syncPush || syncDryRun) {
+		return errors.New("--manifest checks downloaded files, it cannot be combined with --push or --dry-run")
+	}
+	if syncAtomic && !syncPush {
+		return errors.New("--atomic applies to --push")
+	}
+
+	tun, client, err := dialSession(sessionID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tun.Close(); err != nil {
+			slog.Warn("failed to close tunnel", "err", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if syncAtomic && !syncDryRun {
+		info, err := client.Info(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read session details: %w", err)
+		}
+		if !info.Transactions {
+			return errors.New("the sharer cannot stage changes for --atomic, it needs a newer version of orb or a writable share")
+		}
+	}
+
+	var signed *manifest.Manifest
+	if manifestFile != "" {
+		info, err := client.Info(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read session details: %w", err)
+		}
+		if signed, err = fetchManifest(ctx, client, info, sessionID); err != nil {
+			return err
+		}
+	}
+
+	direction := mirror.Pull
+	if syncPush {
+		direction = mirror.Push
+	}
+	progress := newProgressReporter(os.Stderr, progressNone)
+	m := mirror.New(client, direction, remoteDir, localDir, mirror.Options{
+		Delete:    syncDelete,
+		Checksum:  syncChecksum,
+		Verify:    verifyTransfers,
+		WholeFile: syncWhole,
+		Atomic:    syncAtomic,
+		Observer:  transferObserver(sessionID),
+		Progress: func(t transfer.Transfer) {
+			progress.update(syncRelPath(localDir, t.LocalPath), t.Transferred, t.Size, t.Speed)
+		},
+	})
+
+	changes, err := syncOnce(ctx, m, localDir, syncDryRun)
+	if err != nil {
+		return err
+	}
+	if changes == 0 {
+		if jsonOutput {
+			if err := printJSON(event{Event: "synced"}); err != nil {
+				return err
+			}
+		} else {
+			fmt.Println("Already up to date.")
+		}
+	}
+	if signed != nil {
+		return checkAgainstManifest(signed, remoteDir, localDir)
+	}
+	return nil
+}
+
+// syncOnce plans and applies one synchronization pass, printing each change.
+// It returns the number of planned changes.
+func syncOnce(ctx context.Context, m *mirror.Mirror, localDir string, dryRun bool) (int, error) {
+	actions, err := m.Plan(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(actions) == 0 {
+		return 0, nil
+	}
+
+	var copies int
+	var size int64
+	for _, a := range actions {
+		if dryRun {
+			printAction(a)
+		}
+		if a.Kind == mirror.Copy {
+			copies++
+			size += a.Size
+		}
+	}
+	if dryRun {
+		if jsonOutput {
+			return len(actions), printJSON(event{Event: "dry_run", Files: copies, Size: size})
+		}
+		fmt.Printf("Dry run: %d changes, %d files (%s) to copy\n", len(actions), copies, formatBytes(size))
+		return len(actions), nil
+	}
+
+	for _, a := range actions {
+		if a.Kind != mirror.Copy {
+			printAction(a)
+		}
+	}
+
+	err = m.Apply(ctx, actions, concurrency, func(t transfer.Transfer) {
+		rel := syncRelPath(localDir, t.LocalPath)
+		switch {
+		case jsonOutput && t.State == transfer.StateDone:
+			_ = printJSON(event{Event: "copied", Path: rel, Size: t.Size, Reused: t.Reused, SHA256: hex.EncodeToString(t.SHA256)})
+		case jsonOutput:
+			_ = printJSON(event{Event: "failed", Path: rel, Error: fmt.Sprint(t.Err)})
+		case t.State == transfer.StateDone:
+			fmt.Printf("✓ %s (%s)\n", rel, formatCopied(t))
+		default:
+			fmt.Fprintf(os.Stderr, "✗ %s: %v\n", rel, t.Err)
+		}
+	})
+	if err != nil {
+		return len(actions), err
+	}
+
+	if copies > 0 {
+		if jsonOutput {
+			return len(actions), printJSON(event{Event: "synced", Files: copies, Size: size})
+		}
+		fmt.Printf("Synced %d files (%s)\n", copies, formatBytes(size))
+	}
+	return len(actions), nil
+}
+
+// formatCopied describes the size of a copied file, how much of it was sent
+// when the rest was already at the destination, and its verification
+func formatCopied(t transfer.Transfer) string {
+	details := formatBytes(t.Size)
+	if t.Reused > 0 {
+		details += fmt.Sprintf(", %s sent", formatBytes(t.Size-t.Reused))
+	}
+	if t.SHA256 != nil {
+		details += ", checksum verified"
+	}
+	return details
+}
+
+// syncRelPath names a copied file relative to the local directory of a sync
+func syncRelPath(localDir, localPath string) string {
+	rel, _ := filepath.Rel(localDir, localPath)
+	return filepath.ToSlash(rel)
+}
+
+// printAction reports a planned change, as text or as a JSON event named
+// after the action ("copy", "mkdir" or "delete")
+func printAction(a mirror.Action) {
+	if !jsonOutput {
+		fmt.Println(formatAction(a))
+		return
+	}
+
+	name := "copy"
+	switch a.Kind {
+	case mirror.Delete:
+		name = "delete"
+	case mirror.Mkdir:
+		name = "mkdir"
+	}
+	_ = printJSON(event{Event: name, Path: a.Path, Size: a.Size})
+}
+
+// formatAction describes a planned change on one line
+func formatAction(a mirror.Action) string {
+	switch a.Kind {
+	case mirror.Delete:
+		return "- " + a.Path
+	case mirror.Mkdir:
+		return "+ " + a.Path + "/"
+	default:
+		return fmt.Sprintf("> %s (%s)", a.Path, formatBytes(a.Size))
+	}
+}
+
+// formatBytes renders a byte count with a binary unit
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
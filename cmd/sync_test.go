@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/filesystem"
+	"github.com/Zayan-Mohamed/orb/internal/mirror"
+	"github.com/Zayan-Mohamed/orb/internal/relay"
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/internal/tunnel"
+)
+
+// testShare shares dir through a relay of its own and returns a client
+// connected to it. The share answers the connection, as orb share does.
+func testShare(t *testing.T, dir string) *remote.Client {
+	t.Helper()
+	rs := relay.NewRelayServer()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/share", rs.HandleShare)
+	mux.HandleFunc("/connect", rs.HandleConnect)
+	mux.HandleFunc("/session/create", rs.HandleCreateSession)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	sessionID, code, err := createSession(srv.URL, dir, 0)
+	if err != nil {
+		t.Fatalf("createSession: %v", err)
+	}
+	fs, err := filesystem.NewSecureFilesystem(dir, false)
+	if err != nil {
+		t.Fatalf("NewSecureFilesystem: %v", err)
+	}
+
+	shared := make(chan error, 1)
+	go func() {
+		tun, err := tunnel.NewTunnel(srv.URL, sessionID, code, false)
+		if err != nil {
+			shared <- err
+			return
+		}
+		t.Cleanup(func() { _ = tun.Close() })
+		shared <- nil
+		_ = handleShareRequests(tun, fs, nil, 0, shareControls{})
+	}()
+
+	tun, err := tunnel.NewTunnel(srv.URL, sessionID, code, true)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { _ = tun.Close() })
+	if err := <-shared; err != nil {
+		t.Fatalf("share: %v", err)
+	}
+	return remote.NewClient(tunnel.NewMux(tun))
+}
+
+func TestSyncPushShrinksFiles(t *testing.T) {
+	for name, whole := range map[string]bool{"delta": false, "whole-file": true} {
+		t.Run(name, func(t *testing.T) {
+			sharedDir, localDir := t.TempDir(), t.TempDir()
+			if err := os.WriteFile(filepath.Join(sharedDir, "notes.txt"), bytes.Repeat([]byte("old "), 750), 0640); err != nil {
+				t.Fatal(err)
+			}
+			want := []byte("shorter than before")
+			if err := os.WriteFile(filepath.Join(localDir, "notes.txt"), want, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			m := mirror.New(testShare(t, sharedDir), mirror.Push, "/", localDir, mirror.Options{WholeFile: whole})
+			actions, err := m.Plan(ctx)
+			if err != nil {
+				t.Fatalf("Plan: %v", err)
+			}
+			if err := m.Apply(ctx, actions, 1, nil); err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+
+			got, err := os.ReadFile(filepath.Join(sharedDir, "notes.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("shared file is %d bytes %q, want %q", len(got), got[:min(len(got), 40)], want)
+			}
+			info, err := os.Stat(filepath.Join(sharedDir, "notes.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if runtime.GOOS != "windows" && info.Mode().Perm() != 0640 {
+				t.Errorf("shared file has mode %v, want it kept at %v", info.Mode().Perm(), os.FileMode(0640))
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/journal"
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	"github.com/spf13/cobra"
+)
+
+var transfersCmd = &cobra.Command{
+	Use:   "transfers",
+	Short: "List and resume the transfers made on this machine",
+	Long: `Every download and upload made by orb get, receive, sync, watch, the browser
+and the background daemon is recorded in transfers.db in the configuration
+directory. Transfers whose process ended before they did are shown as
+interrupted and can be continued with orb transfers resume, even after a
+reboot. Without a subcommand the transfers are listed.`,
+	Args: cobra.NoArgs,
+	RunE: runTransfersList,
+}
+
+var transfersListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the recorded transfers, oldest first",
+	Args:  cobra.NoArgs,
+	RunE:  runTransfersList,
+}
+
+var transfersResumeCmd = &cobra.Command{
+	Use:   "resume <id>",
+	Short: "Continue an interrupted or failed transfer",
+	Long: `Reconnect to the transfer's session and continue it. A download keeps the
+bytes it already has as long as they match the start of the remote file, an
+upload the bytes the sharer already has. Otherwise only the blocks that
+differ are moved. The result is checked against the sharer's SHA-256
+checksum. A session ends with its receiver, so a share started again for the
+rest of the transfer is given with --session.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runTransfersResume,
+}
+
+var transfersClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Forget finished and interrupted transfers",
+	Args:  cobra.NoArgs,
+	RunE:  runTransfersClear,
+}
+
+// resumeSession is --session of orb transfers resume
+var resumeSession string
+
+func init() {
+	rootCmd.AddCommand(transfersCmd)
+	transfersCmd.AddCommand(transfersListCmd, transfersResumeCmd, transfersClearCmd)
+	addRelayFlags(transfersResumeCmd)
+	addPasscodeFlags(transfersResumeCmd)
+	transfersResumeCmd.Flags().StringVar(&resumeSession, "session", "", "Continue through this session instead of the transfer's own")
+	addProgressFlag(transfersResumeCmd)
+}
+
+// transferObserver records the transfers of a session in transfers.db, or
+// returns nil when there is nowhere to record them
+func transferObserver(sessionID string) func(transfer.Transfer) {
+	j, err := journal.Open()
+	if err != nil {
+		slog.Debug("not recording transfers", "err", err)
+		return nil
+	}
+	return j.Observer(sessionID)
+}
+
+// journalMoved records that a finished download was moved into place
+func journalMoved(from, to string) {
+	j, err := journal.Open()
+	if err == nil {
+		err = j.Moved(from, to)
+	}
+	if err != nil {
+		slog.Debug("failed to record transfer", "err", err)
+	}
+}
+
+func runTransfersList(cmd *cobra.Command, args []string) error {
+	j, err := journal.Open()
+	if err != nil {
+		return err
+	}
+	records, err := j.Records()
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		if records == nil {
+			records = []journal.Record{}
+		}
+		return printJSON(records)
+	}
+
+	if len(records) == 0 {
+		fmt.Println("No transfers recorded on this machine.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tPROGRESS\tSESSION\tUPDATED\tTRANSFER")
+	for _, r := range records {
+		progress := formatBytes(r.Transferred)
+		if r.Size > 0 && r.State != transfer.StateDone.String() {
+			progress = fmt.Sprintf("%s of %s", formatBytes(r.Transferred), formatBytes(r.Size))
+		}
+		route := r.Remote + " → " + r.Local
+		if r.Direction == transfer.Upload.String() {
+			route = r.Local + " → " + r.Remote
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s ago\t%s\n",
+			r.ID, r.State, progress, r.SessionID, time.Since(r.Updated).Round(time.Second), route)
+	}
+	return w.Flush()
+}
+
+func runTransfersClear(cmd *cobra.Command, args []string) error {
+	j, err := journal.Open()
+	if err != nil {
+		return err
+	}
+	n, err := j.Clear()
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		return printJSON(event{Event: "cleared", Files: n})
+	}
+	fmt.Printf("✓ Cleared %d transfers\n", n)
+	return nil
+}
+
+func runTransfersResume(cmd *cobra.Command, args []string) error {
+	j, err := journal.Open()
+	if err != nil {
+		return err
+	}
+	rec, err := j.Find(args[0])
+	if err != nil {
+		return fmt.Errorf("%w, see orb transfers list", err)
+	}
+	switch rec.State {
+	case transfer.StateDone.String():
+		return fmt.Errorf("transfer %s is already done", rec.ID)
+	case journal.StateInterrupted, transfer.StateFailed.String(), transfer.StateCancelled.String():
+	default:
+		return fmt.Errorf("transfer %s is still %s in process %d", rec.ID, rec.State, rec.PID)
+	}
+	if resumeSession != "" {
+		rec.SessionID = resumeSession
+	}
+	if rec.SessionID == "" {
+		return fmt.Errorf("transfer %s does not belong to a session, give one with --session", rec.ID)
+	}
+
+	tun, client, err := dialSession(rec.SessionID)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tun.Close() }()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if rec.Direction == transfer.Upload.String() {
+		return resumeUpload(ctx, client, j, rec)
+	}
+	return resumeDownloadRecord(ctx, client, j, rec)
+}
+
+// resumeDownloadRecord continues a recorded download
+func resumeDownloadRecord(ctx context.Context, client *remote.Client, j *journal.Journal, rec journal.Record) error {
+	stat, err := client.Stat(ctx, rec.Remote)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", rec.Remote, err)
+	}
+	if stat.IsDir {
+		return fmt.Errorf("%s is now a directory", rec.Remote)
+	}
+
+	// orb get and receive download next to the target and move the file
+	// into place once it is verified
+	resumeDownload = true
+	if target, ok := strings.CutSuffix(rec.Local, ".orb-partial"); ok {
+		return receiveToFile(ctx, client, rec.Remote, target, stat.Size, j.ObserveAs(rec))
+	}
+
+	offset, err := resumeOffset(ctx, client, rec.Remote, rec.Local, stat.Size)
+	if err != nil {
+		return err
+	}
+	if !jsonOutput {
+		fmt.Printf("Resuming %s at %s of %s\n", filepath.Base(rec.Local), formatBytes(offset), formatBytes(stat.Size))
+	}
+	return runResumed(ctx, client, j, rec, transfer.Download, stat.Size, offset)
+}
+
+// resumeUpload continues a recorded upload from what the sharer already has,
+// if that matches the start of the local file
+func resumeUpload(ctx context.Context, client *remote.Client, j *journal.Journal, rec journal.Record) error {
+	info, err := os.Stat(rec.Local)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", rec.Local)
+	}
+	size := info.Size()
+
+	var offset int64
+	if stat, err := client.Stat(ctx, rec.Remote); err == nil && !stat.IsDir && stat.Size > 0 && stat.Size <= size {
+		localSum, err := hashLocalPrefix(rec.Local, stat.Size)
+		if err != nil {
+			return err
+		}
+		remoteSum, err := client.HashPrefix(ctx, rec.Remote, stat.Size)
+		if err != nil {
+			return fmt.Errorf("failed to check the partial upload: %w", err)
+		}
+		if bytes.Equal(localSum, remoteSum) {
+			offset = stat.Size
+		}
+	}
+
+	if !jsonOutput {
+		fmt.Printf("Resuming %s at %s of %s\n", path.Base(rec.Remote), formatBytes(offset), formatBytes(size))
+	}
+	return runResumed(ctx, client, j, rec, transfer.Upload, size, offset)
+}
+
+// runResumed runs a resumed transfer from offset, reusing the blocks of the
+// existing copy when it has to start over, and verifies the result
+func runResumed(ctx context.Context, client *remote.Client, j *journal.Journal, rec journal.Record, dir transfer.Direction, size, offset int64) error {
+	manager := transfer.NewManager(client, 1)
+	manager.SetParallel(parallel)
+	manager.SetVerify(true)
+	manager.SetDelta(true)
+	manager.SetObserver(j.ObserveAs(rec))
+	id := manager.EnqueueFrom(dir, rec.Remote, rec.Local, size, offset)
+	if err := waitTransfer(ctx, manager, id, rec.Remote); err != nil {
+		return fmt.Errorf("%w, run orb transfers resume %s to continue", err, rec.ID)
+	}
+
+	if jsonOutput {
+		return printJSON(event{Event: "resumed", Path: rec.Local, Target: rec.Remote, Size: size})
+	}
+	fmt.Printf("✓ Resumed %s, checksum verified\n", filepath.Base(rec.Local))
+	return nil
+}
+
+// hashLocalPrefix returns the SHA-256 checksum of the first n bytes of a
+// local file
+func hashLocalPrefix(name string, n int64) ([]byte, error) {
+	// #nosec G304 -- the file was recorded by an earlier transfer of this user
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	h := sha256.New()
+	if _, err := io.CopyN(h, file, n); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s is shorter than %d bytes", name, n)
+		}
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/spf13/cobra"
+)
+
+var treeCmd = &cobra.Command{
+	Use:   "tree <session-id> [path]",
+	Short: "Show the directory tree of a shared session",
+	Long: `Show a directory of a shared folder and everything below it as a tree, with
+the size of every file and the total at the end. Paths are relative to the
+shared folder; the default is its root.
+
+--depth limits how far down the tree is shown, and --pattern and --exclude
+pick the files shown by name, e.g. --pattern '*.jpg'.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runTree,
+}
+
+var (
+	treeDepth    int
+	treePattern  string
+	treeExcludes []string
+)
+
+func init() {
+	rootCmd.AddCommand(treeCmd)
+	addRelayFlags(treeCmd)
+	addPasscodeFlags(treeCmd)
+	treeCmd.Flags().IntVarP(&treeDepth, "depth", "L", 0, "Descend at most this many levels, 0 for no limit")
+	treeCmd.Flags().StringVarP(&treePattern, "pattern", "P", "", "Only show files whose name matches this glob, and the directories leading to them")
+	treeCmd.Flags().StringArrayVarP(&treeExcludes, "exclude", "I", nil, "Leave out files and directories whose name matches this glob (repeatable)")
+}
+
+// treeNode is an entry of the tree, as printed by --json
+type treeNode struct {
+	Name     string      `json:"name"`
+	Size     int64       `json:"size"`
+	IsDir    bool        `json:"is_dir"`
+	Children []*treeNode `json:"children,omitempty"`
+}
+
+// treeCounts sums up a tree for its last line
+type treeCounts struct {
+	dirs, files int
+	size        int64
+}
+
+func runTree(cmd *cobra.Command, args []string) error {
+	dir := "/"
+	if len(args) == 2 {
+		dir = path.Join("/", args[1])
+	}
+	if treeDepth < 0 {
+		return errors.New("--depth cannot be negative")
+	}
+	for _, pattern := range append([]string{treePattern}, treeExcludes...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	tun, client, err := dialSession(args[0])
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tun.Close(); err != nil {
+			slog.Warn("failed to close tunnel", "err", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	root := &treeNode{Name: dir, IsDir: true}
+	if root.Children, err = walkTree(ctx, client, dir, 1); err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(root)
+	}
+
+	var counts treeCounts
+	fmt.Println(dir)
+	printTree(root.Children, "", &counts)
+	fmt.Printf("\n%d directories, %d files, %s\n", counts.dirs, counts.files, formatBytes(counts.size))
+	return nil
+}
+
+// walkTree lists dir and, down to --depth, the directories below it.
+// Directories come first, each group sorted by name.
+func walkTree(ctx context.Context, client *remote.Client, dir string, depth int) ([]*treeNode, error) {
+	files, err := client.List(ctx, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+
+	var nodes []*treeNode
+	for _, f := range files {
+		if treeExcluded(f.Name) {
+			continue
+		}
+		node := &treeNode{Name: f.Name, Size: f.Size, IsDir: f.IsDir}
+		if !f.IsDir {
+			if treePattern != "" {
+				if ok, _ := path.Match(treePattern, f.Name); !ok {
+					continue
+				}
+			}
+			nodes = append(nodes, node)
+			continue
+		}
+
+		if treeDepth == 0 || depth < treeDepth {
+			if node.Children, err = walkTree(ctx, client, path.Join(dir, f.Name), depth+1); err != nil {
+				return nil, err
+			}
+		}
+		// With --pattern, only directories leading to a match are of interest
+		if treePattern != "" && len(node.Children) == 0 {
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+
+	slices.SortFunc(nodes, func(a, b *treeNode) int {
+		if a.IsDir != b.IsDir {
+			if a.IsDir {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return nodes, nil
+}
+
+// treeExcluded reports whether --exclude leaves a name out
+func treeExcluded(name string) bool {
+	for _, pattern := range treeExcludes {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// printTree draws nodes below a line starting with indent
+func printTree(nodes []*treeNode, indent string, counts *treeCounts) {
+	for i, node := range nodes {
+		branch, next := "├── ", "│   "
+		if i == len(nodes)-1 {
+			branch, next = "└── ", "    "
+		}
+
+		if node.IsDir {
+			counts.dirs++
+			fmt.Printf("%s%s%s/\n", indent, branch, node.Name)
+			printTree(node.Children, indent+next, counts)
+			continue
+		}
+		counts.files++
+		counts.size += node.Size
+		fmt.Printf("%s%s%s  (%s)\n", indent, branch, node.Name, formatBytes(node.Size))
+	}
+}
@@ -1,41 +1,72 @@
 package cmd
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"math"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/internal/telemetry"
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	"github.com/Zayan-Mohamed/orb/internal/tunnel"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
-// createSession creates a new session with the relay server
-func createSession(relayURL, sharedPath string) (string, string, error) {
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
+// createSession creates a new session with the relay server. A ttl above
+// zero has the relay end the session early.
+func createSession(relayURL, sharedPath string, ttl time.Duration) (sessionID, passcode string, err error) {
+	client := relayHTTPClient(10 * time.Second)
 
-	reqBody := map[string]string{
+	reqBody := map[string]any{
 		"shared_path": sharedPath,
 	}
+	if ttl > 0 {
+		reqBody["ttl_seconds"] = int64(ttl.Seconds())
+	}
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	resp, err := client.Post(
-		relayURL+"/session/create",
-		"application/json",
-		bytes.NewReader(jsonData),
-	)
+	req, err := http.NewRequest(http.MethodPost, relayURL+"/session/create", bytes.NewReader(jsonData))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid relay URL: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if relayToken != "" {
+		req.Header.Set("Authorization", "Bearer "+relayToken)
+	}
+	_, span := telemetry.Start(context.Background(), "session create", telemetry.KindClient)
+	if tp := span.Context().Traceparent(); tp != "" {
+		req.Header.Set("Traceparent", tp)
+	}
+	defer func() { span.End(err) }()
+
+	resp, err := client.Do(req)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to contact relay: %w", err)
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to close response body: %v\n", err)
+			slog.Warn("failed to close response body", "err", err)
 		}
 	}()
 
@@ -52,6 +83,255 @@ func createSession(relayURL, sharedPath string) (string, string, error) {
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return "", "", fmt.Errorf("failed to decode response: %w", err)
 	}
+	span.SetAttributes(slog.String("orb.session", result.SessionID))
 
 	return result.SessionID, result.Passcode, nil
 }
+
+// revokeSession ends a session at the relay, disconnecting both peers, so
+// that it cannot be joined again once orb is gone
+func revokeSession(relayURL, sessionID, passcode string) error {
+	client := relayHTTPClient(5 * time.Second)
+
+	jsonData, err := json.Marshal(map[string]string{
+		"session_id": sessionID,
+		"passcode":   passcode,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, relayURL+"/session/revoke", bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("invalid relay URL: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if relayToken != "" {
+		req.Header.Set("Authorization", "Bearer "+relayToken)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to contact relay: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			slog.Warn("failed to close response body", "err", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("relay error: %s", strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// endSession revokes the session once, however often it is called. Failing
+// is not fatal since the relay expires sessions on its own.
+func endSession(sessionID, passcode string) func() {
+	return sync.OnceFunc(func() {
+		if err := revokeSession(relayURL, sessionID, passcode); err != nil {
+			slog.Debug("failed to revoke session", "session", sessionID, "err", err)
+		}
+	})
+}
+
+// defaultDownloadDir is where downloads land unless configured otherwise:
+// ~/Downloads/orb, or the current directory when there is no home directory
+func defaultDownloadDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(home, "Downloads", "orb")
+}
+
+// resolveDownloadDir returns the absolute form of dir, creating it if needed
+func resolveDownloadDir(dir string) (string, error) {
+	dir, err := config.ExpandHome(dir)
+	if err != nil {
+		return "", err
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("invalid output directory: %w", err)
+	}
+
+	if err := os.MkdirAll(absDir, 0750); err != nil {
+		return "", fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	info, err := os.Stat(absDir)
+	if err != nil {
+		return "", fmt.Errorf("output directory does not exist: %w", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("output path must be a directory")
+	}
+
+	return absDir, nil
+}
+
+// dialSession connects to a share for a command, prompting for the passcode
+// when none was given. Prompts go to stderr so stdout stays usable in pipes.
+// The tunnel is kept alive while idle, e.g. for orb watch, and the caller
+// closes it.
+func dialSession(sessionID string) (*tunnel.Tunnel, *remote.Client, error) {
+	if err := resolvePasscode(); err != nil {
+		return nil, nil, err
+	}
+
+	// Connector is the initiator (starts the handshake)
+	tun, err := dialAnyRelay(sessionID, passcode, relayDialOptions()...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	mux := tunnel.NewMux(tun)
+	mux.KeepAlive()
+	return tun, remote.NewClient(mux), nil
+}
+
+// addPasscodeFlags registers --passcode and --passcode-file on a command
+// that connects to a session, see resolvePasscode
+func addPasscodeFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&passcode, "passcode", "p", "", "Session passcode, \"-\" to read it from stdin (will prompt if not provided)")
+	cmd.Flags().StringVar(&passcodeFile, "passcode-file", "", "Read the session passcode from this file")
+}
+
+// resolvePasscode settles the passcode for connecting. In order of
+// precedence it comes from --passcode-file, from stdin with "--passcode -",
+// from --passcode or ORB_PASSCODE, or from a prompt.
+func resolvePasscode() error {
+	var code string
+	switch {
+	case passcodeFile != "":
+		path, err := config.ExpandHome(passcodeFile)
+		if err != nil {
+			return err
+		}
+		// #nosec G304 -- the passcode file is chosen by the user
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read passcode file: %w", err)
+		}
+		code, _, _ = strings.Cut(string(data), "\n")
+	case passcode == "-":
+		line, err := readLine(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read passcode: %w", err)
+		}
+		code = line
+	case passcode != "":
+		return nil
+	default:
+		line, err := readPasscode()
+		if err != nil {
+			return err
+		}
+		code = line
+	}
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return errors.New("no passcode given")
+	}
+	passcode = code
+	return nil
+}
+
+// readPasscode asks for the passcode on stderr. On a terminal the input is
+// not echoed.
+func readPasscode() (string, error) {
+	fmt.Fprint(os.Stderr, "Enter passcode: ")
+
+	var line string
+	var err error
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		defer restoreTerminalOnInterrupt(fd)()
+		var b []byte
+		b, err = term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		line = string(b)
+	} else {
+		line, err = readLine(os.Stdin)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read passcode: %w", err)
+	}
+	return line, nil
+}
+
+// restoreTerminalOnInterrupt puts the terminal back the way it was when
+// Ctrl+C or SIGTERM arrives before the returned function is called, then
+// exits. Otherwise an interrupted prompt would leave echo turned off.
+func restoreTerminalOnInterrupt(fd int) func() {
+	state, err := term.GetState(fd)
+	if err != nil {
+		return func() {}
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-sig; ok {
+			_ = term.Restore(fd, state)
+			fmt.Fprintln(os.Stderr)
+			os.Exit(130)
+		}
+	}()
+
+	return func() {
+		signal.Stop(sig)
+		close(sig)
+	}
+}
+
+// readLine reads a single line, which may lack its line break at the end of input
+func readLine(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return line, nil
+}
+
+// byteSize is a flag value for sizes such as "500K" or "2M". Units are
+// binary (K = 1024 bytes) and a plain number is bytes.
+type byteSize int64
+
+func (s *byteSize) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+func (s *byteSize) Set(raw string) error {
+	value := strings.ToUpper(strings.TrimSpace(raw))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+
+	multiplier := 1.0
+	if i := strings.IndexAny(value, "KMGT"); i >= 0 && i == len(value)-1 {
+		multiplier = math.Pow(1024, float64(strings.IndexByte("KMGT", value[i])+1))
+		value = value[:i]
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q, expected something like 500K or 2M", raw)
+	}
+	*s = byteSize(n * multiplier)
+	return nil
+}
+
+func (s *byteSize) Type() string {
+	return "size"
+}
+
+// checkParallel validates --parallel
+func checkParallel() error {
+	if parallel < 1 || parallel > transfer.MaxParallel {
+		return fmt.Errorf("--parallel must be between 1 and %d", transfer.MaxParallel)
+	}
+	return nil
+}
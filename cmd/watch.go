@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/Zayan-Mohamed/orb/internal/mirror"
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	"github.com/Zayan-Mohamed/orb/internal/tunnel"
+	"github.com/Zayan-Mohamed/orb/internal/watch"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+	"github.com/spf13/cobra"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch <session-id> <remote-path> <local-dir>",
+	Short: "Keep a local directory in sync with a share",
+	Long: `Synchronize like "orb sync", then keep running and apply every change the
+sharer reports, turning the local directory into a live mirror. With --push
+local changes are sent to a writable share instead.`,
+	Args: cobra.ExactArgs(3),
+	RunE: runWatch,
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+	addRelayFlags(watchCmd)
+	addPasscodeFlags(watchCmd)
+	watchCmd.Flags().BoolVar(&syncPush, "push", false, "Mirror the local directory to the share instead")
+	watchCmd.Flags().BoolVar(&syncDelete, "delete", false, "Delete files that no longer exist in the source")
+	watchCmd.Flags().BoolVarP(&syncChecksum, "checksum", "c", false, "Compare file contents instead of modification times")
+	watchCmd.Flags().BoolVarP(&syncWhole, "whole-file", "W", false, "Copy changed files in full instead of only the changed blocks")
+	watchCmd.Flags().BoolVar(&verifyTransfers, "verify", false, "Check every copied file against the sharer's SHA-256 checksum")
+	watchCmd.Flags().IntVar(&concurrency, "concurrency", transfer.DefaultConcurrency, "Number of files to copy at the same time")
+}
+
+func runWatch(cmd *cobra.Command, args []string) error {
+	sessionID, remoteDir := args[0], args[1]
+
+	localDir, err := filepath.Abs(args[2])
+	if err != nil {
+		return fmt.Errorf("invalid local directory: %w", err)
+	}
+
+	tun, client, err := dialSession(sessionID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tun.Close(); err != nil {
+			slog.Warn("failed to close tunnel", "err", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	direction := mirror.Pull
+	if syncPush {
+		direction = mirror.Push
+	}
+	m := mirror.New(client, direction, remoteDir, localDir, mirror.Options{
+		Delete:    syncDelete,
+		Checksum:  syncChecksum,
+		Verify:    verifyTransfers,
+		WholeFile: syncWhole,
+		Observer:  transferObserver(sessionID),
+	})
+
+	// Start watching before the first pass so that nothing changed during
+	// it is missed. Only the source side is watched.
+	var remoteEvents <-chan protocol.WatchEvent
+	var localChanges <-chan []string
+	if direction == mirror.Pull {
+		if err := client.Watch(ctx, remoteDir); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", remoteDir, err)
+		}
+		remoteEvents = client.WatchEvents()
+	} else {
+		watcher, err := watch.New(localDir)
+		if err != nil {
+			return fmt.Errorf("failed to watch %s: %w", localDir, err)
+		}
+		defer func() { _ = watcher.Close() }()
+		localChanges = watcher.Changes()
+	}
+
+	if _, err := syncOnce(ctx, m, localDir, false); err != nil {
+		return err
+	}
+	if jsonOutput {
+		if err := printJSON(event{Event: "watching"}); err != nil {
+			return err
+		}
+	} else {
+		fmt.Println("Watching for changes. Press Ctrl+C to stop.")
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-remoteEvents:
+			if !ok {
+				if err := client.Mux().Err(); errors.Is(err, tunnel.ErrPeerClosed) {
+					// Keep the reason the sharer gave, such as an expired session
+					reason := strings.TrimPrefix(err.Error(), tunnel.ErrPeerClosed.Error())
+					return errors.New("the sharer ended the session" + reason)
+				}
+				return errors.New("connection to the sharer was lost")
+			}
+		case _, ok := <-localChanges:
+			if !ok {
+				return errors.New("stopped watching the local directory")
+			}
+		}
+
+		// Events only say where to look, a full pass picks up everything
+		// that changed since the last one
+		if _, err := syncOnce(ctx, m, localDir, false); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			slog.Warn("sync failed", "err", err)
+		}
+	}
+}
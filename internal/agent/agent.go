@@ -0,0 +1,150 @@
+// Package agent keeps the shares orb agent runs in agent.yaml next to
+// config.yaml. Each has a name, the directories it shares and the options
+// of orb share it is started with.
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrNotFound is returned for an unknown share name
+var ErrNotFound = errors.New("no share with that name")
+
+// validName keeps names usable on the command line and in logs
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// Share is a share orb agent keeps running
+type Share struct {
+	Name     string   `yaml:"name" json:"name"`
+	Paths    []string `yaml:"paths" json:"paths"`
+	ReadOnly bool     `yaml:"read_only,omitempty" json:"read_only,omitempty"`
+	Include  []string `yaml:"include,omitempty" json:"include,omitempty"`
+	Exclude  []string `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+	Index    bool     `yaml:"index,omitempty" json:"index,omitempty"`
+}
+
+// Equal reports whether two definitions start the same share
+func (s Share) Equal(other Share) bool {
+	return s.Name == other.Name && s.ReadOnly == other.ReadOnly && s.Index == other.Index &&
+		slices.Equal(s.Paths, other.Paths) && slices.Equal(s.Include, other.Include) &&
+		slices.Equal(s.Exclude, other.Exclude)
+}
+
+// file is the layout of agent.yaml
+type file struct {
+	Shares []Share `yaml:"shares"`
+}
+
+// Store is the agent.yaml of this machine
+type Store struct {
+	path string
+	mu   sync.Mutex // serializes the changes of this process
+}
+
+// Open uses agent.yaml next to config.yaml
+func Open() (*Store, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return nil, err
+	}
+	return &Store{path: filepath.Join(dir, "agent.yaml")}, nil
+}
+
+// Path returns the location of the file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Shares returns the shares in the order they were added
+func (s *Store) Shares() ([]Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read()
+}
+
+// Add stores a new share. Names are unique.
+func (s *Store) Add(share Share) error {
+	if !validName.MatchString(share.Name) {
+		return fmt.Errorf("invalid name %q, use letters, digits, dots, dashes and underscores", share.Name)
+	}
+	if len(share.Paths) == 0 {
+		return errors.New("a share needs at least one directory")
+	}
+	return s.update(func(shares []Share) ([]Share, error) {
+		for _, existing := range shares {
+			if existing.Name == share.Name {
+				return nil, fmt.Errorf("a share named %s exists already", share.Name)
+			}
+		}
+		return append(shares, share), nil
+	})
+}
+
+// Remove deletes a share
+func (s *Store) Remove(name string) error {
+	return s.update(func(shares []Share) ([]Share, error) {
+		for i, share := range shares {
+			if share.Name == name {
+				return append(shares[:i], shares[i+1:]...), nil
+			}
+		}
+		return nil, ErrNotFound
+	})
+}
+
+// update rewrites the file with the shares change returns
+func (s *Store) update(change func([]Share) ([]Share, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shares, err := s.read()
+	if err != nil {
+		return err
+	}
+	shares, err = change(shares)
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(file{Shares: shares})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(s.path), err)
+	}
+	temp := s.path + ".tmp"
+	if err := os.WriteFile(temp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write agent shares: %w", err)
+	}
+	if err := os.Rename(temp, s.path); err != nil {
+		_ = os.Remove(temp)
+		return fmt.Errorf("failed to write agent shares: %w", err)
+	}
+	return nil
+}
+
+// read loads the file, which is missing until the first share is added
+func (s *Store) read() ([]Share, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agent shares: %w", err)
+	}
+	var f file
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", s.path, err)
+	}
+	return f.Shares, nil
+}
@@ -0,0 +1,475 @@
+// Package archive extracts downloaded tar and zip archives. Extraction never
+// writes outside the target directory: entries with absolute paths or ".."
+// are refused, files are created through an os.Root of the target, and
+// symbolic links may only point at other entries of the archive.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Format is the kind of an archive
+type Format int
+
+const (
+	// None is a file that is not an archive orb can extract
+	None Format = iota
+	Tar
+	TarGzip
+	TarBzip2
+	Zip
+)
+
+func (f Format) String() string {
+	switch f {
+	case Tar:
+		return "tar"
+	case TarGzip:
+		return "tar.gz"
+	case TarBzip2:
+		return "tar.bz2"
+	case Zip:
+		return "zip"
+	default:
+		return "none"
+	}
+}
+
+// ErrNotArchive is returned for files that are neither tar nor zip archives
+var ErrNotArchive = errors.New("not a tar or zip archive")
+
+// extensions are stripped from an archive's name for its default directory,
+// longest first
+var extensions = []string{".tar.gz", ".tar.bz2", ".tgz", ".tbz2", ".tbz", ".tar", ".zip"}
+
+// Result tells what an extraction created
+type Result struct {
+	Files   int   // regular files, links included
+	Dirs    int   // directories
+	Bytes   int64 // of the regular files
+	Skipped int   // devices, pipes and other entries that are not extracted
+}
+
+// Detect tells the format of an archive from its first bytes. The
+// compressed formats are only taken for tar archives once decompressed.
+func Detect(name string) (Format, error) {
+	// #nosec G304 -- the archive was downloaded by the user running orb
+	file, err := os.Open(name)
+	if err != nil {
+		return None, err
+	}
+	defer func() { _ = file.Close() }()
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(file, head); err != nil {
+		return None, nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return None, err
+	}
+
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return Zip, nil
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			return None, nil
+		}
+		defer func() { _ = zr.Close() }()
+		if isTar(zr) {
+			return TarGzip, nil
+		}
+	case bytes.HasPrefix(head, []byte("BZh")):
+		if isTar(bzip2.NewReader(file)) {
+			return TarBzip2, nil
+		}
+	default:
+		if isTar(file) {
+			return Tar, nil
+		}
+	}
+	return None, nil
+}
+
+// isTar reports whether r starts with a tar header
+func isTar(r io.Reader) bool {
+	_, err := tar.NewReader(r).Next()
+	return err == nil
+}
+
+// DefaultDir returns the directory an archive is extracted to when none is
+// given: next to it, named after it without its extension
+func DefaultDir(name string) string {
+	base := filepath.Base(name)
+	lower := strings.ToLower(base)
+	for _, ext := range extensions {
+		if strings.HasSuffix(lower, ext) && len(base) > len(ext) {
+			return filepath.Join(filepath.Dir(name), base[:len(base)-len(ext)])
+		}
+	}
+	return name + ".d"
+}
+
+// Extract unpacks the archive at name into dest, which is created if
+// missing. Existing files in dest are overwritten by entries of the same
+// name.
+func Extract(ctx context.Context, name, dest string) (Result, error) {
+	format, err := Detect(name)
+	if err != nil {
+		return Result{}, err
+	}
+	if format == None {
+		return Result{}, fmt.Errorf("%s: %w", filepath.Base(name), ErrNotArchive)
+	}
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return Result{}, err
+	}
+	root, err := os.OpenRoot(dest)
+	if err != nil {
+		return Result{}, err
+	}
+	defer func() { _ = root.Close() }()
+	x := &extractor{ctx: ctx, root: root, dest: dest}
+
+	if format == Zip {
+		err = x.zip(name)
+	} else {
+		err = x.tar(name, format)
+	}
+	return x.result, err
+}
+
+// extractor creates the entries of one archive below dest
+type extractor struct {
+	ctx    context.Context
+	root   *os.Root
+	dest   string
+	result Result
+}
+
+func (x *extractor) tar(name string, format Format) error {
+	// #nosec G304 -- the archive was downloaded by the user running orb
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	var r io.Reader = bufio.NewReader(file)
+	switch format {
+	case TarGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = zr.Close() }()
+		r = zr
+	case TarBzip2:
+		r = bzip2.NewReader(r)
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		if err := x.ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("corrupt archive: %w", err)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeDir:
+			err = x.mkdir(hdr.Name, hdr.ModTime)
+		case tar.TypeReg:
+			err = x.file(hdr.Name, tr, hdr.FileInfo().Mode(), hdr.ModTime)
+		case tar.TypeSymlink:
+			err = x.symlink(hdr.Name, hdr.Linkname)
+		case tar.TypeLink:
+			err = x.hardlink(hdr.Name, hdr.Linkname, hdr.ModTime)
+		default:
+			x.result.Skipped++
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (x *extractor) zip(name string) error {
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		return fmt.Errorf("corrupt archive: %w", err)
+	}
+	defer func() { _ = zr.Close() }()
+
+	for _, f := range zr.File {
+		if err := x.ctx.Err(); err != nil {
+			return err
+		}
+
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			err = x.mkdir(f.Name, f.Modified)
+		case mode&fs.ModeSymlink != 0:
+			err = x.zipSymlink(f)
+		case mode.IsRegular():
+			err = x.zipFile(f)
+		default:
+			x.result.Skipped++
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extractor) zipFile(f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rc.Close() }()
+	return x.file(f.Name, rc, f.Mode(), f.Modified)
+}
+
+// zipSymlink creates a link stored the way zip does, with the target as the
+// content of the entry
+func (x *extractor) zipSymlink(f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rc.Close() }()
+	target, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return err
+	}
+	return x.symlink(f.Name, string(target))
+}
+
+// local turns the name of an entry into a path relative to dest, refusing
+// names that would leave it. The top directory itself is "".
+func local(name string) (string, error) {
+	clean := path.Clean(strings.ReplaceAll(name, `\`, "/"))
+	if clean == "." || clean == "/" {
+		return "", nil
+	}
+	rel := filepath.FromSlash(clean)
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("refusing entry %q outside of the target directory", name)
+	}
+	return rel, nil
+}
+
+// parents creates the directories leading to rel and makes sure that none
+// of them is a link, so that nothing is created elsewhere through one
+func (x *extractor) parents(rel string) error {
+	dir := filepath.Dir(rel)
+	if dir == "." {
+		return nil
+	}
+	var prefix string
+	for _, part := range strings.Split(dir, string(filepath.Separator)) {
+		prefix = filepath.Join(prefix, part)
+		info, err := x.root.Lstat(prefix)
+		if errors.Is(err, fs.ErrNotExist) {
+			if err := x.root.Mkdir(prefix, 0755); err != nil {
+				return err
+			}
+			x.result.Dirs++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("refusing entry %q: %s is not a directory", rel, prefix)
+		}
+	}
+	return nil
+}
+
+// clear removes a link or file that an entry replaces
+func (x *extractor) clear(rel string) error {
+	info, err := x.root.Lstat(rel)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("refusing entry %q: a directory of that name exists", rel)
+	}
+	return x.root.Remove(rel)
+}
+
+func (x *extractor) mkdir(name string, modTime time.Time) error {
+	rel, err := local(name)
+	if err != nil || rel == "" {
+		return err
+	}
+	if err := x.parents(rel); err != nil {
+		return err
+	}
+	info, err := x.root.Lstat(rel)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if err := x.root.Mkdir(rel, 0755); err != nil {
+			return err
+		}
+		x.result.Dirs++
+	case err != nil:
+		return err
+	case !info.IsDir():
+		return fmt.Errorf("refusing entry %q: a file of that name exists", name)
+	}
+	x.chtimes(rel, modTime)
+	return nil
+}
+
+func (x *extractor) file(name string, r io.Reader, mode fs.FileMode, modTime time.Time) error {
+	rel, err := local(name)
+	if err != nil {
+		return err
+	}
+	if rel == "" {
+		return fmt.Errorf("refusing file entry %q", name)
+	}
+	if err := x.parents(rel); err != nil {
+		return err
+	}
+	if err := x.clear(rel); err != nil {
+		return err
+	}
+
+	// Only permission bits, never setuid and the like
+	file, err := x.root.OpenFile(rel, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(file, &contextReader{ctx: x.ctx, r: r})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	x.result.Files++
+	x.result.Bytes += n
+	x.chtimes(rel, modTime)
+	return nil
+}
+
+// symlink creates a link whose target stays within dest. A target may only
+// climb with leading "..", since a ".." after a link would climb from where
+// that link points.
+func (x *extractor) symlink(name, target string) error {
+	rel, err := local(name)
+	if err != nil {
+		return err
+	}
+	if rel == "" {
+		return fmt.Errorf("refusing link entry %q", name)
+	}
+	if !safeTarget(rel, target) {
+		return fmt.Errorf("refusing link %q to %q outside of the target directory", name, target)
+	}
+	if err := x.parents(rel); err != nil {
+		return err
+	}
+	if err := x.clear(rel); err != nil {
+		return err
+	}
+	if err := os.Symlink(filepath.FromSlash(target), filepath.Join(x.dest, rel)); err != nil {
+		return err
+	}
+	x.result.Files++
+	return nil
+}
+
+// safeTarget reports whether a link at rel to target stays within dest
+func safeTarget(rel, target string) bool {
+	target = filepath.ToSlash(target)
+	if target == "" || path.IsAbs(target) || filepath.IsAbs(filepath.FromSlash(target)) || filepath.VolumeName(target) != "" {
+		return false
+	}
+	climbing := true
+	for _, part := range strings.Split(target, "/") {
+		if part == ".." {
+			if !climbing {
+				return false
+			}
+			continue
+		}
+		climbing = false
+	}
+	return filepath.IsLocal(filepath.Join(filepath.Dir(rel), filepath.FromSlash(target)))
+}
+
+// hardlink copies an entry extracted earlier, a tar hard link
+func (x *extractor) hardlink(name, target string, modTime time.Time) error {
+	source, err := local(target)
+	if err != nil {
+		return err
+	}
+	file, err := x.root.Open(source)
+	if err != nil {
+		return fmt.Errorf("refusing link %q: %w", name, err)
+	}
+	defer func() { _ = file.Close() }()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("refusing link %q to %q, which is not a file", name, target)
+	}
+	return x.file(name, file, info.Mode(), modTime)
+}
+
+// chtimes sets the modification time of an extracted entry. The path has no
+// links in it, see parents.
+func (x *extractor) chtimes(rel string, modTime time.Time) {
+	if !modTime.IsZero() {
+		_ = os.Chtimes(filepath.Join(x.dest, rel), modTime, modTime)
+	}
+}
+
+// contextReader stops a long copy once ctx is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,25 @@
+package clipboard
+
+import (
+	"os"
+
+	"github.com/atotto/clipboard"
+	"github.com/muesli/termenv"
+	"golang.org/x/term"
+)
+
+// Write copies text to the system clipboard. Without a clipboard tool (e.g.
+// over SSH) it falls back to the OSC 52 escape sequence, which most terminals
+// forward to the local clipboard.
+func Write(text string) error {
+	err := clipboard.WriteAll(text)
+	if err == nil {
+		return nil
+	}
+
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		return err
+	}
+	termenv.Copy(text)
+	return nil
+}
@@ -0,0 +1,195 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the user configuration loaded from config.yaml
+type Config struct {
+	// Top-level settings apply to every command
+	Settings `yaml:",inline"`
+
+	// Profile names the profile used when none is given on the command line
+	Profile  string              `yaml:"profile"`
+	Profiles map[string]Settings `yaml:"profiles"`
+
+	TUI TUIConfig `yaml:"tui"`
+
+	// Hooks are commands run on events of shares
+	Hooks []Hook `yaml:"hooks"`
+}
+
+// Hook runs a command when a share reports an event, such as
+// "upload_completed", or every event for "*". The command is run by the
+// shell and gets the event as JSON on stdin.
+type Hook struct {
+	Event string `yaml:"event"`
+	Run   string `yaml:"run"`
+	// Timeout ends the command after a duration such as "30s"
+	Timeout string `yaml:"timeout"`
+}
+
+// Settings are defaults for command-line flags. Unset values keep the
+// built-in defaults; flags given on the command line always win. Relays are
+// tried in order after Relay, for falling back when one is down.
+type Settings struct {
+	Relay       string   `yaml:"relay"`
+	Relays      []string `yaml:"relays"`
+	Output      string   `yaml:"output"`
+	Concurrency int      `yaml:"concurrency"`
+	BWLimit     string   `yaml:"bwlimit"`
+	Proxy       string   `yaml:"proxy"`
+	// Via is a relay that hides this machine's address from the others
+	Via string `yaml:"via"`
+	// FastestRelay tries the relays in order of their round trip time
+	FastestRelay bool `yaml:"fastest_relay"`
+	// Encrypt saves downloaded files encrypted, as with --encrypt
+	Encrypt bool `yaml:"encrypt"`
+}
+
+// merge returns s with the values set in override replacing its own
+func (s Settings) merge(override Settings) Settings {
+	// A profile's relay replaces the top-level relays and vice versa
+	if override.Relay != "" || len(override.Relays) > 0 {
+		s.Relay, s.Relays = override.Relay, override.Relays
+	}
+	if override.Output != "" {
+		s.Output = override.Output
+	}
+	if override.Concurrency != 0 {
+		s.Concurrency = override.Concurrency
+	}
+	if override.BWLimit != "" {
+		s.BWLimit = override.BWLimit
+	}
+	if override.Proxy != "" {
+		s.Proxy = override.Proxy
+	}
+	if override.Via != "" {
+		s.Via = override.Via
+	}
+	if override.FastestRelay {
+		s.FastestRelay = true
+	}
+	if override.Encrypt {
+		s.Encrypt = true
+	}
+	return s
+}
+
+// RelayList returns the configured relays, relay before relays
+func (s Settings) RelayList() []string {
+	if s.Relay == "" {
+		return s.Relays
+	}
+	return append([]string{s.Relay}, s.Relays...)
+}
+
+// Resolve returns the top-level settings with those of the named profile
+// layered on top. An empty name selects the configured default profile, if any.
+func (c *Config) Resolve(profile string) (Settings, error) {
+	if profile == "" {
+		profile = c.Profile
+	}
+	if profile == "" {
+		return c.Settings, nil
+	}
+
+	p, ok := c.Profiles[profile]
+	if !ok {
+		return Settings{}, fmt.Errorf("unknown profile %q", profile)
+	}
+	return c.Settings.merge(p), nil
+}
+
+// TUIConfig holds file browser preferences
+type TUIConfig struct {
+	Keymap KeymapConfig `yaml:"keymap"`
+	Theme  ThemeConfig  `yaml:"theme"`
+}
+
+// KeymapConfig selects a keybinding preset and overrides individual actions.
+// Bindings maps action names (e.g. "download") to the keys that trigger them;
+// a two-key sequence is written with a space, e.g. "g g".
+type KeymapConfig struct {
+	Preset   string              `yaml:"preset"`
+	Bindings map[string][]string `yaml:"bindings"`
+}
+
+// ThemeConfig selects a color theme and overrides individual colors.
+// Colors are ANSI numbers ("205") or hex values ("#ff87d7").
+type ThemeConfig struct {
+	Name    string `yaml:"name"`
+	Accent  string `yaml:"accent"`
+	Text    string `yaml:"text"`
+	Muted   string `yaml:"muted"`
+	Success string `yaml:"success"`
+	Error   string `yaml:"error"`
+	Border  string `yaml:"border"`
+}
+
+// ExpandHome replaces a leading "~" in path with the user's home directory
+func ExpandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, `~\`) {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate home directory: %w", err)
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// Dir returns the directory holding orb's configuration (~/.config/orb on Linux)
+func Dir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate config directory: %w", err)
+	}
+	return filepath.Join(dir, "orb"), nil
+}
+
+// Path returns the location of config.yaml
+func Path() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.yaml"), nil
+}
+
+// Load reads config.yaml. A missing file yields an empty configuration.
+func Load() (*Config, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	return LoadFile(path)
+}
+
+// LoadFile reads the configuration at path. A missing file yields an empty configuration.
+func LoadFile(path string) (*Config, error) {
+	cfg := &Config{}
+
+	// #nosec G304 -- path is the user's own configuration file
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,406 @@
+package daemon
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The control API of "orb daemon" lets GUIs, tray apps and scripts drive
+// orb. It is served over HTTP on a unix socket in the daemon directory, and
+// every request must carry the token from the token file next to it:
+//
+//	Authorization: Bearer <token>
+//
+//	GET    /v1/shares           running shares, []Status
+//	POST   /v1/shares           start a share, ShareRequest -> ShareCreated
+//	GET    /v1/shares/{id}      Status of a share
+//	DELETE /v1/shares/{id}      stop a share
+//	GET    /v1/transfers        []TransferStatus
+//	POST   /v1/transfers        start a download, TransferRequest -> TransferStatus
+//	GET    /v1/transfers/{id}   TransferStatus of a transfer
+//	DELETE /v1/transfers/{id}   cancel a transfer
+//	GET    /v1/agent/shares     shares of orb agent, []ManagedShare
+//
+// Failures are answered with an APIError.
+
+// ErrTransferNotFound is returned for transfer IDs the daemon does not know
+var ErrTransferNotFound = errors.New("no transfer with that ID")
+
+// ErrInvalidRequest is wrapped by Backend errors about the request itself,
+// which are answered with 400 Bad Request
+var ErrInvalidRequest = errors.New("invalid request")
+
+// ShareRequest asks the daemon to share paths in the background, as
+// "orb share --daemon" would
+type ShareRequest struct {
+	Paths        []string `json:"paths"`
+	ReadOnly     bool     `json:"read_only,omitempty"`
+	Expire       string   `json:"expire,omitempty"` // e.g. "30m"
+	MaxDownloads int      `json:"max_downloads,omitempty"`
+	Relay        string   `json:"relay,omitempty"`
+}
+
+// ShareCreated is the session of a share started through the API
+type ShareCreated struct {
+	SessionID string `json:"session_id"`
+	Passcode  string `json:"passcode"`
+	Relay     string `json:"relay"`
+	PID       int    `json:"pid"`
+	LogFile   string `json:"log_file,omitempty"`
+	Expires   string `json:"expires,omitempty"`
+}
+
+// TransferRequest asks the daemon to download a file of a session. Dest is
+// a local directory or file, the download directory when empty.
+type TransferRequest struct {
+	SessionID string `json:"session_id"`
+	Passcode  string `json:"passcode"`
+	Relay     string `json:"relay,omitempty"`
+	Path      string `json:"path"`
+	Dest      string `json:"dest,omitempty"`
+}
+
+// TransferStatus is the progress of a transfer started through the API
+type TransferStatus struct {
+	ID          int       `json:"id"`
+	SessionID   string    `json:"session_id"`
+	Direction   string    `json:"direction"`
+	RemotePath  string    `json:"remote_path"`
+	LocalPath   string    `json:"local_path"`
+	Size        int64     `json:"size"`
+	Transferred int64     `json:"transferred"`
+	Progress    float64   `json:"progress"` // percent
+	Speed       float64   `json:"speed"`    // bytes per second
+	State       string    `json:"state"`
+	Error       string    `json:"error,omitempty"`
+	Started     time.Time `json:"started,omitzero"`
+	Finished    time.Time `json:"finished,omitzero"`
+	SHA256      string    `json:"sha256,omitempty"`
+}
+
+// ManagedShare is a share orb agent keeps running, with the session it
+// currently waits on. A new session is created whenever the share ends.
+type ManagedShare struct {
+	Name  string   `json:"name"`
+	Paths []string `json:"paths"`
+	// State is "starting", "waiting", "connected" or "restarting"
+	State     string    `json:"state"`
+	SessionID string    `json:"session_id,omitempty"`
+	Passcode  string    `json:"passcode,omitempty"`
+	Relay     string    `json:"relay,omitempty"`
+	PID       int       `json:"pid,omitempty"`
+	Sessions  int       `json:"sessions"` // created so far
+	Since     time.Time `json:"since,omitzero"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// APIError is the body of a failed request
+type APIError struct {
+	Error string `json:"error"`
+}
+
+// Backend carries out the requests of the control API that need more than
+// the registry of running shares
+type Backend interface {
+	CreateShare(req ShareRequest) (ShareCreated, error)
+	StartTransfer(req TransferRequest) (TransferStatus, error)
+	Transfers() []TransferStatus
+	CancelTransfer(id int) error
+}
+
+// Supervisor is implemented by the backend of orb agent, which keeps shares
+// of its own running
+type Supervisor interface {
+	ManagedShares() []ManagedShare
+}
+
+// ErrNoAPI is returned when neither orb daemon nor orb agent answers
+var ErrNoAPI = errors.New("neither orb agent nor orb daemon is running")
+
+// APIPaths returns the control socket of orb daemon and the file holding
+// its token
+func APIPaths() (socket, token string, err error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Join(dir, "api.sock"), filepath.Join(dir, "api.token"), nil
+}
+
+// APIServer is the control API of a running orb daemon
+type APIServer struct {
+	socket string
+	token  string
+	srv    *http.Server
+}
+
+// ServeAPI starts answering control requests on the API socket until Close.
+// A new token is written for every start.
+func ServeAPI(backend Backend) (*APIServer, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	socketPath, tokenPath, err := APIPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only a socket nobody answers on is left over from a crash
+	if conn, err := net.DialTimeout("unix", socketPath, time.Second); err == nil {
+		_ = conn.Close()
+		return nil, errors.New("orb daemon is already running")
+	}
+	_ = os.Remove(socketPath)
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(secret)
+	if err := os.WriteFile(tokenPath, []byte(token+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write API token: %w", err)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		_ = os.Remove(tokenPath)
+		return nil, fmt.Errorf("failed to open control socket: %w", err)
+	}
+
+	s := &APIServer{socket: socketPath, token: tokenPath}
+	s.srv = &http.Server{
+		Handler:           authenticate(token, apiHandler(backend)),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() { _ = s.srv.Serve(listener) }()
+	return s, nil
+}
+
+// Close stops answering control requests and removes the socket and token
+func (s *APIServer) Close() error {
+	err := s.srv.Close()
+	_ = os.Remove(s.socket)
+	_ = os.Remove(s.token)
+	return err
+}
+
+// authenticate only passes on requests that carry token
+func authenticate(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, APIError{Error: "missing or wrong API token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func apiHandler(backend Backend) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /v1/shares", func(w http.ResponseWriter, r *http.Request) {
+		infos, err := List()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		statuses := make([]Status, 0, len(infos))
+		for _, info := range infos {
+			status, err := Query(info.SessionID)
+			if err != nil {
+				// Shares that exited since List are gone
+				continue
+			}
+			statuses = append(statuses, status)
+		}
+		writeJSON(w, http.StatusOK, statuses)
+	})
+	mux.HandleFunc("POST /v1/shares", func(w http.ResponseWriter, r *http.Request) {
+		var req ShareRequest
+		if !readJSON(w, r, &req) {
+			return
+		}
+		if len(req.Paths) == 0 {
+			writeJSON(w, http.StatusBadRequest, APIError{Error: "paths is empty"})
+			return
+		}
+		created, err := backend.CreateShare(req)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, created)
+	})
+	mux.HandleFunc("GET /v1/shares/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := Lookup(r.PathValue("id")); err != nil {
+			writeError(w, err)
+			return
+		}
+		status, err := Query(r.PathValue("id"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	})
+	mux.HandleFunc("DELETE /v1/shares/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := Lookup(r.PathValue("id")); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := Stop(r.PathValue("id")); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /v1/transfers", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, backend.Transfers())
+	})
+	mux.HandleFunc("POST /v1/transfers", func(w http.ResponseWriter, r *http.Request) {
+		var req TransferRequest
+		if !readJSON(w, r, &req) {
+			return
+		}
+		if req.SessionID == "" || req.Passcode == "" || req.Path == "" {
+			writeJSON(w, http.StatusBadRequest, APIError{Error: "session_id, passcode and path are required"})
+			return
+		}
+		status, err := backend.StartTransfer(req)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, status)
+	})
+	mux.HandleFunc("GET /v1/transfers/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			writeError(w, ErrTransferNotFound)
+			return
+		}
+		for _, t := range backend.Transfers() {
+			if t.ID == id {
+				writeJSON(w, http.StatusOK, t)
+				return
+			}
+		}
+		writeError(w, ErrTransferNotFound)
+	})
+	mux.HandleFunc("DELETE /v1/transfers/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			writeError(w, ErrTransferNotFound)
+			return
+		}
+		if err := backend.CancelTransfer(id); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /v1/agent/shares", func(w http.ResponseWriter, r *http.Request) {
+		supervisor, ok := backend.(Supervisor)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, APIError{Error: "orb daemon keeps no shares, orb agent does"})
+			return
+		}
+		writeJSON(w, http.StatusOK, supervisor.ManagedShares())
+	})
+
+	return mux
+}
+
+// ManagedShares asks the running orb agent for its shares
+func ManagedShares() ([]ManagedShare, error) {
+	socketPath, tokenPath, err := APIPaths()
+	if err != nil {
+		return nil, err
+	}
+	token, err := os.ReadFile(tokenPath)
+	if err != nil {
+		return nil, ErrNoAPI
+	}
+	c := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://orb/v1/agent/shares", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, ErrNoAPI
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr APIError
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return nil, errors.New(apiErr.Error)
+		}
+		return nil, fmt.Errorf("request failed: %s", resp.Status)
+	}
+	var shares []ManagedShare
+	if err := json.NewDecoder(resp.Body).Decode(&shares); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return shares, nil
+}
+
+// readJSON decodes the body of r into v, answering the request itself when
+// it cannot
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, APIError{Error: "invalid request: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+// writeError answers with err, as 404 for what does not exist
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrTransferNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, ErrInvalidRequest), errors.Is(err, errInvalidID):
+		code = http.StatusBadRequest
+	}
+	writeJSON(w, code, APIError{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
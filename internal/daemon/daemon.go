@@ -0,0 +1,386 @@
+// Package daemon keeps track of the shares running on this machine, in the
+// foreground or in the background, and lets other orb processes inspect and
+// stop them.
+//
+// Every share registers itself with an info file and a control socket in
+// the daemon directory. The socket serves a small HTTP API:
+// GET /status returns a Status, POST /stop ends the share, GET /messages
+// returns the chat with the receiver and POST /messages adds to it. orb daemon
+// serves the wider control API of ServeAPI on a socket of its own.
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+)
+
+// ErrNotFound is returned when no running share has the requested session ID
+var ErrNotFound = errors.New("no running share with that session ID")
+
+// errInvalidID rejects session IDs that cannot name files
+var errInvalidID = errors.New("invalid session ID")
+
+// Info describes a running share. It never contains the passcode.
+type Info struct {
+	SessionID string `json:"session_id"`
+	PID       int    `json:"pid"`
+	Path      string `json:"path"`
+	Relay     string `json:"relay"`
+	ReadOnly  bool   `json:"read_only"`
+	// Background is set for shares started with --daemon
+	Background bool      `json:"background"`
+	Started    time.Time `json:"started"`
+	LogFile    string    `json:"log_file,omitempty"`
+}
+
+// Status is the live state of a running share
+type Status struct {
+	Info
+	Peers         int       `json:"peers"`
+	Connected     bool      `json:"connected"`
+	ConnectedAt   time.Time `json:"connected_at,omitzero"`
+	Requests      int       `json:"requests"`
+	BytesSent     int64     `json:"bytes_sent"`
+	BytesReceived int64     `json:"bytes_received"`
+}
+
+// Message is a line of the chat between the share and its receiver
+type Message struct {
+	// From is "sharer" or "receiver"
+	From string    `json:"from"`
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+}
+
+// Chat is the chat of a running share with its receiver
+type Chat interface {
+	// Messages returns the chat so far, oldest first
+	Messages() []Message
+	// Send sends a message to the receiver
+	Send(text string) error
+}
+
+// ErrNoReceiver is returned when a message is sent while nobody is connected
+var ErrNoReceiver = errors.New("no receiver is connected")
+
+// Dir returns the directory holding the info files and control sockets
+func Dir() (string, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "daemons"), nil
+}
+
+// paths returns the info file and control socket of a session
+func paths(sessionID string) (string, string, error) {
+	if sessionID == "" || strings.ContainsAny(sessionID, `/\.`) {
+		return "", "", fmt.Errorf("%w %q", errInvalidID, sessionID)
+	}
+
+	dir, err := Dir()
+	if err != nil {
+		return "", "", err
+	}
+	base := filepath.Join(dir, sessionID)
+	return base + ".json", base + ".sock", nil
+}
+
+// Server is the control endpoint of a running share
+type Server struct {
+	info   string
+	socket string
+	srv    *http.Server
+}
+
+// Serve registers a share and answers control requests until
+// Close. status is called for every status request, stop when asked to stop.
+// chat may be nil for shares without one.
+func Serve(info Info, status func() Status, stop func(), chat Chat) (*Server, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	infoPath, socketPath, err := paths(info.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// A socket left behind by a crashed share would make Listen fail
+	_ = os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open control socket: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(status())
+	})
+	mux.HandleFunc("/stop", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		go stop()
+	})
+	if chat != nil {
+		mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+			serveMessages(w, r, chat)
+		})
+	}
+
+	s := &Server{
+		info:   infoPath,
+		socket: socketPath,
+		srv:    &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second},
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	if err := os.WriteFile(infoPath, data, 0600); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to register share: %w", err)
+	}
+
+	go func() { _ = s.srv.Serve(listener) }()
+	return s, nil
+}
+
+// serveMessages lists the chat or sends a message
+func serveMessages(w http.ResponseWriter, r *http.Request, chat Chat) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(chat.Messages())
+	case http.MethodPost:
+		var req struct {
+			Text string `json:"text"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			http.Error(w, "invalid message", http.StatusBadRequest)
+			return
+		}
+		if err := chat.Send(req.Text); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, ErrNoReceiver) {
+				status = http.StatusConflict
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Close unregisters the share and stops answering control requests
+func (s *Server) Close() error {
+	err := s.srv.Close()
+	_ = os.Remove(s.info)
+	_ = os.Remove(s.socket)
+	return err
+}
+
+// List returns the registered shares, oldest first. Entries
+// whose process no longer answers are removed.
+func List() ([]Info, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []Info
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		info, err := Lookup(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Started.Before(infos[j].Started) })
+	return infos, nil
+}
+
+// Lookup returns the share with the given session ID
+func Lookup(sessionID string) (Info, error) {
+	infoPath, socketPath, err := paths(sessionID)
+	if err != nil {
+		return Info{}, err
+	}
+
+	data, err := os.ReadFile(infoPath) // #nosec G304 -- path is inside the daemon directory
+	if errors.Is(err, os.ErrNotExist) {
+		return Info{}, ErrNotFound
+	}
+	if err != nil {
+		return Info{}, err
+	}
+
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return Info{}, fmt.Errorf("corrupt daemon file %s: %w", infoPath, err)
+	}
+
+	// A share that was killed cannot clean up after itself
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err != nil {
+		_ = os.Remove(infoPath)
+		_ = os.Remove(socketPath)
+		return Info{}, ErrNotFound
+	}
+	_ = conn.Close()
+
+	return info, nil
+}
+
+// client talks to the control socket of a session
+func client(sessionID string) (*http.Client, error) {
+	_, socketPath, err := paths(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}, nil
+}
+
+// Query asks a share for its status
+func Query(sessionID string) (Status, error) {
+	c, err := client(sessionID)
+	if err != nil {
+		return Status{}, err
+	}
+
+	resp, err := c.Get("http://orb/status")
+	if err != nil {
+		return Status{}, ErrNotFound
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return Status{}, fmt.Errorf("status request failed: %s", resp.Status)
+	}
+
+	var status Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return Status{}, fmt.Errorf("invalid status response: %w", err)
+	}
+	return status, nil
+}
+
+// Stop asks a share to end
+func Stop(sessionID string) error {
+	c, err := client(sessionID)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Post("http://orb/stop", "", nil)
+	if err != nil {
+		return ErrNotFound
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("stop request failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// Messages returns the chat of a share with its receiver
+func Messages(sessionID string) ([]Message, error) {
+	c, err := client(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Get("http://orb/messages")
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("messages request failed: %s", resp.Status)
+	}
+
+	var messages []Message
+	if err := json.NewDecoder(resp.Body).Decode(&messages); err != nil {
+		return nil, fmt.Errorf("invalid messages response: %w", err)
+	}
+	return messages, nil
+}
+
+// SendMessage has a share send a chat message to its receiver
+func SendMessage(sessionID, text string) error {
+	c, err := client(sessionID)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	resp, err := c.Post("http://orb/messages", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return ErrNotFound
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return nil
+	case http.StatusConflict:
+		return ErrNoReceiver
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("message failed: %s", strings.TrimSpace(string(msg)))
+	}
+}
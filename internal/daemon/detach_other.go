@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package daemon
+
+import "os/exec"
+
+// Detach does nothing on this platform; the background share ends with the
+// terminal it was started from
+func Detach(cmd *exec.Cmd) {}
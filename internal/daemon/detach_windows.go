@@ -0,0 +1,19 @@
+//go:build windows
+
+package daemon
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detachedProcess is DETACHED_PROCESS, which syscall does not define
+const detachedProcess = 0x00000008
+
+// Detach makes cmd run without a console, so that it survives the console
+// it was started from
+func Detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: detachedProcess | syscall.CREATE_NEW_PROCESS_GROUP,
+	}
+}
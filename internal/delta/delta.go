@@ -0,0 +1,246 @@
+// Package delta finds the blocks two copies of a file have in common, the
+// way rsync does, so that re-transferring a slightly changed file only moves
+// the blocks that changed. One copy is cut into fixed blocks, each with a
+// cheap rolling checksum and a strong one. The other copy is scanned at
+// every byte offset; where the rolling checksum matches a block, the strong
+// checksum confirms it. Blocks are found even when data was inserted or
+// removed before them.
+package delta
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+)
+
+const (
+	// MinBlockSize and MaxBlockSize bound the block size of a signature
+	MinBlockSize = 2 * 1024
+	MaxBlockSize = 1024 * 1024
+
+	// StrongSize is the length of the strong checksum of a block, the start
+	// of its SHA-256 checksum
+	StrongSize = 16
+
+	// scanBuffer is how much of the scanned file is read at a time
+	scanBuffer = 4 * 1024 * 1024
+
+	// filterBits sizes the bitmap that rules out most rolling checksums
+	// before the block table is consulted
+	filterBits = 20
+)
+
+// BlockSize picks the block size for a file of size bytes: the power of two
+// nearest above its square root, within MinBlockSize and MaxBlockSize. The
+// checksums then stay a small fraction of the file while a change costs
+// little more than itself.
+func BlockSize(size int64) int64 {
+	root := int64(math.Sqrt(float64(size)))
+	if root <= MinBlockSize {
+		return MinBlockSize
+	}
+	block := int64(1) << bits.Len64(uint64(root-1))
+	return min(block, MaxBlockSize)
+}
+
+// Signature holds the checksums of the blocks of one copy of a file
+type Signature struct {
+	BlockSize int64
+	Size      int64 // of the file
+	Weak      []uint32
+	Strong    []byte // StrongSize bytes per block
+}
+
+// Blocks returns the number of blocks of a file of the signature's size
+func (s *Signature) Blocks() int64 {
+	return (s.Size + s.BlockSize - 1) / s.BlockSize
+}
+
+// BlockLength returns the length of block i, which is short for the last
+// block of most files
+func (s *Signature) BlockLength(i int64) int64 {
+	return min(s.BlockSize, s.Size-i*s.BlockSize)
+}
+
+// Sign computes the checksums of up to count blocks of blockSize bytes read
+// from r. It stops early at the end of r.
+func Sign(ctx context.Context, r io.Reader, blockSize int64, count int) ([]uint32, []byte, error) {
+	if blockSize < MinBlockSize || blockSize > MaxBlockSize {
+		return nil, nil, fmt.Errorf("block size must be between %d and %d bytes", MinBlockSize, MaxBlockSize)
+	}
+
+	var weak []uint32
+	var strong []byte
+	buf := make([]byte, blockSize)
+	for range count {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			weak = append(weak, newRolling(buf[:n]).sum())
+			strong = append(strong, Strong(buf[:n])...)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return weak, strong, nil
+}
+
+// Strong returns the strong checksum of a block
+func Strong(block []byte) []byte {
+	sum := sha256.Sum256(block)
+	return sum[:StrongSize]
+}
+
+// rolling is the rsync rolling checksum of a window of bytes. It can be
+// moved forward one byte in constant time.
+type rolling struct {
+	a, b uint32
+	n    uint32
+}
+
+func newRolling(window []byte) rolling {
+	r := rolling{n: uint32(len(window))}
+	for i, c := range window {
+		r.a += uint32(c)
+		r.b += uint32(len(window)-i) * uint32(c)
+	}
+	return r
+}
+
+// roll moves the window one byte forward, dropping out and adding in
+func (r *rolling) roll(out, in byte) {
+	r.a += uint32(in) - uint32(out)
+	r.b += r.a - r.n*uint32(out)
+}
+
+func (r rolling) sum() uint32 {
+	return r.a&0xffff | r.b<<16
+}
+
+// filterSlot spreads rolling checksums over the filter bitmap
+func filterSlot(weak uint32) uint32 {
+	return (weak * 2654435761) >> (32 - filterBits)
+}
+
+// Find scans size bytes of r for the full blocks of sig and calls found for
+// each match with the offset in r and every block with that content, lowest
+// first. Matches do not overlap and come in order of offset. The short last
+// block of sig, if any, is never matched.
+func Find(ctx context.Context, r io.ReaderAt, size int64, sig *Signature, found func(offset int64, blocks []int64) error) error {
+	bs := sig.BlockSize
+	if bs < MinBlockSize || bs > MaxBlockSize {
+		return fmt.Errorf("block size must be between %d and %d bytes", MinBlockSize, MaxBlockSize)
+	}
+	if int64(len(sig.Strong)) != int64(len(sig.Weak))*StrongSize {
+		return errors.New("malformed signature")
+	}
+
+	table := make(map[uint32][]int64)
+	filter := make([]uint64, (1<<filterBits)/64)
+	for i, weak := range sig.Weak {
+		if sig.BlockLength(int64(i)) != bs {
+			continue
+		}
+		table[weak] = append(table[weak], int64(i))
+		slot := filterSlot(weak)
+		filter[slot/64] |= 1 << (slot % 64)
+	}
+	if len(table) == 0 {
+		return nil
+	}
+
+	// lookup returns the blocks whose content is window
+	lookup := func(weak uint32, window []byte) []int64 {
+		candidates := table[weak]
+		if len(candidates) == 0 {
+			return nil
+		}
+		strong := Strong(window)
+		var blocks []int64
+		for _, i := range candidates {
+			if string(sig.Strong[i*StrongSize:(i+1)*StrongSize]) == string(strong) {
+				blocks = append(blocks, i)
+			}
+		}
+		return blocks
+	}
+
+	src := io.NewSectionReader(r, 0, size)
+	buf := make([]byte, 0, max(scanBuffer, 4*bs))
+	var base int64 // offset of buf[0] in r
+	pos := 0       // start of the window in buf
+	eof := false
+
+	// fill moves the window to the front of buf and reads after it
+	fill := func() error {
+		n := copy(buf[:cap(buf)], buf[pos:])
+		buf = buf[:n]
+		base += int64(pos)
+		pos = 0
+		for len(buf) < cap(buf) && !eof {
+			n, err := src.Read(buf[len(buf):cap(buf)])
+			buf = buf[:len(buf)+n]
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		return ctx.Err()
+	}
+
+	var sum rolling
+	valid := false // sum describes buf[pos:pos+bs]
+	for {
+		if int64(len(buf)-pos) < bs {
+			if eof {
+				return nil
+			}
+			if err := fill(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		window := buf[pos : pos+int(bs)]
+		if !valid {
+			sum = newRolling(window)
+			valid = true
+		}
+		weak := sum.sum()
+		slot := filterSlot(weak)
+		if filter[slot/64]&(1<<(slot%64)) != 0 {
+			if blocks := lookup(weak, window); len(blocks) > 0 {
+				if err := found(base+int64(pos), blocks); err != nil {
+					return err
+				}
+				pos += int(bs)
+				valid = false
+				continue
+			}
+		}
+
+		// The next window needs the byte after this one
+		if int64(len(buf)-pos) == bs {
+			if eof {
+				return nil
+			}
+			if err := fill(); err != nil {
+				return err
+			}
+			continue
+		}
+		sum.roll(buf[pos], buf[pos+int(bs)])
+		pos++
+	}
+}
@@ -0,0 +1,326 @@
+// Package drop encrypts files that are left at a relay for a receiver to
+// pick up later, so that sender and receiver need not be online at the same
+// time. The relay only stores what Seal writes and cannot read it. The same
+// format keeps downloads encrypted on the receiver's disk, under a local key
+// instead of a passcode.
+//
+// The key is derived with Argon2id from the passcode and a random salt in
+// the header, or for a local key with HMAC-SHA256 from the key and the
+// salt, and such files start with "ORBFILE1" instead. The file is cut into
+// segments of 64 KB, each sealed with XChaCha20-Poly1305 under a nonce made
+// of a random prefix and its number, so that segments cannot be reordered.
+// The first segment holds the name and size of the file, and the last one
+// is marked as such, so that a stored file cut short is detected. The
+// layout is:
+//
+//	"ORBDROP1" | salt (16) | nonce prefix (16) | segments
+//	segment: length of the sealed segment (4, big endian) | sealed segment
+package drop
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Zayan-Mohamed/orb/internal/crypto"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	magic       = "ORBDROP1"
+	keyMagic    = "ORBFILE1"
+	saltSize    = 16
+	prefixSize  = 16
+	segmentSize = 64 * 1024
+	headerSize  = len(magic) + saltSize + prefixSize
+	maxSealed   = segmentSize + chacha20poly1305.Overhead
+
+	// KeySize is the size of local keys
+	KeySize = 32
+)
+
+var (
+	// ErrWrongPasscode is returned when the passcode does not open a file
+	ErrWrongPasscode = errors.New("wrong passcode")
+	// ErrWrongKey is returned when the local key does not open a file
+	ErrWrongKey = errors.New("the file was encrypted with a different key")
+	// ErrCorrupt is returned for a file that was changed or cut short
+	ErrCorrupt = errors.New("the stored file is corrupt or incomplete")
+
+	// errSegment is returned for a segment that does not decrypt
+	errSegment = fmt.Errorf("%w, a segment does not decrypt", ErrCorrupt)
+)
+
+// Meta describes the file inside
+type Meta struct {
+	Name string `json:"name"`
+	// Size is -1 for standard input, whose size was not known up front
+	Size int64 `json:"size"`
+}
+
+// Sealer encrypts one file
+type Sealer struct {
+	header []byte
+	key    []byte
+}
+
+// NewSealer picks a salt and derives the key from passcode
+func NewSealer(passcode string) (*Sealer, error) {
+	header, err := newHeader(magic)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{header: header, key: deriveKey(passcode, header)}, nil
+}
+
+// NewKeySealer picks a salt and derives the key of the file from a local
+// key of KeySize bytes
+func NewKeySealer(key []byte) (*Sealer, error) {
+	if len(key) != KeySize {
+		return nil, crypto.ErrInvalidKey
+	}
+	header, err := newHeader(keyMagic)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{header: header, key: fileKey(key, header)}, nil
+}
+
+// newHeader returns a header with a new salt and nonce prefix
+func newHeader(kind string) ([]byte, error) {
+	random, err := crypto.SecureRandom(saltSize + prefixSize)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(kind), random...), nil
+}
+
+// Token returns the token that deletes the stored file at the relay, which
+// only holders of the passcode can derive
+func (s *Sealer) Token() string {
+	return token(s.key)
+}
+
+// Seal writes the encrypted file to dst
+func (s *Sealer) Seal(dst io.Writer, src io.Reader, meta Meta) error {
+	aead, err := chacha20poly1305.NewX(s.key)
+	if err != nil {
+		return err
+	}
+	prefix := s.header[len(magic)+saltSize:]
+	if _, err := dst.Write(s.header); err != nil {
+		return err
+	}
+
+	info, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	var counter uint64
+	seal := func(plain []byte, final bool) error {
+		sealed := aead.Seal(nil, nonce(prefix, counter), plain, additional(final))
+		counter++
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(sealed)))
+		if _, err := dst.Write(length[:]); err != nil {
+			return err
+		}
+		_, err := dst.Write(sealed)
+		return err
+	}
+	if err := seal(info, false); err != nil {
+		return err
+	}
+
+	buf := make([]byte, segmentSize)
+	for {
+		n, err := io.ReadFull(src, buf)
+		final := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !final {
+			return err
+		}
+		if err := seal(buf[:n], final); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+// SealedSize returns how many bytes Seal writes for a file, or -1 when its
+// size is unknown
+func SealedSize(meta Meta) int64 {
+	if meta.Size < 0 {
+		return -1
+	}
+	info, err := json.Marshal(meta)
+	if err != nil {
+		return -1
+	}
+	// The last segment is empty when the file fills the others exactly
+	segments := meta.Size/segmentSize + 1
+	overhead := int64(4 + chacha20poly1305.Overhead)
+	return int64(headerSize) + overhead + int64(len(info)) + segments*overhead + meta.Size
+}
+
+// Reader decrypts a stored file as it is read
+type Reader struct {
+	src     *bufio.Reader
+	key     []byte
+	prefix  []byte
+	meta    Meta
+	counter uint64
+	aead    cipher.AEAD
+	pending []byte
+	done    bool
+}
+
+// Open reads the header of a stored file and checks passcode against it
+func Open(src io.Reader, passcode string) (*Reader, error) {
+	r := &Reader{src: bufio.NewReaderSize(src, maxSealed+4)}
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r.src, header); err != nil || string(header[:len(magic)]) != magic {
+		return nil, errors.New("not a file stored by orb")
+	}
+	return r.open(header, deriveKey(passcode, header), ErrWrongPasscode)
+}
+
+// OpenKey reads the header of a file encrypted with a local key and checks
+// key against it
+func OpenKey(src io.Reader, key []byte) (*Reader, error) {
+	if len(key) != KeySize {
+		return nil, crypto.ErrInvalidKey
+	}
+	r := &Reader{src: bufio.NewReaderSize(src, maxSealed+4)}
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r.src, header); err != nil || string(header[:len(keyMagic)]) != keyMagic {
+		return nil, errors.New("not a file encrypted by orb")
+	}
+	return r.open(header, fileKey(key, header), ErrWrongKey)
+}
+
+// open opens the first segment, which holds the name and size, with key.
+// wrongKey is returned when it does not decrypt.
+func (r *Reader) open(header, key []byte, wrongKey error) (*Reader, error) {
+	r.key = key
+	r.prefix = header[len(magic)+saltSize:]
+	aead, err := chacha20poly1305.NewX(r.key)
+	if err != nil {
+		return nil, err
+	}
+	r.aead = aead
+
+	info, final, err := r.segment()
+	if err != nil {
+		if errors.Is(err, errSegment) {
+			// The first segment fails to open with the wrong key
+			return nil, wrongKey
+		}
+		return nil, err
+	}
+	if final || json.Unmarshal(info, &r.meta) != nil {
+		return nil, ErrCorrupt
+	}
+	return r, nil
+}
+
+// Meta returns the name and size of the file
+func (r *Reader) Meta() Meta {
+	return r.meta
+}
+
+// Token returns the token that deletes the stored file
+func (r *Reader) Token() string {
+	return token(r.key)
+}
+
+// Read returns the decrypted file, and ErrCorrupt once anything does not
+// add up
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		plain, final, err := r.segment()
+		if err != nil {
+			return 0, err
+		}
+		r.pending, r.done = plain, final
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// segment opens the next segment
+func (r *Reader) segment() ([]byte, bool, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r.src, length[:]); err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size < chacha20poly1305.Overhead || size > maxSealed {
+		return nil, false, ErrCorrupt
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(r.src, sealed); err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	nonce := nonce(r.prefix, r.counter)
+	for _, final := range []bool{false, true} {
+		if plain, err := r.aead.Open(nil, nonce, sealed, additional(final)); err == nil {
+			r.counter++
+			if final {
+				// Nothing may follow the last segment
+				if _, err := r.src.Peek(1); err == nil {
+					return nil, false, ErrCorrupt
+				}
+			}
+			return plain, final, nil
+		}
+	}
+	return nil, false, errSegment
+}
+
+// deriveKey derives the key of a file from the passcode, salted with its
+// header
+func deriveKey(passcode string, header []byte) []byte {
+	return crypto.DeriveKey(passcode, string(header[len(magic):len(magic)+saltSize]))
+}
+
+// fileKey derives the key of a file from a local key, salted with its header
+func fileKey(key, header []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("orb file key "))
+	mac.Write(header[len(keyMagic) : len(keyMagic)+saltSize])
+	return mac.Sum(nil)
+}
+
+func token(key []byte) string {
+	sum := sha256.Sum256(append([]byte("orb drop token "), key...))
+	return hex.EncodeToString(sum[:])
+}
+
+func nonce(prefix []byte, counter uint64) []byte {
+	n := make([]byte, chacha20poly1305.NonceSizeX)
+	copy(n, prefix)
+	binary.BigEndian.PutUint64(n[prefixSize:], counter)
+	return n
+}
+
+// additional marks the last segment, so that a file cut at a segment
+// boundary does not pass for complete
+func additional(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
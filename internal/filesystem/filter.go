@@ -0,0 +1,91 @@
+package filesystem
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Filter decides which paths of a share are visible to the receiver.
+//
+// Patterns use path.Match syntax. A pattern containing a slash is matched
+// against the whole path relative to the share root, any other pattern
+// against each name along the path, so "*.log" hides log files everywhere
+// and "node_modules" hides those directories with everything below them.
+type Filter struct {
+	include []string
+	exclude []string
+}
+
+// NewFilter creates a filter. Exclude patterns always win. When include
+// patterns are given only matching files are shared, directories stay
+// visible so the matching files can still be reached.
+func NewFilter(include, exclude []string) (*Filter, error) {
+	f := &Filter{}
+	for _, p := range include {
+		p, err := cleanPattern(p)
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, p)
+	}
+	for _, p := range exclude {
+		p, err := cleanPattern(p)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, p)
+	}
+	return f, nil
+}
+
+// cleanPattern normalizes a pattern and checks its syntax
+func cleanPattern(pattern string) (string, error) {
+	cleaned := strings.Trim(path.Clean("/"+strings.ReplaceAll(pattern, "\\", "/")), "/")
+	if cleaned == "" {
+		return "", fmt.Errorf("empty pattern %q", pattern)
+	}
+	if _, err := path.Match(cleaned, ""); err != nil {
+		return "", fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return cleaned, nil
+}
+
+// Allows reports whether a slash-separated path relative to the share root
+// is shared
+func (f *Filter) Allows(rel string, isDir bool) bool {
+	rel = strings.Trim(rel, "/")
+	if rel == "" || rel == "." {
+		return true
+	}
+
+	if matchAny(f.exclude, rel) {
+		return false
+	}
+	if len(f.include) == 0 || isDir {
+		return true
+	}
+	return matchAny(f.include, rel)
+}
+
+// IsEmpty reports whether the filter has no patterns
+func (f *Filter) IsEmpty() bool {
+	return len(f.include) == 0 && len(f.exclude) == 0
+}
+
+// matchAny reports whether rel, or one of the directories containing it,
+// matches one of the patterns
+func matchAny(patterns []string, rel string) bool {
+	for p := rel; p != "."; p = path.Dir(p) {
+		for _, pattern := range patterns {
+			subject := path.Base(p)
+			if strings.Contains(pattern, "/") {
+				subject = p
+			}
+			if ok, _ := path.Match(pattern, subject); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,366 @@
+package filesystem
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/watch"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// indexRefresh is how often an index is rebuilt when the tree cannot be
+// watched, for example because the system's limit on watches is reached
+const indexRefresh = 5 * time.Minute
+
+// searchIndex holds every entry of a directory share with the trigrams of
+// its name, so that a search looks up candidates instead of walking the tree
+type searchIndex struct {
+	mu      sync.RWMutex
+	entries []indexEntry
+	// paths finds the live entry of a path relative to the root
+	paths map[string]int32
+	// grams lists the entries whose lower-case name contains a trigram
+	grams   map[uint32][]int32
+	removed int
+}
+
+// indexEntry is one file or directory of the index
+type indexEntry struct {
+	path string // relative to the root, slash-separated
+	name string // lower-case
+	info protocol.FileInfo
+	link bool
+	gone bool
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		paths: make(map[string]int32),
+		grams: make(map[uint32][]int32),
+	}
+}
+
+// BuildIndex walks the share once and indexes the names, sizes and
+// modification times of its entries, which Search then answers from instead
+// of walking the tree for every query. The index follows changes until ctx
+// ends. Searches walk the tree until it is ready. BuildIndex returns once
+// the index is ready, with the number of entries indexed.
+func (fs *SecureFilesystem) BuildIndex(ctx context.Context) (int, error) {
+	if fs.roots != nil {
+		total := 0
+		for _, r := range fs.roots {
+			n, err := r.fs.BuildIndex(ctx)
+			if err != nil {
+				return total, err
+			}
+			total += n
+		}
+		return total, nil
+	}
+	if fs.file != "" {
+		// Searching a single file needs no index
+		return 1, nil
+	}
+
+	// Watch before walking, so that nothing changed during the walk is missed
+	w, err := watch.New(fs.rootPath)
+	if err != nil {
+		slog.Warn("cannot watch the share, the search index is rebuilt every few minutes instead",
+			"refresh", indexRefresh, "err", err)
+		w = nil
+	}
+
+	idx, err := fs.indexTree()
+	if err != nil {
+		if w != nil {
+			_ = w.Close()
+		}
+		return 0, err
+	}
+	fs.setIndex(idx)
+	go fs.maintainIndex(ctx, w)
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.paths), nil
+}
+
+// indexTree walks the whole share into a new index
+func (fs *SecureFilesystem) indexTree() (*searchIndex, error) {
+	idx := newSearchIndex()
+	if err := fs.indexWalk(idx, fs.rootPath); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// indexWalk adds dir and everything below it to idx, with the rules Search
+// applies while walking
+func (fs *SecureFilesystem) indexWalk(idx *searchIndex, dir string) error {
+	return filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			// Skip unreadable directories instead of giving up on the index
+			if d != nil && d.IsDir() && p != dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if p == fs.rootPath {
+			return nil
+		}
+		if fs.hidden(p, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		idx.add(strings.TrimPrefix(fs.relativePath(p), "/"), info)
+		return nil
+	})
+}
+
+// setIndex makes idx the one Search answers from
+func (fs *SecureFilesystem) setIndex(idx *searchIndex) {
+	fs.indexMu.Lock()
+	fs.index = idx
+	fs.indexMu.Unlock()
+}
+
+// currentIndex returns the index of the share, nil until one is built
+func (fs *SecureFilesystem) currentIndex() *searchIndex {
+	fs.indexMu.RLock()
+	defer fs.indexMu.RUnlock()
+	return fs.index
+}
+
+// maintainIndex applies the changes w reports to the index, or rebuilds it
+// regularly without a watcher, until ctx ends
+func (fs *SecureFilesystem) maintainIndex(ctx context.Context, w *watch.Watcher) {
+	if w == nil {
+		ticker := time.NewTicker(indexRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				idx, err := fs.indexTree()
+				if err != nil {
+					slog.Warn("failed to rebuild the search index", "err", err)
+					continue
+				}
+				fs.setIndex(idx)
+			}
+		}
+	}
+
+	defer func() { _ = w.Close() }()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case changes, ok := <-w.Changes():
+			if !ok {
+				return
+			}
+			idx := fs.currentIndex()
+			for _, rel := range changes {
+				fs.reindex(idx, rel)
+			}
+			idx.compact()
+		}
+	}
+}
+
+// reindex brings the entry of a changed path, and everything below it, up
+// to date
+func (fs *SecureFilesystem) reindex(idx *searchIndex, rel string) {
+	if rel == "." || rel == "" {
+		return
+	}
+	idx.remove(rel)
+
+	p := filepath.Join(fs.rootPath, filepath.FromSlash(rel))
+	info, err := os.Lstat(p)
+	if err != nil {
+		return
+	}
+	// A path whose parent is hidden or gone must not come back on its own
+	if parent := path.Dir(rel); parent != "." && !idx.has(parent) {
+		return
+	}
+	if info.IsDir() {
+		if err := fs.indexWalk(idx, p); err != nil {
+			slog.Debug("failed to index directory", "path", rel, "err", err)
+		}
+		return
+	}
+	if !fs.hidden(p, false) {
+		idx.add(rel, info)
+	}
+}
+
+// add indexes one entry, replacing an earlier one of the same path
+func (idx *searchIndex) add(rel string, info os.FileInfo) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if id, ok := idx.paths[rel]; ok {
+		idx.entries[id].gone = true
+		idx.removed++
+	}
+	id := int32(len(idx.entries))
+	entry := indexEntry{
+		path: rel,
+		name: strings.ToLower(WireName(info.Name())),
+		info: fileInfo(info.Name(), info),
+		link: info.Mode()&os.ModeSymlink != 0,
+	}
+	idx.entries = append(idx.entries, entry)
+	idx.paths[rel] = id
+	for _, g := range trigrams(entry.name) {
+		idx.grams[g] = append(idx.grams[g], id)
+	}
+}
+
+// has reports whether a path is indexed
+func (idx *searchIndex) has(rel string) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	_, ok := idx.paths[rel]
+	return ok
+}
+
+// remove drops a path and everything below it from the index
+func (idx *searchIndex) remove(rel string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	drop := func(p string, id int32) {
+		idx.entries[id].gone = true
+		delete(idx.paths, p)
+		idx.removed++
+	}
+	if id, ok := idx.paths[rel]; ok {
+		drop(rel, id)
+	}
+	prefix := rel + "/"
+	for p, id := range idx.paths {
+		if strings.HasPrefix(p, prefix) {
+			drop(p, id)
+		}
+	}
+}
+
+// compact rebuilds the index without removed entries once they make up
+// half of it, so that it does not grow with every change
+func (idx *searchIndex) compact() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.removed < 1024 || idx.removed < len(idx.entries)/2 {
+		return
+	}
+	entries := make([]indexEntry, 0, len(idx.paths))
+	grams := make(map[uint32][]int32, len(idx.grams))
+	for _, e := range idx.entries {
+		if e.gone {
+			continue
+		}
+		id := int32(len(entries))
+		entries = append(entries, e)
+		idx.paths[e.path] = id
+		for _, g := range trigrams(e.name) {
+			grams[g] = append(grams[g], id)
+		}
+	}
+	idx.entries, idx.grams, idx.removed = entries, grams, 0
+}
+
+// search returns up to maxResults entries below dir (relative to the root,
+// empty for the root itself) whose name contains query, which is lower-case.
+// match decides about entries the index cannot vouch for, such as symlinks.
+func (idx *searchIndex) search(ctx context.Context, dir, query string, maxResults int, match func(indexEntry) bool) (*protocol.SearchResponse, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	resp := &protocol.SearchResponse{}
+	consider := func(id int32) bool {
+		e := idx.entries[id]
+		if e.gone || !strings.HasPrefix(e.path, prefix) || !strings.Contains(e.name, query) {
+			return true
+		}
+		if e.link && !match(e) {
+			return true
+		}
+		if len(resp.Results) >= maxResults {
+			resp.Truncated = true
+			return false
+		}
+		resp.Results = append(resp.Results, protocol.SearchResult{Path: WirePath("/" + e.path), Info: e.info})
+		return true
+	}
+
+	// Queries shorter than a trigram are checked against every name, which
+	// is still far quicker than reading the directories
+	grams := trigrams(query)
+	if len(grams) == 0 {
+		for id := range idx.entries {
+			if id%4096 == 0 && ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if !consider(int32(id)) {
+				break
+			}
+		}
+		return resp, nil
+	}
+
+	// Candidates have every trigram of the query, start from the rarest
+	shortest := idx.grams[grams[0]]
+	for _, g := range grams[1:] {
+		if ids := idx.grams[g]; len(ids) < len(shortest) {
+			shortest = ids
+		}
+	}
+	for i, id := range shortest {
+		if i%4096 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !consider(id) {
+			break
+		}
+	}
+	return resp, nil
+}
+
+// trigrams returns the distinct three-byte sequences of s
+func trigrams(s string) []uint32 {
+	if len(s) < 3 {
+		return nil
+	}
+	seen := make(map[uint32]bool, len(s)-2)
+	grams := make([]uint32, 0, len(s)-2)
+	for i := 0; i+3 <= len(s); i++ {
+		g := uint32(s[i])<<16 | uint32(s[i+1])<<8 | uint32(s[i+2])
+		if !seen[g] {
+			seen[g] = true
+			grams = append(grams, g)
+		}
+	}
+	return grams
+}
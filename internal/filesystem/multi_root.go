@@ -0,0 +1,317 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Zayan-Mohamed/orb/internal/watch"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// ErrVirtualRoot is returned for changes to the root of a share of several
+// directories, which only exists on the wire
+var ErrVirtualRoot = errors.New("the root of a share of several directories cannot be changed")
+
+// sharedRoot is one directory of a share of several, shown as the top-level
+// directory name
+type sharedRoot struct {
+	name string
+	fs   *SecureFilesystem
+}
+
+// NewMultiRootFilesystem shares several directories at once. Each appears
+// as a directory of a virtual root, named after the last element of its
+// path; clashing names get a numeric suffix such as "photos-2".
+func NewMultiRootFilesystem(rootPaths []string, readOnly bool) (*SecureFilesystem, error) {
+	if len(rootPaths) == 0 {
+		return nil, errors.New("no directories to share")
+	}
+
+	fs := &SecureFilesystem{readOnly: readOnly}
+	taken := map[string]bool{}
+	for _, rootPath := range rootPaths {
+		child, err := NewSecureFilesystem(rootPath, readOnly)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", rootPath, err)
+		}
+
+		// Top-level names only exist on the wire
+		base := WireName(filepath.Base(child.rootPath))
+		if base == string(filepath.Separator) || base == "." {
+			base = "root"
+		}
+		name := base
+		for i := 2; taken[name]; i++ {
+			name = base + "-" + strconv.Itoa(i)
+		}
+		taken[name] = true
+		fs.roots = append(fs.roots, sharedRoot{name: name, fs: child})
+	}
+	return fs, nil
+}
+
+// route finds the shared directory a wire path lies in and the path inside
+// it. The virtual root itself yields a nil root.
+func (fs *SecureFilesystem) route(p string) (*sharedRoot, string, error) {
+	name, rest, _ := strings.Cut(strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(p)), "/"), "/")
+	if name == "" {
+		return nil, "/", nil
+	}
+	for i := range fs.roots {
+		if protocol.SameName(fs.roots[i].name, name) {
+			return &fs.roots[i], "/" + rest, nil
+		}
+	}
+	return nil, "", ErrNotShared
+}
+
+// RootNames returns the top-level names of a share of several directories
+// with the paths they stand for, or nil for any other share
+func (fs *SecureFilesystem) RootNames() map[string]string {
+	if fs.roots == nil {
+		return nil
+	}
+	names := make(map[string]string, len(fs.roots))
+	for _, r := range fs.roots {
+		names[r.name] = r.fs.rootPath
+	}
+	return names
+}
+
+func (fs *SecureFilesystem) multiList(p string) (*protocol.ListResponse, error) {
+	root, rest, err := fs.route(p)
+	if err != nil {
+		return nil, err
+	}
+	if root != nil {
+		return root.fs.List(rest)
+	}
+
+	files := make([]protocol.FileInfo, 0, len(fs.roots))
+	for _, r := range fs.roots {
+		info, err := os.Stat(r.fs.rootPath)
+		if err != nil {
+			continue // a directory removed while shared
+		}
+		files = append(files, fileInfo(r.name, info))
+	}
+	return &protocol.ListResponse{Files: files}, nil
+}
+
+func (fs *SecureFilesystem) multiStat(p string) (*protocol.StatResponse, error) {
+	root, rest, err := fs.route(p)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return &protocol.StatResponse{Info: fs.virtualRootInfo()}, nil
+	}
+
+	resp, err := root.fs.Stat(rest)
+	if err == nil && rest == "/" {
+		resp.Info.Name = root.name
+	}
+	return resp, err
+}
+
+// virtualRootInfo describes the root of a share of several directories as a
+// directory as recent as the newest of them
+func (fs *SecureFilesystem) virtualRootInfo() protocol.FileInfo {
+	mode := os.ModeDir | 0755
+	if fs.readOnly {
+		mode = os.ModeDir | 0555
+	}
+	info := protocol.FileInfo{Name: "/", Mode: uint32(mode), IsDir: true}
+	for _, r := range fs.roots {
+		if st, err := os.Stat(r.fs.rootPath); err == nil {
+			info.ModTime = max(info.ModTime, st.ModTime().Unix())
+		}
+	}
+	return info
+}
+
+func (fs *SecureFilesystem) multiHash(ctx context.Context, p string, length int64) (*protocol.HashResponse, error) {
+	root, rest, err := fs.route(p)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, errors.New("cannot hash a directory")
+	}
+	return root.fs.Hash(ctx, rest, length)
+}
+
+func (fs *SecureFilesystem) multiSignature(ctx context.Context, p string, blockSize, first int64, count int) (*protocol.SignatureResponse, error) {
+	root, rest, err := fs.route(p)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, errors.New("cannot checksum a directory")
+	}
+	return root.fs.Signature(ctx, rest, blockSize, first, count)
+}
+
+func (fs *SecureFilesystem) multiWatch(p string) (*watch.Watcher, error) {
+	root, rest, err := fs.route(p)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, errors.New("the root of a share of several directories cannot be watched, watch one of them")
+	}
+	return root.fs.Watch(rest)
+}
+
+func (fs *SecureFilesystem) multiRead(p string, offset, length int64) (*protocol.ReadResponse, error) {
+	root, rest, err := fs.route(p)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, errors.New("cannot read a directory")
+	}
+	return root.fs.Read(rest, offset, length)
+}
+
+func (fs *SecureFilesystem) multiWrite(p string, offset int64, data []byte) (*protocol.WriteResponse, error) {
+	root, rest, err := fs.route(p)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, ErrVirtualRoot
+	}
+	return root.fs.Write(rest, offset, data)
+}
+
+func (fs *SecureFilesystem) multiTruncate(p string, size int64) error {
+	root, rest, err := fs.route(p)
+	if err != nil {
+		return err
+	}
+	if root == nil {
+		return ErrVirtualRoot
+	}
+	return root.fs.Truncate(rest, size)
+}
+
+func (fs *SecureFilesystem) multiCopy(p string, offset int64, source string, sourceOffset, length int64) (*protocol.WriteResponse, error) {
+	root, rest, err := fs.route(p)
+	if err != nil {
+		return nil, err
+	}
+	sourceRoot, sourceRest, err := fs.route(source)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil || sourceRoot == nil {
+		return nil, ErrVirtualRoot
+	}
+	if root != sourceRoot {
+		return nil, errors.New("cannot copy between shared directories")
+	}
+	return root.fs.Copy(rest, offset, sourceRest, sourceOffset, length)
+}
+
+func (fs *SecureFilesystem) multiDelete(p string) error {
+	root, rest, err := fs.route(p)
+	if err != nil {
+		return err
+	}
+	if root == nil {
+		return ErrVirtualRoot
+	}
+	return root.fs.Delete(rest)
+}
+
+func (fs *SecureFilesystem) multiRename(oldPath, newPath string) error {
+	oldRoot, oldRest, err := fs.route(oldPath)
+	if err != nil {
+		return err
+	}
+	newRoot, newRest, err := fs.route(newPath)
+	if err != nil {
+		return err
+	}
+	if oldRoot == nil || newRoot == nil {
+		return ErrVirtualRoot
+	}
+	if oldRoot != newRoot {
+		return errors.New("cannot move between shared directories")
+	}
+	return oldRoot.fs.Rename(oldRest, newRest)
+}
+
+func (fs *SecureFilesystem) multiMkdir(p string, perm uint32) error {
+	root, rest, err := fs.route(p)
+	if err != nil {
+		return err
+	}
+	if root == nil {
+		return ErrVirtualRoot
+	}
+	return root.fs.Mkdir(rest, perm)
+}
+
+func (fs *SecureFilesystem) multiSearch(ctx context.Context, p, query string, maxResults int) (*protocol.SearchResponse, error) {
+	root, rest, err := fs.route(p)
+	if err != nil {
+		return nil, err
+	}
+	if root != nil {
+		resp, err := root.fs.Search(ctx, rest, query, maxResults)
+		if err != nil {
+			return nil, err
+		}
+		prefixResults(resp, root.name)
+		return resp, nil
+	}
+
+	if maxResults <= 0 || maxResults > maxSearchResults {
+		maxResults = maxSearchResults
+	}
+	resp := &protocol.SearchResponse{}
+	for _, r := range fs.roots {
+		if strings.Contains(strings.ToLower(r.name), protocol.NormalizeName(strings.ToLower(strings.TrimSpace(query)))) {
+			if info, err := os.Stat(r.fs.rootPath); err == nil {
+				resp.Results = append(resp.Results, protocol.SearchResult{Path: "/" + r.name, Info: fileInfo(r.name, info)})
+			}
+		}
+
+		left := maxResults - len(resp.Results)
+		if left <= 0 {
+			resp.Truncated = true
+			break
+		}
+		sub, err := r.fs.Search(ctx, "/", query, left)
+		if err != nil {
+			return nil, err
+		}
+		prefixResults(sub, r.name)
+		resp.Results = append(resp.Results, sub.Results...)
+		resp.Truncated = resp.Truncated || sub.Truncated
+	}
+	return resp, nil
+}
+
+// prefixResults moves search results below the top-level name of their directory
+func prefixResults(resp *protocol.SearchResponse, name string) {
+	for i := range resp.Results {
+		resp.Results[i].Path = "/" + name + resp.Results[i].Path
+	}
+}
+
+func (fs *SecureFilesystem) multiShared(p string) bool {
+	root, rest, err := fs.route(p)
+	if err != nil {
+		return false
+	}
+	return root == nil || root.fs.Shared(rest)
+}
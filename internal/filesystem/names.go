@@ -0,0 +1,187 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// windowsNames tells whether names are stored under the rules of Windows,
+// which forbids characters in names that other systems allow
+const windowsNames = runtime.GOOS == "windows"
+
+// windowsForbidden are the printable characters Windows does not allow in
+// names. They are stored as their fullwidth forms, which look the same.
+const windowsForbidden = `<>:"\|?*`
+
+const (
+	// fullwidthOffset is the distance from an ASCII character to its
+	// fullwidth form, e.g. from "?" to "？"
+	fullwidthOffset = 0xFEE0
+	// controlPictures is where the symbols for control characters start,
+	// e.g. "␁" for 0x01
+	controlPictures = 0x2400
+	// symbolSpace stands for a trailing space, which Windows drops
+	symbolSpace = '␠'
+)
+
+// reservedNames are the device names Windows does not allow as names of
+// files, with or without an extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// LocalName returns the name a file that was sent as name is stored under
+// on this machine. On Windows, characters it forbids are replaced by
+// look-alikes, which WireName turns back, so that such files can be
+// received at all and keep their names when sent on.
+func LocalName(name string) string {
+	if !windowsNames || name == "." || name == ".." {
+		return name
+	}
+	return toWindows(name)
+}
+
+// WireName returns the name a file stored as name is sent under, in the
+// normalization form of the protocol
+func WireName(name string) string {
+	if windowsNames {
+		name = fromWindows(name)
+	}
+	return protocol.NormalizeName(name)
+}
+
+// WirePath applies WireName to each element of a slash-separated path
+func WirePath(p string) string {
+	if !windowsNames && !protocol.HasForms(p) {
+		return p
+	}
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = WireName(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// localPath applies LocalName to each element of a slash-separated path,
+// which keeps its slashes
+func localPath(p string) string {
+	if !windowsNames {
+		return p
+	}
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = LocalName(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// toWindows replaces what Windows does not allow in a name
+func toWindows(name string) string {
+	runes := []rune(name)
+	for i, r := range runes {
+		switch {
+		case r < 0x20:
+			runes[i] = r + controlPictures
+		case strings.ContainsRune(windowsForbidden, r):
+			runes[i] = r + fullwidthOffset
+		}
+	}
+	// Trailing dots and spaces would be dropped, so the last one is kept
+	// as a look-alike, which leaves the others in the middle of the name
+	if last := len(runes) - 1; last >= 0 {
+		switch runes[last] {
+		case '.':
+			runes[last] = '.' + fullwidthOffset
+		case ' ':
+			runes[last] = symbolSpace
+		}
+	}
+	// A device name gets the last character before its extension replaced
+	if stem, _, _ := strings.Cut(string(runes), "."); reservedNames[strings.ToUpper(stem)] {
+		runes[len([]rune(stem))-1] += fullwidthOffset
+	}
+	return string(runes)
+}
+
+// fromWindows reverses toWindows
+func fromWindows(name string) string {
+	// The look-alikes are all outside ASCII
+	if !protocol.HasForms(name) {
+		return name
+	}
+	runes := []rune(name)
+	if stem, _, _ := strings.Cut(name, "."); stem != "" {
+		n := len([]rune(stem))
+		if c := runes[n-1] - fullwidthOffset; c >= '0' && c <= 'z' {
+			if device := string(runes[:n-1]) + string(c); reservedNames[strings.ToUpper(device)] {
+				runes[n-1] = c
+			}
+		}
+	}
+	for i, r := range runes {
+		switch {
+		case r > controlPictures && r < controlPictures+0x20:
+			runes[i] = r - controlPictures
+		case r > fullwidthOffset && strings.ContainsRune(windowsForbidden, r-fullwidthOffset):
+			runes[i] = r - fullwidthOffset
+		}
+	}
+	if last := len(runes) - 1; last >= 0 {
+		switch runes[last] {
+		case '.' + fullwidthOffset:
+			runes[last] = '.'
+		case symbolSpace:
+			runes[last] = ' '
+		}
+	}
+	return string(runes)
+}
+
+// matchName returns rel, a path below root, with each element that does not
+// exist replaced by an entry of its directory that has the same name in
+// another normalization form. A file created on macOS and copied elsewhere
+// keeps the decomposed name macOS gave it, which a receiver asks for
+// composed.
+func matchName(root, rel string) string {
+	if !protocol.HasForms(rel) {
+		return rel
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	dir := root
+	for i, part := range parts {
+		if _, err := os.Lstat(filepath.Join(dir, part)); err != nil {
+			match := findName(dir, part)
+			if match == "" {
+				// Nothing below a missing directory exists either
+				break
+			}
+			parts[i] = match
+		}
+		dir = filepath.Join(dir, parts[i])
+	}
+	return filepath.Join(parts...)
+}
+
+// findName returns the entry of dir whose name equals name once both are
+// normalized, or "" when there is none
+func findName(dir, name string) string {
+	if !protocol.HasForms(name) {
+		return ""
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, e := range entries {
+		if protocol.SameName(e.Name(), name) {
+			return e.Name()
+		}
+	}
+	return ""
+}
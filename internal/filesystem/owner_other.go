@@ -0,0 +1,11 @@
+//go:build !unix
+
+package filesystem
+
+import "os"
+
+// Owner returns the name of the user owning a file. File ownership is not
+// reported on this platform.
+func Owner(info os.FileInfo) string {
+	return ""
+}
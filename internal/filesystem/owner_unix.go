@@ -0,0 +1,35 @@
+//go:build unix
+
+package filesystem
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// ownerNames caches uid lookups, which read /etc/passwd or query NSS each time
+var ownerNames sync.Map
+
+// Owner returns the name of the user owning a file, or its uid when the
+// user is unknown
+func Owner(info os.FileInfo) string {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+
+	uid := strconv.FormatUint(uint64(stat.Uid), 10)
+	if name, ok := ownerNames.Load(uid); ok {
+		return name.(string)
+	}
+
+	name := uid
+	if u, err := user.LookupId(uid); err == nil {
+		name = u.Username
+	}
+	ownerNames.Store(uid, name)
+	return name
+}
@@ -1,14 +1,19 @@
 package filesystem
 
 import (
+	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
+	"github.com/Zayan-Mohamed/orb/internal/delta"
+	"github.com/Zayan-Mohamed/orb/internal/watch"
 	"github.com/Zayan-Mohamed/orb/pkg/protocol"
 )
 
@@ -17,12 +22,25 @@ var (
 	ErrSymlinkEscape    = errors.New("symlink points outside shared directory")
 	ErrInvalidPath      = errors.New("invalid path")
 	ErrPermissionDenied = errors.New("permission denied")
+	ErrNotShared        = errors.New("path is not shared")
 )
 
+// maxSearchResults caps the number of entries returned by a single search
+const maxSearchResults = 1000
+
 // SecureFilesystem provides sandboxed filesystem operations
 type SecureFilesystem struct {
 	rootPath string
 	readOnly bool
+	file     string // the only entry of a single-file share, empty otherwise
+	filter   *Filter
+	// roots are the directories of a share of several, which appear below
+	// a virtual root. Requests are passed on to the one they are for.
+	roots []sharedRoot
+
+	// index answers searches once BuildIndex has built it
+	indexMu sync.RWMutex
+	index   *searchIndex
 }
 
 // NewSecureFilesystem creates a new secure filesystem handler
@@ -48,15 +66,51 @@ func NewSecureFilesystem(rootPath string, readOnly bool) (*SecureFilesystem, err
 	}, nil
 }
 
+// NewSingleFileFilesystem shares exactly one file, read-only. The file
+// appears as the only entry of the root directory.
+func NewSingleFileFilesystem(filePath string) (*SecureFilesystem, error) {
+	absFile, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve file path: %w", err)
+	}
+
+	// Serve the real file, so that sanitizePath's symlink checks hold
+	resolved, err := filepath.EvalSymlinks(absFile)
+	if err != nil {
+		return nil, fmt.Errorf("file does not exist: %w", err)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("file does not exist: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("path is not a regular file")
+	}
+
+	return &SecureFilesystem{
+		rootPath: filepath.Dir(resolved),
+		readOnly: true,
+		file:     filepath.Base(resolved),
+	}, nil
+}
+
 // sanitizePath ensures the path is within the root directory
 // This prevents path traversal attacks
 func (fs *SecureFilesystem) sanitizePath(path string) (string, error) {
 	// Clean the path (removes .., ., etc.)
-	cleaned := filepath.Clean(path)
+	cleaned := filepath.Clean(localPath(path))
 
 	// Remove leading slash to make it relative
 	cleaned = strings.TrimPrefix(cleaned, string(filepath.Separator))
 
+	// Names may be stored in another normalization form than they are sent in
+	cleaned = matchName(fs.rootPath, cleaned)
+
+	// A single-file share only exposes its root and the file
+	if fs.file != "" && cleaned != "" && cleaned != "." && cleaned != fs.file {
+		return "", ErrNotShared
+	}
+
 	// Join with root
 	fullPath := filepath.Join(fs.rootPath, cleaned)
 
@@ -79,11 +133,33 @@ func (fs *SecureFilesystem) sanitizePath(path string) (string, error) {
 		return "", ErrPathTraversal
 	}
 
+	// Filtered paths behave as if they were not shared. Paths that do not
+	// exist yet are checked like directories, which only excludes apply to.
+	isDir := true
+	if info, err := os.Stat(resolved); err == nil {
+		isDir = info.IsDir()
+	}
+	if fs.hidden(fullPath, isDir) || fs.hidden(resolved, isDir) {
+		return "", ErrNotShared
+	}
+
 	return resolved, nil
 }
 
+// hidden reports whether the filter keeps a path inside the root out of the share
+func (fs *SecureFilesystem) hidden(absPath string, isDir bool) bool {
+	if fs.filter == nil {
+		return false
+	}
+	return !fs.filter.Allows(fs.relativePath(absPath), isDir)
+}
+
 // List returns directory contents
 func (fs *SecureFilesystem) List(path string) (*protocol.ListResponse, error) {
+	if fs.roots != nil {
+		return fs.multiList(path)
+	}
+
 	safePath, err := fs.sanitizePath(path)
 	if err != nil {
 		return nil, err
@@ -111,20 +187,38 @@ func (fs *SecureFilesystem) List(path string) (*protocol.ListResponse, error) {
 			}
 		}
 
-		files = append(files, protocol.FileInfo{
-			Name:    entry.Name(),
-			Size:    info.Size(),
-			Mode:    uint32(info.Mode()),
-			ModTime: info.ModTime().Unix(),
-			IsDir:   info.IsDir(),
-		})
+		if fs.file != "" && entry.Name() != fs.file {
+			continue
+		}
+
+		if fs.hidden(filepath.Join(safePath, entry.Name()), entry.IsDir()) {
+			continue
+		}
+
+		files = append(files, fileInfo(entry.Name(), info))
 	}
 
 	return &protocol.ListResponse{Files: files}, nil
 }
 
+// fileInfo converts local file information to its wire form
+func fileInfo(name string, info os.FileInfo) protocol.FileInfo {
+	return protocol.FileInfo{
+		Name:    WireName(name),
+		Size:    info.Size(),
+		Mode:    uint32(info.Mode()),
+		ModTime: info.ModTime().Unix(),
+		IsDir:   info.IsDir(),
+		Owner:   Owner(info),
+	}
+}
+
 // Stat returns file information
 func (fs *SecureFilesystem) Stat(path string) (*protocol.StatResponse, error) {
+	if fs.roots != nil {
+		return fs.multiStat(path)
+	}
+
 	safePath, err := fs.sanitizePath(path)
 	if err != nil {
 		return nil, err
@@ -136,18 +230,151 @@ func (fs *SecureFilesystem) Stat(path string) (*protocol.StatResponse, error) {
 	}
 
 	return &protocol.StatResponse{
-		Info: protocol.FileInfo{
-			Name:    info.Name(),
-			Size:    info.Size(),
-			Mode:    uint32(info.Mode()),
-			ModTime: info.ModTime().Unix(),
-			IsDir:   info.IsDir(),
-		},
+		Info: fileInfo(info.Name(), info),
 	}, nil
 }
 
+// Hash returns the SHA-256 checksum of a file, or of its first length bytes
+// when length > 0
+func (fs *SecureFilesystem) Hash(ctx context.Context, path string, length int64) (*protocol.HashResponse, error) {
+	if fs.roots != nil {
+		return fs.multiHash(ctx, path, length)
+	}
+
+	safePath, err := fs.sanitizePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// #nosec G304 -- safePath is validated by ResolvePath to prevent directory traversal
+	file, err := os.Open(safePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			slog.Warn("failed to close file", "err", err)
+		}
+	}()
+	return hashFile(ctx, file, length)
+}
+
+// hashFile returns the SHA-256 checksum of an open file, or of its first
+// length bytes when length > 0
+func hashFile(ctx context.Context, file *os.File, length int64) (*protocol.HashResponse, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if info.IsDir() {
+		return nil, errors.New("cannot hash a directory")
+	}
+	if length > info.Size() {
+		return nil, fmt.Errorf("file is only %d bytes long", info.Size())
+	}
+
+	var r io.Reader = file
+	if length > 0 {
+		r = io.LimitReader(file, length)
+	}
+
+	// Hashing a large file takes a while, stop early when the receiver gives up
+	h := sha256.New()
+	buf := make([]byte, 1024*1024)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		n, err := r.Read(buf)
+		h.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+	}
+
+	return &protocol.HashResponse{SHA256: h.Sum(nil)}, nil
+}
+
+// Signature returns the checksums of up to count blocks of a file, starting
+// with block first, for transferring only the blocks that changed
+func (fs *SecureFilesystem) Signature(ctx context.Context, path string, blockSize, first int64, count int) (*protocol.SignatureResponse, error) {
+	if fs.roots != nil {
+		return fs.multiSignature(ctx, path, blockSize, first, count)
+	}
+
+	safePath, err := fs.sanitizePath(path)
+	if err != nil {
+		return nil, err
+	}
+	if blockSize < delta.MinBlockSize || blockSize > delta.MaxBlockSize {
+		return nil, fmt.Errorf("block size must be between %d and %d bytes", delta.MinBlockSize, delta.MaxBlockSize)
+	}
+	if first < 0 || count < 1 {
+		return nil, errors.New("invalid block range")
+	}
+	count = min(count, int(max(1, protocol.MaxSignatureSpan/blockSize)))
+
+	// #nosec G304 -- safePath is validated by ResolvePath to prevent directory traversal
+	file, err := os.Open(safePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			slog.Warn("failed to close file", "err", err)
+		}
+	}()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if info.IsDir() {
+		return nil, errors.New("cannot checksum a directory")
+	}
+
+	r := io.NewSectionReader(file, first*blockSize, max(0, info.Size()-first*blockSize))
+	weak, strong, err := delta.Sign(ctx, r, blockSize, count)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return &protocol.SignatureResponse{Weak: weak, Strong: strong}, nil
+}
+
+// Watch reports changes below a directory
+func (fs *SecureFilesystem) Watch(path string) (*watch.Watcher, error) {
+	if fs.file != "" {
+		return nil, errors.New("single-file shares cannot be watched")
+	}
+	if fs.roots != nil {
+		return fs.multiWatch(path)
+	}
+
+	safePath, err := fs.sanitizePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(safePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, errors.New("not a directory")
+	}
+
+	return watch.New(safePath)
+}
+
 // Read reads file contents
 func (fs *SecureFilesystem) Read(path string, offset, length int64) (*protocol.ReadResponse, error) {
+	if fs.roots != nil {
+		return fs.multiRead(path, offset, length)
+	}
+
 	safePath, err := fs.sanitizePath(path)
 	if err != nil {
 		return nil, err
@@ -160,7 +387,7 @@ func (fs *SecureFilesystem) Read(path string, offset, length int64) (*protocol.R
 	}
 	defer func() {
 		if err := file.Close(); err != nil {
-			log.Printf("Warning: failed to close file: %v", err)
+			slog.Warn("failed to close file", "err", err)
 		}
 	}()
 
@@ -216,12 +443,20 @@ func (fs *SecureFilesystem) Write(path string, offset int64, data []byte) (*prot
 	if fs.readOnly {
 		return nil, ErrPermissionDenied
 	}
+	if fs.roots != nil {
+		return fs.multiWrite(path, offset, data)
+	}
 
 	safePath, err := fs.sanitizePath(path)
 	if err != nil {
 		return nil, err
 	}
 
+	// A new file must be one the filter shares
+	if fs.hidden(safePath, false) {
+		return nil, ErrNotShared
+	}
+
 	// Open or create file
 	// #nosec G304 -- safePath is validated by ResolvePath to prevent directory traversal
 	file, err := os.OpenFile(safePath, os.O_CREATE|os.O_WRONLY, 0600)
@@ -230,7 +465,7 @@ func (fs *SecureFilesystem) Write(path string, offset int64, data []byte) (*prot
 	}
 	defer func() {
 		if err := file.Close(); err != nil {
-			log.Printf("Warning: failed to close file: %v", err)
+			slog.Warn("failed to close file", "err", err)
 		}
 	}()
 
@@ -248,11 +483,98 @@ func (fs *SecureFilesystem) Write(path string, offset int64, data []byte) (*prot
 	return &protocol.WriteResponse{BytesWritten: int64(n)}, nil
 }
 
+// Truncate cuts a file off at size bytes, or extends it with zeros to size
+func (fs *SecureFilesystem) Truncate(path string, size int64) error {
+	if fs.readOnly {
+		return ErrPermissionDenied
+	}
+	if fs.roots != nil {
+		return fs.multiTruncate(path, size)
+	}
+	if size < 0 {
+		return errors.New("invalid size")
+	}
+
+	safePath, err := fs.sanitizePath(path)
+	if err != nil {
+		return err
+	}
+	if fs.hidden(safePath, false) {
+		return ErrNotShared
+	}
+
+	if err := os.Truncate(safePath, size); err != nil {
+		return fmt.Errorf("failed to truncate file: %w", err)
+	}
+
+	return nil
+}
+
+// Copy copies length bytes at sourceOffset of source to offset of path,
+// creating path when it does not exist
+func (fs *SecureFilesystem) Copy(path string, offset int64, source string, sourceOffset, length int64) (*protocol.WriteResponse, error) {
+	if fs.readOnly {
+		return nil, ErrPermissionDenied
+	}
+	if fs.roots != nil {
+		return fs.multiCopy(path, offset, source, sourceOffset, length)
+	}
+	if offset < 0 || sourceOffset < 0 || length < 0 || length > protocol.MaxCopyLength {
+		return nil, errors.New("invalid range")
+	}
+
+	safeSource, err := fs.sanitizePath(source)
+	if err != nil {
+		return nil, err
+	}
+	safePath, err := fs.sanitizePath(path)
+	if err != nil {
+		return nil, err
+	}
+	if fs.hidden(safePath, false) {
+		return nil, ErrNotShared
+	}
+
+	// #nosec G304 -- safeSource is validated by ResolvePath to prevent directory traversal
+	src, err := os.Open(safeSource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() {
+		if err := src.Close(); err != nil {
+			slog.Warn("failed to close file", "err", err)
+		}
+	}()
+	if info, err := src.Stat(); err != nil || !info.Mode().IsRegular() {
+		return nil, errors.New("can only copy from a regular file")
+	}
+
+	// #nosec G304 -- safePath is validated by ResolvePath to prevent directory traversal
+	dst, err := os.OpenFile(safePath, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() {
+		if err := dst.Close(); err != nil {
+			slog.Warn("failed to close file", "err", err)
+		}
+	}()
+
+	n, err := io.Copy(io.NewOffsetWriter(dst, offset), io.NewSectionReader(src, sourceOffset, length))
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy: %w", err)
+	}
+	return &protocol.WriteResponse{BytesWritten: n}, nil
+}
+
 // Delete removes a file or directory
 func (fs *SecureFilesystem) Delete(path string) error {
 	if fs.readOnly {
 		return ErrPermissionDenied
 	}
+	if fs.roots != nil {
+		return fs.multiDelete(path)
+	}
 
 	safePath, err := fs.sanitizePath(path)
 	if err != nil {
@@ -276,6 +598,9 @@ func (fs *SecureFilesystem) Rename(oldPath, newPath string) error {
 	if fs.readOnly {
 		return ErrPermissionDenied
 	}
+	if fs.roots != nil {
+		return fs.multiRename(oldPath, newPath)
+	}
 
 	safeOldPath, err := fs.sanitizePath(oldPath)
 	if err != nil {
@@ -292,6 +617,11 @@ func (fs *SecureFilesystem) Rename(oldPath, newPath string) error {
 		return errors.New("cannot rename root directory")
 	}
 
+	// Renaming must not move a file out of the share
+	if info, err := os.Stat(safeOldPath); err == nil && fs.hidden(safeNewPath, info.IsDir()) {
+		return ErrNotShared
+	}
+
 	if err := os.Rename(safeOldPath, safeNewPath); err != nil {
 		return fmt.Errorf("failed to rename: %w", err)
 	}
@@ -304,6 +634,9 @@ func (fs *SecureFilesystem) Mkdir(path string, perm uint32) error {
 	if fs.readOnly {
 		return ErrPermissionDenied
 	}
+	if fs.roots != nil {
+		return fs.multiMkdir(path, perm)
+	}
 
 	safePath, err := fs.sanitizePath(path)
 	if err != nil {
@@ -317,12 +650,154 @@ func (fs *SecureFilesystem) Mkdir(path string, perm uint32) error {
 	return nil
 }
 
+// Search walks the tree below path and returns entries whose name contains
+// query (case-insensitive), or looks them up in the index once BuildIndex
+// has built it. Results are capped at maxResults.
+func (fs *SecureFilesystem) Search(ctx context.Context, path, query string, maxResults int) (*protocol.SearchResponse, error) {
+	if fs.roots != nil {
+		return fs.multiSearch(ctx, path, query, maxResults)
+	}
+
+	safePath, err := fs.sanitizePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	query = protocol.NormalizeName(strings.ToLower(strings.TrimSpace(query)))
+	if query == "" {
+		return nil, errors.New("empty search query")
+	}
+
+	if maxResults <= 0 || maxResults > maxSearchResults {
+		maxResults = maxSearchResults
+	}
+
+	if idx := fs.currentIndex(); idx != nil && fs.file == "" {
+		dir := strings.TrimPrefix(fs.relativePath(safePath), "/")
+		return idx.search(ctx, dir, query, maxResults, func(e indexEntry) bool {
+			// Symlinks that point outside or are broken stay out, as below
+			target, err := filepath.EvalSymlinks(filepath.Join(fs.rootPath, filepath.FromSlash(e.path)))
+			return err == nil && strings.HasPrefix(target, fs.rootPath)
+		})
+	}
+
+	resp := &protocol.SearchResponse{}
+	err = filepath.WalkDir(safePath, func(p string, d os.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			// Skip unreadable directories instead of aborting the search
+			if d != nil && d.IsDir() && p != safePath {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if p == safePath {
+			return nil
+		}
+		if fs.file != "" && p != safePath && fs.relativePath(p) != "/"+fs.file {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fs.hidden(p, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.Contains(strings.ToLower(WireName(d.Name())), query) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		// Skip symlinks that point outside or are broken
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := filepath.EvalSymlinks(p)
+			if err != nil || !strings.HasPrefix(target, fs.rootPath) {
+				return nil
+			}
+		}
+
+		if len(resp.Results) >= maxResults {
+			resp.Truncated = true
+			return filepath.SkipAll
+		}
+
+		resp.Results = append(resp.Results, protocol.SearchResult{
+			Path: WirePath(fs.relativePath(p)),
+			Info: fileInfo(info.Name(), info),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+
+	return resp, nil
+}
+
+// relativePath converts an absolute path inside the root into the
+// slash-separated form used on the wire (e.g. "/docs/report.pdf")
+func (fs *SecureFilesystem) relativePath(absPath string) string {
+	rel, err := filepath.Rel(fs.rootPath, absPath)
+	if err != nil || rel == "." {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+// SetFilter limits the share to the paths the filter allows
+func (fs *SecureFilesystem) SetFilter(filter *Filter) {
+	fs.filter = filter
+	for _, r := range fs.roots {
+		r.fs.SetFilter(filter)
+	}
+}
+
+// Shared reports whether a path is reachable through the share
+func (fs *SecureFilesystem) Shared(path string) bool {
+	if fs.roots != nil {
+		return fs.multiShared(path)
+	}
+	_, err := fs.sanitizePath(path)
+	return err == nil
+}
+
+// LocalPath returns where a path of the share lies on this machine
+func (fs *SecureFilesystem) LocalPath(path string) (string, error) {
+	if fs.roots != nil {
+		root, rest, err := fs.route(path)
+		if err != nil {
+			return "", err
+		}
+		if root == nil {
+			return "", ErrVirtualRoot
+		}
+		return root.fs.LocalPath(rest)
+	}
+	return fs.sanitizePath(path)
+}
+
 // IsReadOnly returns whether the filesystem is read-only
 func (fs *SecureFilesystem) IsReadOnly() bool {
 	return fs.readOnly
 }
 
-// RootPath returns the root path
+// SharedFile returns the name of the file of a single-file share, or an
+// empty string when a whole directory is shared
+func (fs *SecureFilesystem) SharedFile() string {
+	return fs.file
+}
+
+// RootPath returns the root path, which is empty for a share of several
+// directories
 func (fs *SecureFilesystem) RootPath() string {
 	return fs.rootPath
 }
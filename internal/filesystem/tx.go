@@ -0,0 +1,649 @@
+package filesystem
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// maxTxChanges bounds the changes staged in one transaction
+const maxTxChanges = 100000
+
+// Files of a staging directory besides the staged files, which are numbered
+const (
+	txOwnerFile   = "root"         // the directories of the share it belongs to
+	txJournalFile = "journal.json" // the changes, once committed
+	txAppliedFile = "applied"      // one byte per change made
+)
+
+// Kinds of staged changes
+const (
+	txWrite  = "write"
+	txDelete = "delete"
+	txRename = "rename"
+	txMkdir  = "mkdir"
+)
+
+// ErrTxDone is returned for a transaction that was committed or rolled back
+var ErrTxDone = errors.New("the transaction is over")
+
+// Tx stages changes to a share until Commit makes them all, in the order
+// they were staged, or Rollback drops them. Files written in it are kept in
+// a staging directory outside the share, the other changes are only noted.
+// Commit writes the changes to a journal before it makes them, so that a
+// sharer that stops halfway finishes them with RecoverTx once it shares the
+// same directories again.
+//
+// A file written in a transaction starts out empty and replaces the file
+// of its path when committed. It is safe for concurrent use.
+type Tx struct {
+	fs  *SecureFilesystem
+	id  string
+	dir string
+
+	mu     sync.Mutex
+	ops    []*txOp
+	staged map[string]*txOp // the write of each file written so far
+	files  int
+	done   bool
+}
+
+// txOp is one staged change
+type txOp struct {
+	Kind    string `json:"kind"` // empty once dropped
+	Path    string `json:"path"`
+	NewPath string `json:"new_path,omitempty"`
+	Perm    uint32 `json:"perm,omitempty"`
+	File    string `json:"file,omitempty"` // staged file of a write
+}
+
+// BeginTx opens a transaction, staged in a directory of its own below dir
+func (fs *SecureFilesystem) BeginTx(dir string) (*Tx, error) {
+	if fs.readOnly {
+		return nil, ErrPermissionDenied
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create transaction directory: %w", err)
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(b)
+	stage := filepath.Join(dir, id)
+	if err := os.Mkdir(stage, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create transaction directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(stage, txOwnerFile), []byte(fs.identity()), 0600); err != nil {
+		_ = os.RemoveAll(stage)
+		return nil, fmt.Errorf("failed to create transaction directory: %w", err)
+	}
+	return &Tx{fs: fs, id: id, dir: stage, staged: make(map[string]*txOp)}, nil
+}
+
+// ID returns the ID the receiver sends with the changes of the transaction
+func (t *Tx) ID() string {
+	return t.id
+}
+
+// Write writes data at offset of the staged file of p
+func (t *Tx) Write(p string, offset int64, data []byte) (*protocol.WriteResponse, error) {
+	name, err := t.file(cleanTxPath(p))
+	if err != nil {
+		return nil, err
+	}
+	// #nosec G304 -- the staged file is named by the transaction
+	file, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	n, err := file.WriteAt(data, offset)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write file: %w", err)
+	}
+	return &protocol.WriteResponse{BytesWritten: int64(n)}, nil
+}
+
+// Truncate cuts the staged file of p off at size bytes, or extends it with
+// zeros to size
+func (t *Tx) Truncate(p string, size int64) error {
+	if size < 0 {
+		return errors.New("invalid size")
+	}
+	name, err := t.file(cleanTxPath(p))
+	if err != nil {
+		return err
+	}
+	if err := os.Truncate(name, size); err != nil {
+		return fmt.Errorf("failed to truncate file: %w", err)
+	}
+	return nil
+}
+
+// Copy copies length bytes at sourceOffset of source, staged or not, to
+// offset of the staged file of p
+func (t *Tx) Copy(p string, offset int64, source string, sourceOffset, length int64) (*protocol.WriteResponse, error) {
+	if offset < 0 || sourceOffset < 0 || length < 0 || length > protocol.MaxCopyLength {
+		return nil, errors.New("invalid range")
+	}
+	src, err := t.open(cleanTxPath(source))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = src.Close() }()
+	if info, err := src.Stat(); err != nil || !info.Mode().IsRegular() {
+		return nil, errors.New("can only copy from a regular file")
+	}
+
+	name, err := t.file(cleanTxPath(p))
+	if err != nil {
+		return nil, err
+	}
+	// #nosec G304 -- the staged file is named by the transaction
+	dst, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	n, err := io.Copy(io.NewOffsetWriter(dst, offset), io.NewSectionReader(src, sourceOffset, length))
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy: %w", err)
+	}
+	return &protocol.WriteResponse{BytesWritten: n}, nil
+}
+
+// Hash returns the checksum of the staged file of p, or of the file in the
+// share when p was not written in the transaction
+func (t *Tx) Hash(ctx context.Context, p string, length int64) (*protocol.HashResponse, error) {
+	file, err := t.open(cleanTxPath(p))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+	return hashFile(ctx, file, length)
+}
+
+// Delete stages the removal of a file or directory
+func (t *Tx) Delete(p string) error {
+	p = cleanTxPath(p)
+	if p == "/" {
+		return errors.New("cannot delete root directory")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.stage(p, false); err != nil {
+		return err
+	}
+	t.drop(p)
+	t.ops = append(t.ops, &txOp{Kind: txDelete, Path: p})
+	return nil
+}
+
+// Rename stages a rename. A file written in the transaction is renamed
+// right away, as if it was written under the new name.
+func (t *Tx) Rename(oldPath, newPath string) error {
+	oldPath, newPath = cleanTxPath(oldPath), cleanTxPath(newPath)
+	if oldPath == "/" || newPath == "/" {
+		return errors.New("cannot rename root directory")
+	}
+	if oldPath == newPath {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.stage(newPath, false); err != nil {
+		return err
+	}
+	if err := t.fs.stageable(oldPath, false); err != nil {
+		return err
+	}
+	op, ok := t.staged[oldPath]
+	t.drop(newPath)
+	if !ok {
+		t.ops = append(t.ops, &txOp{Kind: txRename, Path: oldPath, NewPath: newPath})
+		return nil
+	}
+
+	// Moved to the end, after the changes its new place may depend on
+	moved := *op
+	moved.Path = newPath
+	op.Kind = ""
+	delete(t.staged, oldPath)
+	t.staged[newPath] = &moved
+	t.ops = append(t.ops, &moved)
+	return nil
+}
+
+// Mkdir stages the creation of a directory
+func (t *Tx) Mkdir(p string, perm uint32) error {
+	p = cleanTxPath(p)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.stage(p, true); err != nil {
+		return err
+	}
+	t.ops = append(t.ops, &txOp{Kind: txMkdir, Path: p, Perm: perm})
+	return nil
+}
+
+// Written returns the paths of the files written in the transaction
+func (t *Tx) Written() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var written []string
+	for _, op := range t.ops {
+		if op.Kind == txWrite {
+			written = append(written, op.Path)
+		}
+	}
+	return written
+}
+
+// Commit makes the staged changes and returns how many there were. A change
+// that fails stops the commit, the error tells how many were made before.
+func (t *Tx) Commit() (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return 0, ErrTxDone
+	}
+	t.done = true
+	defer func() { _ = os.RemoveAll(t.dir) }()
+
+	var ops []txOp
+	for _, op := range t.ops {
+		if op.Kind == "" {
+			continue
+		}
+		ops = append(ops, *op)
+		// The staged files must be on disk before the journal that refers
+		// to them
+		if op.Kind == txWrite {
+			if err := syncFile(filepath.Join(t.dir, op.File)); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := writeJournal(t.dir, ops); err != nil {
+		return 0, err
+	}
+	n, err := t.fs.replay(t.dir, ops, 0, false)
+	if err != nil {
+		return n, fmt.Errorf("made %d of %d changes: %w", n, len(ops), err)
+	}
+	return n, nil
+}
+
+// Rollback drops the staged changes
+func (t *Tx) Rollback() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return ErrTxDone
+	}
+	t.done = true
+	return os.RemoveAll(t.dir)
+}
+
+// file returns the staged file of p, staging an empty one first when p
+// was not written in the transaction yet
+func (t *Tx) file(p string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if op, ok := t.staged[p]; ok && !t.done {
+		return filepath.Join(t.dir, op.File), nil
+	}
+	if err := t.stage(p, false); err != nil {
+		return "", err
+	}
+
+	t.files++
+	op := &txOp{Kind: txWrite, Path: p, File: strconv.Itoa(t.files)}
+	name := filepath.Join(t.dir, op.File)
+	// #nosec G304 -- the staged file is named by the transaction
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to stage file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("failed to stage file: %w", err)
+	}
+	t.ops = append(t.ops, op)
+	t.staged[p] = op
+	return name, nil
+}
+
+// open opens the staged file of p, or the file in the share when p was not
+// written in the transaction
+func (t *Tx) open(p string) (*os.File, error) {
+	t.mu.Lock()
+	op, ok := t.staged[p]
+	t.mu.Unlock()
+	if !ok {
+		return t.fs.open(p)
+	}
+	// #nosec G304 -- the staged file is named by the transaction
+	file, err := os.Open(filepath.Join(t.dir, op.File))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return file, nil
+}
+
+// stage checks that one more change to p can be staged. Caller must hold
+// t.mu.
+func (t *Tx) stage(p string, isDir bool) error {
+	if t.done {
+		return ErrTxDone
+	}
+	if len(t.ops) >= maxTxChanges {
+		return fmt.Errorf("a transaction holds at most %d changes", maxTxChanges)
+	}
+	return t.fs.stageable(p, isDir)
+}
+
+// drop forgets the staged file of p, which a later change replaces. Caller
+// must hold t.mu.
+func (t *Tx) drop(p string) {
+	op, ok := t.staged[p]
+	if !ok {
+		return
+	}
+	op.Kind = ""
+	delete(t.staged, p)
+	_ = os.Remove(filepath.Join(t.dir, op.File))
+}
+
+// RecoverTx finishes the transactions below dir that an earlier sharer of
+// the same directories committed but stopped making, and drops those it
+// left uncommitted. It returns how many it finished.
+func (fs *SecureFilesystem) RecoverTx(dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read transaction directory: %w", err)
+	}
+
+	finished := 0
+	var errs []error
+	for _, entry := range entries {
+		stage := filepath.Join(dir, entry.Name())
+		// #nosec G304 -- the transaction directory belongs to orb
+		owner, err := os.ReadFile(filepath.Join(stage, txOwnerFile))
+		if err != nil || string(owner) != fs.identity() {
+			continue
+		}
+		// #nosec G304 -- the transaction directory belongs to orb
+		if data, err := os.ReadFile(filepath.Join(stage, txJournalFile)); err == nil {
+			var ops []txOp
+			applied := 0
+			if info, err := os.Stat(filepath.Join(stage, txAppliedFile)); err == nil {
+				applied = int(info.Size())
+			}
+			if err := json.Unmarshal(data, &ops); err != nil {
+				errs = append(errs, fmt.Errorf("transaction %s: unreadable journal: %w", entry.Name(), err))
+			} else if _, err := fs.replay(stage, ops, min(applied, len(ops)), true); err != nil {
+				errs = append(errs, fmt.Errorf("transaction %s: %w", entry.Name(), err))
+			} else {
+				finished++
+			}
+		}
+		if err := os.RemoveAll(stage); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return finished, errors.Join(errs...)
+}
+
+// replay makes the changes of a committed transaction from the one at from
+// on, noting each in the applied file, and returns how many are made. When
+// resumed, the first of them may have been made by a sharer that stopped
+// before it could note it, so it may fail.
+func (fs *SecureFilesystem) replay(dir string, ops []txOp, from int, resumed bool) (int, error) {
+	// #nosec G304 -- the transaction directory belongs to orb
+	applied, err := os.OpenFile(filepath.Join(dir, txAppliedFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return from, fmt.Errorf("failed to open the transaction journal: %w", err)
+	}
+	defer func() { _ = applied.Close() }()
+
+	for i := from; i < len(ops); i++ {
+		if err := fs.apply(dir, ops[i]); err != nil && (!resumed || i != from) {
+			return i, fmt.Errorf("failed to %s %s: %w", ops[i].Kind, ops[i].Path, err)
+		}
+		if _, err := applied.Write([]byte{1}); err != nil {
+			return i + 1, fmt.Errorf("failed to write the transaction journal: %w", err)
+		}
+	}
+	return len(ops), nil
+}
+
+// apply makes one change of a committed transaction
+func (fs *SecureFilesystem) apply(dir string, op txOp) error {
+	switch op.Kind {
+	case txWrite:
+		return fs.place(op.Path, filepath.Join(dir, op.File))
+	case txDelete:
+		// Also when the file was only ever written in the transaction
+		if err := fs.Delete(op.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	case txRename:
+		return fs.Rename(op.Path, op.NewPath)
+	case txMkdir:
+		return fs.Mkdir(op.Path, op.Perm)
+	}
+	return fmt.Errorf("unknown change %q", op.Kind)
+}
+
+// place moves a staged file to p, replacing the file there
+func (fs *SecureFilesystem) place(p, staged string) error {
+	if fs.readOnly {
+		return ErrPermissionDenied
+	}
+	if fs.roots != nil {
+		root, rest, err := fs.route(p)
+		if err != nil {
+			return err
+		}
+		if root == nil {
+			return ErrVirtualRoot
+		}
+		return root.fs.place(rest, staged)
+	}
+
+	safePath, err := fs.sanitizePath(p)
+	if err != nil {
+		return err
+	}
+	if safePath == fs.rootPath {
+		return errors.New("cannot replace root directory")
+	}
+	if fs.hidden(safePath, false) {
+		return ErrNotShared
+	}
+	// A replaced file keeps its permissions
+	if info, err := os.Stat(safePath); err == nil {
+		if info.IsDir() {
+			return errors.New("a directory is in the way")
+		}
+		_ = os.Chmod(staged, info.Mode().Perm())
+	}
+	if err := os.Rename(staged, safePath); err == nil {
+		return nil
+	}
+
+	// The staging directory may be on another filesystem, the file is then
+	// copied next to its place and moved there
+	temp := safePath + ".orb-tx"
+	if err := copyFile(staged, temp); err != nil {
+		_ = os.Remove(temp)
+		return err
+	}
+	if err := os.Rename(temp, safePath); err != nil {
+		_ = os.Remove(temp)
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+	_ = os.Remove(staged)
+	return nil
+}
+
+// stageable checks what it can of a change to p before the changes staged
+// earlier are made, which Commit checks again when it makes it
+func (fs *SecureFilesystem) stageable(p string, isDir bool) error {
+	if fs.readOnly {
+		return ErrPermissionDenied
+	}
+	if fs.roots != nil {
+		root, rest, err := fs.route(p)
+		if err != nil {
+			return err
+		}
+		if root == nil {
+			return ErrVirtualRoot
+		}
+		return root.fs.stageable(rest, isDir)
+	}
+	if fs.hidden(filepath.Join(fs.rootPath, filepath.FromSlash(strings.TrimPrefix(p, "/"))), isDir) {
+		return ErrNotShared
+	}
+	return nil
+}
+
+// open opens a file of the share for reading
+func (fs *SecureFilesystem) open(p string) (*os.File, error) {
+	if fs.roots != nil {
+		root, rest, err := fs.route(p)
+		if err != nil {
+			return nil, err
+		}
+		if root == nil {
+			return nil, ErrVirtualRoot
+		}
+		return root.fs.open(rest)
+	}
+
+	safePath, err := fs.sanitizePath(p)
+	if err != nil {
+		return nil, err
+	}
+	// #nosec G304 -- safePath is validated by ResolvePath to prevent directory traversal
+	file, err := os.Open(safePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return file, nil
+}
+
+// identity names the directories of the share, to tell the transactions
+// an earlier run left for it
+func (fs *SecureFilesystem) identity() string {
+	if fs.roots == nil {
+		return fs.rootPath
+	}
+	paths := make([]string, len(fs.roots))
+	for i, root := range fs.roots {
+		paths[i] = root.fs.rootPath
+	}
+	return strings.Join(paths, "\n")
+}
+
+// cleanTxPath turns a wire path into the form transactions key files by
+func cleanTxPath(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))
+}
+
+// writeJournal notes the changes of a committed transaction in dir
+func writeJournal(dir string, ops []txOp) error {
+	data, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+	temp := filepath.Join(dir, txJournalFile+".tmp")
+	// #nosec G304 -- the transaction directory belongs to orb
+	file, err := os.OpenFile(temp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write the transaction journal: %w", err)
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp, filepath.Join(dir, txJournalFile))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write the transaction journal: %w", err)
+	}
+	return nil
+}
+
+// syncFile flushes a file to disk
+func syncFile(name string) error {
+	// #nosec G304 -- the staged file is named by the transaction
+	file, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open staged file: %w", err)
+	}
+	err = file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// copyFile copies src to a new file dst with the same permissions, flushed
+// to disk
+func copyFile(src, dst string) error {
+	// #nosec G304 -- the staged file is named by the transaction
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open staged file: %w", err)
+	}
+	defer func() { _ = in.Close() }()
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat staged file: %w", err)
+	}
+	// #nosec G304 -- dst is validated by the caller
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy staged file: %w", err)
+	}
+	return nil
+}
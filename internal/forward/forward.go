@@ -0,0 +1,351 @@
+// Package forward carries TCP connections through the tunnel for
+// orb connect --forward. Each connection is a stream of StreamData frames in
+// both directions, with a window of unacknowledged bytes per stream so that
+// a slow reader on one side holds back only its own sender.
+package forward
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// maxChunk is the most data sent in one frame
+const maxChunk = 32 * 1024
+
+// ErrReset is returned by a stream the peer tore down
+var ErrReset = errors.New("stream reset by the peer")
+
+// FrameTypes are the frame types Streams.Handle consumes
+var FrameTypes = []uint32{
+	protocol.FrameTypeStreamData,
+	protocol.FrameTypeStreamAck,
+	protocol.FrameTypeStreamClose,
+}
+
+// Streams are the forwarded connections of one tunnel
+type Streams struct {
+	send func(*protocol.Frame) error
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+	closed  bool
+}
+
+// NewStreams sends the frames of its streams with send, which must send
+// them with frame ID 0
+func NewStreams(send func(*protocol.Frame) error) *Streams {
+	return &Streams{
+		send:    send,
+		streams: make(map[uint32]*Stream),
+	}
+}
+
+// New opens a stream with a new ID, on the side that asks for forwarded
+// connections
+func (s *Streams) New() (*Stream, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, net.ErrClosed
+	}
+	for {
+		s.nextID++
+		if _, taken := s.streams[s.nextID]; !taken && s.nextID != 0 {
+			break
+		}
+	}
+	return s.add(s.nextID), nil
+}
+
+// Open opens the stream the peer picked for a connection
+func (s *Streams) Open(id uint32) (*Stream, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, net.ErrClosed
+	}
+	if _, taken := s.streams[id]; taken {
+		return nil, errors.New("stream already open")
+	}
+	return s.add(id), nil
+}
+
+// add registers a stream. Caller must hold s.mu.
+func (s *Streams) add(id uint32) *Stream {
+	st := &Stream{id: id, streams: s}
+	st.cond = sync.NewCond(&st.mu)
+	s.streams[id] = st
+	return st
+}
+
+// lookup returns an open stream, nil when it is unknown
+func (s *Streams) lookup(id uint32) *Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[id]
+}
+
+// remove forgets a stream that is closed in both directions
+func (s *Streams) remove(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
+
+// Handle delivers a stream frame of the peer. It never blocks, so it can be
+// called from the loop reading the tunnel. Frames of unknown streams are
+// dropped; they belong to streams closed in the meantime.
+func (s *Streams) Handle(frame *protocol.Frame) {
+	dec := gob.NewDecoder(bytes.NewReader(frame.Payload))
+	switch frame.Type {
+	case protocol.FrameTypeStreamData:
+		var msg protocol.StreamData
+		if err := dec.Decode(&msg); err != nil {
+			return
+		}
+		if st := s.lookup(msg.Stream); st != nil {
+			st.receive(msg.Data)
+		}
+	case protocol.FrameTypeStreamAck:
+		var msg protocol.StreamAck
+		if err := dec.Decode(&msg); err != nil {
+			return
+		}
+		if st := s.lookup(msg.Stream); st != nil {
+			st.acknowledged(msg.Bytes)
+		}
+	case protocol.FrameTypeStreamClose:
+		var msg protocol.StreamClose
+		if err := dec.Decode(&msg); err != nil {
+			return
+		}
+		if st := s.lookup(msg.Stream); st != nil {
+			st.remoteClosed(msg.Abort)
+		}
+	}
+}
+
+// CloseAll ends every stream without telling the peer, once the tunnel is gone
+func (s *Streams) CloseAll() {
+	s.mu.Lock()
+	s.closed = true
+	streams := s.streams
+	s.streams = make(map[uint32]*Stream)
+	s.mu.Unlock()
+
+	for _, st := range streams {
+		st.remoteClosed(true)
+	}
+}
+
+// sendFrame encodes and sends a stream frame
+func (s *Streams) sendFrame(frameType uint32, msg any) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(msg); err != nil {
+		return err
+	}
+	return s.send(&protocol.Frame{Type: frameType, Payload: buf.Bytes()})
+}
+
+// Stream is one forwarded connection. Read and Write may be called from
+// different goroutines.
+type Stream struct {
+	id      uint32
+	streams *Streams
+
+	mu         sync.Mutex
+	cond       *sync.Cond
+	buf        []byte // received, not yet read
+	unacked    int64  // sent, not yet acknowledged
+	pendingAck int64  // read, not yet acknowledged
+	readDone   bool   // the peer sends no more
+	writeDone  bool   // we send no more
+	reset      bool
+}
+
+// ID identifies the stream on the tunnel
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+// Read reads data the peer sent. It returns io.EOF once the peer closed its
+// side and ErrReset when it tore the stream down.
+func (st *Stream) Read(p []byte) (int, error) {
+	st.mu.Lock()
+	for len(st.buf) == 0 && !st.readDone && !st.reset {
+		st.cond.Wait()
+	}
+	if st.reset {
+		st.mu.Unlock()
+		return 0, ErrReset
+	}
+	if len(st.buf) == 0 {
+		st.mu.Unlock()
+		return 0, io.EOF
+	}
+
+	n := copy(p, st.buf)
+	st.buf = st.buf[n:]
+	st.pendingAck += int64(n)
+	// Acknowledging every read would cost a frame per read
+	var ack int64
+	if st.pendingAck >= protocol.StreamWindow/4 || len(st.buf) == 0 {
+		ack = st.pendingAck
+		st.pendingAck = 0
+	}
+	st.mu.Unlock()
+
+	if ack > 0 {
+		_ = st.streams.sendFrame(protocol.FrameTypeStreamAck, protocol.StreamAck{Stream: st.id, Bytes: ack})
+	}
+	return n, nil
+}
+
+// Write sends p to the peer, waiting while the peer has not acknowledged
+// a window's worth of earlier data
+func (st *Stream) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		st.mu.Lock()
+		for st.unacked >= protocol.StreamWindow && !st.reset && !st.writeDone {
+			st.cond.Wait()
+		}
+		if st.reset {
+			st.mu.Unlock()
+			return written, ErrReset
+		}
+		if st.writeDone {
+			st.mu.Unlock()
+			return written, io.ErrClosedPipe
+		}
+		n := min(len(p)-written, maxChunk, int(protocol.StreamWindow-st.unacked))
+		st.unacked += int64(n)
+		st.mu.Unlock()
+
+		msg := protocol.StreamData{Stream: st.id, Data: p[written : written+n]}
+		if err := st.streams.sendFrame(protocol.FrameTypeStreamData, msg); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// CloseWrite tells the peer that no more data follows, like a TCP FIN
+func (st *Stream) CloseWrite() error {
+	st.mu.Lock()
+	if st.writeDone || st.reset {
+		st.mu.Unlock()
+		return nil
+	}
+	st.writeDone = true
+	done := st.readDone
+	st.cond.Broadcast()
+	st.mu.Unlock()
+
+	if done {
+		st.streams.remove(st.id)
+	}
+	return st.streams.sendFrame(protocol.FrameTypeStreamClose, protocol.StreamClose{Stream: st.id})
+}
+
+// Close tears the stream down in both directions
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.reset || (st.writeDone && st.readDone) {
+		st.mu.Unlock()
+		st.streams.remove(st.id)
+		return nil
+	}
+	st.reset = true
+	st.cond.Broadcast()
+	st.mu.Unlock()
+
+	st.streams.remove(st.id)
+	return st.streams.sendFrame(protocol.FrameTypeStreamClose, protocol.StreamClose{Stream: st.id, Abort: true})
+}
+
+// receive queues data of the peer. A peer that ignores the window is cut off.
+func (st *Stream) receive(data []byte) {
+	st.mu.Lock()
+	if st.reset || st.readDone {
+		st.mu.Unlock()
+		return
+	}
+	if int64(len(st.buf)+len(data)) > protocol.StreamWindow {
+		st.mu.Unlock()
+		slog.Debug("stream exceeded its window", "stream", st.id)
+		_ = st.Close()
+		return
+	}
+	st.buf = append(st.buf, data...)
+	st.cond.Broadcast()
+	st.mu.Unlock()
+}
+
+// acknowledged makes room in the window
+func (st *Stream) acknowledged(n int64) {
+	st.mu.Lock()
+	st.unacked = max(st.unacked-n, 0)
+	st.cond.Broadcast()
+	st.mu.Unlock()
+}
+
+// remoteClosed records that the peer closed its side, or the whole stream
+func (st *Stream) remoteClosed(abort bool) {
+	st.mu.Lock()
+	if abort {
+		st.reset = true
+	}
+	st.readDone = true
+	done := st.writeDone || st.reset
+	st.cond.Broadcast()
+	st.mu.Unlock()
+
+	if done {
+		st.streams.remove(st.id)
+	}
+}
+
+// Pipe copies between conn and st in both directions until both are done,
+// then closes them. A side that finishes sending is half-closed on the
+// other, so protocols that rely on it keep working.
+func Pipe(conn net.Conn, st *Stream) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if _, err := io.Copy(st, conn); err != nil {
+			_ = st.Close()
+			_ = conn.Close()
+			return
+		}
+		_ = st.CloseWrite()
+	}()
+	go func() {
+		defer wg.Done()
+		if _, err := io.Copy(conn, st); err != nil {
+			_ = st.Close()
+			_ = conn.Close()
+			return
+		}
+		if tcp, ok := conn.(interface{ CloseWrite() error }); ok {
+			_ = tcp.CloseWrite()
+		} else {
+			_ = conn.Close()
+		}
+	}()
+	wg.Wait()
+	_ = st.Close()
+	_ = conn.Close()
+}
@@ -0,0 +1,120 @@
+// Package gateway serves a share to a browser on the receiving machine: a
+// small web UI, a JSON listing and file access through WebDAV
+package gateway
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/mount"
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+)
+
+//go:embed index.html
+var indexHTML []byte
+
+// filesPrefix is where the files of the share are served
+const filesPrefix = "/files"
+
+// entry is a file of a listing of /api/list
+type entry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	IsDir   bool      `json:"is_dir"`
+	ModTime time.Time `json:"modified"`
+}
+
+// listing is the response of /api/list
+type listing struct {
+	Path     string  `json:"path"`
+	ReadOnly bool    `json:"read_only"`
+	Entries  []entry `json:"entries"`
+}
+
+// Serve serves the web UI for the share behind client on ln until ctx is
+// done or the tunnel closes
+func Serve(ctx context.Context, client *remote.Client, ln net.Listener, opts mount.Options) error {
+	srv := &http.Server{
+		Handler:           checkHost(ln, NewHandler(client, opts)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-client.Mux().Done():
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("http gateway failed: %w", err)
+	}
+	if err := client.Mux().Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("connection lost: %w", err)
+	}
+	return nil
+}
+
+// NewHandler serves the web UI at /, listings at /api/list?path= and the
+// files below /files, where GET downloads with range support and PUT
+// uploads to a writable share
+func NewHandler(client *remote.Client, opts mount.Options) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(filesPrefix+"/", mount.NewWebDAVHandler(client, filesPrefix, opts))
+	mux.HandleFunc("GET /api/list", func(w http.ResponseWriter, r *http.Request) {
+		dir := path.Join("/", r.URL.Query().Get("path"))
+		files, err := client.List(r.Context(), dir)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		resp := listing{Path: dir, ReadOnly: opts.ReadOnly, Entries: make([]entry, 0, len(files))}
+		for _, f := range files {
+			resp.Entries = append(resp.Entries, entry{
+				Name:    f.Name,
+				Size:    f.Size,
+				IsDir:   f.IsDir,
+				ModTime: time.Unix(f.ModTime, 0),
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(indexHTML)
+	})
+	return mux
+}
+
+// checkHost refuses requests naming another host than the one listened on
+// when that is a loopback address. It keeps web pages from reaching the
+// share through a DNS name that resolves to localhost.
+func checkHost(ln net.Listener, next http.Handler) http.Handler {
+	addr, ok := ln.Addr().(*net.TCPAddr)
+	if !ok || !addr.IP.IsLoopback() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			http.Error(w, "forbidden host", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
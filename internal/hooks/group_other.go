@@ -0,0 +1,9 @@
+//go:build !unix
+
+package hooks
+
+import "os/exec"
+
+// killGroup leaves cmd as it is, only the command itself is killed when its
+// context is done
+func killGroup(cmd *exec.Cmd) {}
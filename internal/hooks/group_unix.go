@@ -0,0 +1,17 @@
+//go:build unix
+
+package hooks
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killGroup starts cmd in a process group of its own, which is killed as a
+// whole when its context is done
+func killGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
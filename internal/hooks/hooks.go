@@ -0,0 +1,235 @@
+// Package hooks runs the commands configured under hooks in config.yaml
+// when a share reports an event, so that users can script notifications,
+// virus scans of uploads or post-processing.
+//
+// A command is run by the shell, sh on Unix and cmd on Windows. It gets the
+// event as one line of JSON on stdin and its main fields in the environment
+// as ORB_EVENT, ORB_SESSION_ID, ORB_PATH and ORB_LOCAL_PATH. Commands run
+// one after another in the order of their events, so a slow one delays the
+// rest but never the share itself.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+)
+
+// The events a share reports
+const (
+	PeerConnected    = "peer_connected"
+	PeerDisconnected = "peer_disconnected"
+	FileDownloaded   = "file_downloaded"
+	UploadCompleted  = "upload_completed"
+	QuotaExceeded    = "quota_exceeded"
+)
+
+// Events lists the events hooks can be run on, besides "*" for all of them
+var Events = []string{PeerConnected, PeerDisconnected, FileDownloaded, UploadCompleted, QuotaExceeded}
+
+const (
+	// DefaultTimeout ends commands that set no timeout of their own
+	DefaultTimeout = time.Minute
+	// queueSize bounds the events waiting for their commands, further ones
+	// are dropped
+	queueSize = 256
+	// closeTimeout is how long Close waits for queued commands
+	closeTimeout = 30 * time.Second
+	// waitDelay is how long a command that exited or was ended may leave
+	// processes it started holding on to its output
+	waitDelay = 5 * time.Second
+)
+
+// Event is what a hook gets on stdin
+type Event struct {
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	SessionID string    `json:"session_id,omitempty"`
+	// Path is the path in the share, LocalPath where it lies on this machine
+	Path      string `json:"path,omitempty"`
+	LocalPath string `json:"local_path,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	// Files counts the downloads of a share with a download limit
+	Files int    `json:"files,omitempty"`
+	Text  string `json:"text,omitempty"`
+}
+
+// hook is a configured command with its parsed timeout
+type hook struct {
+	event   string
+	run     string
+	timeout time.Duration
+}
+
+// Runner runs the hooks of a share. A nil Runner runs nothing, so callers
+// need not check whether any hooks are configured.
+type Runner struct {
+	hooks []hook
+	queue chan Event
+	done  chan struct{}
+	once  sync.Once
+}
+
+// New checks the configured hooks and starts running their commands. It
+// returns nil when there are none.
+func New(configured []config.Hook) (*Runner, error) {
+	if len(configured) == 0 {
+		return nil, nil
+	}
+	hooks := make([]hook, 0, len(configured))
+	for _, h := range configured {
+		if h.Event != "*" && !known(h.Event) {
+			return nil, fmt.Errorf("hook for unknown event %q, use one of %s or *", h.Event, strings.Join(Events, ", "))
+		}
+		if strings.TrimSpace(h.Run) == "" {
+			return nil, fmt.Errorf("hook for %s has no command to run", h.Event)
+		}
+		timeout := DefaultTimeout
+		if h.Timeout != "" {
+			d, err := time.ParseDuration(h.Timeout)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("hook for %s: invalid timeout %q", h.Event, h.Timeout)
+			}
+			timeout = d
+		}
+		hooks = append(hooks, hook{event: h.Event, run: h.Run, timeout: timeout})
+	}
+
+	r := &Runner{hooks: hooks, queue: make(chan Event, queueSize), done: make(chan struct{})}
+	go r.loop()
+	return r, nil
+}
+
+func known(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Wants reports whether any hook runs on event, so that callers can skip
+// work only hooks need
+func (r *Runner) Wants(event string) bool {
+	if r == nil {
+		return false
+	}
+	for _, h := range r.hooks {
+		if h.event == event || h.event == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Fire queues the commands of an event without waiting for them
+func (r *Runner) Fire(e Event) {
+	if !r.Wants(e.Event) {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	select {
+	case r.queue <- e:
+	default:
+		slog.Warn("too many hooks waiting, event dropped", "event", e.Event, "path", e.Path)
+	}
+}
+
+// Close waits for the queued commands to finish, for a while
+func (r *Runner) Close() {
+	if r == nil {
+		return
+	}
+	r.once.Do(func() { close(r.queue) })
+	select {
+	case <-r.done:
+	case <-time.After(closeTimeout):
+		slog.Warn("hooks still running, not waiting for them")
+	}
+}
+
+func (r *Runner) loop() {
+	defer close(r.done)
+	for e := range r.queue {
+		for _, h := range r.hooks {
+			if h.event == e.Event || h.event == "*" {
+				h.exec(e)
+			}
+		}
+	}
+}
+
+// exec runs the command of h for e and logs the outcome
+func (h hook) exec(e Event) {
+	input, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	cmd := shellCommand(ctx, h.run)
+	cmd.Stdin = bytes.NewReader(append(input, '\n'))
+	cmd.Env = append(os.Environ(),
+		"ORB_EVENT="+e.Event,
+		"ORB_SESSION_ID="+e.SessionID,
+		"ORB_PATH="+e.Path,
+		"ORB_LOCAL_PATH="+e.LocalPath,
+	)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	started := time.Now()
+	err = cmd.Run()
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		slog.Warn("hook timed out", "event", e.Event, "run", h.run, "timeout", h.timeout)
+	case errors.Is(err, exec.ErrWaitDelay):
+		slog.Debug("hook ran, leaving processes in the background", "event", e.Event, "run", h.run, "duration", time.Since(started))
+	case err != nil:
+		slog.Warn("hook failed", "event", e.Event, "run", h.run, "err", err, "output", lastLine(output.String()))
+	default:
+		slog.Debug("hook ran", "event", e.Event, "run", h.run, "duration", time.Since(started))
+	}
+}
+
+// shellCommand runs command line by the shell of the system. On Unix the
+// processes it starts are killed with it when ctx is done. Its output is
+// given up on waitDelay after it exited or was killed.
+func shellCommand(ctx context.Context, line string) *exec.Cmd {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		// #nosec G204 -- hooks are commands the user configured
+		cmd = exec.CommandContext(ctx, "cmd", "/C", line)
+	} else {
+		// #nosec G204 -- hooks are commands the user configured
+		cmd = exec.CommandContext(ctx, "sh", "-c", line)
+	}
+	cmd.WaitDelay = waitDelay
+	killGroup(cmd)
+	return cmd
+}
+
+// lastLine picks the last line a failed command printed, usually its error
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
@@ -0,0 +1,171 @@
+package jobs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression: minute, hour, day of month, month
+// and day of week, each a set of the values it matches
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// A day matches either day field when both are restricted, as in cron
+	domAny, dowAny bool
+}
+
+// macros are the shorthands cron accepts in place of the five fields
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// ParseSchedule parses a cron expression of five fields, such as
+// "0 2 * * *" for 2 AM every day, or one of @hourly, @daily, @weekly,
+// @monthly and @yearly. Fields take lists, ranges, steps and the English
+// names of months and days, e.g. "*/15", "1-5" or "mon,wed,fri".
+func ParseSchedule(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "@") {
+		expanded, ok := macros[strings.ToLower(expr)]
+		if !ok {
+			return Schedule{}, fmt.Errorf("unknown schedule %s", expr)
+		}
+		expr = expanded
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return Schedule{}, fmt.Errorf("schedule %q needs five fields: minute hour day month weekday", expr)
+	}
+
+	var s Schedule
+	var err error
+	if s.minute, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return Schedule{}, fmt.Errorf("minute: %w", err)
+	}
+	if s.hour, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return Schedule{}, fmt.Errorf("hour: %w", err)
+	}
+	if s.dom, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return Schedule{}, fmt.Errorf("day of month: %w", err)
+	}
+	if s.month, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return Schedule{}, fmt.Errorf("month: %w", err)
+	}
+	if s.dow, err = parseField(fields[4], 0, 7, dayNames); err != nil {
+		return Schedule{}, fmt.Errorf("day of week: %w", err)
+	}
+	// Sunday is both 0 and 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+	return s, nil
+}
+
+// parseField turns one field into the set of values it matches. names, if
+// set, are accepted for the values from the lowest one on.
+func parseField(field string, lo, hi int, names []string) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+			step = n
+		}
+
+		from, to := lo, hi
+		if rng != "*" {
+			first, last, isRange := strings.Cut(rng, "-")
+			var err error
+			if from, err = fieldValue(first, lo, hi, names); err != nil {
+				return 0, err
+			}
+			to = from
+			if isRange {
+				if to, err = fieldValue(last, lo, hi, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				to = hi
+			}
+			if to < from {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		}
+
+		for v := from; v <= to; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// fieldValue parses a number or name of a field
+func fieldValue(text string, lo, hi int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(text, name) {
+			return lo + i, nil
+		}
+	}
+	v, err := strconv.Atoi(text)
+	if err != nil || v < lo || v > hi {
+		return 0, fmt.Errorf("%q is not between %d and %d", text, lo, hi)
+	}
+	return v, nil
+}
+
+// dayMatches reports whether the schedule runs on the day of t
+func (s Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// Next returns the first minute after t the schedule runs at, or the zero
+// time when it never does, such as on the 31st of February
+func (s Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		y, mo, d := t.Date()
+		switch {
+		case s.month&(1<<int(mo)) == 0:
+			t = time.Date(y, mo+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(y, mo, d+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(y, mo, d, t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,183 @@
+// Package jobs keeps the scheduled transfers of orb jobs in jobs.yaml next to
+// config.yaml. orb daemon runs them when their cron schedule comes round.
+//
+// The file holds the session passcodes of the jobs and is only readable by
+// its owner. Every change rewrites it through a temporary file, so readers
+// never see it half written.
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrNotFound is returned for an unknown job ID
+var ErrNotFound = errors.New("no job with that ID")
+
+// Job is a command run on a schedule
+type Job struct {
+	ID       string `yaml:"id" json:"id"`
+	Schedule string `yaml:"schedule" json:"schedule"`
+	// Args is the orb command run, e.g. sync SESSION / /backups
+	Args     []string  `yaml:"args" json:"args"`
+	Passcode string    `yaml:"passcode" json:"-"`
+	Added    time.Time `yaml:"added" json:"added"`
+
+	// The outcome of the last run
+	LastRun   time.Time `yaml:"last_run,omitempty" json:"last_run,omitzero"`
+	LastError string    `yaml:"last_error,omitempty" json:"last_error,omitempty"`
+	// LastChanges counts the files copied, deleted and created
+	LastChanges int `yaml:"last_changes,omitempty" json:"last_changes,omitempty"`
+}
+
+// file is the layout of jobs.yaml
+type file struct {
+	Jobs []Job `yaml:"jobs"`
+}
+
+// Store is the jobs.yaml of this machine
+type Store struct {
+	path string
+	mu   sync.Mutex // serializes the changes of this process
+}
+
+// Open uses jobs.yaml next to config.yaml
+func Open() (*Store, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return nil, err
+	}
+	return &Store{path: filepath.Join(dir, "jobs.yaml")}, nil
+}
+
+// Path returns the location of the file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Jobs returns the jobs in the order they were added
+func (s *Store) Jobs() ([]Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read()
+}
+
+// Find returns the job with the given ID
+func (s *Store) Find(id string) (Job, error) {
+	jobs, err := s.Jobs()
+	if err != nil {
+		return Job{}, err
+	}
+	for _, j := range jobs {
+		if j.ID == id {
+			return j, nil
+		}
+	}
+	return Job{}, ErrNotFound
+}
+
+// Add stores a new job, giving it an ID and the time it was added
+func (s *Store) Add(j Job) (Job, error) {
+	if _, err := ParseSchedule(j.Schedule); err != nil {
+		return Job{}, err
+	}
+	j.ID = newID()
+	j.Added = time.Now()
+	err := s.update(func(jobs []Job) ([]Job, error) {
+		return append(jobs, j), nil
+	})
+	return j, err
+}
+
+// Remove deletes a job
+func (s *Store) Remove(id string) error {
+	return s.update(func(jobs []Job) ([]Job, error) {
+		for i, j := range jobs {
+			if j.ID == id {
+				return append(jobs[:i], jobs[i+1:]...), nil
+			}
+		}
+		return nil, ErrNotFound
+	})
+}
+
+// Ran records the outcome of a run of a job. A job removed in the meantime
+// is left out.
+func (s *Store) Ran(id string, at time.Time, changes int, runErr error) error {
+	return s.update(func(jobs []Job) ([]Job, error) {
+		for i := range jobs {
+			if jobs[i].ID != id {
+				continue
+			}
+			jobs[i].LastRun, jobs[i].LastChanges, jobs[i].LastError = at, changes, ""
+			if runErr != nil {
+				jobs[i].LastError = runErr.Error()
+			}
+		}
+		return jobs, nil
+	})
+}
+
+// update rewrites the file with the jobs change returns
+func (s *Store) update(change func([]Job) ([]Job, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs, err := s.read()
+	if err != nil {
+		return err
+	}
+	jobs, err = change(jobs)
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(file{Jobs: jobs})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(s.path), err)
+	}
+	temp := s.path + ".tmp"
+	if err := os.WriteFile(temp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write jobs: %w", err)
+	}
+	if err := os.Rename(temp, s.path); err != nil {
+		_ = os.Remove(temp)
+		return fmt.Errorf("failed to write jobs: %w", err)
+	}
+	return nil
+}
+
+// read loads the file, which is missing until the first job is added
+func (s *Store) read() ([]Job, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jobs: %w", err)
+	}
+	var f file
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", s.path, err)
+	}
+	return f.Jobs, nil
+}
+
+// newID returns a short random job ID that is easy to type
+func newID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package journal
+
+// processAlive assumes that the process of an unfinished transfer is gone,
+// there is no portable way to tell
+func processAlive(pid int) bool {
+	return false
+}
@@ -0,0 +1,25 @@
+//go:build windows
+
+package journal
+
+import "syscall"
+
+// stillActive is the exit code of a process that has not exited
+const stillActive = 259
+
+// processAlive reports whether a process with the given PID is running
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	handle, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer func() { _ = syscall.CloseHandle(handle) }()
+	var code uint32
+	if err := syscall.GetExitCodeProcess(handle, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
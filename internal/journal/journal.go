@@ -0,0 +1,273 @@
+// Package journal keeps the transfers of every orb process in transfers.db
+// next to config.yaml, so that they can be listed with orb transfers and
+// resumed after the process that ran them is gone.
+//
+// The file is a log of JSON records, one per line, appended as transfers
+// start, advance and end; the newest line of a transfer wins. Each append
+// is a single small write, so processes can share the file. It is compacted
+// once it grows past maxSize.
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+)
+
+const (
+	// maxSize is the size of the log that triggers a compaction
+	maxSize = 1024 * 1024
+
+	// keepFinished is how many finished transfers survive a compaction
+	keepFinished = 500
+
+	// progressInterval is the minimum time between progress records of a
+	// running transfer
+	progressInterval = 2 * time.Second
+)
+
+// ErrNotFound is returned for an unknown transfer ID
+var ErrNotFound = errors.New("no transfer with that ID")
+
+// StateInterrupted is the state of a transfer that did not finish and whose
+// process is gone
+const StateInterrupted = "interrupted"
+
+// Record is the last known state of a transfer
+type Record struct {
+	ID          string    `json:"id"`
+	SessionID   string    `json:"session_id"`
+	Direction   string    `json:"direction"` // "download" or "upload"
+	Remote      string    `json:"remote"`
+	Local       string    `json:"local"`
+	Size        int64     `json:"size"`
+	Transferred int64     `json:"transferred"`
+	State       string    `json:"state"`
+	SHA256      string    `json:"sha256,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	PID         int       `json:"pid"`
+	Started     time.Time `json:"started"`
+	Updated     time.Time `json:"updated"`
+}
+
+// Finished reports whether the transfer completed, failed or was cancelled
+func (r Record) Finished() bool {
+	switch r.State {
+	case transfer.StateDone.String(), transfer.StateFailed.String(), transfer.StateCancelled.String():
+		return true
+	}
+	return false
+}
+
+// Journal is the transfers.db of this machine
+type Journal struct {
+	path string
+	mu   sync.Mutex // serializes the writes of this process
+}
+
+// Open uses transfers.db next to config.yaml
+func Open() (*Journal, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{path: filepath.Join(dir, "transfers.db")}, nil
+}
+
+// Path returns the location of the journal
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Records returns the last state of every transfer, oldest first. Transfers
+// whose process ended before they did are StateInterrupted.
+func (j *Journal) Records() ([]Record, error) {
+	records, err := j.read()
+	if err != nil {
+		return nil, err
+	}
+	for i, r := range records {
+		if !r.Finished() && r.PID != os.Getpid() && !processAlive(r.PID) {
+			records[i].State = StateInterrupted
+		}
+	}
+	return records, nil
+}
+
+// Find returns the last state of one transfer
+func (j *Journal) Find(id string) (Record, error) {
+	records, err := j.Records()
+	if err != nil {
+		return Record{}, err
+	}
+	for _, r := range records {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return Record{}, fmt.Errorf("%w %q", ErrNotFound, id)
+}
+
+// Put appends a record, replacing any earlier one with the same ID
+func (j *Journal) Put(r Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	file, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open the transfer journal: %w", err)
+	}
+	_, err = file.Write(append(line, '\n'))
+	info, statErr := file.Stat()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write the transfer journal: %w", err)
+	}
+
+	if statErr == nil && info.Size() > maxSize {
+		return j.compact(func(records []Record) []Record { return records })
+	}
+	return nil
+}
+
+// Moved records that the local file of a finished download was moved, e.g.
+// from its .orb-partial file into place
+func (j *Journal) Moved(from, to string) error {
+	records, err := j.read()
+	if err != nil {
+		return err
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Local == from {
+			r := records[i]
+			r.Local = to
+			r.Updated = time.Now()
+			return j.Put(r)
+		}
+	}
+	return nil
+}
+
+// Clear forgets finished and interrupted transfers and returns how many
+func (j *Journal) Clear() (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	cleared := 0
+	err := j.compact(func(records []Record) []Record {
+		kept := records[:0]
+		for _, r := range records {
+			if r.Finished() || (r.PID != os.Getpid() && !processAlive(r.PID)) {
+				cleared++
+				continue
+			}
+			kept = append(kept, r)
+		}
+		return kept
+	})
+	return cleared, err
+}
+
+// read loads the log and keeps the newest line of each transfer, ordered by
+// start. Lines that cannot be parsed, e.g. cut short by a crash, are skipped.
+func (j *Journal) read() ([]Record, error) {
+	data, err := os.ReadFile(j.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the transfer journal: %w", err)
+	}
+
+	latest := make(map[string]Record)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil || r.ID == "" {
+			continue
+		}
+		latest[r.ID] = r
+	}
+
+	records := make([]Record, 0, len(latest))
+	for _, r := range latest {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(a, b int) bool {
+		if !records[a].Started.Equal(records[b].Started) {
+			return records[a].Started.Before(records[b].Started)
+		}
+		return records[a].ID < records[b].ID
+	})
+	return records, nil
+}
+
+// compact rewrites the log with one line per transfer that filter keeps,
+// dropping the oldest finished transfers beyond keepFinished. Caller must
+// hold j.mu.
+func (j *Journal) compact(filter func([]Record) []Record) error {
+	records, err := j.read()
+	if err != nil {
+		return err
+	}
+	records = filter(records)
+
+	finished := 0
+	for _, r := range records {
+		if r.Finished() {
+			finished++
+		}
+	}
+	var buf bytes.Buffer
+	for _, r := range records {
+		if r.Finished() && finished > keepFinished {
+			finished--
+			continue
+		}
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+
+	temp := j.path + ".tmp"
+	if err := os.WriteFile(temp, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write the transfer journal: %w", err)
+	}
+	if err := os.Rename(temp, j.path); err != nil {
+		_ = os.Remove(temp)
+		return fmt.Errorf("failed to write the transfer journal: %w", err)
+	}
+	return nil
+}
+
+// newID returns a short random transfer ID that is easy to type
+func newID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,79 @@
+package journal
+
+import (
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+)
+
+// Observer returns a transfer.Manager observer that records the transfers of
+// the manager, which belong to the given session. Failing writes are logged
+// and otherwise ignored, the journal must not stop a transfer.
+func (j *Journal) Observer(sessionID string) func(transfer.Transfer) {
+	o := &observer{journal: j, sessionID: sessionID, records: make(map[int]*Record)}
+	return o.observe
+}
+
+// ObserveAs is Observer for a manager that runs a single transfer, which
+// continues rec, e.g. once it is resumed. The transfer keeps the ID of rec.
+func (j *Journal) ObserveAs(rec Record) func(transfer.Transfer) {
+	o := &observer{journal: j, sessionID: rec.SessionID, records: make(map[int]*Record), resumed: &rec}
+	return o.observe
+}
+
+type observer struct {
+	journal   *Journal
+	sessionID string
+	resumed   *Record // continued by the first transfer, see ObserveAs
+	mu        sync.Mutex
+	records   map[int]*Record // by manager transfer ID
+}
+
+func (o *observer) observe(t transfer.Transfer) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	r, ok := o.records[t.ID]
+	if !ok {
+		if o.resumed != nil {
+			r, o.resumed = o.resumed, nil
+		} else {
+			r = &Record{ID: newID(), SessionID: o.sessionID, Started: time.Now()}
+		}
+		o.records[t.ID] = r
+	}
+
+	// Progress is written now and then, every change of state at once
+	state := t.State.String()
+	now := time.Now()
+	if ok && state == r.State && now.Sub(r.Updated) < progressInterval {
+		return
+	}
+
+	r.Direction = t.Direction.String()
+	r.Remote = t.RemotePath
+	r.Local = t.LocalPath
+	r.Size = t.Size
+	r.Transferred = t.Transferred
+	r.State = state
+	r.Error = ""
+	if t.Err != nil {
+		r.Error = t.Err.Error()
+	}
+	if t.SHA256 != nil {
+		r.SHA256 = hex.EncodeToString(t.SHA256)
+	}
+	r.PID = os.Getpid()
+	r.Updated = now
+
+	if err := o.journal.Put(*r); err != nil {
+		slog.Debug("failed to record transfer", "id", r.ID, "err", err)
+	}
+	if t.State.Finished() {
+		delete(o.records, t.ID)
+	}
+}
@@ -0,0 +1,122 @@
+// Package logging configures the structured logger shared by all commands.
+//
+// Log records must never contain passcodes, keys or file contents; session
+// IDs, paths, sizes and errors are fine.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Options selects what is logged and where
+type Options struct {
+	// Level is "debug", "info", "warn" or "error". When empty, Verbose
+	// selects debug, Quiet selects error and the default is info.
+	Level   string
+	Verbose bool
+	Quiet   bool
+
+	// File receives the log instead of stderr when set. It is appended to.
+	File string
+
+	// JSON writes records as JSON objects instead of key=value text
+	JSON bool
+}
+
+// level is shared by every handler, so that a redirected logger keeps the
+// configured verbosity
+var level = new(slog.LevelVar)
+
+// file is the open log file, if any
+var file *os.File
+
+// Setup installs the default logger described by opts
+func Setup(opts Options) error {
+	lvl, err := parseLevel(opts)
+	if err != nil {
+		return err
+	}
+	level.Set(lvl)
+
+	var out io.Writer = os.Stderr
+	if opts.File != "" {
+		// #nosec G304 -- the log file is chosen by the user
+		f, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		Close()
+		file = f
+		out = f
+	}
+
+	var handler slog.Handler
+	if opts.JSON {
+		handler = slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level})
+	} else {
+		handler = slog.NewTextHandler(out, &slog.HandlerOptions{Level: level})
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// parseLevel picks the level from the options
+func parseLevel(opts Options) (slog.Level, error) {
+	if opts.Verbose && opts.Quiet {
+		return 0, fmt.Errorf("--verbose and --quiet cannot be combined")
+	}
+
+	switch strings.ToLower(opts.Level) {
+	case "":
+		switch {
+		case opts.Verbose:
+			return slog.LevelDebug, nil
+		case opts.Quiet:
+			return slog.LevelError, nil
+		}
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", opts.Level)
+}
+
+// Redirect sends log records to w, e.g. while a full-screen view would be
+// torn apart by them, and returns a function restoring the previous logger.
+// A log file set up with Setup keeps receiving them instead.
+func Redirect(w io.Writer) func() {
+	if file != nil {
+		return func() {}
+	}
+
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			// The view shows its own timestamps
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})))
+	return func() { slog.SetDefault(prev) }
+}
+
+// Close closes the log file, if one is open
+func Close() {
+	if file != nil {
+		_ = file.Close()
+		file = nil
+	}
+}
@@ -0,0 +1,88 @@
+package manifest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+)
+
+// IdentityPath returns where the identity key of this machine is kept,
+// identity.key next to config.yaml
+func IdentityPath() (string, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "identity.key"), nil
+}
+
+// LoadIdentity reads the Ed25519 key manifests are signed with, creating it
+// on first use. The key stays the same across sessions, so that receivers
+// can recognize the sharer by its fingerprint.
+func LoadIdentity() (ed25519.PrivateKey, error) {
+	keyPath, err := IdentityPath()
+	if err != nil {
+		return nil, err
+	}
+	// #nosec G304 -- the path is under the config directory
+	data, err := os.ReadFile(keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return createIdentity(keyPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM key", keyPath)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", keyPath, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 key", keyPath)
+	}
+	return key, nil
+}
+
+func createIdentity(keyPath string) (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate identity key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(keyPath), err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	// O_EXCL keeps the key of a process that created it at the same time
+	f, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return LoadIdentity()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write identity key: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(keyPath)
+		return nil, fmt.Errorf("failed to write identity key: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write identity key: %w", err)
+	}
+	return key, nil
+}
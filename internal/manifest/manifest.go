@@ -0,0 +1,203 @@
+// Package manifest lists the files of a share with their sizes,
+// modification times and SHA-256 checksums, signed with the Ed25519
+// identity key of the sharer. A receiver that keeps the signed manifest can
+// prove later, without the sharer, which files it got and that they were
+// not changed in transit or since.
+//
+// A signed manifest is a JSON document holding the manifest itself, the
+// public key of the sharer and the signature. The signature covers the
+// compact JSON encoding of the manifest, so the file can be reformatted
+// without breaking it.
+package manifest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Version is the manifest format this build writes and reads
+const Version = 1
+
+// ErrBadSignature is returned for a manifest that was changed after it was
+// signed, or signed by another key than the one it names
+var ErrBadSignature = errors.New("the manifest signature does not match, it was changed or is not from that sharer")
+
+// Entry is a file of the share
+type Entry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	SHA256  string    `json:"sha256"`
+}
+
+// Manifest lists the files of a session, sorted by path
+type Manifest struct {
+	Version   int       `json:"version"`
+	SessionID string    `json:"session_id"`
+	Created   time.Time `json:"created"`
+	Files     []Entry   `json:"files"`
+}
+
+// Signed is a manifest with its signature, as sent to receivers and saved
+type Signed struct {
+	Manifest  json.RawMessage `json:"manifest"`
+	PublicKey []byte          `json:"public_key"`
+	Signature []byte          `json:"signature"`
+}
+
+// Sign encodes m signed with key
+func Sign(m *Manifest, key ed25519.PrivateKey) ([]byte, error) {
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+	body, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(Signed{
+		Manifest:  body,
+		PublicKey: key.Public().(ed25519.PublicKey),
+		Signature: ed25519.Sign(key, body),
+	}, "", "  ")
+}
+
+// Open checks the signature of a signed manifest and returns the manifest
+// with the key that signed it. Whether that key is the expected one is up
+// to the caller.
+func Open(data []byte) (*Manifest, ed25519.PublicKey, error) {
+	var s Signed
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, nil, fmt.Errorf("not a signed manifest: %w", err)
+	}
+	if len(s.PublicKey) != ed25519.PublicKeySize || len(s.Manifest) == 0 {
+		return nil, nil, errors.New("not a signed manifest: the key or the manifest is missing")
+	}
+	var body bytes.Buffer
+	if err := json.Compact(&body, s.Manifest); err != nil {
+		return nil, nil, fmt.Errorf("not a signed manifest: %w", err)
+	}
+	pub := ed25519.PublicKey(s.PublicKey)
+	if !ed25519.Verify(pub, body.Bytes(), s.Signature) {
+		return nil, nil, ErrBadSignature
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(body.Bytes(), &m); err != nil {
+		return nil, nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if m.Version != Version {
+		return nil, nil, fmt.Errorf("manifest version %d is not supported, this orb reads version %d", m.Version, Version)
+	}
+	return &m, pub, nil
+}
+
+// Fingerprint identifies a public key the way SSH does, e.g.
+// SHA256:uN2hD4...
+func Fingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// Find returns the entry of a file
+func (m *Manifest) Find(p string) (Entry, bool) {
+	p = path.Clean("/" + p)
+	i := sort.Search(len(m.Files), func(i int) bool { return m.Files[i].Path >= p })
+	if i < len(m.Files) && m.Files[i].Path == p {
+		return m.Files[i], true
+	}
+	return Entry{}, false
+}
+
+// Under returns the entries of the file or directory remote, keyed by their
+// path relative to it. A file is keyed by ".".
+func (m *Manifest) Under(remote string) map[string]Entry {
+	remote = path.Clean("/" + remote)
+	entries := make(map[string]Entry)
+	if e, ok := m.Find(remote); ok {
+		entries["."] = e
+		return entries
+	}
+	prefix := strings.TrimSuffix(remote, "/") + "/"
+	for _, e := range m.Files {
+		if strings.HasPrefix(e.Path, prefix) {
+			entries[strings.TrimPrefix(e.Path, prefix)] = e
+		}
+	}
+	return entries
+}
+
+// Problem is a local file that does not match its entry
+type Problem struct {
+	Path   string `json:"path"`
+	Local  string `json:"local"`
+	Reason string `json:"reason"`
+}
+
+// Check compares the copies under local of the files under remote with
+// their entries, and returns the number of files checked and those that
+// differ. Local files the manifest does not list are ignored. When remote
+// is a file, local is its copy.
+func Check(m *Manifest, remote, local string) (int, []Problem, error) {
+	entries := m.Under(remote)
+	if len(entries) == 0 {
+		return 0, nil, fmt.Errorf("the manifest lists no files under %s", path.Clean("/"+remote))
+	}
+	rels := make([]string, 0, len(entries))
+	for rel := range entries {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+
+	var problems []Problem
+	for _, rel := range rels {
+		e := entries[rel]
+		file := filepath.Join(local, filepath.FromSlash(rel))
+		if reason := checkFile(e, file); reason != "" {
+			problems = append(problems, Problem{Path: e.Path, Local: file, Reason: reason})
+		}
+	}
+	return len(rels), problems, nil
+}
+
+// checkFile describes how file differs from e, or returns an empty string
+func checkFile(e Entry, file string) string {
+	// #nosec G304 -- the user names the files to check
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return "missing"
+	}
+	if err != nil {
+		return err.Error()
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err.Error()
+	}
+	if info.IsDir() {
+		return "is a directory"
+	}
+	if info.Size() != e.Size {
+		return fmt.Sprintf("size %d, the manifest says %d", info.Size(), e.Size)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err.Error()
+	}
+	if hex.EncodeToString(h.Sum(nil)) != e.SHA256 {
+		return "checksum differs"
+	}
+	return ""
+}
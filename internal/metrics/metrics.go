@@ -0,0 +1,281 @@
+// Package metrics keeps the counters orb exposes to Prometheus with
+// --metrics-addr. Metrics are process wide and cheap enough to update
+// whether or not anything scrapes them.
+//
+// Like the log, metrics never carry paths, passcodes or file contents;
+// labels are limited to small fixed sets such as a request type.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metric is anything the registry can write out
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []metric
+)
+
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, other := range registry {
+		if other.name() == m.name() {
+			panic("metrics: " + m.name() + " registered twice")
+		}
+	}
+	registry = append(registry, m)
+}
+
+// family is what all kinds of metric share: a name, help and label names,
+// and the series seen so far keyed by their label values
+type family struct {
+	fullName string
+	help     string
+	kind     string
+	labels   []string
+
+	mu     sync.Mutex
+	series map[string][]string // key -> label values
+}
+
+func newFamily(name, help, kind string, labels []string) *family {
+	return &family{fullName: name, help: help, kind: kind, labels: labels, series: make(map[string][]string)}
+}
+
+func (f *family) name() string {
+	return f.fullName
+}
+
+// key returns the series key of the label values, registering the series.
+// f.mu must be held.
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.fullName, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	if _, ok := f.series[key]; !ok {
+		f.series[key] = slices.Clone(values)
+	}
+	return key
+}
+
+// keys returns the series keys in a stable order. f.mu must be held.
+func (f *family) keys() []string {
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// header writes the HELP and TYPE lines
+func (f *family) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.fullName, f.help, f.fullName, f.kind)
+}
+
+// labelString formats label pairs, with extra ones such as le appended
+func (f *family) labelString(values []string, extra ...string) string {
+	var pairs []string
+	for i, l := range f.labels {
+		pairs = append(pairs, l+`="`+escape(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escape(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case v == math.Trunc(v) && math.Abs(v) < 1e15:
+		// Byte counts read better without an exponent
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter is a value that only goes up, per combination of label values
+type Counter struct {
+	*family
+	values map[string]float64
+}
+
+// NewCounter registers a counter with the given label names
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{family: newFamily(name, help, "counter", labels), values: make(map[string]float64)}
+	if len(labels) == 0 {
+		// Without labels the single series exists from the start, at 0
+		c.values[c.key(nil)] = 0
+	}
+	register(c)
+	return c
+}
+
+// Add increases the counter of the label values by v
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[c.key(labelValues)] += v
+}
+
+// Inc increases the counter of the label values by one
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, k := range c.keys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.fullName, c.labelString(c.series[k]), formatFloat(c.values[k]))
+	}
+}
+
+// Gauge is a value that goes up and down, per combination of label values
+type Gauge struct {
+	*family
+	values map[string]float64
+}
+
+// NewGauge registers a gauge with the given label names
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{family: newFamily(name, help, "gauge", labels), values: make(map[string]float64)}
+	if len(labels) == 0 {
+		g.values[g.key(nil)] = 0
+	}
+	register(g)
+	return g
+}
+
+// Add changes the gauge of the label values by v, which may be negative
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[g.key(labelValues)] += v
+}
+
+// Set sets the gauge of the label values to v
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[g.key(labelValues)] = v
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.header(w)
+	for _, k := range g.keys() {
+		fmt.Fprintf(w, "%s%s %s\n", g.fullName, g.labelString(g.series[k]), formatFloat(g.values[k]))
+	}
+}
+
+// Histogram counts observations into buckets, per combination of label
+// values
+type Histogram struct {
+	*family
+	buckets []float64 // upper bounds, ascending
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds
+// and label names
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		family:  newFamily(name, help, "histogram", labels),
+		buckets: slices.Sorted(slices.Values(buckets)),
+		values:  make(map[string]*histogramValue),
+	}
+	register(h)
+	return h
+}
+
+// Observe records v for the label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := h.key(labelValues)
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+	}
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		hv.counts[i]++
+	}
+	hv.count++
+	hv.sum += v
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, k := range h.keys() {
+		values, hv := h.series[k], h.values[k]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += hv.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.fullName, h.labelString(values, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.fullName, h.labelString(values, "le", "+Inf"), hv.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.fullName, h.labelString(values), formatFloat(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.fullName, h.labelString(values), hv.count)
+	}
+}
+
+// LatencyBuckets suit round trips through a relay, in seconds
+var LatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Write writes every metric in the Prometheus text format
+func Write(w io.Writer) {
+	registryMu.Lock()
+	metrics := slices.Clone(registry)
+	registryMu.Unlock()
+
+	slices.SortFunc(metrics, func(a, b metric) int { return strings.Compare(a.name(), b.name()) })
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the metrics to Prometheus
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w)
+	})
+}
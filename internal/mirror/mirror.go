@@ -0,0 +1,477 @@
+// Package mirror makes one directory tree match another across the tunnel,
+// copying only what changed
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/filesystem"
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// dirPerm is used for directories created while mirroring
+const dirPerm = 0755
+
+// rollbackTimeout bounds dropping the transaction of a push that failed
+const rollbackTimeout = 10 * time.Second
+
+// Direction tells which side is the source
+type Direction int
+
+const (
+	// Pull makes the local directory match the remote one
+	Pull Direction = iota
+	// Push makes the remote directory match the local one
+	Push
+)
+
+// Options control how the trees are compared
+type Options struct {
+	// Delete removes entries that exist only at the destination
+	Delete bool
+	// Checksum compares file contents instead of modification times
+	Checksum bool
+	// Verify checks every copied file against the sharer's checksum
+	Verify bool
+	// WholeFile copies changed files in full instead of only the blocks
+	// that differ from the destination's copy
+	WholeFile bool
+	// Atomic stages the changes of a push in a transaction on the sharer,
+	// which makes all of them once every copy is complete, or none
+	Atomic bool
+	// Progress, when set, is called with each copy as it advances and once
+	// more when it is complete
+	Progress func(transfer.Transfer)
+	// Observer, when set, is passed to the transfer manager of the copies,
+	// see transfer.Manager.SetObserver
+	Observer func(transfer.Transfer)
+}
+
+// ActionKind is what has to happen to one path
+type ActionKind int
+
+const (
+	Copy ActionKind = iota
+	Mkdir
+	Delete
+)
+
+func (k ActionKind) String() string {
+	switch k {
+	case Copy:
+		return "copy"
+	case Mkdir:
+		return "mkdir"
+	case Delete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+// Action is one step of a plan. Path is relative to the mirrored
+// directories and always uses forward slashes.
+type Action struct {
+	Kind    ActionKind
+	Path    string
+	Size    int64
+	ModTime time.Time // source modification time of copied files
+}
+
+// entry is a file or directory found while walking one side
+type entry struct {
+	size    int64
+	modTime time.Time
+	isDir   bool
+	symlink bool // local symlinks are replaced, never written through
+}
+
+// Mirror compares and updates a remote directory and a local directory
+type Mirror struct {
+	client    *remote.Client
+	direction Direction
+	remoteDir string
+	localDir  string
+	opts      Options
+
+	// localNames maps the paths found by walkLocal, as they are sent, to
+	// those of the files on disk, which may be in another normalization form
+	localNames map[string]string
+}
+
+// New creates a mirror between remoteDir on the sharer and localDir
+func New(client *remote.Client, direction Direction, remoteDir, localDir string, opts Options) *Mirror {
+	return &Mirror{
+		client:    client,
+		direction: direction,
+		remoteDir: path.Join("/", remoteDir),
+		localDir:  localDir,
+		opts:      opts,
+	}
+}
+
+// Plan lists the actions that make the destination match the source.
+// Deletions come first, then directories parents-first, then copies.
+func (m *Mirror) Plan(ctx context.Context) ([]Action, error) {
+	remoteEntries, err := m.walkRemote(ctx)
+	if err != nil {
+		return nil, err
+	}
+	localEntries, err := m.walkLocal()
+	if err != nil {
+		return nil, err
+	}
+
+	src, dst := remoteEntries, localEntries
+	if m.direction == Push {
+		src, dst = localEntries, remoteEntries
+	}
+
+	var deletes, mkdirs, copies []Action
+	for _, p := range sortedPaths(src) {
+		s := src[p]
+		d, exists := dst[p]
+
+		// A file replaced by a directory or the other way around
+		if exists && (d.isDir != s.isDir || d.symlink) {
+			deletes = append(deletes, Action{Kind: Delete, Path: p})
+			exists = false
+		}
+
+		switch {
+		case s.isDir && !exists:
+			mkdirs = append(mkdirs, Action{Kind: Mkdir, Path: p})
+		case s.isDir:
+			// Already there
+		default:
+			changed := !exists
+			if exists {
+				changed, err = m.changed(ctx, p, s, d)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if changed {
+				copies = append(copies, Action{Kind: Copy, Path: p, Size: s.size, ModTime: s.modTime})
+			}
+		}
+	}
+
+	if m.opts.Delete {
+		for _, p := range sortedPaths(dst) {
+			if _, ok := src[p]; ok || underAny(p, deletes) {
+				continue
+			}
+			deletes = append(deletes, Action{Kind: Delete, Path: p})
+		}
+	}
+
+	return append(append(deletes, mkdirs...), copies...), nil
+}
+
+// changed reports whether the destination copy of a file differs from the source
+func (m *Mirror) changed(ctx context.Context, p string, src, dst entry) (bool, error) {
+	if src.size != dst.size {
+		return true, nil
+	}
+
+	if m.opts.Checksum {
+		remoteSum, err := m.client.Hash(ctx, m.remotePath(p))
+		if err != nil {
+			return false, fmt.Errorf("failed to checksum %s: %w", p, err)
+		}
+		localSum, err := hashFile(m.localPath(p))
+		if err != nil {
+			return false, fmt.Errorf("failed to checksum %s: %w", p, err)
+		}
+		return !bytes.Equal(remoteSum, localSum), nil
+	}
+
+	// Pulled files get the remote modification time, so they match exactly.
+	// Pushed files cannot be stamped on the sharer and are only ever newer.
+	if m.direction == Pull {
+		return !src.modTime.Equal(dst.modTime), nil
+	}
+	return src.modTime.After(dst.modTime), nil
+}
+
+// Apply carries out a plan. Copies run on a transfer manager with the given
+// concurrency; done is called as each one finishes. Failed copies do not stop
+// the others, their count is returned as the error. With Options.Atomic, a
+// push that fails in any way makes no changes at all.
+func (m *Mirror) Apply(ctx context.Context, actions []Action, concurrency int, done func(transfer.Transfer)) error {
+	if !m.opts.Atomic || m.direction != Push || len(actions) == 0 {
+		return m.apply(ctx, actions, concurrency, done)
+	}
+
+	tx, err := m.client.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin a transaction: %w", err)
+	}
+	staged := *m
+	staged.client = tx
+	if err := staged.apply(ctx, actions, concurrency, done); err != nil {
+		// The sharer also rolls back once the tunnel is gone
+		rollback, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+		defer cancel()
+		_ = tx.Rollback(rollback)
+		return fmt.Errorf("%w, none of the changes were made", err)
+	}
+	if _, err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit the changes: %w", err)
+	}
+	return nil
+}
+
+// apply carries out a plan
+func (m *Mirror) apply(ctx context.Context, actions []Action, concurrency int, done func(transfer.Transfer)) error {
+	if len(actions) > 0 {
+		if err := m.mkdir(ctx, ""); err != nil {
+			return fmt.Errorf("failed to create the destination directory: %w", err)
+		}
+	}
+
+	modTimes := make(map[string]time.Time)
+	manager := transfer.NewManager(m.client, concurrency)
+	manager.SetVerify(m.opts.Verify)
+	manager.SetDelta(!m.opts.WholeFile)
+	manager.SetObserver(m.opts.Observer)
+	for _, a := range actions {
+		var err error
+		switch a.Kind {
+		case Delete:
+			err = m.delete(ctx, a.Path)
+		case Mkdir:
+			err = m.mkdir(ctx, a.Path)
+		case Copy:
+			dir := transfer.Download
+			if m.direction == Push {
+				dir = transfer.Upload
+			}
+			manager.Enqueue(dir, m.remotePath(a.Path), m.localPath(a.Path), a.Size)
+			modTimes[m.localPath(a.Path)] = a.ModTime
+		}
+		if err != nil {
+			manager.CancelAll()
+			return fmt.Errorf("failed to %s %s: %w", a.Kind, a.Path, err)
+		}
+	}
+
+	failed, err := wait(ctx, manager, m.opts.Progress, func(t *transfer.Transfer) {
+		// Stamp pulled files so the next run sees them as unchanged
+		if t.Direction == transfer.Download && t.State == transfer.StateDone {
+			modTime := modTimes[t.LocalPath]
+			if err := os.Chtimes(t.LocalPath, modTime, modTime); err != nil {
+				t.State = transfer.StateFailed
+				t.Err = err
+			}
+		}
+		if done != nil {
+			done(*t)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed to copy", failed, len(modTimes))
+	}
+	return nil
+}
+
+// wait blocks until every transfer has finished, passing each one to finish
+// once and, as it advances, to progress if set. It returns how many did not
+// complete.
+func wait(ctx context.Context, manager *transfer.Manager, progress func(transfer.Transfer), finish func(*transfer.Transfer)) (int, error) {
+	reported := make(map[int]bool)
+	failed := 0
+	for {
+		pending := 0
+		for _, t := range manager.Snapshot() {
+			if !t.State.Finished() {
+				pending++
+				if progress != nil && t.State == transfer.StateRunning {
+					progress(t)
+				}
+				continue
+			}
+			if reported[t.ID] {
+				continue
+			}
+			reported[t.ID] = true
+			if progress != nil && t.State == transfer.StateDone {
+				progress(t)
+			}
+			finish(&t)
+			if t.State != transfer.StateDone {
+				failed++
+			}
+		}
+		if pending == 0 {
+			return failed, nil
+		}
+
+		select {
+		case <-manager.Updates():
+		case <-ctx.Done():
+			manager.CancelAll()
+			return failed, ctx.Err()
+		}
+	}
+}
+
+func (m *Mirror) delete(ctx context.Context, p string) error {
+	if m.direction == Push {
+		return m.client.Delete(ctx, m.remotePath(p))
+	}
+	return os.RemoveAll(m.localPath(p))
+}
+
+func (m *Mirror) mkdir(ctx context.Context, p string) error {
+	if m.direction == Push {
+		return m.client.Mkdir(ctx, m.remotePath(p), dirPerm)
+	}
+	return os.MkdirAll(m.localPath(p), dirPerm)
+}
+
+// walkRemote lists the remote tree. A missing remote directory is empty
+// when pushing; Apply creates it.
+func (m *Mirror) walkRemote(ctx context.Context) (map[string]entry, error) {
+	entries := make(map[string]entry)
+	if m.direction == Push {
+		if _, err := m.client.Stat(ctx, m.remoteDir); err != nil {
+			return entries, nil
+		}
+	}
+
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		files, err := m.client.List(ctx, m.remotePath(rel))
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", m.remotePath(rel), err)
+		}
+		for _, f := range files {
+			// Older sharers send names in the form they are stored in
+			p := path.Join(rel, protocol.NormalizeName(f.Name))
+			entries[p] = entry{size: f.Size, modTime: time.Unix(f.ModTime, 0), isDir: f.IsDir}
+			if f.IsDir {
+				if err := walk(p); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return entries, walk("")
+}
+
+// walkLocal lists the local tree. Symlinks are not pushed, as the sharer
+// does not follow them either. A missing local directory is empty when pulling.
+func (m *Mirror) walkLocal() (map[string]entry, error) {
+	entries := make(map[string]entry)
+	names := make(map[string]string)
+	m.localNames = names
+	if _, err := os.Stat(m.localDir); os.IsNotExist(err) && m.direction == Pull {
+		return entries, nil
+	}
+
+	err := filepath.WalkDir(m.localDir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == m.localDir {
+			return nil
+		}
+		symlink := d.Type()&os.ModeSymlink != 0
+		if symlink && m.direction == Push {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(m.localDir, p)
+		if err != nil {
+			return err
+		}
+		key := filesystem.WirePath(filepath.ToSlash(rel))
+		names[key] = rel
+		entries[key] = entry{
+			size:    info.Size(),
+			modTime: info.ModTime().Truncate(time.Second),
+			isDir:   d.IsDir(),
+			symlink: symlink,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", m.localDir, err)
+	}
+	return entries, nil
+}
+
+func (m *Mirror) remotePath(rel string) string {
+	return path.Join(m.remoteDir, rel)
+}
+
+// localPath returns where a path lies locally: the file walkLocal found
+// for it, or a name this machine can store below the parent's
+func (m *Mirror) localPath(rel string) string {
+	if local, ok := m.localNames[rel]; ok {
+		return filepath.Join(m.localDir, local)
+	}
+	dir := m.localDir
+	if parent := path.Dir(rel); parent != "." {
+		dir = m.localPath(parent)
+	}
+	return filepath.Join(dir, filesystem.LocalName(path.Base(rel)))
+}
+
+// sortedPaths returns the keys in lexical order, which puts parents before children
+func sortedPaths(entries map[string]entry) []string {
+	paths := make([]string, 0, len(entries))
+	for p := range entries {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// underAny reports whether p is inside a directory that is already being deleted
+func underAny(p string, deletes []Action) bool {
+	for _, a := range deletes {
+		if p == a.Path || strings.HasPrefix(p, a.Path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// hashFile returns the SHA-256 checksum of a local file
+func hashFile(name string) ([]byte, error) {
+	// #nosec G304 -- local paths are chosen by the user running orb
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
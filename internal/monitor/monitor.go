@@ -0,0 +1,230 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxOps is the number of recent operations kept for display
+	maxOps = 200
+
+	// coalesceWindow is how far back chunk requests are merged into an
+	// earlier entry for the same file
+	coalesceWindow = 16
+)
+
+// Op is a request served to a peer
+type Op struct {
+	Time  time.Time
+	Peer  int
+	Kind  string // "list", "read", "write", ...
+	Path  string
+	Bytes int64 // file data moved, summed over the chunk requests for one file
+	Err   string
+}
+
+// Peer is a receiver connected to the share
+type Peer struct {
+	ID            int
+	Name          string
+	Connected     time.Time
+	Requests      int
+	BytesSent     int64 // wire bytes sent to the peer
+	BytesReceived int64 // wire bytes received from the peer
+}
+
+// peer is the monitor-owned state behind a Peer
+type peer struct {
+	Peer
+	disconnect func()
+}
+
+// Monitor collects what a sharer is serving so it can be shown live.
+// It is safe for concurrent use by the request handlers and the dashboard.
+type Monitor struct {
+	mu      sync.Mutex
+	peers   []*peer
+	ops     []Op
+	nextID  int
+	paused  bool
+	err     error
+	updates chan struct{}
+}
+
+// New creates an empty monitor
+func New() *Monitor {
+	return &Monitor{
+		updates: make(chan struct{}, 1),
+	}
+}
+
+// Updates returns a channel that receives a value whenever anything changes.
+// Notifications are coalesced, so receivers should read the state afterwards.
+func (m *Monitor) Updates() <-chan struct{} {
+	return m.updates
+}
+
+// notify signals listeners without blocking. Caller must hold m.mu.
+func (m *Monitor) notify() {
+	select {
+	case m.updates <- struct{}{}:
+	default:
+	}
+}
+
+// AddPeer registers a connected peer. disconnect is called to revoke its access.
+func (m *Monitor) AddPeer(name string, disconnect func()) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	m.peers = append(m.peers, &peer{
+		Peer: Peer{
+			ID:        m.nextID,
+			Name:      name,
+			Connected: time.Now(),
+		},
+		disconnect: disconnect,
+	})
+	m.notify()
+	return m.nextID
+}
+
+// RemovePeer forgets a peer that has disconnected
+func (m *Monitor) RemovePeer(id int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, p := range m.peers {
+		if p.ID == id {
+			m.peers = append(m.peers[:i], m.peers[i+1:]...)
+			break
+		}
+	}
+	m.notify()
+}
+
+// Revoke disconnects a peer
+func (m *Monitor) Revoke(id int) error {
+	m.mu.Lock()
+	var disconnect func()
+	for _, p := range m.peers {
+		if p.ID == id {
+			disconnect = p.disconnect
+		}
+	}
+	m.mu.Unlock()
+
+	if disconnect == nil {
+		return fmt.Errorf("peer %d not found", id)
+	}
+	disconnect()
+	return nil
+}
+
+// RevokeAll disconnects every peer
+func (m *Monitor) RevokeAll() {
+	for _, p := range m.Peers() {
+		_ = m.Revoke(p.ID)
+	}
+}
+
+// Record adds a served request to the activity stream. sent and received are
+// the wire sizes of the response and request.
+func (m *Monitor) Record(op Op, sent, received int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if op.Time.IsZero() {
+		op.Time = time.Now()
+	}
+
+	for _, p := range m.peers {
+		if p.ID == op.Peer {
+			p.Requests++
+			p.BytesSent += sent
+			p.BytesReceived += received
+		}
+	}
+
+	// A file transfer is many chunk requests, interleaved with those of
+	// other transfers; show it as one entry that moves to the top
+	if op.Err == "" && (op.Kind == "read" || op.Kind == "write") {
+		for i := len(m.ops) - 1; i >= 0 && i >= len(m.ops)-coalesceWindow; i-- {
+			prev := m.ops[i]
+			if prev.Peer == op.Peer && prev.Kind == op.Kind && prev.Path == op.Path && prev.Err == "" {
+				op.Bytes += prev.Bytes
+				m.ops = append(m.ops[:i], m.ops[i+1:]...)
+				break
+			}
+		}
+	}
+
+	m.ops = append(m.ops, op)
+	if len(m.ops) > maxOps {
+		m.ops = append([]Op(nil), m.ops[len(m.ops)-maxOps:]...)
+	}
+	m.notify()
+}
+
+// Write records a log line in the activity stream, so the standard logger can
+// be pointed at the monitor while the dashboard owns the terminal
+func (m *Monitor) Write(p []byte) (int, error) {
+	m.Record(Op{Kind: "log", Err: strings.TrimSpace(string(p))}, 0, 0)
+	return len(p), nil
+}
+
+// Peers returns a copy of the connected peers
+func (m *Monitor) Peers() []Peer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	peers := make([]Peer, len(m.peers))
+	for i, p := range m.peers {
+		peers[i] = p.Peer
+	}
+	return peers
+}
+
+// Ops returns a copy of the recent operations, oldest first
+func (m *Monitor) Ops() []Op {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Op(nil), m.ops...)
+}
+
+// SetPaused stops or resumes serving requests
+func (m *Monitor) SetPaused(paused bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.paused = paused
+	m.notify()
+}
+
+// Paused reports whether requests should be refused
+func (m *Monitor) Paused() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.paused
+}
+
+// Fail records an error that ended sharing, e.g. a failed handshake
+func (m *Monitor) Fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.err = err
+	m.notify()
+}
+
+// Err returns the error that ended sharing, if any
+func (m *Monitor) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
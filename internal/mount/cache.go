@@ -0,0 +1,232 @@
+package mount
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Zayan-Mohamed/orb/internal/metrics"
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// cacheReads counts the chunks looked up in caches, see --metrics-addr
+var cacheReads = metrics.NewCounter("orb_cache_reads_total", "Chunks of files looked up in the read cache, by result", "result")
+
+// Cache keeps the chunks of remote files read through a mount or local
+// server, so that reading a file again does not fetch it again. Chunks are
+// kept under the path and the size and modification time of their file,
+// so a file changed by the sharer is fetched anew once its attributes are;
+// changes made through the mount drop its chunks right away. The least
+// recently used chunks go first once the cache is full.
+//
+// A nil Cache caches nothing. It is safe for concurrent use.
+type Cache struct {
+	dir string // chunks are kept in memory when empty
+	max int64
+
+	mu      sync.Mutex
+	used    int64
+	lru     *list.List // of *cacheEntry, most recently used first
+	chunks  map[chunkKey]*list.Element
+	pending map[chunkKey]chan struct{} // chunks being read, closed once done
+}
+
+// chunkKey names a chunk of one version of a file
+type chunkKey struct {
+	path    string
+	modTime int64
+	size    int64
+	offset  int64
+}
+
+type cacheEntry struct {
+	key  chunkKey
+	data []byte // nil when kept on disk
+	size int64
+}
+
+// NewCache returns a cache of up to size bytes, kept in memory, or on disk
+// in a directory of its own below dir when dir is given. Close removes it.
+func NewCache(size int64, dir string) (*Cache, error) {
+	c := &Cache{
+		max:     size,
+		lru:     list.New(),
+		chunks:  make(map[chunkKey]*list.Element),
+		pending: make(map[chunkKey]chan struct{}),
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+		// The chunks are plaintext, only this run may read them
+		own, err := os.MkdirTemp(dir, "orb-cache-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+		c.dir = own
+	}
+	return c, nil
+}
+
+// Close empties the cache and removes its directory
+func (c *Cache) Close() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.chunks = make(map[chunkKey]*list.Element)
+	c.used = 0
+	if c.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(c.dir)
+}
+
+// load returns the chunk of length bytes at offset of the file described
+// by info, from the cache or else from read, and keeps what read returned.
+// A chunk is only read once at a time, a second reader waits for the first.
+func (c *Cache) load(ctx context.Context, p string, info protocol.FileInfo, offset, length int64, read func() ([]byte, error)) ([]byte, error) {
+	if c == nil {
+		return read()
+	}
+	key := chunkKey{path: p, modTime: info.ModTime, size: info.Size, offset: offset}
+	for {
+		if data, ok := c.get(key); ok {
+			cacheReads.Inc("hit")
+			return data, nil
+		}
+		c.mu.Lock()
+		wait, busy := c.pending[key]
+		if !busy {
+			c.pending[key] = make(chan struct{})
+		}
+		c.mu.Unlock()
+		if !busy {
+			break
+		}
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	defer func() {
+		c.mu.Lock()
+		close(c.pending[key])
+		delete(c.pending, key)
+		c.mu.Unlock()
+	}()
+
+	cacheReads.Inc("miss")
+	data, err := read()
+	// Short chunks are past the end of a file that shrank, not worth keeping
+	if err == nil && int64(len(data)) == length {
+		c.put(key, data)
+	}
+	return data, err
+}
+
+// get returns a kept chunk
+func (c *Cache) get(key chunkKey) ([]byte, bool) {
+	c.mu.Lock()
+	elem, ok := c.chunks[key]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if entry.data != nil {
+		return entry.data, true
+	}
+	// #nosec G304 -- the name is a hash inside the cache's own directory
+	data, err := os.ReadFile(c.file(key))
+	if err != nil || int64(len(data)) != entry.size {
+		c.drop(key)
+		return nil, false
+	}
+	return data, true
+}
+
+// put keeps a chunk, making room for it by dropping the least recently
+// used ones
+func (c *Cache) put(key chunkKey, data []byte) {
+	if int64(len(data)) > c.max {
+		return
+	}
+	entry := &cacheEntry{key: key, size: int64(len(data))}
+	if c.dir == "" {
+		entry.data = append([]byte(nil), data...)
+	} else if err := os.WriteFile(c.file(key), data, 0600); err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.chunks[key]; ok {
+		// Kept by a read that finished just before this one began
+		return
+	}
+	for c.used+entry.size > c.max && c.lru.Len() > 0 {
+		c.removeLocked(c.lru.Back())
+	}
+	c.chunks[key] = c.lru.PushFront(entry)
+	c.used += entry.size
+}
+
+// purge drops every chunk of p, after it was changed through the mount
+func (c *Cache) purge(p string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, elem := range c.chunks {
+		if key.path == p {
+			c.removeLocked(elem)
+		}
+	}
+}
+
+// drop removes one chunk
+func (c *Cache) drop(key chunkKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.chunks[key]; ok {
+		c.removeLocked(elem)
+	}
+}
+
+func (c *Cache) removeLocked(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.chunks, entry.key)
+	c.used -= entry.size
+	if c.dir != "" {
+		_ = os.Remove(c.file(entry.key))
+	}
+}
+
+// file is where a chunk is kept on disk, named by a hash so that paths of
+// the share do not show in the cache directory
+func (c *Cache) file(key chunkKey) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		key.path,
+		strconv.FormatInt(key.modTime, 10),
+		strconv.FormatInt(key.size, 10),
+		strconv.FormatInt(key.offset/transfer.ChunkSize, 10),
+	}, "\x00")))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,332 @@
+//go:build linux || darwin
+
+package mount
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Flags of rename(2), as Linux numbers them
+const (
+	renameNoReplace = 1
+	renameExchange  = 2
+)
+
+// Supported reports whether this platform has a mount backend
+const Supported = true
+
+// CheckMountPoint fails unless mountPoint is an existing directory
+func CheckMountPoint(mountPoint string) error {
+	if info, err := os.Stat(mountPoint); err != nil || !info.IsDir() {
+		return fmt.Errorf("mount point %s must be an existing directory", mountPoint)
+	}
+	return nil
+}
+
+// Mount serves the share behind client at mountPoint until ctx is done or
+// the tunnel closes, then unmounts it
+func Mount(ctx context.Context, client *remote.Client, mountPoint string, opts Options) error {
+	rfs := newRemoteFS(client, opts)
+	timeout := rfs.opts.AttrTimeout
+
+	fsOpts := &fs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:       "orb",
+			Name:         "orb",
+			DirectMount:  true,
+			MaxReadAhead: rfs.opts.ReadAhead,
+		},
+		EntryTimeout:    &timeout,
+		AttrTimeout:     &timeout,
+		NegativeTimeout: &timeout,
+		UID:             uint32(os.Getuid()),
+		GID:             uint32(os.Getgid()),
+	}
+	if rfs.opts.ReadOnly {
+		fsOpts.Options = append(fsOpts.Options, "ro")
+	}
+
+	server, err := fs.Mount(mountPoint, &node{fs: rfs}, fsOpts)
+	if err != nil {
+		return fmt.Errorf("failed to mount at %s: %w", mountPoint, err)
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-client.Mux().Done():
+		}
+		// Busy mounts are left to the user to unmount
+		_ = server.Unmount()
+	}()
+	server.Wait()
+
+	if err := client.Mux().Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("connection lost: %w", err)
+	}
+	return nil
+}
+
+// node is a file or directory of the mount. It holds no state of its own,
+// everything is looked up by path.
+type node struct {
+	fs.Inode
+	fs *remoteFS
+}
+
+var (
+	_ fs.NodeLookuper  = (*node)(nil)
+	_ fs.NodeGetattrer = (*node)(nil)
+	_ fs.NodeSetattrer = (*node)(nil)
+	_ fs.NodeReaddirer = (*node)(nil)
+	_ fs.NodeOpener    = (*node)(nil)
+	_ fs.NodeCreater   = (*node)(nil)
+	_ fs.NodeMkdirer   = (*node)(nil)
+	_ fs.NodeUnlinker  = (*node)(nil)
+	_ fs.NodeRmdirer   = (*node)(nil)
+	_ fs.NodeRenamer   = (*node)(nil)
+)
+
+// path is the path of the node in the share
+func (n *node) path() string {
+	return "/" + n.Path(n.Root())
+}
+
+func (n *node) child(name string) string {
+	return path.Join(n.path(), name)
+}
+
+// newChild makes the inode for a file or directory of n
+func (n *node) newChild(ctx context.Context, info protocol.FileInfo, out *fuse.EntryOut) *fs.Inode {
+	fillAttr(info, &out.Attr)
+	mode := uint32(fuse.S_IFREG)
+	if info.IsDir {
+		mode = fuse.S_IFDIR
+	}
+	return n.NewInode(ctx, &node{fs: n.fs}, fs.StableAttr{Mode: mode})
+}
+
+func (n *node) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	info, err := n.fs.stat(ctx, n.child(name))
+	if err != nil {
+		return nil, errno(err)
+	}
+	return n.newChild(ctx, info, out), 0
+}
+
+func (n *node) Getattr(ctx context.Context, _ fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	info, err := n.fs.stat(ctx, n.path())
+	if err != nil {
+		return errno(err)
+	}
+	fillAttr(info, &out.Attr)
+	return 0
+}
+
+// Setattr changes the size of a file. Modes, owners and times are kept by
+// the sharer and left as they are.
+func (n *node) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	if size, ok := in.GetSize(); ok {
+		if f, ok := fh.(*file); ok {
+			if err := f.flush(ctx); err != nil {
+				return errno(err)
+			}
+		}
+		if err := n.fs.truncate(ctx, n.path(), int64(size)); err != nil {
+			return errno(err)
+		}
+	}
+	return n.Getattr(ctx, fh, out)
+}
+
+func (n *node) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	files, err := n.fs.list(ctx, n.path())
+	if err != nil {
+		return nil, errno(err)
+	}
+
+	entries := make([]fuse.DirEntry, 0, len(files))
+	for _, f := range files {
+		mode := uint32(fuse.S_IFREG)
+		if f.IsDir {
+			mode = fuse.S_IFDIR
+		}
+		entries = append(entries, fuse.DirEntry{Name: f.Name, Mode: mode})
+	}
+	return fs.NewListDirStream(entries), 0
+}
+
+func (n *node) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		if err := n.fs.writable(); err != nil {
+			return nil, 0, errno(err)
+		}
+	}
+	if flags&syscall.O_TRUNC != 0 {
+		if err := n.fs.truncate(ctx, n.path(), 0); err != nil {
+			return nil, 0, errno(err)
+		}
+	}
+	return &file{openFile: n.fs.open(n.path()), node: n}, 0, 0
+}
+
+func (n *node) Create(ctx context.Context, name string, flags, mode uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
+	p := n.child(name)
+	if flags&syscall.O_EXCL != 0 {
+		if _, err := n.fs.stat(ctx, p); err == nil {
+			return nil, nil, 0, syscall.EEXIST
+		}
+	}
+	if err := n.fs.create(ctx, p); err != nil {
+		return nil, nil, 0, errno(err)
+	}
+	if flags&syscall.O_TRUNC != 0 {
+		if err := n.fs.truncate(ctx, p, 0); err != nil {
+			return nil, nil, 0, errno(err)
+		}
+	}
+
+	info, err := n.fs.stat(ctx, p)
+	if err != nil {
+		return nil, nil, 0, errno(err)
+	}
+	child := n.newChild(ctx, info, out)
+	return child, &file{openFile: n.fs.open(p), node: child.Operations().(*node)}, 0, 0
+}
+
+func (n *node) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	p := n.child(name)
+	if err := n.fs.mkdir(ctx, p, mode); err != nil {
+		return nil, errno(err)
+	}
+	info, err := n.fs.stat(ctx, p)
+	if err != nil {
+		return nil, errno(err)
+	}
+	return n.newChild(ctx, info, out), 0
+}
+
+func (n *node) Unlink(ctx context.Context, name string) syscall.Errno {
+	return errno(n.fs.remove(ctx, n.child(name), false))
+}
+
+func (n *node) Rmdir(ctx context.Context, name string) syscall.Errno {
+	return errno(n.fs.remove(ctx, n.child(name), true))
+}
+
+func (n *node) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	if flags&renameExchange != 0 {
+		return syscall.ENOTSUP
+	}
+	target := path.Join("/"+newParent.EmbeddedInode().Path(n.Root()), newName)
+	return errno(n.fs.rename(ctx, n.child(name), target, flags&renameNoReplace != 0))
+}
+
+// file is an open file of the mount
+type file struct {
+	*openFile
+	node *node
+}
+
+var (
+	_ fs.FileReader   = (*file)(nil)
+	_ fs.FileWriter   = (*file)(nil)
+	_ fs.FileFlusher  = (*file)(nil)
+	_ fs.FileFsyncer  = (*file)(nil)
+	_ fs.FileReleaser = (*file)(nil)
+)
+
+// follow keeps the handle on its file when the file was moved while open
+func (f *file) follow() {
+	f.rename(f.node.path())
+}
+
+func (f *file) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	f.follow()
+	n, err := f.readAt(ctx, dest, off)
+	if err != nil {
+		return nil, errno(err)
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+func (f *file) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
+	f.follow()
+	n, err := f.writeAt(ctx, data, off)
+	if err != nil {
+		return 0, errno(err)
+	}
+	return uint32(n), 0
+}
+
+func (f *file) Flush(ctx context.Context) syscall.Errno {
+	f.follow()
+	return errno(f.flush(ctx))
+}
+
+func (f *file) Fsync(ctx context.Context, _ uint32) syscall.Errno {
+	f.follow()
+	return errno(f.flush(ctx))
+}
+
+// Release sends what is left once the file is closed. Its errors cannot
+// reach the program that wrote the data any more, Flush reported them.
+func (f *file) Release(ctx context.Context) syscall.Errno {
+	f.follow()
+	return errno(f.flush(ctx))
+}
+
+// fillAttr converts the attributes the sharer reports
+func fillAttr(info protocol.FileInfo, out *fuse.Attr) {
+	mode := os.FileMode(info.Mode)
+	out.Mode = uint32(mode.Perm())
+	if info.IsDir {
+		out.Mode |= fuse.S_IFDIR
+	} else {
+		out.Mode |= fuse.S_IFREG
+	}
+	out.Size = uint64(max(info.Size, 0))
+	out.Blocks = (out.Size + 511) / 512
+	out.Nlink = 1
+	mtime := time.Unix(info.ModTime, 0)
+	out.SetTimes(nil, &mtime, &mtime)
+}
+
+// errno maps a failure to the error code returned to the program
+func errno(err error) syscall.Errno {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errNotFound):
+		return syscall.ENOENT
+	case errors.Is(err, errExists):
+		return syscall.EEXIST
+	case errors.Is(err, errNotEmpty):
+		return syscall.ENOTEMPTY
+	case errors.Is(err, errIsDir):
+		return syscall.EISDIR
+	case errors.Is(err, errNotDir):
+		return syscall.ENOTDIR
+	case errors.Is(err, errAccess):
+		return syscall.EACCES
+	case errors.Is(err, errReadOnly):
+		return syscall.EROFS
+	case errors.Is(err, errUnsupported):
+		return syscall.ENOTSUP
+	case errors.Is(err, context.Canceled):
+		return syscall.EINTR
+	default:
+		return syscall.EIO
+	}
+}
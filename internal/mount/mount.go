@@ -0,0 +1,113 @@
+// Package mount makes a share available to local programs, as a directory
+// through FUSE or over WebDAV, SFTP, 9P, NFS or restic's REST protocol,
+// backed by requests over the tunnel
+package mount
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+)
+
+// ErrUnsupported is returned on platforms without a mount backend
+var ErrUnsupported = errors.New("mounting is only supported on Linux, macOS and Windows, use --webdav or the file browser instead of --mount")
+
+const (
+	// DefaultAttrTimeout is how long attributes and directory entries are
+	// trusted before the sharer is asked again
+	DefaultAttrTimeout = time.Second
+
+	// DefaultReadAhead is how much of a file is fetched at once while it is
+	// read from start to end
+	DefaultReadAhead = 1024 * 1024
+
+	// DefaultWriteBack is how much written data is collected before it is
+	// sent to the sharer
+	DefaultWriteBack = 1024 * 1024
+
+	// DefaultCacheSize is how much of what was read is kept for reading it
+	// again
+	DefaultCacheSize = 64 * 1024 * 1024
+)
+
+// Options tune a mount. Zero values take the defaults.
+type Options struct {
+	// ReadOnly refuses changes locally, as the sharer would
+	ReadOnly bool
+	// AttrTimeout is how long attributes are cached
+	AttrTimeout time.Duration
+	// ReadAhead is the window fetched for sequential reads
+	ReadAhead int
+	// WriteBack is the amount of written data held before it is sent
+	WriteBack int
+	// Cache keeps what was read for later reads, shared by everything
+	// served with these options. Nil caches nothing.
+	Cache *Cache
+}
+
+func (o *Options) setDefaults() {
+	if o.AttrTimeout <= 0 {
+		o.AttrTimeout = DefaultAttrTimeout
+	}
+	if o.ReadAhead <= 0 {
+		o.ReadAhead = DefaultReadAhead
+	}
+	if o.WriteBack <= 0 {
+		o.WriteBack = DefaultWriteBack
+	}
+}
+
+// serveConns runs serve for every connection accepted on ln until ctx is
+// done or the tunnel closes, then closes the connections still open and
+// waits for serve to return on each
+func serveConns(ctx context.Context, client *remote.Client, ln net.Listener, serve func(conn net.Conn)) error {
+	var (
+		mu    sync.Mutex
+		conns = make(map[net.Conn]struct{})
+		wg    sync.WaitGroup
+	)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-client.Mux().Done():
+		}
+		_ = ln.Close()
+		mu.Lock()
+		for conn := range conns {
+			_ = conn.Close()
+		}
+		mu.Unlock()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			break
+		}
+		mu.Lock()
+		conns[conn] = struct{}{}
+		mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				mu.Lock()
+				delete(conns, conn)
+				mu.Unlock()
+				wg.Done()
+			}()
+			serve(conn)
+		}()
+	}
+	wg.Wait()
+
+	if err := client.Mux().Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("connection lost: %w", err)
+	}
+	return nil
+}
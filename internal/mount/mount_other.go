@@ -0,0 +1,22 @@
+//go:build !linux && !darwin && !windows
+
+package mount
+
+import (
+	"context"
+
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+)
+
+// Supported reports whether this platform has a mount backend
+const Supported = false
+
+// CheckMountPoint fails, there is nothing to mount with
+func CheckMountPoint(mountPoint string) error {
+	return ErrUnsupported
+}
+
+// Mount is not available on this platform
+func Mount(ctx context.Context, client *remote.Client, mountPoint string, opts Options) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,475 @@
+package mount
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// ONC RPC, as NFS and its mount protocol are carried
+const (
+	rpcCall         = 0
+	rpcReply        = 1
+	rpcAccepted     = 0
+	rpcDenied       = 1
+	rpcVersion      = 2
+	rpcMismatch     = 0
+	rpcSuccess      = 0
+	rpcProgUnavail  = 1
+	rpcProgMismatch = 2
+	rpcProcUnavail  = 3
+	rpcGarbageArgs  = 4
+	rpcAuthUnix     = 1
+	rpcLastFrag     = 0x80000000
+	rpcMaxRecord    = nfsMaxData + 64*1024
+
+	nfsProgram   = 100003
+	mountProgram = 100005
+	nfsVersion   = 3
+)
+
+// Procedures of the mount protocol, version 3
+const (
+	mountNull    = 0
+	mountMnt     = 1
+	mountDump    = 2
+	mountUmnt    = 3
+	mountUmntAll = 4
+	mountExport  = 5
+)
+
+// nfsIdle is how long a file is kept open after its last request before
+// its writes are sent and its read-ahead dropped. NFS has no open or close.
+const nfsIdle = 5 * time.Second
+
+// nfsCred is who a request comes from, as AUTH_UNIX tells it
+type nfsCred struct {
+	uid, gid uint32
+}
+
+// nfsFile is a file being read or written by NFS clients
+type nfsFile struct {
+	*openFile
+	used time.Time
+}
+
+// nfsServer serves NFSv3 and the mount protocol for one share. Handles name
+// paths through a table, so that they stay valid across renames; a random
+// key in every handle keeps them from being guessed.
+type nfsServer struct {
+	fs     *remoteFS
+	export string
+	key    [8]byte
+
+	mu       sync.Mutex
+	verf     [8]byte // changes when written data may have been lost
+	paths    map[uint64]string
+	ids      map[string]uint64
+	next     uint64
+	files    map[string]*nfsFile
+	listings map[string][]protocol.FileInfo // directories being read
+}
+
+// ServeNFS serves the share behind client over NFSv3 on ln until ctx is
+// done or the tunnel closes, for devices that speak nothing else. The mount
+// protocol is served on the same port, and the share is exported as
+// /password.
+func ServeNFS(ctx context.Context, client *remote.Client, ln net.Listener, opts Options, password string) error {
+	s := &nfsServer{
+		fs:       newRemoteFS(client, opts),
+		export:   "/" + password,
+		paths:    map[uint64]string{1: "/"},
+		ids:      map[string]uint64{"/": 1},
+		next:     2,
+		files:    make(map[string]*nfsFile),
+		listings: make(map[string][]protocol.FileInfo),
+	}
+	if _, err := rand.Read(s.key[:]); err != nil {
+		return fmt.Errorf("failed to generate file handle key: %w", err)
+	}
+	binary.BigEndian.PutUint64(s.verf[:], uint64(time.Now().UnixNano()))
+
+	sweepCtx, stopSweep := context.WithCancel(ctx)
+	defer stopSweep()
+	go s.sweep(sweepCtx)
+
+	err := serveConns(ctx, client, ln, func(conn net.Conn) {
+		if err := s.serveConn(ctx, conn); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+			slog.Debug("nfs connection ended", "remote", conn.RemoteAddr(), "err", err)
+		}
+		_ = conn.Close()
+	})
+	s.closeFiles(ctx, 0)
+	return err
+}
+
+// serveConn answers the calls on one connection, each as it comes in
+func (s *nfsServer) serveConn(ctx context.Context, conn net.Conn) error {
+	var (
+		wg      sync.WaitGroup
+		writeMu sync.Mutex
+	)
+	defer wg.Wait()
+
+	for {
+		call, err := readRecord(conn)
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reply := s.call(ctx, &xdrReader{b: call})
+			if reply == nil {
+				return
+			}
+			msg := binary.BigEndian.AppendUint32(nil, rpcLastFrag|uint32(len(reply.b)))
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			if _, err := conn.Write(append(msg, reply.b...)); err != nil {
+				slog.Debug("failed to send nfs reply", "err", err)
+			}
+		}()
+	}
+}
+
+// readRecord reads one RPC message, made of fragments
+func readRecord(r io.Reader) ([]byte, error) {
+	var record []byte
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, err
+		}
+		h := binary.BigEndian.Uint32(header[:])
+		size := int(h &^ rpcLastFrag)
+		if len(record)+size > rpcMaxRecord {
+			return nil, fmt.Errorf("rpc message of more than %d bytes", rpcMaxRecord)
+		}
+		start := len(record)
+		record = append(record, make([]byte, size)...)
+		if _, err := io.ReadFull(r, record[start:]); err != nil {
+			return nil, err
+		}
+		if h&rpcLastFrag != 0 {
+			return record, nil
+		}
+	}
+}
+
+// call answers one RPC call, nil for messages that are not calls
+func (s *nfsServer) call(ctx context.Context, r *xdrReader) *xdrWriter {
+	xid, mtype := r.u32(), r.u32()
+	if r.err != nil || mtype != rpcCall {
+		return nil
+	}
+	w := &xdrWriter{}
+	w.u32(xid)
+	w.u32(rpcReply)
+
+	if r.u32() != rpcVersion {
+		w.u32(rpcDenied)
+		w.u32(rpcMismatch)
+		w.u32(rpcVersion)
+		w.u32(rpcVersion)
+		return w
+	}
+	prog, vers, proc := r.u32(), r.u32(), r.u32()
+	flavor, body := r.u32(), r.opaque(400)
+	r.u32()       // verifier flavor
+	r.opaque(400) // verifier
+	var cred nfsCred
+	if flavor == rpcAuthUnix {
+		cr := &xdrReader{b: body}
+		cr.u32()       // stamp
+		cr.opaque(255) // machine name
+		cred.uid, cred.gid = cr.u32(), cr.u32()
+	}
+
+	w.u32(rpcAccepted)
+	w.u32(0) // AUTH_NONE verifier
+	w.u32(0)
+	if r.err != nil {
+		w.u32(rpcGarbageArgs)
+		return w
+	}
+	if prog != nfsProgram && prog != mountProgram {
+		w.u32(rpcProgUnavail)
+		return w
+	}
+	if vers != nfsVersion {
+		w.u32(rpcProgMismatch)
+		w.u32(nfsVersion)
+		w.u32(nfsVersion)
+		return w
+	}
+
+	res := &xdrWriter{}
+	var ok bool
+	if prog == mountProgram {
+		ok = s.mount(proc, r, res)
+	} else {
+		ok = s.nfs(ctx, proc, cred, r, res)
+	}
+	switch {
+	case !ok:
+		w.u32(rpcProcUnavail)
+	case r.err != nil:
+		w.u32(rpcGarbageArgs)
+	default:
+		w.u32(rpcSuccess)
+		w.b = append(w.b, res.b...)
+	}
+	return w
+}
+
+// mount answers a call of the mount protocol. The share is exported to
+// those who know its name; listing the exports shows none.
+func (s *nfsServer) mount(proc uint32, r *xdrReader, w *xdrWriter) bool {
+	switch proc {
+	case mountNull, mountUmnt, mountUmntAll:
+		if proc == mountUmnt {
+			r.str(1024)
+		}
+	case mountMnt:
+		dir := path.Clean("/" + r.str(1024))
+		if subtle.ConstantTimeCompare([]byte(dir), []byte(s.export)) != 1 {
+			w.u32(nfsErrAccess)
+			return true
+		}
+		w.u32(nfsOK)
+		s.writeHandle(w, "/")
+		w.u32(1) // flavors
+		w.u32(rpcAuthUnix)
+	case mountDump, mountExport:
+		w.bool(false)
+	default:
+		return false
+	}
+	return true
+}
+
+// id returns the number of the handle of p, giving it one if it had none
+func (s *nfsServer) id(p string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.ids[p]
+	if !ok {
+		id = s.next
+		s.next++
+		s.ids[p] = id
+		s.paths[id] = p
+	}
+	return id
+}
+
+// writeHandle writes the file handle of p
+func (s *nfsServer) writeHandle(w *xdrWriter, p string) {
+	h := make([]byte, 0, 16)
+	h = binary.BigEndian.AppendUint64(h, s.id(p))
+	h = append(h, s.key[:]...)
+	w.opaque(h)
+}
+
+// readHandle reads a file handle and returns the path it names
+func (s *nfsServer) readHandle(r *xdrReader) (string, uint32) {
+	h := r.opaque(64)
+	if len(h) != 16 {
+		return "", nfsErrBadHandle
+	}
+	if subtle.ConstantTimeCompare(h[8:], s.key[:]) != 1 {
+		return "", nfsErrStale
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.paths[binary.BigEndian.Uint64(h)]
+	if !ok {
+		return "", nfsErrStale
+	}
+	return p, nfsOK
+}
+
+// moved points the handles and open files below oldPath at newPath, and
+// forgets what was at newPath before
+func (s *nfsServer) moved(oldPath, newPath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.ids[newPath]; ok {
+		delete(s.ids, newPath)
+		delete(s.paths, id)
+	}
+	delete(s.files, newPath)
+
+	for p, id := range s.ids {
+		if p != oldPath && !strings.HasPrefix(p, oldPath+"/") {
+			continue
+		}
+		moved := newPath + strings.TrimPrefix(p, oldPath)
+		delete(s.ids, p)
+		s.ids[moved] = id
+		s.paths[id] = moved
+		if f, ok := s.files[p]; ok {
+			delete(s.files, p)
+			f.rename(moved)
+			s.files[moved] = f
+		}
+	}
+}
+
+// file returns the open file for p, opening it on first use
+func (s *nfsServer) file(p string) *nfsFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[p]
+	if !ok {
+		f = &nfsFile{openFile: s.fs.open(p)}
+		s.files[p] = f
+	}
+	f.used = time.Now()
+	return f
+}
+
+// closeFile sends the writes held for p and forgets its open file, so that
+// the next read sees the file as the sharer has it
+func (s *nfsServer) closeFile(ctx context.Context, p string) error {
+	s.mu.Lock()
+	f, ok := s.files[p]
+	delete(s.files, p)
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return f.flush(ctx)
+}
+
+// flushFile sends the writes held for p, keeping it open
+func (s *nfsServer) flushFile(ctx context.Context, p string) error {
+	s.mu.Lock()
+	f, ok := s.files[p]
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return f.flush(ctx)
+}
+
+// sweep closes files idle for nfsIdle until ctx is done
+func (s *nfsServer) sweep(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.closeFiles(ctx, nfsIdle)
+		}
+	}
+}
+
+// closeFiles closes the files unused for longer than idle. Writes that
+// cannot be sent change the write verifier, which tells clients to send
+// again what they wrote since their last commit.
+func (s *nfsServer) closeFiles(ctx context.Context, idle time.Duration) {
+	var idleFiles []*nfsFile
+	s.mu.Lock()
+	for p, f := range s.files {
+		if time.Since(f.used) >= idle {
+			idleFiles = append(idleFiles, f)
+			delete(s.files, p)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, f := range idleFiles {
+		if err := f.flush(context.WithoutCancel(ctx)); err != nil {
+			slog.Warn("failed to write back to the share", "path", f.path, "err", err)
+			s.mu.Lock()
+			binary.BigEndian.PutUint64(s.verf[:], binary.BigEndian.Uint64(s.verf[:])+1)
+			s.mu.Unlock()
+		}
+	}
+}
+
+// writeVerf is what clients compare to learn whether unstable writes they
+// made are still to be had
+func (s *nfsServer) writeVerf() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]byte(nil), s.verf[:]...)
+}
+
+// xdrWriter builds XDR data
+type xdrWriter struct {
+	b []byte
+}
+
+func (w *xdrWriter) u32(v uint32) { w.b = binary.BigEndian.AppendUint32(w.b, v) }
+func (w *xdrWriter) u64(v uint64) { w.b = binary.BigEndian.AppendUint64(w.b, v) }
+
+func (w *xdrWriter) bool(v bool) {
+	if v {
+		w.u32(1)
+	} else {
+		w.u32(0)
+	}
+}
+
+// fixed writes data of a length both sides know
+func (w *xdrWriter) fixed(data []byte) {
+	w.b = append(w.b, data...)
+	w.b = append(w.b, make([]byte, (4-len(data)%4)%4)...)
+}
+
+func (w *xdrWriter) opaque(data []byte) {
+	w.u32(uint32(len(data)))
+	w.fixed(data)
+}
+
+func (w *xdrWriter) str(v string) { w.opaque([]byte(v)) }
+
+// xdrReader reads XDR data. Data cut short or too long sets err and reads
+// as zeros from then on.
+type xdrReader struct {
+	b   []byte
+	err error
+}
+
+func (r *xdrReader) take(n int) []byte {
+	padded := n + (4-n%4)%4
+	if r.err != nil || n < 0 || padded > len(r.b) {
+		r.err = io.ErrUnexpectedEOF
+		return make([]byte, 8)[:min(max(n, 0), 8)]
+	}
+	b := r.b[:n]
+	r.b = r.b[padded:]
+	return b
+}
+
+func (r *xdrReader) u32() uint32 { return binary.BigEndian.Uint32(r.take(4)) }
+func (r *xdrReader) u64() uint64 { return binary.BigEndian.Uint64(r.take(8)) }
+func (r *xdrReader) bool() bool  { return r.u32() != 0 }
+
+// opaque reads variable length data of at most limit bytes
+func (r *xdrReader) opaque(limit int) []byte {
+	n := r.u32()
+	if n > uint32(limit) {
+		r.err = fmt.Errorf("xdr field of %d bytes", n)
+	}
+	return r.take(int(min(n, uint32(limit))))
+}
+
+func (r *xdrReader) str(limit int) string { return string(r.opaque(limit)) }
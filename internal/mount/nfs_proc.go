@@ -0,0 +1,577 @@
+package mount
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// Procedures of NFS version 3
+const (
+	nfsNull        = 0
+	nfsGetattr     = 1
+	nfsSetattr     = 2
+	nfsLookup      = 3
+	nfsAccess      = 4
+	nfsReadlink    = 5
+	nfsRead        = 6
+	nfsWrite       = 7
+	nfsCreate      = 8
+	nfsMkdir       = 9
+	nfsSymlink     = 10
+	nfsMknod       = 11
+	nfsRemove      = 12
+	nfsRmdir       = 13
+	nfsRename      = 14
+	nfsLink        = 15
+	nfsReaddir     = 16
+	nfsReaddirPlus = 17
+	nfsFsstat      = 18
+	nfsFsinfo      = 19
+	nfsPathconf    = 20
+	nfsCommit      = 21
+)
+
+// Status codes of NFS version 3, also used by the mount protocol
+const (
+	nfsOK           = 0
+	nfsErrNoEnt     = 2
+	nfsErrIO        = 5
+	nfsErrAccess    = 13
+	nfsErrExist     = 17
+	nfsErrNotDir    = 20
+	nfsErrIsDir     = 21
+	nfsErrInval     = 22
+	nfsErrROFS      = 30
+	nfsErrNotEmpty  = 66
+	nfsErrStale     = 70
+	nfsErrBadHandle = 10001
+	nfsErrNotSupp   = 10004
+)
+
+const (
+	// nfsMaxData is the most data read or written by one request
+	nfsMaxData = 1024 * 1024
+
+	nfsUnstable  = 0
+	nfsFileSync  = 2
+	nfsUnchecked = 0
+	nfsExclusive = 2
+	// Access to modify, extend and delete, refused by read-only shares
+	nfsAccessWrite = 0x04 | 0x08 | 0x10
+)
+
+// nfs answers a call of NFS version 3, false for unknown procedures
+func (s *nfsServer) nfs(ctx context.Context, proc uint32, cred nfsCred, r *xdrReader, w *xdrWriter) bool {
+	switch proc {
+	case nfsNull:
+
+	case nfsGetattr:
+		p, status := s.readHandle(r)
+		if status == nfsOK {
+			var info protocol.FileInfo
+			info, status = s.stat(ctx, p)
+			if status == nfsOK {
+				w.u32(nfsOK)
+				s.writeAttr(w, p, info, cred)
+				break
+			}
+		}
+		w.u32(status)
+
+	case nfsSetattr:
+		p, status := s.readHandle(r)
+		attrs := readSattr(r)
+		if guard := r.bool(); guard {
+			r.u64() // ctime the client expects
+		}
+		if r.err != nil {
+			break
+		}
+		// Only the size can change, modes, owners and times stay with the
+		// sharer. Changing them succeeds so that copying tools go on.
+		if status == nfsOK && attrs.size != nil {
+			status = s.result(s.truncate(ctx, p, int64(*attrs.size)))
+		}
+		w.u32(status)
+		s.writeWcc(ctx, w, p, cred)
+
+	case nfsLookup:
+		dir, status := s.readHandle(r)
+		name := r.str(255)
+		p := dir
+		switch {
+		case status != nfsOK:
+		case name == "..":
+			p = path.Dir(dir)
+		case name == "" || strings.Contains(name, "/"):
+			status = nfsErrInval
+		case name != ".":
+			p = path.Join(dir, name)
+		}
+		var info protocol.FileInfo
+		if status == nfsOK {
+			info, status = s.stat(ctx, p)
+		}
+		w.u32(status)
+		if status == nfsOK {
+			s.writeHandle(w, p)
+			w.bool(true)
+			s.writeAttr(w, p, info, cred)
+		}
+		s.writePostAttr(ctx, w, dir, cred)
+
+	case nfsAccess:
+		p, status := s.readHandle(r)
+		access := r.u32()
+		w.u32(status)
+		s.writePostAttr(ctx, w, p, cred)
+		if status == nfsOK {
+			if s.fs.writable() != nil {
+				access &^= nfsAccessWrite
+			}
+			w.u32(access)
+		}
+
+	case nfsReadlink:
+		s.readHandle(r)
+		w.u32(nfsErrInval)
+		w.bool(false)
+
+	case nfsRead:
+		s.read(ctx, r, w, cred)
+
+	case nfsWrite:
+		s.write(ctx, r, w, cred)
+
+	case nfsCreate, nfsMkdir:
+		dir, name, status := s.readDirOp(r)
+		p := path.Join(dir, name)
+		var attrs nfsSattr
+		if proc == nfsMkdir {
+			attrs = readSattr(r)
+		} else {
+			how := r.u32()
+			if how == nfsExclusive {
+				r.take(8) // verifier
+			} else {
+				attrs = readSattr(r)
+			}
+			if status == nfsOK && how != nfsUnchecked {
+				if _, err := s.fs.stat(ctx, p); err == nil {
+					status = nfsErrExist
+				}
+			}
+		}
+		if r.err != nil {
+			break
+		}
+		if status == nfsOK {
+			switch {
+			case proc == nfsMkdir && attrs.mode != nil:
+				status = s.result(s.fs.mkdir(ctx, p, *attrs.mode))
+			case proc == nfsMkdir:
+				status = s.result(s.fs.mkdir(ctx, p, 0755))
+			default:
+				status = s.result(s.fs.create(ctx, p))
+				if status == nfsOK && attrs.size != nil {
+					status = s.result(s.truncate(ctx, p, int64(*attrs.size)))
+				}
+			}
+		}
+		w.u32(status)
+		if status == nfsOK {
+			w.bool(true)
+			s.writeHandle(w, p)
+			s.writePostAttr(ctx, w, p, cred)
+		}
+		s.writeWcc(ctx, w, dir, cred)
+
+	case nfsSymlink, nfsMknod:
+		dir, _, _ := s.readDirOp(r)
+		w.u32(nfsErrNotSupp)
+		s.writeWcc(ctx, w, dir, cred)
+
+	case nfsLink:
+		w.u32(nfsErrNotSupp)
+		w.bool(false)
+		w.bool(false)
+		w.bool(false)
+
+	case nfsRemove, nfsRmdir:
+		dir, name, status := s.readDirOp(r)
+		if r.err != nil {
+			break
+		}
+		if status == nfsOK {
+			p := path.Join(dir, name)
+			if err := s.closeFile(ctx, p); err != nil {
+				status = s.result(err)
+			} else {
+				status = s.result(s.fs.remove(ctx, p, proc == nfsRmdir))
+			}
+		}
+		w.u32(status)
+		s.writeWcc(ctx, w, dir, cred)
+
+	case nfsRename:
+		fromDir, fromName, status := s.readDirOp(r)
+		toDir, toName, toStatus := s.readDirOp(r)
+		if r.err != nil {
+			break
+		}
+		if status == nfsOK {
+			status = toStatus
+		}
+		if status == nfsOK {
+			oldPath, newPath := path.Join(fromDir, fromName), path.Join(toDir, toName)
+			if err := s.closeFile(ctx, oldPath); err != nil {
+				status = s.result(err)
+			} else if status = s.result(s.fs.rename(ctx, oldPath, newPath, false)); status == nfsOK {
+				s.moved(oldPath, newPath)
+			}
+		}
+		w.u32(status)
+		s.writeWcc(ctx, w, fromDir, cred)
+		s.writeWcc(ctx, w, toDir, cred)
+
+	case nfsReaddir, nfsReaddirPlus:
+		s.readdir(ctx, proc == nfsReaddirPlus, r, w, cred)
+
+	case nfsFsstat:
+		p, status := s.readHandle(r)
+		w.u32(status)
+		s.writePostAttr(ctx, w, p, cred)
+		if status == nfsOK {
+			// The sharer does not report its free space, and devices
+			// refuse to copy to a file system that looks full
+			const unknown = 1 << 50
+			for range 6 {
+				w.u64(unknown) // bytes and files, in all, free and available
+			}
+			w.u32(0)
+		}
+
+	case nfsFsinfo:
+		p, status := s.readHandle(r)
+		w.u32(status)
+		s.writePostAttr(ctx, w, p, cred)
+		if status == nfsOK {
+			w.u32(nfsMaxData) // rtmax
+			w.u32(nfsMaxData) // rtpref
+			w.u32(4096)       // rtmult
+			w.u32(nfsMaxData) // wtmax
+			w.u32(nfsMaxData) // wtpref
+			w.u32(4096)       // wtmult
+			w.u32(64 * 1024)  // dtpref
+			w.u64(1 << 62)    // maxfilesize
+			w.u32(1)          // time_delta
+			w.u32(0)
+			w.u32(0x8) // FSF3_HOMOGENEOUS
+		}
+
+	case nfsPathconf:
+		p, status := s.readHandle(r)
+		w.u32(status)
+		s.writePostAttr(ctx, w, p, cred)
+		if status == nfsOK {
+			w.u32(1)      // linkmax
+			w.u32(255)    // name_max
+			w.bool(true)  // no_trunc
+			w.bool(true)  // chown_restricted
+			w.bool(false) // case_insensitive
+			w.bool(true)  // case_preserving
+		}
+
+	case nfsCommit:
+		p, status := s.readHandle(r)
+		r.u64() // offset
+		r.u32() // count
+		if r.err != nil {
+			break
+		}
+		if status == nfsOK {
+			status = s.result(s.flushFile(ctx, p))
+		}
+		w.u32(status)
+		s.writeWcc(ctx, w, p, cred)
+		if status == nfsOK {
+			w.fixed(s.writeVerf())
+		}
+
+	default:
+		return false
+	}
+	return true
+}
+
+// read answers READ from the open file of the handle
+func (s *nfsServer) read(ctx context.Context, r *xdrReader, w *xdrWriter, cred nfsCred) {
+	p, status := s.readHandle(r)
+	off, count := r.u64(), r.u32()
+	if r.err != nil {
+		return
+	}
+
+	var data []byte
+	if status == nfsOK {
+		data = make([]byte, min(count, nfsMaxData))
+		n, err := s.file(p).readAt(ctx, data, int64(off))
+		data, status = data[:n], s.result(err)
+	}
+	w.u32(status)
+	info, attrStatus := protocol.FileInfo{}, uint32(nfsErrStale)
+	if p != "" {
+		info, attrStatus = s.stat(ctx, p)
+	}
+	w.bool(attrStatus == nfsOK)
+	if attrStatus == nfsOK {
+		s.writeAttr(w, p, info, cred)
+	}
+	if status == nfsOK {
+		w.u32(uint32(len(data)))
+		w.bool(attrStatus == nfsOK && int64(off)+int64(len(data)) >= info.Size)
+		w.opaque(data)
+	}
+}
+
+// write answers WRITE. Unstable writes are held until COMMIT or until the
+// file is idle, stable ones sent at once.
+func (s *nfsServer) write(ctx context.Context, r *xdrReader, w *xdrWriter, cred nfsCred) {
+	p, status := s.readHandle(r)
+	off := r.u64()
+	r.u32() // count, the length of the data tells the same
+	stable := r.u32()
+	data := r.opaque(nfsMaxData)
+	if r.err != nil {
+		return
+	}
+
+	if status == nfsOK {
+		status = s.result(s.fs.writable())
+	}
+	if status == nfsOK {
+		f := s.file(p)
+		_, err := f.writeAt(ctx, data, int64(off))
+		if err == nil && stable != nfsUnstable {
+			err = f.flush(ctx)
+		}
+		status = s.result(err)
+	}
+	w.u32(status)
+	s.writeWcc(ctx, w, p, cred)
+	if status == nfsOK {
+		w.u32(uint32(len(data)))
+		if stable == nfsUnstable {
+			w.u32(nfsUnstable)
+		} else {
+			w.u32(nfsFileSync)
+		}
+		w.fixed(s.writeVerf())
+	}
+}
+
+// readdir answers READDIR and READDIRPLUS. Cookies are positions in the
+// listing, which is taken anew when a directory is read from the start.
+func (s *nfsServer) readdir(ctx context.Context, plus bool, r *xdrReader, w *xdrWriter, cred nfsCred) {
+	dir, status := s.readHandle(r)
+	cookie := r.u64()
+	r.take(8) // cookie verifier
+	count := r.u32()
+	if plus {
+		count = r.u32() // maxcount, dircount only counts names
+	}
+	if r.err != nil {
+		return
+	}
+
+	var files []protocol.FileInfo
+	if status == nfsOK {
+		s.mu.Lock()
+		listed, ok := s.listings[dir]
+		s.mu.Unlock()
+		if cookie == 0 || !ok {
+			var err error
+			listed, err = s.fs.list(ctx, dir)
+			status = s.result(err)
+		}
+		files = listed
+	}
+
+	w.u32(status)
+	s.writePostAttr(ctx, w, dir, cred)
+	if status != nfsOK {
+		return
+	}
+	w.u64(0) // cookie verifier
+
+	// The reply holds the header written so far, the entries and two
+	// words ending them
+	budget := int(count) - len(w.b) - 8
+	i := min(cookie, uint64(len(files)))
+	for ; i < uint64(len(files)); i++ {
+		f := files[i]
+		p := path.Join(dir, f.Name)
+		entry := &xdrWriter{}
+		entry.bool(true)
+		entry.u64(s.id(p))
+		entry.str(f.Name)
+		entry.u64(i + 1)
+		if plus {
+			entry.bool(true)
+			s.writeAttr(entry, p, s.fs.withPending(p, f), cred)
+			entry.bool(true)
+			s.writeHandle(entry, p)
+		}
+		if len(entry.b) > budget {
+			break
+		}
+		budget -= len(entry.b)
+		w.b = append(w.b, entry.b...)
+	}
+	w.bool(false)
+	eof := i == uint64(len(files))
+	w.bool(eof)
+
+	// The listing is kept until the directory has been read to the end
+	s.mu.Lock()
+	if eof {
+		delete(s.listings, dir)
+	} else {
+		s.listings[dir] = files
+	}
+	s.mu.Unlock()
+}
+
+// readDirOp reads the handle of a directory and the name of an entry in it
+func (s *nfsServer) readDirOp(r *xdrReader) (string, string, uint32) {
+	dir, status := s.readHandle(r)
+	name := r.str(255)
+	if status == nfsOK && (name == "" || name == "." || name == ".." || strings.Contains(name, "/")) {
+		status = nfsErrInval
+	}
+	return dir, name, status
+}
+
+// truncate sets the size of p, after the writes held for it
+func (s *nfsServer) truncate(ctx context.Context, p string, size int64) error {
+	if err := s.closeFile(ctx, p); err != nil {
+		return err
+	}
+	return s.fs.truncate(ctx, p, size)
+}
+
+// stat returns the attributes of p with the status of the failure
+func (s *nfsServer) stat(ctx context.Context, p string) (protocol.FileInfo, uint32) {
+	info, err := s.fs.stat(ctx, p)
+	return info, s.result(err)
+}
+
+// writeAttr writes the attributes of p. Files belong to whoever asks.
+func (s *nfsServer) writeAttr(w *xdrWriter, p string, info protocol.FileInfo, cred nfsCred) {
+	size := uint64(max(info.Size, 0))
+	if info.IsDir {
+		w.u32(2) // NF3DIR
+	} else {
+		w.u32(1) // NF3REG
+	}
+	w.u32(uint32(os.FileMode(info.Mode).Perm()))
+	if info.IsDir {
+		w.u32(2) // nlink
+	} else {
+		w.u32(1)
+	}
+	w.u32(cred.uid)
+	w.u32(cred.gid)
+	w.u64(size)
+	w.u64(size) // used
+	w.u64(0)    // rdev
+	w.u64(1)    // fsid
+	w.u64(s.id(p))
+	for range 3 {
+		w.u32(uint32(info.ModTime)) // atime, mtime and ctime
+		w.u32(0)
+	}
+}
+
+// writePostAttr writes the attributes of p if it can be looked up
+func (s *nfsServer) writePostAttr(ctx context.Context, w *xdrWriter, p string, cred nfsCred) {
+	if p == "" {
+		w.bool(false)
+		return
+	}
+	info, err := s.fs.stat(ctx, p)
+	w.bool(err == nil)
+	if err == nil {
+		s.writeAttr(w, p, info, cred)
+	}
+}
+
+// writeWcc writes the attributes of p after a change. The ones from before
+// are not known.
+func (s *nfsServer) writeWcc(ctx context.Context, w *xdrWriter, p string, cred nfsCred) {
+	w.bool(false)
+	s.writePostAttr(ctx, w, p, cred)
+}
+
+// result is the status of err
+func (s *nfsServer) result(err error) uint32 {
+	switch {
+	case err == nil:
+		return nfsOK
+	case errors.Is(err, errNotFound):
+		return nfsErrNoEnt
+	case errors.Is(err, errExists):
+		return nfsErrExist
+	case errors.Is(err, errNotEmpty):
+		return nfsErrNotEmpty
+	case errors.Is(err, errIsDir):
+		return nfsErrIsDir
+	case errors.Is(err, errNotDir):
+		return nfsErrNotDir
+	case errors.Is(err, errAccess):
+		return nfsErrAccess
+	case errors.Is(err, errReadOnly):
+		return nfsErrROFS
+	case errors.Is(err, errUnsupported):
+		return nfsErrNotSupp
+	default:
+		return nfsErrIO
+	}
+}
+
+// nfsSattr is what SETATTR, CREATE and MKDIR ask to set, of what is
+// honored
+type nfsSattr struct {
+	mode *uint32
+	size *uint64
+}
+
+// readSattr reads the attributes to set
+func readSattr(r *xdrReader) nfsSattr {
+	var attrs nfsSattr
+	if r.bool() {
+		mode := r.u32() & 0777
+		attrs.mode = &mode
+	}
+	if r.bool() {
+		r.u32() // uid
+	}
+	if r.bool() {
+		r.u32() // gid
+	}
+	if r.bool() {
+		size := r.u64()
+		attrs.size = &size
+	}
+	for range 2 { // atime and mtime
+		if r.u32() == 2 { // SET_TO_CLIENT_TIME
+			r.u64()
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,821 @@
+package mount
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// Message types of 9P2000.L
+const (
+	p9Rlerror     = 7
+	p9Tstatfs     = 8
+	p9Tlopen      = 12
+	p9Tlcreate    = 14
+	p9Trename     = 20
+	p9Tgetattr    = 24
+	p9Tsetattr    = 26
+	p9Treaddir    = 40
+	p9Tfsync      = 50
+	p9Tlock       = 52
+	p9Tgetlock    = 54
+	p9Tmkdir      = 72
+	p9Trenameat   = 74
+	p9Tunlinkat   = 76
+	p9Tversion    = 100
+	p9Tauth       = 102
+	p9Tattach     = 104
+	p9Tflush      = 108
+	p9Twalk       = 110
+	p9Tread       = 116
+	p9Twrite      = 118
+	p9Tclunk      = 120
+	p9Tremove     = 122
+	p9NoTag       = 0xffff
+	p9Version     = "9P2000.L"
+	p9HeaderSize  = 7
+	p9MaxMsize    = 1024 * 1024
+	p9StatfsMagic = 0x01021997 // V9FS_MAGIC
+)
+
+// Error numbers as Linux has them, which 9P2000.L clients expect on every
+// platform
+const (
+	p9EPERM      = 1
+	p9ENOENT     = 2
+	p9EIO        = 5
+	p9EBADF      = 9
+	p9EACCES     = 13
+	p9EEXIST     = 17
+	p9ENOTDIR    = 20
+	p9EISDIR     = 21
+	p9EINVAL     = 22
+	p9EROFS      = 30
+	p9ENOTEMPTY  = 39
+	p9EOPNOTSUPP = 95
+)
+
+// Flags of Tlopen and Tlcreate, and of Tunlinkat and Tsetattr
+const (
+	p9OWronly      = 0x1
+	p9ORdwr        = 0x2
+	p9OCreat       = 0x40
+	p9OExcl        = 0x80
+	p9OTrunc       = 0x200
+	p9OAppend      = 0x400
+	p9AtRemoveDir  = 0x200
+	p9SetattrSize  = 0x8
+	p9GetattrBasic = 0x7ff
+)
+
+// p9Fid is a file of the share a client refers to by number, open once
+// Tlopen or Tlcreate succeeded
+type p9Fid struct {
+	path    string
+	uid     uint32 // of the user who attached, who owns every file
+	isDir   bool
+	file    *openFile
+	append  bool
+	entries []protocol.FileInfo // listing of a directory being read
+}
+
+// p9Conn serves 9P2000.L on one connection. Requests are answered
+// concurrently; the fid table and writes are guarded by mu.
+type p9Conn struct {
+	fs       *remoteFS
+	conn     net.Conn
+	password string
+
+	mu       sync.Mutex
+	msize    uint32
+	fids     map[uint32]*p9Fid
+	inflight map[uint16]chan struct{}
+}
+
+// Serve9P serves the share behind client over 9P2000.L on ln until ctx is
+// done or the tunnel closes, so that Linux can mount it with its own 9P
+// client and no FUSE. Clients attach with the password as aname.
+func Serve9P(ctx context.Context, client *remote.Client, ln net.Listener, opts Options, password string) error {
+	rfs := newRemoteFS(client, opts)
+
+	return serveConns(ctx, client, ln, func(conn net.Conn) {
+		c := &p9Conn{fs: rfs, conn: conn, password: password, msize: p9MaxMsize,
+			fids: make(map[uint32]*p9Fid), inflight: make(map[uint16]chan struct{})}
+		if err := c.serve(ctx); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+			slog.Debug("9p connection ended", "remote", conn.RemoteAddr(), "err", err)
+		}
+		_ = conn.Close()
+	})
+}
+
+// serve reads requests until the connection closes, flushing files left
+// open then
+func (c *p9Conn) serve(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait()
+		for _, fid := range c.fids {
+			if fid.file != nil {
+				_ = fid.file.flush(ctx)
+			}
+		}
+	}()
+
+	var header [p9HeaderSize]byte
+	for {
+		if _, err := io.ReadFull(c.conn, header[:]); err != nil {
+			return err
+		}
+		size := binary.LittleEndian.Uint32(header[:])
+		typ := header[4]
+		tag := binary.LittleEndian.Uint16(header[5:])
+		if size < p9HeaderSize || size > c.currentMsize() {
+			return fmt.Errorf("9p message of %d bytes", size)
+		}
+		body := make([]byte, size-p9HeaderSize)
+		if _, err := io.ReadFull(c.conn, body); err != nil {
+			return err
+		}
+
+		// Versions and flushes change what other requests see, so they
+		// are answered before reading on
+		if typ == p9Tversion || typ == p9Tflush {
+			c.reply(tag, c.handle(ctx, typ, tag, &p9Reader{b: body}))
+			continue
+		}
+
+		done := make(chan struct{})
+		c.mu.Lock()
+		c.inflight[tag] = done
+		c.mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.reply(tag, c.handle(ctx, typ, tag, &p9Reader{b: body}))
+			c.mu.Lock()
+			delete(c.inflight, tag)
+			c.mu.Unlock()
+			close(done)
+		}()
+	}
+}
+
+func (c *p9Conn) currentMsize() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.msize
+}
+
+// reply sends a response, written in one piece
+func (c *p9Conn) reply(tag uint16, w *p9Writer) {
+	msg := w.message(tag)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.conn.Write(msg); err != nil {
+		slog.Debug("failed to send 9p reply", "err", err)
+	}
+}
+
+// handle answers one request
+func (c *p9Conn) handle(ctx context.Context, typ byte, tag uint16, r *p9Reader) *p9Writer {
+	resp := c.request(ctx, typ, r)
+	if r.err != nil {
+		return p9Error(p9EINVAL)
+	}
+	return resp
+}
+
+func (c *p9Conn) request(ctx context.Context, typ byte, r *p9Reader) *p9Writer {
+	switch typ {
+	case p9Tversion:
+		msize, version := r.u32(), r.str()
+		c.mu.Lock()
+		c.msize = min(max(msize, 4096), p9MaxMsize)
+		c.fids = make(map[uint32]*p9Fid)
+		msize = c.msize
+		c.mu.Unlock()
+
+		w := newP9Writer(p9Tversion + 1)
+		w.u32(msize)
+		if version != p9Version {
+			version = "unknown"
+		}
+		w.str(version)
+		return w
+
+	case p9Tflush:
+		oldTag := r.u16()
+		c.mu.Lock()
+		done, ok := c.inflight[oldTag]
+		c.mu.Unlock()
+		// Answering the flushed request first keeps its tag from being
+		// reused while its reply is still on the way
+		if ok {
+			<-done
+		}
+		return newP9Writer(p9Tflush + 1)
+
+	case p9Tauth:
+		return p9Error(p9EOPNOTSUPP)
+
+	case p9Tattach:
+		fid, _, _, aname, uid := r.u32(), r.u32(), r.str(), r.str(), r.u32()
+		if subtle.ConstantTimeCompare([]byte(aname), []byte(c.password)) != 1 {
+			return p9Error(p9EACCES)
+		}
+		info, err := c.fs.stat(ctx, "/")
+		if err != nil {
+			return p9ErrorOf(err)
+		}
+		if uid == ^uint32(0) {
+			uid = 0
+		}
+		c.mu.Lock()
+		c.fids[fid] = &p9Fid{path: "/", isDir: true, uid: uid}
+		c.mu.Unlock()
+
+		w := newP9Writer(p9Tattach + 1)
+		w.qid("/", info)
+		return w
+
+	case p9Twalk:
+		fid, newFid, n := r.u32(), r.u32(), int(r.u16())
+		names := make([]string, 0, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			names = append(names, r.str())
+		}
+		return c.walk(ctx, fid, newFid, names)
+
+	case p9Tgetattr:
+		f, errno := c.fid(r.u32())
+		if errno != 0 {
+			return p9Error(errno)
+		}
+		info, err := c.fs.stat(ctx, f.path)
+		if err != nil {
+			return p9ErrorOf(err)
+		}
+		return p9Attr(f, info)
+
+	case p9Tsetattr:
+		f, errno := c.fid(r.u32())
+		if errno != 0 {
+			return p9Error(errno)
+		}
+		valid := r.u32()
+		r.u32() // mode
+		r.u32() // uid
+		r.u32() // gid
+		size := r.u64()
+		// Only the size can change, modes, owners and times stay with the
+		// sharer. Changing them succeeds so that copying tools go on.
+		if valid&p9SetattrSize != 0 && r.err == nil {
+			if f.file != nil {
+				if err := f.file.flush(ctx); err != nil {
+					return p9ErrorOf(err)
+				}
+			}
+			if err := c.fs.truncate(ctx, f.path, int64(size)); err != nil {
+				return p9ErrorOf(err)
+			}
+		}
+		return newP9Writer(p9Tsetattr + 1)
+
+	case p9Tlopen:
+		fid, flags := r.u32(), r.u32()
+		f, errno := c.fid(fid)
+		if errno != 0 {
+			return p9Error(errno)
+		}
+		info, err := c.open(ctx, &f, flags)
+		if err != nil {
+			return p9ErrorOf(err)
+		}
+		c.update(fid, func(stored *p9Fid) {
+			stored.isDir, stored.file, stored.append = f.isDir, f.file, f.append
+		})
+		w := newP9Writer(p9Tlopen + 1)
+		w.qid(f.path, info)
+		w.u32(c.currentMsize() - p9HeaderSize - 17)
+		return w
+
+	case p9Tlcreate:
+		fid, name, flags := r.u32(), r.str(), r.u32()
+		r.u32() // mode
+		r.u32() // gid
+		dir, errno := c.fid(fid)
+		if errno != 0 {
+			return p9Error(errno)
+		}
+		p, errno := p9Child(dir.path, name)
+		if errno != 0 {
+			return p9Error(errno)
+		}
+		if _, err := c.fs.stat(ctx, p); err == nil && flags&p9OExcl != 0 {
+			return p9Error(p9EEXIST)
+		}
+		if err := c.fs.create(ctx, p); err != nil {
+			return p9ErrorOf(err)
+		}
+
+		// The fid of the directory becomes the new file, opened
+		f := p9Fid{path: p, uid: dir.uid}
+		info, err := c.open(ctx, &f, flags&^p9OCreat)
+		if err != nil {
+			return p9ErrorOf(err)
+		}
+		c.mu.Lock()
+		c.fids[fid] = &f
+		c.mu.Unlock()
+
+		w := newP9Writer(p9Tlcreate + 1)
+		w.qid(p, info)
+		w.u32(c.currentMsize() - p9HeaderSize - 17)
+		return w
+
+	case p9Tread:
+		fid, off, count := r.u32(), r.u64(), r.u32()
+		f, errno := c.fid(fid)
+		if errno != 0 {
+			return p9Error(errno)
+		}
+		if f.file == nil {
+			return p9Error(p9EBADF)
+		}
+		buf := make([]byte, min(count, c.currentMsize()-p9HeaderSize-4))
+		n, err := f.file.readAt(ctx, buf, int64(off))
+		if err != nil {
+			return p9ErrorOf(err)
+		}
+		w := newP9Writer(p9Tread + 1)
+		w.u32(uint32(n))
+		w.b = append(w.b, buf[:n]...)
+		return w
+
+	case p9Twrite:
+		fid, off, count := r.u32(), int64(r.u64()), r.u32()
+		data := r.take(int(count))
+		f, errno := c.fid(fid)
+		if errno != 0 {
+			return p9Error(errno)
+		}
+		if f.file == nil {
+			return p9Error(p9EBADF)
+		}
+		if f.append {
+			info, err := c.fs.stat(ctx, f.path)
+			if err != nil {
+				return p9ErrorOf(err)
+			}
+			off = info.Size
+		}
+		n, err := f.file.writeAt(ctx, data, off)
+		if err != nil {
+			return p9ErrorOf(err)
+		}
+		w := newP9Writer(p9Twrite + 1)
+		w.u32(uint32(n))
+		return w
+
+	case p9Treaddir:
+		fid, off, count := r.u32(), r.u64(), r.u32()
+		f, errno := c.fid(fid)
+		if errno != 0 {
+			return p9Error(errno)
+		}
+		if !f.isDir {
+			return p9Error(p9ENOTDIR)
+		}
+		if off == 0 || f.entries == nil {
+			files, err := c.fs.list(ctx, f.path)
+			if err != nil {
+				return p9ErrorOf(err)
+			}
+			f.entries = files
+			c.update(fid, func(stored *p9Fid) { stored.entries = files })
+		}
+		return p9Readdir(&f, off, min(count, c.currentMsize()-p9HeaderSize-4))
+
+	case p9Tfsync:
+		f, errno := c.fid(r.u32())
+		if errno != 0 {
+			return p9Error(errno)
+		}
+		if f.file != nil {
+			if err := f.file.flush(ctx); err != nil {
+				return p9ErrorOf(err)
+			}
+		}
+		return newP9Writer(p9Tfsync + 1)
+
+	case p9Tclunk, p9Tremove:
+		fid := r.u32()
+		f, errno := c.fid(fid)
+		if errno != 0 {
+			return p9Error(errno)
+		}
+		c.mu.Lock()
+		delete(c.fids, fid)
+		c.mu.Unlock()
+
+		var err error
+		if f.file != nil {
+			err = f.file.flush(ctx)
+		}
+		if typ == p9Tremove && err == nil {
+			err = c.fs.remove(ctx, f.path, f.isDir)
+		}
+		if err != nil {
+			return p9ErrorOf(err)
+		}
+		return newP9Writer(typ + 1)
+
+	case p9Tmkdir:
+		dfid, name, mode := r.u32(), r.str(), r.u32()
+		r.u32() // gid
+		dir, errno := c.fid(dfid)
+		if errno != 0 {
+			return p9Error(errno)
+		}
+		p, errno := p9Child(dir.path, name)
+		if errno != 0 {
+			return p9Error(errno)
+		}
+		if err := c.fs.mkdir(ctx, p, mode&0777); err != nil {
+			return p9ErrorOf(err)
+		}
+		info, err := c.fs.stat(ctx, p)
+		if err != nil {
+			return p9ErrorOf(err)
+		}
+		w := newP9Writer(p9Tmkdir + 1)
+		w.qid(p, info)
+		return w
+
+	case p9Tunlinkat:
+		dfid, name, flags := r.u32(), r.str(), r.u32()
+		dir, errno := c.fid(dfid)
+		if errno != 0 {
+			return p9Error(errno)
+		}
+		p, errno := p9Child(dir.path, name)
+		if errno != 0 {
+			return p9Error(errno)
+		}
+		if err := c.fs.remove(ctx, p, flags&p9AtRemoveDir != 0); err != nil {
+			return p9ErrorOf(err)
+		}
+		return newP9Writer(p9Tunlinkat + 1)
+
+	case p9Trename:
+		fid, dfid, name := r.u32(), r.u32(), r.str()
+		f, errno := c.fid(fid)
+		if errno != 0 {
+			return p9Error(errno)
+		}
+		dir, errno := c.fid(dfid)
+		if errno != 0 {
+			return p9Error(errno)
+		}
+		return c.rename(ctx, f.path, dir.path, name, p9Trename+1)
+
+	case p9Trenameat:
+		oldDfid, oldName, newDfid, newName := r.u32(), r.str(), r.u32(), r.str()
+		oldDir, errno := c.fid(oldDfid)
+		if errno != 0 {
+			return p9Error(errno)
+		}
+		newDir, errno := c.fid(newDfid)
+		if errno != 0 {
+			return p9Error(errno)
+		}
+		oldPath, errno := p9Child(oldDir.path, oldName)
+		if errno != 0 {
+			return p9Error(errno)
+		}
+		return c.rename(ctx, oldPath, newDir.path, newName, p9Trenameat+1)
+
+	case p9Tstatfs:
+		if _, errno := c.fid(r.u32()); errno != 0 {
+			return p9Error(errno)
+		}
+		// The sharer does not report its free space
+		w := newP9Writer(p9Tstatfs + 1)
+		w.u32(p9StatfsMagic)
+		w.u32(4096)
+		for range 6 {
+			w.u64(0) // blocks, bfree, bavail, files, ffree, fsid
+		}
+		w.u32(255)
+		return w
+
+	case p9Tlock:
+		// Locks are not shared with the sharer, every lock is granted
+		w := newP9Writer(p9Tlock + 1)
+		w.u8(0)
+		return w
+
+	case p9Tgetlock:
+		r.u32() // fid
+		r.u8()  // type
+		start, length, procID, clientID := r.u64(), r.u64(), r.u32(), r.str()
+		w := newP9Writer(p9Tgetlock + 1)
+		w.u8(2) // F_UNLCK, nothing holds a lock
+		w.u64(start)
+		w.u64(length)
+		w.u32(procID)
+		w.str(clientID)
+		return w
+
+	default:
+		return p9Error(p9EOPNOTSUPP)
+	}
+}
+
+// walk follows names from fid and makes newFid the file reached. Only a
+// walk of every name counts; a partial one answers the steps taken.
+func (c *p9Conn) walk(ctx context.Context, fid, newFid uint32, names []string) *p9Writer {
+	f, errno := c.fid(fid)
+	if errno != 0 {
+		return p9Error(errno)
+	}
+
+	w := newP9Writer(p9Twalk + 1)
+	w.u16(uint16(len(names)))
+	p, isDir := f.path, f.isDir
+	for i, name := range names {
+		next, errno := p9Child(p, name)
+		if name == ".." {
+			next, errno = path.Dir(p), 0
+		}
+		var info protocol.FileInfo
+		var err error
+		if errno == 0 {
+			info, err = c.fs.stat(ctx, next)
+		}
+		if errno != 0 || err != nil {
+			if i == 0 {
+				if errno != 0 {
+					return p9Error(errno)
+				}
+				return p9ErrorOf(err)
+			}
+			binary.LittleEndian.PutUint16(w.b[p9HeaderSize:], uint16(i))
+			return w
+		}
+		w.qid(next, info)
+		p, isDir = next, info.IsDir
+	}
+
+	c.mu.Lock()
+	c.fids[newFid] = &p9Fid{path: p, isDir: isDir, uid: f.uid}
+	c.mu.Unlock()
+	return w
+}
+
+// open opens f for Tlopen, or for Tlcreate once the file exists
+func (c *p9Conn) open(ctx context.Context, f *p9Fid, flags uint32) (protocol.FileInfo, error) {
+	if flags&(p9OWronly|p9ORdwr|p9OTrunc|p9OAppend) != 0 {
+		if err := c.fs.writable(); err != nil {
+			return protocol.FileInfo{}, err
+		}
+	}
+	info, err := c.fs.stat(ctx, f.path)
+	if err != nil {
+		return protocol.FileInfo{}, err
+	}
+	if info.IsDir {
+		f.isDir = true
+		return info, nil
+	}
+	if flags&p9OTrunc != 0 {
+		if err := c.fs.truncate(ctx, f.path, 0); err != nil {
+			return protocol.FileInfo{}, err
+		}
+		info.Size = 0
+	}
+
+	f.file = c.fs.open(f.path)
+	f.append = flags&p9OAppend != 0
+	return info, nil
+}
+
+// rename moves oldPath into dir as name and points the fids below it at
+// their new place
+func (c *p9Conn) rename(ctx context.Context, oldPath, dir, name string, rtype byte) *p9Writer {
+	newPath, errno := p9Child(dir, name)
+	if errno != 0 {
+		return p9Error(errno)
+	}
+	if err := c.fs.rename(ctx, oldPath, newPath, false); err != nil {
+		return p9ErrorOf(err)
+	}
+
+	c.mu.Lock()
+	for _, f := range c.fids {
+		if f.path == oldPath || strings.HasPrefix(f.path, oldPath+"/") {
+			f.path = newPath + strings.TrimPrefix(f.path, oldPath)
+			if f.file != nil {
+				f.file.rename(f.path)
+			}
+		}
+	}
+	c.mu.Unlock()
+	return newP9Writer(rtype)
+}
+
+// fid returns a copy of a fid of the connection, which renames cannot
+// change while it is in use
+func (c *p9Conn) fid(fid uint32) (p9Fid, uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.fids[fid]
+	if !ok {
+		return p9Fid{}, p9EBADF
+	}
+	return *f, 0
+}
+
+// update applies fn to a fid of the connection, if it is still there
+func (c *p9Conn) update(fid uint32, fn func(f *p9Fid)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.fids[fid]; ok {
+		fn(f)
+	}
+}
+
+// p9Attr answers Tgetattr for f
+func p9Attr(f p9Fid, info protocol.FileInfo) *p9Writer {
+	w := newP9Writer(p9Tgetattr + 1)
+	w.u64(p9GetattrBasic)
+	w.qid(f.path, info)
+	w.u32(p9Mode(info))
+	w.u32(f.uid)
+	w.u32(f.uid)
+	w.u64(1)                                       // nlink
+	w.u64(0)                                       // rdev
+	w.u64(uint64(max(info.Size, 0)))               // size
+	w.u64(4096)                                    // blksize
+	w.u64((uint64(max(info.Size, 0)) + 511) / 512) // blocks
+	for range 3 {
+		w.u64(uint64(info.ModTime)) // atime, mtime and ctime
+		w.u64(0)
+	}
+	for range 4 {
+		w.u64(0) // btime, gen and data_version
+	}
+	return w
+}
+
+// p9Readdir answers Treaddir with the entries of f from off, as many as fit
+// in count bytes. Offsets are positions in the listing.
+func p9Readdir(f *p9Fid, off uint64, count uint32) *p9Writer {
+	w := newP9Writer(p9Treaddir + 1)
+	w.u32(0)
+	start := len(w.b)
+	for i := off; i < uint64(len(f.entries)); i++ {
+		entry := f.entries[i]
+		if len(w.b)-start+24+len(entry.Name) > int(count) {
+			break
+		}
+		w.qid(path.Join(f.path, entry.Name), entry)
+		w.u64(i + 1)
+		if entry.IsDir {
+			w.u8(4) // DT_DIR
+		} else {
+			w.u8(8) // DT_REG
+		}
+		w.str(entry.Name)
+	}
+	binary.LittleEndian.PutUint32(w.b[p9HeaderSize:], uint32(len(w.b)-start))
+	return w
+}
+
+// p9Child joins a name to a directory, refusing names that are not one
+// entry of it
+func p9Child(dir, name string) (string, uint32) {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return "", p9EINVAL
+	}
+	return path.Join(dir, name), 0
+}
+
+// p9Mode is the Linux mode of a file
+func p9Mode(info protocol.FileInfo) uint32 {
+	mode := uint32(os.FileMode(info.Mode).Perm())
+	if info.IsDir {
+		return mode | 0040000
+	}
+	return mode | 0100000
+}
+
+func p9Error(errno uint32) *p9Writer {
+	w := newP9Writer(p9Rlerror)
+	w.u32(errno)
+	return w
+}
+
+// p9ErrorOf answers with the error number of err
+func p9ErrorOf(err error) *p9Writer {
+	switch {
+	case errors.Is(err, errNotFound):
+		return p9Error(p9ENOENT)
+	case errors.Is(err, errExists):
+		return p9Error(p9EEXIST)
+	case errors.Is(err, errNotEmpty):
+		return p9Error(p9ENOTEMPTY)
+	case errors.Is(err, errIsDir):
+		return p9Error(p9EISDIR)
+	case errors.Is(err, errNotDir):
+		return p9Error(p9ENOTDIR)
+	case errors.Is(err, errAccess):
+		return p9Error(p9EACCES)
+	case errors.Is(err, errReadOnly):
+		return p9Error(p9EROFS)
+	case errors.Is(err, errUnsupported):
+		return p9Error(p9EOPNOTSUPP)
+	case errors.Is(err, context.Canceled):
+		return p9Error(p9EPERM)
+	default:
+		return p9Error(p9EIO)
+	}
+}
+
+// p9Writer builds a message
+type p9Writer struct {
+	b []byte
+}
+
+func newP9Writer(typ byte) *p9Writer {
+	// Room for the size and tag, filled in by message
+	return &p9Writer{b: []byte{0, 0, 0, 0, typ, 0, 0}}
+}
+
+func (w *p9Writer) u8(v uint8)   { w.b = append(w.b, v) }
+func (w *p9Writer) u16(v uint16) { w.b = binary.LittleEndian.AppendUint16(w.b, v) }
+func (w *p9Writer) u32(v uint32) { w.b = binary.LittleEndian.AppendUint32(w.b, v) }
+func (w *p9Writer) u64(v uint64) { w.b = binary.LittleEndian.AppendUint64(w.b, v) }
+
+func (w *p9Writer) str(s string) {
+	w.u16(uint16(len(s)))
+	w.b = append(w.b, s...)
+}
+
+// qid identifies a file by a hash of its path, which stays the same for as
+// long as the file is not moved
+func (w *p9Writer) qid(p string, info protocol.FileInfo) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(p))
+	if info.IsDir {
+		w.u8(0x80) // QTDIR
+	} else {
+		w.u8(0)
+	}
+	w.u32(uint32(info.ModTime))
+	w.u64(h.Sum64())
+}
+
+// message returns the message with its size and tag
+func (w *p9Writer) message(tag uint16) []byte {
+	binary.LittleEndian.PutUint32(w.b, uint32(len(w.b)))
+	binary.LittleEndian.PutUint16(w.b[5:], tag)
+	return w.b
+}
+
+// p9Reader reads the fields of a request. A request cut short sets err
+// and reads as zeros from then on.
+type p9Reader struct {
+	b   []byte
+	err error
+}
+
+func (r *p9Reader) take(n int) []byte {
+	if r.err != nil || n > len(r.b) || n < 0 {
+		r.err = io.ErrUnexpectedEOF
+		// Enough zeros for the numbers, lengths need not be honored
+		return make([]byte, 8)[:min(max(n, 0), 8)]
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *p9Reader) u8() uint8   { return r.take(1)[0] }
+func (r *p9Reader) u16() uint16 { return binary.LittleEndian.Uint16(r.take(2)) }
+func (r *p9Reader) u32() uint32 { return binary.LittleEndian.Uint32(r.take(4)) }
+func (r *p9Reader) u64() uint64 { return binary.LittleEndian.Uint64(r.take(8)) }
+func (r *p9Reader) str() string { return string(r.take(int(r.u16()))) }
@@ -0,0 +1,509 @@
+package mount
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// Failures the mount backends translate to the error codes of their platform
+var (
+	errNotFound    = errors.New("no such file or directory")
+	errExists      = errors.New("file exists")
+	errNotEmpty    = errors.New("directory not empty")
+	errIsDir       = errors.New("is a directory")
+	errNotDir      = errors.New("not a directory")
+	errAccess      = errors.New("permission denied")
+	errReadOnly    = errors.New("read-only share")
+	errUnsupported = errors.New("not supported by the share")
+)
+
+// maxInflight bounds the chunk requests one read-ahead or flush has open
+const maxInflight = 8
+
+// prefetchTimeout bounds a read-ahead in the background
+const prefetchTimeout = time.Minute
+
+// classify maps a failure reported by the sharer to one of the errors above.
+// Sharers only send a message for most failures, so it is matched on text.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	var remoteErr *protocol.ErrorResponse
+	if !errors.As(err, &remoteErr) {
+		return err
+	}
+	if remoteErr.Code == protocol.ErrCodeNotFound {
+		return errNotFound
+	}
+
+	msg := strings.ToLower(remoteErr.Message)
+	switch {
+	case strings.Contains(msg, "no such file"), strings.Contains(msg, "not shared"):
+		return errNotFound
+	case strings.Contains(msg, "file exists"):
+		return errExists
+	case strings.Contains(msg, "not empty"):
+		return errNotEmpty
+	case strings.Contains(msg, "not a directory"):
+		return errNotDir
+	case strings.Contains(msg, "is a directory"), strings.Contains(msg, "cannot read a directory"):
+		return errIsDir
+	case strings.Contains(msg, "permission denied"), strings.Contains(msg, "declined"),
+		strings.Contains(msg, "paused"), strings.Contains(msg, "cannot be changed"):
+		return errAccess
+	}
+	return err
+}
+
+// cachedAttr is a stat result, or a missing file, kept for AttrTimeout
+type cachedAttr struct {
+	info    protocol.FileInfo
+	missing bool
+	expires time.Time
+}
+
+// remoteFS is the platform independent part of a mount: cached attributes,
+// read-ahead and write-back on top of the remote client. Paths are absolute
+// paths of the share.
+type remoteFS struct {
+	client *remote.Client
+	opts   Options
+
+	mu    sync.Mutex
+	attrs map[string]cachedAttr
+	grown map[string]int64 // sizes of files with writes not yet sent
+}
+
+func newRemoteFS(client *remote.Client, opts Options) *remoteFS {
+	opts.setDefaults()
+	return &remoteFS{
+		client: client,
+		opts:   opts,
+		attrs:  make(map[string]cachedAttr),
+		grown:  make(map[string]int64),
+	}
+}
+
+// stat returns the attributes of p, from the cache while they are fresh
+func (r *remoteFS) stat(ctx context.Context, p string) (protocol.FileInfo, error) {
+	r.mu.Lock()
+	cached, ok := r.attrs[p]
+	r.mu.Unlock()
+
+	if !ok || time.Now().After(cached.expires) {
+		info, err := r.client.Stat(ctx, p)
+		err = classify(err)
+		if err != nil && !errors.Is(err, errNotFound) {
+			return protocol.FileInfo{}, err
+		}
+		cached = cachedAttr{missing: err != nil, expires: time.Now().Add(r.opts.AttrTimeout)}
+		if info != nil {
+			cached.info = *info
+		}
+		r.mu.Lock()
+		r.attrs[p] = cached
+		r.mu.Unlock()
+	}
+
+	if cached.missing {
+		return protocol.FileInfo{}, errNotFound
+	}
+	return r.withPending(p, cached.info), nil
+}
+
+// withPending grows the size of a file by the writes held for it
+func (r *remoteFS) withPending(p string, info protocol.FileInfo) protocol.FileInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if size, ok := r.grown[p]; ok && size > info.Size {
+		info.Size = size
+	}
+	if r.opts.ReadOnly {
+		info.Mode &^= 0222
+	}
+	return info
+}
+
+// list returns the entries of directory p and caches their attributes, so
+// that looking each of them up afterwards needs no further requests
+func (r *remoteFS) list(ctx context.Context, p string) ([]protocol.FileInfo, error) {
+	files, err := r.client.List(ctx, p)
+	if err != nil {
+		return nil, classify(err)
+	}
+
+	expires := time.Now().Add(r.opts.AttrTimeout)
+	r.mu.Lock()
+	for _, f := range files {
+		r.attrs[path.Join(p, f.Name)] = cachedAttr{info: f, expires: expires}
+	}
+	r.mu.Unlock()
+
+	for i := range files {
+		files[i] = r.withPending(path.Join(p, files[i].Name), files[i])
+	}
+	return files, nil
+}
+
+// invalidate drops the cached attributes of paths and their directories,
+// and the cached contents of paths
+func (r *remoteFS) invalidate(paths ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range paths {
+		delete(r.attrs, p)
+		delete(r.attrs, path.Dir(p))
+		r.opts.Cache.purge(p)
+	}
+}
+
+// writable fails for changes to a read-only share before asking the sharer
+func (r *remoteFS) writable() error {
+	if r.opts.ReadOnly {
+		return errReadOnly
+	}
+	return nil
+}
+
+// create makes an empty file, or leaves an existing one as it is
+func (r *remoteFS) create(ctx context.Context, p string) error {
+	if err := r.writable(); err != nil {
+		return err
+	}
+	defer r.invalidate(p)
+	_, err := r.client.Write(ctx, p, 0, nil)
+	return classify(err)
+}
+
+func (r *remoteFS) mkdir(ctx context.Context, p string, perm uint32) error {
+	if err := r.writable(); err != nil {
+		return err
+	}
+	if _, err := r.stat(ctx, p); err == nil {
+		return errExists
+	}
+	defer r.invalidate(p)
+	return classify(r.client.Mkdir(ctx, p, perm&0777))
+}
+
+// remove deletes a file, or an empty directory when dir is set. Sharers
+// delete directories with everything in them, so emptiness is checked here.
+func (r *remoteFS) remove(ctx context.Context, p string, dir bool) error {
+	if err := r.writable(); err != nil {
+		return err
+	}
+	info, err := r.stat(ctx, p)
+	if err != nil {
+		return err
+	}
+	switch {
+	case dir && !info.IsDir:
+		return errNotDir
+	case !dir && info.IsDir:
+		return errIsDir
+	case dir:
+		files, err := r.client.List(ctx, p)
+		if err != nil {
+			return classify(err)
+		}
+		if len(files) > 0 {
+			return errNotEmpty
+		}
+	}
+
+	defer r.invalidate(p)
+	return classify(r.client.Delete(ctx, p))
+}
+
+// rename moves oldPath to newPath, replacing newPath unless noReplace is set
+func (r *remoteFS) rename(ctx context.Context, oldPath, newPath string, noReplace bool) error {
+	if err := r.writable(); err != nil {
+		return err
+	}
+	if noReplace {
+		if _, err := r.stat(ctx, newPath); err == nil {
+			return errExists
+		}
+	}
+
+	defer r.invalidate(oldPath, newPath)
+	if err := classify(r.client.Rename(ctx, oldPath, newPath)); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	if size, ok := r.grown[oldPath]; ok {
+		r.grown[newPath] = size
+		delete(r.grown, oldPath)
+	}
+	r.mu.Unlock()
+	return nil
+}
+
+// truncate sets the size of a file. Sharers that predate truncation can
+// only make a file longer.
+func (r *remoteFS) truncate(ctx context.Context, p string, size int64) error {
+	if err := r.writable(); err != nil {
+		return err
+	}
+	info, err := r.stat(ctx, p)
+	if err != nil {
+		return err
+	}
+	if info.IsDir {
+		return errIsDir
+	}
+
+	if size == info.Size {
+		return nil
+	}
+
+	defer r.invalidate(p)
+	err = r.client.Truncate(ctx, p, size)
+	if errors.Is(err, remote.ErrNoTruncate) {
+		if size < info.Size {
+			return errUnsupported
+		}
+		_, err = r.client.Write(ctx, p, size-1, []byte{0})
+	}
+	return classify(err)
+}
+
+// openFile is an open file of a mount. Sequential reads are served from a
+// window fetched ahead of them, and writes that continue one another are
+// collected and sent together.
+type openFile struct {
+	fs   *remoteFS
+	path string
+
+	mu         sync.Mutex
+	window     []byte // data read ahead, starting at windowAt
+	at         int64
+	nextOff    int64 // where a sequential read continues
+	prefetched int64 // where the window fetched into the cache ends
+
+	dirty   []byte // data written but not yet sent, starting at dirtyAt
+	dirtyAt int64
+}
+
+func (r *remoteFS) open(p string) *openFile {
+	return &openFile{fs: r, path: p}
+}
+
+// rename follows a move of the open file
+func (f *openFile) rename(p string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.path = p
+}
+
+// readAt fills dest from off and returns how many bytes were read, fewer at
+// the end of the file
+func (f *openFile) readAt(ctx context.Context, dest []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Reads see the file as it will be once pending writes are sent
+	if err := f.flushLocked(ctx); err != nil {
+		return 0, err
+	}
+
+	if off < f.at || off+int64(len(dest)) > f.at+int64(len(f.window)) {
+		// Only reads that continue the previous one are read ahead for
+		size := int64(len(dest))
+		if off == f.nextOff {
+			size = max(size, int64(f.fs.opts.ReadAhead))
+		}
+		data, err := f.fs.fetch(ctx, f.path, off, size)
+		if err != nil {
+			return 0, err
+		}
+		f.window, f.at = data, off
+		if off == f.nextOff {
+			f.prefetch(off + int64(len(data)))
+		}
+	}
+
+	n := 0
+	if off < f.at+int64(len(f.window)) {
+		n = copy(dest, f.window[off-f.at:])
+	}
+	f.nextOff = off + int64(n)
+	return n, nil
+}
+
+// prefetch fetches the window from off into the cache in the background,
+// so that a file read from start to end does not wait for each window. It
+// only runs with a cache, once per window.
+func (f *openFile) prefetch(off int64) {
+	if f.fs.opts.Cache == nil || off <= f.prefetched {
+		return
+	}
+	f.prefetched = off + int64(f.fs.opts.ReadAhead)
+	go func(p string) {
+		ctx, cancel := context.WithTimeout(context.Background(), prefetchTimeout)
+		defer cancel()
+		if _, err := f.fs.fetch(ctx, p, off, int64(f.fs.opts.ReadAhead)); err != nil {
+			slog.Debug("read-ahead failed", "err", err)
+		}
+	}(f.path)
+}
+
+// fetch reads up to size bytes of p from off, in chunks requested side by
+// side, or taken from the cache. The result is shorter at the end of the
+// file.
+func (r *remoteFS) fetch(ctx context.Context, p string, off, size int64) ([]byte, error) {
+	// Sharers refuse reads that start past the end, so the window stops at
+	// the size last seen. A file that grew since is read further next time.
+	info, err := r.stat(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	size = min(size, info.Size-off)
+	if size <= 0 {
+		return nil, nil
+	}
+
+	// Whole chunks are read, so that they can be cached for reads that do
+	// not start where this one does
+	first := off - off%transfer.ChunkSize
+	span := min(off+size+transfer.ChunkSize-1-(off+size-1)%transfer.ChunkSize, info.Size) - first
+	buf := make([]byte, span)
+	lengths := make([]int, (span+transfer.ChunkSize-1)/transfer.ChunkSize)
+	err = eachChunk(ctx, span, func(ctx context.Context, i int, start, length int64) error {
+		data, err := r.opts.Cache.load(ctx, p, info, first+start, length, func() ([]byte, error) {
+			return r.client.Read(ctx, p, first+start, length)
+		})
+		if err != nil {
+			if strings.Contains(err.Error(), "invalid offset") {
+				// The file shrank since its size was seen
+				return nil
+			}
+			return classify(err)
+		}
+		lengths[i] = copy(buf[start:start+length], data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The file ends at the first short chunk
+	n := int64(0)
+	for _, length := range lengths {
+		n += int64(length)
+		if int64(length) < transfer.ChunkSize {
+			break
+		}
+	}
+	if n <= off-first {
+		return nil, nil
+	}
+	return buf[off-first : min(n, off-first+size)], nil
+}
+
+// writeAt holds data written at off, sending what was held before when it
+// does not continue it
+func (f *openFile) writeAt(ctx context.Context, data []byte, off int64) (int, error) {
+	if err := f.fs.writable(); err != nil {
+		return 0, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.dirty) > 0 && off != f.dirtyAt+int64(len(f.dirty)) {
+		if err := f.flushLocked(ctx); err != nil {
+			return 0, err
+		}
+	}
+	if len(f.dirty) == 0 {
+		f.dirtyAt = off
+	}
+	f.dirty = append(f.dirty, data...)
+	f.window = nil
+
+	f.fs.mu.Lock()
+	if end := off + int64(len(data)); end > f.fs.grown[f.path] {
+		f.fs.grown[f.path] = end
+	}
+	f.fs.mu.Unlock()
+
+	if len(f.dirty) >= f.fs.opts.WriteBack {
+		if err := f.flushLocked(ctx); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// flush sends the writes held for the file
+func (f *openFile) flush(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.flushLocked(ctx)
+}
+
+func (f *openFile) flushLocked(ctx context.Context) error {
+	if len(f.dirty) == 0 {
+		return nil
+	}
+	dirty, at := f.dirty, f.dirtyAt
+	f.dirty = nil
+
+	defer f.fs.invalidate(f.path)
+	err := eachChunk(ctx, int64(len(dirty)), func(ctx context.Context, _ int, start, length int64) error {
+		_, err := f.fs.client.Write(ctx, f.path, at+start, dirty[start:start+length])
+		return classify(err)
+	})
+
+	f.fs.mu.Lock()
+	delete(f.fs.grown, f.path)
+	f.fs.mu.Unlock()
+	return err
+}
+
+// eachChunk calls fn for every chunk of size bytes, up to maxInflight at a
+// time, and returns the first error
+func eachChunk(ctx context.Context, size int64, fn func(ctx context.Context, i int, start, length int64) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		sem      = make(chan struct{}, maxInflight)
+	)
+	for i, start := 0, int64(0); start < size; i, start = i+1, start+transfer.ChunkSize {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := fn(ctx, i, start, min(transfer.ChunkSize, size-start)); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
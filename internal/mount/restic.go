@@ -0,0 +1,395 @@
+package mount
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+)
+
+// Content types of the two versions of restic's REST protocol
+const (
+	resticV1 = "application/vnd.x.restic.rest.v1"
+	resticV2 = "application/vnd.x.restic.rest.v2"
+)
+
+// resticTypes are the directories of a restic repository
+var resticTypes = []string{"data", "keys", "locks", "snapshots", "index"}
+
+// ServeRestic serves the share behind client over restic's REST protocol on
+// ln until ctx is done or the tunnel closes, so that restic can keep a
+// repository in it. Any directory of the share can hold one, addressed by
+// its path. Clients log in with password over HTTP basic authentication,
+// any user name works.
+func ServeRestic(ctx context.Context, client *remote.Client, ln net.Listener, opts Options, password string) error {
+	srv := &http.Server{Handler: NewResticHandler(client, opts, password), ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-client.Mux().Done():
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("restic server failed: %w", err)
+	}
+	if err := client.Mux().Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("connection lost: %w", err)
+	}
+	return nil
+}
+
+// NewResticHandler serves the share behind client over restic's REST
+// protocol
+func NewResticHandler(client *remote.Client, opts Options, password string) http.Handler {
+	return &resticHandler{fs: newRemoteFS(client, opts), password: password}
+}
+
+// resticHandler answers the requests of restic's rest: backend. Objects are
+// laid out as restic's local backend does, so a repository can also be
+// used directly on the sharer's machine.
+type resticHandler struct {
+	fs       *remoteFS
+	password string
+}
+
+func (h *resticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, pass, ok := r.BasicAuth()
+	if !ok || subtle.ConstantTimeCompare([]byte(pass), []byte(h.password)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="orb"`)
+		http.Error(w, "the session passcode is required", http.StatusUnauthorized)
+		return
+	}
+
+	repo, kind, name := resticPath(r.URL.Path)
+	var err error
+	switch {
+	case kind == "" && r.Method == http.MethodPost && r.URL.Query().Get("create") == "true":
+		err = h.create(r.Context(), repo)
+	case kind == "config":
+		err = h.object(w, r, path.Join(repo, "config"), "")
+	case kind != "" && name == "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		err = h.list(w, r, repo, kind)
+	case kind != "" && name != "":
+		err = h.object(w, r, h.objectPath(repo, kind, name), name)
+	default:
+		http.Error(w, "not a request of the restic REST protocol", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.Debug("restic request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+		http.Error(w, err.Error(), resticStatus(err))
+	}
+}
+
+// resticPath splits a request path into the directory of the repository,
+// the type of object and its name, either of which may be empty
+func resticPath(p string) (repo, kind, name string) {
+	clean := path.Clean("/" + p)
+	dir, last := path.Split(clean)
+	dir = path.Clean(dir)
+
+	if last == "config" {
+		return dir, "config", ""
+	}
+	if isResticType(last) {
+		return dir, last, ""
+	}
+	if parent, kind := path.Split(dir); isResticType(kind) && validObjectName(last) {
+		return path.Clean(parent), kind, last
+	}
+	return clean, "", ""
+}
+
+func isResticType(s string) bool {
+	for _, t := range resticTypes {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
+
+// validObjectName accepts the hexadecimal IDs restic names objects by
+func validObjectName(name string) bool {
+	if name == "" || len(name) > 128 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// objectPath places data objects in subdirectories named after their first
+// two characters, like restic's local backend
+func (h *resticHandler) objectPath(repo, kind, name string) string {
+	if kind == "data" && len(name) >= 2 {
+		return path.Join(repo, kind, name[:2], name)
+	}
+	return path.Join(repo, kind, name)
+}
+
+// create makes the directories of a new repository
+func (h *resticHandler) create(ctx context.Context, repo string) error {
+	dirs := make([]string, 0, len(resticTypes)+256)
+	for _, t := range resticTypes {
+		dirs = append(dirs, path.Join(repo, t))
+	}
+	for i := 0; i < 256; i++ {
+		dirs = append(dirs, path.Join(repo, "data", fmt.Sprintf("%02x", i)))
+	}
+	for _, dir := range dirs {
+		if err := h.mkdirAll(ctx, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mkdirAll creates dir and the directories above it that are missing, the
+// sharer only creates directories in existing ones
+func (h *resticHandler) mkdirAll(ctx context.Context, dir string) error {
+	if dir == "/" {
+		return nil
+	}
+	info, err := h.fs.stat(ctx, dir)
+	switch {
+	case err == nil && info.IsDir:
+		return nil
+	case err == nil:
+		return errNotDir
+	case !errors.Is(err, errNotFound):
+		return err
+	}
+	if err := h.mkdirAll(ctx, path.Dir(dir)); err != nil {
+		return err
+	}
+	if err := h.fs.mkdir(ctx, dir, 0700); err != nil && !errors.Is(err, errExists) {
+		return err
+	}
+	return nil
+}
+
+// resticEntry is an object in a listing of version 2 of the protocol
+type resticEntry struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// list answers with the objects of one type
+func (h *resticHandler) list(w http.ResponseWriter, r *http.Request, repo, kind string) error {
+	ctx := r.Context()
+	dir := path.Join(repo, kind)
+	files, err := h.fs.list(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	var entries []resticEntry
+	for _, f := range files {
+		if !f.IsDir {
+			if validObjectName(f.Name) {
+				entries = append(entries, resticEntry{Name: f.Name, Size: f.Size})
+			}
+			continue
+		}
+		if kind != "data" || len(f.Name) != 2 {
+			continue
+		}
+		sub, err := h.fs.list(ctx, path.Join(dir, f.Name))
+		if err != nil {
+			return err
+		}
+		for _, s := range sub {
+			if !s.IsDir && validObjectName(s.Name) {
+				entries = append(entries, resticEntry{Name: s.Name, Size: s.Size})
+			}
+		}
+	}
+
+	var body any = entries
+	contentType := resticV2
+	if !strings.Contains(r.Header.Get("Accept"), resticV2) {
+		names := make([]string, 0, len(entries))
+		for _, e := range entries {
+			names = append(names, e.Name)
+		}
+		body, contentType = names, resticV1
+	}
+	if len(entries) == 0 {
+		body = []string{}
+	}
+	w.Header().Set("Content-Type", contentType)
+	return json.NewEncoder(w).Encode(body)
+}
+
+// object reads, writes or deletes one object. name, if set, is checked
+// against the SHA-256 checksum of uploads.
+func (h *resticHandler) object(w http.ResponseWriter, r *http.Request, p, name string) error {
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		info, err := h.fs.stat(ctx, p)
+		if err != nil {
+			return err
+		}
+		if info.IsDir {
+			return errIsDir
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", time.Unix(info.ModTime, 0), &resticReader{ctx: ctx, file: h.fs.open(p), size: info.Size})
+		return nil
+
+	case http.MethodPost:
+		if err := h.upload(ctx, r.Body, p, name); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusOK)
+		return nil
+
+	case http.MethodDelete:
+		if err := h.fs.remove(ctx, p, false); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+	return errMethod
+}
+
+// upload stores body at p. It is written under a temporary name and moved
+// into place once complete, so that an interrupted backup leaves no partial
+// object behind.
+func (h *resticHandler) upload(ctx context.Context, body io.Reader, p, name string) error {
+	if err := h.fs.writable(); err != nil {
+		return err
+	}
+	if _, err := h.fs.stat(ctx, p); err == nil {
+		return errExists
+	}
+	if err := h.mkdirAll(ctx, path.Dir(p)); err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	tmp := path.Join(path.Dir(p), ".orb-upload-"+path.Base(p)+"-"+hex.EncodeToString(suffix))
+	if err := h.fs.create(ctx, tmp); err != nil {
+		return err
+	}
+	done := false
+	defer func() {
+		if !done {
+			_ = h.fs.remove(context.WithoutCancel(ctx), tmp, false)
+		}
+	}()
+
+	file := h.fs.open(tmp)
+	sum := sha256.New()
+	buf := make([]byte, transfer.ChunkSize)
+	var off int64
+	for {
+		n, err := io.ReadFull(body, buf)
+		if n > 0 {
+			sum.Write(buf[:n])
+			if _, err := file.writeAt(ctx, buf[:n], off); err != nil {
+				return err
+			}
+			off += int64(n)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := file.flush(ctx); err != nil {
+		return err
+	}
+	if name != "" && hex.EncodeToString(sum.Sum(nil)) != name {
+		return errChecksum
+	}
+
+	if err := h.fs.rename(ctx, tmp, p, true); err != nil {
+		return err
+	}
+	done = true
+	return nil
+}
+
+var (
+	errMethod   = errors.New("method not allowed")
+	errChecksum = errors.New("the upload does not match its name, it was damaged on the way")
+)
+
+// resticStatus picks the HTTP status of a failure
+func resticStatus(err error) int {
+	switch {
+	case errors.Is(err, errNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errExists), errors.Is(err, errReadOnly), errors.Is(err, errAccess):
+		return http.StatusForbidden
+	case errors.Is(err, errMethod):
+		return http.StatusMethodNotAllowed
+	case errors.Is(err, errChecksum), errors.Is(err, errIsDir):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// resticReader lets http.ServeContent read an object, ranges included
+type resticReader struct {
+	ctx  context.Context
+	file *openFile
+	size int64
+	off  int64
+}
+
+func (r *resticReader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	n, err := r.file.readAt(r.ctx, p[:min(int64(len(p)), r.size-r.off)], r.off)
+	r.off += int64(n)
+	if err == nil && n == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *resticReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.off = offset
+	return offset, nil
+}
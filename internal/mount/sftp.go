@@ -0,0 +1,123 @@
+package mount
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"golang.org/x/crypto/ssh"
+)
+
+// SFTPConfig is what ServeSFTP needs besides the share
+type SFTPConfig struct {
+	// HostKey identifies the server to SSH clients
+	HostKey ssh.Signer
+	// Password is accepted for any user name
+	Password string
+}
+
+// ServeSFTP serves the share behind client over SFTP on ln until ctx is
+// done or the tunnel closes, so that SFTP clients and tools built on them
+// can use it unmodified
+func ServeSFTP(ctx context.Context, client *remote.Client, ln net.Listener, opts Options, cfg SFTPConfig) error {
+	sshCfg := &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if subtle.ConstantTimeCompare(password, []byte(cfg.Password)) == 1 {
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+	}
+	sshCfg.AddHostKey(cfg.HostKey)
+	rfs := newRemoteFS(client, opts)
+
+	return serveConns(ctx, client, ln, func(conn net.Conn) {
+		serveSSH(ctx, conn, sshCfg, rfs)
+	})
+}
+
+// serveSSH runs the SFTP subsystem for the sessions of one SSH connection
+func serveSSH(ctx context.Context, conn net.Conn, cfg *ssh.ServerConfig, rfs *remoteFS) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		slog.Debug("sftp handshake failed", "remote", conn.RemoteAddr(), "err", err)
+		_ = conn.Close()
+		return
+	}
+	defer func() { _ = sconn.Close() }()
+	slog.Debug("sftp client connected", "remote", conn.RemoteAddr(), "user", sconn.User())
+	go ssh.DiscardRequests(reqs)
+
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			_ = newCh.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		go serveChannel(ctx, ch, chReqs, rfs)
+	}
+}
+
+// serveChannel starts SFTP when a session asks for the subsystem and
+// refuses shells, commands and everything else
+func serveChannel(ctx context.Context, ch ssh.Channel, reqs <-chan *ssh.Request, rfs *remoteFS) {
+	for req := range reqs {
+		var subsystem struct{ Name string }
+		if req.Type != "subsystem" || ssh.Unmarshal(req.Payload, &subsystem) != nil || subsystem.Name != "sftp" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		_ = req.Reply(true, nil)
+
+		go func() {
+			err := newSFTPSession(rfs).serve(ctx, ch)
+			if err != nil && !errors.Is(err, io.EOF) {
+				slog.Debug("sftp session ended", "err", err)
+			}
+			_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+			_ = ch.Close()
+		}()
+	}
+}
+
+// LoadHostKey reads the SSH host key at path, creating an Ed25519 key there
+// when there is none, so that clients see the same key every time
+func LoadHostKey(path string) (ssh.Signer, error) {
+	// #nosec G304 -- the key lives in the configuration directory
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return ssh.ParsePrivateKey(data)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read host key: %w", err)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate host key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "orb sftp host key")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode host key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write host key: %w", err)
+	}
+	return ssh.NewSignerFromKey(key)
+}
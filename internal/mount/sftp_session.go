@@ -0,0 +1,528 @@
+package mount
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// Packet types of SFTP version 3 (draft-ietf-secsh-filexfer-02)
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpWrite    = 6
+	sftpLstat    = 7
+	sftpFstat    = 8
+	sftpSetstat  = 9
+	sftpFsetstat = 10
+	sftpOpendir  = 11
+	sftpReaddir  = 12
+	sftpRemove   = 13
+	sftpMkdir    = 14
+	sftpRmdir    = 15
+	sftpRealpath = 16
+	sftpStat     = 17
+	sftpRename   = 18
+	sftpReadlink = 19
+	sftpSymlink  = 20
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpName     = 104
+	sftpAttrs    = 105
+	sftpExtended = 200
+)
+
+// Status codes
+const (
+	sftpOK               = 0
+	sftpEOF              = 1
+	sftpNoSuchFile       = 2
+	sftpPermissionDenied = 3
+	sftpFailure          = 4
+	sftpBadMessage       = 5
+	sftpOpUnsupported    = 8
+)
+
+// Flags of OPEN
+const (
+	sftpFlagRead   = 0x01
+	sftpFlagWrite  = 0x02
+	sftpFlagAppend = 0x04
+	sftpFlagCreate = 0x08
+	sftpFlagTrunc  = 0x10
+	sftpFlagExcl   = 0x20
+)
+
+// Flags of file attributes
+const (
+	sftpAttrSize        = 0x01
+	sftpAttrUIDGID      = 0x02
+	sftpAttrPermissions = 0x04
+	sftpAttrTimes       = 0x08
+	sftpAttrExtended    = 0x80000000
+)
+
+const (
+	// sftpMaxPacket bounds the packets accepted from clients, which send
+	// writes of 32 KB and a few more for OpenSSH's larger requests
+	sftpMaxPacket = 1024 * 1024
+	// sftpMaxRead bounds the data returned by one READ
+	sftpMaxRead = 256 * 1024
+	// sftpDirBatch is the number of entries sent per READDIR
+	sftpDirBatch = 100
+)
+
+// sftpSession serves one SFTP subsystem. Requests are answered in order.
+type sftpSession struct {
+	fs      *remoteFS
+	handles map[string]any // *sftpFile or *sftpDir
+	next    int
+}
+
+// sftpFile is an open file
+type sftpFile struct {
+	*openFile
+	append bool
+}
+
+// sftpDir is an open directory and the entries not yet read from it
+type sftpDir struct {
+	path    string
+	entries []protocol.FileInfo
+}
+
+func newSFTPSession(rfs *remoteFS) *sftpSession {
+	return &sftpSession{fs: rfs, handles: make(map[string]any)}
+}
+
+// serve answers requests from rw until it closes. Files still open then
+// are flushed.
+func (s *sftpSession) serve(ctx context.Context, rw io.ReadWriter) error {
+	defer func() {
+		for _, h := range s.handles {
+			if f, ok := h.(*sftpFile); ok {
+				_ = f.flush(ctx)
+			}
+		}
+	}()
+
+	var header [4]byte
+	for {
+		if _, err := io.ReadFull(rw, header[:]); err != nil {
+			return err
+		}
+		size := binary.BigEndian.Uint32(header[:])
+		if size == 0 || size > sftpMaxPacket {
+			return fmt.Errorf("sftp packet of %d bytes", size)
+		}
+		packet := make([]byte, size)
+		if _, err := io.ReadFull(rw, packet); err != nil {
+			return err
+		}
+
+		resp := s.handle(ctx, packet[0], &sftpReader{b: packet[1:]})
+		if _, err := rw.Write(resp.packet()); err != nil {
+			return err
+		}
+	}
+}
+
+// handle answers one request
+func (s *sftpSession) handle(ctx context.Context, typ byte, r *sftpReader) *sftpWriter {
+	if typ == sftpInit {
+		w := newSFTPWriter(sftpVersion)
+		w.u32(3)
+		w.str("posix-rename@openssh.com")
+		w.str("1")
+		return w
+	}
+
+	id := r.u32()
+	resp := s.request(ctx, typ, id, r)
+	if r.err != nil {
+		return sftpStatusReply(id, sftpBadMessage, "malformed request")
+	}
+	return resp
+}
+
+func (s *sftpSession) request(ctx context.Context, typ byte, id uint32, r *sftpReader) *sftpWriter {
+	switch typ {
+	case sftpRealpath:
+		p := sftpPath(r.str())
+		w := newSFTPWriter(sftpName)
+		w.u32(id)
+		w.u32(1)
+		w.str(p)
+		w.str(p)
+		w.u32(0)
+		return w
+
+	case sftpStat, sftpLstat:
+		info, err := s.fs.stat(ctx, sftpPath(r.str()))
+		if err != nil {
+			return sftpErrorReply(id, err)
+		}
+		return sftpAttrsReply(id, info)
+
+	case sftpFstat:
+		f, err := s.file(r.str())
+		if err != nil {
+			return sftpErrorReply(id, err)
+		}
+		info, err := s.fs.stat(ctx, f.path)
+		if err != nil {
+			return sftpErrorReply(id, err)
+		}
+		return sftpAttrsReply(id, info)
+
+	case sftpOpendir:
+		p := sftpPath(r.str())
+		files, err := s.fs.list(ctx, p)
+		if err != nil {
+			return sftpErrorReply(id, err)
+		}
+		return s.newHandle(id, &sftpDir{path: p, entries: files})
+
+	case sftpReaddir:
+		d, ok := s.handles[r.str()].(*sftpDir)
+		if !ok {
+			return sftpStatusReply(id, sftpFailure, "invalid handle")
+		}
+		if len(d.entries) == 0 {
+			return sftpStatusReply(id, sftpEOF, "")
+		}
+		batch := d.entries[:min(sftpDirBatch, len(d.entries))]
+		d.entries = d.entries[len(batch):]
+
+		w := newSFTPWriter(sftpName)
+		w.u32(id)
+		w.u32(uint32(len(batch)))
+		for _, f := range batch {
+			w.str(f.Name)
+			w.str(sftpLongName(f))
+			w.attrs(f)
+		}
+		return w
+
+	case sftpOpen:
+		p, flags := sftpPath(r.str()), r.u32()
+		r.attrs()
+		if r.err != nil {
+			return nil
+		}
+		f, err := s.open(ctx, p, flags)
+		if err != nil {
+			return sftpErrorReply(id, err)
+		}
+		return s.newHandle(id, f)
+
+	case sftpClose:
+		handle := r.str()
+		h, ok := s.handles[handle]
+		if !ok {
+			return sftpStatusReply(id, sftpFailure, "invalid handle")
+		}
+		delete(s.handles, handle)
+		if f, ok := h.(*sftpFile); ok {
+			if err := f.flush(ctx); err != nil {
+				return sftpErrorReply(id, err)
+			}
+		}
+		return sftpStatusReply(id, sftpOK, "")
+
+	case sftpRead:
+		handle, off, length := r.str(), r.u64(), r.u32()
+		f, err := s.file(handle)
+		if err != nil {
+			return sftpErrorReply(id, err)
+		}
+		buf := make([]byte, min(length, sftpMaxRead))
+		n, err := f.readAt(ctx, buf, int64(off))
+		if err != nil {
+			return sftpErrorReply(id, err)
+		}
+		if n == 0 {
+			return sftpStatusReply(id, sftpEOF, "")
+		}
+		w := newSFTPWriter(sftpData)
+		w.u32(id)
+		w.bytes(buf[:n])
+		return w
+
+	case sftpWrite:
+		handle, off, data := r.str(), int64(r.u64()), r.bytesField()
+		f, err := s.file(handle)
+		if err != nil {
+			return sftpErrorReply(id, err)
+		}
+		if f.append {
+			info, err := s.fs.stat(ctx, f.path)
+			if err != nil {
+				return sftpErrorReply(id, err)
+			}
+			off = info.Size
+		}
+		if _, err := f.writeAt(ctx, data, off); err != nil {
+			return sftpErrorReply(id, err)
+		}
+		return sftpStatusReply(id, sftpOK, "")
+
+	case sftpSetstat, sftpFsetstat:
+		var p string
+		if typ == sftpSetstat {
+			p = sftpPath(r.str())
+		} else {
+			f, err := s.file(r.str())
+			if err != nil {
+				return sftpErrorReply(id, err)
+			}
+			if err := f.flush(ctx); err != nil {
+				return sftpErrorReply(id, err)
+			}
+			p = f.path
+		}
+		// Only the size can change, modes, owners and times stay with
+		// the sharer. Clients that copy them along are not failed for it.
+		if size, ok := r.attrs(); ok {
+			if err := s.fs.truncate(ctx, p, size); err != nil {
+				return sftpErrorReply(id, err)
+			}
+		}
+		return sftpStatusReply(id, sftpOK, "")
+
+	case sftpRemove:
+		return sftpErrorReply(id, s.fs.remove(ctx, sftpPath(r.str()), false))
+
+	case sftpRmdir:
+		return sftpErrorReply(id, s.fs.remove(ctx, sftpPath(r.str()), true))
+
+	case sftpMkdir:
+		p := sftpPath(r.str())
+		r.attrs()
+		return sftpErrorReply(id, s.fs.mkdir(ctx, p, 0755))
+
+	case sftpRename:
+		// Version 3 renames never replace the target
+		oldPath, newPath := sftpPath(r.str()), sftpPath(r.str())
+		return sftpErrorReply(id, s.fs.rename(ctx, oldPath, newPath, true))
+
+	case sftpExtended:
+		if r.str() != "posix-rename@openssh.com" {
+			return sftpStatusReply(id, sftpOpUnsupported, "unsupported extension")
+		}
+		oldPath, newPath := sftpPath(r.str()), sftpPath(r.str())
+		return sftpErrorReply(id, s.fs.rename(ctx, oldPath, newPath, false))
+
+	case sftpReadlink, sftpSymlink:
+		return sftpStatusReply(id, sftpOpUnsupported, "shares have no symbolic links")
+
+	default:
+		return sftpStatusReply(id, sftpOpUnsupported, "unsupported request")
+	}
+}
+
+// open opens the file p with the flags of an OPEN request
+func (s *sftpSession) open(ctx context.Context, p string, flags uint32) (*sftpFile, error) {
+	if flags&(sftpFlagWrite|sftpFlagAppend|sftpFlagCreate|sftpFlagTrunc) != 0 {
+		if err := s.fs.writable(); err != nil {
+			return nil, err
+		}
+	}
+
+	info, err := s.fs.stat(ctx, p)
+	switch {
+	case errors.Is(err, errNotFound) && flags&sftpFlagCreate != 0:
+		if err := s.fs.create(ctx, p); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case info.IsDir:
+		return nil, errIsDir
+	case flags&(sftpFlagCreate|sftpFlagExcl) == sftpFlagCreate|sftpFlagExcl:
+		return nil, errExists
+	case flags&sftpFlagTrunc != 0:
+		if err := s.fs.truncate(ctx, p, 0); err != nil {
+			return nil, err
+		}
+	}
+	return &sftpFile{openFile: s.fs.open(p), append: flags&sftpFlagAppend != 0}, nil
+}
+
+// newHandle registers an open file or directory and replies with its handle
+func (s *sftpSession) newHandle(id uint32, h any) *sftpWriter {
+	s.next++
+	handle := strconv.Itoa(s.next)
+	s.handles[handle] = h
+
+	w := newSFTPWriter(sftpHandle)
+	w.u32(id)
+	w.str(handle)
+	return w
+}
+
+// file returns the open file of a handle
+func (s *sftpSession) file(handle string) (*sftpFile, error) {
+	switch h := s.handles[handle].(type) {
+	case *sftpFile:
+		return h, nil
+	case *sftpDir:
+		return nil, errIsDir
+	default:
+		return nil, errors.New("invalid handle")
+	}
+}
+
+// sftpPath makes a path of a request absolute, the share being both root
+// and home directory
+func sftpPath(p string) string {
+	return path.Join("/", p)
+}
+
+// sftpLongName is the ls -l line of an entry, which clients show as is
+func sftpLongName(f protocol.FileInfo) string {
+	owner := f.Owner
+	if owner == "" {
+		owner = "orb"
+	}
+	mode := os.FileMode(f.Mode)
+	if f.IsDir {
+		mode |= os.ModeDir
+	}
+	return fmt.Sprintf("%s 1 %-8s %-8s %12d %s %s",
+		mode.String(), owner, owner, f.Size, time.Unix(f.ModTime, 0).Format("Jan _2 15:04"), f.Name)
+}
+
+func sftpStatusReply(id, code uint32, msg string) *sftpWriter {
+	w := newSFTPWriter(sftpStatus)
+	w.u32(id)
+	w.u32(code)
+	w.str(msg)
+	w.str("")
+	return w
+}
+
+// sftpErrorReply answers with the status of err, OK when it is nil
+func sftpErrorReply(id uint32, err error) *sftpWriter {
+	switch {
+	case err == nil:
+		return sftpStatusReply(id, sftpOK, "")
+	case errors.Is(err, errNotFound):
+		return sftpStatusReply(id, sftpNoSuchFile, err.Error())
+	case errors.Is(err, errAccess), errors.Is(err, errReadOnly):
+		return sftpStatusReply(id, sftpPermissionDenied, err.Error())
+	case errors.Is(err, errUnsupported):
+		return sftpStatusReply(id, sftpOpUnsupported, err.Error())
+	default:
+		return sftpStatusReply(id, sftpFailure, err.Error())
+	}
+}
+
+func sftpAttrsReply(id uint32, info protocol.FileInfo) *sftpWriter {
+	w := newSFTPWriter(sftpAttrs)
+	w.u32(id)
+	w.attrs(info)
+	return w
+}
+
+// sftpWriter builds a packet
+type sftpWriter struct {
+	b []byte
+}
+
+func newSFTPWriter(typ byte) *sftpWriter {
+	// Room for the length, filled in by packet
+	return &sftpWriter{b: []byte{0, 0, 0, 0, typ}}
+}
+
+func (w *sftpWriter) u32(v uint32) { w.b = binary.BigEndian.AppendUint32(w.b, v) }
+func (w *sftpWriter) u64(v uint64) { w.b = binary.BigEndian.AppendUint64(w.b, v) }
+func (w *sftpWriter) str(s string) { w.bytes([]byte(s)) }
+
+func (w *sftpWriter) bytes(b []byte) {
+	w.u32(uint32(len(b)))
+	w.b = append(w.b, b...)
+}
+
+// attrs writes the size, permissions and times of a file
+func (w *sftpWriter) attrs(info protocol.FileInfo) {
+	perm := uint32(os.FileMode(info.Mode).Perm())
+	if info.IsDir {
+		perm |= 0040000
+	} else {
+		perm |= 0100000
+	}
+	w.u32(sftpAttrSize | sftpAttrPermissions | sftpAttrTimes)
+	w.u64(uint64(max(info.Size, 0)))
+	w.u32(perm)
+	w.u32(uint32(info.ModTime))
+	w.u32(uint32(info.ModTime))
+}
+
+// packet returns the packet with its length
+func (w *sftpWriter) packet() []byte {
+	binary.BigEndian.PutUint32(w.b, uint32(len(w.b)-4))
+	return w.b
+}
+
+// sftpReader reads the fields of a request. A request cut short sets err
+// and reads as zeros from then on.
+type sftpReader struct {
+	b   []byte
+	err error
+}
+
+func (r *sftpReader) take(n int) []byte {
+	if r.err != nil || n > len(r.b) || n < 0 {
+		r.err = io.ErrUnexpectedEOF
+		// Enough zeros for the numbers, lengths need not be honored
+		return make([]byte, 8)[:min(max(n, 0), 8)]
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *sftpReader) u32() uint32        { return binary.BigEndian.Uint32(r.take(4)) }
+func (r *sftpReader) u64() uint64        { return binary.BigEndian.Uint64(r.take(8)) }
+func (r *sftpReader) str() string        { return string(r.bytesField()) }
+func (r *sftpReader) bytesField() []byte { return r.take(int(r.u32())) }
+
+// attrs reads file attributes and returns the size among them, if any
+func (r *sftpReader) attrs() (int64, bool) {
+	flags := r.u32()
+	var size uint64
+	if flags&sftpAttrSize != 0 {
+		size = r.u64()
+	}
+	if flags&sftpAttrUIDGID != 0 {
+		r.u32()
+		r.u32()
+	}
+	if flags&sftpAttrPermissions != 0 {
+		r.u32()
+	}
+	if flags&sftpAttrTimes != 0 {
+		r.u32()
+		r.u32()
+	}
+	if flags&sftpAttrExtended != 0 {
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			r.str()
+			r.str()
+		}
+	}
+	return int64(size), flags&sftpAttrSize != 0
+}
@@ -0,0 +1,268 @@
+package mount
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+	"golang.org/x/net/webdav"
+)
+
+// ServeWebDAV serves the share behind client as a WebDAV endpoint on ln
+// until ctx is done or the tunnel closes. File managers of every platform
+// can mount it without a driver.
+func ServeWebDAV(ctx context.Context, client *remote.Client, ln net.Listener, opts Options) error {
+	srv := &http.Server{Handler: NewWebDAVHandler(client, "", opts), ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-client.Mux().Done():
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("webdav server failed: %w", err)
+	}
+	if err := client.Mux().Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("connection lost: %w", err)
+	}
+	return nil
+}
+
+// NewWebDAVHandler serves the share behind client over WebDAV below the URL
+// path prefix. GET requests support ranges, so it also serves downloads.
+func NewWebDAVHandler(client *remote.Client, prefix string, opts Options) http.Handler {
+	return &webdav.Handler{
+		Prefix:     prefix,
+		FileSystem: &davFS{fs: newRemoteFS(client, opts)},
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				slog.Debug("webdav request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+			}
+		},
+	}
+}
+
+// davFS adapts a share to the file system of the WebDAV handler
+type davFS struct {
+	fs *remoteFS
+}
+
+func (d *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return davError("mkdir", name, d.fs.mkdir(ctx, davPath(name), uint32(perm)))
+}
+
+func (d *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	p := davPath(name)
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		if err := d.fs.writable(); err != nil {
+			return nil, davError("open", name, err)
+		}
+	}
+
+	info, err := d.fs.stat(ctx, p)
+	switch {
+	case errors.Is(err, errNotFound) && flag&os.O_CREATE != 0:
+		if err := d.fs.create(ctx, p); err != nil {
+			return nil, davError("open", name, err)
+		}
+		if info, err = d.fs.stat(ctx, p); err != nil {
+			return nil, davError("open", name, err)
+		}
+	case err != nil:
+		return nil, davError("open", name, err)
+	case flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, davError("open", name, errExists)
+	case flag&os.O_TRUNC != 0 && !info.IsDir:
+		if err := d.fs.truncate(ctx, p, 0); err != nil {
+			return nil, davError("open", name, err)
+		}
+		info.Size = 0
+	}
+
+	return &davFile{ctx: ctx, openFile: d.fs.open(p), info: info}, nil
+}
+
+// RemoveAll deletes a file or a directory with everything in it, which is
+// what the sharer does for any delete
+func (d *davFS) RemoveAll(ctx context.Context, name string) error {
+	if err := d.fs.writable(); err != nil {
+		return davError("remove", name, err)
+	}
+	p := davPath(name)
+	defer d.fs.invalidate(p)
+	return davError("remove", name, classify(d.fs.client.Delete(ctx, p)))
+}
+
+func (d *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	return davError("rename", oldName, d.fs.rename(ctx, davPath(oldName), davPath(newName), false))
+}
+
+func (d *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	info, err := d.fs.stat(ctx, davPath(name))
+	if err != nil {
+		return nil, davError("stat", name, err)
+	}
+	return davInfo{info: info, name: path.Base(davPath(name))}, nil
+}
+
+// davFile is an open file or directory. WebDAV reads and writes files as
+// streams, so it keeps the offset the next call continues from.
+type davFile struct {
+	ctx context.Context
+	*openFile
+	info   protocol.FileInfo
+	offset int64
+	listed []fs.FileInfo // entries not yet returned by Readdir
+	read   bool          // whether listed has been filled
+}
+
+func (f *davFile) Read(p []byte) (int, error) {
+	if f.info.IsDir {
+		return 0, davError("read", f.path, errIsDir)
+	}
+	n, err := f.readAt(f.ctx, p, f.offset)
+	if err != nil {
+		return n, davError("read", f.path, err)
+	}
+	f.offset += int64(n)
+	if n == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (f *davFile) Write(p []byte) (int, error) {
+	n, err := f.writeAt(f.ctx, p, f.offset)
+	if err != nil {
+		return n, davError("write", f.path, err)
+	}
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *davFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		info, err := f.fs.stat(f.ctx, f.path)
+		if err != nil {
+			return 0, davError("seek", f.path, err)
+		}
+		offset += info.Size
+	default:
+		return 0, fmt.Errorf("seek %s: invalid whence %d", f.path, whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("seek %s: negative offset", f.path)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *davFile) Readdir(count int) ([]fs.FileInfo, error) {
+	if !f.info.IsDir {
+		return nil, davError("readdir", f.path, errNotDir)
+	}
+	if !f.read {
+		files, err := f.fs.list(f.ctx, f.path)
+		if err != nil {
+			return nil, davError("readdir", f.path, err)
+		}
+		for _, file := range files {
+			f.listed = append(f.listed, davInfo{info: file, name: file.Name})
+		}
+		f.read = true
+	}
+
+	if count <= 0 {
+		listed := f.listed
+		f.listed = nil
+		return listed, nil
+	}
+	if len(f.listed) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(f.listed))
+	listed := f.listed[:n]
+	f.listed = f.listed[n:]
+	return listed, nil
+}
+
+func (f *davFile) Stat() (fs.FileInfo, error) {
+	info, err := f.fs.stat(f.ctx, f.path)
+	if err != nil {
+		return nil, davError("stat", f.path, err)
+	}
+	return davInfo{info: info, name: path.Base(f.path)}, nil
+}
+
+// Close sends what was written. PUT requests learn about failures here.
+func (f *davFile) Close() error {
+	return davError("close", f.path, f.flush(f.ctx))
+}
+
+// davInfo presents the attributes of a file as the WebDAV handler wants
+// them
+type davInfo struct {
+	info protocol.FileInfo
+	name string
+}
+
+func (i davInfo) Name() string       { return i.name }
+func (i davInfo) Size() int64        { return i.info.Size }
+func (i davInfo) Mode() fs.FileMode  { return fs.FileMode(i.info.Mode) }
+func (i davInfo) ModTime() time.Time { return time.Unix(i.info.ModTime, 0) }
+func (i davInfo) IsDir() bool        { return i.info.IsDir }
+func (i davInfo) Sys() any           { return nil }
+
+// ContentType guesses the type from the name. Without it the handler reads
+// the start of every file it lists to sniff the type.
+func (i davInfo) ContentType(context.Context) (string, error) {
+	if ctype := mime.TypeByExtension(path.Ext(i.name)); ctype != "" {
+		return ctype, nil
+	}
+	return "application/octet-stream", nil
+}
+
+// davPath cleans a path of a WebDAV request into a path of the share
+func davPath(name string) string {
+	return path.Join("/", name)
+}
+
+// davError turns a failure into the errors of package os, which the WebDAV
+// handler maps to status codes
+func davError(op, name string, err error) error {
+	var target error
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, errNotFound):
+		target = os.ErrNotExist
+	case errors.Is(err, errExists):
+		target = os.ErrExist
+	case errors.Is(err, errAccess), errors.Is(err, errReadOnly):
+		target = os.ErrPermission
+	default:
+		return err
+	}
+	return &os.PathError{Op: op, Path: name, Err: target}
+}
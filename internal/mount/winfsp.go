@@ -0,0 +1,435 @@
+//go:build windows
+
+package mount
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Zayan-Mohamed/orb/internal/filesystem"
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+	"github.com/winfsp/cgofuse/fuse"
+)
+
+// Supported reports whether this platform has a mount backend
+const Supported = true
+
+// CheckMountPoint fails unless mountPoint is a free drive letter such as
+// X:, or a path that does not exist yet in an existing directory. WinFsp
+// creates the mount point itself.
+func CheckMountPoint(mountPoint string) error {
+	if isDriveLetter(mountPoint) {
+		if _, err := os.Stat(mountPoint + `\`); err == nil {
+			return fmt.Errorf("drive %s is already in use", mountPoint)
+		}
+		return nil
+	}
+	if _, err := os.Stat(mountPoint); err == nil {
+		return fmt.Errorf("mount point %s must not exist yet, or be a drive letter such as X:", mountPoint)
+	}
+	if info, err := os.Stat(filepath.Dir(mountPoint)); err != nil || !info.IsDir() {
+		return fmt.Errorf("mount point %s must be in an existing directory", mountPoint)
+	}
+	return nil
+}
+
+func isDriveLetter(p string) bool {
+	return len(p) == 2 && p[1] == ':' && (p[0]|0x20 >= 'a' && p[0]|0x20 <= 'z')
+}
+
+// Mount serves the share behind client at mountPoint through WinFsp until
+// ctx is done or the tunnel closes, then unmounts it
+func Mount(ctx context.Context, client *remote.Client, mountPoint string, opts Options) (err error) {
+	w := &winFS{
+		fs:      newRemoteFS(client, opts),
+		ctx:     ctx,
+		ready:   make(chan struct{}),
+		handles: make(map[uint64]*openFile),
+	}
+	host := fuse.NewFileSystemHost(w)
+	// Windows programs look files up in any case, the sharer may not
+	host.SetCapCaseInsensitive(true)
+	host.SetCapReaddirPlus(true)
+
+	mountOpts := []string{"-o", fmt.Sprintf("uid=-1,gid=-1,volname=orb,FileInfoTimeout=%d", w.fs.opts.AttrTimeout.Milliseconds())}
+
+	// cgofuse panics when the WinFsp DLL cannot be loaded
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to mount at %s: %v, install WinFsp from https://winfsp.dev", mountPoint, r)
+		}
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-client.Mux().Done():
+		case <-done:
+			return
+		}
+		// The host can only unmount once it is mounted
+		select {
+		case <-w.ready:
+			host.Unmount()
+		case <-done:
+		}
+	}()
+
+	if !host.Mount(mountPoint, mountOpts) {
+		return fmt.Errorf("failed to mount at %s", mountPoint)
+	}
+
+	if err := client.Mux().Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("connection lost: %w", err)
+	}
+	return nil
+}
+
+// winFS is the share as WinFsp sees it. Paths arrive with slashes and in
+// whatever case a program used, and names in the look-alikes Windows
+// allows instead of the characters it forbids.
+type winFS struct {
+	fuse.FileSystemBase
+	fs    *remoteFS
+	ctx   context.Context
+	ready chan struct{} // closed once mounted
+
+	mu      sync.Mutex
+	handles map[uint64]*openFile
+	next    uint64
+}
+
+var (
+	_ fuse.FileSystemInterface = (*winFS)(nil)
+	_ fuse.FileSystemGetpath   = (*winFS)(nil)
+	_ fuse.FileSystemRename3   = (*winFS)(nil)
+	_ fuse.FileSystemChflags   = (*winFS)(nil)
+	_ fuse.FileSystemSetcrtime = (*winFS)(nil)
+)
+
+func (w *winFS) Init() {
+	close(w.ready)
+}
+
+// resolve turns a path as WinFsp passes it into the path of the share,
+// matching names in another case when there is no exact match. Paths that
+// match nothing are returned as they are, for creating them.
+func (w *winFS) resolve(p string) string {
+	p = filesystem.WirePath(path.Clean("/" + p))
+	if p == "/" {
+		return p
+	}
+	if _, err := w.fs.stat(w.ctx, p); !errors.Is(err, errNotFound) {
+		return p
+	}
+
+	dir, name := w.resolve(path.Dir(p)), path.Base(p)
+	files, err := w.fs.list(w.ctx, dir)
+	if err == nil {
+		for _, f := range files {
+			if strings.EqualFold(protocol.NormalizeName(f.Name), name) {
+				return path.Join(dir, f.Name)
+			}
+		}
+	}
+	return path.Join(dir, name)
+}
+
+// localPath is p of the share with the names Windows shows for it
+func localPath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = filesystem.LocalName(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// Getpath reports the case the sharer uses for a path
+func (w *winFS) Getpath(p string, fh uint64) (int, string) {
+	return 0, localPath(w.resolve(p))
+}
+
+// Statfs reports room for anything, the free space of the sharer is not
+// known and Explorer refuses to copy files that would not fit
+func (w *winFS) Statfs(p string, stat *fuse.Statfs_t) int {
+	const blockSize = 4096
+	*stat = fuse.Statfs_t{
+		Bsize:   blockSize,
+		Frsize:  blockSize,
+		Blocks:  1 << 40 / blockSize,
+		Bfree:   1 << 40 / blockSize,
+		Bavail:  1 << 40 / blockSize,
+		Namemax: 255,
+	}
+	return 0
+}
+
+func (w *winFS) Getattr(p string, stat *fuse.Stat_t, fh uint64) int {
+	p = w.resolve(p)
+	info, err := w.fs.stat(w.ctx, p)
+	if err != nil {
+		return errc(err)
+	}
+	fillStat(path.Base(p), info, stat)
+	return 0
+}
+
+func (w *winFS) Opendir(p string) (int, uint64) {
+	info, err := w.fs.stat(w.ctx, w.resolve(p))
+	switch {
+	case err != nil:
+		return errc(err), ^uint64(0)
+	case !info.IsDir:
+		return -fuse.ENOTDIR, ^uint64(0)
+	}
+	return 0, 0
+}
+
+func (w *winFS) Readdir(p string, fill func(name string, stat *fuse.Stat_t, ofst int64) bool, ofst int64, fh uint64) int {
+	files, err := w.fs.list(w.ctx, w.resolve(p))
+	if err != nil {
+		return errc(err)
+	}
+	fill(".", nil, 0)
+	fill("..", nil, 0)
+	for _, f := range files {
+		var stat fuse.Stat_t
+		fillStat(f.Name, f, &stat)
+		if !fill(filesystem.LocalName(f.Name), &stat, 0) {
+			break
+		}
+	}
+	return 0
+}
+
+func (w *winFS) Open(p string, flags int) (int, uint64) {
+	if flags&fuse.O_ACCMODE != fuse.O_RDONLY {
+		if err := w.fs.writable(); err != nil {
+			return errc(err), ^uint64(0)
+		}
+	}
+	p = w.resolve(p)
+	info, err := w.fs.stat(w.ctx, p)
+	switch {
+	case err != nil:
+		return errc(err), ^uint64(0)
+	case info.IsDir:
+		return -fuse.EISDIR, ^uint64(0)
+	}
+	if flags&fuse.O_TRUNC != 0 {
+		if err := w.fs.truncate(w.ctx, p, 0); err != nil {
+			return errc(err), ^uint64(0)
+		}
+	}
+	return 0, w.addHandle(p)
+}
+
+func (w *winFS) Create(p string, flags int, mode uint32) (int, uint64) {
+	p = w.resolve(p)
+	if flags&fuse.O_EXCL != 0 {
+		if _, err := w.fs.stat(w.ctx, p); err == nil {
+			return -fuse.EEXIST, ^uint64(0)
+		}
+	}
+	if err := w.fs.create(w.ctx, p); err != nil {
+		return errc(err), ^uint64(0)
+	}
+	if flags&fuse.O_TRUNC != 0 {
+		if err := w.fs.truncate(w.ctx, p, 0); err != nil {
+			return errc(err), ^uint64(0)
+		}
+	}
+	return 0, w.addHandle(p)
+}
+
+func (w *winFS) addHandle(p string) uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.next++
+	w.handles[w.next] = w.fs.open(p)
+	return w.next
+}
+
+func (w *winFS) handle(fh uint64) *openFile {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.handles[fh]
+}
+
+func (w *winFS) Read(p string, buff []byte, ofst int64, fh uint64) int {
+	f := w.handle(fh)
+	if f == nil {
+		return -fuse.EBADF
+	}
+	n, err := f.readAt(w.ctx, buff, ofst)
+	if err != nil {
+		return errc(err)
+	}
+	return n
+}
+
+func (w *winFS) Write(p string, buff []byte, ofst int64, fh uint64) int {
+	f := w.handle(fh)
+	if f == nil {
+		return -fuse.EBADF
+	}
+	n, err := f.writeAt(w.ctx, buff, ofst)
+	if err != nil {
+		return errc(err)
+	}
+	return n
+}
+
+func (w *winFS) Flush(p string, fh uint64) int {
+	if f := w.handle(fh); f != nil {
+		return errc(f.flush(w.ctx))
+	}
+	return 0
+}
+
+func (w *winFS) Fsync(p string, datasync bool, fh uint64) int {
+	return w.Flush(p, fh)
+}
+
+// Release sends what is left once the file is closed. Its errors cannot
+// reach the program that wrote the data any more, Flush reported them.
+func (w *winFS) Release(p string, fh uint64) int {
+	w.mu.Lock()
+	f := w.handles[fh]
+	delete(w.handles, fh)
+	w.mu.Unlock()
+	if f == nil {
+		return 0
+	}
+	return errc(f.flush(w.ctx))
+}
+
+// Truncate changes the size of a file. Windows sets sizes this way for
+// open files too.
+func (w *winFS) Truncate(p string, size int64, fh uint64) int {
+	if f := w.handle(fh); f != nil {
+		if err := f.flush(w.ctx); err != nil {
+			return errc(err)
+		}
+	}
+	return errc(w.fs.truncate(w.ctx, w.resolve(p), size))
+}
+
+func (w *winFS) Mkdir(p string, mode uint32) int {
+	return errc(w.fs.mkdir(w.ctx, w.resolve(p), mode))
+}
+
+func (w *winFS) Unlink(p string) int {
+	return errc(w.fs.remove(w.ctx, w.resolve(p), false))
+}
+
+func (w *winFS) Rmdir(p string) int {
+	return errc(w.fs.remove(w.ctx, w.resolve(p), true))
+}
+
+func (w *winFS) Rename(oldPath, newPath string) int {
+	return w.Rename3(oldPath, newPath, 0)
+}
+
+func (w *winFS) Rename3(oldPath, newPath string, flags uint32) int {
+	if flags&fuse.RENAME_EXCHANGE != 0 {
+		return -fuse.ENOTSUP
+	}
+	from, to := w.resolve(oldPath), w.resolve(newPath)
+	// Renaming a file to another case of its name finds the file itself
+	if to == from {
+		to = filesystem.WirePath(path.Clean("/" + newPath))
+		if to == from {
+			return 0
+		}
+	}
+	if err := w.fs.rename(w.ctx, from, to, flags&fuse.RENAME_NOREPLACE != 0); err != nil {
+		return errc(err)
+	}
+
+	// Open handles follow the move of their file or of a directory above it
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, f := range w.handles {
+		f.mu.Lock()
+		if f.path == from || strings.HasPrefix(f.path, from+"/") {
+			f.path = to + strings.TrimPrefix(f.path, from)
+		}
+		f.mu.Unlock()
+	}
+	return 0
+}
+
+// Modes, owners, times and attributes are kept by the sharer and left as
+// they are. Succeeding keeps programs that copy them working.
+
+func (w *winFS) Chmod(p string, mode uint32) int                 { return 0 }
+func (w *winFS) Chown(p string, uid, gid uint32) int             { return 0 }
+func (w *winFS) Utimens(p string, tmsp []fuse.Timespec) int      { return 0 }
+func (w *winFS) Chflags(p string, flags uint32) int              { return 0 }
+func (w *winFS) Setcrtime(p string, tmsp fuse.Timespec) int      { return 0 }
+func (w *winFS) Access(p string, mask uint32) int                { return 0 }
+func (w *winFS) Releasedir(p string, fh uint64) int              { return 0 }
+func (w *winFS) Fsyncdir(p string, datasync bool, fh uint64) int { return 0 }
+
+// fillStat converts the attributes the sharer reports. Files that cannot
+// be written are read-only to Windows, and dot files hidden as they are
+// elsewhere.
+func fillStat(name string, info protocol.FileInfo, out *fuse.Stat_t) {
+	mode := os.FileMode(info.Mode)
+	out.Mode = uint32(mode.Perm())
+	if info.IsDir {
+		out.Mode |= fuse.S_IFDIR
+	} else {
+		out.Mode |= fuse.S_IFREG
+		if mode&0200 == 0 {
+			out.Flags |= fuse.UF_READONLY
+		}
+	}
+	if strings.HasPrefix(name, ".") && name != "." && name != ".." {
+		out.Flags |= fuse.UF_HIDDEN
+	}
+	out.Size = max(info.Size, 0)
+	out.Blocks = (out.Size + 511) / 512
+	out.Blksize = 4096
+	out.Nlink = 1
+	mtime := fuse.Timespec{Sec: info.ModTime}
+	out.Mtim, out.Ctim, out.Atim, out.Birthtim = mtime, mtime, mtime, mtime
+}
+
+// errc maps a failure to the negated error code returned to WinFsp
+func errc(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errNotFound):
+		return -fuse.ENOENT
+	case errors.Is(err, errExists):
+		return -fuse.EEXIST
+	case errors.Is(err, errNotEmpty):
+		return -fuse.ENOTEMPTY
+	case errors.Is(err, errIsDir):
+		return -fuse.EISDIR
+	case errors.Is(err, errNotDir):
+		return -fuse.ENOTDIR
+	case errors.Is(err, errAccess):
+		return -fuse.EACCES
+	case errors.Is(err, errReadOnly):
+		return -fuse.EROFS
+	case errors.Is(err, errUnsupported):
+		return -fuse.ENOTSUP
+	case errors.Is(err, context.Canceled):
+		return -fuse.EINTR
+	default:
+		return -fuse.EIO
+	}
+}
@@ -0,0 +1,277 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// hopDialTimeout bounds connecting to the relay behind a hop
+	hopDialTimeout = 10 * time.Second
+	// hopChunk bounds the messages passed back to the client
+	hopChunk = 32 * 1024
+	// anyPublicRelay in the hop targets stands for any public address
+	anyPublicRelay = "*"
+	// hopRelayTTL is how long a target of "*" that answered as a relay is
+	// trusted before it is checked again
+	hopRelayTTL = 10 * time.Minute
+)
+
+// errNotPublic is returned for hops to loopback, private and other local
+// addresses, which "*" does not cover
+var errNotPublic = errors.New("not a public address")
+
+// errNotRelay is returned for targets of "*" that do not answer as a relay
+var errNotRelay = errors.New("not a relay")
+
+// carrierGradeNAT is the shared address space of RFC 6598, which is not
+// public although IsPrivate does not cover it
+var carrierGradeNAT = netip.MustParsePrefix("100.64.0.0/10")
+
+// ForwardHops lets clients reach the given relays through this one with
+// "--via", so that those relays see this relay's address instead of the
+// client's. Targets are host:port or relay URLs, "*" stands for any relay at
+// a public address, which has to answer a probe before clients reach it.
+func (rs *RelayServer) ForwardHops(targets []string) error {
+	rs.hopTargets = nil
+	rs.hopRelays = make(map[string]time.Time)
+	for _, raw := range targets {
+		if raw == anyPublicRelay {
+			rs.hopTargets = append(rs.hopTargets, anyPublicRelay)
+			continue
+		}
+		target, err := hopTarget(raw)
+		if err != nil {
+			return err
+		}
+		rs.hopTargets = append(rs.hopTargets, target)
+	}
+	return nil
+}
+
+// hopTarget turns a relay URL or host:port into the host:port hops dial
+func hopTarget(raw string) (string, error) {
+	if strings.Contains(raw, "://") {
+		u, err := url.Parse(raw)
+		if err != nil || u.Hostname() == "" {
+			return "", fmt.Errorf("invalid hop target %q", raw)
+		}
+		port := u.Port()
+		if port == "" {
+			port = "80"
+			if u.Scheme == "https" || u.Scheme == "wss" {
+				port = "443"
+			}
+		}
+		return strings.ToLower(net.JoinHostPort(u.Hostname(), port)), nil
+	}
+	host, port, err := net.SplitHostPort(raw)
+	if err != nil || host == "" || port == "" {
+		return "", fmt.Errorf("invalid hop target %q, expected host:port or a relay URL", raw)
+	}
+	return strings.ToLower(net.JoinHostPort(host, port)), nil
+}
+
+// hopDialer returns the dialer for a hop to target, or false when this
+// relay does not forward there. Relays listed by name may be local, "*"
+// only reaches public addresses, checked once resolved, and is marked by
+// anyRelay so that the target is checked to be a relay.
+func (rs *RelayServer) hopDialer(target string) (dialer *net.Dialer, anyRelay, ok bool) {
+	target, err := hopTarget(target)
+	if err != nil {
+		return nil, false, false
+	}
+	if slices.Contains(rs.hopTargets, target) {
+		return &net.Dialer{}, false, true
+	}
+	if !slices.Contains(rs.hopTargets, anyPublicRelay) {
+		return nil, false, false
+	}
+	return &net.Dialer{Control: func(_, address string, _ syscall.RawConn) error {
+		addr, err := netip.ParseAddrPort(address)
+		if err != nil {
+			return err
+		}
+		ip := addr.Addr().Unmap()
+		if ip.IsLoopback() || ip.IsPrivate() || carrierGradeNAT.Contains(ip) || ip.IsUnspecified() ||
+			ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+			return errNotPublic
+		}
+		return nil
+	}}, true, true
+}
+
+// checkRelay makes sure that target, a target of "*", is a relay, so that
+// "*" cannot be used to reach any other server. The target has to echo a
+// probe, over TLS or else in the clear, as relays do for clients choosing
+// one. Targets that did are not checked again for hopRelayTTL.
+func (rs *RelayServer) checkRelay(ctx context.Context, dialer *net.Dialer, target string) error {
+	rs.hopMu.Lock()
+	checked, ok := rs.hopRelays[target]
+	rs.hopMu.Unlock()
+	if ok && time.Since(checked) < hopRelayTTL {
+		return nil
+	}
+
+	err := probeRelay(ctx, dialer, "wss", target)
+	if err != nil {
+		err = probeRelay(ctx, dialer, "ws", target)
+	}
+	if err != nil {
+		return err
+	}
+
+	rs.hopMu.Lock()
+	defer rs.hopMu.Unlock()
+	for t, at := range rs.hopRelays {
+		if time.Since(at) >= hopRelayTTL {
+			delete(rs.hopRelays, t)
+		}
+	}
+	rs.hopRelays[target] = time.Now()
+	return nil
+}
+
+// probeRelay has target echo a message on /probe over a WebSocket of scheme
+func probeRelay(ctx context.Context, dialer *net.Dialer, scheme, target string) error {
+	d := websocket.Dialer{NetDialContext: dialer.DialContext, HandshakeTimeout: hopDialTimeout}
+	conn, resp, err := d.DialContext(ctx, scheme+"://"+target+"/probe", nil)
+	if resp != nil && resp.Body != nil {
+		_ = resp.Body.Close()
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetReadDeadline(deadline)
+		_ = conn.SetWriteDeadline(deadline)
+	}
+	probe := []byte("orb hop")
+	if err := conn.WriteMessage(websocket.BinaryMessage, probe); err != nil {
+		return err
+	}
+	_, echo, err := conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(echo, probe) {
+		return errNotRelay
+	}
+	return nil
+}
+
+// HandleHop passes the connection of a client on to the relay named by
+// ?to=. The client speaks to that relay through the WebSocket, with TLS for
+// an https relay, so this relay learns which relay the client uses but not
+// the session or anything in it, and the relay behind sees this relay's
+// address instead of the client's.
+func (rs *RelayServer) HandleHop(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("to")
+	dialer, anyRelay, ok := rs.hopDialer(target)
+	if !ok {
+		http.Error(w, "this relay does not forward to "+target, http.StatusForbidden)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), hopDialTimeout)
+	if anyRelay {
+		if err := rs.checkRelay(ctx, dialer, target); err != nil {
+			cancel()
+			slog.Debug("hop target is not a relay", "err", err)
+			http.Error(w, "this relay does not forward to "+target+", it does not answer as a relay", http.StatusForbidden)
+			return
+		}
+	}
+	upstream, err := dialer.DialContext(ctx, "tcp", target)
+	cancel()
+	if err != nil {
+		slog.Debug("hop failed", "err", err)
+		http.Error(w, "failed to reach "+target, http.StatusBadGateway)
+		return
+	}
+	defer func() { _ = upstream.Close() }()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Warn("failed to upgrade connection", "err", err)
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	// Neither the client's address nor the target is logged, the point of
+	// a hop is that no relay keeps both ends
+	slog.Debug("hop opened")
+
+	conn.SetReadLimit(maxMessageSize)
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(pingPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+					return
+				}
+			case <-done:
+				return
+			case <-rs.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// From the relay behind back to the client
+	go func() {
+		buf := make([]byte, hopChunk)
+		for {
+			n, err := upstream.Read(buf)
+			if n > 0 {
+				_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); err != nil {
+					break
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+		_ = conn.Close()
+	}()
+
+	var forwarded int64
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		_ = upstream.SetWriteDeadline(time.Now().Add(writeWait))
+		if _, err := upstream.Write(data); err != nil {
+			break
+		}
+		forwarded += int64(len(data))
+	}
+	slog.Debug("hop closed", "bytes", forwarded)
+}
@@ -2,13 +2,21 @@ package relay
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/Zayan-Mohamed/orb/internal/session"
+	"github.com/Zayan-Mohamed/orb/internal/telemetry"
 	"github.com/gorilla/websocket"
 )
 
@@ -32,10 +40,17 @@ var upgrader = websocket.Upgrader{
 // RelayServer is the blind relay server that forwards encrypted bytes
 type RelayServer struct {
 	sessionManager *session.SessionManager
+	token          string   // required from clients when set
+	webDir         string   // browser receiver served at /receive/, if set
+	store          *Store   // files kept for later pickup, if set
+	hopTargets     []string // relays clients may reach through this one
 	connections    map[string]*ConnectionPair
 	mu             sync.RWMutex
 	ctx            context.Context
 	cancel         context.CancelFunc
+
+	hopMu     sync.Mutex
+	hopRelays map[string]time.Time // targets of "*" found to be relays, and when
 }
 
 // ConnectionPair represents a sharer-receiver connection pair
@@ -83,7 +98,7 @@ func (rs *RelayServer) HandleShare(w http.ResponseWriter, r *http.Request) {
 	// Upgrade to WebSocket
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("Failed to upgrade connection: %v", err)
+		slog.Warn("failed to upgrade connection", "err", err)
 		return
 	}
 
@@ -110,10 +125,11 @@ func (rs *RelayServer) HandleShare(w http.ResponseWriter, r *http.Request) {
 	}
 	rs.mu.Unlock()
 
-	log.Printf("Sharer connected: session=%s", sessionID)
+	slog.Info("sharer connected", "session", sessionID)
 
 	// Start message forwarding
-	go rs.forwardMessages(conn, sessionID, true)
+	span := traceHTTP(r, "relay forward", slog.String("orb.session", sessionID), slog.String("orb.relay.endpoint", "share"))
+	go rs.forwardMessages(conn, sessionID, true, span)
 	go rs.keepAlive(conn)
 
 	// Update session activity
@@ -141,7 +157,7 @@ func (rs *RelayServer) HandleConnect(w http.ResponseWriter, r *http.Request) {
 	// Upgrade to WebSocket
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("Failed to upgrade connection: %v", err)
+		slog.Warn("failed to upgrade connection", "err", err)
 		return
 	}
 
@@ -168,32 +184,65 @@ func (rs *RelayServer) HandleConnect(w http.ResponseWriter, r *http.Request) {
 	}
 	rs.mu.Unlock()
 
-	log.Printf("Receiver connected: session=%s", sessionID)
+	slog.Info("receiver connected", "session", sessionID)
 
 	// Start message forwarding
-	go rs.forwardMessages(conn, sessionID, false)
+	span := traceHTTP(r, "relay forward", slog.String("orb.session", sessionID), slog.String("orb.relay.endpoint", "connect"))
+	go rs.forwardMessages(conn, sessionID, false, span)
 	go rs.keepAlive(conn)
 
 	// Update session activity
 	rs.sessionManager.UpdateActivity(sessionID)
 }
 
+// traceHTTP starts a span for serving r, in the trace of the client when it
+// sent a traceparent
+func traceHTTP(r *http.Request, name string, attrs ...slog.Attr) *telemetry.Span {
+	parent := telemetry.ParseTraceparent(r.Header.Get("Traceparent"))
+	_, span := telemetry.Start(telemetry.ContextWithRemote(context.Background(), parent), name, telemetry.KindServer, attrs...)
+	return span
+}
+
 // forwardMessages forwards encrypted messages between peers
 // The relay server never sees plaintext - it's a blind pipe
-func (rs *RelayServer) forwardMessages(conn *websocket.Conn, sessionID string, isSharer bool) {
+func (rs *RelayServer) forwardMessages(conn *websocket.Conn, sessionID string, isSharer bool, span *telemetry.Span) {
+	// reason is the one a peer gave for leaving, passed on to the other
+	var reason string
+	// What the span records: counts and timings, never content
+	var (
+		messages, forwarded, dropped int64
+		writeTime                    time.Duration
+		failure                      error
+	)
+	defer func() {
+		span.SetAttributes(
+			slog.Int64("orb.relay.messages", messages),
+			slog.Int64("orb.relay.bytes", forwarded),
+			slog.Int64("orb.relay.dropped", dropped),
+			slog.Int64("orb.relay.write_ms", writeTime.Milliseconds()),
+		)
+		span.End(failure)
+	}()
 	defer func() {
-		if err := conn.Close(); err != nil {
-			log.Printf("Warning: failed to close connection: %v", err)
+		// A revoked session has been closed already
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			slog.Warn("failed to close connection", "err", err)
 		}
 		rs.cleanupConnection(sessionID, isSharer)
+		rs.closePeer(sessionID, isSharer, reason)
 	}()
 
 	for {
 		// Read encrypted message (the relay is blind to content)
 		messageType, message, err := conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) && closeErr.Code == websocket.CloseNormalClosure {
+				reason = closeErr.Text
+			}
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				slog.Warn("websocket error", "session", sessionID, "err", err)
+				failure = err
 			}
 			break
 		}
@@ -217,13 +266,21 @@ func (rs *RelayServer) forwardMessages(conn *websocket.Conn, sessionID string, i
 			target = pair.Sharer
 		}
 
+		messages++
 		if target != nil {
 			_ = target.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := target.WriteMessage(messageType, message); err != nil {
-				log.Printf("Failed to forward message: %v", err)
+			start := time.Now()
+			err := target.WriteMessage(messageType, message)
+			writeTime += time.Since(start)
+			if err != nil {
+				slog.Warn("failed to forward message", "session", sessionID, "err", err)
+				failure = err
 				pair.mu.Unlock()
 				break
 			}
+			forwarded += int64(len(message))
+		} else {
+			dropped++
 		}
 		pair.mu.Unlock()
 
@@ -232,6 +289,35 @@ func (rs *RelayServer) forwardMessages(conn *websocket.Conn, sessionID string, i
 	}
 }
 
+// closePeer ends the other side of a session once one side has left. A
+// session carries a single tunnel, so the peer could only wait forever.
+// A reason the leaving side gave is passed on.
+func (rs *RelayServer) closePeer(sessionID string, isSharer bool, reason string) {
+	rs.mu.RLock()
+	pair, exists := rs.connections[sessionID]
+	rs.mu.RUnlock()
+	if !exists {
+		return
+	}
+
+	pair.mu.Lock()
+	defer pair.mu.Unlock()
+
+	peer, left := pair.Sharer, "receiver disconnected"
+	if isSharer {
+		peer, left = pair.Receiver, "sharer disconnected"
+	}
+	if reason == "" {
+		reason = left
+	}
+	if peer == nil {
+		return
+	}
+
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason)
+	_ = peer.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+}
+
 // keepAlive sends periodic pings to keep connection alive
 func (rs *RelayServer) keepAlive(conn *websocket.Conn) {
 	ticker := time.NewTicker(pingPeriod)
@@ -269,7 +355,7 @@ func (rs *RelayServer) cleanupConnection(sessionID string, isSharer bool) {
 	// If both connections are gone, remove the pair
 	if pair.Sharer == nil && pair.Receiver == nil {
 		delete(rs.connections, sessionID)
-		log.Printf("Session closed: %s", sessionID)
+		slog.Info("session closed", "session", sessionID)
 	}
 }
 
@@ -288,16 +374,16 @@ func (rs *RelayServer) monitorConnections() {
 				if now.Sub(pair.lastPing) > 30*time.Minute {
 					if pair.Sharer != nil {
 						if err := pair.Sharer.Close(); err != nil {
-							log.Printf("Warning: failed to close sharer connection: %v", err)
+							slog.Warn("failed to close sharer connection", "err", err)
 						}
 					}
 					if pair.Receiver != nil {
 						if err := pair.Receiver.Close(); err != nil {
-							log.Printf("Warning: failed to close receiver connection: %v", err)
+							slog.Warn("failed to close receiver connection", "err", err)
 						}
 					}
 					delete(rs.connections, sessionID)
-					log.Printf("Removed stale connection: %s", sessionID)
+					slog.Info("removed stale connection", "session", sessionID)
 				}
 			}
 			rs.mu.Unlock()
@@ -314,8 +400,13 @@ func (rs *RelayServer) HandleCreateSession(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	span := traceHTTP(r, "relay session create")
+	defer span.End(nil)
+
 	var req struct {
 		SharedPath string `json:"shared_path"`
+		// TTLSeconds shortens the lifetime of the session, 0 keeps the default
+		TTLSeconds int64 `json:"ttl_seconds"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -324,31 +415,280 @@ func (rs *RelayServer) HandleCreateSession(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Create session
-	sess, err := rs.sessionManager.CreateSession(req.SharedPath)
+	if req.TTLSeconds < 0 {
+		http.Error(w, "invalid ttl", http.StatusBadRequest)
+		return
+	}
+	sess, err := rs.sessionManager.CreateSession(req.SharedPath, time.Duration(req.TTLSeconds)*time.Second)
 	if err != nil {
+		span.End(err)
 		http.Error(w, "failed to create session", http.StatusInternalServerError)
 		return
 	}
+	span.SetAttributes(slog.String("orb.session", sess.ID))
 
 	// Return session details
 	response := map[string]string{
 		"session_id": sess.ID,
 		"passcode":   sess.Passcode,
+		"expires_at": sess.Expires.UTC().Format(time.RFC3339),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(response)
 
 	// Never log passcodes (security requirement)
-	log.Printf("Session created: %s", sess.ID)
+	slog.Info("session created", "session", sess.ID)
+}
+
+// Probes answer a few small messages, enough to time a round trip
+const (
+	probeMessages   = 4
+	probeMessageMax = 64
+	probeTimeout    = 10 * time.Second
+)
+
+// HandleProbe echoes the messages of a WebSocket back, so that clients can
+// time a round trip through the same path as a tunnel before choosing a
+// relay
+func (rs *RelayServer) HandleProbe(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Debug("failed to upgrade probe", "err", err)
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	conn.SetReadLimit(probeMessageMax)
+	_ = conn.SetReadDeadline(time.Now().Add(probeTimeout))
+	for range probeMessages {
+		kind, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := conn.WriteMessage(kind, data); err != nil {
+			return
+		}
+	}
+}
+
+// HandleRevokeSession ends a session before it expires. Knowing the passcode
+// proves that the caller is one of the session's peers. Connected peers are
+// disconnected.
+func (rs *RelayServer) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		SessionID string `json:"session_id"`
+		Passcode  string `json:"passcode"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if err := rs.sessionManager.ValidatePasscode(req.SessionID, req.Passcode); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err := rs.sessionManager.RevokeSession(req.SessionID); err != nil {
+		http.Error(w, "invalid session", http.StatusNotFound)
+		return
+	}
+	rs.disconnect(req.SessionID, "session revoked")
+
+	w.WriteHeader(http.StatusNoContent)
+	slog.Info("session revoked", "session", req.SessionID)
+}
+
+// disconnect closes both sides of a session, telling them why
+func (rs *RelayServer) disconnect(sessionID, reason string) {
+	rs.mu.RLock()
+	pair, exists := rs.connections[sessionID]
+	rs.mu.RUnlock()
+	if !exists {
+		return
+	}
+
+	pair.mu.Lock()
+	defer pair.mu.Unlock()
+
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason)
+	for _, conn := range []*websocket.Conn{pair.Sharer, pair.Receiver} {
+		if conn != nil {
+			_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+			_ = conn.Close()
+		}
+	}
+}
+
+// RequireToken makes the relay refuse clients that do not present token
+// as a bearer token, so that a public relay only serves its owner's peers
+func (rs *RelayServer) RequireToken(token string) {
+	rs.token = token
+}
+
+// ServeWeb serves the browser receiver built into dir at /receive/, so that
+// receivers without orb can open /receive/#SESSION and download in the
+// browser. The relay only hands out the page; the browser decrypts.
+func (rs *RelayServer) ServeWeb(dir string) {
+	rs.webDir = dir
+}
+
+// StoreFiles lets senders leave encrypted files at the relay for receivers
+// that pick them up later, at /drop
+func (rs *RelayServer) StoreFiles(store *Store) {
+	rs.store = store
+}
+
+// dropTransferTimeout bounds the upload or download of a stored file,
+// which may take longer than other requests
+const dropTransferTimeout = time.Hour
+
+// dropTokenHeader carries the token that deletes a stored file
+const dropTokenHeader = "X-Orb-Drop-Token"
+
+// HandleDropPut stores the ciphertext of the request body. The sender sets
+// the token that deletes it and may ask for a shorter lifetime with ?ttl=
+// in seconds.
+func (rs *RelayServer) HandleDropPut(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get(dropTokenHeader)
+	if len(token) < 32 {
+		http.Error(w, "missing token", http.StatusBadRequest)
+		return
+	}
+	var ttl time.Duration
+	if raw := r.URL.Query().Get("ttl"); raw != "" {
+		seconds, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || seconds < 0 {
+			http.Error(w, "invalid ttl", http.StatusBadRequest)
+			return
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+	if r.ContentLength > rs.store.limits.MaxSize {
+		http.Error(w, fmt.Sprintf("file too large, this relay stores up to %d bytes", rs.store.limits.MaxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	_ = http.NewResponseController(w).SetReadDeadline(time.Now().Add(dropTransferTimeout))
+
+	id, expires, err := rs.store.Put(r.Body, token, ttl)
+	switch {
+	case errors.Is(err, ErrDropTooLarge):
+		http.Error(w, fmt.Sprintf("file too large, this relay stores up to %d bytes", rs.store.limits.MaxSize), http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, ErrStoreFull):
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	case err != nil:
+		slog.Warn("failed to store file", "err", err)
+		http.Error(w, "failed to store file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"id":         id,
+		"expires_at": expires.UTC().Format(time.RFC3339),
+	})
+	slog.Info("file stored", "id", id, "expires", expires.Format(time.RFC3339))
+}
+
+// HandleDropGet sends a stored file. Anyone with the ID may fetch it, only
+// the passcode decrypts it.
+func (rs *RelayServer) HandleDropGet(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validDropID(id) {
+		http.Error(w, ErrDropNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	f, size, err := rs.store.Open(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(dropTransferTimeout))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	if _, err := io.Copy(w, f); err != nil {
+		slog.Debug("stored file not sent", "id", id, "err", err)
+	}
+}
+
+// HandleDropDelete removes a stored file, once picked up
+func (rs *RelayServer) HandleDropDelete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validDropID(id) {
+		http.Error(w, ErrDropNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	switch err := rs.store.Delete(id, r.Header.Get(dropTokenHeader)); {
+	case errors.Is(err, ErrDropToken):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+		slog.Info("stored file picked up", "id", id)
+	}
+}
+
+// webHandler serves the files of the browser receiver
+func (rs *RelayServer) webHandler() http.Handler {
+	files := http.StripPrefix("/receive/", http.FileServer(http.Dir(rs.webDir)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The module takes longer to send than other responses may
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(5 * time.Minute))
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("Content-Security-Policy",
+			"default-src 'self'; script-src 'self' 'wasm-unsafe-eval'; connect-src 'self'; frame-ancestors 'none'")
+		files.ServeHTTP(w, r)
+	})
+}
+
+// authorize wraps a handler with the token check
+func (rs *RelayServer) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rs.token != "" {
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(rs.token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}
 }
 
 // Start starts the relay server
 func (rs *RelayServer) Start(addr string) error {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/share", rs.HandleShare)
-	mux.HandleFunc("/connect", rs.HandleConnect)
-	mux.HandleFunc("/session/create", rs.HandleCreateSession)
+	mux.HandleFunc("/share", rs.authorize(rs.HandleShare))
+	mux.HandleFunc("/connect", rs.authorize(rs.HandleConnect))
+	mux.HandleFunc("/session/create", rs.authorize(rs.HandleCreateSession))
+	mux.HandleFunc("/session/revoke", rs.authorize(rs.HandleRevokeSession))
+	mux.HandleFunc("/probe", rs.authorize(rs.HandleProbe))
+	if rs.webDir != "" {
+		mux.Handle("GET /receive/", rs.webHandler())
+	}
+	if rs.store != nil {
+		mux.HandleFunc("POST /drop", rs.authorize(rs.HandleDropPut))
+		mux.HandleFunc("GET /drop/{id}", rs.authorize(rs.HandleDropGet))
+		mux.HandleFunc("DELETE /drop/{id}", rs.authorize(rs.HandleDropDelete))
+		go rs.store.Sweep(rs.ctx.Done())
+	}
+	if len(rs.hopTargets) > 0 {
+		mux.HandleFunc("GET /hop", rs.authorize(rs.HandleHop))
+	}
 
 	server := &http.Server{
 		Addr:         addr,
@@ -358,7 +698,7 @@ func (rs *RelayServer) Start(addr string) error {
 		IdleTimeout:  60 * time.Second,
 	}
 
-	log.Printf("Relay server starting on %s", addr)
+	slog.Info("relay server starting", "addr", addr)
 	return server.ListenAndServe()
 }
 
@@ -373,12 +713,12 @@ func (rs *RelayServer) Shutdown() {
 	for _, pair := range rs.connections {
 		if pair.Sharer != nil {
 			if err := pair.Sharer.Close(); err != nil {
-				log.Printf("Warning: failed to close sharer connection: %v", err)
+				slog.Warn("failed to close sharer connection", "err", err)
 			}
 		}
 		if pair.Receiver != nil {
 			if err := pair.Receiver.Close(); err != nil {
-				log.Printf("Warning: failed to close receiver connection: %v", err)
+				slog.Warn("failed to close receiver connection", "err", err)
 			}
 		}
 	}
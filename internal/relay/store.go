@@ -0,0 +1,260 @@
+package relay
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DropIDLength is the length of the IDs of stored files, longer than
+// session IDs so that clients can tell the two apart
+const DropIDLength = 10
+
+// storeSweep is how often expired files are removed
+const storeSweep = time.Minute
+
+var (
+	// ErrDropNotFound is returned for unknown and expired files
+	ErrDropNotFound = errors.New("no stored file with that ID, it expired or was picked up")
+	// ErrDropTooLarge is returned for files above the size limit
+	ErrDropTooLarge = errors.New("file too large")
+	// ErrStoreFull is returned when the relay has no room for more files
+	ErrStoreFull = errors.New("the relay has no room for more stored files")
+	// ErrDropToken is returned when the token of a deletion does not match
+	ErrDropToken = errors.New("wrong token for this file")
+)
+
+// StoreLimits bound what a relay stores
+type StoreLimits struct {
+	// MaxSize is the largest file accepted
+	MaxSize int64
+	// Total is the space all stored files may take
+	Total int64
+	// MaxTTL is the longest a file is kept, and the default
+	MaxTTL time.Duration
+}
+
+// Store keeps encrypted files for receivers that pick them up later. It
+// only ever sees ciphertext: senders encrypt with a key derived from a
+// passcode that never reaches the relay. Each file is kept next to a small
+// JSON record with its expiry and a hash of the token that deletes it.
+type Store struct {
+	dir    string
+	limits StoreLimits
+
+	mu    sync.Mutex
+	drops map[string]dropRecord
+	used  int64 // bytes stored and being received
+}
+
+// dropRecord is the metadata of a stored file
+type dropRecord struct {
+	Size      int64     `json:"size"`
+	Expires   time.Time `json:"expires"`
+	TokenHash string    `json:"token_sha256"`
+}
+
+// NewStore keeps files in dir, picking up those stored before a restart
+func NewStore(dir string, limits StoreLimits) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	s := &Store{dir: dir, limits: limits, drops: make(map[string]dropRecord)}
+
+	records, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range records {
+		id := strings.TrimSuffix(filepath.Base(name), ".json")
+		// #nosec G304 -- the record is inside the store directory
+		data, err := os.ReadFile(name)
+		var rec dropRecord
+		if err == nil {
+			err = json.Unmarshal(data, &rec)
+		}
+		if err != nil {
+			slog.Warn("dropping unreadable stored file", "id", id, "err", err)
+			s.remove(id)
+			continue
+		}
+		s.drops[id] = rec
+		s.used += rec.Size
+	}
+	// Uploads cut short by the restart
+	temps, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	for _, name := range temps {
+		_ = os.Remove(name)
+	}
+	return s, nil
+}
+
+// Put stores what r yields for ttl, capped at the limit, and returns the ID
+// of the file. token deletes it again.
+func (s *Store) Put(r io.Reader, token string, ttl time.Duration) (string, time.Time, error) {
+	if ttl <= 0 || ttl > s.limits.MaxTTL {
+		ttl = s.limits.MaxTTL
+	}
+	// The whole limit is reserved up front and the rest returned once the
+	// size is known
+	s.mu.Lock()
+	if s.used+s.limits.MaxSize > s.limits.Total {
+		s.mu.Unlock()
+		return "", time.Time{}, ErrStoreFull
+	}
+	s.used += s.limits.MaxSize
+	s.mu.Unlock()
+	reserved := s.limits.MaxSize
+	defer func() {
+		s.mu.Lock()
+		s.used -= reserved
+		s.mu.Unlock()
+	}()
+
+	id, err := newDropID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	temp := filepath.Join(s.dir, id+".tmp")
+	// #nosec G304 -- the name is a generated ID inside the store directory
+	f, err := os.OpenFile(temp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	// One byte past the limit tells a file at the limit from a larger one
+	n, err := io.Copy(f, io.LimitReader(r, s.limits.MaxSize+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > s.limits.MaxSize {
+		err = ErrDropTooLarge
+	}
+	if err != nil {
+		_ = os.Remove(temp)
+		return "", time.Time{}, err
+	}
+
+	rec := dropRecord{Size: n, Expires: time.Now().Add(ttl), TokenHash: hashToken(token)}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		_ = os.Remove(temp)
+		return "", time.Time{}, err
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, id+".json"), data, 0600); err != nil {
+		_ = os.Remove(temp)
+		return "", time.Time{}, err
+	}
+	if err := os.Rename(temp, filepath.Join(s.dir, id+".blob")); err != nil {
+		s.remove(id)
+		_ = os.Remove(temp)
+		return "", time.Time{}, err
+	}
+
+	s.mu.Lock()
+	s.drops[id] = rec
+	s.used += n
+	s.mu.Unlock()
+	return id, rec.Expires, nil
+}
+
+// Open returns a stored file and its size
+func (s *Store) Open(id string) (*os.File, int64, error) {
+	s.mu.Lock()
+	rec, ok := s.drops[id]
+	s.mu.Unlock()
+	if !ok || time.Now().After(rec.Expires) {
+		return nil, 0, ErrDropNotFound
+	}
+	// #nosec G304 -- id names a known file inside the store directory
+	f, err := os.Open(filepath.Join(s.dir, id+".blob"))
+	if err != nil {
+		return nil, 0, ErrDropNotFound
+	}
+	return f, rec.Size, nil
+}
+
+// Delete removes a stored file, for whoever knows its token
+func (s *Store) Delete(id, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.drops[id]
+	if !ok {
+		return ErrDropNotFound
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(rec.TokenHash)) != 1 {
+		return ErrDropToken
+	}
+	delete(s.drops, id)
+	s.used -= rec.Size
+	s.remove(id)
+	return nil
+}
+
+// Sweep removes expired files until done is closed
+func (s *Store) Sweep(done <-chan struct{}) {
+	ticker := time.NewTicker(storeSweep)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		s.mu.Lock()
+		for id, rec := range s.drops {
+			if now.After(rec.Expires) {
+				delete(s.drops, id)
+				s.used -= rec.Size
+				s.remove(id)
+				slog.Info("stored file expired", "id", id)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// remove deletes the files of id
+func (s *Store) remove(id string) {
+	_ = os.Remove(filepath.Join(s.dir, id+".blob"))
+	_ = os.Remove(filepath.Join(s.dir, id+".json"))
+}
+
+// validDropID keeps request paths from naming anything but stored files
+func validDropID(id string) bool {
+	if len(id) != DropIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !strings.ContainsRune("ABCDEFGHIJKLMNOPQRSTUVWXYZ234567", c) {
+			return false
+		}
+	}
+	return true
+}
+
+func newDropID() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate ID: %w", err)
+	}
+	return base32.StdEncoding.EncodeToString(b)[:DropIDLength], nil
+}
+
+// hashToken keeps tokens off the disk of the relay
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,368 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/tunnel"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// ErrNoTruncate is returned by Truncate and WriteTruncate when the sharer
+// runs an older orb that cannot change the size of a file
+var ErrNoTruncate = errors.New("the sharer cannot change the size of a file, it runs an older orb")
+
+// Client performs filesystem operations on the sharer's folder over a tunnel
+type Client struct {
+	mux *tunnel.Mux
+	tx  string // transaction the changes are staged in, see BeginTx
+}
+
+// NewClient creates a remote filesystem client on top of a multiplexed tunnel
+func NewClient(mux *tunnel.Mux) *Client {
+	return &Client{mux: mux}
+}
+
+// Mux returns the underlying request multiplexer
+func (c *Client) Mux() *tunnel.Mux {
+	return c.mux
+}
+
+// Ping measures the round-trip time to the sharer
+func (c *Client) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	resp, err := c.mux.Request(ctx, &protocol.Frame{
+		Type:    protocol.FrameTypePing,
+		Payload: []byte{},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if resp.Type != protocol.FrameTypePong {
+		return 0, fmt.Errorf("expected pong, got %d", resp.Type)
+	}
+	return time.Since(start), nil
+}
+
+// Info describes the share, e.g. whether it accepts changes
+func (c *Client) Info(ctx context.Context) (*protocol.InfoResponse, error) {
+	var resp protocol.InfoResponse
+	if err := c.mux.Call(ctx, protocol.FrameTypeInfo, protocol.InfoRequest{}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// List returns the contents of a remote directory
+func (c *Client) List(ctx context.Context, path string) ([]protocol.FileInfo, error) {
+	var resp protocol.ListResponse
+	if err := c.mux.Call(ctx, protocol.FrameTypeList, protocol.ListRequest{Path: path}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Files, nil
+}
+
+// Stat returns information about a single remote entry
+func (c *Client) Stat(ctx context.Context, path string) (*protocol.FileInfo, error) {
+	var resp protocol.StatResponse
+	if err := c.mux.Call(ctx, protocol.FrameTypeStat, protocol.StatRequest{Path: path}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Info, nil
+}
+
+// Read reads up to length bytes of a remote file starting at offset
+func (c *Client) Read(ctx context.Context, path string, offset, length int64) ([]byte, error) {
+	var resp protocol.ReadResponse
+	req := protocol.ReadRequest{
+		Path:   path,
+		Offset: offset,
+		Length: length,
+	}
+	if err := c.mux.Call(ctx, protocol.FrameTypeRead, req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// Bench sends data to the sharer and returns the size bytes of filler it
+// answers with. Sharers that predate it do not answer at all, so ctx should
+// carry a deadline.
+func (c *Client) Bench(ctx context.Context, data []byte, size int64) ([]byte, error) {
+	var resp protocol.BenchResponse
+	req := protocol.BenchRequest{Data: data, Size: size}
+	if err := c.mux.Call(ctx, protocol.FrameTypeBench, req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// Hash returns the SHA-256 checksum of a remote file
+func (c *Client) Hash(ctx context.Context, path string) ([]byte, error) {
+	var resp protocol.HashResponse
+	if err := c.mux.Call(ctx, protocol.FrameTypeHash, protocol.HashRequest{Path: path, Tx: c.tx}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.SHA256, nil
+}
+
+// HashPrefix returns the SHA-256 checksum of the first length bytes of a
+// remote file. Sharers that predate it return the checksum of the whole file.
+func (c *Client) HashPrefix(ctx context.Context, path string, length int64) ([]byte, error) {
+	var resp protocol.HashResponse
+	req := protocol.HashRequest{Path: path, Length: length, Tx: c.tx}
+	if err := c.mux.Call(ctx, protocol.FrameTypeHash, req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.SHA256, nil
+}
+
+// Manifest returns the signed manifest of the share, fetched in as many
+// requests as it takes
+func (c *Client) Manifest(ctx context.Context) ([]byte, error) {
+	var data []byte
+	for {
+		var resp protocol.ManifestResponse
+		req := protocol.ManifestRequest{Offset: int64(len(data))}
+		if err := c.mux.Call(ctx, protocol.FrameTypeManifest, req, &resp); err != nil {
+			return nil, err
+		}
+		data = append(data, resp.Data...)
+		if int64(len(data)) >= resp.Size {
+			return data, nil
+		}
+		if len(resp.Data) == 0 {
+			return nil, fmt.Errorf("the manifest ended after %d of %d bytes", len(data), resp.Size)
+		}
+	}
+}
+
+// Signature returns the checksums of up to count blocks of a remote file,
+// starting with block first. The sharer may return fewer.
+func (c *Client) Signature(ctx context.Context, path string, blockSize, first int64, count int) (*protocol.SignatureResponse, error) {
+	var resp protocol.SignatureResponse
+	req := protocol.SignatureRequest{Path: path, BlockSize: blockSize, First: first, Count: count}
+	if err := c.mux.Call(ctx, protocol.FrameTypeSignature, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Watch asks the sharer to report changes below path on WatchEvents
+func (c *Client) Watch(ctx context.Context, path string) error {
+	return c.mux.Call(ctx, protocol.FrameTypeWatch, protocol.WatchRequest{Path: path}, nil)
+}
+
+// WatchEvents returns the changes reported for watched directories. The
+// channel closes when the tunnel does. Only one caller may read it.
+func (c *Client) WatchEvents() <-chan protocol.WatchEvent {
+	events := make(chan protocol.WatchEvent)
+	go func() {
+		defer close(events)
+		for {
+			select {
+			case frame := <-c.mux.Events():
+				if frame.Type != protocol.FrameTypeEvent {
+					continue
+				}
+				var event protocol.WatchEvent
+				if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&event); err != nil {
+					continue
+				}
+				select {
+				case events <- event:
+				case <-c.mux.Done():
+					return
+				}
+			case <-c.mux.Done():
+				return
+			}
+		}
+	}()
+	return events
+}
+
+// SendMessage sends a line of chat to the sharer
+func (c *Client) SendMessage(text string) error {
+	if len(text) > protocol.MaxChatMessage {
+		return fmt.Errorf("message is longer than %d bytes", protocol.MaxChatMessage)
+	}
+	var buf bytes.Buffer
+	msg := protocol.ChatMessage{Text: text, Sent: time.Now().Unix()}
+	if err := gob.NewEncoder(&buf).Encode(msg); err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	return c.mux.Notify(&protocol.Frame{Type: protocol.FrameTypeMessage, Payload: buf.Bytes()})
+}
+
+// Messages returns the chat messages of the sharer. The channel closes when
+// the tunnel does. Only one caller may read it.
+func (c *Client) Messages() <-chan protocol.ChatMessage {
+	messages := make(chan protocol.ChatMessage)
+	go func() {
+		defer close(messages)
+		for {
+			select {
+			case frame := <-c.mux.Chat():
+				var msg protocol.ChatMessage
+				if err := gob.NewDecoder(bytes.NewReader(frame.Payload)).Decode(&msg); err != nil {
+					continue
+				}
+				select {
+				case messages <- msg:
+				case <-c.mux.Done():
+					return
+				}
+			case <-c.mux.Done():
+				return
+			}
+		}
+	}()
+	return messages
+}
+
+// Forward asks the sharer to connect to address and to carry the
+// connection on stream
+func (c *Client) Forward(ctx context.Context, address string, stream uint32) error {
+	req := protocol.ForwardRequest{Address: address, Stream: stream}
+	return c.mux.Call(ctx, protocol.FrameTypeForward, req, nil)
+}
+
+// Write writes data to a remote file at offset
+func (c *Client) Write(ctx context.Context, path string, offset int64, data []byte) (int64, error) {
+	var resp protocol.WriteResponse
+	req := protocol.WriteRequest{
+		Path:   path,
+		Offset: offset,
+		Data:   data,
+		Tx:     c.tx,
+	}
+	if err := c.mux.Call(ctx, protocol.FrameTypeWrite, req, &resp); err != nil {
+		return 0, err
+	}
+	return resp.BytesWritten, nil
+}
+
+// WriteTruncate writes data to a remote file at offset and then cuts the
+// file off, or extends it with zeros, to end where data does. Older sharers
+// write the data but leave the size alone, and ErrNoTruncate is returned
+// with the bytes written.
+func (c *Client) WriteTruncate(ctx context.Context, path string, offset int64, data []byte) (int64, error) {
+	var resp protocol.WriteResponse
+	req := protocol.WriteRequest{
+		Path:     path,
+		Offset:   offset,
+		Data:     data,
+		Tx:       c.tx,
+		Truncate: true,
+	}
+	if err := c.mux.Call(ctx, protocol.FrameTypeWrite, req, &resp); err != nil {
+		return 0, err
+	}
+	if !resp.Truncated {
+		return resp.BytesWritten, ErrNoTruncate
+	}
+	return resp.BytesWritten, nil
+}
+
+// Truncate sets the size of a remote file, cutting it off or extending it
+// with zeros
+func (c *Client) Truncate(ctx context.Context, path string, size int64) error {
+	_, err := c.WriteTruncate(ctx, path, size, nil)
+	return err
+}
+
+// Copy copies length bytes at sourceOffset of the remote file source to
+// offset of the remote file path, without sending them through the tunnel
+func (c *Client) Copy(ctx context.Context, path string, offset int64, source string, sourceOffset, length int64) (int64, error) {
+	var resp protocol.WriteResponse
+	req := protocol.CopyRequest{
+		Path:         path,
+		Offset:       offset,
+		Source:       source,
+		SourceOffset: sourceOffset,
+		Length:       length,
+		Tx:           c.tx,
+	}
+	if err := c.mux.Call(ctx, protocol.FrameTypeCopy, req, &resp); err != nil {
+		return 0, err
+	}
+	return resp.BytesWritten, nil
+}
+
+// Delete removes a remote file or directory
+func (c *Client) Delete(ctx context.Context, path string) error {
+	return c.mux.Call(ctx, protocol.FrameTypeDelete, protocol.DeleteRequest{Path: path, Tx: c.tx}, nil)
+}
+
+// Rename renames a remote file or directory
+func (c *Client) Rename(ctx context.Context, oldPath, newPath string) error {
+	req := protocol.RenameRequest{
+		OldPath: oldPath,
+		NewPath: newPath,
+		Tx:      c.tx,
+	}
+	return c.mux.Call(ctx, protocol.FrameTypeRename, req, nil)
+}
+
+// Mkdir creates a remote directory
+func (c *Client) Mkdir(ctx context.Context, path string, perm uint32) error {
+	req := protocol.MkdirRequest{
+		Path: path,
+		Perm: perm,
+		Tx:   c.tx,
+	}
+	return c.mux.Call(ctx, protocol.FrameTypeMkdir, req, nil)
+}
+
+// BeginTx opens a transaction on the sharer and returns a client whose
+// changes are staged in it: none of them is made until Commit, and all are
+// dropped by Rollback or when the tunnel closes first. Reads see the share
+// as it was, except for hashes of files written in the transaction.
+func (c *Client) BeginTx(ctx context.Context) (*Client, error) {
+	var resp protocol.TxBeginResponse
+	if err := c.mux.Call(ctx, protocol.FrameTypeTxBegin, protocol.TxBeginRequest{}, &resp); err != nil {
+		return nil, err
+	}
+	return &Client{mux: c.mux, tx: resp.ID}, nil
+}
+
+// Commit makes the changes staged in the transaction of a client returned
+// by BeginTx and returns how many there were
+func (c *Client) Commit(ctx context.Context) (int, error) {
+	if c.tx == "" {
+		return 0, errors.New("no transaction to commit")
+	}
+	var resp protocol.TxCommitResponse
+	if err := c.mux.Call(ctx, protocol.FrameTypeTxCommit, protocol.TxCommitRequest{ID: c.tx}, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Changes, nil
+}
+
+// Rollback drops the changes staged in the transaction of a client
+// returned by BeginTx
+func (c *Client) Rollback(ctx context.Context) error {
+	if c.tx == "" {
+		return errors.New("no transaction to roll back")
+	}
+	return c.mux.Call(ctx, protocol.FrameTypeTxRollback, protocol.TxRollbackRequest{ID: c.tx}, nil)
+}
+
+// Search finds entries below path whose name contains query
+func (c *Client) Search(ctx context.Context, path, query string, maxResults int) (*protocol.SearchResponse, error) {
+	var resp protocol.SearchResponse
+	req := protocol.SearchRequest{
+		Path:       path,
+		Query:      query,
+		MaxResults: maxResults,
+	}
+	if err := c.mux.Call(ctx, protocol.FrameTypeSearch, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
@@ -0,0 +1,68 @@
+//go:build darwin
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+func preview(spec Spec, opts Options) (string, string, error) {
+	if opts.System {
+		return "", "", errors.New("--system is only supported with systemd")
+	}
+	path, err := launchdPlistPath(spec.Name)
+	if err != nil {
+		return "", "", err
+	}
+	logPath, err := launchdLogPath(spec.Name)
+	if err != nil {
+		return "", "", err
+	}
+	return path, launchdPlist(spec, logPath), nil
+}
+
+func install(spec Spec, opts Options) error {
+	path, content, err := preview(spec, opts)
+	if err != nil {
+		return err
+	}
+	logPath, err := launchdLogPath(spec.Name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(parentDir(logPath), 0750); err != nil {
+		return fmt.Errorf("failed to create %s: %w", parentDir(logPath), err)
+	}
+	if err := writeDefinition(path, content); err != nil {
+		return err
+	}
+	return run("launchctl", "load", "-w", path)
+}
+
+func uninstall(name string, opts Options) error {
+	path, err := launchdPlistPath(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("service %s is not installed: %w", name, err)
+	}
+	if err := run("launchctl", "unload", "-w", path); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	return nil
+}
+
+// Logs describes where the output of a service goes
+func Logs(name string, _ Options) string {
+	path, err := launchdLogPath(name)
+	if err != nil {
+		return ""
+	}
+	return path
+}
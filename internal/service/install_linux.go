@@ -0,0 +1,56 @@
+//go:build linux
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+func preview(spec Spec, opts Options) (string, string, error) {
+	path, err := systemdUnitPath(spec.Name, opts)
+	if err != nil {
+		return "", "", err
+	}
+	return path, systemdUnit(spec, opts), nil
+}
+
+func install(spec Spec, opts Options) error {
+	path, content, err := preview(spec, opts)
+	if err != nil {
+		return err
+	}
+	if err := writeDefinition(path, content); err != nil {
+		return err
+	}
+	if err := systemctl(opts, "daemon-reload"); err != nil {
+		return err
+	}
+	return systemctl(opts, "enable", "--now", spec.Name+".service")
+}
+
+func uninstall(name string, opts Options) error {
+	path, err := systemdUnitPath(name, opts)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("service %s is not installed: %w", name, err)
+	}
+	if err := systemctl(opts, "disable", "--now", name+".service"); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	return systemctl(opts, "daemon-reload")
+}
+
+// Logs describes where the output of a service goes
+func Logs(name string, opts Options) string {
+	if opts.System {
+		return "journalctl -u " + name
+	}
+	return "journalctl --user -u " + name
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !windows
+
+package service
+
+func preview(Spec, Options) (string, string, error) { return "", "", ErrUnsupported }
+
+func install(Spec, Options) error { return ErrUnsupported }
+
+func uninstall(string, Options) error { return ErrUnsupported }
+
+// Logs describes where the output of a service goes
+func Logs(string, Options) string { return "" }
@@ -0,0 +1,102 @@
+//go:build windows
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// preview describes the service that would be created; Windows keeps
+// services in the registry rather than in a file
+func preview(spec Spec, opts Options) (string, string, error) {
+	if opts.System {
+		return "", "", errors.New("--system is only supported with systemd")
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Name:        %s\n", spec.Name)
+	fmt.Fprintf(&b, "DisplayName: %s\n", spec.Description)
+	fmt.Fprintf(&b, "StartType:   automatic\n")
+	fmt.Fprintf(&b, "Command:     %s\n", strings.Join(append([]string{spec.Executable}, spec.Args...), " "))
+	return `HKLM\SYSTEM\CurrentControlSet\Services\` + spec.Name, b.String(), nil
+}
+
+func install(spec Spec, opts Options) error {
+	if opts.System {
+		return errors.New("--system is only supported with systemd")
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager, run as administrator: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.CreateService(spec.Name, spec.Executable, mgr.Config{
+		DisplayName: spec.Description,
+		StartType:   mgr.StartAutomatic,
+	}, spec.Args...)
+	if err != nil {
+		return fmt.Errorf("failed to create service %s: %w", spec.Name, err)
+	}
+	defer s.Close()
+
+	// Restart after 5 seconds whenever the command exits. RunIfService
+	// reports an exit the service manager did not ask for as a failure.
+	actions := []mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 5 * time.Second}}
+	if err := s.SetRecoveryActions(actions, 0); err != nil {
+		return fmt.Errorf("failed to configure restarts: %w", err)
+	}
+	if err := s.SetRecoveryActionsOnNonCrashFailures(true); err != nil {
+		return fmt.Errorf("failed to configure restarts: %w", err)
+	}
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("failed to start service %s: %w", spec.Name, err)
+	}
+	return nil
+}
+
+func uninstall(name string, opts Options) error {
+	if opts.System {
+		return errors.New("--system is only supported with systemd")
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager, run as administrator: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", name, err)
+	}
+	defer s.Close()
+
+	// Stopping fails when the service is not running, which is fine
+	_, _ = s.Control(svc.Stop)
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to delete service %s: %w", name, err)
+	}
+	return nil
+}
+
+// Logs describes where the output of a service goes
+func Logs(name string, _ Options) string {
+	return logPath(name)
+}
+
+// logPath is where RunIfService writes the output of a service. Services
+// run as LocalSystem, so it lives outside the user's profile.
+func logPath(name string) string {
+	dir := os.Getenv("ProgramData")
+	if dir == "" {
+		dir = `C:\ProgramData`
+	}
+	return filepath.Join(dir, "orb", "logs", name+".log")
+}
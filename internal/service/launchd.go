@@ -0,0 +1,59 @@
+package service
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// launchdPlistPath returns where the agent of a service is installed
+func launchdPlistPath(name string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate home directory: %w", err)
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", name+".plist"), nil
+}
+
+// launchdLogPath returns where launchd writes the output of a service
+func launchdLogPath(name string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate home directory: %w", err)
+	}
+	return filepath.Join(home, "Library", "Logs", "orb", name+".log"), nil
+}
+
+// launchdPlist renders the property list of a launchd agent
+func launchdPlist(spec Spec, logPath string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(&b, "<!DOCTYPE plist PUBLIC \"-//Apple//DTD PLIST 1.0//EN\" \"http://www.apple.com/DTDs/PropertyList-1.0.dtd\">\n")
+	fmt.Fprintf(&b, "<plist version=\"1.0\">\n")
+	fmt.Fprintf(&b, "<dict>\n")
+	fmt.Fprintf(&b, "  <key>Label</key>\n  <string>%s</string>\n", xmlEscape(spec.Name))
+	fmt.Fprintf(&b, "  <key>ProgramArguments</key>\n  <array>\n")
+	for _, arg := range append([]string{spec.Executable}, spec.Args...) {
+		fmt.Fprintf(&b, "    <string>%s</string>\n", xmlEscape(arg))
+	}
+	fmt.Fprintf(&b, "  </array>\n")
+	fmt.Fprintf(&b, "  <key>RunAtLoad</key>\n  <true/>\n")
+	if spec.Restart {
+		fmt.Fprintf(&b, "  <key>KeepAlive</key>\n  <true/>\n")
+	} else {
+		fmt.Fprintf(&b, "  <key>KeepAlive</key>\n  <dict>\n    <key>SuccessfulExit</key>\n    <false/>\n  </dict>\n")
+	}
+	fmt.Fprintf(&b, "  <key>StandardOutPath</key>\n  <string>%s</string>\n", xmlEscape(logPath))
+	fmt.Fprintf(&b, "  <key>StandardErrorPath</key>\n  <string>%s</string>\n", xmlEscape(logPath))
+	fmt.Fprintf(&b, "</dict>\n")
+	fmt.Fprintf(&b, "</plist>\n")
+	return b.String()
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
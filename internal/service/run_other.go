@@ -0,0 +1,6 @@
+//go:build !windows
+
+package service
+
+// RunIfService reports false; only Windows runs services through a handler
+func RunIfService(func() error) bool { return false }
@@ -0,0 +1,62 @@
+//go:build windows
+
+package service
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// RunIfService runs fn under the Windows service manager when the process
+// was started as a service and reports true. Otherwise it does nothing and
+// reports false.
+func RunIfService(fn func() error) bool {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return false
+	}
+	// The name is ignored for services running in their own process
+	_ = svc.Run("", handler(fn))
+	return true
+}
+
+type handler func() error
+
+func (h handler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	// Services have no console, send what would be printed there to the
+	// log file. The first argument is the name of the service.
+	if len(args) > 0 {
+		path := logPath(args[0])
+		if err := os.MkdirAll(parentDir(path), 0750); err == nil {
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600) // #nosec G304 -- path derived from the service name
+			if err == nil {
+				defer f.Close()
+				os.Stdout, os.Stderr = f, f
+			}
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- h() }()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-done:
+			// The command ended without being asked to, report a failure so
+			// that the recovery actions restart it
+			return true, 1
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				return false, 0
+			}
+		}
+	}
+}
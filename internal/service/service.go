@@ -0,0 +1,110 @@
+// Package service installs orb commands as services managed by the
+// operating system: systemd units on Linux, launchd agents on macOS and
+// services on Windows.
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ErrUnsupported is returned on platforms without a supported service manager
+var ErrUnsupported = errors.New("installing services is not supported on this platform")
+
+// Spec describes a service running an orb command
+type Spec struct {
+	// Name identifies the service, e.g. "orb-relay"
+	Name        string
+	Description string
+
+	// Executable and Args are the command line the service runs
+	Executable string
+	Args       []string
+
+	// Restart restarts the command whenever it exits, not only on failure.
+	// Shares need this to serve the next receiver.
+	Restart bool
+}
+
+// Options select where a service is installed
+type Options struct {
+	// System installs a system-wide service instead of one for the current
+	// user. Only systemd distinguishes the two; it needs root.
+	System bool
+}
+
+// Validate checks that the name can be used for files and service managers
+func (s Spec) Validate() error {
+	if s.Name == "" {
+		return errors.New("service name is empty")
+	}
+	for _, r := range s.Name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return fmt.Errorf("invalid service name %q, use letters, digits, '-', '_' and '.'", s.Name)
+		}
+	}
+	return nil
+}
+
+// Install writes the service definition, then enables and starts the service
+func Install(spec Spec, opts Options) error {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	return install(spec, opts)
+}
+
+// Uninstall stops and removes a service installed with Install
+func Uninstall(name string, opts Options) error {
+	if err := (Spec{Name: name}).Validate(); err != nil {
+		return err
+	}
+	return uninstall(name, opts)
+}
+
+// Preview returns where Install would write the service definition and what
+// it would contain, without changing anything
+func Preview(spec Spec, opts Options) (string, string, error) {
+	if err := spec.Validate(); err != nil {
+		return "", "", err
+	}
+	return preview(spec, opts)
+}
+
+// writeDefinition writes a service definition file, creating its directory
+func writeDefinition(path, content string) error {
+	if err := os.MkdirAll(parentDir(path), 0750); err != nil {
+		return fmt.Errorf("failed to create %s: %w", parentDir(path), err)
+	}
+	// The command line may hold a relay token, keep it private
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// parentDir is filepath.Dir for both separators, so that definitions for
+// another platform can be previewed
+func parentDir(path string) string {
+	i := strings.LastIndexAny(path, `/\`)
+	if i <= 0 {
+		return "."
+	}
+	return path[:i]
+}
+
+// run executes a service manager command, including its output in errors
+func run(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput() // #nosec G204 -- fixed service manager commands
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fmt.Errorf("%s %s: %s", name, strings.Join(args, " "), msg)
+	}
+	return nil
+}
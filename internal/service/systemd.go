@@ -0,0 +1,74 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// systemdUnitPath returns where the unit of a service is installed
+func systemdUnitPath(name string, opts Options) (string, error) {
+	if opts.System {
+		return filepath.Join("/etc/systemd/system", name+".service"), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate config directory: %w", err)
+	}
+	return filepath.Join(dir, "systemd", "user", name+".service"), nil
+}
+
+// systemdUnit renders the unit file of a service
+func systemdUnit(spec Spec, opts Options) string {
+	cmdline := make([]string, 0, len(spec.Args)+1)
+	for _, arg := range append([]string{spec.Executable}, spec.Args...) {
+		cmdline = append(cmdline, systemdQuote(arg))
+	}
+
+	restart := "on-failure"
+	if spec.Restart {
+		restart = "always"
+	}
+	wantedBy := "default.target"
+	if opts.System {
+		wantedBy = "multi-user.target"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[Unit]\n")
+	fmt.Fprintf(&b, "Description=%s\n", spec.Description)
+	fmt.Fprintf(&b, "Wants=network-online.target\n")
+	fmt.Fprintf(&b, "After=network-online.target\n")
+	fmt.Fprintf(&b, "\n")
+	fmt.Fprintf(&b, "[Service]\n")
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(cmdline, " "))
+	// A system service started with sudo runs as the user who invoked it
+	if user := os.Getenv("SUDO_USER"); opts.System && user != "" {
+		fmt.Fprintf(&b, "User=%s\n", user)
+	}
+	fmt.Fprintf(&b, "Restart=%s\n", restart)
+	fmt.Fprintf(&b, "RestartSec=5\n")
+	fmt.Fprintf(&b, "\n")
+	fmt.Fprintf(&b, "[Install]\n")
+	fmt.Fprintf(&b, "WantedBy=%s\n", wantedBy)
+	return b.String()
+}
+
+// systemdQuote quotes a command line argument for ExecStart
+func systemdQuote(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\;$") {
+		return arg
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", "$$")
+	return `"` + r.Replace(arg) + `"`
+}
+
+// systemctl runs systemctl for the user or system instance
+func systemctl(opts Options, args ...string) error {
+	if !opts.System {
+		args = append([]string{"--user"}, args...)
+	}
+	return run("systemctl", args...)
+}
@@ -22,6 +22,7 @@ type Session struct {
 	ID             string
 	Passcode       string
 	Created        time.Time
+	Expires        time.Time
 	LastActivity   time.Time
 	FailedAttempts int
 	Locked         bool
@@ -80,8 +81,13 @@ func GeneratePasscode() (string, error) {
 	return passcode, nil
 }
 
-// CreateSession creates a new session
-func (sm *SessionManager) CreateSession(sharedPath string) (*Session, error) {
+// CreateSession creates a new session that expires after ttl, or after
+// SessionTimeout when ttl is zero or longer
+func (sm *SessionManager) CreateSession(sharedPath string, ttl time.Duration) (*Session, error) {
+	if ttl <= 0 || ttl > SessionTimeout {
+		ttl = SessionTimeout
+	}
+
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
@@ -105,11 +111,13 @@ func (sm *SessionManager) CreateSession(sharedPath string) (*Session, error) {
 		return nil, err
 	}
 
+	now := time.Now()
 	session := &Session{
 		ID:           sessionID,
 		Passcode:     passcode,
-		Created:      time.Now(),
-		LastActivity: time.Now(),
+		Created:      now,
+		Expires:      now.Add(ttl),
+		LastActivity: now,
 		SharedPath:   sharedPath,
 		Active:       true,
 	}
@@ -119,15 +127,23 @@ func (sm *SessionManager) CreateSession(sharedPath string) (*Session, error) {
 	return session, nil
 }
 
-// GetSession retrieves a session by ID
+// GetSession retrieves a session by ID. Expired sessions are not returned.
 func (sm *SessionManager) GetSession(sessionID string) (*Session, bool) {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
 	session, exists := sm.sessions[sessionID]
+	if exists && session.Expired() {
+		return nil, false
+	}
 	return session, exists
 }
 
+// Expired reports whether the session has outlived its TTL
+func (s *Session) Expired() bool {
+	return time.Now().After(s.Expires)
+}
+
 // ValidatePasscode validates a passcode for a session (with rate limiting)
 func (sm *SessionManager) ValidatePasscode(sessionID, passcode string) error {
 	// Start timer for constant-time response
@@ -156,7 +172,7 @@ func (sm *SessionManager) ValidatePasscode(sessionID, passcode string) error {
 	}
 
 	// Check if expired
-	if time.Since(session.Created) > SessionTimeout {
+	if session.Expired() {
 		delete(sm.sessions, sessionID)
 		return fmt.Errorf("session expired")
 	}
@@ -229,7 +245,7 @@ func (sm *SessionManager) cleanupExpired() {
 		now := time.Now()
 		for id, session := range sm.sessions {
 			// Remove sessions that are expired or inactive for too long
-			if now.Sub(session.Created) > SessionTimeout ||
+			if session.Expired() ||
 				now.Sub(session.LastActivity) > 30*time.Minute {
 				delete(sm.sessions, id)
 			}
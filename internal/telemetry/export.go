@@ -0,0 +1,360 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// batchSize is the most spans sent in one request
+	batchSize = 512
+	// queueSize is the most spans waiting for export; more are dropped
+	// rather than slowing down the transfer being traced
+	queueSize = 4096
+	// flushInterval is how long a span waits for others to share a request
+	flushInterval = 5 * time.Second
+)
+
+// config is the exporter as set up by the OTEL_* environment variables
+type config struct {
+	endpoint string
+	header   http.Header
+	timeout  time.Duration
+	service  string
+	version  string
+}
+
+// configFromEnv reads the standard OTLP exporter variables. It returns nil
+// when no endpoint is set or the SDK is disabled.
+func configFromEnv(service, version string) (*config, error) {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return nil, nil
+	}
+	if exporter := os.Getenv("OTEL_TRACES_EXPORTER"); exporter != "" && exporter != "otlp" {
+		// "none" and exporters orb does not have turn tracing off
+		return nil, nil
+	}
+
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			return nil, nil
+		}
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q", endpoint)
+	}
+
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	if protocol != "" && protocol != "http/json" {
+		return nil, fmt.Errorf("OTLP protocol %q is not supported, use http/json", protocol)
+	}
+
+	header := http.Header{}
+	for _, env := range []string{"OTEL_EXPORTER_OTLP_HEADERS", "OTEL_EXPORTER_OTLP_TRACES_HEADERS"} {
+		if err := parseHeaders(header, os.Getenv(env)); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", env, err)
+		}
+	}
+
+	timeout := 10 * time.Second
+	if ms := os.Getenv("OTEL_EXPORTER_OTLP_TIMEOUT"); ms != "" {
+		n, err := strconv.Atoi(ms)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_TIMEOUT %q", ms)
+		}
+		timeout = time.Duration(n) * time.Millisecond
+	}
+
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		service = name
+	}
+	return &config{endpoint: endpoint, header: header, timeout: timeout, service: service, version: version}, nil
+}
+
+// parseHeaders adds the comma separated key=value pairs of s to header.
+// Values are URL encoded.
+func parseHeaders(header http.Header, s string) error {
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("%q is not key=value", pair)
+		}
+		value, err := url.QueryUnescape(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		header.Set(strings.TrimSpace(key), value)
+	}
+	return nil
+}
+
+// exporter sends finished spans in batches to an OTLP/HTTP endpoint
+type exporter struct {
+	cfg    config
+	client *http.Client
+	queue  chan *Span
+	flush  chan chan error
+}
+
+func newExporter(cfg config) *exporter {
+	e := &exporter{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.timeout},
+		queue:  make(chan *Span, queueSize),
+		flush:  make(chan chan error),
+	}
+	go e.loop()
+	return e
+}
+
+// add queues a finished span, dropping it when the queue is full
+func (e *exporter) add(s *Span) {
+	select {
+	case e.queue <- s:
+	default:
+	}
+}
+
+// close exports what is queued and stops the exporter
+func (e *exporter) close(timeout time.Duration) error {
+	reply := make(chan error, 1)
+	select {
+	case e.flush <- reply:
+	case <-time.After(timeout):
+		return errorf("timed out")
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-time.After(timeout):
+		return errorf("timed out")
+	}
+}
+
+func (e *exporter) loop() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	send := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := e.send(batch)
+		batch = batch[:0]
+		return err
+	}
+
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) >= batchSize {
+				if err := send(); err != nil {
+					slog.Debug("failed to export traces", "err", err)
+				}
+			}
+		case <-ticker.C:
+			if err := send(); err != nil {
+				slog.Debug("failed to export traces", "err", err)
+			}
+		case reply := <-e.flush:
+			var err error
+			for drained := false; !drained; {
+				select {
+				case s := <-e.queue:
+					batch = append(batch, s)
+					if len(batch) >= batchSize {
+						err = send()
+					}
+				default:
+					drained = true
+				}
+			}
+			if sendErr := send(); sendErr != nil {
+				err = sendErr
+			}
+			reply <- err
+			return
+		}
+	}
+}
+
+// send posts spans as an OTLP ExportTraceServiceRequest in JSON
+func (e *exporter) send(spans []*Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range e.cfg.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return errorf("%w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode/100 != 2 {
+		return errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// errorf words export failures
+func errorf(format string, args ...any) error {
+	return fmt.Errorf("otlp: "+format, args...)
+}
+
+// The OTLP JSON encoding, trimmed to what orb's spans use
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              Kind           `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"` // 2 is an error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func (e *exporter) request(spans []*Span) otlpRequest {
+	resource := []otlpKeyValue{
+		keyValue(slog.String("service.name", e.cfg.service)),
+		keyValue(slog.String("telemetry.sdk.name", "orb")),
+		keyValue(slog.String("telemetry.sdk.language", "go")),
+	}
+	if e.cfg.version != "" {
+		resource = append(resource, keyValue(slog.String("service.version", e.cfg.version)))
+	}
+
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.sc.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.sc.SpanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		for _, a := range s.attrs {
+			span.Attributes = append(span.Attributes, keyValue(a))
+		}
+		if s.failed {
+			span.Status = otlpStatus{Code: 2, Message: s.err}
+		}
+		s.mu.Unlock()
+		out = append(out, span)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: resource},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/Zayan-Mohamed/orb", Version: e.cfg.version},
+			Spans: out,
+		}},
+	}}}
+}
+
+// keyValue converts an attribute to its OTLP form
+func keyValue(a slog.Attr) otlpKeyValue {
+	v := a.Value.Resolve()
+	var out otlpValue
+	switch v.Kind() {
+	case slog.KindBool:
+		b := v.Bool()
+		out.BoolValue = &b
+	case slog.KindInt64:
+		i := strconv.FormatInt(v.Int64(), 10)
+		out.IntValue = &i
+	case slog.KindUint64:
+		i := strconv.FormatUint(v.Uint64(), 10)
+		out.IntValue = &i
+	case slog.KindFloat64:
+		f := v.Float64()
+		out.DoubleValue = &f
+	case slog.KindDuration:
+		i := strconv.FormatInt(v.Duration().Milliseconds(), 10)
+		out.IntValue = &i
+	default:
+		s := v.String()
+		out.StringValue = &s
+	}
+	return otlpKeyValue{Key: a.Key, Value: out}
+}
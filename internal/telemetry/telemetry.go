@@ -0,0 +1,214 @@
+// Package telemetry records OpenTelemetry traces of sessions, handshakes,
+// requests and relay forwarding, and exports them over OTLP/HTTP so that
+// operators can follow a slow transfer from the receiver through the relay
+// to the sharer.
+//
+// Tracing is off unless an OTLP endpoint is configured with the standard
+// OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT
+// variables. Spans carry IDs, request types, sizes and timings only, never
+// paths, passcodes or file contents.
+//
+// Trace context crosses process boundaries as a W3C traceparent: in an HTTP
+// header towards the relay, and in the encrypted frames between the peers.
+package telemetry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kind tells the role of a span in a trace, as in OTLP
+type Kind int
+
+// Span kinds
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// SpanContext identifies a span across processes
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// IsValid reports whether sc identifies a span
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats sc as a W3C traceparent, "" when it is not valid
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-01"
+}
+
+// ParseTraceparent reads a W3C traceparent. Anything else gives an invalid
+// SpanContext, so that a bad header merely starts a new trace.
+func ParseTraceparent(s string) SpanContext {
+	var sc SpanContext
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return SpanContext{}
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}
+	}
+	return sc
+}
+
+// Span is an operation being timed. A nil *Span, returned while tracing is
+// off, ignores every call.
+type Span struct {
+	name   string
+	kind   Kind
+	sc     SpanContext
+	parent [8]byte
+	start  time.Time
+
+	mu     sync.Mutex
+	end    time.Time
+	attrs  []slog.Attr
+	err    string
+	failed bool
+	ended  bool
+}
+
+// SetAttributes adds attributes to the span
+func (s *Span) SetAttributes(attrs ...slog.Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// End finishes the span and hands it to the exporter. A non-nil err marks
+// the span as failed. Only the first call counts.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	if err != nil {
+		s.failed = true
+		s.err = err.Error()
+	}
+	s.mu.Unlock()
+
+	if exp := current(); exp != nil {
+		exp.add(s)
+	}
+}
+
+// Context returns the identity of the span, for passing it on to another
+// process
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+type spanKey struct{}
+
+// ContextWithRemote returns a context whose spans are children of sc,
+// a span of another process
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, sc)
+}
+
+// FromContext returns the span context that new spans in ctx are children of
+func FromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(spanKey{}).(SpanContext)
+	return sc
+}
+
+// Start begins a span as a child of the span in ctx, or of a new trace. It
+// returns nil and ctx unchanged while tracing is off.
+func Start(ctx context.Context, name string, kind Kind, attrs ...slog.Attr) (context.Context, *Span) {
+	if current() == nil {
+		return ctx, nil
+	}
+
+	parent := FromContext(ctx)
+	s := &Span{
+		name:  name,
+		kind:  kind,
+		start: time.Now(),
+		attrs: attrs,
+	}
+	if parent.IsValid() {
+		s.sc.TraceID = parent.TraceID
+		s.parent = parent.SpanID
+	} else {
+		_, _ = rand.Read(s.sc.TraceID[:])
+	}
+	_, _ = rand.Read(s.sc.SpanID[:])
+
+	return context.WithValue(ctx, spanKey{}, s.sc), s
+}
+
+// Enabled reports whether spans are being exported
+func Enabled() bool {
+	return current() != nil
+}
+
+// active is the exporter while tracing is on
+var active atomic.Pointer[exporter]
+
+func current() *exporter {
+	return active.Load()
+}
+
+// Setup starts exporting spans when the environment names an OTLP
+// endpoint. service becomes service.name unless OTEL_SERVICE_NAME is set.
+func Setup(service, version string) error {
+	cfg, err := configFromEnv(service, version)
+	if err != nil || cfg == nil {
+		return err
+	}
+
+	// Commands run again in the same process, e.g. as a Windows service,
+	// keep the exporter they have
+	if active.Load() != nil {
+		return nil
+	}
+	active.Store(newExporter(*cfg))
+	slog.Debug("exporting traces", "endpoint", cfg.endpoint)
+	return nil
+}
+
+// Shutdown exports the spans that are still buffered and stops tracing
+func Shutdown() {
+	if exp := active.Swap(nil); exp != nil {
+		if err := exp.close(5 * time.Second); err != nil {
+			slog.Warn("failed to export traces", "err", err)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package transfer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/delta"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// errNoDelta tells that a transfer has nothing to reuse, or a sharer that
+// cannot help, and copies the whole file instead
+var errNoDelta = errors.New("delta transfer not possible")
+
+// maxSignatureBlocks bounds the blocks asked for in one signature request,
+// the sharer returns fewer for large blocks
+const maxSignatureBlocks = 1 << 20
+
+// signature fetches the block checksums of a remote file of the given size
+func (m *Manager) signature(ctx context.Context, remotePath string, size int64) (*delta.Signature, error) {
+	sig := &delta.Signature{BlockSize: delta.BlockSize(size), Size: size}
+	blocks := sig.Blocks()
+	for first := int64(0); first < blocks; first = int64(len(sig.Weak)) {
+		resp, err := m.client.Signature(ctx, remotePath, sig.BlockSize, first, int(min(blocks-first, maxSignatureBlocks)))
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Weak) == 0 || len(resp.Strong) != len(resp.Weak)*delta.StrongSize {
+			return nil, fmt.Errorf("%s changed on the sharer during the transfer", remotePath)
+		}
+		sig.Weak = append(sig.Weak, resp.Weak...)
+		sig.Strong = append(sig.Strong, resp.Strong...)
+	}
+	return sig, nil
+}
+
+// remoteSignature is signature for delta transfers: a sharer that answers
+// with an error, e.g. because it predates delta transfers, gets errNoDelta
+func (m *Manager) remoteSignature(ctx context.Context, remotePath string, size int64) (*delta.Signature, error) {
+	sig, err := m.signature(ctx, remotePath, size)
+	var remoteErr *protocol.ErrorResponse
+	if errors.As(err, &remoteErr) {
+		slog.Debug("copying the whole file", "path", remotePath, "reason", remoteErr.Message)
+		return nil, errNoDelta
+	}
+	return sig, err
+}
+
+// restart drops the progress of a job that starts over, without taking back
+// the bytes it already moved. delta tells whether it starts a delta transfer.
+func (m *Manager) restart(j *job, delta bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j.Transferred = 0
+	j.Reused = 0
+	j.sampledBytes = 0
+	j.delta = delta
+	m.observe(j)
+	m.notify()
+}
+
+// reuse records n bytes of a running job taken from the existing copy
+// instead of the tunnel
+func (m *Manager) reuse(j *job, n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j.Transferred += n
+	j.Reused += n
+	m.notify()
+}
+
+// deltaDownload rebuilds the local file of a download from the blocks it
+// shares with the remote file, fetching only the others. The local file is
+// replaced once the new one is complete. It returns errNoDelta when there
+// is no local file to reuse.
+func (m *Manager) deltaDownload(ctx context.Context, j *job) (err error) {
+	info, err := os.Stat(j.LocalPath)
+	if err != nil || !info.Mode().IsRegular() || info.Size() == 0 || j.Size < delta.MinBlockSize {
+		return errNoDelta
+	}
+	sig, err := m.remoteSignature(ctx, j.RemotePath, j.Size)
+	if err != nil {
+		return err
+	}
+	m.restart(j, true)
+
+	// #nosec G304 -- local paths are chosen by the receiving user, not the sharer
+	basis, err := os.Open(j.LocalPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = basis.Close() }()
+
+	// Where each block of the remote file is found locally, -1 if not
+	have := make([]int64, sig.Blocks())
+	for i := range have {
+		have[i] = -1
+	}
+	err = delta.Find(ctx, basis, info.Size(), sig, func(offset int64, blocks []int64) error {
+		for _, b := range blocks {
+			if have[b] < 0 {
+				have[b] = offset
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to compare with %s: %w", j.LocalPath, err)
+	}
+
+	temp := j.LocalPath + ".orb-delta"
+	// #nosec G304 -- the temporary file sits next to the user's local file
+	file, err := os.OpenFile(temp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if file != nil {
+			_ = file.Close()
+		}
+		if err != nil {
+			_ = os.Remove(temp)
+		}
+	}()
+
+	buf := make([]byte, sig.BlockSize)
+	for i := int64(0); i < sig.Blocks(); {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		offset := i * sig.BlockSize
+		if have[i] >= 0 {
+			n := sig.BlockLength(i)
+			if _, err := basis.ReadAt(buf[:n], have[i]); err != nil {
+				return err
+			}
+			if _, err := file.WriteAt(buf[:n], offset); err != nil {
+				return err
+			}
+			m.reuse(j, n)
+			i++
+			continue
+		}
+
+		// Fetch the run of blocks the local file lacks
+		end := i
+		for end < sig.Blocks() && have[end] < 0 {
+			end++
+		}
+		for offset < min(end*sig.BlockSize, j.Size) {
+			length := min(min(end*sig.BlockSize, j.Size)-offset, ChunkSize)
+			if err := m.fetchChunk(ctx, j, file, offset, length); err != nil {
+				return err
+			}
+			offset += length
+			m.progress(j, offset)
+		}
+		i = end
+	}
+
+	err = file.Close()
+	file = nil
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(temp, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(temp, j.LocalPath)
+}
+
+// deltaUpload rebuilds the remote file of an upload on the sharer from the
+// blocks it shares with the local file, sending only the others. The remote
+// file is replaced once the new one is complete. It returns errNoDelta when
+// there is no remote file to reuse.
+func (m *Manager) deltaUpload(ctx context.Context, j *job) (err error) {
+	if j.Size < delta.MinBlockSize {
+		return errNoDelta
+	}
+	stat, err := m.client.Stat(ctx, j.RemotePath)
+	if err != nil || stat.IsDir || stat.Size == 0 {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return errNoDelta
+	}
+	sig, err := m.remoteSignature(ctx, j.RemotePath, stat.Size)
+	if err != nil {
+		return err
+	}
+	m.restart(j, true)
+
+	// #nosec G304 -- local paths are chosen by the user running orb
+	file, err := os.Open(j.LocalPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	// Local offsets whose block the remote file already has
+	type match struct{ offset, block int64 }
+	var matches []match
+	err = delta.Find(ctx, file, j.Size, sig, func(offset int64, blocks []int64) error {
+		matches = append(matches, match{offset, blocks[0]})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to compare with %s: %w", j.RemotePath, err)
+	}
+
+	// The new file is built next to the old one, which it is copied from
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	temp := j.RemotePath + ".orb-delta-" + hex.EncodeToString(suffix)
+	defer func() {
+		if err != nil {
+			cleanup, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			_ = m.client.Delete(cleanup, temp)
+		}
+	}()
+
+	var offset int64
+	for i := 0; i < len(matches); {
+		start := matches[i]
+		if err := m.sendRange(ctx, j, file, temp, offset, start.offset); err != nil {
+			return err
+		}
+
+		// Blocks that follow each other on both sides are copied at once
+		length := sig.BlockSize
+		for i++; i < len(matches) && length+sig.BlockSize <= protocol.MaxCopyLength; i++ {
+			next := matches[i]
+			if next.offset != start.offset+length || next.block*sig.BlockSize != start.block*sig.BlockSize+length {
+				break
+			}
+			length += sig.BlockSize
+		}
+		copied, err := m.client.Copy(ctx, temp, start.offset, j.RemotePath, start.block*sig.BlockSize, length)
+		if err != nil {
+			return err
+		}
+		if copied != length {
+			return fmt.Errorf("the sharer copied %d of %d bytes at offset %d", copied, length, start.offset)
+		}
+		m.reuse(j, length)
+		offset = start.offset + length
+	}
+	if err := m.sendRange(ctx, j, file, temp, offset, j.Size); err != nil {
+		return err
+	}
+
+	return m.client.Rename(ctx, temp, j.RemotePath)
+}
+
+// sendRange uploads the bytes from offset up to end of a local file to the
+// same offsets of a remote file
+func (m *Manager) sendRange(ctx context.Context, j *job, file io.ReaderAt, remotePath string, offset, end int64) error {
+	buf := make([]byte, ChunkSize)
+	for offset < end {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := file.ReadAt(buf[:min(end-offset, ChunkSize)], offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("unexpected end of local file at offset %d", offset)
+		}
+
+		written, err := m.client.Write(ctx, remotePath, offset, buf[:n])
+		if err != nil {
+			return err
+		}
+		if written == 0 {
+			return fmt.Errorf("remote accepted no data at offset %d", offset)
+		}
+
+		offset += written
+		m.progress(j, offset)
+	}
+	return nil
+}
@@ -0,0 +1,845 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/metrics"
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/internal/telemetry"
+)
+
+const (
+	// ChunkSize is the amount of data requested per read/write frame
+	ChunkSize = 64 * 1024 // 64KB chunks
+
+	// DefaultConcurrency is the number of transfers run at the same time
+	DefaultConcurrency = 3
+
+	// MaxParallel bounds the chunks of one download fetched at the same
+	// time, in line with the requests a sharer serves at once
+	MaxParallel = 16
+
+	// speedInterval is the minimum time between speed samples
+	speedInterval = 500 * time.Millisecond
+
+	// speedSmoothing is the weight of the newest sample in the moving average
+	speedSmoothing = 0.3
+)
+
+// ErrChecksumMismatch fails a verified transfer whose copy differs from the
+// sharer's file
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Metrics of the transfers of this process, see --metrics-addr
+var (
+	transfersActive = metrics.NewGauge("orb_transfers_active", "Transfers currently running", "direction")
+	transfersDone   = metrics.NewCounter("orb_transfers_total", "Transfers that ended, by direction and final state", "direction", "state")
+	transferBytes   = metrics.NewCounter("orb_transfer_bytes_total", "File bytes moved by transfers", "direction")
+)
+
+// Direction tells whether a transfer pulls from or pushes to the sharer
+type Direction int
+
+const (
+	Download Direction = iota
+	Upload
+)
+
+func (d Direction) String() string {
+	if d == Upload {
+		return "upload"
+	}
+	return "download"
+}
+
+// State is the lifecycle state of a transfer
+type State int
+
+const (
+	StateQueued State = iota
+	StateRunning
+	StatePaused
+	StateDone
+	StateFailed
+	StateCancelled
+)
+
+func (s State) String() string {
+	switch s {
+	case StateQueued:
+		return "queued"
+	case StateRunning:
+		return "running"
+	case StatePaused:
+		return "paused"
+	case StateDone:
+		return "done"
+	case StateFailed:
+		return "failed"
+	case StateCancelled:
+		return "cancelled"
+	default:
+		return "unknown"
+	}
+}
+
+// Finished reports whether the transfer has reached a terminal state
+func (s State) Finished() bool {
+	return s == StateDone || s == StateFailed || s == StateCancelled
+}
+
+// Transfer is a snapshot of a single queued, running or finished transfer
+type Transfer struct {
+	ID          int
+	Direction   Direction
+	RemotePath  string
+	LocalPath   string
+	Size        int64
+	Transferred int64
+	Reused      int64 // of Transferred, taken from the existing copy by a delta transfer
+	State       State
+	Err         error
+	Started     time.Time
+	Finished    time.Time
+	Speed       float64 // smoothed bytes per second while running
+	SHA256      []byte  // checksum both sides agreed on, set when verified
+}
+
+// Progress returns the completed percentage of the transfer
+func (t Transfer) Progress() float64 {
+	if t.Size <= 0 {
+		if t.State == StateDone {
+			return 100
+		}
+		return 0
+	}
+	return float64(t.Transferred) / float64(t.Size) * 100
+}
+
+// ETA estimates the time left from the smoothed speed. It returns false
+// when there is no estimate yet.
+func (t Transfer) ETA() (time.Duration, bool) {
+	if t.State != StateRunning || t.Speed <= 0 || t.Size <= t.Transferred {
+		return 0, false
+	}
+	seconds := float64(t.Size-t.Transferred) / t.Speed
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+// job is the manager-owned mutable state behind a Transfer
+type job struct {
+	Transfer
+	cancel context.CancelFunc
+	pause  bool
+	delta  bool // rebuilding the file from the existing copy, see SetDelta
+
+	// Last speed sample
+	sampledAt    time.Time
+	sampledBytes int64
+}
+
+// Manager queues transfers and runs a bounded number of them concurrently
+// over a single multiplexed tunnel
+type Manager struct {
+	client      *remote.Client
+	concurrency int
+	parallel    int  // chunks of one download fetched at the same time
+	verify      bool // compare checksums after each transfer
+	delta       bool // reuse the blocks of existing copies
+	mu          sync.Mutex
+	jobs        []*job
+	nextID      int
+	running     int
+	bytes       int64      // total bytes moved by all transfers
+	history     []Transfer // completed and failed transfers, oldest first
+	observer    func(Transfer)
+	updates     chan struct{}
+	wg          sync.WaitGroup // running jobs
+}
+
+// NewManager creates a transfer manager
+func NewManager(client *remote.Client, concurrency int) *Manager {
+	if concurrency < 1 {
+		concurrency = DefaultConcurrency
+	}
+
+	return &Manager{
+		client:      client,
+		concurrency: concurrency,
+		parallel:    1,
+		updates:     make(chan struct{}, 1),
+	}
+}
+
+// SetVerify makes every transfer compare the SHA-256 checksum of the local
+// file with the one the sharer computes once the copy is complete. A
+// mismatch fails the transfer with ErrChecksumMismatch and removes a
+// downloaded file.
+func (m *Manager) SetVerify(verify bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.verify = verify
+}
+
+// SetDelta makes a transfer that replaces an existing copy of the file,
+// local for downloads and remote for uploads, move only the blocks that
+// differ between the two. The rest is taken from the existing copy, which
+// is replaced once the new one is complete. Transfers that resume from an
+// offset, and sharers that predate delta transfers, copy as before.
+func (m *Manager) SetDelta(delta bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.delta = delta
+}
+
+// SetParallel sets how many chunks of each download are fetched at the same
+// time. More than one keeps a high-latency link busy while earlier requests
+// are still on their way.
+func (m *Manager) SetParallel(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.parallel = max(1, min(n, MaxParallel))
+}
+
+// SetObserver makes the manager call observe with a snapshot of a transfer
+// whenever it is queued, starts, makes progress, pauses or ends, e.g. to
+// record it on disk. observe runs with the manager locked, so it must return
+// quickly and must not call the manager.
+func (m *Manager) SetObserver(observe func(Transfer)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observer = observe
+}
+
+// Updates returns a channel that receives a value whenever any transfer changes.
+// Notifications are coalesced, so receivers should call Snapshot afterwards.
+func (m *Manager) Updates() <-chan struct{} {
+	return m.updates
+}
+
+// Enqueue adds a transfer to the queue and returns its ID
+func (m *Manager) Enqueue(dir Direction, remotePath, localPath string, size int64) int {
+	return m.EnqueueFrom(dir, remotePath, localPath, size, 0)
+}
+
+// EnqueueFrom adds a transfer that starts at offset, e.g. to finish a
+// download whose first offset bytes already exist locally
+func (m *Manager) EnqueueFrom(dir Direction, remotePath, localPath string, size, offset int64) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	j := &job{
+		Transfer: Transfer{
+			ID:          m.nextID,
+			Direction:   dir,
+			RemotePath:  remotePath,
+			LocalPath:   localPath,
+			Size:        size,
+			Transferred: offset,
+			State:       StateQueued,
+		},
+	}
+	m.jobs = append(m.jobs, j)
+	m.observe(j)
+
+	m.schedule()
+	m.notify()
+
+	return m.nextID
+}
+
+// Snapshot returns a copy of all transfers in queue order
+func (m *Manager) Snapshot() []Transfer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	transfers := make([]Transfer, len(m.jobs))
+	for i, j := range m.jobs {
+		transfers[i] = j.Transfer
+	}
+	return transfers
+}
+
+// Active returns the number of transfers that are queued or running
+func (m *Manager) Active() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, j := range m.jobs {
+		if j.State == StateQueued || j.State == StateRunning {
+			n++
+		}
+	}
+	return n
+}
+
+// BytesTransferred returns the total number of bytes moved by all transfers
+// since the manager was created, including ones cleared from the list
+func (m *Manager) BytesTransferred() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.bytes
+}
+
+// History returns every transfer that completed or failed since the manager
+// was created, oldest first. Unlike Snapshot it is not affected by ClearFinished.
+func (m *Manager) History() []Transfer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Transfer(nil), m.history...)
+}
+
+// Pause stops a queued or running transfer, keeping its progress so it can be resumed
+func (m *Manager) Pause(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j := m.find(id)
+	if j == nil {
+		return fmt.Errorf("transfer %d not found", id)
+	}
+
+	switch j.State {
+	case StateQueued:
+		j.State = StatePaused
+		m.observe(j)
+	case StateRunning:
+		j.pause = true
+		j.cancel()
+	default:
+		return fmt.Errorf("transfer %d is %s", id, j.State)
+	}
+
+	m.notify()
+	return nil
+}
+
+// Resume puts a paused transfer back in the queue
+func (m *Manager) Resume(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j := m.find(id)
+	if j == nil {
+		return fmt.Errorf("transfer %d not found", id)
+	}
+	if j.State != StatePaused {
+		return fmt.Errorf("transfer %d is %s", id, j.State)
+	}
+
+	j.State = StateQueued
+	m.observe(j)
+	m.schedule()
+	m.notify()
+	return nil
+}
+
+// Cancel aborts a transfer. Partially downloaded files are removed.
+func (m *Manager) Cancel(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j := m.find(id)
+	if j == nil {
+		return fmt.Errorf("transfer %d not found", id)
+	}
+
+	switch j.State {
+	case StateQueued, StatePaused:
+		j.State = StateCancelled
+		j.Finished = time.Now()
+		m.removePartial(j)
+		m.observe(j)
+	case StateRunning:
+		j.pause = false
+		j.cancel()
+	default:
+		return fmt.Errorf("transfer %d is %s", id, j.State)
+	}
+
+	m.notify()
+	return nil
+}
+
+// CancelAll aborts every unfinished transfer
+func (m *Manager) CancelAll() {
+	for _, t := range m.Snapshot() {
+		if !t.State.Finished() {
+			_ = m.Cancel(t.ID)
+		}
+	}
+}
+
+// Wait blocks until no transfer is running. After CancelAll it returns
+// once cancelled downloads have closed and removed their files.
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
+
+// ClearFinished drops completed, failed and cancelled transfers from the list
+func (m *Manager) ClearFinished() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.jobs[:0]
+	for _, j := range m.jobs {
+		if !j.State.Finished() {
+			kept = append(kept, j)
+		}
+	}
+	m.jobs = kept
+	m.notify()
+}
+
+// find returns the job with the given ID. Caller must hold m.mu.
+func (m *Manager) find(id int) *job {
+	for _, j := range m.jobs {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// schedule starts queued jobs while there are free slots. Caller must hold m.mu.
+func (m *Manager) schedule() {
+	for _, j := range m.jobs {
+		if m.running >= m.concurrency {
+			return
+		}
+		if j.State != StateQueued {
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		j.cancel = cancel
+		j.pause = false
+		j.State = StateRunning
+		if j.Started.IsZero() {
+			j.Started = time.Now()
+		}
+		j.Speed = 0
+		j.sampledAt = time.Now()
+		j.sampledBytes = j.Transferred
+		m.running++
+		transfersActive.Add(1, j.Direction.String())
+		m.observe(j)
+
+		m.wg.Add(1)
+		go m.run(ctx, j)
+	}
+}
+
+// observe passes a snapshot of j to the observer, if any. Caller must hold m.mu.
+func (m *Manager) observe(j *job) {
+	if m.observer != nil {
+		m.observer(j.Transfer)
+	}
+}
+
+// notify signals listeners without blocking. Caller must hold m.mu.
+func (m *Manager) notify() {
+	select {
+	case m.updates <- struct{}{}:
+	default:
+	}
+}
+
+// run executes a single job and records its outcome
+func (m *Manager) run(ctx context.Context, j *job) {
+	defer m.wg.Done()
+
+	// The requests of the transfer become children of its span
+	ctx, span := telemetry.Start(ctx, "transfer "+j.Direction.String(), telemetry.KindInternal,
+		slog.Int64("orb.transfer.size", j.Size))
+
+	var err error
+	if j.Direction == Upload {
+		err = m.upload(ctx, j)
+	} else {
+		err = m.download(ctx, j)
+	}
+
+	m.mu.Lock()
+	verify := m.verify
+	m.mu.Unlock()
+	var sum []byte
+	if err == nil && verify {
+		sum, err = m.verifyChecksum(ctx, j)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j.cancel()
+	m.running--
+	transfersActive.Add(-1, j.Direction.String())
+	j.Speed = 0
+
+	switch {
+	case err == nil:
+		j.State = StateDone
+		j.Finished = time.Now()
+		j.SHA256 = sum
+	case errors.Is(err, context.Canceled) && j.pause:
+		j.State = StatePaused
+	case errors.Is(err, context.Canceled):
+		j.State = StateCancelled
+		j.Finished = time.Now()
+		m.removePartial(j)
+	default:
+		j.State = StateFailed
+		j.Err = err
+		j.Finished = time.Now()
+	}
+
+	if j.State == StateDone || j.State == StateFailed {
+		m.history = append(m.history, j.Transfer)
+	}
+	if j.State != StatePaused {
+		transfersDone.Inc(j.Direction.String(), j.State.String())
+	}
+	m.observe(j)
+	span.SetAttributes(slog.Int64("orb.transfer.bytes", j.Transferred), slog.String("orb.transfer.state", j.State.String()))
+	if j.State == StateFailed {
+		span.End(err)
+	} else {
+		span.End(nil)
+	}
+
+	m.schedule()
+	m.notify()
+}
+
+// progress records transferred bytes for a running job
+func (m *Manager) progress(j *job, transferred int64) {
+	m.mu.Lock()
+	m.bytes += transferred - j.Transferred
+	if transferred > j.Transferred {
+		transferBytes.Add(float64(transferred-j.Transferred), j.Direction.String())
+	}
+	j.Transferred = transferred
+
+	now := time.Now()
+	if elapsed := now.Sub(j.sampledAt); elapsed >= speedInterval {
+		sample := float64(transferred-j.sampledBytes) / elapsed.Seconds()
+		if j.Speed == 0 {
+			j.Speed = sample
+		} else {
+			j.Speed = speedSmoothing*sample + (1-speedSmoothing)*j.Speed
+		}
+		j.sampledAt = now
+		j.sampledBytes = transferred
+	}
+
+	m.observe(j)
+	m.notify()
+	m.mu.Unlock()
+}
+
+// verifyChecksum compares a finished transfer with the sharer's copy and
+// returns the checksum they share
+func (m *Manager) verifyChecksum(ctx context.Context, j *job) ([]byte, error) {
+	// #nosec G304 -- local paths are chosen by the user running orb
+	file, err := os.Open(j.LocalPath)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, file)
+	_ = file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum %s: %w", j.LocalPath, err)
+	}
+	localSum := h.Sum(nil)
+
+	remoteSum, err := m.client.Hash(ctx, j.RemotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the sharer's checksum: %w", err)
+	}
+	if !bytes.Equal(localSum, remoteSum) {
+		if j.Direction == Download {
+			_ = os.Remove(j.LocalPath)
+		}
+		return nil, fmt.Errorf("%w: local %x, sharer %x", ErrChecksumMismatch, localSum[:8], remoteSum[:min(8, len(remoteSum))])
+	}
+	return localSum, nil
+}
+
+// removePartial deletes the local file of an unfinished download. Caller must hold m.mu.
+func (m *Manager) removePartial(j *job) {
+	// A delta download removes its own temporary file, the local file is
+	// the old copy
+	if j.Direction != Download || j.Transferred == 0 || j.delta {
+		return
+	}
+	if err := os.Remove(j.LocalPath); err != nil && !os.IsNotExist(err) {
+		j.Err = err
+	}
+}
+
+// download copies a remote file to the local path, continuing from any
+// progress made before the transfer was paused
+func (m *Manager) download(ctx context.Context, j *job) error {
+	m.mu.Lock()
+	offset := j.Transferred
+	parallel := m.parallel
+	useDelta := m.delta && (offset == 0 || j.delta)
+	m.mu.Unlock()
+
+	if useDelta {
+		if err := m.deltaDownload(ctx, j); !errors.Is(err, errNoDelta) {
+			return err
+		}
+		m.restart(j, false)
+		offset = 0
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+
+	// #nosec G304 -- local paths are chosen by the receiving user, not the sharer
+	file, err := os.OpenFile(j.LocalPath, flags, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to close file %s: %v\n", j.LocalPath, err)
+		}
+	}()
+
+	if parallel > 1 && j.Size-offset > ChunkSize {
+		return m.downloadParallel(ctx, j, file, offset, parallel)
+	}
+
+	for offset < j.Size {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		length := j.Size - offset
+		if length > ChunkSize {
+			length = ChunkSize
+		}
+
+		data, err := m.client.Read(ctx, j.RemotePath, offset, length)
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return fmt.Errorf("unexpected end of file at offset %d", offset)
+		}
+
+		if _, err := file.WriteAt(data, offset); err != nil {
+			return err
+		}
+
+		offset += int64(len(data))
+		m.progress(j, offset)
+	}
+
+	return nil
+}
+
+// downloadParallel fetches up to parallel chunks of a download at a time and
+// writes each at its offset. Progress only counts the bytes before the first
+// missing chunk, so that a paused download resumes without a gap.
+func (m *Manager) downloadParallel(ctx context.Context, j *job, file *os.File, offset int64, parallel int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		next     = offset            // start of the next chunk to fetch
+		prefix   = offset            // every byte before it is written
+		finished = map[int64]int64{} // written chunks after prefix, by offset
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	for range parallel {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				if firstErr != nil || next >= j.Size {
+					mu.Unlock()
+					return
+				}
+				start := next
+				length := min(j.Size-start, ChunkSize)
+				next += length
+				mu.Unlock()
+
+				err := m.fetchChunk(ctx, j, file, start, length)
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+						cancel()
+					}
+					mu.Unlock()
+					return
+				}
+				finished[start] = length
+				for n, ok := finished[prefix]; ok; n, ok = finished[prefix] {
+					delete(finished, prefix)
+					prefix += n
+				}
+				m.progress(j, prefix)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+	if firstErr != nil {
+		// Drop chunks written after a gap, the file then ends where the
+		// download continues
+		if err := file.Truncate(prefix); err != nil {
+			return err
+		}
+	}
+	return firstErr
+}
+
+// fetchChunk reads length bytes at offset from the sharer into file
+func (m *Manager) fetchChunk(ctx context.Context, j *job, file *os.File, offset, length int64) error {
+	for length > 0 {
+		data, err := m.client.Read(ctx, j.RemotePath, offset, length)
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return fmt.Errorf("unexpected end of file at offset %d", offset)
+		}
+		if _, err := file.WriteAt(data, offset); err != nil {
+			return err
+		}
+		offset += int64(len(data))
+		length -= int64(len(data))
+	}
+	return nil
+}
+
+// upload copies a local file to the remote path, continuing from any
+// progress made before the transfer was paused
+func (m *Manager) upload(ctx context.Context, j *job) error {
+	m.mu.Lock()
+	offset := j.Transferred
+	useDelta := m.delta && (offset == 0 || j.delta)
+	m.mu.Unlock()
+
+	if useDelta {
+		if err := m.deltaUpload(ctx, j); !errors.Is(err, errNoDelta) {
+			return err
+		}
+		m.restart(j, false)
+		offset = 0
+	}
+
+	// #nosec G304 -- local paths are chosen by the user running orb
+	file, err := os.Open(j.LocalPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to close file %s: %v\n", j.LocalPath, err)
+		}
+	}()
+
+	// Empty files still need to be created on the sharer, and the last
+	// write cuts off the end of a longer remote file that the upload replaces
+	if j.Size == 0 {
+		_, err := m.client.WriteTruncate(ctx, j.RemotePath, 0, nil)
+		if errors.Is(err, remote.ErrNoTruncate) {
+			return m.replaceLonger(ctx, j, file)
+		}
+		return err
+	}
+
+	buf := make([]byte, ChunkSize)
+	for offset < j.Size {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := file.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("unexpected end of local file at offset %d", offset)
+		}
+
+		write := m.client.Write
+		if offset+int64(n) >= j.Size {
+			write = m.client.WriteTruncate
+		}
+		written, err := write(ctx, j.RemotePath, offset, buf[:n])
+		if errors.Is(err, remote.ErrNoTruncate) {
+			return m.replaceLonger(ctx, j, file)
+		}
+		if err != nil {
+			return err
+		}
+		if written == 0 {
+			return fmt.Errorf("remote accepted no data at offset %d", offset)
+		}
+
+		offset += written
+		m.progress(j, offset)
+	}
+
+	return nil
+}
+
+// replaceLonger finishes an upload to a sharer that cannot cut files off.
+// A remote file left longer than the local one is uploaded again under a
+// temporary name, which then replaces it.
+func (m *Manager) replaceLonger(ctx context.Context, j *job, file io.ReaderAt) (err error) {
+	stat, err := m.client.Stat(ctx, j.RemotePath)
+	if err != nil || stat.Size <= j.Size {
+		return err
+	}
+	m.restart(j, false)
+
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	temp := j.RemotePath + ".orb-upload-" + hex.EncodeToString(suffix)
+	defer func() {
+		if err != nil {
+			cleanup, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			_ = m.client.Delete(cleanup, temp)
+		}
+	}()
+
+	if _, err := m.client.Write(ctx, temp, 0, nil); err != nil {
+		return err
+	}
+	if err := m.sendRange(ctx, j, file, temp, 0, j.Size); err != nil {
+		return err
+	}
+	return m.client.Rename(ctx, temp, j.RemotePath)
+}
@@ -0,0 +1,188 @@
+package tui
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// bookmarkStore persists bookmarked remote directories, keyed by session ID
+type bookmarkStore struct {
+	path string
+}
+
+// newBookmarkStore uses bookmarks.json next to config.yaml
+func newBookmarkStore() (*bookmarkStore, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return nil, err
+	}
+	return &bookmarkStore{path: filepath.Join(dir, "bookmarks.json")}, nil
+}
+
+// readAll loads bookmarks for every session
+func (s *bookmarkStore) readAll() (map[string][]string, error) {
+	all := make(map[string][]string)
+
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return all, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bookmarks: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("failed to parse bookmarks: %w", err)
+	}
+	return all, nil
+}
+
+// Load returns the bookmarks saved for a session
+func (s *bookmarkStore) Load(sessionID string) ([]string, error) {
+	all, err := s.readAll()
+	if err != nil {
+		return nil, err
+	}
+	return all[sessionID], nil
+}
+
+// Save replaces the bookmarks saved for a session
+func (s *bookmarkStore) Save(sessionID string, bookmarks []string) error {
+	all, err := s.readAll()
+	if err != nil {
+		return err
+	}
+
+	if len(bookmarks) == 0 {
+		delete(all, sessionID)
+	} else {
+		all[sessionID] = bookmarks
+	}
+
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bookmarks: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write bookmarks: %w", err)
+	}
+	return nil
+}
+
+// bookmarkItem is a bookmarked directory in the bookmarks list
+type bookmarkItem string
+
+func (i bookmarkItem) Title() string       { return "🔖 " + string(i) }
+func (i bookmarkItem) Description() string { return "" }
+func (i bookmarkItem) FilterValue() string { return string(i) }
+
+func newBookmarkList(keys KeyMap, st styles) list.Model {
+	delegate := st.delegate
+	delegate.ShowDescription = false
+
+	l := st.newList("Bookmarks")
+	l.SetDelegate(delegate)
+	l.SetShowStatusBar(false)
+	l.SetFilteringEnabled(false)
+	keys.applyToList(&l)
+	return l
+}
+
+// addBookmark bookmarks the current remote directory
+func (m model) addBookmark() (model, tea.Cmd, bool) {
+	for _, b := range m.bookmarkPaths {
+		if b == m.currentPath {
+			m.notice = m.currentPath + " is already bookmarked"
+			return m, nil, true
+		}
+	}
+
+	m.bookmarkPaths = append(append([]string{}, m.bookmarkPaths...), m.currentPath)
+	if err := m.saveBookmarks(); err != nil {
+		m.error = err.Error()
+		return m, nil, true
+	}
+
+	m.error = ""
+	m.notice = "Bookmarked " + m.currentPath
+	return m, nil, true
+}
+
+// saveBookmarks persists the bookmarks when a store is available
+func (m model) saveBookmarks() error {
+	if m.bookmarkStore == nil || m.sessionID == "" {
+		return nil
+	}
+	return m.bookmarkStore.Save(m.sessionID, m.bookmarkPaths)
+}
+
+// openBookmarks shows the bookmarks list
+func (m model) openBookmarks() (model, tea.Cmd, bool) {
+	if len(m.bookmarkPaths) == 0 {
+		m.notice = "No bookmarks yet. Press b to bookmark the current directory."
+		return m, nil, true
+	}
+
+	m.showBookmarks = true
+	return m, m.refreshBookmarkList(), true
+}
+
+func (m *model) refreshBookmarkList() tea.Cmd {
+	items := make([]list.Item, len(m.bookmarkPaths))
+	for i, b := range m.bookmarkPaths {
+		items[i] = bookmarkItem(b)
+	}
+	return m.bookmarks.SetItems(items)
+}
+
+// handleBookmarksKey handles keys while the bookmarks list is shown
+func (m model) handleBookmarksKey(msg tea.KeyMsg) (model, tea.Cmd, bool) {
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("q"))):
+		return m.quit()
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("esc", "B"))):
+		m.showBookmarks = false
+		return m, nil, true
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("enter"))):
+		selected, ok := m.bookmarks.SelectedItem().(bookmarkItem)
+		if !ok {
+			return m, nil, true
+		}
+		m.showBookmarks = false
+		m.currentPath = string(selected)
+		m.list.ResetSelected()
+		return m, m.loadDirectory(), true
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("x", "delete"))):
+		index := m.bookmarks.Index()
+		if index < 0 || index >= len(m.bookmarkPaths) {
+			return m, nil, true
+		}
+		kept := append([]string{}, m.bookmarkPaths[:index]...)
+		m.bookmarkPaths = append(kept, m.bookmarkPaths[index+1:]...)
+		if err := m.saveBookmarks(); err != nil {
+			m.error = err.Error()
+		}
+		if len(m.bookmarkPaths) == 0 {
+			m.showBookmarks = false
+		}
+		return m, m.refreshBookmarkList(), true
+	}
+
+	var cmd tea.Cmd
+	m.bookmarks, cmd = m.bookmarks.Update(msg)
+	return m, cmd, true
+}
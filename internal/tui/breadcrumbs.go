@@ -0,0 +1,120 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// breadcrumbSeparator is drawn between path segments
+const breadcrumbSeparator = " › "
+
+// breadcrumb is one clickable segment of the current path
+type breadcrumb struct {
+	label string
+	path  string
+	start int // first column of the label
+	end   int // column after the label
+}
+
+// breadcrumbs splits the current path into segments with their screen columns
+func (m model) breadcrumbs() []breadcrumb {
+	crumbs := []breadcrumb{{label: "/", path: "/"}}
+	if m.currentPath != "/" {
+		current := "/"
+		for _, part := range strings.Split(strings.Trim(m.currentPath, "/"), "/") {
+			current = filepath.Join(current, part)
+			crumbs = append(crumbs, breadcrumb{label: part, path: current})
+		}
+	}
+
+	col := 1 // leading space
+	for i := range crumbs {
+		if i > 0 {
+			col += lipgloss.Width(breadcrumbSeparator)
+		}
+		crumbs[i].start = col
+		col += lipgloss.Width(crumbs[i].label)
+		crumbs[i].end = col
+	}
+	return crumbs
+}
+
+// renderBreadcrumbs draws the current path, highlighting the last segment
+func (m model) renderBreadcrumbs() string {
+	crumbs := m.breadcrumbs()
+
+	var b strings.Builder
+	b.WriteString(" ")
+	for i, c := range crumbs {
+		if i > 0 {
+			b.WriteString(m.styles.status.UnsetPadding().Render(breadcrumbSeparator))
+		}
+		if i == len(crumbs)-1 {
+			b.WriteString(m.styles.selected.Render(c.label))
+		} else {
+			b.WriteString(c.label)
+		}
+	}
+	return b.String()
+}
+
+// handleMouse jumps to a clicked breadcrumb and scrolls the file list with the wheel
+func (m model) handleMouse(msg tea.MouseMsg) (tea.Model, tea.Cmd) {
+	if m.showTransfers || m.showResults || m.showBookmarks || m.showHistory || m.prompt.kind != promptNone {
+		return m, nil
+	}
+
+	switch {
+	case msg.Button == tea.MouseButtonWheelUp:
+		m.list.CursorUp()
+	case msg.Button == tea.MouseButtonWheelDown:
+		m.list.CursorDown()
+	case msg.Button == tea.MouseButtonLeft && msg.Action == tea.MouseActionPress && msg.Y == 0:
+		for _, c := range m.breadcrumbs() {
+			if msg.X >= c.start && msg.X < c.end {
+				return m.changeDirectory(c.path)
+			}
+		}
+	}
+	return m, nil
+}
+
+// changeDirectory shows another remote directory
+func (m model) changeDirectory(path string) (model, tea.Cmd) {
+	if path == m.currentPath {
+		return m, nil
+	}
+	m.currentPath = path
+	m.list.ResetSelected()
+	return m, m.loadDirectory()
+}
+
+// gotoMsg reports that a typed path was confirmed to be a remote directory
+type gotoMsg string
+
+// gotoPath checks that a typed path is a remote directory before opening it.
+// Relative paths are resolved against the current directory.
+func (m model) gotoPath(value string) (model, tea.Cmd, bool) {
+	target := value
+	if !strings.HasPrefix(target, "/") {
+		target = filepath.Join(m.currentPath, target)
+	}
+	target = filepath.Clean(target)
+
+	client := m.client
+	return m, func() tea.Msg {
+		info, err := client.Stat(context.Background(), target)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir {
+			return fmt.Errorf("%s is not a directory", target)
+		}
+		return gotoMsg(target)
+	}, true
+}
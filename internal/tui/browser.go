@@ -1,87 +1,68 @@
 package tui
 
 import (
-	"bytes"
-	"encoding/gob"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
-	"time"
 
+	"github.com/Zayan-Mohamed/orb/internal/filesystem"
+	"github.com/Zayan-Mohamed/orb/internal/journal"
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
 	"github.com/Zayan-Mohamed/orb/internal/tunnel"
 	"github.com/Zayan-Mohamed/orb/pkg/protocol"
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
-	"github.com/charmbracelet/lipgloss"
 )
 
-// Download progress messages
-type downloadProgressMsg struct {
-	downloaded int64
-	speed      int64
+// safeFilename reports whether a download may be saved under a name, which
+// must name a file in the download directory rather than point elsewhere.
+// Names in any script are fine.
+func safeFilename(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		!strings.ContainsAny(name, "/\x00") && !strings.ContainsRune(name, filepath.Separator)
 }
 
-type downloadCompleteMsg struct {
-	filename string
-	size     int64
-}
-
-type downloadErrorMsg struct {
-	error string
-}
-
-type downloadCancelMsg struct{}
-
-type downloadResetMsg struct{}
-
-var (
-	titleStyle = lipgloss.NewStyle().
-			Bold(true).
-			Foreground(lipgloss.Color("205")).
-			Padding(0, 1)
-
-	statusStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("241")).
-			Padding(0, 1)
-
-	helpStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("241")).
-			Padding(1, 0)
-
-	progressStyle = lipgloss.NewStyle().
-			Bold(true).
-			Foreground(lipgloss.Color("46")).
-			Padding(0, 1)
-
-	progressBarStyle = lipgloss.NewStyle().
-				Foreground(lipgloss.Color("240")).
-				Background(lipgloss.Color("240")).
-				Width(50)
-
-	progressFilledStyle = lipgloss.NewStyle().
-				Foreground(lipgloss.Color("46")).
-				Background(lipgloss.Color("46"))
-)
-
-type downloadState struct {
-	filename      string
-	totalSize     int64
-	downloaded    int64
-	chunkSize     int64
-	isDownloading bool
-	cancelled     bool
-	progress      float64
-	speed         int64 // bytes per second
-	startTime     int64 // Unix timestamp
+// transfersUpdatedMsg is sent whenever the transfer manager reports a change
+type transfersUpdatedMsg struct{}
+
+// footerHeight is the number of lines reserved above and below the file list
+const footerHeight = 9
+
+// Options configures the file browser
+type Options struct {
+	// Concurrency is the number of transfers run at the same time
+	Concurrency int
+	// Parallel is the number of chunks of each download fetched at the
+	// same time, one when unset
+	Parallel int
+	// Verify checks every transfer against the sharer's checksum
+	Verify bool
+	// DownloadDir is where downloaded files are written
+	DownloadDir string
+	// SessionID identifies the share, used to persist per-session state
+	SessionID string
+	// Keys are the browser keybindings, DefaultKeyMap() when unset
+	Keys *KeyMap
+	// Theme sets the browser colors, DefaultTheme when unset
+	Theme *Theme
+	// Finished, if set, gets the transfers that completed or failed once
+	// the browser is closed
+	Finished func([]transfer.Transfer)
 }
 
 type fileItem struct {
-	name  string
-	size  int64
-	isDir bool
+	name     string
+	size     int64
+	modTime  int64
+	mode     uint32
+	owner    string
+	isDir    bool
+	detailed bool // show permissions, owner and modification time
 }
 
 func (i fileItem) Title() string {
@@ -92,10 +73,14 @@ func (i fileItem) Title() string {
 }
 
 func (i fileItem) Description() string {
-	if i.isDir {
-		return "<DIR>"
+	size := "<DIR>"
+	if !i.isDir {
+		size = formatSize(i.size)
 	}
-	return formatSize(i.size)
+	if i.detailed && i.name != ".." {
+		return i.details(size)
+	}
+	return size
 }
 
 func (i fileItem) FilterValue() string {
@@ -103,62 +88,191 @@ func (i fileItem) FilterValue() string {
 }
 
 type model struct {
-	tunnel      *tunnel.Tunnel
+	client      *remote.Client
+	transfers   *transfer.Manager
 	currentPath string
 	list        list.Model
+	sort        sortOrder
+	detailed    bool
+	typeFilter  typeFilter
+	allItems    []list.Item // current listing before the type filter
+	keys        KeyMap
+	styles      styles
+	pendingKey  string // first key of a two-key sequence
 	error       string
-	download    downloadState // NEW: Add download state
+	notice      string
+	readOnly    bool
+	downloadDir string
+	width       int
+	height      int
+	status      statusBar
+
+	// Transfers view
+	showTransfers  bool
+	transferList   []transfer.Transfer
+	transferCursor int
+	lastDownload   *transfer.Transfer // most recently completed download
+
+	// Single-line prompt (upload, search, rename, ...)
+	prompt promptState
+	input  textinput.Model
+
+	// Remote search results
+	showResults bool
+	results     list.Model
+
+	// Split local/remote view
+	dual       bool
+	focusLocal bool
+	local      localPane
+
+	// Bookmarked remote directories
+	sessionID     string
+	bookmarkStore *bookmarkStore
+	bookmarkPaths []string
+	showBookmarks bool
+	bookmarks     list.Model
+
+	// Transfers finished during this session
+	showHistory bool
+	history     list.Model
+
+	// Chat with the sharer
+	showChat bool
+	chat     []chatLine
+	chatIn   <-chan protocol.ChatMessage
 }
 
-func newModel(tun *tunnel.Tunnel) model {
-	items := []list.Item{}
+func newModel(client *remote.Client, opts Options) model {
+	keys := DefaultKeyMap()
+	if opts.Keys != nil {
+		keys = *opts.Keys
+	}
+
+	theme := DefaultTheme
+	if opts.Theme != nil {
+		theme = *opts.Theme
+	}
+	st := newStyles(theme)
 
-	l := list.New(items, list.NewDefaultDelegate(), 0, 0)
-	l.Title = "Orb File Browser"
+	l := st.newList("Orb File Browser")
 	l.SetShowStatusBar(true)
 	l.SetFilteringEnabled(true)
-	l.Styles.Title = titleStyle
+	// The search key searches the whole share, the list's own filter only
+	// matches the visible directory
+	keys.applyToList(&l)
+
+	results := st.newList("Search")
+	results.SetShowStatusBar(true)
+	results.SetFilteringEnabled(true)
+	keys.applyToList(&results)
+
+	history := st.newList("Transfer History")
+	history.SetShowStatusBar(false)
+	history.SetFilteringEnabled(true)
+	keys.applyToList(&history)
+
+	downloadDir := opts.DownloadDir
+	if downloadDir == "" {
+		downloadDir = "."
+	}
+
+	transfers := transfer.NewManager(client, opts.Concurrency)
+	transfers.SetParallel(opts.Parallel)
+	transfers.SetVerify(opts.Verify)
+	// Without a config directory transfers are not recorded, but still work
+	if j, err := journal.Open(); err == nil {
+		transfers.SetObserver(j.Observer(opts.SessionID))
+	}
 
-	return model{
-		tunnel:      tun,
+	m := model{
+		client:      client,
+		transfers:   transfers,
 		currentPath: "/",
+		downloadDir: downloadDir,
 		list:        l,
-		download:    downloadState{}, // Initialize download state
+		keys:        keys,
+		styles:      st,
+		input:       textinput.New(),
+		results:     results,
+		local:       newLocalPane("", keys, st),
+		sessionID:   opts.SessionID,
+		bookmarks:   newBookmarkList(keys, st),
+		history:     history,
+		chatIn:      client.Messages(),
 	}
+
+	store, err := newBookmarkStore()
+	if err == nil {
+		m.bookmarkStore = store
+		m.bookmarkPaths, err = store.Load(opts.SessionID)
+	}
+	if err != nil {
+		m.error = err.Error()
+	}
+
+	return m
 }
 
 func (m model) Init() tea.Cmd {
-	return m.loadDirectory()
+	return tea.Batch(m.loadDirectory(), m.loadShareInfo(), m.waitForTransfers(), m.pingTunnel(), m.waitForChat())
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	// Handle download-related messages via helper
-	if m2, cmd, handled := m.handleDownloadMsg(msg); handled {
-		return m2, cmd
-	}
-
-	// Handle key messages with download cancellation
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
-		m.list.SetWidth(msg.Width)
-		m.list.SetHeight(msg.Height - 4)
+		m.width = msg.Width
+		m.height = msg.Height
+		return m.resize(), nil
+
+	case localItemsMsg:
+		return m.handleLocalItems(msg)
+
+	case transfersUpdatedMsg:
+		return m.handleTransfersUpdated()
+
+	case searchResultsMsg:
+		return m.handleSearchResults(msg)
+
+	case shareInfoMsg:
+		m.readOnly = msg.ReadOnly
+		m.status.haveInfo = true
 		return m, nil
 
+	case pingMsg:
+		return m.handlePing(msg)
+
+	case gotoMsg:
+		m.error = ""
+		return m.changeDirectory(string(msg))
+
+	case tea.MouseMsg:
+		return m.handleMouse(msg)
+
+	case fileOpDoneMsg:
+		return m.handleFileOpDone(msg)
+
+	case extractedMsg:
+		return m.handleExtracted(msg)
+
+	case chatMsg:
+		return m.handleChatMsg(msg)
+
+	case chatSentMsg:
+		return m.handleChatSent(msg)
+
 	case tea.KeyMsg:
 		if m2, cmd, handled := m.handleKeyMsg(msg); handled {
 			return m2, cmd
 		}
+
 	case []list.Item:
-		if !m.download.isDownloading {
-			m.list.SetItems(msg)
-			m.error = ""
-		}
-		return m, nil
+		m.sort.apply(msg)
+		m.error = ""
+		return m, m.setRemoteItems(msg)
 
 	case error:
-		if !m.download.isDownloading {
-			m.error = msg.Error()
-		}
+		m.error = msg.Error()
 		return m, nil
 	}
 
@@ -167,81 +281,251 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
-// handleDownloadMsg handles download-related messages and returns handled=true if message
-// was consumed. It keeps `model.Update` smaller and easier to test.
-func (m model) handleDownloadMsg(msg tea.Msg) (model, tea.Cmd, bool) {
-	switch msg := msg.(type) {
-	case downloadProgressMsg:
-		if m.download.isDownloading && !m.download.cancelled {
-			m.download.downloaded = msg.downloaded
-			m.download.speed = msg.speed
-			m.download.progress = float64(msg.downloaded) / float64(m.download.totalSize) * 100
-			return m, nil, true
-		}
+// waitForTransfers blocks until the transfer manager reports a change
+func (m model) waitForTransfers() tea.Cmd {
+	updates := m.transfers.Updates()
+	return func() tea.Msg {
+		<-updates
+		return transfersUpdatedMsg{}
+	}
+}
 
-	case downloadCompleteMsg:
-		m.download.isDownloading = false
-		m.download.progress = 100
-		// Reset after 2 seconds
-		return m, tea.Tick(2*time.Second, func(t time.Time) tea.Msg {
-			return downloadResetMsg{}
-		}), true
-
-	case downloadErrorMsg:
-		m.download.isDownloading = false
-		m.error = msg.error
-		return m, nil, true
+// handleTransfersUpdated refreshes the transfer snapshot and reloads the
+// listing when an upload into the current directory finishes.
+func (m model) handleTransfersUpdated() (tea.Model, tea.Cmd) {
+	previous := make(map[int]transfer.State, len(m.transferList))
+	for _, t := range m.transferList {
+		previous[t.ID] = t.State
+	}
 
-	case downloadCancelMsg:
-		// Reset download state
-		m.download = downloadState{}
-		return m, m.loadDirectory(), true
+	m.transferList = m.transfers.Snapshot()
+	if m.transferCursor >= len(m.transferList) {
+		m.transferCursor = len(m.transferList) - 1
+	}
+	if m.transferCursor < 0 {
+		m.transferCursor = 0
+	}
 
-	case downloadResetMsg:
-		// Reset download state
-		m.download = downloadState{}
-		return m, m.loadDirectory(), true
+	cmds := []tea.Cmd{m.waitForTransfers()}
+	if m.showHistory {
+		cmds = append(cmds, m.refreshHistory())
+	}
+	reloadRemote, reloadLocal := false, false
+	for _, t := range m.transferList {
+		if t.Direction == transfer.Upload && t.State == transfer.StateDone &&
+			previous[t.ID] != transfer.StateDone && filepath.Dir(t.RemotePath) == m.currentPath {
+			reloadRemote = true
+		}
+		if m.localDownloadFinished(t, previous[t.ID]) {
+			reloadLocal = true
+		}
+		if t.Direction == transfer.Download && t.State == transfer.StateDone &&
+			previous[t.ID] != transfer.StateDone {
+			finished := t
+			m.lastDownload = &finished
+			m.notice = fmt.Sprintf("Downloaded %s • %s to open", filepath.Base(t.LocalPath), m.keys.Launch.Help().Key)
+			m = m.offerExtract(t)
+		}
+	}
+	if reloadRemote {
+		cmds = append(cmds, m.loadDirectory())
+	}
+	if reloadLocal {
+		cmds = append(cmds, m.loadLocalDirectory())
 	}
 
-	return m, nil, false
+	return m, tea.Batch(cmds...)
 }
 
 // handleKeyMsg extracts logic for keyboard handling from `model.Update`.
 // It returns handled=true when the key is consumed and should not be forwarded
 // to the list component.
 func (m model) handleKeyMsg(msg tea.KeyMsg) (model, tea.Cmd, bool) {
-	// ESC key cancels downloads
-	if key.Matches(msg, key.NewBinding(key.WithKeys("escape"))) {
-		if m.download.isDownloading {
-			m.download.cancelled = true
-			m.download.isDownloading = false
-			return m, nil, true
+	if key.Matches(msg, key.NewBinding(key.WithKeys("ctrl+c"))) {
+		return m.quit()
+	}
+
+	if m.prompt.kind != promptNone {
+		return m.handlePromptKey(msg)
+	}
+
+	if m.showTransfers {
+		return m.handleTransfersKey(msg)
+	}
+
+	if m.showResults {
+		return m.handleResultsKey(msg)
+	}
+
+	if m.showBookmarks {
+		return m.handleBookmarksKey(msg)
+	}
+
+	if m.showHistory {
+		return m.handleHistoryKey(msg)
+	}
+
+	// Let the list handle keys while the user is typing a filter
+	if m.list.FilterState() == list.Filtering {
+		return m, nil, false
+	}
+
+	if m.dual {
+		if m2, cmd, handled := m.handleDualKey(msg); handled {
+			return m2, cmd, true
 		}
 	}
 
+	pressed := msg.String()
+	if m.pendingKey != "" {
+		pressed = m.pendingKey + " " + pressed
+		m.pendingKey = ""
+	} else if m.keys.startsSequence(pressed) {
+		m.pendingKey = pressed
+		return m, nil, true
+	}
+
 	switch {
-	case key.Matches(msg, key.NewBinding(key.WithKeys("ctrl+c", "q"))):
-		return m, tea.Quit, true
+	case matches(pressed, m.keys.Quit):
+		return m.quit()
 
-	case key.Matches(msg, key.NewBinding(key.WithKeys("enter"))):
+	case matches(pressed, m.keys.Open):
 		return m.handleEnterKey()
 
-	case key.Matches(msg, key.NewBinding(key.WithKeys("backspace"))):
+	case matches(pressed, m.keys.Parent):
 		return m.handleBackspaceKey()
 
-	case key.Matches(msg, key.NewBinding(key.WithKeys("d"))):
+	case matches(pressed, m.keys.Top):
+		m.list.Select(0)
+		return m, nil, true
+
+	case matches(pressed, m.keys.Bottom):
+		m.list.Select(len(m.list.VisibleItems()) - 1)
+		return m, nil, true
+
+	case matches(pressed, m.keys.Download):
 		return m.handleDownloadKey()
+
+	case matches(pressed, m.keys.Upload):
+		return m.openPrompt(promptUpload, "Upload: ", "path/to/local/file", "")
+
+	case matches(pressed, m.keys.Transfers):
+		m.showTransfers = true
+		return m, nil, true
+
+	case matches(pressed, m.keys.Launch):
+		return m.openLastDownload()
+
+	case matches(pressed, m.keys.Copy):
+		return m.copySelectedPath()
+
+	case matches(pressed, m.keys.Search):
+		return m.openSearch()
+
+	case matches(pressed, m.keys.Sort):
+		m.sort.field = m.sort.field.next()
+		return m.resort()
+
+	case matches(pressed, m.keys.Reverse):
+		m.sort.descending = !m.sort.descending
+		return m.resort()
+
+	case matches(pressed, m.keys.Details):
+		return m.toggleDetails()
+
+	case matches(pressed, m.keys.Types):
+		return m.openTypeFilter()
+
+	case matches(pressed, m.keys.GoTo):
+		return m.openPrompt(promptGoto, "Go to: ", "/remote/path", m.currentPath)
+
+	case matches(pressed, m.keys.Delete):
+		return m.handleDeleteKey()
+
+	case matches(pressed, m.keys.Rename):
+		return m.handleRenameKey()
+
+	case matches(pressed, m.keys.Mkdir):
+		return m.handleMkdirKey()
+
+	case matches(pressed, m.keys.Destination):
+		return m.openPrompt(promptDestination, "Download to: ", "local directory", m.downloadDir)
+
+	case matches(pressed, m.keys.Split):
+		return m.toggleDualPane()
+
+	case matches(pressed, m.keys.Bookmark):
+		return m.addBookmark()
+
+	case matches(pressed, m.keys.Bookmarks):
+		return m.openBookmarks()
+
+	case matches(pressed, m.keys.History):
+		return m.openHistory()
+
+	case matches(pressed, m.keys.Chat):
+		return m.openChat()
+	}
+
+	// An unfinished sequence that matched nothing is dropped
+	if strings.Contains(pressed, " ") {
+		return m, nil, true
 	}
 
 	return m, nil, false
 }
 
-// handleEnterKey handles Enter key behavior (navigation or download).
-func (m model) handleEnterKey() (model, tea.Cmd, bool) {
-	if m.download.isDownloading {
-		return m, nil, true
+// resize fits the lists to the terminal, splitting the width in dual mode
+func (m model) resize() model {
+	height := m.height - footerHeight
+	if height < 1 {
+		height = 1
 	}
 
+	m.results.SetSize(m.width, height)
+	m.history.SetSize(m.width, height)
+	m.bookmarks.SetSize(m.width, height)
+
+	if m.dual {
+		// Leave room for the pane borders
+		paneWidth := m.width/2 - 2
+		m.list.SetSize(paneWidth, height-2)
+		m.local.list.SetSize(paneWidth, height-2)
+	} else {
+		m.list.SetSize(m.width, height)
+	}
+
+	return m
+}
+
+// shareInfoMsg carries the sharer's description of the share
+type shareInfoMsg protocol.InfoResponse
+
+func (m model) loadShareInfo() tea.Cmd {
+	client := m.client
+	return func() tea.Msg {
+		info, err := client.Info(context.Background())
+		if err != nil {
+			return err
+		}
+		return shareInfoMsg(*info)
+	}
+}
+
+// resort reorders the current listing after the sort order changed
+func (m model) resort() (model, tea.Cmd, bool) {
+	m.sort.apply(m.allItems)
+	return m, m.setRemoteItems(m.allItems), true
+}
+
+// quit cancels unfinished transfers so partial downloads are cleaned up
+func (m model) quit() (model, tea.Cmd, bool) {
+	m.transfers.CancelAll()
+	return m, tea.Quit, true
+}
+
+// handleEnterKey handles Enter key behavior (navigation or download).
+func (m model) handleEnterKey() (model, tea.Cmd, bool) {
 	selected := m.list.SelectedItem()
 	if selected != nil {
 		item := selected.(fileItem)
@@ -253,16 +537,13 @@ func (m model) handleEnterKey() (model, tea.Cmd, bool) {
 			}
 			return m, m.loadDirectory(), true
 		}
-		return m, m.initiateDownload(item.name, item.size), true
+		return m.queueDownload(item)
 	}
 	return m, nil, false
 }
 
 // handleBackspaceKey handles navigation up one directory.
 func (m model) handleBackspaceKey() (model, tea.Cmd, bool) {
-	if m.download.isDownloading {
-		return m, nil, true
-	}
 	if m.currentPath != "/" {
 		m.currentPath = filepath.Dir(m.currentPath)
 		return m, m.loadDirectory(), true
@@ -272,141 +553,178 @@ func (m model) handleBackspaceKey() (model, tea.Cmd, bool) {
 
 // handleDownloadKey handles explicit download command ("d").
 func (m model) handleDownloadKey() (model, tea.Cmd, bool) {
-	if m.download.isDownloading {
-		return m, nil, true
-	}
 	selected := m.list.SelectedItem()
 	if selected != nil {
 		item := selected.(fileItem)
 		if !item.isDir {
-			return m, m.initiateDownload(item.name, item.size), true
+			return m.queueDownload(item)
 		}
 	}
 	return m, nil, false
 }
 
-func (m model) View() string {
-	var b strings.Builder
+// queueDownload adds the selected file to the transfer queue
+func (m model) queueDownload(item fileItem) (model, tea.Cmd, bool) {
+	// Validate filename to prevent path traversal
+	name := filesystem.LocalName(item.name)
+	if !safeFilename(name) {
+		m.error = "invalid filename: contains unsafe characters"
+		return m, nil, true
+	}
 
-	// Show progress overlay during download
-	if m.download.isDownloading {
-		b.WriteString(m.renderDownloadProgress())
-		return b.String()
+	remotePath := filepath.Join(m.currentPath, item.name)
+	localPath := filepath.Join(m.downloadDir, name)
+	return m.startDownload(remotePath, localPath, item.size)
+}
+
+// queueUpload adds a local file to the transfer queue, targeting the current directory
+func (m model) queueUpload(localPath string) (model, tea.Cmd, bool) {
+	if localPath == "" {
+		return m, nil, true
 	}
 
-	// Title
-	b.WriteString(m.list.View())
-	b.WriteString("\n")
+	info, err := os.Stat(localPath)
+	if err != nil {
+		m.error = err.Error()
+		return m, nil, true
+	}
+	if info.IsDir() {
+		m.error = "uploading directories is not supported"
+		return m, nil, true
+	}
 
-	// Current path
-	b.WriteString(statusStyle.Render("Path: " + m.currentPath))
-	b.WriteString("\n")
+	remotePath := filepath.Join(m.currentPath, filesystem.WireName(filepath.Base(localPath)))
+	m.transfers.Enqueue(transfer.Upload, remotePath, localPath, info.Size())
+	m.error = ""
+	return m, nil, true
+}
 
-	// Error message
-	if m.error != "" {
-		b.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("196")).Render("Error: " + m.error))
-		b.WriteString("\n")
+// setDownloadDir changes where downloads are written for the rest of the session
+func (m model) setDownloadDir(dir string) (model, tea.Cmd, bool) {
+	if dir == "~" || strings.HasPrefix(dir, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			m.error = err.Error()
+			return m, nil, true
+		}
+		dir = filepath.Join(home, strings.TrimPrefix(dir, "~"))
 	}
 
-	// Help
-	helpText := "Enter: open/download • d: download • backspace: parent dir"
-	if m.download.isDownloading {
-		helpText = "ESC: cancel download"
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		m.error = err.Error()
+		return m, nil, true
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		m.error = err.Error()
+		return m, nil, true
+	}
+	if !info.IsDir() {
+		m.error = dir + " is not a directory"
+		return m, nil, true
 	}
-	helpText += " • q: quit"
-	b.WriteString(helpStyle.Render(helpText))
 
-	return b.String()
+	m.downloadDir = filepath.Clean(dir)
+	m.error = ""
+	m.notice = "Downloads will be saved to " + m.downloadDir
+	return m, nil, true
 }
 
-func (m model) renderDownloadProgress() string {
+func (m model) View() string {
+	if m.showTransfers {
+		return m.renderTransfers()
+	}
+
 	var b strings.Builder
 
+	// Current path
+	b.WriteString(m.renderBreadcrumbs())
+	b.WriteString("\n")
+
 	// Title
-	b.WriteString(titleStyle.Render("Downloading File"))
-	b.WriteString("\n\n")
+	switch {
+	case m.showChat:
+		b.WriteString(m.renderChat())
+	case m.showResults:
+		b.WriteString(m.results.View())
+	case m.showBookmarks:
+		b.WriteString(m.bookmarks.View())
+	case m.showHistory:
+		b.WriteString(m.history.View())
+	case m.dual:
+		b.WriteString(m.renderPanes())
+	default:
+		b.WriteString(m.list.View())
+	}
+	b.WriteString("\n")
 
-	// Filename
-	b.WriteString(progressStyle.Render("File: " + m.download.filename))
+	// Connection status
+	b.WriteString(m.renderStatusBar())
 	b.WriteString("\n")
 
-	// Progress bar
-	barWidth := 50
-	filled := int(float64(barWidth) * m.download.progress / 100)
-	if filled > barWidth {
-		filled = barWidth
+	// Current path
+	pathLine := m.sort.String()
+	if m.typeFilter.active() {
+		pathLine += "  •  " + m.typeFilter.String()
 	}
-	empty := barWidth - filled
-
-	filledStr := progressFilledStyle.Render(strings.Repeat("█", filled))
-	emptyStr := strings.Repeat("░", empty)
-	b.WriteString(progressBarStyle.Render(filledStr + emptyStr))
-	b.WriteString("\n\n")
+	b.WriteString(m.styles.status.Render(pathLine))
+	b.WriteString("\n")
+	b.WriteString(m.styles.status.Render("Downloads → " + m.downloadDir))
+	b.WriteString("\n")
 
-	// Progress info
-	progressText := fmt.Sprintf("%.1f%%", m.download.progress)
-	sizeText := fmt.Sprintf("%s / %s",
-		formatSize(m.download.downloaded),
-		formatSize(m.download.totalSize))
+	// Transfer summary
+	if summary := m.transferSummary(); summary != "" {
+		b.WriteString(m.styles.progress.Render(summary))
+		b.WriteString("\n")
+	}
 
-	b.WriteString(progressStyle.Render(progressText + "  " + sizeText))
-	b.WriteString("\n")
+	// Prompt
+	if m.prompt.kind != promptNone {
+		b.WriteString(m.promptView())
+		b.WriteString("\n")
+	}
 
-	// Speed
-	if m.download.speed > 0 {
-		speedText := fmt.Sprintf("Speed: %s/s", formatSize(m.download.speed))
-		b.WriteString(statusStyle.Render(speedText))
+	// Error message
+	if m.error != "" {
+		b.WriteString(m.styles.error.Render("Error: " + m.error))
+		b.WriteString("\n")
+	} else if m.notice != "" {
+		b.WriteString(m.styles.progress.Render(m.notice))
 		b.WriteString("\n")
 	}
 
-	// Cancel hint
-	b.WriteString(helpStyle.Render("Press ESC to cancel"))
+	// Help
+	k := m.keys
+	helpText := helpLine(k.Open, k.Download, k.Upload, k.Search, k.Filter, k.Types, k.Sort, k.Reverse, k.Details,
+		k.GoTo, k.Destination, k.Split, k.Bookmark, k.Bookmarks, k.Transfers, k.History, k.Chat, k.Launch, k.Copy, k.Parent, k.Quit)
+	if !m.readOnly {
+		helpText += " • " + helpLine(k.Delete, k.Rename, k.Mkdir)
+	}
+	switch {
+	case m.prompt.kind != promptNone:
+		helpText = m.promptHelp()
+	case m.showResults:
+		helpText = "Enter: jump to/download • d: download • y: copy path • /: new search • ESC: back to browser • q: quit"
+	case m.showBookmarks:
+		helpText = "Enter: jump to bookmark • x: remove • ESC: back to browser • q: quit"
+	case m.showHistory:
+		helpText = "o: open download • f: filter • ESC: back to browser • q: quit"
+	case m.dual:
+		helpText = "Tab: switch pane • c/F5: copy to other pane • Enter: open • backspace: parent dir • w: single view • t: transfers • q: quit"
+	}
+	b.WriteString(m.styles.help.Render(helpText))
 
 	return b.String()
 }
 
 func (m model) loadDirectory() tea.Cmd {
+	detailed := m.detailed
 	return func() tea.Msg {
-		// Send list request
-		req := protocol.ListRequest{
-			Path: m.currentPath,
-		}
-
-		var buf bytes.Buffer
-		if err := gob.NewEncoder(&buf).Encode(req); err != nil {
-			return err
-		}
-
-		frame := &protocol.Frame{
-			Type:    protocol.FrameTypeList,
-			Payload: buf.Bytes(),
-		}
-
-		if err := m.tunnel.SendFrame(frame); err != nil {
-			return err
-		}
-
-		// Receive response
-		respFrame, err := m.tunnel.ReceiveFrame()
+		files, err := m.client.List(context.Background(), m.currentPath)
 		if err != nil {
 			return err
 		}
 
-		if respFrame.Type == protocol.FrameTypeError {
-			var errResp protocol.ErrorResponse
-			_ = gob.NewDecoder(bytes.NewReader(respFrame.Payload)).Decode(&errResp)
-			return fmt.Errorf("%s", errResp.Message)
-		}
-
-		if respFrame.Type != protocol.FrameTypeResponse {
-			return fmt.Errorf("unexpected frame type: %d", respFrame.Type)
-		}
-
-		var resp protocol.ListResponse
-		if err := gob.NewDecoder(bytes.NewReader(respFrame.Payload)).Decode(&resp); err != nil {
-			return err
-		}
-
 		// Convert to list items
 		items := []list.Item{}
 
@@ -418,11 +736,15 @@ func (m model) loadDirectory() tea.Cmd {
 			})
 		}
 
-		for _, file := range resp.Files {
+		for _, file := range files {
 			items = append(items, fileItem{
-				name:  file.Name,
-				size:  file.Size,
-				isDir: file.IsDir,
+				name:     file.Name,
+				size:     file.Size,
+				modTime:  file.ModTime,
+				mode:     file.Mode,
+				owner:    file.Owner,
+				isDir:    file.IsDir,
+				detailed: detailed,
 			})
 		}
 
@@ -430,129 +752,25 @@ func (m model) loadDirectory() tea.Cmd {
 	}
 }
 
-func (m model) initiateDownload(filename string, size int64) tea.Cmd {
-	return func() tea.Msg {
-		// Initialize download state
-		m.download.filename = filename
-		m.download.totalSize = size
-		m.download.chunkSize = 64 * 1024 // 64KB chunks
-		m.download.downloaded = 0
-		m.download.isDownloading = true
-		m.download.cancelled = false
-		m.download.progress = 0
-		m.download.startTime = time.Now().Unix()
-
-		remotePath := filepath.Join(m.currentPath, filename)
-
-		// Validate filename to prevent path traversal - only allow safe characters
-		match, _ := regexp.MatchString(`^[a-zA-Z0-9._-]+$`, filename)
-		if !match {
-			return downloadErrorMsg{error: "invalid filename: contains unsafe characters"}
-		}
-
-		localPath := filename
-
-		// Create local file
-		// #nosec G304 - filename validated with regex whitelist above
-		file, err := os.OpenFile(localPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
-		if err != nil {
-			return downloadErrorMsg{error: err.Error()}
-		}
-		defer func() {
-			if err := file.Close(); err != nil {
-				fmt.Fprintf(os.Stderr, "warning: failed to close file %s: %v\n", localPath, err)
-			}
-		}()
-		var totalDownloaded int64
-		chunkSize := m.download.chunkSize
-		for offset := int64(0); offset < size; offset += chunkSize {
-			// Check for cancellation
-			if m.download.cancelled {
-				if err := os.Remove(localPath); err != nil && !os.IsNotExist(err) {
-					return downloadErrorMsg{error: err.Error()}
-				}
-				return downloadCancelMsg{}
-			}
-
-			// Calculate chunk size for this iteration
-			remaining := size - offset
-			currentChunkSize := chunkSize
-			if remaining < chunkSize {
-				currentChunkSize = remaining
-			}
-
-			// Send read request for this chunk
-			readReq := protocol.ReadRequest{
-				Path:   remotePath,
-				Offset: offset,
-				Length: currentChunkSize,
-			}
-
-			var buf bytes.Buffer
-			if err := gob.NewEncoder(&buf).Encode(readReq); err != nil {
-				return downloadErrorMsg{error: err.Error()}
-			}
-
-			frame := &protocol.Frame{
-				Type:    protocol.FrameTypeRead,
-				Payload: buf.Bytes(),
-			}
-
-			if err := m.tunnel.SendFrame(frame); err != nil {
-				return downloadErrorMsg{error: err.Error()}
-			}
-
-			// Receive chunk response
-			respFrame, err := m.tunnel.ReceiveFrame()
-			if err != nil {
-				return downloadErrorMsg{error: err.Error()}
-			}
-
-			if respFrame.Type == protocol.FrameTypeError {
-				var errResp protocol.ErrorResponse
-				_ = gob.NewDecoder(bytes.NewReader(respFrame.Payload)).Decode(&errResp)
-				return downloadErrorMsg{error: errResp.Message}
-			}
-
-			if respFrame.Type != protocol.FrameTypeResponse {
-				return downloadErrorMsg{error: fmt.Sprintf("unexpected frame type: %d", respFrame.Type)}
-			}
-
-			var readResp protocol.ReadResponse
-			if err := gob.NewDecoder(bytes.NewReader(respFrame.Payload)).Decode(&readResp); err != nil {
-				return downloadErrorMsg{error: err.Error()}
-			}
-
-			// Write chunk to file
-			if _, err := file.WriteAt(readResp.Data, offset); err != nil {
-				return downloadErrorMsg{error: err.Error()}
-			}
-
-			totalDownloaded += int64(len(readResp.Data))
-
-			// speed calculation removed; progress will be shown after completion
-			_ = time.Now().Unix() - m.download.startTime
-
-			// continue downloading; progress will be shown after completion
-		}
-
-		// Download complete
-		return downloadCompleteMsg{
-			filename: filename,
-			size:     totalDownloaded,
-		}
-	}
-}
-
 // StartFileBrowser starts the TUI file browser
-func StartFileBrowser(tun *tunnel.Tunnel) error {
-	m := newModel(tun)
-	p := tea.NewProgram(m, tea.WithAltScreen())
+func StartFileBrowser(tun *tunnel.Tunnel, opts Options) error {
+	client := remote.NewClient(tunnel.NewMux(tun))
+	m := newModel(client, opts)
+	p := tea.NewProgram(m, tea.WithAltScreen(), tea.WithMouseCellMotion())
+
+	_, err := p.Run()
+
+	// Quitting on SIGTERM skips the quit key handling, so cancel whatever
+	// is left here and let partial downloads be cleaned up before exiting
+	m.transfers.CancelAll()
+	m.transfers.Wait()
+	if opts.Finished != nil {
+		opts.Finished(m.transfers.History())
+	}
 
-	if _, err := p.Run(); err != nil {
+	if err != nil {
 		return fmt.Errorf("error running TUI: %w", err)
 	}
-
 	return nil
 }
 
@@ -0,0 +1,148 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxChatLines is the number of chat messages kept for the chat pane
+const maxChatLines = 200
+
+// chatLine is a message shown in the chat pane
+type chatLine struct {
+	fromSharer bool
+	text       string
+	time       time.Time
+}
+
+// chatMsg carries a message of the sharer
+type chatMsg protocol.ChatMessage
+
+// chatSentMsg reports whether a message reached the tunnel
+type chatSentMsg struct {
+	text string
+	err  error
+}
+
+// waitForChat blocks until the sharer sends a message
+func (m model) waitForChat() tea.Cmd {
+	messages := m.chatIn
+	return func() tea.Msg {
+		msg, ok := <-messages
+		if !ok {
+			return nil
+		}
+		return chatMsg(msg)
+	}
+}
+
+// handleChatMsg adds a message of the sharer to the chat and points at it
+// when the chat pane is closed
+func (m model) handleChatMsg(msg chatMsg) (tea.Model, tea.Cmd) {
+	text := sanitizeChat(msg.Text)
+	m = m.addChatLine(chatLine{fromSharer: true, text: text, time: time.Now()})
+	if !m.showChat {
+		m.notice = fmt.Sprintf("💬 Sharer: %s • %s to reply", text, m.keys.Chat.Help().Key)
+	}
+	return m, m.waitForChat()
+}
+
+// handleChatSent shows a sent message, or why it could not be sent
+func (m model) handleChatSent(msg chatSentMsg) (tea.Model, tea.Cmd) {
+	if msg.err != nil {
+		m.error = "message not sent: " + msg.err.Error()
+		return m, nil
+	}
+	m.error = ""
+	return m.addChatLine(chatLine{text: msg.text, time: time.Now()}), nil
+}
+
+func (m model) addChatLine(line chatLine) model {
+	m.chat = append(m.chat, line)
+	if len(m.chat) > maxChatLines {
+		m.chat = append([]chatLine(nil), m.chat[len(m.chat)-maxChatLines:]...)
+	}
+	return m
+}
+
+// openChat shows the chat pane with the message prompt
+func (m model) openChat() (model, tea.Cmd, bool) {
+	m.showChat = true
+	m.notice = ""
+	return m.openPrompt(promptChat, "Message: ", "type a message for the sharer", "")
+}
+
+// handleChatKey handles keys while the chat prompt is open. Enter sends the
+// message and keeps the prompt open for the next one.
+func (m model) handleChatKey(msg tea.KeyMsg) (model, tea.Cmd, bool) {
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("esc"))):
+		m.showChat = false
+		return m.closePrompt(), nil, true
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("enter"))):
+		text := strings.TrimSpace(m.input.Value())
+		m.input.SetValue("")
+		if text == "" {
+			return m, nil, true
+		}
+		return m, m.sendChat(text), true
+	}
+
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return m, cmd, true
+}
+
+// sendChat sends a message to the sharer
+func (m model) sendChat(text string) tea.Cmd {
+	client := m.client
+	return func() tea.Msg {
+		return chatSentMsg{text: text, err: client.SendMessage(text)}
+	}
+}
+
+// renderChat renders the newest messages that fit above the footer
+func (m model) renderChat() string {
+	height := m.height - footerHeight
+	if height < 1 {
+		height = 1
+	}
+
+	var b strings.Builder
+	b.WriteString(m.styles.title.Render("Chat with the sharer"))
+	b.WriteString("\n\n")
+
+	lines := m.chat
+	if len(lines) > height-2 && height > 2 {
+		lines = lines[len(lines)-(height-2):]
+	}
+	if len(lines) == 0 {
+		b.WriteString(m.styles.status.Render("No messages yet. The sharer answers with orb msg."))
+		b.WriteString("\n")
+	}
+	for _, line := range lines {
+		who := "You"
+		if line.fromSharer {
+			who = "Sharer"
+		}
+		b.WriteString(fmt.Sprintf(" %s  %s: %s\n", line.time.Format("15:04:05"), who, line.text))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// sanitizeChat keeps control characters of the sharer's message from
+// redrawing the terminal
+func sanitizeChat(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, strings.TrimSpace(text))
+}
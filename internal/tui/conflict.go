@@ -0,0 +1,127 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// download is a download waiting for the user to resolve a conflict with an
+// existing local file
+type download struct {
+	remotePath string
+	localPath  string
+	size       int64
+	existing   int64 // size of the local file already at localPath
+}
+
+// canResume reports whether the local file looks like an earlier, partial
+// copy of the remote one
+func (d download) canResume() bool {
+	return d.existing > 0 && d.existing < d.size
+}
+
+// startDownload queues a download, asking first when localPath already exists
+func (m model) startDownload(remotePath, localPath string, size int64) (model, tea.Cmd, bool) {
+	info, err := os.Stat(localPath)
+	switch {
+	case os.IsNotExist(err):
+		m.transfers.Enqueue(transfer.Download, remotePath, localPath, size)
+		m.error = ""
+		return m, nil, true
+	case err != nil:
+		m.error = err.Error()
+		return m, nil, true
+	case info.IsDir():
+		m.error = localPath + " is a directory"
+		return m, nil, true
+	}
+
+	m.prompt = promptState{
+		kind: promptConflict,
+		download: download{
+			remotePath: remotePath,
+			localPath:  localPath,
+			size:       size,
+			existing:   info.Size(),
+		},
+	}
+	m.input.Blur()
+	return m, nil, true
+}
+
+// handleConflictKey resolves a download conflict with the chosen option
+func (m model) handleConflictKey(msg tea.KeyMsg) (model, tea.Cmd, bool) {
+	d := m.prompt.download
+
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("o", "O"))):
+		m.transfers.Enqueue(transfer.Download, d.remotePath, d.localPath, d.size)
+		m.notice = "Overwriting " + d.localPath
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("r", "R"))):
+		localPath, err := uniquePath(d.localPath)
+		if err != nil {
+			m.error = err.Error()
+			break
+		}
+		m.transfers.Enqueue(transfer.Download, d.remotePath, localPath, d.size)
+		m.notice = "Saving as " + filepath.Base(localPath)
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("c", "C"))) && d.canResume():
+		m.transfers.EnqueueFrom(transfer.Download, d.remotePath, d.localPath, d.size, d.existing)
+		m.notice = fmt.Sprintf("Resuming %s at %s", filepath.Base(d.localPath), formatSize(d.existing))
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("s", "S", "esc"))):
+		m.notice = "Skipped " + filepath.Base(d.localPath)
+
+	default:
+		return m, nil, true
+	}
+
+	return m.closePrompt(), nil, true
+}
+
+// conflictView describes the conflict and the available choices
+func (m model) conflictView() string {
+	d := m.prompt.download
+	question := fmt.Sprintf("%s already exists (%s, remote %s).",
+		filepath.Base(d.localPath), formatSize(d.existing), formatSize(d.size))
+	return m.styles.error.Render(question)
+}
+
+// conflictHelp lists the options for the open conflict
+func (m model) conflictHelp() string {
+	options := []string{"o: overwrite", "r: rename (keep both)", "s/ESC: skip"}
+	if m.prompt.download.canResume() {
+		options = append(options, "c: resume partial download")
+	}
+	return strings.Join(options, " • ")
+}
+
+// uniquePath returns path with the first free " (n)" suffix before its extension,
+// e.g. "report (1).pdf"
+func uniquePath(path string) (string, error) {
+	dir, name := filepath.Split(path)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if base == "" {
+		// Dotfiles such as ".env" have no extension to keep
+		base, ext = name, ""
+	}
+
+	for n := 1; n < 10000; n++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, n, ext))
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no free name for %s", name)
+}
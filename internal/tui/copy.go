@@ -0,0 +1,29 @@
+package tui
+
+import (
+	"path/filepath"
+
+	"github.com/Zayan-Mohamed/orb/internal/clipboard"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// copyPath copies a remote path to the system clipboard
+func (m model) copyPath(path string) (model, tea.Cmd, bool) {
+	if err := clipboard.Write(path); err != nil {
+		m.error = "failed to copy to clipboard: " + err.Error()
+		return m, nil, true
+	}
+	m.error = ""
+	m.notice = "Copied " + path
+	return m, nil, true
+}
+
+// copySelectedPath copies the remote path of the selected entry, or of the
+// current directory when ".." is selected
+func (m model) copySelectedPath() (model, tea.Cmd, bool) {
+	path := m.currentPath
+	if item, ok := m.list.SelectedItem().(fileItem); ok && item.name != ".." {
+		path = filepath.Join(m.currentPath, item.name)
+	}
+	return m.copyPath(path)
+}
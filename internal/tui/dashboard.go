@@ -0,0 +1,289 @@
+package tui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/monitor"
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// dashboardSampleInterval is how often per-peer throughput is measured
+const dashboardSampleInterval = time.Second
+
+// DashboardOptions describe the share shown by the sharer dashboard
+type DashboardOptions struct {
+	SessionID string
+	Passcode  string
+	Path      string
+	ReadOnly  bool
+	// Theme sets the dashboard colors, DefaultTheme when unset
+	Theme *Theme
+	// Context closes the dashboard when cancelled
+	Context context.Context
+}
+
+// monitorUpdatedMsg is sent whenever the monitor reports a change
+type monitorUpdatedMsg struct{}
+
+// dashboardTickMsg triggers a throughput sample
+type dashboardTickMsg time.Time
+
+// dashboardModel is the sharer's live view of peers and served requests
+type dashboardModel struct {
+	monitor *monitor.Monitor
+	opts    DashboardOptions
+	styles  styles
+	width   int
+	height  int
+
+	peers      []monitor.Peer
+	ops        []monitor.Op
+	paused     bool
+	err        error
+	peerCursor int
+	confirm    bool // asking whether to revoke the selected peer
+
+	// Throughput per peer, sampled every dashboardSampleInterval
+	rates      map[int]float64
+	lastBytes  map[int]int64
+	lastSample time.Time
+}
+
+func newDashboardModel(mon *monitor.Monitor, opts DashboardOptions) dashboardModel {
+	theme := DefaultTheme
+	if opts.Theme != nil {
+		theme = *opts.Theme
+	}
+
+	return dashboardModel{
+		monitor:   mon,
+		opts:      opts,
+		styles:    newStyles(theme),
+		rates:     make(map[int]float64),
+		lastBytes: make(map[int]int64),
+	}
+}
+
+func (m dashboardModel) Init() tea.Cmd {
+	return tea.Batch(m.waitForMonitor(), m.tick())
+}
+
+// waitForMonitor blocks until the monitor reports a change
+func (m dashboardModel) waitForMonitor() tea.Cmd {
+	updates := m.monitor.Updates()
+	return func() tea.Msg {
+		<-updates
+		return monitorUpdatedMsg{}
+	}
+}
+
+func (m dashboardModel) tick() tea.Cmd {
+	return tea.Tick(dashboardSampleInterval, func(t time.Time) tea.Msg {
+		return dashboardTickMsg(t)
+	})
+}
+
+func (m dashboardModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		return m, nil
+
+	case monitorUpdatedMsg:
+		m.refresh()
+		return m, m.waitForMonitor()
+
+	case dashboardTickMsg:
+		m.sample(time.Time(msg))
+		return m, m.tick()
+
+	case tea.KeyMsg:
+		return m.handleKey(msg)
+	}
+
+	return m, nil
+}
+
+// refresh copies the current state out of the monitor
+func (m *dashboardModel) refresh() {
+	m.peers = m.monitor.Peers()
+	m.ops = m.monitor.Ops()
+	m.paused = m.monitor.Paused()
+	m.err = m.monitor.Err()
+
+	if m.peerCursor >= len(m.peers) {
+		m.peerCursor = len(m.peers) - 1
+	}
+	if m.peerCursor < 0 {
+		m.peerCursor = 0
+	}
+	if len(m.peers) == 0 {
+		m.confirm = false
+	}
+}
+
+// sample updates each peer's throughput from the bytes moved since the last tick
+func (m *dashboardModel) sample(now time.Time) {
+	elapsed := now.Sub(m.lastSample).Seconds()
+	rates := make(map[int]float64, len(m.peers))
+	lastBytes := make(map[int]int64, len(m.peers))
+
+	for _, p := range m.monitor.Peers() {
+		total := p.BytesSent + p.BytesReceived
+		if previous, ok := m.lastBytes[p.ID]; ok && elapsed > 0 {
+			rates[p.ID] = float64(total-previous) / elapsed
+		}
+		lastBytes[p.ID] = total
+	}
+
+	m.rates = rates
+	m.lastBytes = lastBytes
+	m.lastSample = now
+}
+
+func (m dashboardModel) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.confirm {
+		m.confirm = false
+		if key.Matches(msg, key.NewBinding(key.WithKeys("y", "Y"))) && len(m.peers) > 0 {
+			_ = m.monitor.Revoke(m.peers[m.peerCursor].ID)
+		}
+		return m, nil
+	}
+
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("q", "ctrl+c"))):
+		return m, tea.Quit
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("up", "k"))):
+		if m.peerCursor > 0 {
+			m.peerCursor--
+		}
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("down", "j"))):
+		if m.peerCursor < len(m.peers)-1 {
+			m.peerCursor++
+		}
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("p", " "))):
+		m.monitor.SetPaused(!m.paused)
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("x", "delete"))):
+		m.confirm = len(m.peers) > 0
+	}
+
+	return m, nil
+}
+
+func (m dashboardModel) View() string {
+	var b strings.Builder
+
+	b.WriteString(m.styles.title.Render("Orb Share Dashboard"))
+	b.WriteString("\n\n")
+
+	mode := "read-write"
+	if m.opts.ReadOnly {
+		mode = "read-only"
+	}
+	b.WriteString(m.styles.status.Render(fmt.Sprintf("Session %s  •  Passcode %s  •  %s  •  %s",
+		m.opts.SessionID, m.opts.Passcode, m.opts.Path, mode)))
+	b.WriteString("\n")
+
+	switch {
+	case m.err != nil:
+		b.WriteString(m.styles.error.Render("Error: " + m.err.Error()))
+	case m.paused:
+		b.WriteString(m.styles.error.Render("⏸ Paused: requests are refused until you resume"))
+	default:
+		b.WriteString(m.styles.progress.Render("● Sharing"))
+	}
+	b.WriteString("\n\n")
+
+	// Peers
+	b.WriteString(m.styles.title.Render(fmt.Sprintf("Peers (%d)", len(m.peers))))
+	b.WriteString("\n")
+	if len(m.peers) == 0 {
+		b.WriteString(m.styles.status.Render("Waiting for a receiver to connect..."))
+		b.WriteString("\n")
+	}
+	for i, p := range m.peers {
+		b.WriteString(m.renderPeer(p, i == m.peerCursor))
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	// Activity, newest first, as much as fits
+	b.WriteString(m.styles.title.Render("Activity"))
+	b.WriteString("\n")
+	rows := m.height - len(m.peers) - 12
+	if m.height == 0 || rows < 3 {
+		rows = 3
+	}
+	if len(m.ops) == 0 {
+		b.WriteString(m.styles.status.Render("No requests yet."))
+		b.WriteString("\n")
+	}
+	for i := len(m.ops) - 1; i >= 0 && len(m.ops)-i <= rows; i-- {
+		b.WriteString(m.renderOp(m.ops[i]))
+		b.WriteString("\n")
+	}
+
+	if m.confirm {
+		b.WriteString(m.styles.error.Render(fmt.Sprintf("Revoke access for %s? (y/N)", m.peers[m.peerCursor].Name)))
+		b.WriteString("\n")
+	}
+
+	pause := "p: pause"
+	if m.paused {
+		pause = "p: resume"
+	}
+	b.WriteString(m.styles.help.Render("↑/↓: select peer • " + pause + " • x: revoke access • q: stop sharing"))
+
+	return b.String()
+}
+
+func (m dashboardModel) renderPeer(p monitor.Peer, selected bool) string {
+	name := "  " + p.Name
+	if selected {
+		name = m.styles.selected.Render("> " + p.Name)
+	}
+
+	return fmt.Sprintf("%s  %s", name, m.styles.status.Render(fmt.Sprintf(
+		"since %s  •  %d requests  •  ↑ %s  ↓ %s  •  %s/s",
+		p.Connected.Format("15:04:05"),
+		p.Requests,
+		formatSize(p.BytesSent),
+		formatSize(p.BytesReceived),
+		formatSize(int64(m.rates[p.ID])))))
+}
+
+func (m dashboardModel) renderOp(op monitor.Op) string {
+	line := fmt.Sprintf("%s  %-6s  %s", op.Time.Format("15:04:05"), op.Kind, op.Path)
+	if op.Bytes > 0 {
+		line += "  " + formatSize(op.Bytes)
+	}
+	if op.Err != "" {
+		return " " + m.styles.error.Render(line+"  "+op.Err)
+	}
+	return " " + line
+}
+
+// StartDashboard shows the sharer dashboard until the user quits
+func StartDashboard(mon *monitor.Monitor, opts DashboardOptions) error {
+	programOpts := []tea.ProgramOption{tea.WithAltScreen()}
+	if opts.Context != nil {
+		programOpts = append(programOpts, tea.WithContext(opts.Context))
+	}
+	p := tea.NewProgram(newDashboardModel(mon, opts), programOpts...)
+
+	if _, err := p.Run(); err != nil && !errors.Is(err, tea.ErrProgramKilled) {
+		return fmt.Errorf("error running dashboard: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// detailTimeFormat is the modification time layout of the detailed view
+const detailTimeFormat = "2006-01-02 15:04"
+
+// details renders the permissions, owner, modification time and size columns
+func (i fileItem) details(size string) string {
+	owner := i.owner
+	if owner == "" {
+		owner = "-"
+	}
+
+	modified := "-"
+	if i.modTime > 0 {
+		modified = time.Unix(i.modTime, 0).Format(detailTimeFormat)
+	}
+
+	return fmt.Sprintf("%s  %-10s  %s  %s", os.FileMode(i.mode), owner, modified, size)
+}
+
+// toggleDetails switches the file lists between sizes only and full details
+func (m model) toggleDetails() (model, tea.Cmd, bool) {
+	m.detailed = !m.detailed
+	cmds := []tea.Cmd{
+		m.setRemoteItems(withDetails(m.allItems, m.detailed)),
+		m.local.list.SetItems(withDetails(m.local.list.Items(), m.detailed)),
+	}
+	return m, tea.Batch(cmds...), true
+}
+
+// withDetails returns a copy of items with the detail mode applied
+func withDetails(items []list.Item, detailed bool) []list.Item {
+	updated := make([]list.Item, len(items))
+	for n, item := range items {
+		if f, ok := item.(fileItem); ok {
+			f.detailed = detailed
+			item = f
+		}
+		updated[n] = item
+	}
+	return updated
+}
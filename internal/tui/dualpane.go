@@ -0,0 +1,224 @@
+package tui
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/Zayan-Mohamed/orb/internal/filesystem"
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// localItemsMsg carries a freshly read local directory listing
+type localItemsMsg struct {
+	dir   string
+	items []list.Item
+}
+
+// localPane is the local filesystem side of the split view
+type localPane struct {
+	dir  string
+	list list.Model
+}
+
+func newLocalPane(dir string, keys KeyMap, st styles) localPane {
+	l := st.newList("Local")
+	l.SetShowStatusBar(false)
+	// Filtering messages are routed to the remote list, so keep the
+	// local pane to plain navigation
+	l.SetFilteringEnabled(false)
+	keys.applyToList(&l)
+
+	return localPane{dir: dir, list: l}
+}
+
+// loadLocalDirectory reads the local pane's directory
+func (m model) loadLocalDirectory() tea.Cmd {
+	dir := m.local.dir
+	detailed := m.detailed
+	return func() tea.Msg {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+
+		items := []list.Item{}
+		if parent := filepath.Dir(dir); parent != dir {
+			items = append(items, fileItem{name: "..", isDir: true})
+		}
+
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			items = append(items, fileItem{
+				name:     entry.Name(),
+				size:     info.Size(),
+				modTime:  info.ModTime().Unix(),
+				mode:     uint32(info.Mode()),
+				owner:    filesystem.Owner(info),
+				isDir:    info.IsDir(),
+				detailed: detailed,
+			})
+		}
+
+		return localItemsMsg{dir: dir, items: items}
+	}
+}
+
+// handleLocalItems shows a local listing if it is still current
+func (m model) handleLocalItems(msg localItemsMsg) (tea.Model, tea.Cmd) {
+	if msg.dir != m.local.dir {
+		return m, nil
+	}
+	m.sort.apply(msg.items)
+	return m, m.local.list.SetItems(msg.items)
+}
+
+// toggleDualPane switches between the single remote list and the split view
+func (m model) toggleDualPane() (model, tea.Cmd, bool) {
+	m.dual = !m.dual
+	m.focusLocal = false
+	m = m.resize()
+	if m.dual {
+		if m.local.dir == "" {
+			dir, err := filepath.Abs(m.downloadDir)
+			if err != nil {
+				m.error = err.Error()
+				m.dual = false
+				return m.resize(), nil, true
+			}
+			m.local.dir = dir
+		}
+		return m, m.loadLocalDirectory(), true
+	}
+	return m, nil, true
+}
+
+// handleDualKey handles keys shared by both panes of the split view
+func (m model) handleDualKey(msg tea.KeyMsg) (model, tea.Cmd, bool) {
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("tab"))):
+		m.focusLocal = !m.focusLocal
+		return m, nil, true
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("c", "f5"))):
+		if m.focusLocal {
+			return m.copyToRemote()
+		}
+		return m.copyToLocal()
+	}
+
+	if m.focusLocal {
+		return m.handleLocalKey(msg)
+	}
+
+	return m, nil, false
+}
+
+// handleLocalKey handles navigation in the local pane
+func (m model) handleLocalKey(msg tea.KeyMsg) (model, tea.Cmd, bool) {
+	pressed := msg.String()
+	switch {
+	case matches(pressed, m.keys.Quit):
+		return m.quit()
+
+	case matches(pressed, m.keys.Split):
+		return m.toggleDualPane()
+
+	case matches(pressed, m.keys.Details):
+		return m.toggleDetails()
+
+	case matches(pressed, m.keys.Open):
+		item, ok := m.local.list.SelectedItem().(fileItem)
+		if !ok || !item.isDir {
+			return m, nil, true
+		}
+		if item.name == ".." {
+			m.local.dir = filepath.Dir(m.local.dir)
+		} else {
+			m.local.dir = filepath.Join(m.local.dir, item.name)
+		}
+		m.local.list.ResetSelected()
+		return m, m.loadLocalDirectory(), true
+
+	case matches(pressed, m.keys.Parent):
+		m.local.dir = filepath.Dir(m.local.dir)
+		m.local.list.ResetSelected()
+		return m, m.loadLocalDirectory(), true
+	}
+
+	var cmd tea.Cmd
+	m.local.list, cmd = m.local.list.Update(msg)
+	return m, cmd, true
+}
+
+// copyToLocal downloads the selected remote file into the local pane's directory
+func (m model) copyToLocal() (model, tea.Cmd, bool) {
+	item, ok := m.selectedEntry()
+	if !ok {
+		return m, nil, true
+	}
+	if item.isDir {
+		m.error = "copying directories is not supported"
+		return m, nil, true
+	}
+	name := filesystem.LocalName(item.name)
+	if !safeFilename(name) {
+		m.error = "invalid filename: contains unsafe characters"
+		return m, nil, true
+	}
+
+	remotePath := filepath.Join(m.currentPath, item.name)
+	localPath := filepath.Join(m.local.dir, name)
+	m, cmd, _ := m.startDownload(remotePath, localPath, item.size)
+	if m.prompt.kind == promptNone && m.error == "" {
+		m.notice = "Copying " + item.name + " to " + m.local.dir
+	}
+	return m, cmd, true
+}
+
+// copyToRemote uploads the selected local file into the current remote directory
+func (m model) copyToRemote() (model, tea.Cmd, bool) {
+	item, ok := m.local.list.SelectedItem().(fileItem)
+	if !ok || item.name == ".." {
+		return m, nil, true
+	}
+	if item.isDir {
+		m.error = "copying directories is not supported"
+		return m, nil, true
+	}
+
+	m, cmd, handled := m.queueUpload(filepath.Join(m.local.dir, item.name))
+	if m.error == "" {
+		m.notice = "Copying " + item.name + " to " + m.currentPath
+	}
+	return m, cmd, handled
+}
+
+// renderPanes lays out the local and remote lists side by side
+func (m model) renderPanes() string {
+	local := m.styles.pane
+	remote := m.styles.pane
+	if m.focusLocal {
+		local = m.styles.activePane
+	} else {
+		remote = m.styles.activePane
+	}
+
+	return lipgloss.JoinHorizontal(lipgloss.Top,
+		local.Render(m.local.list.View()),
+		remote.Render(m.list.View()),
+	)
+}
+
+// localDownloadFinished reports whether a download into the local pane's
+// directory has just completed
+func (m model) localDownloadFinished(t transfer.Transfer, previous transfer.State) bool {
+	return m.dual && t.Direction == transfer.Download && t.State == transfer.StateDone &&
+		previous != transfer.StateDone && filepath.Dir(t.LocalPath) == m.local.dir
+}
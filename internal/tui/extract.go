@@ -0,0 +1,94 @@
+package tui
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Zayan-Mohamed/orb/internal/archive"
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// extractedMsg reports the end of an extraction
+type extractedMsg struct {
+	notice string
+	err    error
+}
+
+// offerExtract asks whether to extract a finished download that is a tar or
+// zip archive, unless another prompt is open
+func (m model) offerExtract(t transfer.Transfer) model {
+	if m.prompt.kind != promptNone {
+		return m
+	}
+	if format, err := archive.Detect(t.LocalPath); err != nil || format == archive.None {
+		return m
+	}
+	m.prompt = promptState{kind: promptExtract, archive: t}
+	m.input.Blur()
+	return m
+}
+
+// extractView asks about the archive of the open prompt
+func (m model) extractView() string {
+	t := m.prompt.archive
+	return m.styles.status.Render(fmt.Sprintf("Extract %s into %s%c? (y/N)",
+		filepath.Base(t.LocalPath), filepath.Base(archive.DefaultDir(t.LocalPath)), filepath.Separator))
+}
+
+// extractArchive unpacks a downloaded archive next to it. A download that
+// was not verified is checked against the sharer's checksum first.
+func (m model) extractArchive(t transfer.Transfer) tea.Cmd {
+	client := m.client
+	return func() tea.Msg {
+		ctx := context.Background()
+		name := filepath.Base(t.LocalPath)
+		if t.SHA256 == nil {
+			// #nosec G304 -- the file was just downloaded by this session
+			file, err := os.Open(t.LocalPath)
+			if err != nil {
+				return extractedMsg{err: err}
+			}
+			h := sha256.New()
+			_, err = io.Copy(h, file)
+			_ = file.Close()
+			if err != nil {
+				return extractedMsg{err: err}
+			}
+			remoteSum, err := client.Hash(ctx, t.RemotePath)
+			if err != nil {
+				return extractedMsg{err: fmt.Errorf("failed to verify %s: %w", name, err)}
+			}
+			if !bytes.Equal(h.Sum(nil), remoteSum) {
+				return extractedMsg{err: fmt.Errorf("%s does not match the sharer's checksum, not extracting it", name)}
+			}
+		}
+
+		dest := archive.DefaultDir(t.LocalPath)
+		result, err := archive.Extract(ctx, t.LocalPath, dest)
+		if err != nil {
+			return extractedMsg{err: fmt.Errorf("failed to extract %s: %w", name, err)}
+		}
+		return extractedMsg{notice: fmt.Sprintf("Extracted %d files (%s) into %s", result.Files, formatSize(result.Bytes), dest)}
+	}
+}
+
+// handleExtracted shows the result and refreshes the local pane
+func (m model) handleExtracted(msg extractedMsg) (tea.Model, tea.Cmd) {
+	if msg.err != nil {
+		m.error = msg.err.Error()
+		m.notice = ""
+		return m, nil
+	}
+	m.error = ""
+	m.notice = msg.notice
+	if m.dual {
+		return m, m.loadLocalDirectory()
+	}
+	return m, nil
+}
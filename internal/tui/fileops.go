@@ -0,0 +1,137 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// newFolderPerm is the mode requested for directories created from the browser
+const newFolderPerm = 0755
+
+// fileOpDoneMsg reports the outcome of a delete, rename or mkdir
+type fileOpDoneMsg struct {
+	notice string
+	err    error
+}
+
+// selectedEntry returns the selected list entry, excluding the parent link
+func (m model) selectedEntry() (fileItem, bool) {
+	item, ok := m.list.SelectedItem().(fileItem)
+	if !ok || item.name == ".." {
+		return fileItem{}, false
+	}
+	return item, true
+}
+
+// requireWritable reports an error when the share does not accept changes
+func (m model) requireWritable() (model, bool) {
+	if m.readOnly {
+		m.error = "share is read-only"
+		return m, false
+	}
+	return m, true
+}
+
+// handleDeleteKey asks for confirmation before deleting the selected entry
+func (m model) handleDeleteKey() (model, tea.Cmd, bool) {
+	m, ok := m.requireWritable()
+	if !ok {
+		return m, nil, true
+	}
+	item, ok := m.selectedEntry()
+	if !ok {
+		return m, nil, true
+	}
+	return m.openConfirm(promptDelete, item)
+}
+
+// handleRenameKey opens an inline prompt with the current name
+func (m model) handleRenameKey() (model, tea.Cmd, bool) {
+	m, ok := m.requireWritable()
+	if !ok {
+		return m, nil, true
+	}
+	item, ok := m.selectedEntry()
+	if !ok {
+		return m, nil, true
+	}
+	m, cmd, handled := m.openPrompt(promptRename, "Rename to: ", "new name", item.name)
+	m.prompt.target = item
+	return m, cmd, handled
+}
+
+// handleMkdirKey opens a prompt for the new folder name
+func (m model) handleMkdirKey() (model, tea.Cmd, bool) {
+	m, ok := m.requireWritable()
+	if !ok {
+		return m, nil, true
+	}
+	return m.openPrompt(promptMkdir, "New folder: ", "folder name", "")
+}
+
+// validEntryName rejects names that would leave the current directory
+func validEntryName(name string) error {
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid name: %q", name)
+	}
+	return nil
+}
+
+func (m model) deleteEntry(item fileItem) tea.Cmd {
+	client := m.client
+	target := filepath.Join(m.currentPath, item.name)
+	return func() tea.Msg {
+		if err := client.Delete(context.Background(), target); err != nil {
+			return fileOpDoneMsg{err: err}
+		}
+		return fileOpDoneMsg{notice: "Deleted " + item.name}
+	}
+}
+
+func (m model) renameEntry(item fileItem, newName string) tea.Cmd {
+	client := m.client
+	oldPath := filepath.Join(m.currentPath, item.name)
+	newPath := filepath.Join(m.currentPath, newName)
+	return func() tea.Msg {
+		if err := validEntryName(newName); err != nil {
+			return fileOpDoneMsg{err: err}
+		}
+		if newName == item.name {
+			return nil
+		}
+		if err := client.Rename(context.Background(), oldPath, newPath); err != nil {
+			return fileOpDoneMsg{err: err}
+		}
+		return fileOpDoneMsg{notice: fmt.Sprintf("Renamed %s to %s", item.name, newName)}
+	}
+}
+
+func (m model) makeDirectory(name string) tea.Cmd {
+	client := m.client
+	target := filepath.Join(m.currentPath, name)
+	return func() tea.Msg {
+		if err := validEntryName(name); err != nil {
+			return fileOpDoneMsg{err: err}
+		}
+		if err := client.Mkdir(context.Background(), target, newFolderPerm); err != nil {
+			return fileOpDoneMsg{err: err}
+		}
+		return fileOpDoneMsg{notice: "Created folder " + name}
+	}
+}
+
+// handleFileOpDone shows the result and refreshes the listing
+func (m model) handleFileOpDone(msg fileOpDoneMsg) (tea.Model, tea.Cmd) {
+	if msg.err != nil {
+		m.error = msg.err.Error()
+		m.notice = ""
+		return m, nil
+	}
+	m.error = ""
+	m.notice = msg.notice
+	return m, m.loadDirectory()
+}
@@ -0,0 +1,120 @@
+package tui
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// fileCategories groups common extensions for the type filter
+var fileCategories = map[string][]string{
+	"images":    {".jpg", ".jpeg", ".png", ".gif", ".bmp", ".webp", ".svg", ".tif", ".tiff", ".heic", ".ico", ".raw"},
+	"documents": {".pdf", ".doc", ".docx", ".odt", ".rtf", ".txt", ".md", ".xls", ".xlsx", ".ods", ".csv", ".ppt", ".pptx", ".odp", ".epub"},
+	"archives":  {".zip", ".tar", ".gz", ".tgz", ".bz2", ".xz", ".zst", ".7z", ".rar", ".iso"},
+	"code": {".go", ".py", ".js", ".ts", ".jsx", ".tsx", ".c", ".h", ".cpp", ".hpp", ".rs", ".java", ".kt",
+		".rb", ".php", ".sh", ".html", ".css", ".json", ".yaml", ".yml", ".toml", ".sql", ".swift"},
+	"audio": {".mp3", ".flac", ".wav", ".ogg", ".m4a", ".aac", ".opus"},
+	"video": {".mp4", ".mkv", ".mov", ".avi", ".webm", ".wmv", ".m4v"},
+}
+
+// typeFilter limits the file list to a category or a set of extensions.
+// Directories are always shown so the tree stays navigable.
+type typeFilter struct {
+	name string          // category name or the extensions as entered
+	exts map[string]bool // lowercase extensions including the dot
+}
+
+// parseTypeFilter accepts a category name ("images") or a list of
+// extensions separated by commas or spaces ("pdf, .epub")
+func parseTypeFilter(value string) (typeFilter, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" || value == "all" {
+		return typeFilter{}, nil
+	}
+
+	if exts, ok := fileCategories[value]; ok {
+		f := typeFilter{name: value, exts: make(map[string]bool, len(exts))}
+		for _, ext := range exts {
+			f.exts[ext] = true
+		}
+		return f, nil
+	}
+
+	fields := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+	f := typeFilter{exts: make(map[string]bool, len(fields))}
+	for _, ext := range fields {
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if ext == "." || strings.ContainsAny(ext[1:], "./\\") {
+			return typeFilter{}, fmt.Errorf("%q is neither a category (%s) nor an extension", ext, strings.Join(categoryNames(), ", "))
+		}
+		f.exts[ext] = true
+	}
+	f.name = strings.Join(fields, ", ")
+	return f, nil
+}
+
+func categoryNames() []string {
+	names := make([]string, 0, len(fileCategories))
+	for name := range fileCategories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// active reports whether the filter hides anything
+func (f typeFilter) active() bool {
+	return len(f.exts) > 0
+}
+
+func (f typeFilter) String() string {
+	return "Type: " + f.name
+}
+
+// apply returns the items matching the filter
+func (f typeFilter) apply(items []list.Item) []list.Item {
+	if !f.active() {
+		return items
+	}
+
+	kept := make([]list.Item, 0, len(items))
+	for _, item := range items {
+		if file, ok := item.(fileItem); !ok || file.isDir || f.exts[strings.ToLower(filepath.Ext(file.name))] {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// setRemoteItems shows a remote listing through the type filter. The
+// unfiltered listing is kept so the filter can be changed without reloading.
+func (m *model) setRemoteItems(items []list.Item) tea.Cmd {
+	m.allItems = items
+	return m.list.SetItems(m.typeFilter.apply(items))
+}
+
+// setTypeFilter changes the type filter from the prompt value
+func (m model) setTypeFilter(value string) (model, tea.Cmd, bool) {
+	f, err := parseTypeFilter(value)
+	if err != nil {
+		m.error = err.Error()
+		return m, nil, true
+	}
+
+	m.typeFilter = f
+	m.error = ""
+	m.list.ResetSelected()
+	return m, m.setRemoteItems(m.allItems), true
+}
+
+// openTypeFilter asks for a category or extensions, prefilled with the current filter
+func (m model) openTypeFilter() (model, tea.Cmd, bool) {
+	placeholder := strings.Join(categoryNames(), ", ") + " or extensions, e.g. pdf,epub"
+	return m.openPrompt(promptTypeFilter, "Show only: ", placeholder, m.typeFilter.name)
+}
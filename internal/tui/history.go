@@ -0,0 +1,101 @@
+package tui
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// historyItem is a completed or failed transfer in the history list
+type historyItem struct {
+	transfer.Transfer
+}
+
+func (i historyItem) Title() string {
+	mark := "✓"
+	if i.State == transfer.StateFailed {
+		mark = "✗"
+	}
+	return mark + " " + filepath.Base(i.RemotePath)
+}
+
+func (i historyItem) Description() string {
+	destination := i.LocalPath
+	if i.Direction == transfer.Upload {
+		destination = "remote:" + i.RemotePath
+	}
+
+	desc := fmt.Sprintf("%s • %s • %s • %s → %s",
+		i.Finished.Format("15:04:05"),
+		i.Direction,
+		formatSize(i.Size),
+		i.Finished.Sub(i.Started).Round(100*time.Millisecond),
+		destination)
+	if i.SHA256 != nil {
+		desc += " • checksum verified"
+	}
+	if i.Err != nil {
+		desc += " • " + i.Err.Error()
+	}
+	return desc
+}
+
+func (i historyItem) FilterValue() string { return filepath.Base(i.RemotePath) }
+
+// openHistory shows the transfers finished during this session
+func (m model) openHistory() (model, tea.Cmd, bool) {
+	m.showHistory = true
+	return m, m.refreshHistory(), true
+}
+
+// refreshHistory loads the history list from the transfer manager, newest first
+func (m *model) refreshHistory() tea.Cmd {
+	history := m.transfers.History()
+	items := make([]list.Item, len(history))
+	for i, t := range history {
+		items[len(history)-1-i] = historyItem{t}
+	}
+	m.history.Title = fmt.Sprintf("Transfer History (%d)", len(items))
+	return m.history.SetItems(items)
+}
+
+// handleHistoryKey handles keys while the history list is shown
+func (m model) handleHistoryKey(msg tea.KeyMsg) (model, tea.Cmd, bool) {
+	if m.history.FilterState() == list.Filtering {
+		var cmd tea.Cmd
+		m.history, cmd = m.history.Update(msg)
+		return m, cmd, true
+	}
+
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("q"))):
+		return m.quit()
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("esc"))):
+		if m.history.FilterState() == list.FilterApplied {
+			m.history.ResetFilter()
+			return m, nil, true
+		}
+		m.showHistory = false
+		return m, nil, true
+
+	case matches(msg.String(), m.keys.History):
+		m.showHistory = false
+		return m, nil, true
+
+	case matches(msg.String(), m.keys.Launch):
+		if selected, ok := m.history.SelectedItem().(historyItem); ok {
+			return m.openDownload(selected.Transfer)
+		}
+		return m, nil, true
+	}
+
+	var cmd tea.Cmd
+	m.history, cmd = m.history.Update(msg)
+	return m, cmd, true
+}
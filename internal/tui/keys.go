@@ -0,0 +1,218 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+)
+
+// KeyMap holds the file browser bindings. Keys separated by a space
+// (e.g. "g g") form a two-key sequence.
+type KeyMap struct {
+	Up          key.Binding
+	Down        key.Binding
+	Top         key.Binding
+	Bottom      key.Binding
+	Open        key.Binding
+	Parent      key.Binding
+	GoTo        key.Binding
+	Download    key.Binding
+	Upload      key.Binding
+	Search      key.Binding
+	Filter      key.Binding
+	Types       key.Binding
+	Sort        key.Binding
+	Reverse     key.Binding
+	Details     key.Binding
+	Destination key.Binding
+	Split       key.Binding
+	Bookmark    key.Binding
+	Bookmarks   key.Binding
+	Transfers   key.Binding
+	History     key.Binding
+	Launch      key.Binding
+	Copy        key.Binding
+	Delete      key.Binding
+	Rename      key.Binding
+	Mkdir       key.Binding
+	Chat        key.Binding
+	Quit        key.Binding
+}
+
+// DefaultKeyMap returns the standard bindings
+func DefaultKeyMap() KeyMap {
+	return KeyMap{
+		Up:          key.NewBinding(key.WithKeys("up", "k"), key.WithHelp("↑/k", "up")),
+		Down:        key.NewBinding(key.WithKeys("down", "j"), key.WithHelp("↓/j", "down")),
+		Top:         key.NewBinding(key.WithKeys("home"), key.WithHelp("home", "top")),
+		Bottom:      key.NewBinding(key.WithKeys("end"), key.WithHelp("end", "bottom")),
+		Open:        key.NewBinding(key.WithKeys("enter"), key.WithHelp("Enter", "open/download")),
+		Parent:      key.NewBinding(key.WithKeys("backspace"), key.WithHelp("backspace", "parent dir")),
+		GoTo:        key.NewBinding(key.WithKeys(":"), key.WithHelp(":", "go to path")),
+		Download:    key.NewBinding(key.WithKeys("d"), key.WithHelp("d", "download")),
+		Upload:      key.NewBinding(key.WithKeys("u"), key.WithHelp("u", "upload")),
+		Search:      key.NewBinding(key.WithKeys("/"), key.WithHelp("/", "search")),
+		Filter:      key.NewBinding(key.WithKeys("f"), key.WithHelp("f", "filter")),
+		Types:       key.NewBinding(key.WithKeys("T"), key.WithHelp("T", "type filter")),
+		Sort:        key.NewBinding(key.WithKeys("s"), key.WithHelp("s", "sort field")),
+		Reverse:     key.NewBinding(key.WithKeys("r"), key.WithHelp("r", "reverse")),
+		Details:     key.NewBinding(key.WithKeys("i"), key.WithHelp("i", "details")),
+		Destination: key.NewBinding(key.WithKeys("D"), key.WithHelp("D", "destination")),
+		Split:       key.NewBinding(key.WithKeys("w"), key.WithHelp("w", "split view")),
+		Bookmark:    key.NewBinding(key.WithKeys("b"), key.WithHelp("b", "bookmark")),
+		Bookmarks:   key.NewBinding(key.WithKeys("B"), key.WithHelp("B", "bookmarks")),
+		Transfers:   key.NewBinding(key.WithKeys("t"), key.WithHelp("t", "transfers")),
+		History:     key.NewBinding(key.WithKeys("H"), key.WithHelp("H", "history")),
+		Launch:      key.NewBinding(key.WithKeys("o"), key.WithHelp("o", "open download")),
+		Copy:        key.NewBinding(key.WithKeys("y"), key.WithHelp("y", "copy path")),
+		Delete:      key.NewBinding(key.WithKeys("x", "delete"), key.WithHelp("x", "delete")),
+		Rename:      key.NewBinding(key.WithKeys("R"), key.WithHelp("R", "rename")),
+		Mkdir:       key.NewBinding(key.WithKeys("N"), key.WithHelp("N", "new folder")),
+		Chat:        key.NewBinding(key.WithKeys("m"), key.WithHelp("m", "chat")),
+		Quit:        key.NewBinding(key.WithKeys("q"), key.WithHelp("q", "quit")),
+	}
+}
+
+// ViKeyMap returns vi-style bindings: h/l to leave and enter directories,
+// gg/G to jump to the top and bottom
+func ViKeyMap() KeyMap {
+	k := DefaultKeyMap()
+	k.Top = key.NewBinding(key.WithKeys("g g", "home"), key.WithHelp("gg", "top"))
+	k.Bottom = key.NewBinding(key.WithKeys("G", "end"), key.WithHelp("G", "bottom"))
+	k.Open = key.NewBinding(key.WithKeys("l", "enter"), key.WithHelp("l", "open/download"))
+	k.Parent = key.NewBinding(key.WithKeys("h", "backspace"), key.WithHelp("h", "parent dir"))
+	k.Quit = key.NewBinding(key.WithKeys("q", "Z Q"), key.WithHelp("q", "quit"))
+	return k
+}
+
+// actions maps config action names to their bindings
+func (k *KeyMap) actions() map[string]*key.Binding {
+	return map[string]*key.Binding{
+		"up":          &k.Up,
+		"down":        &k.Down,
+		"top":         &k.Top,
+		"bottom":      &k.Bottom,
+		"open":        &k.Open,
+		"parent":      &k.Parent,
+		"goto":        &k.GoTo,
+		"download":    &k.Download,
+		"upload":      &k.Upload,
+		"search":      &k.Search,
+		"filter":      &k.Filter,
+		"types":       &k.Types,
+		"sort":        &k.Sort,
+		"reverse":     &k.Reverse,
+		"details":     &k.Details,
+		"destination": &k.Destination,
+		"split":       &k.Split,
+		"bookmark":    &k.Bookmark,
+		"bookmarks":   &k.Bookmarks,
+		"transfers":   &k.Transfers,
+		"history":     &k.History,
+		"launch":      &k.Launch,
+		"copy":        &k.Copy,
+		"delete":      &k.Delete,
+		"rename":      &k.Rename,
+		"mkdir":       &k.Mkdir,
+		"chat":        &k.Chat,
+		"quit":        &k.Quit,
+	}
+}
+
+// KeyMapFromConfig builds a keymap from a preset plus per-action overrides
+func KeyMapFromConfig(cfg config.KeymapConfig) (KeyMap, error) {
+	var k KeyMap
+	switch strings.ToLower(cfg.Preset) {
+	case "", "default":
+		k = DefaultKeyMap()
+	case "vi", "vim":
+		k = ViKeyMap()
+	default:
+		return KeyMap{}, fmt.Errorf("unknown keymap preset %q (use \"default\" or \"vi\")", cfg.Preset)
+	}
+
+	actions := k.actions()
+	for name, keys := range cfg.Bindings {
+		b, ok := actions[name]
+		if !ok {
+			return KeyMap{}, fmt.Errorf("unknown keymap action %q (valid actions: %s)", name, strings.Join(actionNames(actions), ", "))
+		}
+		if len(keys) == 0 {
+			return KeyMap{}, fmt.Errorf("keymap action %q has no keys", name)
+		}
+		*b = key.NewBinding(key.WithKeys(keys...), key.WithHelp(keys[0], b.Help().Desc))
+	}
+
+	return k, nil
+}
+
+func actionNames(actions map[string]*key.Binding) []string {
+	names := make([]string, 0, len(actions))
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// matches reports whether pressed (a key or space-separated key sequence) triggers b
+func matches(pressed string, b key.Binding) bool {
+	if !b.Enabled() {
+		return false
+	}
+	for _, k := range b.Keys() {
+		if k == pressed {
+			return true
+		}
+	}
+	return false
+}
+
+// startsSequence reports whether pressed is the first key of a two-key binding
+func (k KeyMap) startsSequence(pressed string) bool {
+	for _, b := range k.actions() {
+		for _, keys := range b.Keys() {
+			if strings.HasPrefix(keys, pressed+" ") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyToList makes the bubbles list navigate with the keymap's movement keys
+func (k KeyMap) applyToList(l *list.Model) {
+	l.KeyMap.CursorUp = singleKeys(k.Up)
+	l.KeyMap.CursorDown = singleKeys(k.Down)
+	l.KeyMap.GoToStart = singleKeys(k.Top)
+	l.KeyMap.GoToEnd = singleKeys(k.Bottom)
+	l.KeyMap.Filter = k.Filter
+	l.KeyMap.Quit = key.NewBinding(key.WithDisabled())
+}
+
+// singleKeys drops key sequences, which the list component cannot match
+func singleKeys(b key.Binding) key.Binding {
+	keys := []string{}
+	for _, k := range b.Keys() {
+		if !strings.Contains(k, " ") {
+			keys = append(keys, k)
+		}
+	}
+	return key.NewBinding(key.WithKeys(keys...), key.WithHelp(b.Help().Key, b.Help().Desc))
+}
+
+// helpLine renders bindings as "key: description" separated by bullets
+func helpLine(bindings ...key.Binding) string {
+	parts := make([]string, 0, len(bindings))
+	for _, b := range bindings {
+		if !b.Enabled() {
+			continue
+		}
+		parts = append(parts, b.Help().Key+": "+b.Help().Desc)
+	}
+	return strings.Join(parts, " • ")
+}
@@ -0,0 +1,58 @@
+package tui
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// openCommand returns the command that opens path with the desktop's default application
+func openCommand(path string) *exec.Cmd {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", path)
+	case "windows":
+		// The empty argument is the window title expected by start
+		return exec.Command("cmd", "/c", "start", "", path)
+	default:
+		return exec.Command("xdg-open", path)
+	}
+}
+
+// openFile launches the default application for a downloaded file without
+// waiting for it to exit
+func openFile(path string) tea.Cmd {
+	return func() tea.Msg {
+		// #nosec G204 -- path is a file this user downloaded, passed as a single argument
+		cmd := openCommand(path)
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("failed to open %s: %w", filepath.Base(path), err)
+		}
+		go func() { _ = cmd.Wait() }()
+		return nil
+	}
+}
+
+// openDownload opens a finished download
+func (m model) openDownload(t transfer.Transfer) (model, tea.Cmd, bool) {
+	if t.Direction != transfer.Download || t.State != transfer.StateDone {
+		m.error = "only completed downloads can be opened"
+		return m, nil, true
+	}
+	m.error = ""
+	m.notice = "Opening " + filepath.Base(t.LocalPath)
+	return m, openFile(t.LocalPath), true
+}
+
+// openLastDownload opens the most recently completed download
+func (m model) openLastDownload() (model, tea.Cmd, bool) {
+	if m.lastDownload == nil {
+		m.notice = "Nothing downloaded yet"
+		return m, nil, true
+	}
+	return m.openDownload(*m.lastDownload)
+}
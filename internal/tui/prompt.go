@@ -0,0 +1,177 @@
+package tui
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// promptKind identifies what the single-line prompt is asking for
+type promptKind int
+
+const (
+	promptNone promptKind = iota
+	promptUpload
+	promptSearch
+	promptRename
+	promptMkdir
+	promptDelete
+	promptDestination
+	promptConflict
+	promptTypeFilter
+	promptGoto
+	promptChat
+	promptExtract
+)
+
+// promptState holds the open prompt and the entry it applies to
+type promptState struct {
+	kind     promptKind
+	target   fileItem          // entry being renamed or deleted
+	download download          // download whose local file already exists
+	archive  transfer.Transfer // finished download that can be extracted
+}
+
+// openPrompt shows a text prompt of the given kind prefilled with value
+func (m model) openPrompt(kind promptKind, label, placeholder, value string) (model, tea.Cmd, bool) {
+	m.prompt = promptState{kind: kind}
+	m.input.Prompt = label
+	m.input.Placeholder = placeholder
+	m.input.SetValue(value)
+	m.input.CursorEnd()
+	return m, m.input.Focus(), true
+}
+
+// openConfirm shows a yes/no question about target
+func (m model) openConfirm(kind promptKind, target fileItem) (model, tea.Cmd, bool) {
+	m.prompt = promptState{kind: kind, target: target}
+	m.input.Blur()
+	return m, nil, true
+}
+
+// closePrompt hides the prompt
+func (m model) closePrompt() model {
+	m.prompt = promptState{}
+	m.input.Blur()
+	return m
+}
+
+// handlePromptKey handles keys while a prompt is open
+func (m model) handlePromptKey(msg tea.KeyMsg) (model, tea.Cmd, bool) {
+	if m.prompt.kind == promptConflict {
+		return m.handleConflictKey(msg)
+	}
+	if m.prompt.kind == promptChat {
+		return m.handleChatKey(msg)
+	}
+
+	if m.prompt.kind == promptDelete {
+		target := m.prompt.target
+		m = m.closePrompt()
+		if key.Matches(msg, key.NewBinding(key.WithKeys("y", "Y"))) {
+			return m, m.deleteEntry(target), true
+		}
+		return m, nil, true
+	}
+
+	if m.prompt.kind == promptExtract {
+		t := m.prompt.archive
+		m = m.closePrompt()
+		if key.Matches(msg, key.NewBinding(key.WithKeys("y", "Y"))) {
+			m.notice = "Extracting " + filepath.Base(t.LocalPath) + "…"
+			return m, m.extractArchive(t), true
+		}
+		return m, nil, true
+	}
+
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("esc"))):
+		return m.closePrompt(), nil, true
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("enter"))):
+		prompt := m.prompt
+		value := strings.TrimSpace(m.input.Value())
+		m = m.closePrompt()
+		return m.submitPrompt(prompt, value)
+	}
+
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return m, cmd, true
+}
+
+// submitPrompt acts on the value entered in a prompt
+func (m model) submitPrompt(prompt promptState, value string) (model, tea.Cmd, bool) {
+	// An empty type filter shows all files again
+	if prompt.kind == promptTypeFilter {
+		return m.setTypeFilter(value)
+	}
+	if value == "" {
+		return m, nil, true
+	}
+
+	switch prompt.kind {
+	case promptUpload:
+		return m.queueUpload(value)
+	case promptSearch:
+		m.error = ""
+		return m, m.runSearch(value), true
+	case promptRename:
+		return m, m.renameEntry(prompt.target, value), true
+	case promptMkdir:
+		return m, m.makeDirectory(value), true
+	case promptDestination:
+		return m.setDownloadDir(value)
+	case promptGoto:
+		return m.gotoPath(value)
+	}
+
+	return m, nil, true
+}
+
+// promptView renders the open prompt
+func (m model) promptView() string {
+	if m.prompt.kind == promptConflict {
+		return m.conflictView()
+	}
+	if m.prompt.kind == promptExtract {
+		return m.extractView()
+	}
+	if m.prompt.kind == promptDelete {
+		what := "file"
+		if m.prompt.target.isDir {
+			what = "directory and everything in it"
+		}
+		return m.styles.error.Render("Delete " + what + " " + m.prompt.target.name + "? (y/N)")
+	}
+	return m.input.View()
+}
+
+// promptHelp returns the help line for the open prompt
+func (m model) promptHelp() string {
+	switch m.prompt.kind {
+	case promptUpload:
+		return "Enter: start upload • ESC: cancel"
+	case promptSearch:
+		return "Enter: search whole share • ESC: cancel"
+	case promptDelete:
+		return "y: delete • any other key: cancel"
+	case promptExtract:
+		return "y: extract, checking the sharer's checksum first if needed • any other key: skip"
+	case promptConflict:
+		return m.conflictHelp()
+	case promptGoto:
+		return "Enter: open remote directory (absolute or relative) • ESC: cancel"
+	case promptTypeFilter:
+		return "Enter: apply (empty shows all files) • ESC: cancel"
+	case promptDestination:
+		return "Enter: use this directory for the rest of the session • ESC: cancel"
+	case promptChat:
+		return "Enter: send • ESC: back to browser"
+	default:
+		return "Enter: confirm • ESC: cancel"
+	}
+}
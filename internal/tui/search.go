@@ -0,0 +1,153 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxSearchResults caps the number of matches requested from the sharer
+const maxSearchResults = 500
+
+// searchResultsMsg carries the sharer's answer to a search request
+type searchResultsMsg struct {
+	query     string
+	results   []protocol.SearchResult
+	truncated bool
+}
+
+// searchItem is a single search match shown in the results list
+type searchItem struct {
+	path string
+	info protocol.FileInfo
+}
+
+func (i searchItem) Title() string {
+	if i.info.IsDir {
+		return "📁 " + i.path
+	}
+	return "📄 " + i.path
+}
+
+func (i searchItem) Description() string {
+	if i.info.IsDir {
+		return "<DIR>"
+	}
+	return formatSize(i.info.Size)
+}
+
+func (i searchItem) FilterValue() string {
+	return i.path
+}
+
+// openSearch shows the search prompt
+func (m model) openSearch() (model, tea.Cmd, bool) {
+	return m.openPrompt(promptSearch, "Search: ", "part of a file name", "")
+}
+
+// runSearch asks the sharer for matches across the whole share
+func (m model) runSearch(query string) tea.Cmd {
+	client := m.client
+	return func() tea.Msg {
+		resp, err := client.Search(context.Background(), "/", query, maxSearchResults)
+		if err != nil {
+			return err
+		}
+		return searchResultsMsg{
+			query:     query,
+			results:   resp.Results,
+			truncated: resp.Truncated,
+		}
+	}
+}
+
+// handleSearchResults switches to the results view
+func (m model) handleSearchResults(msg searchResultsMsg) (tea.Model, tea.Cmd) {
+	items := make([]list.Item, 0, len(msg.results))
+	for _, r := range msg.results {
+		items = append(items, searchItem{path: r.Path, info: r.Info})
+	}
+
+	title := fmt.Sprintf("Search: %q (%d matches)", msg.query, len(items))
+	if msg.truncated {
+		title = fmt.Sprintf("Search: %q (first %d matches)", msg.query, len(items))
+	}
+
+	m.results.Title = title
+	m.results.ResetSelected()
+	cmd := m.results.SetItems(items)
+	m.showResults = true
+	return m, cmd
+}
+
+// handleResultsKey handles keys while search results are shown
+func (m model) handleResultsKey(msg tea.KeyMsg) (model, tea.Cmd, bool) {
+	if m.results.FilterState() == list.Filtering {
+		var cmd tea.Cmd
+		m.results, cmd = m.results.Update(msg)
+		return m, cmd, true
+	}
+
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("q"))):
+		return m.quit()
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("esc"))):
+		if m.results.FilterState() == list.FilterApplied {
+			m.results.ResetFilter()
+			return m, nil, true
+		}
+		m.showResults = false
+		return m, nil, true
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("/"))):
+		return m.openSearch()
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("enter"))):
+		return m.openSearchResult(false)
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("d"))):
+		return m.openSearchResult(true)
+
+	case matches(msg.String(), m.keys.Copy):
+		if selected, ok := m.results.SelectedItem().(searchItem); ok {
+			return m.copyPath(selected.path)
+		}
+		return m, nil, true
+	}
+
+	var cmd tea.Cmd
+	m.results, cmd = m.results.Update(msg)
+	return m, cmd, true
+}
+
+// openSearchResult jumps to a matched directory, or downloads a matched file.
+// With downloadOnly set, directories are ignored.
+func (m model) openSearchResult(downloadOnly bool) (model, tea.Cmd, bool) {
+	selected, ok := m.results.SelectedItem().(searchItem)
+	if !ok {
+		return m, nil, true
+	}
+
+	if selected.info.IsDir {
+		if downloadOnly {
+			return m, nil, true
+		}
+		m.showResults = false
+		m.currentPath = selected.path
+		return m, m.loadDirectory(), true
+	}
+
+	m.showResults = false
+	m.currentPath = path.Dir(selected.path)
+	m, _, _ = m.queueDownload(fileItem{
+		name: selected.info.Name,
+		size: selected.info.Size,
+	})
+	return m, m.loadDirectory(), true
+}
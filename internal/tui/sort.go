@@ -0,0 +1,87 @@
+package tui
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+)
+
+// sortField selects the attribute the file list is ordered by
+type sortField int
+
+const (
+	sortByName sortField = iota
+	sortBySize
+	sortByModTime
+)
+
+func (f sortField) String() string {
+	switch f {
+	case sortBySize:
+		return "size"
+	case sortByModTime:
+		return "modified"
+	default:
+		return "name"
+	}
+}
+
+// next cycles name → size → modified → name
+func (f sortField) next() sortField {
+	return (f + 1) % 3
+}
+
+// sortOrder is the user's sort choice. It lives on the model so it is kept
+// when navigating between directories.
+type sortOrder struct {
+	field      sortField
+	descending bool
+}
+
+func (o sortOrder) String() string {
+	arrow := "↑"
+	if o.descending {
+		arrow = "↓"
+	}
+	return "Sort: " + o.field.String() + " " + arrow
+}
+
+// apply orders items in place. The parent entry stays on top and directories
+// are always grouped before files.
+func (o sortOrder) apply(items []list.Item) {
+	sort.SliceStable(items, func(i, j int) bool {
+		a, aok := items[i].(fileItem)
+		b, bok := items[j].(fileItem)
+		if !aok || !bok {
+			return false
+		}
+
+		if a.name == ".." || b.name == ".." {
+			return a.name == ".."
+		}
+		if a.isDir != b.isDir {
+			return a.isDir
+		}
+
+		if o.descending {
+			return o.less(b, a)
+		}
+		return o.less(a, b)
+	})
+}
+
+// less compares two entries by the selected field, falling back to name
+func (o sortOrder) less(a, b fileItem) bool {
+	switch o.field {
+	case sortBySize:
+		if a.size != b.size {
+			return a.size < b.size
+		}
+	case sortByModTime:
+		if a.modTime != b.modTime {
+			return a.modTime < b.modTime
+		}
+	}
+	return strings.ToLower(a.name) < strings.ToLower(b.name)
+}
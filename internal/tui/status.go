@@ -0,0 +1,125 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	// pingInterval is how often the status bar measures the round-trip time
+	pingInterval = 2 * time.Second
+
+	// pingTimeout marks the tunnel unresponsive when no pong arrives in time
+	pingTimeout = 5 * time.Second
+)
+
+// connState is the tunnel state shown in the status bar
+type connState int
+
+const (
+	connConnected connState = iota
+	connUnresponsive
+	connDisconnected
+)
+
+func (s connState) String() string {
+	switch s {
+	case connUnresponsive:
+		return "unresponsive"
+	case connDisconnected:
+		return "disconnected"
+	default:
+		return "connected"
+	}
+}
+
+// pingMsg carries the result of a periodic ping
+type pingMsg struct {
+	rtt time.Duration
+	err error
+	at  time.Time
+}
+
+// statusBar tracks the connection details shown below the file list
+type statusBar struct {
+	state      connState
+	rtt        time.Duration
+	throughput float64 // bytes per second over the last ping interval
+	lastBytes  int64
+	lastSample time.Time
+	haveInfo   bool // the share mode is known
+}
+
+// pingTunnel waits for the next interval and measures the round-trip time
+func (m model) pingTunnel() tea.Cmd {
+	client := m.client
+	return tea.Tick(pingInterval, func(time.Time) tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		defer cancel()
+
+		rtt, err := client.Ping(ctx)
+		return pingMsg{rtt: rtt, err: err, at: time.Now()}
+	})
+}
+
+// handlePing updates the connection state and samples transfer throughput
+func (m model) handlePing(msg pingMsg) (tea.Model, tea.Cmd) {
+	s := &m.status
+
+	bytes := m.transfers.BytesTransferred()
+	if !s.lastSample.IsZero() {
+		if elapsed := msg.at.Sub(s.lastSample).Seconds(); elapsed > 0 {
+			s.throughput = float64(bytes-s.lastBytes) / elapsed
+		}
+	}
+	s.lastBytes = bytes
+	s.lastSample = msg.at
+
+	switch {
+	case m.client.Mux().Err() != nil:
+		// The tunnel is gone for good, stop pinging
+		s.state = connDisconnected
+		s.throughput = 0
+		return m, nil
+	case msg.err != nil:
+		s.state = connUnresponsive
+	default:
+		s.state = connConnected
+		s.rtt = msg.rtt
+	}
+
+	return m, m.pingTunnel()
+}
+
+// renderStatusBar renders the tunnel state, latency, throughput and share mode
+func (m model) renderStatusBar() string {
+	s := m.status
+
+	state := "● " + s.state.String()
+	if s.state == connConnected {
+		state = m.styles.progress.UnsetPadding().Render(state)
+	} else {
+		state = m.styles.error.Render(state)
+	}
+
+	parts := []string{state}
+	if s.rtt > 0 && s.state != connDisconnected {
+		parts = append(parts, fmt.Sprintf("RTT %s", s.rtt.Round(time.Millisecond)))
+	}
+	if s.throughput > 0 {
+		parts = append(parts, formatSize(int64(s.throughput))+"/s")
+	}
+	if s.haveInfo {
+		if m.readOnly {
+			parts = append(parts, "read-only")
+		} else {
+			parts = append(parts, "read-write")
+		}
+	}
+
+	return " " + strings.Join(parts, m.styles.status.Render("•"))
+}
@@ -0,0 +1,205 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Zayan-Mohamed/orb/internal/config"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Theme is the set of colors used by the file browser. Colors are ANSI
+// numbers ("205") or hex values ("#ff87d7"); an empty color means the
+// terminal's default.
+type Theme struct {
+	Accent  string // titles, selection and the focused pane
+	Text    string // file names
+	Muted   string // status lines, descriptions and help
+	Success string // progress and notices
+	Error   string // errors and confirmations
+	Border  string // pane borders and the empty part of progress bars
+	Bold    bool   // emphasize titles and selection with bold text
+}
+
+// Built-in themes
+var (
+	DefaultTheme = Theme{
+		Accent:  "205",
+		Muted:   "241",
+		Success: "46",
+		Error:   "196",
+		Border:  "240",
+		Bold:    true,
+	}
+
+	// LightTheme uses darker colors that stay readable on light backgrounds
+	LightTheme = Theme{
+		Accent:  "125",
+		Text:    "235",
+		Muted:   "243",
+		Success: "28",
+		Error:   "160",
+		Border:  "250",
+		Bold:    true,
+	}
+
+	// HighContrastTheme sticks to bright basic colors that are easy to tell
+	// apart, including for red/green color blindness
+	HighContrastTheme = Theme{
+		Accent:  "11",
+		Text:    "15",
+		Muted:   "15",
+		Success: "14",
+		Error:   "13",
+		Border:  "15",
+		Bold:    true,
+	}
+
+	// NoColorTheme relies on bold text and symbols only
+	NoColorTheme = Theme{
+		Bold: true,
+	}
+)
+
+// ThemeFromConfig resolves the configured theme. NO_COLOR in the environment
+// always wins, see https://no-color.org.
+func ThemeFromConfig(cfg config.ThemeConfig) (Theme, error) {
+	if os.Getenv("NO_COLOR") != "" {
+		return NoColorTheme, nil
+	}
+
+	var t Theme
+	switch strings.ToLower(cfg.Name) {
+	case "", "default", "dark":
+		t = DefaultTheme
+	case "light":
+		t = LightTheme
+	case "high-contrast", "highcontrast":
+		t = HighContrastTheme
+	case "no-color", "none", "mono":
+		return NoColorTheme, nil
+	default:
+		return Theme{}, fmt.Errorf("unknown theme %q (use default, light, high-contrast or no-color)", cfg.Name)
+	}
+
+	overrides := []struct {
+		value string
+		field *string
+	}{
+		{cfg.Accent, &t.Accent},
+		{cfg.Text, &t.Text},
+		{cfg.Muted, &t.Muted},
+		{cfg.Success, &t.Success},
+		{cfg.Error, &t.Error},
+		{cfg.Border, &t.Border},
+	}
+	for _, o := range overrides {
+		if o.value != "" {
+			*o.field = o.value
+		}
+	}
+
+	return t, nil
+}
+
+// color converts a theme color, mapping "" to the terminal default
+func color(c string) lipgloss.TerminalColor {
+	if c == "" {
+		return lipgloss.NoColor{}
+	}
+	return lipgloss.Color(c)
+}
+
+// styles are the lipgloss styles derived from a theme
+type styles struct {
+	title          lipgloss.Style
+	status         lipgloss.Style
+	help           lipgloss.Style
+	progress       lipgloss.Style
+	progressBar    lipgloss.Style
+	progressFilled lipgloss.Style
+	selected       lipgloss.Style
+	error          lipgloss.Style
+	pane           lipgloss.Style
+	activePane     lipgloss.Style
+	delegate       list.DefaultDelegate
+}
+
+func newStyles(t Theme) styles {
+	s := styles{
+		title: lipgloss.NewStyle().
+			Bold(t.Bold).
+			Foreground(color(t.Accent)).
+			Padding(0, 1),
+
+		status: lipgloss.NewStyle().
+			Foreground(color(t.Muted)).
+			Padding(0, 1),
+
+		help: lipgloss.NewStyle().
+			Foreground(color(t.Muted)).
+			Padding(1, 0),
+
+		progress: lipgloss.NewStyle().
+			Bold(t.Bold).
+			Foreground(color(t.Success)).
+			Padding(0, 1),
+
+		progressBar: lipgloss.NewStyle().
+			Foreground(color(t.Border)).
+			Background(color(t.Border)),
+
+		progressFilled: lipgloss.NewStyle().
+			Foreground(color(t.Success)).
+			Background(color(t.Success)),
+
+		selected: lipgloss.NewStyle().
+			Bold(t.Bold).
+			Foreground(color(t.Accent)),
+
+		// Without colors, errors stand out through bold text alone
+		error: lipgloss.NewStyle().
+			Bold(t.Error == "").
+			Foreground(color(t.Error)),
+
+		pane: lipgloss.NewStyle().
+			Border(lipgloss.RoundedBorder()).
+			BorderForeground(color(t.Border)),
+	}
+
+	s.activePane = s.pane.BorderForeground(color(t.Accent))
+	if t.Accent == "" {
+		// Without colors, mark the focused pane with a heavier border
+		s.activePane = s.pane.Border(lipgloss.ThickBorder())
+	}
+
+	d := list.NewDefaultDelegate()
+	d.Styles.NormalTitle = d.Styles.NormalTitle.Foreground(color(t.Text))
+	d.Styles.NormalDesc = d.Styles.NormalDesc.Foreground(color(t.Muted))
+	d.Styles.SelectedTitle = d.Styles.SelectedTitle.
+		Bold(t.Bold).
+		Foreground(color(t.Accent)).
+		BorderForeground(color(t.Accent))
+	d.Styles.SelectedDesc = d.Styles.SelectedDesc.
+		Foreground(color(t.Accent)).
+		BorderForeground(color(t.Accent))
+	d.Styles.DimmedTitle = d.Styles.DimmedTitle.Foreground(color(t.Muted))
+	d.Styles.DimmedDesc = d.Styles.DimmedDesc.Foreground(color(t.Muted))
+	d.Styles.FilterMatch = d.Styles.FilterMatch.Underline(true)
+	s.delegate = d
+
+	return s
+}
+
+// newList creates a bubbles list styled with the theme
+func (s styles) newList(title string) list.Model {
+	l := list.New([]list.Item{}, s.delegate, 0, 0)
+	l.Title = title
+	l.Styles.Title = s.title
+	l.Styles.StatusBar = l.Styles.StatusBar.Foreground(s.status.GetForeground())
+	l.Styles.HelpStyle = l.Styles.HelpStyle.Foreground(s.help.GetForeground())
+	l.Styles.NoItems = l.Styles.NoItems.Foreground(s.status.GetForeground())
+	return l
+}
@@ -0,0 +1,211 @@
+package tui
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const transferBarWidth = 30
+
+// handleTransfersKey handles keys while the transfers pane is shown
+func (m model) handleTransfersKey(msg tea.KeyMsg) (model, tea.Cmd, bool) {
+	switch {
+	case key.Matches(msg, key.NewBinding(key.WithKeys("q"))):
+		return m.quit()
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("t", "esc"))):
+		m.showTransfers = false
+		return m, nil, true
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("up", "k"))):
+		if m.transferCursor > 0 {
+			m.transferCursor--
+		}
+		return m, nil, true
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("down", "j"))):
+		if m.transferCursor < len(m.transferList)-1 {
+			m.transferCursor++
+		}
+		return m, nil, true
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("p", " "))):
+		if t, ok := m.selectedTransfer(); ok {
+			var err error
+			if t.State == transfer.StatePaused {
+				err = m.transfers.Resume(t.ID)
+			} else {
+				err = m.transfers.Pause(t.ID)
+			}
+			m.setError(err)
+		}
+		return m, nil, true
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("x", "delete"))):
+		if t, ok := m.selectedTransfer(); ok {
+			m.setError(m.transfers.Cancel(t.ID))
+		}
+		return m, nil, true
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("c"))):
+		m.transfers.ClearFinished()
+		return m, nil, true
+
+	case key.Matches(msg, key.NewBinding(key.WithKeys("o"))):
+		if t, ok := m.selectedTransfer(); ok {
+			return m.openDownload(t)
+		}
+		return m, nil, true
+	}
+
+	return m, nil, true
+}
+
+// selectedTransfer returns the transfer under the cursor
+func (m model) selectedTransfer() (transfer.Transfer, bool) {
+	if m.transferCursor < 0 || m.transferCursor >= len(m.transferList) {
+		return transfer.Transfer{}, false
+	}
+	return m.transferList[m.transferCursor], true
+}
+
+// setError shows err in the status line, or clears it when err is nil
+func (m *model) setError(err error) {
+	if err != nil {
+		m.error = err.Error()
+	} else {
+		m.error = ""
+	}
+}
+
+// transferSummary returns a one-line description of active transfers
+func (m model) transferSummary() string {
+	var running, queued, paused int
+	for _, t := range m.transferList {
+		switch t.State {
+		case transfer.StateRunning:
+			running++
+		case transfer.StateQueued:
+			queued++
+		case transfer.StatePaused:
+			paused++
+		}
+	}
+
+	if running+queued+paused == 0 {
+		return ""
+	}
+	summary := fmt.Sprintf("Transfers: %d running, %d queued, %d paused", running, queued, paused)
+	if eta, ok := overallETA(m.transferList); ok {
+		summary += ", " + formatDuration(eta) + " left"
+	}
+	return summary + " (t to view)"
+}
+
+// overallETA estimates when all running and queued transfers will be done,
+// assuming the combined speed of the running ones holds
+func overallETA(transfers []transfer.Transfer) (time.Duration, bool) {
+	var remaining int64
+	var speed float64
+	for _, t := range transfers {
+		switch t.State {
+		case transfer.StateRunning:
+			speed += t.Speed
+			fallthrough
+		case transfer.StateQueued:
+			remaining += t.Size - t.Transferred
+		}
+	}
+	if speed <= 0 || remaining <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(remaining) / speed * float64(time.Second)), true
+}
+
+// formatDuration renders a time estimate as "1h02m", "3m05s" or "42s"
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	switch {
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds())%60)
+	default:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+}
+
+func (m model) renderTransfers() string {
+	var b strings.Builder
+
+	b.WriteString(m.styles.title.Render("Transfers"))
+	b.WriteString("\n\n")
+
+	if len(m.transferList) == 0 {
+		b.WriteString(m.styles.status.Render("No transfers yet. Press d on a file to download it."))
+		b.WriteString("\n")
+	}
+
+	for i, t := range m.transferList {
+		b.WriteString(m.renderTransferRow(t, i == m.transferCursor))
+		b.WriteString("\n")
+	}
+
+	if m.error != "" {
+		b.WriteString("\n")
+		b.WriteString(m.styles.error.Render("Error: " + m.error))
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\n")
+	b.WriteString(m.renderStatusBar())
+	b.WriteString("\n")
+	b.WriteString(m.styles.help.Render("↑/↓: select • p: pause/resume • x: cancel • c: clear finished • o: open • t/ESC: back • q: quit"))
+
+	return b.String()
+}
+
+func (m model) renderTransferRow(t transfer.Transfer, selected bool) string {
+	arrow := "↓"
+	if t.Direction == transfer.Upload {
+		arrow = "↑"
+	}
+
+	name := fmt.Sprintf("%s %s", arrow, filepath.Base(t.RemotePath))
+	if selected {
+		name = m.styles.selected.Render("> " + name)
+	} else {
+		name = "  " + name
+	}
+
+	progress := t.Progress()
+	filled := int(float64(transferBarWidth) * progress / 100)
+	if filled > transferBarWidth {
+		filled = transferBarWidth
+	}
+	bar := m.styles.progressFilled.Render(strings.Repeat("█", filled)) +
+		m.styles.progressBar.Render(strings.Repeat("░", transferBarWidth-filled))
+
+	detail := fmt.Sprintf("%5.1f%%  %s / %s  %s",
+		progress,
+		formatSize(t.Transferred),
+		formatSize(t.Size),
+		t.State)
+	if t.State == transfer.StateRunning && t.Speed > 0 {
+		detail += "  " + formatSize(int64(t.Speed)) + "/s"
+		if eta, ok := t.ETA(); ok {
+			detail += "  ETA " + formatDuration(eta)
+		}
+	}
+	if t.Err != nil {
+		detail += ": " + t.Err.Error()
+	}
+
+	return name + "\n    " + bar + " " + m.styles.status.Render(detail)
+}
@@ -0,0 +1,202 @@
+package tunnel
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/Zayan-Mohamed/orb/internal/metrics"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+const (
+	// minCompress is the smallest payload worth compressing
+	minCompress = 64
+	// minSample is the smallest payload whose entropy is measured, below it
+	// the estimate is too low to tell compressed data from other data
+	minSample = 1024
+	// sampleSize and samples bound how much of a payload is looked at to
+	// tell whether it compresses
+	sampleSize = 4096
+	samples    = 3
+	// maxEntropy is the entropy in bits per byte above which a payload is
+	// taken as compressed or encrypted already
+	maxEntropy = 7.5
+	// magicWindow is how far into a payload file formats are recognized. The
+	// data of a chunk follows the gob description of its message.
+	magicWindow = 128
+)
+
+// Metrics of compression, see --metrics-addr
+var (
+	compressedPayloads = metrics.NewCounter("orb_tunnel_compression_total", "Payloads sent to peers that decode compressed ones, by how they were sent", "result")
+	compressionSaved   = metrics.NewCounter("orb_tunnel_compression_saved_bytes_total", "Bytes compression saved on payloads sent through tunnels")
+)
+
+// compressedFormats start files whose contents are compressed already, so
+// compressing chunks of them only costs CPU
+var compressedFormats = [][]byte{
+	[]byte("PK\x03\x04"),                     // zip, docx, jar, apk
+	[]byte("\x1f\x8b\x08"),                   // gzip
+	[]byte("BZh"),                            // bzip2
+	[]byte("\xfd7zXZ\x00"),                   // xz
+	[]byte("\x28\xb5\x2f\xfd"),               // zstd
+	[]byte("7z\xbc\xaf\x27\x1c"),             // 7z
+	[]byte("Rar!\x1a\x07"),                   // rar
+	[]byte("\xff\xd8\xff"),                   // jpeg
+	[]byte("\x89PNG\r\n\x1a\n"),              // png
+	[]byte("GIF8"),                           // gif
+	[]byte("ftyp"),                           // mp4, mov, heic, at offset 4
+	[]byte("\x1a\x45\xdf\xa3"),               // mkv, webm
+	[]byte("OggS"),                           // ogg, opus
+	[]byte("fLaC"),                           // flac
+	[]byte("ID3"),                            // mp3
+	[]byte("\x00\x00\x00\x0cjP  \r\n\x87\n"), // jpeg 2000
+}
+
+// Writers and readers are large, so they are kept for the next payload
+var (
+	// Only the best compression finds matches in a preset dictionary, and
+	// the payloads it is used for are small
+	dictionaryWriters = sync.Pool{New: func() any {
+		w, _ := flate.NewWriterDict(nil, flate.BestCompression, protocol.Dictionary())
+		return w
+	}}
+	smallWriters = sync.Pool{New: func() any {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	}}
+	bulkWriters = sync.Pool{New: func() any {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	}}
+	readers = sync.Pool{New: func() any {
+		return flate.NewReader(bytes.NewReader(nil))
+	}}
+)
+
+// compressPayload returns payload compressed and its encoding, or payload
+// itself with protocol.EncodingNone when compressing does not pay.
+// dictionary tells whether the peer has the same shared dictionary.
+// Small payloads, mostly requests and listings, are compressed thoroughly
+// and with the dictionary, bulk ones as fast as DEFLATE goes.
+func compressPayload(payload []byte, dictionary bool) ([]byte, uint8) {
+	// Larger payloads are sent as they are, so that the peer can refuse
+	// anything that decompresses to more
+	if len(payload) < minCompress || len(payload) > protocol.MaxFrameSize {
+		return payload, protocol.EncodingNone
+	}
+	if reason := incompressible(payload); reason != "" {
+		compressedPayloads.Inc(reason)
+		return payload, protocol.EncodingNone
+	}
+
+	pool, encoding, result := &smallWriters, uint8(protocol.EncodingDeflate), "deflate"
+	switch {
+	case len(payload) >= protocol.BulkPayload:
+		pool = &bulkWriters
+	case dictionary:
+		pool, encoding, result = &dictionaryWriters, protocol.EncodingDeflateDictionary, "dictionary"
+	}
+	w := pool.Get().(*flate.Writer)
+	defer pool.Put(w)
+	var buf bytes.Buffer
+	buf.Grow(len(payload) / 2)
+	w.Reset(&buf)
+	if _, err := w.Write(payload); err != nil {
+		return payload, protocol.EncodingNone
+	}
+	if err := w.Close(); err != nil {
+		return payload, protocol.EncodingNone
+	}
+
+	// Less than a sixteenth saved is not worth decompressing
+	if buf.Len() > len(payload)-len(payload)/16 {
+		compressedPayloads.Inc("no_gain")
+		return payload, protocol.EncodingNone
+	}
+	compressedPayloads.Inc(result)
+	compressionSaved.Add(float64(len(payload) - buf.Len()))
+	return buf.Bytes(), encoding
+}
+
+// incompressible returns why a payload is not worth compressing, judged
+// from samples of it: "compressed_format" when it starts a file of a
+// compressed format, "high_entropy" when its bytes look random, as those of
+// compressed and encrypted files do, and "" otherwise
+func incompressible(payload []byte) string {
+	window := payload[:min(len(payload), magicWindow)]
+	for _, magic := range compressedFormats {
+		if bytes.Contains(window, magic) {
+			return "compressed_format"
+		}
+	}
+	if len(payload) < minSample {
+		return ""
+	}
+
+	// Samples from the start, the middle and the end
+	size := min(len(payload), sampleSize)
+	var high int
+	for i := range samples {
+		offset := (len(payload) - size) * i / (samples - 1)
+		if entropy(payload[offset:offset+size]) > maxEntropy {
+			high++
+		}
+	}
+	if high > samples/2 {
+		return "high_entropy"
+	}
+	return ""
+}
+
+// entropy returns the Shannon entropy of data in bits per byte
+func entropy(data []byte) float64 {
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	var bits float64
+	n := float64(len(data))
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / n
+			bits -= p * math.Log2(p)
+		}
+	}
+	return bits
+}
+
+// errTooLarge is returned for payloads that decompress to more than is
+// ever compressed
+var errTooLarge = errors.New("decompressed payload exceeds maximum size")
+
+// decompressPayload reverses compressPayload
+func decompressPayload(payload []byte, encoding uint8) ([]byte, error) {
+	var dictionary []byte
+	switch encoding {
+	case protocol.EncodingDeflate:
+	case protocol.EncodingDeflateDictionary:
+		dictionary = protocol.Dictionary()
+	default:
+		return nil, fmt.Errorf("unknown payload encoding %d", encoding)
+	}
+
+	r := readers.Get().(io.ReadCloser)
+	defer readers.Put(r)
+	if err := r.(flate.Resetter).Reset(bytes.NewReader(payload), dictionary); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, protocol.MaxFrameSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > protocol.MaxFrameSize {
+		return nil, errTooLarge
+	}
+	return data, nil
+}
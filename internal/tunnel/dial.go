@@ -0,0 +1,35 @@
+//go:build !js
+
+package tunnel
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/websocket"
+)
+
+// dial opens the WebSocket connection to the relay at u
+func dial(u *url.URL, options dialOptions) (wsConn, error) {
+	dialer := *websocket.DefaultDialer
+	if options.proxy != nil {
+		dialer.Proxy = http.ProxyURL(options.proxy)
+	}
+	if options.hop != nil {
+		// The hop stands in for the proxy, which is only used to reach it
+		dialer.Proxy = nil
+		dialer.NetDialContext = options.dialHop
+	}
+	conn, resp, err := dialer.Dial(u.String(), options.header)
+	if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("relay requires a valid token (--relay-token)")
+	}
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRelayUnreachable, err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,176 @@
+//go:build js && wasm
+
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"syscall/js"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dial opens the WebSocket connection to the relay at u with the browser's
+// WebSocket. Browsers send no custom headers and take their proxy from the
+// system, so relay tokens and proxies are not used.
+func dial(u *url.URL, options dialOptions) (wsConn, error) {
+	if options.hop != nil {
+		return nil, errors.New("browsers cannot reach the relay through another relay")
+	}
+	c := &browserConn{ws: js.Global().Get("WebSocket").New(u.String())}
+	c.ws.Set("binaryType", "arraybuffer")
+	c.cond = sync.NewCond(&c.mu)
+
+	opened := make(chan bool, 1)
+	c.funcs = []js.Func{
+		js.FuncOf(func(js.Value, []js.Value) any {
+			opened <- true
+			return nil
+		}),
+		js.FuncOf(func(_ js.Value, args []js.Value) any {
+			data := js.Global().Get("Uint8Array").New(args[0].Get("data"))
+			msg := make([]byte, data.Get("length").Int())
+			js.CopyBytesToGo(msg, data)
+			c.mu.Lock()
+			c.queue = append(c.queue, msg)
+			c.mu.Unlock()
+			c.cond.Broadcast()
+			return nil
+		}),
+		js.FuncOf(func(_ js.Value, args []js.Value) any {
+			select {
+			case opened <- false:
+			default:
+			}
+			c.mu.Lock()
+			if c.err == nil {
+				c.err = &websocket.CloseError{Code: args[0].Get("code").Int(), Text: args[0].Get("reason").String()}
+			}
+			c.mu.Unlock()
+			c.cond.Broadcast()
+			return nil
+		}),
+		// Browsers follow an error with a close event, but not all runtimes do
+		js.FuncOf(func(js.Value, []js.Value) any {
+			select {
+			case opened <- false:
+			default:
+			}
+			return nil
+		}),
+	}
+	c.ws.Call("addEventListener", "open", c.funcs[0])
+	c.ws.Call("addEventListener", "message", c.funcs[1])
+	c.ws.Call("addEventListener", "close", c.funcs[2])
+	c.ws.Call("addEventListener", "error", c.funcs[3])
+
+	if !<-opened {
+		c.release()
+		// Browsers hide why a WebSocket failed, a session the relay does
+		// not know looks the same as a relay that is down
+		return nil, fmt.Errorf("%w: the relay refused the connection or the session does not exist", ErrRelayUnreachable)
+	}
+	return c, nil
+}
+
+// browserConn is a WebSocket of the browser. Messages are queued as they
+// arrive, since the callbacks of the browser must not block.
+type browserConn struct {
+	ws    js.Value
+	funcs []js.Func
+
+	mu           sync.Mutex
+	cond         *sync.Cond
+	queue        [][]byte
+	err          error // set once the connection closed
+	readDeadline time.Time
+}
+
+func (c *browserConn) ReadMessage() (int, []byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var timer *time.Timer
+	if !c.readDeadline.IsZero() {
+		timer = time.AfterFunc(time.Until(c.readDeadline), c.cond.Broadcast)
+		defer timer.Stop()
+	}
+	for len(c.queue) == 0 && c.err == nil {
+		if !c.readDeadline.IsZero() && time.Now().After(c.readDeadline) {
+			return 0, nil, errors.New("i/o timeout")
+		}
+		c.cond.Wait()
+	}
+	if len(c.queue) == 0 {
+		return 0, nil, c.err
+	}
+	msg := c.queue[0]
+	c.queue = c.queue[1:]
+	return websocket.BinaryMessage, msg, nil
+}
+
+func (c *browserConn) WriteMessage(_ int, data []byte) error {
+	c.mu.Lock()
+	err := c.err
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	buf := js.Global().Get("Uint8Array").New(len(data))
+	js.CopyBytesToJS(buf, data)
+	c.ws.Call("send", buf)
+	return nil
+}
+
+// WriteControl closes the connection for a close message, the browser
+// answers pings itself
+func (c *browserConn) WriteControl(messageType int, data []byte, _ time.Time) error {
+	if messageType != websocket.CloseMessage {
+		return nil
+	}
+	reason := ""
+	if len(data) > 2 {
+		reason = string(data[2:])
+	}
+	c.ws.Call("close", websocket.CloseNormalClosure, reason)
+	return nil
+}
+
+func (c *browserConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+// SetWriteDeadline does nothing, the browser buffers what is sent
+func (c *browserConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+func (c *browserConn) Close() error {
+	c.ws.Call("close")
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = net.ErrClosed
+	}
+	c.mu.Unlock()
+	c.cond.Broadcast()
+	c.release()
+	return nil
+}
+
+// release frees the callbacks once the connection is of no further use
+func (c *browserConn) release() {
+	for i, event := range []string{"open", "message", "close", "error"} {
+		if i < len(c.funcs) {
+			c.ws.Call("removeEventListener", event, c.funcs[i])
+			c.funcs[i].Release()
+		}
+	}
+	c.funcs = nil
+}
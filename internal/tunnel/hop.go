@@ -0,0 +1,142 @@
+//go:build !js
+
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// hopChunk bounds the messages a connection through a hop is sent in
+const hopChunk = 32 * 1024
+
+// HopDialer returns a dial function for an http.Transport that reaches
+// addresses through the hop given with WithHop, or nil without one
+func HopDialer(opts ...Option) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	options := dialOptions{header: http.Header{}}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.hop == nil {
+		return nil
+	}
+	return options.dialHop
+}
+
+// dialHop asks the hop relay to connect to addr, the host and port of the
+// relay behind it, and returns the connection carried by the WebSocket to
+// the hop. TLS to the relay behind runs inside it, so the hop only ever
+// sees addr.
+func (o dialOptions) dialHop(ctx context.Context, _, addr string) (net.Conn, error) {
+	u := *o.hop
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+	u.Path = "/hop"
+	u.RawQuery = url.Values{"to": {addr}}.Encode()
+
+	dialer := *websocket.DefaultDialer
+	if o.proxy != nil {
+		dialer.Proxy = http.ProxyURL(o.proxy)
+	}
+	header := http.Header{}
+	if o.hopToken != "" {
+		header.Set("Authorization", "Bearer "+o.hopToken)
+	}
+	conn, resp, err := dialer.DialContext(ctx, u.String(), header)
+	if resp != nil {
+		switch resp.StatusCode {
+		case http.StatusUnauthorized:
+			return nil, errors.New("the --via relay requires a valid token (--via-token)")
+		case http.StatusNotFound:
+			return nil, errors.New("the --via relay does not forward connections, it has to be started with --hop-to")
+		case http.StatusForbidden:
+			return nil, fmt.Errorf("the --via relay does not forward to %s", addr)
+		case http.StatusBadGateway:
+			return nil, fmt.Errorf("the --via relay failed to reach %s", addr)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the --via relay: %w", err)
+	}
+	return &hopConn{ws: conn}, nil
+}
+
+// hopConn carries a byte stream in the binary messages of a WebSocket to a
+// hop relay, which passes them on to the relay behind it
+type hopConn struct {
+	ws      *websocket.Conn
+	r       io.Reader // the message being read
+	writeMu sync.Mutex
+}
+
+func (c *hopConn) Read(p []byte) (int, error) {
+	for {
+		if c.r == nil {
+			_, r, err := c.ws.NextReader()
+			if err != nil {
+				var closeErr *websocket.CloseError
+				if errors.As(err, &closeErr) {
+					return 0, io.EOF
+				}
+				return 0, err
+			}
+			c.r = r
+		}
+		n, err := c.r.Read(p)
+		if errors.Is(err, io.EOF) {
+			c.r = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *hopConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), hopChunk)
+		if err := c.ws.WriteMessage(websocket.BinaryMessage, p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (c *hopConn) Close() error {
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	_ = c.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeWriteTimeout))
+	return c.ws.Close()
+}
+
+func (c *hopConn) LocalAddr() net.Addr  { return c.ws.LocalAddr() }
+func (c *hopConn) RemoteAddr() net.Addr { return c.ws.RemoteAddr() }
+
+func (c *hopConn) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
+
+func (c *hopConn) SetReadDeadline(t time.Time) error  { return c.ws.SetReadDeadline(t) }
+func (c *hopConn) SetWriteDeadline(t time.Time) error { return c.ws.SetWriteDeadline(t) }
@@ -0,0 +1,318 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/telemetry"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// eventBuffer is the number of unsolicited frames held for a slow reader
+const eventBuffer = 16
+
+// keepAliveIdle is how long KeepAlive lets a tunnel go without receiving
+// anything before it pings the peer, well within the read deadline of
+// either end
+const keepAliveIdle = dataReadTimeout / 4
+
+// Mux multiplexes concurrent requests over a single tunnel.
+// Each request is tagged with a unique frame ID and a single reader goroutine
+// dispatches responses back to the waiting caller.
+type Mux struct {
+	tun     *Tunnel
+	mu      sync.Mutex
+	nextID  uint32
+	pending map[uint32]chan *protocol.Frame
+	events  chan *protocol.Frame
+	chat    chan *protocol.Frame
+	// handlers consume unsolicited frames of their type in the read loop
+	handlers map[uint32]func(*protocol.Frame)
+	done     chan struct{}
+	err      error
+	received atomic.Int64 // when the last frame arrived, in Unix nanoseconds
+}
+
+// NewMux starts dispatching frames received on the tunnel.
+// Once a Mux is running, callers must not use tun.ReceiveFrame directly.
+func NewMux(tun *Tunnel) *Mux {
+	m := &Mux{
+		tun:      tun,
+		pending:  make(map[uint32]chan *protocol.Frame),
+		events:   make(chan *protocol.Frame, eventBuffer),
+		chat:     make(chan *protocol.Frame, eventBuffer),
+		handlers: make(map[uint32]func(*protocol.Frame)),
+		done:     make(chan struct{}),
+	}
+	m.received.Store(time.Now().UnixNano())
+
+	go m.readLoop()
+
+	return m
+}
+
+// readLoop delivers every received frame to the request waiting for its ID
+func (m *Mux) readLoop() {
+	for {
+		frame, err := m.tun.ReceiveFrame()
+		if err != nil {
+			m.fail(err)
+			return
+		}
+		m.received.Store(time.Now().UnixNano())
+
+		m.mu.Lock()
+		ch, ok := m.pending[frame.ID]
+		delete(m.pending, frame.ID)
+		handler := m.handlers[frame.Type]
+		m.mu.Unlock()
+
+		if ok {
+			ch <- frame
+			continue
+		}
+
+		if frame.ID == 0 && handler != nil {
+			handler(frame)
+			continue
+		}
+
+		// Chat messages have a queue of their own so that whoever reads
+		// watch events does not swallow them
+		if frame.ID == 0 && frame.Type == protocol.FrameTypeMessage {
+			select {
+			case m.chat <- frame:
+			default:
+				slog.Debug("dropped chat message, nobody is reading")
+			}
+			continue
+		}
+
+		// Unsolicited frames such as watch events. They only tell the
+		// receiver to look again, so dropping one behind a full buffer of
+		// others loses nothing.
+		if frame.ID == 0 {
+			select {
+			case m.events <- frame:
+			default:
+			}
+		}
+	}
+}
+
+// fail wakes all waiting requests with a terminal error
+func (m *Mux) fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return
+	}
+
+	m.err = err
+	close(m.done)
+}
+
+// Request sends a frame and waits for the matching response
+func (m *Mux) Request(ctx context.Context, frame *protocol.Frame) (*protocol.Frame, error) {
+	if !telemetry.Enabled() || frame.Type == protocol.FrameTypePing {
+		return m.request(ctx, frame)
+	}
+
+	// Requests outside a traced operation belong to the trace of the tunnel
+	if !telemetry.FromContext(ctx).IsValid() {
+		ctx = telemetry.ContextWithRemote(ctx, m.tun.trace)
+	}
+	ctx, span := telemetry.Start(ctx, "request "+protocol.FrameTypeName(frame.Type), telemetry.KindClient,
+		slog.Int("orb.request.bytes", len(frame.Payload)))
+	frame.Trace = span.Context().Traceparent()
+
+	resp, err := m.request(ctx, frame)
+	failure := err
+	if err == nil {
+		span.SetAttributes(slog.Int("orb.response.bytes", len(resp.Payload)))
+		if resp.Type == protocol.FrameTypeError {
+			failure = remoteFailure(resp)
+		}
+	}
+	span.End(failure)
+	return resp, err
+}
+
+// remoteFailure describes an error response by its code only, since the
+// message may name paths
+func remoteFailure(resp *protocol.Frame) error {
+	var errResp protocol.ErrorResponse
+	if err := gob.NewDecoder(bytes.NewReader(resp.Payload)).Decode(&errResp); err != nil {
+		return errors.New("error response")
+	}
+	return fmt.Errorf("error response %d", errResp.Code)
+}
+
+// request sends a frame and waits for the matching response
+func (m *Mux) request(ctx context.Context, frame *protocol.Frame) (*protocol.Frame, error) {
+	ch := make(chan *protocol.Frame, 1)
+
+	m.mu.Lock()
+	if m.err != nil {
+		err := m.err
+		m.mu.Unlock()
+		return nil, err
+	}
+	m.nextID++
+	if m.nextID == 0 {
+		m.nextID++ // ID 0 is reserved for unsolicited frames
+	}
+	id := m.nextID
+	m.pending[id] = ch
+	m.mu.Unlock()
+
+	frame.ID = id
+	if err := m.tun.SendFrame(frame); err != nil {
+		m.forget(id)
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-ctx.Done():
+		m.forget(id)
+		// Sending may wait behind other frames, the caller should not
+		go m.cancel(id)
+		return nil, ctx.Err()
+	case <-m.done:
+		return nil, fmt.Errorf("tunnel closed: %w", m.err)
+	}
+}
+
+// Call encodes req, performs a request of the given type and decodes the
+// response into resp. Remote failures are returned as *protocol.ErrorResponse.
+func (m *Mux) Call(ctx context.Context, frameType uint32, req, resp interface{}) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(req); err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	respFrame, err := m.Request(ctx, &protocol.Frame{
+		Type:    frameType,
+		Payload: buf.Bytes(),
+	})
+	if err != nil {
+		return err
+	}
+
+	switch respFrame.Type {
+	case protocol.FrameTypeError:
+		var errResp protocol.ErrorResponse
+		if err := gob.NewDecoder(bytes.NewReader(respFrame.Payload)).Decode(&errResp); err != nil {
+			return fmt.Errorf("failed to decode error response: %w", err)
+		}
+		return &errResp
+	case protocol.FrameTypeResponse:
+		if resp == nil {
+			return nil
+		}
+		if err := gob.NewDecoder(bytes.NewReader(respFrame.Payload)).Decode(resp); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unexpected frame type: %d", respFrame.Type)
+	}
+}
+
+// forget drops a pending request that will no longer be waited on
+func (m *Mux) forget(id uint32) {
+	m.mu.Lock()
+	delete(m.pending, id)
+	m.mu.Unlock()
+}
+
+// cancel asks the peer to stop working on an abandoned request
+func (m *Mux) cancel(id uint32) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(protocol.CancelRequest{ID: id}); err != nil {
+		return
+	}
+	// Best effort: a failed send means the tunnel is going away anyway
+	_ = m.tun.SendFrame(&protocol.Frame{
+		Type:    protocol.FrameTypeCancel,
+		Payload: buf.Bytes(),
+	})
+}
+
+// KeepAlive pings the peer whenever nothing arrived for a while, until the
+// tunnel closes, so that neither end reaches its read deadline while the
+// tunnel is idle. Clients that stay connected start it once.
+func (m *Mux) KeepAlive() {
+	go func() {
+		ticker := time.NewTicker(keepAliveIdle / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.done:
+				return
+			case <-ticker.C:
+				if time.Since(time.Unix(0, m.received.Load())) < keepAliveIdle {
+					continue
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), keepAliveIdle)
+				_, _ = m.Request(ctx, &protocol.Frame{Type: protocol.FrameTypePing, Payload: []byte{}})
+				cancel()
+			}
+		}
+	}()
+}
+
+// Notify sends a frame that expects no response, e.g. a chat message
+func (m *Mux) Notify(frame *protocol.Frame) error {
+	if err := m.Err(); err != nil {
+		return fmt.Errorf("tunnel closed: %w", err)
+	}
+	frame.ID = 0
+	return m.tun.SendFrame(frame)
+}
+
+// Handle has handler consume the unsolicited frames of a type, e.g. the
+// data of forwarded connections. It runs on the loop reading the tunnel and
+// must not block.
+func (m *Mux) Handle(frameType uint32, handler func(*protocol.Frame)) {
+	m.mu.Lock()
+	m.handlers[frameType] = handler
+	m.mu.Unlock()
+}
+
+// Chat delivers the chat messages of the peer
+func (m *Mux) Chat() <-chan *protocol.Frame {
+	return m.chat
+}
+
+// Events delivers frames the peer sent without being asked, e.g. watch events
+func (m *Mux) Events() <-chan *protocol.Frame {
+	return m.events
+}
+
+// Done is closed once the underlying tunnel stops delivering frames
+func (m *Mux) Done() <-chan struct{} {
+	return m.done
+}
+
+// Err returns the error that stopped the mux, if any
+func (m *Mux) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// Tunnel returns the underlying tunnel
+func (m *Mux) Tunnel() *Tunnel {
+	return m.tun
+}
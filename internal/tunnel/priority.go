@@ -0,0 +1,64 @@
+package tunnel
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/metrics"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// sendWaitSeconds measures how long frames wait for their turn to be sent,
+// see --metrics-addr
+var sendWaitSeconds = metrics.NewHistogram("orb_tunnel_send_wait_seconds", "Time frames waited for their turn to be sent, by priority class", metrics.LatencyBuckets, "class")
+
+// priorityNames label the priority classes in metrics
+var priorityNames = [protocol.NumPriorities]string{
+	protocol.PriorityInteractive: "interactive",
+	protocol.PriorityNormal:      "normal",
+	protocol.PriorityBulk:        "bulk",
+}
+
+// sendGate lets one sender at a time write to the connection. When the
+// sender is done, the turn goes to the longest waiting sender of the most
+// urgent class, so that a listing queued behind the chunks of a large
+// transfer only waits for the chunk being written.
+type sendGate struct {
+	mu      sync.Mutex
+	busy    bool
+	waiting [protocol.NumPriorities][]chan struct{}
+}
+
+// acquire blocks until it is the turn of a frame of the given class
+func (g *sendGate) acquire(priority int) {
+	started := time.Now()
+	g.mu.Lock()
+	if !g.busy {
+		g.busy = true
+		g.mu.Unlock()
+		sendWaitSeconds.Observe(0, priorityNames[priority])
+		return
+	}
+	turn := make(chan struct{})
+	g.waiting[priority] = append(g.waiting[priority], turn)
+	g.mu.Unlock()
+
+	<-turn
+	sendWaitSeconds.Observe(time.Since(started).Seconds(), priorityNames[priority])
+}
+
+// release hands the turn to the next sender, if any
+func (g *sendGate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for priority := range g.waiting {
+		if queue := g.waiting[priority]; len(queue) > 0 {
+			next := queue[0]
+			queue[0] = nil
+			g.waiting[priority] = queue[1:]
+			close(next)
+			return
+		}
+	}
+	g.busy = false
+}
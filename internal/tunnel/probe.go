@@ -0,0 +1,64 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// probeRounds is how many messages a probe times; the fastest round counts
+const probeRounds = 3
+
+// ErrNoProbe is returned by relays too old to answer probes
+var ErrNoProbe = errors.New("relay does not answer probes")
+
+// Probe times the round trip of a small WebSocket message to the relay, the
+// path tunnels take, which may be slower than plain HTTP requests behind
+// some proxies and load balancers
+func Probe(relayURL string, opts ...Option) (time.Duration, error) {
+	options := dialOptions{header: http.Header{}}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	u, err := url.Parse(relayURL)
+	if err != nil {
+		return 0, fmt.Errorf("invalid relay URL: %w", err)
+	}
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+	u.Path = "/probe"
+
+	conn, err := dial(u, options)
+	if errors.Is(err, ErrSessionNotFound) {
+		return 0, ErrNoProbe
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	best := time.Duration(0)
+	payload := []byte("orb")
+	for range probeRounds {
+		_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		start := time.Now()
+		if err := conn.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+			return 0, err
+		}
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return 0, err
+		}
+		if rtt := time.Since(start); best == 0 || rtt < best {
+			best = rtt
+		}
+	}
+	return best, nil
+}
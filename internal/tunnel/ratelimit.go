@@ -0,0 +1,49 @@
+package tunnel
+
+import (
+	"sync"
+	"time"
+)
+
+// limiter is a token bucket that paces traffic to a number of bytes per
+// second. A message larger than the bucket is let through and paid back by
+// waiting before the next one.
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(bytesPerSecond int64) *limiter {
+	rate := float64(bytesPerSecond)
+	return &limiter{
+		rate:   rate,
+		burst:  rate, // allow up to one second of traffic at once
+		tokens: rate,
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until n bytes may be sent or received
+func (l *limiter) wait(n int) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
@@ -2,13 +2,20 @@ package tunnel
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Zayan-Mohamed/orb/internal/crypto"
+	"github.com/Zayan-Mohamed/orb/internal/metrics"
+	"github.com/Zayan-Mohamed/orb/internal/telemetry"
 	"github.com/Zayan-Mohamed/orb/pkg/protocol"
 	"github.com/gorilla/websocket"
 )
@@ -19,22 +26,117 @@ const (
 	handshakeWriteTimeout = 30 * time.Second
 	dataReadTimeout       = 120 * time.Second // Increased for large file transfers
 	dataWriteTimeout      = 30 * time.Second
+	closeWriteTimeout     = time.Second
 )
 
+// ErrPeerClosed is returned once the peer or the relay ended the session on
+// purpose, e.g. because the peer quit or the session was revoked
+var ErrPeerClosed = errors.New("session ended by the peer")
+
+// ErrSessionNotFound is returned when the relay does not know the session,
+// e.g. because it was created on another relay or has expired
+var ErrSessionNotFound = errors.New("session not found on the relay")
+
+// ErrRelayUnreachable wraps errors reaching the relay at all
+var ErrRelayUnreachable = errors.New("failed to connect to relay")
+
+// Metrics of the tunnels of this process, see --metrics-addr
+var (
+	sentBytes        = metrics.NewCounter("orb_tunnel_sent_bytes_total", "Encrypted bytes sent through tunnels")
+	receivedBytes    = metrics.NewCounter("orb_tunnel_received_bytes_total", "Encrypted bytes received through tunnels")
+	tunnelConnects   = metrics.NewCounter("orb_tunnel_connects_total", "Attempts to set up a tunnel, by role and result", "role", "result")
+	tunnelsOpen      = metrics.NewGauge("orb_tunnels_open", "Tunnels currently open", "role")
+	handshakeSeconds = metrics.NewHistogram("orb_handshake_duration_seconds", "Time the Noise handshake took once both peers were connected", metrics.LatencyBuckets, "role")
+)
+
+// wsConn is the WebSocket connection to the relay, a gorilla connection or,
+// in a browser, the browser's own WebSocket
+type wsConn interface {
+	ReadMessage() (messageType int, data []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
 // Tunnel represents an encrypted tunnel between peers
 type Tunnel struct {
-	conn       *websocket.Conn
+	conn       wsConn
 	sendCipher *crypto.AEAD
 	recvCipher *crypto.AEAD
 	sessionID  string
-	mu         sync.Mutex
+	send       sendGate   // serializes writers, most urgent first
+	recvMu     sync.Mutex // serializes readers
+	mu         sync.Mutex // guards closed
 	closed     bool
+	sendLimit  *limiter // nil when unlimited
+	recvLimit  *limiter
+	sent       atomic.Int64 // encrypted bytes, without WebSocket framing
+	received   atomic.Int64
+	// peerAccepts is the Accept of the peer's frames, 0 until it sent one
+	// that says it decodes compressed payloads
+	peerAccepts atomic.Uint32
+	trace       telemetry.SpanContext // span of the tunnel's setup, if traced
+	role        string                // "sharer" or "receiver", for metrics
+	// handshakeStarted is when both peers were there to shake hands
+	handshakeStarted time.Time
+}
+
+// Option configures how a tunnel connects to the relay
+type Option func(*dialOptions)
+
+type dialOptions struct {
+	header   http.Header
+	bwLimit  int64
+	proxy    *url.URL
+	hop      *url.URL
+	hopToken string
+}
+
+// WithRelayToken authenticates to a relay that only serves clients with a token
+func WithRelayToken(token string) Option {
+	return func(o *dialOptions) {
+		if token != "" {
+			o.header.Set("Authorization", "Bearer "+token)
+		}
+	}
+}
+
+// WithBandwidthLimit caps the traffic in each direction to bytesPerSecond.
+// Zero or less means unlimited.
+func WithBandwidthLimit(bytesPerSecond int64) Option {
+	return func(o *dialOptions) {
+		o.bwLimit = bytesPerSecond
+	}
+}
+
+// WithProxy reaches the relay through an HTTP or SOCKS5 proxy. Without it,
+// or with nil, the proxy comes from HTTPS_PROXY and related variables.
+func WithProxy(proxy *url.URL) Option {
+	return func(o *dialOptions) {
+		o.proxy = proxy
+	}
+}
+
+// WithHop reaches the relay through the relay at hop, which passes the
+// connection on without taking part in it. The relay of the session then
+// sees the address of hop instead of this machine's, and hop, with an https
+// relay behind it, sees neither the session nor anything in it. token
+// authenticates to hop. With a proxy, the proxy is used to reach hop.
+func WithHop(hop *url.URL, token string) Option {
+	return func(o *dialOptions) {
+		o.hop = hop
+		o.hopToken = token
+	}
 }
 
 // NewTunnel creates a new encrypted tunnel
-func NewTunnel(relayURL, sessionID, passcode string, isInitiator bool) (*Tunnel, error) {
-	// Derive key from passcode
-	presharedKey := crypto.DeriveKey(passcode, sessionID)
+func NewTunnel(relayURL, sessionID, passcode string, isInitiator bool, opts ...Option) (*Tunnel, error) {
+	options := dialOptions{header: http.Header{}}
+	for _, opt := range opts {
+		opt(&options)
+	}
 
 	// Connect to relay
 	endpoint := "share"
@@ -59,28 +161,73 @@ func NewTunnel(relayURL, sessionID, passcode string, isInitiator bool) (*Tunnel,
 	q.Set("session", sessionID)
 	u.RawQuery = q.Encode()
 
-	// Dial WebSocket
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	role := "sharer"
+	if isInitiator {
+		role = "receiver"
+	}
+	ctx, span := telemetry.Start(context.Background(), "tunnel", telemetry.KindClient,
+		slog.String("orb.session", sessionID), slog.String("orb.role", role))
+	// The relay's forwarding span joins the trace of the tunnel
+	if tp := span.Context().Traceparent(); tp != "" {
+		options.header.Set("Traceparent", tp)
+	}
+
+	conn, err := dial(u, options)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to relay: %w", err)
+		tunnelConnects.Inc(role, connectResult(err))
+		span.End(err)
+		return nil, err
 	}
 
+	// Derive key from passcode. Doing so once the relay has accepted the
+	// session saves the work when the session is on another relay.
+	presharedKey := crypto.DeriveKey(passcode, sessionID)
+
 	tunnel := &Tunnel{
 		conn:      conn,
 		sessionID: sessionID,
+		trace:     span.Context(),
+		role:      role,
+	}
+	if options.bwLimit > 0 {
+		tunnel.sendLimit = newLimiter(options.bwLimit)
+		tunnel.recvLimit = newLimiter(options.bwLimit)
 	}
 
 	// Perform Noise handshake
-	if err := tunnel.performHandshake(presharedKey, isInitiator); err != nil {
+	_, handshake := telemetry.Start(ctx, "handshake", telemetry.KindInternal)
+	err = tunnel.performHandshake(presharedKey, isInitiator)
+	handshake.End(err)
+	if err != nil {
+		err = fmt.Errorf("handshake failed: %w", err)
 		if closeErr := conn.Close(); closeErr != nil {
-			return nil, fmt.Errorf("handshake failed: %w (failed to close: %v)", err, closeErr)
+			err = fmt.Errorf("%w (failed to close: %v)", err, closeErr)
 		}
-		return nil, fmt.Errorf("handshake failed: %w", err)
+		tunnelConnects.Inc(role, "handshake_failed")
+		span.End(err)
+		return nil, err
 	}
+	span.End(nil)
+	tunnelConnects.Inc(role, "ok")
+	tunnelsOpen.Add(1, role)
+	handshakeSeconds.Observe(time.Since(tunnel.handshakeStarted).Seconds(), role)
 
+	slog.Debug("tunnel established", "session", sessionID, "initiator", isInitiator, "bwlimit", options.bwLimit)
 	return tunnel, nil
 }
 
+// connectResult labels a failure to reach the peer through the relay
+func connectResult(err error) string {
+	switch {
+	case errors.Is(err, ErrSessionNotFound):
+		return "session_not_found"
+	case errors.Is(err, ErrRelayUnreachable):
+		return "relay_unreachable"
+	default:
+		return "failed"
+	}
+}
+
 // performHandshake performs the Noise protocol handshake
 func (t *Tunnel) performHandshake(presharedKey []byte, isInitiator bool) error {
 	noise, err := crypto.NewNoiseHandshake(presharedKey, isInitiator)
@@ -103,6 +250,8 @@ func (t *Tunnel) performHandshake(presharedKey []byte, isInitiator bool) error {
 }
 
 func (t *Tunnel) performInitiatorHandshake(noise *crypto.NoiseHandshake) error {
+	t.handshakeStarted = time.Now()
+
 	// Send initiator message
 	msg, err := noise.CreateInitiatorMessage()
 	if err != nil {
@@ -132,11 +281,12 @@ func (t *Tunnel) performInitiatorHandshake(noise *crypto.NoiseHandshake) error {
 }
 
 func (t *Tunnel) performResponderHandshake(noise *crypto.NoiseHandshake) error {
-	// Receive initiator message
+	// Receive initiator message, which arrives once the receiver is there
 	initFrame, err := t.recvRawFrame()
 	if err != nil {
 		return err
 	}
+	t.handshakeStarted = time.Now()
 
 	if initFrame.Type != protocol.FrameTypeHandshake {
 		return fmt.Errorf("unexpected frame type: %d", initFrame.Type)
@@ -186,27 +336,41 @@ func (t *Tunnel) setupTransportKeys(noise *crypto.NoiseHandshake) error {
 }
 
 // SendFrame sends an encrypted frame
+// It is safe to call concurrently with ReceiveFrame. Of the frames waiting
+// to be sent, those of the most urgent priority class go first, see
+// protocol.FramePriority.
 func (t *Tunnel) SendFrame(frame *protocol.Frame) error {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-
-	if t.closed {
-		return fmt.Errorf("tunnel closed")
+	// Payloads are compressed for peers that decode them, see compress.go
+	out := *frame
+	out.Accept = protocol.DictionaryID()
+	if accepts := t.peerAccepts.Load(); accepts != 0 {
+		out.Payload, out.Encoding = compressPayload(frame.Payload, accepts == protocol.DictionaryID())
 	}
 
 	// Serialize frame payload
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
-	if err := enc.Encode(frame); err != nil {
+	if err := enc.Encode(&out); err != nil {
 		return fmt.Errorf("failed to encode frame: %w", err)
 	}
 
+	t.send.acquire(protocol.FramePriority(frame))
+	defer t.send.release()
+
+	if t.IsClosed() {
+		return fmt.Errorf("tunnel closed")
+	}
+
 	// Encrypt payload
 	encrypted, err := t.sendCipher.Encrypt(buf.Bytes())
 	if err != nil {
 		return fmt.Errorf("failed to encrypt: %w", err)
 	}
 
+	t.sendLimit.wait(len(encrypted))
+	t.sent.Add(int64(len(encrypted)))
+	sentBytes.Add(float64(len(encrypted)))
+
 	// Send over WebSocket
 	_ = t.conn.SetWriteDeadline(time.Now().Add(dataWriteTimeout))
 	if err := t.conn.WriteMessage(websocket.BinaryMessage, encrypted); err != nil {
@@ -218,10 +382,10 @@ func (t *Tunnel) SendFrame(frame *protocol.Frame) error {
 
 // ReceiveFrame receives and decrypts a frame
 func (t *Tunnel) ReceiveFrame() (*protocol.Frame, error) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+	t.recvMu.Lock()
+	defer t.recvMu.Unlock()
 
-	if t.closed {
+	if t.IsClosed() {
 		return nil, fmt.Errorf("tunnel closed")
 	}
 
@@ -229,9 +393,23 @@ func (t *Tunnel) ReceiveFrame() (*protocol.Frame, error) {
 	_ = t.conn.SetReadDeadline(time.Now().Add(dataReadTimeout))
 	_, encrypted, err := t.conn.ReadMessage()
 	if err != nil {
+		// WebSocket read errors are permanent, so the tunnel is unusable
+		_ = t.Close()
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) && closeErr.Code == websocket.CloseNormalClosure {
+			if closeErr.Text != "" {
+				return nil, fmt.Errorf("%w: %s", ErrPeerClosed, closeErr.Text)
+			}
+			return nil, ErrPeerClosed
+		}
 		return nil, fmt.Errorf("failed to receive: %w", err)
 	}
 
+	// Holding back the next read slows the sender down as well
+	t.recvLimit.wait(len(encrypted))
+	t.received.Add(int64(len(encrypted)))
+	receivedBytes.Add(float64(len(encrypted)))
+
 	// Decrypt payload
 	decrypted, err := t.recvCipher.Decrypt(encrypted)
 	if err != nil {
@@ -250,6 +428,17 @@ func (t *Tunnel) ReceiveFrame() (*protocol.Frame, error) {
 		return nil, protocol.ErrUnknownFrameType
 	}
 
+	if frame.Accept != 0 {
+		t.peerAccepts.Store(frame.Accept)
+	}
+	if frame.Encoding != protocol.EncodingNone {
+		payload, err := decompressPayload(frame.Payload, frame.Encoding)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress frame: %w", err)
+		}
+		frame.Payload, frame.Encoding = payload, protocol.EncodingNone
+	}
+
 	return &frame, nil
 }
 
@@ -299,8 +488,20 @@ func (t *Tunnel) Ping() error {
 	return nil
 }
 
+// Traffic returns the encrypted bytes sent and received so far. Comparing
+// them with the data carried shows the cost of framing and encryption.
+func (t *Tunnel) Traffic() (sent, received int64) {
+	return t.sent.Load(), t.received.Load()
+}
+
 // Close closes the tunnel
 func (t *Tunnel) Close() error {
+	return t.CloseWithReason("")
+}
+
+// CloseWithReason closes the tunnel and has the relay tell the peer why,
+// e.g. "session expired"
+func (t *Tunnel) CloseWithReason(reason string) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
@@ -309,6 +510,12 @@ func (t *Tunnel) Close() error {
 	}
 
 	t.closed = true
+	tunnelsOpen.Add(-1, t.role)
+
+	// Tell the relay, and through it the peer, that the tunnel ended on
+	// purpose rather than dropping the connection
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason)
+	_ = t.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeWriteTimeout))
 	return t.conn.Close()
 }
 
@@ -0,0 +1,158 @@
+// Package watch reports changes anywhere below a directory
+package watch
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// quietPeriod is how long the tree must be unchanged before a batch is sent,
+	// so that a file being written produces one notification instead of many
+	quietPeriod = 250 * time.Millisecond
+
+	// maxDelay bounds how long a batch is held back while changes keep coming
+	maxDelay = 2 * time.Second
+)
+
+// Watcher watches a directory tree, including directories created later.
+// Symlinks are not followed.
+type Watcher struct {
+	root    string
+	fsw     *fsnotify.Watcher
+	changes chan []string
+	done    chan struct{}
+}
+
+// New starts watching root
+func New(root string) (*Watcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{
+		root:    root,
+		fsw:     fsw,
+		changes: make(chan []string, 1),
+		done:    make(chan struct{}),
+	}
+	if err := w.addTree(root); err != nil {
+		_ = fsw.Close()
+		return nil, err
+	}
+
+	go w.run()
+
+	return w, nil
+}
+
+// Changes delivers batches of changed paths, relative to the root with forward
+// slashes. A batch that is not received in time is merged into the next one.
+func (w *Watcher) Changes() <-chan []string {
+	return w.changes
+}
+
+// Close stops watching and closes the Changes channel
+func (w *Watcher) Close() error {
+	err := w.fsw.Close()
+	<-w.done
+	return err
+}
+
+// addTree watches dir and every directory below it
+func (w *Watcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// The directory may already be gone again
+			if p != dir && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		return w.fsw.Add(p)
+	})
+}
+
+func (w *Watcher) run() {
+	defer close(w.done)
+	defer close(w.changes)
+
+	pending := make(map[string]bool)
+	var quiet, deadline <-chan time.Time
+
+	for {
+		select {
+		case event, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+
+			// New directories need watches of their own
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Lstat(event.Name); err == nil && info.IsDir() {
+					if err := w.addTree(event.Name); err != nil {
+						slog.Warn("cannot watch directory", "path", event.Name, "err", err)
+					}
+				}
+			}
+
+			if rel, err := filepath.Rel(w.root, event.Name); err == nil {
+				pending[filepath.ToSlash(rel)] = true
+			}
+			quiet = time.After(quietPeriod)
+			if deadline == nil {
+				deadline = time.After(maxDelay)
+			}
+
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			slog.Warn("watch failed", "root", w.root, "err", err)
+
+		case <-quiet:
+			w.flush(pending)
+			quiet, deadline = nil, nil
+
+		case <-deadline:
+			w.flush(pending)
+			quiet, deadline = nil, nil
+		}
+	}
+}
+
+// flush hands the pending paths to the receiver without blocking
+func (w *Watcher) flush(pending map[string]bool) {
+	if len(pending) == 0 {
+		return
+	}
+
+	// Merge with a batch the receiver has not picked up yet
+	select {
+	case previous := <-w.changes:
+		for _, p := range previous {
+			pending[p] = true
+		}
+	default:
+	}
+
+	batch := make([]string, 0, len(pending))
+	for p := range pending {
+		batch = append(batch, p)
+		delete(pending, p)
+	}
+	sort.Strings(batch)
+
+	w.changes <- batch
+}
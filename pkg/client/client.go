@@ -0,0 +1,173 @@
+// Package client lets Go programs use orb shares the way "orb connect"
+// does: open the encrypted tunnel to a sharer through a relay, then list,
+// read, upload and watch files of the share.
+//
+//	tun, err := client.Connect(ctx, client.Session{
+//		Relay:    "https://relay.example.com",
+//		ID:       "ABC123",
+//		Passcode: "123-456",
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	defer tun.Close()
+//
+//	f, err := tun.FS().Open(ctx, "/report.pdf")
+//	if err != nil {
+//		return err
+//	}
+//	defer f.Close()
+//	_, err = io.Copy(out, f)
+//
+// Everything is end-to-end encrypted with a key derived from the passcode,
+// the relay only forwards ciphertext.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/internal/tunnel"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// pingInterval keeps idle tunnels well within their read deadline
+const pingInterval = 30 * time.Second
+
+// ErrSessionNotFound is returned by Connect when the relay does not know
+// the session, e.g. because it expired or was revoked
+var ErrSessionNotFound = tunnel.ErrSessionNotFound
+
+// ErrRelayUnreachable is returned by Connect when the relay cannot be
+// reached at all
+var ErrRelayUnreachable = tunnel.ErrRelayUnreachable
+
+// Session identifies a share, as printed by "orb share"
+type Session struct {
+	Relay    string // URL of the relay, e.g. https://relay.example.com
+	ID       string
+	Passcode string
+
+	Token string   // relay token, for relays started with --token
+	Proxy *url.URL // proxy to reach the relay through, if any
+}
+
+// Tunnel is an open connection to a sharer. It is safe for concurrent use.
+type Tunnel struct {
+	tun    *tunnel.Tunnel
+	client *remote.Client
+	info   *protocol.InfoResponse
+	fs     *RemoteFS
+}
+
+// Connect opens an encrypted tunnel to the sharer of s. A wrong passcode
+// fails the handshake. ctx only bounds connecting, use Close to end the
+// tunnel.
+func Connect(ctx context.Context, s Session) (*Tunnel, error) {
+	if s.Relay == "" || s.ID == "" || s.Passcode == "" {
+		return nil, errors.New("session needs a relay, an ID and a passcode")
+	}
+
+	type result struct {
+		tun *tunnel.Tunnel
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		tun, err := tunnel.NewTunnel(s.Relay, s.ID, s.Passcode, true,
+			tunnel.WithRelayToken(s.Token), tunnel.WithProxy(s.Proxy))
+		done <- result{tun, err}
+	}()
+
+	var tun *tunnel.Tunnel
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return nil, r.err
+		}
+		tun = r.tun
+	case <-ctx.Done():
+		// The handshake cannot be interrupted, close it once it ends
+		go func() {
+			if r := <-done; r.tun != nil {
+				_ = r.tun.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+
+	client := remote.NewClient(tunnel.NewMux(tun))
+	info, err := client.Info(ctx)
+	if err != nil {
+		_ = tun.Close()
+		return nil, fmt.Errorf("failed to read share info: %w", err)
+	}
+
+	t := &Tunnel{tun: tun, client: client, info: info}
+	t.fs = newRemoteFS(t)
+	go t.keepAlive()
+	return t, nil
+}
+
+// keepAlive pings the sharer until the tunnel closes, so that it does not
+// reach its read deadline while idle
+func (t *Tunnel) keepAlive() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.Done():
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), pingInterval)
+			_, _ = t.client.Ping(ctx)
+			cancel()
+		}
+	}
+}
+
+// FS returns the files of the share
+func (t *Tunnel) FS() *RemoteFS {
+	return t.fs
+}
+
+// ReadOnly reports whether the sharer refuses changes
+func (t *Tunnel) ReadOnly() bool {
+	return t.info.ReadOnly
+}
+
+// File returns the name of the only file of a share made with "orb send",
+// empty for folder shares
+func (t *Tunnel) File() string {
+	return t.info.File
+}
+
+// Stream reports whether the share is a stream from "orb send -", whose
+// only file can be read once, from start to end
+func (t *Tunnel) Stream() bool {
+	return t.info.Stream
+}
+
+// Ping measures the round trip to the sharer
+func (t *Tunnel) Ping(ctx context.Context) (time.Duration, error) {
+	return t.client.Ping(ctx)
+}
+
+// Done is closed once the tunnel has closed, see Err for why
+func (t *Tunnel) Done() <-chan struct{} {
+	return t.client.Mux().Done()
+}
+
+// Err returns why the tunnel closed, nil while it is open
+func (t *Tunnel) Err() error {
+	return t.client.Mux().Err()
+}
+
+// Close ends the tunnel, the sharer sees the receiver leave
+func (t *Tunnel) Close() error {
+	return t.tun.Close()
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+)
+
+// File is a remote file opened for reading. It reads a chunk per request
+// to the sharer, wrap it in a bufio.Reader for many small reads. Read and
+// Seek must not be used concurrently, ReadAt may be.
+type File struct {
+	fs   *RemoteFS
+	ctx  context.Context
+	name string
+	info fileInfo
+	size int64 // -1 for streams
+
+	mu     sync.Mutex
+	offset int64
+	closed bool
+}
+
+// Name returns the path the file was opened with
+func (f *File) Name() string {
+	return f.name
+}
+
+// Stat describes the file as it was when opened
+func (f *File) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Read reads the next bytes of the file
+func (f *File) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+// ReadAt reads len(p) bytes at off. Streams can only be read in order, from
+// the start.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+	var total int
+	for total < len(p) {
+		n, err := f.readAt(p[total:], off+int64(total))
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// readAt reads at most one chunk at off
+func (f *File) readAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if f.size >= 0 && off >= f.size {
+		return 0, io.EOF
+	}
+
+	length := int64(min(len(p), transfer.ChunkSize))
+	if f.size >= 0 {
+		length = min(length, f.size-off)
+	}
+	data, err := f.fs.t.client.Read(f.ctx, f.name, off, length)
+	if err != nil {
+		return 0, pathError("read", f.name, err)
+	}
+	if len(data) == 0 {
+		if f.size < 0 {
+			return 0, io.EOF
+		}
+		// The file shrank since it was opened
+		return 0, io.ErrUnexpectedEOF
+	}
+	return copy(p, data), nil
+}
+
+// Seek sets the offset of the next Read. Streams cannot seek.
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.size < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: errors.New("a stream cannot seek")}
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Close ends reading, the tunnel stays open
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	return nil
+}
@@ -0,0 +1,291 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// RemoteFS is the share as seen through a tunnel. Paths are slash-separated
+// and absolute, "/" is the top of the share. Failures reported by the sharer
+// are *fs.PathError values, so errors.Is works with fs.ErrNotExist,
+// fs.ErrExist and fs.ErrPermission.
+type RemoteFS struct {
+	t *Tunnel
+
+	watchOnce sync.Once
+	watchMu   sync.Mutex
+	watchers  map[*watcher]struct{}
+}
+
+func newRemoteFS(t *Tunnel) *RemoteFS {
+	return &RemoteFS{t: t, watchers: make(map[*watcher]struct{})}
+}
+
+// List returns the entries of the directory dir
+func (r *RemoteFS) List(ctx context.Context, dir string) ([]fs.FileInfo, error) {
+	dir = clean(dir)
+	files, err := r.t.client.List(ctx, dir)
+	if err != nil {
+		return nil, pathError("list", dir, err)
+	}
+	infos := make([]fs.FileInfo, len(files))
+	for i := range files {
+		infos[i] = fileInfo{files[i]}
+	}
+	return infos, nil
+}
+
+// Stat describes the file or directory at name
+func (r *RemoteFS) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	name = clean(name)
+	info, err := r.t.client.Stat(ctx, name)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return fileInfo{*info}, nil
+}
+
+// Open opens the file name for reading. The file reads with ctx, which
+// should outlive it.
+func (r *RemoteFS) Open(ctx context.Context, name string) (*File, error) {
+	name = clean(name)
+	f := &File{fs: r, ctx: ctx, name: name, size: -1}
+
+	// Streams cannot be looked at without reading them
+	if r.t.Stream() {
+		f.info = fileInfo{protocol.FileInfo{Name: path.Base(name), Mode: 0o444}}
+		return f, nil
+	}
+
+	info, err := r.t.client.Stat(ctx, name)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	if info.IsDir {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	}
+	f.info = fileInfo{*info}
+	f.size = info.Size
+	return f, nil
+}
+
+// Hash returns the SHA-256 checksum of the file name, computed by the sharer
+func (r *RemoteFS) Hash(ctx context.Context, name string) ([]byte, error) {
+	name = clean(name)
+	sum, err := r.t.client.Hash(ctx, name)
+	if err != nil {
+		return nil, pathError("hash", name, err)
+	}
+	return sum, nil
+}
+
+// Upload writes everything read from src to the file name, replacing it if
+// it exists. The data goes to a temporary file next to it first, which is
+// only renamed into place once it matches the checksum of what was read, so
+// readers never see a partial upload.
+func (r *RemoteFS) Upload(ctx context.Context, name string, src io.Reader) (int64, error) {
+	name = clean(name)
+	if r.t.ReadOnly() {
+		return 0, &fs.PathError{Op: "upload", Path: name, Err: fs.ErrPermission}
+	}
+
+	var suffix [4]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return 0, err
+	}
+	partial := name + ".orb-upload-" + hex.EncodeToString(suffix[:])
+
+	n, err := r.upload(ctx, partial, src)
+	if err == nil {
+		err = r.Rename(ctx, partial, name)
+	}
+	if err != nil {
+		// Best effort, the upload failed either way
+		_ = r.t.client.Delete(context.WithoutCancel(ctx), partial)
+		return n, err
+	}
+	return n, nil
+}
+
+// upload copies src to the new file name and checks the result
+func (r *RemoteFS) upload(ctx context.Context, name string, src io.Reader) (int64, error) {
+	// Empty files still need to be created on the sharer
+	if _, err := r.t.client.Write(ctx, name, 0, nil); err != nil {
+		return 0, pathError("upload", name, err)
+	}
+
+	sum := sha256.New()
+	buf := make([]byte, transfer.ChunkSize)
+	var offset int64
+	for {
+		n, readErr := io.ReadFull(src, buf)
+		if n > 0 {
+			sum.Write(buf[:n])
+			for data := buf[:n]; len(data) > 0; {
+				written, err := r.t.client.Write(ctx, name, offset, data)
+				if err != nil {
+					return offset, pathError("upload", name, err)
+				}
+				if written == 0 {
+					return offset, fmt.Errorf("sharer accepted no data of %s at offset %d", name, offset)
+				}
+				offset += written
+				data = data[written:]
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return offset, readErr
+		}
+	}
+
+	remote, err := r.Hash(ctx, name)
+	if err != nil {
+		return offset, err
+	}
+	if !bytes.Equal(remote, sum.Sum(nil)) {
+		return offset, fmt.Errorf("upload of %s does not match the sharer's checksum", name)
+	}
+	return offset, nil
+}
+
+// Mkdir creates the directory name
+func (r *RemoteFS) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
+	name = clean(name)
+	return pathError("mkdir", name, r.t.client.Mkdir(ctx, name, uint32(perm.Perm())))
+}
+
+// Remove deletes the file or directory name
+func (r *RemoteFS) Remove(ctx context.Context, name string) error {
+	name = clean(name)
+	return pathError("remove", name, r.t.client.Delete(ctx, name))
+}
+
+// Rename moves oldName to newName, replacing newName if it is a file
+func (r *RemoteFS) Rename(ctx context.Context, oldName, newName string) error {
+	oldName, newName = clean(oldName), clean(newName)
+	return pathError("rename", oldName, r.t.client.Rename(ctx, oldName, newName))
+}
+
+// Event reports that entries of Dir changed. Changed names the entries,
+// empty when the sharer could not tell which ones did.
+type Event struct {
+	Dir     string
+	Changed []string
+}
+
+// watcher receives the events below dir
+type watcher struct {
+	dir    string
+	events chan Event
+}
+
+// Watch reports changes below dir until ctx ends or the tunnel closes,
+// then closes the channel. Events only say where to look again; ones that
+// arrive while the channel is full are dropped.
+func (r *RemoteFS) Watch(ctx context.Context, dir string) (<-chan Event, error) {
+	dir = clean(dir)
+	if err := r.t.client.Watch(ctx, dir); err != nil {
+		return nil, pathError("watch", dir, err)
+	}
+	r.watchOnce.Do(func() { go r.dispatch() })
+
+	w := &watcher{dir: dir, events: make(chan Event, 16)}
+	r.watchMu.Lock()
+	r.watchers[w] = struct{}{}
+	r.watchMu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-r.t.Done():
+		}
+		r.watchMu.Lock()
+		delete(r.watchers, w)
+		close(w.events)
+		r.watchMu.Unlock()
+	}()
+	return w.events, nil
+}
+
+// dispatch hands the changes the sharer reports to the watchers of the
+// directories they are in
+func (r *RemoteFS) dispatch() {
+	for e := range r.t.client.WatchEvents() {
+		event := Event{Dir: clean(e.Path), Changed: e.Changed}
+		r.watchMu.Lock()
+		for w := range r.watchers {
+			if within(event.Dir, w.dir) {
+				select {
+				case w.events <- event:
+				default:
+				}
+			}
+		}
+		r.watchMu.Unlock()
+	}
+}
+
+// clean makes name an absolute path of the share
+func clean(name string) string {
+	return path.Clean("/" + name)
+}
+
+// within reports whether name is dir or below it
+func within(name, dir string) bool {
+	return dir == "/" || name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+// pathError wraps a failure reported by the sharer, which mostly sends a
+// message only, so that the usual fs errors match it
+func pathError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var remoteErr *protocol.ErrorResponse
+	if !errors.As(err, &remoteErr) {
+		return err
+	}
+
+	msg := strings.ToLower(remoteErr.Message)
+	switch {
+	case remoteErr.Code == protocol.ErrCodeNotFound,
+		strings.Contains(msg, "no such file"), strings.Contains(msg, "not shared"):
+		err = fs.ErrNotExist
+	case remoteErr.Code == protocol.ErrCodeExists, strings.Contains(msg, "file exists"):
+		err = fs.ErrExist
+	case remoteErr.Code == protocol.ErrCodePermission,
+		strings.Contains(msg, "permission denied"), strings.Contains(msg, "declined"),
+		strings.Contains(msg, "read-only"), strings.Contains(msg, "cannot be changed"):
+		err = fs.ErrPermission
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// fileInfo describes a remote entry. Sys returns the protocol.FileInfo.
+type fileInfo struct {
+	info protocol.FileInfo
+}
+
+func (fi fileInfo) Name() string       { return fi.info.Name }
+func (fi fileInfo) Size() int64        { return fi.info.Size }
+func (fi fileInfo) Mode() fs.FileMode  { return fs.FileMode(fi.info.Mode) }
+func (fi fileInfo) ModTime() time.Time { return time.Unix(fi.info.ModTime, 0) }
+func (fi fileInfo) IsDir() bool        { return fi.info.IsDir }
+func (fi fileInfo) Sys() any           { return fi.info }
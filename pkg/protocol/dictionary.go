@@ -0,0 +1,50 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+)
+
+// dictionaryMessages are encoded into the shared dictionary, the most
+// frequent last, since DEFLATE refers to what is closer for less
+var dictionaryMessages = []any{
+	&InfoRequest{}, &InfoResponse{}, &SearchRequest{}, &SearchResponse{Results: []SearchResult{{}}},
+	&HashRequest{}, &HashResponse{}, &SignatureRequest{}, &SignatureResponse{},
+	&WriteResponse{}, &MkdirRequest{}, &RenameRequest{}, &DeleteRequest{}, &WriteRequest{},
+	&StreamAck{}, &StreamData{}, &ChatMessage{}, &WatchEvent{},
+	&ErrorResponse{}, &StatRequest{}, &StatResponse{}, &ListRequest{}, &ListResponse{Files: []FileInfo{{}}},
+	&ReadResponse{}, &ReadRequest{},
+}
+
+// dictionary is built when the package is initialized, before anything is
+// encoded, so that gob numbers its types the same in every process
+var dictionary, dictionaryID = buildDictionary()
+
+// Dictionary returns the dictionary payloads are compressed with when both
+// peers have the same one. Payloads are gob encoded with an encoder of their
+// own, so each starts with the description of its type, which the
+// dictionary holds for the messages of this package.
+func Dictionary() []byte {
+	return dictionary
+}
+
+// DictionaryID identifies Dictionary, which changes with the messages of
+// this package. It is never 0.
+func DictionaryID() uint32 {
+	return dictionaryID
+}
+
+func buildDictionary() ([]byte, uint32) {
+	var buf bytes.Buffer
+	for _, msg := range dictionaryMessages {
+		_ = gob.NewEncoder(&buf).Encode(msg)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	id := binary.BigEndian.Uint32(sum[:])
+	if id == 0 {
+		id = 1
+	}
+	return buf.Bytes(), id
+}
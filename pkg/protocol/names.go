@@ -0,0 +1,35 @@
+package protocol
+
+import "golang.org/x/text/unicode/norm"
+
+// Names of files are sent in Unicode normalization form C, which composes
+// "é" into one code point. macOS stores names decomposed, as "e" followed by
+// a combining accent, while Linux and Windows keep them as they were
+// created, so the same name can arrive in either form. Sharers and
+// receivers normalize the names they send and match those they get against
+// what is on their disk in any form.
+
+// NormalizeName returns a name, or a slash-separated path, in the form it is
+// sent in
+func NormalizeName(name string) string {
+	if !HasForms(name) {
+		return name
+	}
+	return norm.NFC.String(name)
+}
+
+// SameName reports whether two names are the same once normalized
+func SameName(a, b string) bool {
+	return a == b || NormalizeName(a) == NormalizeName(b)
+}
+
+// HasForms reports whether a name could be written in more than one
+// normalization form, which only names outside ASCII can
+func HasForms(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
@@ -24,10 +24,28 @@ const (
 	FrameTypeDelete        = 0x14
 	FrameTypeRename        = 0x15
 	FrameTypeMkdir         = 0x16
+	FrameTypeSearch        = 0x17
+	FrameTypeInfo          = 0x18
+	FrameTypeCancel        = 0x19
+	FrameTypeHash          = 0x1A
+	FrameTypeWatch         = 0x1B
+	FrameTypeEvent         = 0x1C
+	FrameTypeBench         = 0x1D
+	FrameTypeMessage       = 0x1E
+	FrameTypeForward       = 0x1F
 	FrameTypeResponse      = 0x20
 	FrameTypeError         = 0x21
 	FrameTypePing          = 0x30
 	FrameTypePong          = 0x31
+	FrameTypeStreamData    = 0x40
+	FrameTypeStreamAck     = 0x41
+	FrameTypeStreamClose   = 0x42
+	FrameTypeSignature     = 0x50
+	FrameTypeCopy          = 0x51
+	FrameTypeManifest      = 0x52
+	FrameTypeTxBegin       = 0x53
+	FrameTypeTxCommit      = 0x54
+	FrameTypeTxRollback    = 0x55
 )
 
 var (
@@ -40,8 +58,31 @@ var (
 type Frame struct {
 	Type    uint32
 	Payload []byte
+	// ID correlates a response with its request so that several requests
+	// can be in flight on the same tunnel. Responses echo the request ID.
+	ID uint32
+	// Trace is the W3C traceparent of the request when the receiver traces
+	// it, so that the sharer's spans join the same trace. Peers that do not
+	// know the field ignore it.
+	Trace string
+	// Encoding tells how Payload is compressed. Payloads are only
+	// compressed for peers that set Accept.
+	Encoding uint8
+	// Accept is set by peers that decode compressed payloads, to the
+	// DictionaryID of their shared dictionary
+	Accept uint32
 }
 
+// Payload encodings, see Frame.Encoding
+const (
+	EncodingNone = 0
+	// EncodingDeflate is DEFLATE (RFC 1951)
+	EncodingDeflate = 1
+	// EncodingDeflateDictionary is DEFLATE with Dictionary preset, only
+	// used when the peer's DictionaryID matches
+	EncodingDeflateDictionary = 2
+)
+
 // WriteFrame writes a frame to the writer
 // Format: [4-byte length][4-byte type][encrypted payload]
 func WriteFrame(w io.Writer, frame *Frame) error {
@@ -115,14 +156,136 @@ func ValidateFrameType(frameType uint32) bool {
 		FrameTypeDelete:        true,
 		FrameTypeRename:        true,
 		FrameTypeMkdir:         true,
+		FrameTypeSearch:        true,
+		FrameTypeInfo:          true,
+		FrameTypeCancel:        true,
+		FrameTypeHash:          true,
+		FrameTypeWatch:         true,
+		FrameTypeEvent:         true,
+		FrameTypeBench:         true,
+		FrameTypeMessage:       true,
+		FrameTypeForward:       true,
 		FrameTypeResponse:      true,
 		FrameTypeError:         true,
 		FrameTypePing:          true,
 		FrameTypePong:          true,
+		FrameTypeStreamData:    true,
+		FrameTypeStreamAck:     true,
+		FrameTypeStreamClose:   true,
+		FrameTypeSignature:     true,
+		FrameTypeCopy:          true,
+		FrameTypeManifest:      true,
+		FrameTypeTxBegin:       true,
+		FrameTypeTxCommit:      true,
+		FrameTypeTxRollback:    true,
 	}
 	return validTypes[frameType]
 }
 
+// frameTypeNames names the frame types in traces and logs
+var frameTypeNames = map[uint32]string{
+	FrameTypeHandshake:     "handshake",
+	FrameTypeHandshakeResp: "handshake_response",
+	FrameTypeList:          "list",
+	FrameTypeStat:          "stat",
+	FrameTypeRead:          "read",
+	FrameTypeWrite:         "write",
+	FrameTypeDelete:        "delete",
+	FrameTypeRename:        "rename",
+	FrameTypeMkdir:         "mkdir",
+	FrameTypeSearch:        "search",
+	FrameTypeInfo:          "info",
+	FrameTypeCancel:        "cancel",
+	FrameTypeHash:          "hash",
+	FrameTypeWatch:         "watch",
+	FrameTypeEvent:         "event",
+	FrameTypeBench:         "bench",
+	FrameTypeMessage:       "message",
+	FrameTypeForward:       "forward",
+	FrameTypeResponse:      "response",
+	FrameTypeError:         "error",
+	FrameTypePing:          "ping",
+	FrameTypePong:          "pong",
+	FrameTypeStreamData:    "stream_data",
+	FrameTypeStreamAck:     "stream_ack",
+	FrameTypeStreamClose:   "stream_close",
+	FrameTypeSignature:     "signature",
+	FrameTypeCopy:          "copy",
+	FrameTypeManifest:      "manifest",
+	FrameTypeTxBegin:       "tx_begin",
+	FrameTypeTxCommit:      "tx_commit",
+	FrameTypeTxRollback:    "tx_rollback",
+}
+
+// Priority classes of frames, which decide the order frames waiting to be
+// sent go out in
+const (
+	// PriorityInteractive is for small requests someone is waiting on, such
+	// as listings, stats and pings, and their responses
+	PriorityInteractive = iota
+	// PriorityNormal is for forwarded connections and checksums
+	PriorityNormal
+	// PriorityBulk is for the chunks of transfers
+	PriorityBulk
+
+	// NumPriorities is the number of priority classes
+	NumPriorities
+)
+
+// BulkPayload is the payload size from which a response counts as bulk,
+// whatever it answers
+const BulkPayload = 16 * 1024
+
+// framePriorities are the classes of requests and other frames that are
+// not normal
+var framePriorities = map[uint32]int{
+	FrameTypeList:        PriorityInteractive,
+	FrameTypeStat:        PriorityInteractive,
+	FrameTypeSearch:      PriorityInteractive,
+	FrameTypeInfo:        PriorityInteractive,
+	FrameTypeCancel:      PriorityInteractive,
+	FrameTypeWatch:       PriorityInteractive,
+	FrameTypeEvent:       PriorityInteractive,
+	FrameTypeMessage:     PriorityInteractive,
+	FrameTypePing:        PriorityInteractive,
+	FrameTypePong:        PriorityInteractive,
+	FrameTypeTxBegin:     PriorityInteractive,
+	FrameTypeTxCommit:    PriorityInteractive,
+	FrameTypeTxRollback:  PriorityInteractive,
+	FrameTypeStreamAck:   PriorityInteractive,
+	FrameTypeStreamClose: PriorityInteractive,
+	FrameTypeRead:        PriorityBulk,
+	FrameTypeWrite:       PriorityBulk,
+	FrameTypeCopy:        PriorityBulk,
+	FrameTypeBench:       PriorityBulk,
+	FrameTypeManifest:    PriorityBulk,
+}
+
+// FramePriority returns the priority class of a frame. Responses are
+// interactive unless their payload is BulkPayload or more, so that the
+// answer to a listing overtakes the chunks of a download.
+func FramePriority(frame *Frame) int {
+	switch frame.Type {
+	case FrameTypeResponse, FrameTypeError:
+		if len(frame.Payload) >= BulkPayload {
+			return PriorityBulk
+		}
+		return PriorityInteractive
+	}
+	if priority, ok := framePriorities[frame.Type]; ok {
+		return priority
+	}
+	return PriorityNormal
+}
+
+// FrameTypeName returns the name of a frame type, e.g. "read"
+func FrameTypeName(frameType uint32) string {
+	if name, ok := frameTypeNames[frameType]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", frameType)
+}
+
 // Request types for filesystem operations
 type ListRequest struct {
 	Path string
@@ -138,24 +301,186 @@ type ReadRequest struct {
 	Length int64
 }
 
+// The changes below are staged in the transaction Tx when it is set, see
+// TxBeginRequest, and carried out right away otherwise
+
+// WriteRequest writes Data at Offset of Path, creating it when it does not
+// exist. With Truncate set the file is then cut off, or extended with
+// zeros, to end where Data does, so an empty Data sets its size to Offset.
+// Sharers that do so set Truncated in the response, older ones ignore it.
 type WriteRequest struct {
-	Path   string
-	Offset int64
-	Data   []byte
+	Path     string
+	Offset   int64
+	Data     []byte
+	Tx       string
+	Truncate bool
 }
 
 type DeleteRequest struct {
 	Path string
+	Tx   string
 }
 
 type RenameRequest struct {
 	OldPath string
 	NewPath string
+	Tx      string
 }
 
 type MkdirRequest struct {
 	Path string
 	Perm uint32
+	Tx   string
+}
+
+// SearchRequest asks the sharer for entries below Path whose name contains Query
+type SearchRequest struct {
+	Path       string
+	Query      string
+	MaxResults int
+}
+
+// InfoRequest asks the sharer to describe the share
+type InfoRequest struct{}
+
+// HashRequest asks the sharer for the SHA-256 checksum of a file, or of its
+// first Length bytes when Length > 0, e.g. to check a partial download.
+// With Tx set, a file written in that transaction is hashed as staged.
+type HashRequest struct {
+	Path   string
+	Length int64
+	Tx     string
+}
+
+// TxBeginRequest opens a transaction. Writes, copies, deletions, renames
+// and new directories sent with its ID are staged by the sharer and only
+// carried out, in the order they were sent, once TxCommitRequest commits
+// them. A rollback, or the receiver disconnecting, drops them all.
+type TxBeginRequest struct{}
+
+// TxBeginResponse carries the ID of a new transaction
+type TxBeginResponse struct {
+	ID string
+}
+
+// TxCommitRequest carries out the changes staged in transaction ID
+type TxCommitRequest struct {
+	ID string
+}
+
+// TxCommitResponse tells how many changes a commit carried out
+type TxCommitResponse struct {
+	Changes int
+}
+
+// TxRollbackRequest drops the changes staged in transaction ID
+type TxRollbackRequest struct {
+	ID string
+}
+
+// ManifestRequest asks for the sharer's signed manifest from Offset on
+type ManifestRequest struct {
+	Offset int64
+}
+
+// WatchRequest asks the sharer to report changes below Path until the
+// tunnel closes. Changes arrive as WatchEvent frames with frame ID 0.
+type WatchRequest struct {
+	Path string
+}
+
+// WatchEvent reports changed entries below a watched directory. Changed
+// paths are relative to Path.
+type WatchEvent struct {
+	Path    string
+	Changed []string
+}
+
+// BenchRequest carries Data to the sharer, which discards it and answers
+// with Size bytes of filler, for orb bench. Neither side touches a disk.
+type BenchRequest struct {
+	Data []byte
+	Size int64
+}
+
+// MaxChatMessage is the longest chat message in bytes
+const MaxChatMessage = 4096
+
+// ChatMessage is a line of the chat between sharer and receiver. It is sent
+// with frame ID 0 in either direction and gets no response.
+type ChatMessage struct {
+	Text string
+	Sent int64 // Unix time in seconds
+}
+
+// ForwardRequest asks the sharer to open a TCP connection to Address, e.g.
+// "localhost:3000", for orb connect --forward, and to carry it on Stream.
+// The receiver picks the stream so that it is ready for the sharer's data
+// before the response arrives.
+type ForwardRequest struct {
+	Address string
+	Stream  uint32
+}
+
+// StreamWindow is how many bytes of a stream may be sent before the peer
+// acknowledges them
+const StreamWindow = 256 * 1024
+
+// StreamData carries bytes of a forwarded connection. Stream frames are
+// sent with frame ID 0 in either direction and get no response.
+type StreamData struct {
+	Stream uint32
+	Data   []byte
+}
+
+// StreamAck tells the peer that Bytes more of a stream have been consumed,
+// so that it may send as many again
+type StreamAck struct {
+	Stream uint32
+	Bytes  int64
+}
+
+// StreamClose ends the sender's side of a stream. With Abort set the
+// stream is torn down in both directions.
+type StreamClose struct {
+	Stream uint32
+	Abort  bool
+}
+
+// MaxSignatureSpan bounds the bytes of a file covered by one
+// SignatureResponse, so that checksumming a large file is split into
+// requests that each finish quickly
+const MaxSignatureSpan = 64 * 1024 * 1024
+
+// SignatureRequest asks for the checksums of up to Count blocks of
+// BlockSize bytes of a file, starting with block First, so that only the
+// blocks that differ from another copy need to be transferred
+type SignatureRequest struct {
+	Path      string
+	BlockSize int64
+	First     int64
+	Count     int
+}
+
+// MaxCopyLength is the most bytes one CopyRequest copies
+const MaxCopyLength = 64 * 1024 * 1024
+
+// CopyRequest copies Length bytes at SourceOffset of Source to Offset of
+// Path, both on the sharer, so that an upload can reuse blocks the sharer
+// already has. Path is created when it does not exist.
+type CopyRequest struct {
+	Path         string
+	Offset       int64
+	Source       string
+	SourceOffset int64
+	Length       int64
+	Tx           string
+}
+
+// CancelRequest tells the sharer that the request with frame ID ID was
+// abandoned. It is sent with frame ID 0 and gets no response.
+type CancelRequest struct {
+	ID uint32
 }
 
 // Response types
@@ -165,6 +490,7 @@ type FileInfo struct {
 	Mode    uint32
 	ModTime int64
 	IsDir   bool
+	Owner   string // owning user name, empty when unknown
 }
 
 type ListResponse struct {
@@ -175,12 +501,65 @@ type StatResponse struct {
 	Info FileInfo
 }
 
+// SearchResult is a single match, Path is relative to the share root
+type SearchResult struct {
+	Path string
+	Info FileInfo
+}
+
+type SearchResponse struct {
+	Results   []SearchResult
+	Truncated bool
+}
+
+// InfoResponse describes the share as a whole
+type InfoResponse struct {
+	ReadOnly bool
+	File     string // name of the only file of a single-file share
+	// Stream marks File as a pipe: its size is unknown until it has been
+	// read, and it can only be read once from start to end
+	Stream bool
+	// Manifest is set when the sharer offers a signed manifest of its files
+	Manifest bool
+	// Transactions is set when the sharer stages changes in transactions,
+	// see TxBeginRequest
+	Transactions bool
+}
+
+// ManifestResponse carries the part of the sharer's signed manifest from
+// the requested offset on. Manifests can exceed a frame, so receivers ask
+// until they have Size bytes.
+type ManifestResponse struct {
+	Data []byte
+	Size int64
+}
+
+// HashResponse carries the SHA-256 checksum of a file
+type HashResponse struct {
+	SHA256 []byte
+}
+
 type ReadResponse struct {
 	Data []byte
 }
 
+// SignatureResponse holds the checksums of consecutive blocks: a rolling
+// checksum per block in Weak and a strong checksum of equal length per
+// block in Strong. Fewer blocks than asked for are returned at the end of
+// the file or of MaxSignatureSpan; the last block of a file may be short.
+type SignatureResponse struct {
+	Weak   []uint32
+	Strong []byte
+}
+
+// BenchResponse carries the filler asked for by a BenchRequest
+type BenchResponse struct {
+	Data []byte
+}
+
 type WriteResponse struct {
 	BytesWritten int64
+	Truncated    bool // the file was truncated as the request asked
 }
 
 type ErrorResponse struct {
@@ -188,6 +567,11 @@ type ErrorResponse struct {
 	Message string
 }
 
+// Error implements the error interface so remote failures can be returned directly
+func (e *ErrorResponse) Error() string {
+	return e.Message
+}
+
 // Error codes
 const (
 	ErrCodeNotFound      = 1
@@ -0,0 +1,258 @@
+//go:build js && wasm
+
+// Command web is the browser receiver served by "orb relay --web". It runs
+// the handshake and decryption of orb in the browser, so the relay still
+// only forwards ciphertext. Build it with "make web".
+//
+// It exposes globalThis.orb to the page:
+//
+//	connect(relay, session, passcode) -> Promise<{file, readOnly, stream}>
+//	list(path)                        -> Promise<[{name, size, isDir, modified}]>
+//	download(path, write)             -> Promise<size>
+//	disconnect()
+//
+// download passes the file to write(chunk) a Uint8Array at a time, waiting
+// for the promise write returns, and checks it against the sharer's
+// checksum at the end.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"syscall/js"
+	"time"
+
+	"github.com/Zayan-Mohamed/orb/internal/remote"
+	"github.com/Zayan-Mohamed/orb/internal/transfer"
+	"github.com/Zayan-Mohamed/orb/internal/tunnel"
+	"github.com/Zayan-Mohamed/orb/pkg/protocol"
+)
+
+// pingInterval keeps the tunnel from reaching its read deadline while the
+// user looks around
+const pingInterval = 30 * time.Second
+
+var (
+	mu     sync.Mutex
+	tun    *tunnel.Tunnel
+	client *remote.Client
+	info   *protocol.InfoResponse
+)
+
+func main() {
+	js.Global().Set("orb", js.ValueOf(map[string]any{
+		"connect":    promised(connect),
+		"list":       promised(list),
+		"download":   promised(download),
+		"disconnect": js.FuncOf(disconnect),
+	}))
+	if ready := js.Global().Get("orbReady"); ready.Type() == js.TypeFunction {
+		ready.Invoke()
+	}
+	select {}
+}
+
+// promised exposes fn as a function returning a promise. fn runs on its own
+// goroutine, since blocking in a callback would stall the browser.
+func promised(fn func(args []js.Value) (any, error)) js.Func {
+	return js.FuncOf(func(_ js.Value, args []js.Value) any {
+		return js.Global().Get("Promise").New(js.FuncOf(func(_ js.Value, settle []js.Value) any {
+			resolve, reject := settle[0], settle[1]
+			go func() {
+				result, err := fn(args)
+				if err != nil {
+					reject.Invoke(js.Global().Get("Error").New(err.Error()))
+					return
+				}
+				resolve.Invoke(result)
+			}()
+			return nil
+		}))
+	})
+}
+
+// session returns the client of the connected session
+func session() (*remote.Client, *protocol.InfoResponse, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if client == nil {
+		return nil, nil, errors.New("not connected")
+	}
+	return client, info, nil
+}
+
+func connect(args []js.Value) (any, error) {
+	if len(args) < 3 {
+		return nil, errors.New("connect needs the relay, session and passcode")
+	}
+	relay, sessionID, code := args[0].String(), args[1].String(), args[2].String()
+
+	mu.Lock()
+	connected := client != nil
+	mu.Unlock()
+	if connected {
+		return nil, errors.New("already connected")
+	}
+
+	t, err := tunnel.NewTunnel(relay, sessionID, code, true)
+	if err != nil {
+		return nil, err
+	}
+	c := remote.NewClient(tunnel.NewMux(t))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	i, err := c.Info(ctx)
+	if err != nil {
+		_ = t.Close()
+		return nil, fmt.Errorf("failed to read share info: %w", err)
+	}
+
+	mu.Lock()
+	tun, client, info = t, c, i
+	mu.Unlock()
+	go keepAlive(c)
+
+	return map[string]any{"file": i.File, "readOnly": i.ReadOnly, "stream": i.Stream}, nil
+}
+
+// keepAlive pings the sharer until the tunnel closes
+func keepAlive(c *remote.Client) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.Mux().Done():
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), pingInterval)
+			_, _ = c.Ping(ctx)
+			cancel()
+		}
+	}
+}
+
+func list(args []js.Value) (any, error) {
+	c, _, err := session()
+	if err != nil {
+		return nil, err
+	}
+	path := "/"
+	if len(args) > 0 && args[0].Type() == js.TypeString {
+		path = args[0].String()
+	}
+
+	files, err := c.List(context.Background(), path)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]any, len(files))
+	for i, f := range files {
+		entries[i] = map[string]any{
+			"name":     f.Name,
+			"size":     float64(f.Size),
+			"isDir":    f.IsDir,
+			"modified": float64(f.ModTime) * 1000,
+		}
+	}
+	return entries, nil
+}
+
+func download(args []js.Value) (any, error) {
+	c, i, err := session()
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 || args[1].Type() != js.TypeFunction {
+		return nil, errors.New("download needs a path and a write function")
+	}
+	path, write := args[0].String(), args[1]
+	ctx := context.Background()
+
+	// The size of a stream is unknown until it has been read
+	size := int64(-1)
+	if !i.Stream {
+		stat, err := c.Stat(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		if stat.IsDir {
+			return nil, fmt.Errorf("%s is a folder", path)
+		}
+		size = stat.Size
+	}
+
+	sum := sha256.New()
+	var offset int64
+	for size < 0 || offset < size {
+		data, err := c.Read(ctx, path, offset, transfer.ChunkSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			if size < 0 {
+				break
+			}
+			return nil, fmt.Errorf("%s ended early, at %d of %d bytes", path, offset, size)
+		}
+		sum.Write(data)
+		offset += int64(len(data))
+
+		chunk := js.Global().Get("Uint8Array").New(len(data))
+		js.CopyBytesToJS(chunk, data)
+		if err := await(write.Invoke(chunk)); err != nil {
+			return nil, err
+		}
+	}
+
+	// A stream can only be read once, there is nothing to compare it with
+	if size >= 0 {
+		want, err := c.Hash(ctx, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify %s: %w", path, err)
+		}
+		if !bytes.Equal(sum.Sum(nil), want) {
+			return nil, fmt.Errorf("%s does not match the sharer's checksum", path)
+		}
+	}
+	return float64(offset), nil
+}
+
+// await waits for v when it is a promise
+func await(v js.Value) error {
+	if v.Type() != js.TypeObject || v.Get("then").Type() != js.TypeFunction {
+		return nil
+	}
+	done := make(chan error, 1)
+	onResolve := js.FuncOf(func(js.Value, []js.Value) any {
+		done <- nil
+		return nil
+	})
+	onReject := js.FuncOf(func(_ js.Value, args []js.Value) any {
+		msg := "write failed"
+		if len(args) > 0 && args[0].Truthy() {
+			msg = args[0].Call("toString").String()
+		}
+		done <- errors.New(msg)
+		return nil
+	})
+	defer onResolve.Release()
+	defer onReject.Release()
+	v.Call("then", onResolve, onReject)
+	return <-done
+}
+
+func disconnect(js.Value, []js.Value) any {
+	mu.Lock()
+	t := tun
+	tun, client, info = nil, nil, nil
+	mu.Unlock()
+	if t != nil {
+		_ = t.Close()
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+// Page of the browser receiver. The work is done by orb.wasm, which sets
+// up globalThis.orb, see web/main.go.
+"use strict";
+
+const $ = (id) => document.getElementById(id);
+let cwd = "/";
+
+function status(text, error) {
+  $("status").textContent = text;
+  $("status").className = error ? "error" : "";
+}
+
+function formatBytes(n) {
+  const units = ["B", "KB", "MB", "GB", "TB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return (i ? n.toFixed(1) : n) + " " + units[i];
+}
+
+function join(dir, name) {
+  return (dir.endsWith("/") ? dir : dir + "/") + name;
+}
+
+async function show(path) {
+  render(path, await orb.list(path));
+}
+
+function render(path, entries) {
+  entries.sort((a, b) => (b.isDir - a.isDir) || a.name.localeCompare(b.name));
+  cwd = path;
+  $("path").textContent = path;
+  $("up").disabled = path === "/";
+  const rows = entries.map((e) => {
+    const row = document.createElement("tr");
+    const link = document.createElement("a");
+    link.href = "#";
+    link.textContent = e.isDir ? e.name + "/" : e.name;
+    link.addEventListener("click", (ev) => {
+      ev.preventDefault();
+      const target = join(path, e.name);
+      (e.isDir ? show(target) : save(target, e.name, e.size)).catch((err) => status(err.message, true));
+    });
+    const name = document.createElement("td");
+    name.append(link);
+    const size = document.createElement("td");
+    size.textContent = e.isDir || e.size < 0 ? "" : formatBytes(e.size);
+    const modified = document.createElement("td");
+    modified.textContent = e.modified ? new Date(e.modified).toLocaleString() : "";
+    row.append(name, size, modified);
+    return row;
+  });
+  $("files").replaceChildren(...rows);
+}
+
+// save downloads a file straight to disk where the browser allows it, and
+// through memory otherwise
+async function save(path, name, size) {
+  let done = 0;
+  const progress = (n) => {
+    done += n;
+    status(size >= 0 ? `Receiving ${name}: ${formatBytes(done)} of ${formatBytes(size)}`
+      : `Receiving ${name}: ${formatBytes(done)}`);
+  };
+
+  if (window.showSaveFilePicker) {
+    let handle;
+    try {
+      handle = await window.showSaveFilePicker({ suggestedName: name });
+    } catch (err) {
+      if (err.name === "AbortError") return;
+      throw err;
+    }
+    const out = await handle.createWritable();
+    try {
+      await orb.download(path, async (chunk) => {
+        await out.write(chunk);
+        progress(chunk.length);
+      });
+    } catch (err) {
+      await out.abort();
+      throw err;
+    }
+    await out.close();
+  } else {
+    const parts = [];
+    await orb.download(path, (chunk) => {
+      parts.push(chunk);
+      progress(chunk.length);
+    });
+    const url = URL.createObjectURL(new Blob(parts));
+    const a = document.createElement("a");
+    a.href = url;
+    a.download = name;
+    a.click();
+    setTimeout(() => URL.revokeObjectURL(url), 60000);
+  }
+  status(`Received ${name}, checksum verified.`);
+}
+
+async function connect(session, passcode) {
+  status("Connecting...");
+  $("connect").disabled = true;
+  try {
+    const info = await orb.connect(location.origin, session, passcode);
+    $("join").hidden = true;
+    $("share").hidden = false;
+    status("");
+    if (info.file) {
+      // A single-file share, which may be a stream, has nothing to browse
+      $("up").hidden = true;
+      render("/", [{ name: info.file.split("/").pop(), size: -1, isDir: false }]);
+      return;
+    }
+    $("up").hidden = false;
+    await show("/");
+  } catch (err) {
+    // A wrong passcode fails the handshake, the sharer does not say why
+    status(err.message.startsWith("handshake failed") ? "Wrong passcode, or the sharer is gone." : err.message, true);
+    $("connect").disabled = false;
+  }
+}
+
+$("join").addEventListener("submit", (ev) => {
+  ev.preventDefault();
+  connect($("session").value.trim(), $("passcode").value.trim());
+});
+
+$("up").addEventListener("click", () => {
+  const parent = cwd.replace(/\/[^/]*$/, "") || "/";
+  show(parent).catch((err) => status(err.message, true));
+});
+
+$("leave").addEventListener("click", () => {
+  orb.disconnect();
+  $("share").hidden = true;
+  $("join").hidden = false;
+  $("connect").disabled = false;
+  $("files").replaceChildren();
+  status("Disconnected.");
+});
+
+// The session comes from the link, the passcode is never part of it
+$("session").value = decodeURIComponent(location.hash.slice(1));
+$("join").hidden = false;
+if ($("session").value) $("passcode").focus();
+
+globalThis.orbReady = () => {
+  $("connect").disabled = false;
+};
+
+const go = new Go();
+WebAssembly.instantiateStreaming(fetch("orb.wasm"), go.importObject)
+  .then((result) => go.run(result.instance))
+  .catch((err) => status("Failed to load orb.wasm: " + err.message, true));